@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewCatCommand creates the 'cat' command for the CLI.
+func NewCatCommand() *cobra.Command {
+	var opts commands.CatOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "cat <snap_id_or_hash> <path>",
+		Short: "Print a file from a snapshot.",
+		Long: `Prints the contents of a file in a snapshot to standard output, without
+restoring anything.`,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			opts.Path = args[1]
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Cat(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+
+	return cmd
+}
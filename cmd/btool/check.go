@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewCheckCommand creates the 'check' command for the CLI.
+func NewCheckCommand() *cobra.Command {
+	var opts commands.CheckOptions
+
+	cmd := &cobra.Command{
+		Use:   "check [directory]",
+		Short: "Verify the integrity of the repository.",
+		Long: `Checks that every index entry lies within an existing pack, that the objects
+in the packs hash to their IDs, and that every snapshot can be read along with
+every tree, manifest, and chunk it needs, so damage is found before a restore.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Check(dir, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.SkipData, "skip-data", false, "Do not read the packs to hash their contents, only check the index and the snapshots")
+	cmd.Flags().StringVar(&opts.ReadDataSubset, "read-data-subset", "", "Read only this share of the packs, such as 5%, taking the next share on each run")
+
+	return cmd
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewCheckRemoteCommand creates the 'check-remote' command for the CLI.
+func NewCheckRemoteCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check-remote [directory]",
+		Short: "Check that the repository can be reached and written to.",
+		Long: `Connects to the repository (and every --mirror), then writes, reads back,
+and deletes a small test file, reporting the latency of each step. Run it
+before a long snap to catch bad credentials or permissions early.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.CheckRemote(dir, commands.CheckRemoteOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+	return cmd
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // snapshotCompletions provides dynamic tab completion for snapshot identifiers.
-// It suggests both numeric IDs and unique hash prefixes.
+// It suggests numeric IDs, with their hash prefixes, and "latest".
 func snapshotCompletions(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	// This completion function is for the first argument only.
 	if len(args) != 0 {
@@ -26,8 +26,17 @@ func snapshotCompletions(cmd *cobra.Command, args []string, toComplete string) (
 		dir = dirFlag
 	}
 
+	// The repo flag can point at a repository outside the directory.
+	backend, err := lib.OpenBackend(repositoryOptions(cmd).Repo, dir)
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	store := lib.NewObjectStore(backend)
+	defer store.Close()
+
 	// Get the list of sorted snapshots.
-	snaps, err := lib.GetSortedSnaps(dir)
+	snaps, err := store.GetSortedSnaps()
 	if err != nil {
 		// Don't return an error, just fail to complete.
 		return nil, cobra.ShellCompDirectiveNoFileComp
@@ -35,6 +44,9 @@ func snapshotCompletions(cmd *cobra.Command, args []string, toComplete string) (
 
 	// Create a list of suggestions.
 	var suggestions []string
+	if len(snaps) > 0 {
+		suggestions = append(suggestions, fmt.Sprintf("%s\tsnap %d", lib.LatestSnapIdentifier, snaps[len(snaps)-1].ID))
+	}
 	for _, snap := range snaps {
 		timestamp := snap.Timestamp.Format("2006-01-02 15:04:05")
 		suggestions = append(suggestions, fmt.Sprintf("%d\t%s %s - %s", snap.ID, snap.Hash[:7], timestamp, snap.Message))
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewDaemonCommand creates the 'daemon' command for the CLI.
+func NewDaemonCommand() *cobra.Command {
+	var opts commands.DaemonOptions
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run scheduled snaps and prunes.",
+		Long: `Runs the jobs listed in the daemon config, each on its own cron-like
+schedule, until interrupted. A job snaps a directory or prunes its repository
+down to its most recent snaps. The config is btool/daemon.json in the user's
+config directory unless --config names another file.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Daemon(opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.ConfigFile, "config", "", "The JSON file listing the jobs (defaults to btool/daemon.json in the user's config directory)")
+
+	return cmd
+}
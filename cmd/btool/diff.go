@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewDiffCommand creates the 'diff' command for the CLI.
+func NewDiffCommand() *cobra.Command {
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "diff <snap_id_or_hash> <snap_id_or_hash>",
+		Short: "Show the files that changed between two snapshots.",
+		Long: `Lists the files and directories added (+), removed (-), and modified (M)
+from the first snapshot to the second, with their sizes.`,
+		Args: cobra.ExactArgs(2),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// Both arguments are snapshots.
+			if len(args) > 1 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return snapshotCompletions(cmd, nil, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.Diff(sourceDir, commands.DiffOptions{
+				From:              args[0],
+				To:                args[1],
+				RepositoryOptions: repositoryOptions(cmd),
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+
+	return cmd
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewDuCommand creates the 'du' command for the CLI.
+func NewDuCommand() *cobra.Command {
+	var opts commands.DuOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "du <snap_id_or_hash> [path]",
+		Short: "Show the size of each directory in a snapshot.",
+		Long: `Prints the total size of the files below each directory in a snapshot, or
+within one of its directories, without restoring anything.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			if len(args) > 1 {
+				opts.Path = args[1]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Du(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().IntVar(&opts.MaxDepth, "max-depth", -1, "Print only directories this many levels below the path (-1 for all)")
+
+	return cmd
+}
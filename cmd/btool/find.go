@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewFindCommand creates the 'find' command for the CLI.
+func NewFindCommand() *cobra.Command {
+	var opts commands.FindOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "find <pattern>",
+		Short: "Search the snapshots for files by name.",
+		Long: `Lists every file and directory in the snapshots whose name matches a glob
+such as '*.conf', or whose path does if the glob holds a slash, such as
+'etc/*/config', so you can tell when a file appeared or disappeared.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.Pattern = args[0]
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Find(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().StringArrayVar(&opts.Snaps, "snap", nil, "Search only this snapshot, by ID or hash prefix (repeatable)")
+
+	return cmd
+}
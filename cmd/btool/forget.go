@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewForgetCommand creates the 'forget' command for the CLI.
+func NewForgetCommand() *cobra.Command {
+	var opts commands.ForgetOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "forget <snap_id_or_hash>...",
+		Short: "Remove specific snapshots.",
+		Long: `Removes the given snapshots, wherever they are in the timeline, and
+garbage-collects the data that no remaining snapshot references.
+
+Unlike prune, forget also rewrites the packs that hold such data beside data
+that is still referenced, so that nothing only the forgotten snapshots held,
+such as a secret that was snapped by mistake, is left in the repository.`,
+		Args: cobra.MinimumNArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			// Every argument is a snapshot.
+			return snapshotCompletions(cmd, nil, toComplete)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifiers = args
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Forget(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+
+	return cmd
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewGrepCommand creates the 'grep' command for the CLI.
+func NewGrepCommand() *cobra.Command {
+	var opts commands.GrepOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "grep <snap_id_or_hash> <pattern> [path]",
+		Short: "Search the files in a snapshot for a regular expression.",
+		Long: `Prints the lines of the files in a snapshot, or within one of its
+directories, that match a regular expression, as path:line:text, without
+restoring anything.`,
+		Args:              cobra.RangeArgs(2, 3),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			opts.Pattern = args[1]
+			if len(args) > 2 {
+				opts.Path = args[2]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Grep(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().BoolVarP(&opts.IgnoreCase, "ignore-case", "i", false, "Match letters of either case")
+	cmd.Flags().BoolVarP(&opts.FilesOnly, "files-with-matches", "l", false, "Print only the paths of the files that match")
+
+	return cmd
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewHistoryCommand creates the 'history' command for the CLI.
+func NewHistoryCommand() *cobra.Command {
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "history <path>",
+		Short: "Show the snapshots in which a file changed.",
+		Long: `Lists the snapshots in which a file or directory, given by its path within
+the snapshots, was added, modified, or removed, like git log does for a file.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.History(sourceDir, commands.HistoryOptions{
+				Path:              args[0],
+				RepositoryOptions: repositoryOptions(cmd),
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+
+	return cmd
+}
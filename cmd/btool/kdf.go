@@ -0,0 +1,63 @@
+package main
+
+import (
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/spf13/cobra"
+)
+
+// kdfParams collects the global key derivation flags as seen by cmd. Unless
+// --kdf is given, the zero value selects the default; the cost flags
+// override the default costs of argon2id.
+func kdfParams(cmd *cobra.Command) lib.KDFParams {
+	algorithm, _ := cmd.Flags().GetString("kdf")
+	memory, _ := cmd.Flags().GetUint32("kdf-memory")
+	iterations, _ := cmd.Flags().GetUint32("kdf-iterations")
+	parallelism, _ := cmd.Flags().GetUint8("kdf-parallelism")
+	if algorithm == "" && memory == 0 && iterations == 0 && parallelism == 0 {
+		return lib.KDFParams{}
+	}
+	params := lib.DefaultKDF()
+	if algorithm != "" {
+		params.Algorithm = algorithm
+	}
+	if memory != 0 {
+		params.Memory = memory << 10
+	}
+	if iterations != 0 {
+		params.Iterations = iterations
+	}
+	if parallelism != 0 {
+		params.Parallelism = parallelism
+	}
+	return params
+}
+
+// NewKDFCommand creates the 'kdf' command for the CLI.
+func NewKDFCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kdf",
+		Short: "Choose the cost of deriving keys from passwords.",
+	}
+
+	var opts commands.KDFTuneOptions
+	tuneCmd := &cobra.Command{
+		Use:   "tune",
+		Short: "Benchmark argon2id and suggest --kdf flags for this machine.",
+		Long: `Measures how many argon2id iterations this machine runs in the target time
+and prints the --kdf flags to use them. Slower derivation makes guessing the
+password harder, but every command that unlocks the repository waits that long.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KDFTune(opts)
+		},
+	}
+	tuneCmd.Flags().DurationVar(&opts.Target, "target", time.Second, "How long deriving a key should take")
+	tuneCmd.Flags().Uint32Var(&opts.Memory, "memory", 0, "The argon2id memory cost in MiB (defaults to 64)")
+	tuneCmd.Flags().Uint8Var(&opts.Parallelism, "parallelism", 0, "The number of argon2id lanes (defaults to the number of CPUs, up to 4)")
+	cmd.AddCommand(tuneCmd)
+
+	return cmd
+}
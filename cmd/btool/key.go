@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// directoryArg returns the optional directory argument at index i, or ".".
+func directoryArg(args []string, i int) string {
+	if len(args) > i {
+		return args[i]
+	}
+	return "."
+}
+
+// keyAddOptions collects the flags shared by 'key add' and 'key passwd'.
+func keyAddOptions(cmd *cobra.Command) commands.KeyAddOptions {
+	newPasswordFile, _ := cmd.Flags().GetString("new-password-file")
+	newPasswordCommand, _ := cmd.Flags().GetString("new-password-command")
+	recipient, _ := cmd.Flags().GetString("recipient")
+	kmsKey, _ := cmd.Flags().GetString("kms")
+	return commands.KeyAddOptions{
+		NewPasswordFile:    newPasswordFile,
+		NewPasswordCommand: newPasswordCommand,
+		AgeRecipient:       recipient,
+		KMSKey:             kmsKey,
+		RepositoryOptions:  repositoryOptions(cmd),
+	}
+}
+
+// NewKeyCommand creates the 'key' command, and its subcommands, for the CLI.
+func NewKeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "key",
+		Short: "Manage the passwords and age keys that unlock an encrypted repository.",
+		Long: `An encrypted repository's data is encrypted with a single master key, which is
+stored once per password, age identity, or KMS key that may unlock it. These "key slots"
+can be added and removed without re-encrypting any data.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [directory]",
+		Short: "List the key slots of the repository.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyList(directoryArg(args, 0), commands.KeyOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [directory]",
+		Short: "Add a password, age recipient, or KMS key that can unlock the repository.",
+		Long: `Adds a key slot for a new password, read from --new-password-file,
+--new-password-command, $` + commands.NewPasswordEnvVar + `, or a prompt, for the age public
+key given with --recipient, or for the cloud KMS key given with --kms.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyAdd(directoryArg(args, 0), keyAddOptions(cmd))
+		},
+	}
+	addCmd.Flags().String("new-password-file", "", "Read the new password from this file (defaults to $"+commands.NewPasswordEnvVar+")")
+	addCmd.Flags().String("new-password-command", "", "Read the new password from the output of this shell command")
+	addCmd.Flags().String("recipient", "", "Add this age public key instead of a password")
+	addCmd.Flags().String("kms", "", "Add this KMS key (awskms://..., gcpkms://..., or azurekeyvault://...) instead of a password")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove <id> [directory]",
+		Short: "Revoke a key slot.",
+		Long: `Removes the key slot with the given ID, as shown by 'btool key list'. The last
+slot cannot be removed. Existing data is not re-encrypted, so this only keeps
+out whoever has not already unlocked the repository.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyRemove(directoryArg(args, 1), commands.KeyRemoveOptions{
+				KeyID:             args[0],
+				RepositoryOptions: repositoryOptions(cmd),
+			})
+		},
+	}
+
+	passwdCmd := &cobra.Command{
+		Use:   "passwd [directory]",
+		Short: "Change the password that unlocks the repository.",
+		Long: `Replaces the key slot of the current password with one for the new password,
+read from --new-password-file, --new-password-command, $` + commands.NewPasswordEnvVar + `,
+or a prompt.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyPasswd(directoryArg(args, 0), keyAddOptions(cmd))
+		},
+	}
+	passwdCmd.Flags().String("new-password-file", "", "Read the new password from this file (defaults to $"+commands.NewPasswordEnvVar+")")
+	passwdCmd.Flags().String("new-password-command", "", "Read the new password from the output of this shell command")
+
+	var reencrypt bool
+	rotateCmd := &cobra.Command{
+		Use:   "rotate [directory]",
+		Short: "Switch the repository to a new data key and master key.",
+		Long: `Generates a new data key, which encrypts all new data, and a new master key,
+which every existing key slot is rewrapped to unlock: age and KMS keys by their
+public keys, and password keys with the password given. Another password key
+cannot be rewrapped without its password, so rotation refuses to run until it
+is removed.
+
+With --reencrypt, everything already stored is re-encrypted with the new key
+and the old keys are retired, so a leaked key no longer decrypts anything.
+Re-encryption runs in the foreground, not in the background, because nothing
+else may write to the repository while it runs. An interrupted re-encryption
+leaves the repository readable and is finished by running the command again.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyRotate(directoryArg(args, 0), commands.KeyRotateOptions{
+				Reencrypt:         reencrypt,
+				RepositoryOptions: repositoryOptions(cmd),
+			})
+		},
+	}
+	rotateCmd.Flags().BoolVar(&reencrypt, "reencrypt", false, "Re-encrypt all stored data with the new key and retire the old keys")
+
+	storeCmd := &cobra.Command{
+		Use:   "store [directory]",
+		Short: "Store the repository password in the system keychain.",
+		Long: `Checks the repository password, given as usual or typed in at a prompt, and
+stores it in the system keychain: the macOS Keychain, the Windows Credential
+Manager, or the Secret Service on Linux (through secret-tool). Later commands
+that are given no password use it, so scheduled backups need no password file.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyStore(directoryArg(args, 0), commands.KeyOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+
+	forgetCmd := &cobra.Command{
+		Use:   "forget [directory]",
+		Short: "Remove the repository password from the system keychain.",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return commands.KeyForget(directoryArg(args, 0), commands.KeyOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+
+	cmd.AddCommand(listCmd, addCmd, removeCmd, passwdCmd, rotateCmd, storeCmd, forgetCmd)
+	return cmd
+}
@@ -15,7 +15,7 @@ func NewListCommand() *cobra.Command {
 			if len(args) > 0 {
 				dir = args[0]
 			}
-			return commands.List(dir)
+			return commands.List(dir, commands.ListOptions{RepositoryOptions: repositoryOptions(cmd)})
 		},
 	}
 	return cmd
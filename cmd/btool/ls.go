@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewLsCommand creates the 'ls' command for the CLI.
+func NewLsCommand() *cobra.Command {
+	var opts commands.LsOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "ls <snap_id_or_hash> [path]",
+		Short: "List the files in a snapshot.",
+		Long: `Lists the files and directories in a snapshot, or within one of its
+directories, with their modes, sizes, and modification times, without
+restoring anything.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			if len(args) > 1 {
+				opts.Path = args[1]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Ls(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().BoolVarP(&opts.Recursive, "recursive", "R", false, "List the contents of every directory below the path too")
+	cmd.Flags().BoolVar(&opts.JSON, "json", false, "Print the entries as a JSON array")
+
+	return cmd
+}
@@ -4,17 +4,110 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
 	"github.com/spf13/cobra"
 )
 
+// repoEnvVar names the environment variable that provides the default for --repo.
+const repoEnvVar = "BTOOL_REPO"
+
+// repositoryOptions collects the global repository flags as seen by cmd.
+func repositoryOptions(cmd *cobra.Command) commands.RepositoryOptions {
+	repo, _ := cmd.Flags().GetString("repo")
+	mirrors, _ := cmd.Flags().GetStringArray("mirror")
+	retries, _ := cmd.Flags().GetInt("retries")
+	limitUpload, _ := cmd.Flags().GetInt64("limit-upload")
+	limitDownload, _ := cmd.Flags().GetInt64("limit-download")
+	noCache, _ := cmd.Flags().GetBool("no-cache")
+	passwordFile, _ := cmd.Flags().GetString("password-file")
+	passwordCommand, _ := cmd.Flags().GetString("password-command")
+	encrypt, _ := cmd.Flags().GetBool("encrypt")
+	keyedHashes, _ := cmd.Flags().GetBool("keyed-hashes")
+	hashAlgorithm, _ := cmd.Flags().GetString("hash")
+	chunker, _ := cmd.Flags().GetString("chunker")
+	ageRecipients, _ := cmd.Flags().GetStringArray("age-recipient")
+	ageIdentityFile, _ := cmd.Flags().GetString("age-identity-file")
+	kmsKeys, _ := cmd.Flags().GetStringArray("kms-key")
+	compression, _ := cmd.Flags().GetString("compression")
+	compressionLevel, _ := cmd.Flags().GetInt("compression-level")
+	packSize, _ := cmd.Flags().GetInt64("pack-size")
+	return commands.RepositoryOptions{
+		Repo:             repo,
+		Mirrors:          mirrors,
+		Retries:          retries,
+		LimitUpload:      limitUpload,
+		LimitDownload:    limitDownload,
+		NoCache:          noCache,
+		PasswordFile:     passwordFile,
+		PasswordCommand:  passwordCommand,
+		Encrypt:          encrypt,
+		KeyedHashes:      keyedHashes,
+		HashAlgorithm:    hashAlgorithm,
+		Chunker:          chunker,
+		KDF:              kdfParams(cmd),
+		AgeRecipients:    ageRecipients,
+		AgeIdentityFile:  ageIdentityFile,
+		KMSKeys:          kmsKeys,
+		Compression:      compression,
+		CompressionLevel: compressionLevel,
+		PackSize:         packSize,
+	}
+}
+
 func main() {
 	var rootCmd = &cobra.Command{Use: "btool"}
 
+	// The repository can live anywhere; by default it is .btool in the directory.
+	rootCmd.PersistentFlags().String("repo", os.Getenv(repoEnvVar), "Repository location: a path, or a URL such as s3://bucket/prefix (defaults to $"+repoEnvVar+", then .btool in the directory)")
+	rootCmd.PersistentFlags().StringArray("mirror", nil, "Also replicate every write to this location (repeatable)")
+	rootCmd.PersistentFlags().Int("retries", 3, "How many times to retry a failed storage operation, with exponential backoff")
+	rootCmd.PersistentFlags().Int64("limit-upload", 0, "Limit uploads to the repository to this many KiB/s (0 for unlimited)")
+	rootCmd.PersistentFlags().Int64("limit-download", 0, "Limit downloads from the repository to this many KiB/s (0 for unlimited)")
+	rootCmd.PersistentFlags().String("password-file", "", "Read the password of an encrypted repository from this file (defaults to $"+commands.PasswordEnvVar+", then a prompt)")
+	rootCmd.PersistentFlags().String("password-command", "", "Read the password of an encrypted repository from the output of this shell command, e.g. 'pass show btool'")
+	rootCmd.PersistentFlags().Bool("encrypt", false, "Create a new repository encrypted, prompting for its password if none is given")
+	rootCmd.PersistentFlags().Bool("keyed-hashes", false, "Derive the object IDs of a new encrypted repository with a secret key, so they cannot reveal known files")
+	rootCmd.PersistentFlags().String("hash", "", "Derive the object IDs of a new repository with sha256 (the default) or blake3, which is faster")
+	rootCmd.PersistentFlags().String("chunker", "", "Split files of a new repository into chunks with rabin (the default) or fastcdc, which is faster")
+	rootCmd.PersistentFlags().String("kdf", "", "Derive keys from new passwords with this function: argon2id (the default) or scrypt")
+	rootCmd.PersistentFlags().Uint32("kdf-memory", 0, "The argon2id memory cost for new passwords in MiB (defaults to 64; see 'btool kdf tune')")
+	rootCmd.PersistentFlags().Uint32("kdf-iterations", 0, "The argon2id iterations for new passwords (defaults to 3)")
+	rootCmd.PersistentFlags().Uint8("kdf-parallelism", 0, "The argon2id lanes for new passwords (defaults to 4)")
+	rootCmd.PersistentFlags().StringArray("age-recipient", nil, "Encrypt a new repository to this age public key (repeatable)")
+	rootCmd.PersistentFlags().String("age-identity-file", "", "Unlock an age-encrypted repository with the identities in this file")
+	rootCmd.PersistentFlags().StringArray("kms-key", nil, "Wrap the master key of a new repository with this cloud KMS key, e.g. awskms://alias/btool (repeatable)")
+	rootCmd.PersistentFlags().String("compression", "", "Compress stored objects with off, lz4, zstd, or gzip (defaults to the repository's choice, then zstd)")
+	rootCmd.PersistentFlags().Int("compression-level", 0, "The compression level: 1-9 for lz4 and gzip, 1-22 for zstd (0 for the algorithm's default)")
+	rootCmd.PersistentFlags().Int64("pack-size", 0, "Write packs of this many MiB as a snap goes, which bounds its memory use (defaults to the repository's choice, then 64)")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "Do not cache a remote repository's index and snap manifests locally")
+
 	// Add commands
 	rootCmd.AddCommand(NewSnapCommand())
 	rootCmd.AddCommand(NewListCommand())
+	rootCmd.AddCommand(NewShowCommand())
+	rootCmd.AddCommand(NewStatusCommand())
+	rootCmd.AddCommand(NewStatsCommand())
+	rootCmd.AddCommand(NewLsCommand())
+	rootCmd.AddCommand(NewTreeCommand())
+	rootCmd.AddCommand(NewCatCommand())
+	rootCmd.AddCommand(NewDuCommand())
+	rootCmd.AddCommand(NewDiffCommand())
+	rootCmd.AddCommand(NewFindCommand())
+	rootCmd.AddCommand(NewHistoryCommand())
+	rootCmd.AddCommand(NewGrepCommand())
 	rootCmd.AddCommand(NewRestoreCommand())
 	rootCmd.AddCommand(NewPruneCommand())
+	rootCmd.AddCommand(NewForgetCommand())
+	rootCmd.AddCommand(NewRepackCommand())
+	rootCmd.AddCommand(NewWatchCommand())
+	rootCmd.AddCommand(NewDaemonCommand())
+	rootCmd.AddCommand(NewServeCommand())
+	rootCmd.AddCommand(NewCheckCommand())
+	rootCmd.AddCommand(NewRepairCommand())
+	rootCmd.AddCommand(NewRebuildIndexCommand())
+	rootCmd.AddCommand(NewCheckRemoteCommand())
+	rootCmd.AddCommand(NewKeyCommand())
+	rootCmd.AddCommand(NewKDFCommand())
 	rootCmd.AddCommand(NewCompletionCommand())
 
 	if err := rootCmd.Execute(); err != nil {
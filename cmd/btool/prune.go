@@ -1,34 +1,113 @@
 package main
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/spf13/cobra"
 )
 
 // NewPruneCommand creates the 'prune' command for the CLI.
 func NewPruneCommand() *cobra.Command {
+	var olderThan string
+	var before string
+	var dryRun bool
+	var repack bool
+	var keepBackup string
+	var undo bool
+
 	cmd := &cobra.Command{
-		Use:   "prune <snap-identifier> [directory]",
+		Use:   "prune [<snap-identifier>] [directory]",
 		Short: "Remove snapshots older than the specified one.",
 		Long: `Prunes the backup repository by removing all snapshots older than the
 specified snapshot and safely garbage-collecting all data that is no longer
-referenced by any of the kept snapshots.`,
-		Args: cobra.RangeArgs(1, 2),
+referenced by any of the kept snapshots.
+
+With --older-than or --before, no snapshot is given, and the snapshots taken
+more than that long ago, or before that date, are removed instead. The most
+recent snapshot is always kept.
+
+With --repack, the packs that hold data no kept snapshot needs beside data
+that one does are rewritten with only the latter, rather than kept whole.
+
+With --dry-run, the snapshots and packs that would be deleted, and the space
+that would be reclaimed, are listed without changing anything.
+
+With --keep-backup, what is deleted is kept in the repository's trash for that
+long, and 'btool prune --undo' restores what the latest such prune deleted
+until then. Each prune purges the backups whose time is over.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if olderThan != "" || before != "" || undo {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.RangeArgs(1, 2)(cmd, args)
+		},
 		ValidArgsFunction: snapshotCompletions,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// The first argument is the snapshot identifier.
-			snapIdentifier := args[0]
+			opts := commands.PruneOptions{DryRun: dryRun, Repack: repack, Undo: undo, RepositoryOptions: repositoryOptions(cmd)}
+			if keepBackup != "" {
+				age, err := lib.ParseAge(keepBackup)
+				if err != nil {
+					return err
+				}
+				opts.KeepBackup = age
+			}
+			switch {
+			case undo && (olderThan != "" || before != "" || keepBackup != "" || dryRun || repack):
+				return fmt.Errorf("--undo cannot be used with other prune flags")
+			case undo:
+				// The only argument is the directory.
+			case olderThan != "" && before != "":
+				return fmt.Errorf("--older-than and --before cannot be used together")
+			case olderThan != "":
+				age, err := lib.ParseAge(olderThan)
+				if err != nil {
+					return err
+				}
+				opts.Before = time.Now().Add(-age)
+			case before != "":
+				t, err := parseDate(before)
+				if err != nil {
+					return err
+				}
+				opts.Before = t
+			default:
+				// The first argument is the snapshot identifier.
+				opts.SnapIdentifier = args[0]
+				args = args[1:]
+			}
 
-			// The second, optional argument is the directory.
+			// The next, optional argument is the directory.
 			dir := "."
-			if len(args) > 1 {
-				dir = args[1]
+			if len(args) > 0 {
+				dir = args[0]
 			}
-
-			opts := commands.PruneOptions{SnapIdentifier: snapIdentifier}
 			return commands.Prune(dir, opts)
 		},
 	}
 
+	cmd.Flags().StringVar(&olderThan, "older-than", "", "Remove the snapshots taken longer ago than this, such as 30d, 2w, or 12h")
+	cmd.Flags().StringVar(&before, "before", "", "Remove the snapshots taken before this date, such as 2024-01-01, in local time")
+	cmd.Flags().BoolVar(&repack, "repack", false, "Also rewrite the packs that hold unneeded data beside needed data, as 'btool repack' does")
+	cmd.Flags().StringVar(&keepBackup, "keep-backup", "", "Keep what is deleted for this long, such as 24h or 7d, so that the prune can be undone")
+	cmd.Flags().BoolVar(&undo, "undo", false, "Restore what the latest prune run with --keep-backup deleted")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "List the snapshots and packs that would be deleted without changing anything")
+
 	return cmd
 }
+
+// parseDate parses a date, such as 2024-01-01, or a date and time in the
+// local time zone, such as "2024-01-01 15:04", or in RFC 3339.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: expected a date such as 2024-01-01", s)
+}
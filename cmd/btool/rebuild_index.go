@@ -0,0 +1,27 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewRebuildIndexCommand creates the 'rebuild-index' command for the CLI.
+func NewRebuildIndexCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rebuild-index [directory]",
+		Short: "Rebuild the index of the repository.",
+		Long: `Writes the index anew as one shard per pack, from the index files that can still
+be read and the packs themselves. Index files that cannot be read, or only list
+packs that no longer exist, are removed.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.RebuildIndex(dir, commands.RebuildIndexOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewRepackCommand creates the 'repack' command for the CLI.
+func NewRepackCommand() *cobra.Command {
+	var opts commands.RepackOptions
+
+	cmd := &cobra.Command{
+		Use:   "repack [directory]",
+		Short: "Drop the data no snapshot needs from the packs.",
+		Long: `Rewrites the packs of the repository that hold data no snapshot needs
+beside data that one does, keeping only the latter, and deletes the packs that
+hold nothing a snapshot needs. Prune keeps such packs whole, so the data would
+otherwise take up space for good. 'btool prune --repack' does the same as it
+prunes.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Repack(dir, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "List the packs that would be dropped and rewritten without changing anything")
+
+	return cmd
+}
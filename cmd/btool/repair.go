@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewRepairCommand creates the 'repair' command for the CLI.
+func NewRepairCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "repair [directory]",
+		Short: "Repair a damaged repository.",
+		Long: `Checks the repository as 'check' does, then drops the index entries of objects
+that are missing or corrupt, moves the intact objects of the packs that held them
+to new packs, and marks the snapshots that needed what was lost as damaged, so
+the rest of the repository can be used and pruned again.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.Repair(dir, commands.RepairOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+
+	return cmd
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
 	"github.com/spf13/cobra"
 )
@@ -9,17 +11,60 @@ import (
 func NewRestoreCommand() *cobra.Command {
 	var sourceDir string
 	var outputDir string
+	var subPath string
+	var toStdout bool
+	var include, exclude []string
+	var noDelete bool
+	var dryRun bool
+	var verify bool
+	var verifyOnly bool
+	var tarFile string
+	var onConflict string
+	var preserveOwner bool
+	var acls bool
+	var renameCollisions bool
 
 	cmd := &cobra.Command{
-		Use:   "restore <snap_id_or_hash>",
+		Use:   "restore <snap_id_or_hash> [output_dir]",
 		Short: "Restore a directory state from a snapshot.",
 		Long: `Restores a snapshot to a specified directory. The target directory
-will be modified to match the state of the snapshot.`,
-		Args: cobra.ExactArgs(1), // Requires exactly one argument: the snapshot identifier.
-		ValidArgsFunction: snapshotCompletions,
+will be modified to match the state of the snapshot. It can be given as the
+second argument instead of with --output.
+
+With --path, only that file or directory of the snapshot is restored, at the
+same path below the target directory, and the rest of it is left alone.
+With --path and --stdout, the file at that path is written to standard output
+instead, as 'btool cat' does.
+
+With --tar, the snapshot is written as a tar archive to the given file, or to
+standard output for "-", instead of being restored. Files named .tar.gz or .tgz
+are compressed.
+
+With --verify-only, the target directory is compared with the snapshot
+instead, by the contents, mode, and modification time of every file, and the
+differences are listed without changing anything. The command fails if there
+are any.
+
+--include and --exclude restore only the files whose paths in the snapshot
+match an include pattern, if any are given, and no exclude pattern. Patterns
+take the syntax of .btoolignore.`,
+		Args: cobra.RangeArgs(1, 2), // The snapshot identifier, and optionally the output directory.
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) == 1 {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			}
+			return snapshotCompletions(cmd, args, toComplete)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			snapIdentifier := args[0]
 
+			if len(args) == 2 {
+				if outputDir != "" {
+					return fmt.Errorf("the output directory is given both as an argument and with --output")
+				}
+				outputDir = args[1]
+			}
+
 			// If output directory is not specified, it defaults to the source directory.
 			finalOutputDir := outputDir
 			if finalOutputDir == "" {
@@ -27,13 +72,44 @@ will be modified to match the state of the snapshot.`,
 			}
 
 			// Call the core logic from the internal/btool/commands package.
-			return commands.Restore(sourceDir, snapIdentifier, finalOutputDir)
+			opts := commands.RestoreOptions{
+				SnapIdentifier:    snapIdentifier,
+				OutputDir:         finalOutputDir,
+				Path:              subPath,
+				Stdout:            toStdout,
+				Include:           include,
+				Exclude:           exclude,
+				NoDelete:          noDelete,
+				DryRun:            dryRun,
+				Verify:            verify,
+				VerifyOnly:        verifyOnly,
+				Tar:               tarFile,
+				OnConflict:        onConflict,
+				PreserveOwner:     preserveOwner,
+				ACLs:              acls,
+				RenameCollisions:  renameCollisions,
+				RepositoryOptions: repositoryOptions(cmd),
+			}
+			return commands.Restore(sourceDir, opts)
 		},
 	}
 
 	// Define flags for the command.
 	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
 	cmd.Flags().StringVarP(&outputDir, "output", "o", "", "The directory to restore files to (defaults to source directory)")
+	cmd.Flags().StringVar(&subPath, "path", "", "Restore only this file or directory of the snapshot")
+	cmd.Flags().BoolVar(&toStdout, "stdout", false, "Write the file given by --path to standard output instead of restoring it")
+	cmd.Flags().StringArrayVar(&include, "include", nil, "Restore only the files matching this pattern, as in .btoolignore (repeatable)")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Do not restore the files matching this pattern, as in .btoolignore (repeatable)")
+	cmd.Flags().BoolVar(&noDelete, "no-delete", false, "Keep files in the target directory that the snapshot does not hold")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print the files that would be created, overwritten, and deleted without writing anything")
+	cmd.Flags().StringVar(&tarFile, "tar", "", "Write the snapshot as a tar archive to this file, or to standard output for \"-\"")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", commands.ConflictOverwrite, "What to do with a file in the target directory that differs from the snapshot's: overwrite, skip, fail, or rename")
+	cmd.Flags().BoolVar(&verify, "verify", false, "Read the restored files back and check them against the snapshot's chunk hashes")
+	cmd.Flags().BoolVar(&verifyOnly, "verify-only", false, "Compare the target directory with the snapshot and list the differences without changing anything")
+	cmd.Flags().BoolVar(&preserveOwner, "preserve-owner", false, "Give restored files the user and group that owned them (usually needs root)")
+	cmd.Flags().BoolVar(&acls, "acls", false, "Give restored files the POSIX ACLs they had (Linux only)")
+	cmd.Flags().BoolVar(&renameCollisions, "rename-collisions", false, "Restore names that differ only in case under other names on a case-insensitive filesystem")
 
 	return cmd
 }
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/spf13/cobra"
+)
+
+// NewServeCommand creates the 'serve' command for the CLI.
+func NewServeCommand() *cobra.Command {
+	var opts commands.ServeOptions
+
+	cmd := &cobra.Command{
+		Use:   "serve [directory]",
+		Short: "Serve the repositories in a directory over HTTP.",
+		Long: `Serves every repository stored under the given directory over HTTP, so
+other machines can use it with --repo http://host:port/<name>. Each <name> is a
+subdirectory of the served directory and is created on the first snap.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.Serve(dir, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Listen, "listen", "127.0.0.1:8520", "The address to listen on")
+	cmd.Flags().StringVar(&opts.Token, "token", os.Getenv(lib.ServerTokenEnvVar), "An access token with full access to every repository (defaults to $"+lib.ServerTokenEnvVar+")")
+	cmd.Flags().StringVar(&opts.ClientsFile, "clients", "", "A JSON file of per-client tokens, roles, and repositories")
+	cmd.Flags().StringVar(&opts.TLSCertFile, "tls-cert", "", "A TLS certificate file, to serve HTTPS")
+	cmd.Flags().StringVar(&opts.TLSKeyFile, "tls-key", "", "The TLS private key file for --tls-cert")
+
+	return cmd
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewShowCommand creates the 'show' command for the CLI.
+func NewShowCommand() *cobra.Command {
+	var opts commands.ShowOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "show <snap_id_or_hash>",
+		Short: "Show the details of a snapshot.",
+		Long: `Prints everything the manifest of a snapshot records: its ID, hash,
+timestamp, message, root tree, sizes, and parent.`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Show(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().BoolVar(&opts.JSON, "json", false, "Print the snapshot manifest as JSON")
+
+	return cmd
+}
@@ -1,27 +1,94 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/spf13/cobra"
 )
 
 func NewSnapCommand() *cobra.Command {
 	var message string
+	var maxMemory int64
+	var forceRescan bool
+	var skipIfUnchanged bool
+	var specialFiles bool
+	var streams bool
+	var dryRun bool
+	var stdin bool
+	var stdinFilename string
+	var exclude, excludeFiles, include []string
+	var filesFrom string
+	var preSnap, postSnap string
+	var excludeLargerThan string
+	var maxSize string
 
 	cmd := &cobra.Command{
-		Use:   "snap [directory]",
+		Use:   "snap [path...]",
 		Short: "Create a new snap for a directory.",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Creates a new snap of a directory, the current one by default.
+
+Given several directories and files, the snap holds each of them at its top
+level, under the last element of its path, and is stored in the repository
+of the current directory unless --repo gives another.
+
+With --stdin, the snap holds a single file with what is piped into btool,
+named by --stdin-filename, as in: pg_dump mydb | btool snap --stdin
+--stdin-filename mydb.sql. It too is stored in the repository of the current
+directory unless --repo gives another.
+
+Executable files named pre-snap and post-snap in the hooks directory of a
+local repository, or of its cache directory otherwise, run before the files
+are read and once the snap is over. --pre-snap and --post-snap give shell
+commands to run instead. The post-snap hook learns how the snap went from
+BTOOL_SNAP_STATUS, BTOOL_SNAP_ID, BTOOL_SNAP_HASH, and other variables.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			dir := "."
-			if len(args) > 0 {
-				dir = args[0]
+			if stdin {
+				if len(args) > 0 {
+					return fmt.Errorf("no paths can be given with --stdin")
+				}
+				return commands.SnapStdin(os.Stdin, commands.SnapOptions{Message: message, StdinFilename: stdinFilename, MaxMemory: maxMemory, MaxSize: maxSize, PreSnap: preSnap, PostSnap: postSnap, RepositoryOptions: repositoryOptions(cmd)})
+			}
+			if stdinFilename != "" {
+				return fmt.Errorf("--stdin-filename can only be used with --stdin")
+			}
+			paths := args
+			if len(paths) == 0 {
+				paths = []string{"."}
+			}
+			var maxFileSize int64
+			if excludeLargerThan != "" {
+				size, err := lib.ParseSize(excludeLargerThan)
+				if err != nil {
+					return err
+				}
+				maxFileSize = size
 			}
-			return commands.Snap(dir, message)
+			return commands.SnapPaths(paths, commands.SnapOptions{Message: message, ForceRescan: forceRescan, SkipIfUnchanged: skipIfUnchanged, SpecialFiles: specialFiles, Streams: streams, MaxMemory: maxMemory, Exclude: exclude, ExcludeFiles: excludeFiles, Include: include, FilesFrom: filesFrom, DryRun: dryRun, ExcludeLargerThan: maxFileSize, MaxSize: maxSize, PreSnap: preSnap, PostSnap: postSnap, RepositoryOptions: repositoryOptions(cmd)})
 		},
 	}
 
 	cmd.Flags().StringVarP(&message, "message", "m", "", "A message to associate with the snap")
+	cmd.Flags().BoolVar(&forceRescan, "force-rescan", false, "Ignore the file cache and read every file, even those unchanged since the last snap")
+	cmd.Flags().BoolVar(&forceRescan, "force", false, "")
+	cmd.Flags().MarkDeprecated("force", "use --force-rescan instead")
+	cmd.Flags().BoolVar(&skipIfUnchanged, "skip-if-unchanged", false, "Create no snap if nothing changed since the latest one")
+	cmd.Flags().BoolVar(&specialFiles, "special-files", false, "Include FIFOs, sockets, and device nodes")
+	cmd.Flags().BoolVar(&streams, "streams", false, "Include the alternate data streams of files (Windows only)")
+	cmd.Flags().StringArrayVar(&exclude, "exclude", nil, "Leave out the files matching this pattern, as in .btoolignore (repeatable)")
+	cmd.Flags().StringArrayVar(&excludeFiles, "exclude-file", nil, "Leave out the files matching the patterns in this file, one per line as in .btoolignore (repeatable)")
+	cmd.Flags().StringVar(&excludeLargerThan, "exclude-larger-than", "", "Leave out the files larger than this size, such as 500M or 2G, listing them after the snap")
+	cmd.Flags().StringArrayVar(&include, "include", nil, "Snap only the files matching this pattern, as in .btoolignore (repeatable)")
+	cmd.Flags().StringVar(&filesFrom, "files-from", "", "Snap only the files and directories listed in this file, one per line, relative to the directory or absolute")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report how many files the snap would hold and about how much new data it would add, without writing anything")
+	cmd.Flags().BoolVar(&stdin, "stdin", false, "Snap what is read from standard input as a single file, instead of paths")
+	cmd.Flags().StringVar(&stdinFilename, "stdin-filename", "", "The name of the file that --stdin stores what it reads as (defaults to "+commands.DefaultStdinFilename+")")
+	cmd.Flags().StringVar(&preSnap, "pre-snap", "", "A shell command to run before the snap, in place of the repository's pre-snap hook; the snap is not taken if it fails")
+	cmd.Flags().StringVar(&postSnap, "post-snap", "", "A shell command to run once the snap is over, in place of the repository's post-snap hook")
+	cmd.Flags().StringVar(&maxSize, "max-size", "", "Store this as the most the repository may take up, such as 50G, removing the oldest snaps after a snap to stay under it (0 for no limit)")
+	cmd.Flags().Int64Var(&maxMemory, "max-memory", 0, "Hold at most this many MiB of file data in memory while snapping, waiting for it to be written (0 for no limit)")
 
 	return cmd
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewStatsCommand creates the 'stats' command for the CLI.
+func NewStatsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "stats [directory]",
+		Short: "Show how much deduplication and compression save.",
+		Long: `Reports the files, chunks, and sizes of each snapshot and of the repository
+as a whole, and how much deduplication and compression save on storing them.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.Stats(dir, commands.StatsOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewStatusCommand creates the 'status' command for the CLI.
+func NewStatusCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status [directory]",
+		Short: "Show the files that changed since the latest snapshot.",
+		Long: `Lists the files and directories added (+), removed (-), and modified (M)
+in a directory since its latest snapshot, like git status. Nothing is
+written to the repository.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			return commands.Status(dir, commands.StatusOptions{RepositoryOptions: repositoryOptions(cmd)})
+		},
+	}
+	return cmd
+}
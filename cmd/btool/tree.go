@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewTreeCommand creates the 'tree' command for the CLI.
+func NewTreeCommand() *cobra.Command {
+	var opts commands.TreeOptions
+	var sourceDir string
+
+	cmd := &cobra.Command{
+		Use:   "tree <snap_id_or_hash> [path]",
+		Short: "Draw the directory hierarchy of a snapshot.",
+		Long: `Draws the directories and files of a snapshot, or within one of its
+directories, as the tree utility does, with the size of each file. Entries
+that are new or changed since the snapshot before are marked.`,
+		Args:              cobra.RangeArgs(1, 2),
+		ValidArgsFunction: snapshotCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.SnapIdentifier = args[0]
+			if len(args) > 1 {
+				opts.Path = args[1]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Tree(sourceDir, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&sourceDir, "directory", "d", ".", "The directory containing the .btool database")
+	cmd.Flags().IntVarP(&opts.MaxDepth, "level", "L", 0, "Descend only this many levels of directories (0 for all)")
+
+	return cmd
+}
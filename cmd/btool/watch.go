@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/spf13/cobra"
+)
+
+// NewWatchCommand creates the 'watch' command for the CLI.
+func NewWatchCommand() *cobra.Command {
+	var opts commands.WatchOptions
+
+	cmd := &cobra.Command{
+		Use:   "watch [directory]",
+		Short: "Snap a directory whenever it changes.",
+		Long: `Takes a snap of the directory, then watches it and takes another after each
+burst of changes, once it has been quiet for --quiet-period. Snaps start at
+least --min-interval apart, however busy the directory is. Runs until
+interrupted.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			opts.RepositoryOptions = repositoryOptions(cmd)
+			return commands.Watch(dir, opts)
+		},
+	}
+
+	cmd.Flags().DurationVar(&opts.QuietPeriod, "quiet-period", commands.DefaultQuietPeriod, "How long the directory must go without changes before a snap")
+	cmd.Flags().DurationVar(&opts.MinInterval, "min-interval", commands.DefaultMinInterval, "The least time between the starts of two snaps")
+	cmd.Flags().StringVarP(&opts.Message, "message", "m", "", "A message to associate with each snap")
+	cmd.Flags().BoolVar(&opts.SkipIfUnchanged, "skip-if-unchanged", false, "Create no snap if changes were undone before it")
+	cmd.Flags().BoolVar(&opts.SpecialFiles, "special-files", false, "Include FIFOs, sockets, and device nodes")
+	cmd.Flags().BoolVar(&opts.Streams, "streams", false, "Include the alternate data streams of files (Windows only)")
+	cmd.Flags().Int64Var(&opts.MaxMemory, "max-memory", 0, "Hold at most this many MiB of file data in memory while snapping, waiting for it to be written (0 for no limit)")
+
+	return cmd
+}
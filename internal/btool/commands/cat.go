@@ -0,0 +1,81 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// CatOptions holds the configuration for the cat command.
+type CatOptions struct {
+	SnapIdentifier string
+	// Path is the file within the snap to print, with forward slashes.
+	Path string
+	RepositoryOptions
+}
+
+// writeManifest writes the contents that a file manifest lists to w as its
+// chunks are read, so that a file is never held in memory whole.
+func writeManifest(store *lib.ObjectStore, manifestHash string, w io.Writer) error {
+	var manifest types.FileManifest
+	if err := store.ReadObjectAsJSON(manifestHash, &manifest); err != nil {
+		return fmt.Errorf("failed to read manifest %s: %w", manifestHash, err)
+	}
+	hashes := make([]string, len(manifest.Chunks))
+	for i, chunkRef := range manifest.Chunks {
+		hashes[i] = chunkRef.Hash
+	}
+	return store.ReadObjects(hashes, func(chunkData []byte) error {
+		_, err := w.Write(chunkData)
+		return err
+	})
+}
+
+// Cat is the main function for the 'cat' command. It prints the contents
+// of a file within a snap to stdout, without restoring anything.
+func Cat(sourceDir string, options CatOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	return printSnapFile(store, snap, options.Path)
+}
+
+// printSnapFile writes the contents of the file at path p within a snap to
+// stdout.
+func printSnapFile(store *lib.ObjectStore, snap *lib.SnapDetail, p string) error {
+	entry, err := findSnapEntry(store, snap.RootTreeHash, cleanSnapPath(p))
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", p, snap.ID, err)
+	}
+	switch entry.Type {
+	case "blob":
+	case "tree":
+		return fmt.Errorf("%q is a directory in snap %d; use 'btool ls' to list it", p, snap.ID)
+	default:
+		return fmt.Errorf("%q is a %s in snap %d, which holds no contents", p, entry.Type, snap.ID)
+	}
+
+	writer := bufio.NewWriterSize(os.Stdout, restoreBufferSize)
+	if err := writeManifest(store, entry.Hash, writer); err != nil {
+		return fmt.Errorf("failed to read %q from snap %d: %w", p, snap.ID, err)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,62 @@
+package commands_test
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatCommand(t *testing.T) {
+	t.Run("should print a file as it was in the snap", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "subdir", "fileB.txt"), []byte("changed since"), 0644))
+
+		// Act
+		var catErr error
+		output := captureStdout(t, func() {
+			catErr = commands.Cat(sourceDir, commands.CatOptions{SnapIdentifier: "1", Path: "subdir/fileB.txt"})
+		})
+
+		// Assert
+		require.NoError(t, catErr)
+		assert.Equal(t, "me too", output)
+	})
+
+	t.Run("should print a file of many chunks whole", func(t *testing.T) {
+		// Arrange
+		sourceDir := t.TempDir()
+		content := make([]byte, 3*1024*1024)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "large.bin"), content, 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var catErr error
+		output := captureStdout(t, func() {
+			catErr = commands.Cat(sourceDir, commands.CatOptions{SnapIdentifier: "1", Path: "large.bin"})
+		})
+
+		// Assert
+		require.NoError(t, catErr)
+		assert.True(t, string(content) == output, "The printed contents should match the file")
+	})
+
+	t.Run("should refuse to print a directory", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		err := commands.Cat(sourceDir, commands.CatOptions{SnapIdentifier: "1", Path: "subdir"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is a directory")
+	})
+}
@@ -0,0 +1,481 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// CheckOptions holds the configuration for the check command.
+type CheckOptions struct {
+	// SkipData checks only that the objects the snaps need are in the index
+	// and their packs, without reading the packs to hash their contents.
+	SkipData bool
+	// ReadDataSubset, such as "5%", reads only that share of the packs to
+	// hash their contents. The packs fall into groups of about that size by
+	// their hashes, and each run reads the group after the one the last run
+	// on this machine read, so that all are read in turn.
+	ReadDataSubset string
+	RepositoryOptions
+}
+
+// repoChecker verifies a repository from its packs up to its snaps,
+// recording what it finds wrong.
+type repoChecker struct {
+	store *lib.ObjectStore
+	index types.PackIndex
+	// packs holds the size of each pack by hash.
+	packs map[string]int64
+	// dangling holds the index entries whose packs are missing or too short
+	// to hold them, and corrupt the objects whose stored bytes do not decode
+	// to their IDs, by hash.
+	dangling map[string]types.PackIndexEntry
+	corrupt  map[string]types.PackIndexEntry
+	// unreadable holds the packs that could not be read at all, whose
+	// objects all count as corrupt.
+	unreadable map[string]bool
+	// mismatched holds the packs whose bytes no longer hash to their names.
+	mismatched map[string]bool
+	// snaps are those the check read, and damaged holds the hashes of those
+	// that need objects that are missing or corrupt. Snaps that repair marked
+	// as damaged are counted there, but their problems are not reported.
+	snaps   []lib.SnapDetail
+	damaged map[string]bool
+	// trees and manifests hold whether each one checked, and all it refers
+	// to, is intact, as snaps mostly share them.
+	trees, manifests map[string]bool
+	problems         []string
+}
+
+// problem records something wrong.
+func (c *repoChecker) problem(format string, args ...any) {
+	c.problems = append(c.problems, fmt.Sprintf(format, args...))
+}
+
+// report prints the outcome of a step of the check, with the problems found
+// since the last, and returns how many there were.
+func (c *repoChecker) report(name, summary string) int {
+	found := len(c.problems)
+	if found == 0 {
+		fmt.Printf("   ✅ %-15s %s\n", name, summary)
+		return 0
+	}
+	fmt.Printf("   ❌ %-15s %d problem(s)\n", name, found)
+	for _, problem := range c.problems {
+		fmt.Printf("      - %s\n", problem)
+	}
+	c.problems = nil
+	return found
+}
+
+// usable reports whether an object is in the index, in a pack that holds
+// it, and, as far as checked, intact.
+func (c *repoChecker) usable(hash string) bool {
+	_, indexed := c.index[hash]
+	_, dangling := c.dangling[hash]
+	_, corrupt := c.corrupt[hash]
+	return indexed && !dangling && !corrupt
+}
+
+// checkIndex checks that every index entry lies within an existing pack.
+func (c *repoChecker) checkIndex() {
+	for hash, entry := range c.index {
+		size, exists := c.packs[entry.PackHash]
+		switch {
+		case !exists:
+			c.dangling[hash] = entry
+			c.problem("object %s is in pack %s, which is missing", shortHash(hash), shortHash(entry.PackHash))
+		case entry.Offset < 0 || entry.Length < 0 || entry.Offset+entry.Length > size:
+			c.dangling[hash] = entry
+			c.problem("object %s lies past the end of pack %s", shortHash(hash), shortHash(entry.PackHash))
+		}
+	}
+	sort.Strings(c.problems)
+}
+
+// checkStateFileName is the name of the file, in the local state directory
+// of a repository, that records which subset of the packs check read last.
+const checkStateFileName = "check-state.json"
+
+// checkState is what check remembers between runs.
+type checkState struct {
+	// DataSubsetRun counts the runs that read a subset of the packs.
+	DataSubsetRun int64 `json:"dataSubsetRun"`
+}
+
+// dataSubset selects the packs whose group, by hash, is group of groups.
+type dataSubset struct {
+	group, groups int
+}
+
+// parseDataSubset parses a share of the packs such as "5%" and returns how
+// many groups of about that size the packs fall into.
+func parseDataSubset(spec string) (int, error) {
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(spec), "%"), 64)
+	if err != nil || percent <= 0 || percent > 100 {
+		return 0, fmt.Errorf("invalid data subset %q: want a percentage above 0 and at most 100, such as 5%%", spec)
+	}
+	// Rounding the number of groups down makes each at least as large as
+	// asked.
+	return max(1, int(100/percent)), nil
+}
+
+// contains reports whether the pack with the given hash is in the subset. A
+// nil subset holds every pack.
+func (d *dataSubset) contains(packHash string) bool {
+	if d == nil {
+		return true
+	}
+	if len(packHash) < 8 {
+		return d.group == 0
+	}
+	prefix, err := strconv.ParseUint(packHash[:8], 16, 32)
+	if err != nil {
+		return d.group == 0
+	}
+	return int(prefix%uint64(d.groups)) == d.group
+}
+
+// loadCheckState reads what check remembers about the store's repository,
+// which is nothing if it has not run here before.
+func loadCheckState(store *lib.ObjectStore) (checkState, string, error) {
+	var state checkState
+	stateDir, err := localStateDir(store)
+	if err != nil {
+		return state, "", err
+	}
+	statePath := filepath.Join(stateDir, checkStateFileName)
+	content, err := os.ReadFile(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, statePath, nil
+	}
+	if err != nil {
+		return state, "", err
+	}
+	if err := json.Unmarshal(content, &state); err != nil {
+		return checkState{}, statePath, nil // Start over from the first subset.
+	}
+	return state, statePath, nil
+}
+
+// saveCheckState records what check remembers at statePath.
+func saveCheckState(statePath string, state checkState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(statePath, content, 0644)
+}
+
+// checkPacks reads the packs of subset to check that their objects hash to
+// their IDs, and returns how many objects and packs it read.
+func (c *repoChecker) checkPacks(subset *dataSubset) (int, int) {
+	byPack := make(map[string]map[string]types.PackIndexEntry)
+	for hash, entry := range c.index {
+		if _, dangling := c.dangling[hash]; dangling || !subset.contains(entry.PackHash) {
+			continue
+		}
+		if byPack[entry.PackHash] == nil {
+			byPack[entry.PackHash] = make(map[string]types.PackIndexEntry)
+		}
+		byPack[entry.PackHash][hash] = entry
+	}
+	checked := 0
+	for packHash, objects := range byPack {
+		err := c.store.CheckPack(packHash, objects, func(hash string, err error) {
+			if hash == "" {
+				c.mismatched[packHash] = true
+				c.problem("pack %s: %v", shortHash(packHash), err)
+				return
+			}
+			c.corrupt[hash] = objects[hash]
+			c.problem("object %s in pack %s: %v", shortHash(hash), shortHash(packHash), err)
+		})
+		if err != nil {
+			for hash, entry := range objects {
+				c.corrupt[hash] = entry
+			}
+			c.unreadable[packHash] = true
+			c.problem("pack %s could not be read: %v", shortHash(packHash), err)
+			continue
+		}
+		checked += len(objects)
+	}
+	sort.Strings(c.problems)
+	return checked, len(byPack)
+}
+
+// checkManifest checks that the manifest with the given hash, of the file
+// at path p, can be read, and that its chunks are usable.
+func (c *repoChecker) checkManifest(hash, p string) bool {
+	if intact, checked := c.manifests[hash]; checked {
+		return intact
+	}
+	c.manifests[hash] = false
+	if !c.usable(hash) {
+		c.problem("the manifest of %s (%s) is missing", p, shortHash(hash))
+		return false
+	}
+	var manifest types.FileManifest
+	if err := c.store.ReadObjectAsJSON(hash, &manifest); err != nil {
+		c.problem("the manifest of %s (%s) could not be read: %v", p, shortHash(hash), err)
+		return false
+	}
+	missing := 0
+	for _, chunk := range manifest.Chunks {
+		if !c.usable(chunk.Hash) {
+			missing++
+		}
+	}
+	if missing > 0 {
+		c.problem("%d of the %d chunks of %s are missing", missing, len(manifest.Chunks), p)
+		return false
+	}
+	c.manifests[hash] = true
+	return true
+}
+
+// checkTree checks that the tree with the given hash, found at path dir, can
+// be read, and that everything below it is intact.
+func (c *repoChecker) checkTree(hash, dir string) bool {
+	if intact, checked := c.trees[hash]; checked {
+		return intact
+	}
+	c.trees[hash] = false
+	name := dir + "/"
+	if dir == "" {
+		name = "the root directory"
+	}
+	if !c.usable(hash) {
+		c.problem("the tree of %s (%s) is missing", name, shortHash(hash))
+		return false
+	}
+	var tree types.Tree
+	if err := c.store.ReadObjectAsJSON(hash, &tree); err != nil {
+		c.problem("the tree of %s (%s) could not be read: %v", name, shortHash(hash), err)
+		return false
+	}
+	intact := true
+	for _, entry := range tree.Entries {
+		p := path.Join(dir, entry.Name)
+		switch entry.Type {
+		case "tree":
+			intact = c.checkTree(entry.Hash, p) && intact
+		case "blob":
+			intact = c.checkManifest(entry.Hash, p) && intact
+		}
+		for _, stream := range entry.Streams {
+			intact = c.checkManifest(stream.Hash, lib.StreamPath(p, stream.Name)) && intact
+		}
+	}
+	c.trees[hash] = intact
+	return intact
+}
+
+// checkSnaps checks that every snap can be read, along with everything it
+// refers to.
+func (c *repoChecker) checkSnaps() error {
+	snaps, err := c.store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots: %w", err)
+	}
+	c.snaps = snaps
+	files, err := c.store.Backend().List(lib.SnapsDirName)
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	snapFiles := 0
+	for _, file := range files {
+		if strings.HasSuffix(file.Name, ".json") {
+			snapFiles++
+		}
+	}
+	if unreadable := snapFiles - len(snaps); unreadable > 0 {
+		c.problem("%d snap file(s) could not be read", unreadable)
+	}
+	// Snaps marked as damaged go last, so the problems of the trees they
+	// share with the rest are reported with those.
+	ordered := make([]lib.SnapDetail, 0, len(snaps))
+	for _, marked := range []bool{false, true} {
+		for _, snap := range snaps {
+			if snap.Damaged == marked {
+				ordered = append(ordered, snap)
+			}
+		}
+	}
+	for _, snap := range ordered {
+		before := len(c.problems)
+		if c.checkTree(snap.RootTreeHash, "") {
+			continue
+		}
+		c.damaged[snap.Hash] = true
+		switch {
+		case snap.Damaged:
+			c.problems = c.problems[:before]
+		case len(c.problems) == before:
+			// The problems of the trees it shares with earlier snaps were
+			// reported with those.
+			c.problem("snap %d needs objects reported above", snap.ID)
+		default:
+			for i := before; i < len(c.problems); i++ {
+				c.problems[i] = fmt.Sprintf("snap %d: %s", snap.ID, c.problems[i])
+			}
+		}
+	}
+	return nil
+}
+
+// markedDamaged returns the IDs of the snaps that repair marked as damaged.
+func (c *repoChecker) markedDamaged() []string {
+	var marked []string
+	for _, snap := range c.snaps {
+		if snap.Damaged {
+			marked = append(marked, strconv.FormatInt(snap.ID, 10))
+		}
+	}
+	return marked
+}
+
+// shortHash returns the start of a hash, enough to tell objects apart in a
+// report.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// newRepoChecker returns a checker for the repository of store, having read
+// its index and listed its packs.
+func newRepoChecker(store *lib.ObjectStore) (*repoChecker, error) {
+	index, err := store.GetIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the index: %w", err)
+	}
+	packs, err := store.ListPacks()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packs: %w", err)
+	}
+	c := &repoChecker{
+		store:      store,
+		index:      index,
+		packs:      make(map[string]int64),
+		dangling:   make(map[string]types.PackIndexEntry),
+		corrupt:    make(map[string]types.PackIndexEntry),
+		unreadable: make(map[string]bool),
+		mismatched: make(map[string]bool),
+		damaged:    make(map[string]bool),
+		trees:      make(map[string]bool),
+		manifests:  make(map[string]bool),
+	}
+	for _, pack := range packs {
+		c.packs[pack.Name] = pack.Size
+	}
+	return c, nil
+}
+
+// run checks the index, then unless skipData the contents of the packs of
+// subset, then the snaps, printing the outcome of each, and returns how many
+// problems it found.
+func (c *repoChecker) run(skipData bool, subset *dataSubset) (int, error) {
+	found := 0
+	c.checkIndex()
+	found += c.report("index", fmt.Sprintf("%d object(s) in %d pack(s)", len(c.index), len(c.packs)))
+	if !skipData {
+		checked, packs := c.checkPacks(subset)
+		summary := fmt.Sprintf("%d object(s) hash to their IDs", checked)
+		if subset != nil {
+			summary = fmt.Sprintf("%d object(s) in %d pack(s) of subset %d of %d hash to their IDs", checked, packs, subset.group+1, subset.groups)
+		}
+		found += c.report("pack contents", summary)
+	}
+	if err := c.checkSnaps(); err != nil {
+		return found, err
+	}
+	summary := fmt.Sprintf("%d snap(s), %d tree(s), and %d file(s) complete", len(c.snaps), len(c.trees), len(c.manifests))
+	if marked := c.markedDamaged(); len(marked) > 0 {
+		summary += fmt.Sprintf(", but snap(s) %s marked as damaged", strings.Join(marked, ", "))
+	}
+	found += c.report("snaps", summary)
+	return found, nil
+}
+
+// Check is the main function for the 'check' command. It verifies that every
+// index entry lies within an existing pack, that the objects in the packs
+// hash to their IDs, and that every snap can be read along with every tree,
+// manifest, and chunk it refers to, so that damage is found before a restore
+// needs the data.
+func Check(directory string, options CheckOptions) error {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", directory, err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var subset *dataSubset
+	var state checkState
+	var statePath string
+	if options.ReadDataSubset != "" {
+		if options.SkipData {
+			return fmt.Errorf("--skip-data and --read-data-subset cannot be used together")
+		}
+		groups, err := parseDataSubset(options.ReadDataSubset)
+		if err != nil {
+			return err
+		}
+		if state, statePath, err = loadCheckState(store); err != nil {
+			return fmt.Errorf("failed to read the check state: %w", err)
+		}
+		subset = &dataSubset{group: int(state.DataSubsetRun % int64(groups)), groups: groups}
+	}
+
+	fmt.Printf("🔍 Checking %s...\n", store.Backend().Location())
+	c, err := newRepoChecker(store)
+	if err != nil {
+		return err
+	}
+	found, err := c.run(options.SkipData, subset)
+	if err != nil {
+		return err
+	}
+	if subset != nil {
+		// The next run reads the next subset, whatever this one found.
+		state.DataSubsetRun++
+		if err := saveCheckState(statePath, state); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not save the check state, so the next run reads the same subset: %v\n", err)
+		}
+	}
+	if found > 0 {
+		fmt.Println("Run 'btool repair' to drop what was lost and mark the snaps that need it as damaged.")
+		var damaged []string
+		for _, snap := range c.snaps {
+			if c.damaged[snap.Hash] && !snap.Damaged {
+				damaged = append(damaged, strconv.FormatInt(snap.ID, 10))
+			}
+		}
+		if len(damaged) > 0 {
+			return fmt.Errorf("found %d problem(s); damaged snap(s): %s", found, strings.Join(damaged, ", "))
+		}
+		return fmt.Errorf("found %d problem(s)", found)
+	}
+	fmt.Println("✅ No problems found!")
+	return nil
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// CheckRemoteOptions holds the configuration for the check-remote command.
+type CheckRemoteOptions struct {
+	RepositoryOptions
+}
+
+// checkStep is a single named probe run against a backend.
+type checkStep struct {
+	name string
+	run  func(backend lib.Backend) error
+}
+
+// probeSize is the size of the file written to test uploads and downloads.
+const probeSize = 64 * 1024
+
+// checkSteps returns the probes run by check-remote, in order. They write,
+// read back, and delete a small file named probeName, so they need the same
+// permissions as a snap and prune.
+func checkSteps(probeName string, probe []byte) []checkStep {
+	return []checkStep{
+		{"connect and list", func(backend lib.Backend) error {
+			_, err := backend.List(lib.SnapsDirName)
+			return err
+		}},
+		{"write", func(backend lib.Backend) error {
+			return backend.Put(probeName, probe)
+		}},
+		{"read", func(backend lib.Backend) error {
+			data, err := backend.Get(probeName)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data, probe) {
+				return fmt.Errorf("read back %d bytes that differ from the %d written", len(data), len(probe))
+			}
+			return nil
+		}},
+		{"read range", func(backend lib.Backend) error {
+			data, err := backend.GetRange(probeName, 1024, 1024)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(data, probe[1024:2048]) {
+				return fmt.Errorf("ranged read returned the wrong bytes")
+			}
+			return nil
+		}},
+		{"delete", func(backend lib.Backend) error {
+			if err := backend.Delete(probeName); err != nil {
+				return err
+			}
+			if _, err := backend.Get(probeName); !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("file still readable after delete (%v)", err)
+			}
+			return nil
+		}},
+	}
+}
+
+// checkBackend runs every probe against backend, printing the outcome and
+// latency of each. It stops at the first failure, since later probes depend
+// on earlier ones, and tries to remove the probe file if it was written.
+func checkBackend(backend lib.Backend) error {
+	suffix := make([]byte, 8)
+	probe := make([]byte, probeSize)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	if _, err := rand.Read(probe); err != nil {
+		return err
+	}
+	probeName := "meta/check-" + hex.EncodeToString(suffix)
+
+	for _, step := range checkSteps(probeName, probe) {
+		start := time.Now()
+		err := step.run(backend)
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			fmt.Printf("   ❌ %-18s %v\n", step.name, err)
+			_ = backend.Delete(probeName)
+			return fmt.Errorf("%s: %s failed: %w", backend.Location(), step.name, err)
+		}
+		fmt.Printf("   ✅ %-18s %v\n", step.name, elapsed)
+	}
+	return nil
+}
+
+// CheckRemote is the main function for the 'check-remote' command. It checks
+// that the configured repository and every mirror can be reached, that the
+// credentials work, and that files can be written, read, and deleted,
+// reporting the latency of each operation. Retries and caching are bypassed so
+// that problems show up immediately.
+func CheckRemote(directory string, options CheckRemoteOptions) error {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", directory, err)
+	}
+
+	var failed []error
+	for _, repo := range append([]string{options.Repo}, options.Mirrors...) {
+		backend, err := lib.OpenBackend(repo, absDir)
+		if err != nil {
+			fmt.Printf("🔌 Checking %s...\n   ❌ %v\n", repo, err)
+			failed = append(failed, fmt.Errorf("could not open repository: %w", err))
+			continue
+		}
+
+		fmt.Printf("🔌 Checking %s...\n", backend.Location())
+		if err := checkBackend(backend); err != nil {
+			failed = append(failed, err)
+		}
+		closeBackend(backend)
+	}
+
+	if len(failed) > 0 {
+		return errors.Join(failed...)
+	}
+	fmt.Println("✅ All checks passed!")
+	return nil
+}
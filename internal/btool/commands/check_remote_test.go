@@ -0,0 +1,46 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckRemoteCommand(t *testing.T) {
+	t.Run("should pass against a writable repository and leave nothing behind", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		repoDir := filepath.Join(t.TempDir(), "repo")
+
+		// Act
+		err := commands.CheckRemote(testDir, commands.CheckRemoteOptions{RepositoryOptions: commands.RepositoryOptions{Repo: repoDir}})
+
+		// Assert
+		require.NoError(t, err)
+		leftovers, err := os.ReadDir(filepath.Join(repoDir, "meta"))
+		require.NoError(t, err)
+		assert.Empty(t, leftovers, "The probe file should be deleted")
+	})
+
+	t.Run("should report every destination that fails", func(t *testing.T) {
+		// Arrange: a server that is not listening, and an unsupported scheme.
+		testDir := t.TempDir()
+		options := commands.RepositoryOptions{
+			Repo:    "http://127.0.0.1:1/repo",
+			Mirrors: []string{filepath.Join(t.TempDir(), "mirror"), "ftp://example.com/repo"},
+		}
+
+		// Act
+		err := commands.CheckRemote(testDir, commands.CheckRemoteOptions{RepositoryOptions: options})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "127.0.0.1:1")
+		assert.Contains(t, err.Error(), "unsupported repository scheme")
+		assert.NotContains(t, err.Error(), "mirror", "The working mirror should pass")
+	})
+}
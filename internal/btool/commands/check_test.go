@@ -0,0 +1,136 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCheckTest creates a repository with a snap of a file, then a second
+// snap that adds another in a pack of its own, returning the directory and
+// the hash of the second pack.
+func setupCheckTest(t *testing.T) (string, string) {
+	t.Helper()
+	sourceDir := setupRestoreTest(t)
+	packs, err := os.ReadDir(lib.GetPacksDir(sourceDir))
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileC.txt"), []byte("only in the second snap"), 0644))
+	require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+	after, err := os.ReadDir(lib.GetPacksDir(sourceDir))
+	require.NoError(t, err)
+	require.Len(t, after, 2)
+	for _, pack := range after {
+		if pack.Name() != packs[0].Name() {
+			return sourceDir, pack.Name()
+		}
+	}
+	t.Fatal("the second snap wrote no pack")
+	return "", ""
+}
+
+func TestCheckCommand(t *testing.T) {
+	t.Run("should find nothing wrong with an intact repository", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupCheckTest(t)
+
+		// Act
+		var checkErr error
+		output := captureStdout(t, func() {
+			checkErr = commands.Check(sourceDir, commands.CheckOptions{})
+		})
+
+		// Assert
+		require.NoError(t, checkErr)
+		assert.Contains(t, output, "✅ pack contents")
+		assert.Contains(t, output, "2 snap(s)")
+		assert.Contains(t, output, "No problems found!")
+	})
+
+	t.Run("should find objects whose bytes changed", func(t *testing.T) {
+		// Arrange
+		sourceDir, packHash := setupCheckTest(t)
+		packPath := filepath.Join(lib.GetPacksDir(sourceDir), packHash)
+		pack, err := os.ReadFile(packPath)
+		require.NoError(t, err)
+		for i := range pack {
+			pack[i] ^= 0xff
+		}
+		require.NoError(t, os.WriteFile(packPath, pack, 0644))
+
+		// Act
+		var checkErr error
+		output := captureStdout(t, func() {
+			checkErr = commands.Check(sourceDir, commands.CheckOptions{})
+		})
+
+		// Assert
+		require.Error(t, checkErr)
+		assert.Contains(t, checkErr.Error(), "damaged snap(s): 2")
+		assert.Contains(t, output, "❌ pack contents")
+		assert.Contains(t, output, "❌ snaps")
+	})
+
+	t.Run("should find index entries whose pack is missing without reading data", func(t *testing.T) {
+		// Arrange
+		sourceDir, packHash := setupCheckTest(t)
+		require.NoError(t, os.Remove(filepath.Join(lib.GetPacksDir(sourceDir), packHash)))
+
+		// Act
+		var checkErr error
+		output := captureStdout(t, func() {
+			checkErr = commands.Check(sourceDir, commands.CheckOptions{SkipData: true})
+		})
+
+		// Assert
+		require.Error(t, checkErr)
+		assert.Contains(t, checkErr.Error(), "damaged snap(s): 2")
+		assert.Contains(t, output, "which is missing")
+		assert.NotContains(t, output, "pack contents")
+		assert.Contains(t, output, "snap 2: ")
+	})
+
+	t.Run("should read a different subset of the packs on each run", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupCheckTest(t)
+		runCheck := func() string {
+			var checkErr error
+			output := captureStdout(t, func() {
+				checkErr = commands.Check(sourceDir, commands.CheckOptions{ReadDataSubset: "50%"})
+			})
+			require.NoError(t, checkErr)
+			return output
+		}
+
+		// Act
+		first, second, third := runCheck(), runCheck(), runCheck()
+
+		// Assert
+		assert.Contains(t, first, "subset 1 of 2")
+		assert.Contains(t, second, "subset 2 of 2")
+		assert.Contains(t, third, "subset 1 of 2")
+		assert.FileExists(t, filepath.Join(lib.GetBtoolDir(sourceDir), "check-state.json"))
+	})
+
+	t.Run("should reject a subset that is not a percentage", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupCheckTest(t)
+
+		for _, spec := range []string{"0%", "150%", "half"} {
+			// Act
+			var checkErr error
+			captureStdout(t, func() {
+				checkErr = commands.Check(sourceDir, commands.CheckOptions{ReadDataSubset: spec})
+			})
+
+			// Assert
+			assert.ErrorContains(t, checkErr, "invalid data subset", spec)
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/stretchr/testify/assert"
@@ -32,11 +33,11 @@ func TestSnapWithMessage(t *testing.T) {
 
 	// 1. Create a snap with a message
 	message := "this is a test message"
-	err := Snap(tmpDir, message)
+	err := Snap(tmpDir, SnapOptions{Message: message})
 	require.NoError(t, err, "Snap() failed")
 
 	// 2. Get the snaps and check the message
-	snaps, err := lib.GetSortedSnaps(tmpDir)
+	snaps, err := lib.NewLocalObjectStore(tmpDir).GetSortedSnaps()
 	require.NoError(t, err, "GetSortedSnaps() failed")
 
 	require.Len(t, snaps, 1)
@@ -48,24 +49,126 @@ func TestListDoesNotResetState(t *testing.T) {
 	defer cleanup()
 
 	// 1. Create an initial snap
-	err := Snap(tmpDir, "first snap")
+	err := Snap(tmpDir, SnapOptions{Message: "first snap"})
 	require.NoError(t, err, "Snap() failed")
 
 	// 2. Get the initial index state
-	store := lib.NewObjectStore(tmpDir)
+	store := lib.NewLocalObjectStore(tmpDir)
 	index1, err := store.GetIndex()
 	require.NoError(t, err, "GetIndex() failed")
 	require.NotEmpty(t, index1, "Index is empty after first snap, should not be")
 
 	// 3. Run the List command
-	err = List(tmpDir)
+	err = List(tmpDir, ListOptions{})
 	require.NoError(t, err, "List() failed")
 
 	// 4. Get the index state again and compare
 	// Re-create the store to simulate a separate command run
-	store2 := lib.NewObjectStore(tmpDir)
+	store2 := lib.NewLocalObjectStore(tmpDir)
 	index2, err := store2.GetIndex()
 	require.NoError(t, err, "GetIndex() failed after List()")
 
 	assert.Len(t, index2, len(index1), "List command appears to have reset the state")
 }
+
+func TestRestoreCaseCollisions(t *testing.T) {
+	// Restoring onto a case-insensitive filesystem is simulated, so that
+	// the snap can hold both names.
+	original := caseInsensitive
+	caseInsensitive = func(string) (bool, error) { return true, nil }
+	t.Cleanup(func() { caseInsensitive = original })
+
+	snapTree := func(t *testing.T) string {
+		t.Helper()
+		sourceDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "README.txt"), []byte("upper"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "readme.txt"), []byte("lower"), 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(sourceDir, "Docs"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "Docs", "a"), []byte("a"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "Docs", "A"), []byte("A"), 0644))
+		require.NoError(t, Snap(sourceDir, SnapOptions{}))
+		return sourceDir
+	}
+
+	t.Run("should fail without touching the output directory", func(t *testing.T) {
+		// Arrange
+		sourceDir := snapTree(t)
+		outputDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "keep"), []byte("keep"), 0644))
+
+		// Act
+		err := Restore(sourceDir, RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "README.txt, readme.txt")
+		assert.Contains(t, err.Error(), "Docs/A, Docs/a")
+		assert.Contains(t, err.Error(), "--rename-collisions")
+		assert.FileExists(t, filepath.Join(outputDir, "keep"))
+	})
+
+	t.Run("should restore later names under other names", func(t *testing.T) {
+		// Arrange
+		sourceDir := snapTree(t)
+		outputDir := filepath.Join(t.TempDir(), "out")
+
+		// Act
+		err := Restore(sourceDir, RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RenameCollisions: true})
+
+		// Assert
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(outputDir, "README.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "upper", string(content))
+		content, err = os.ReadFile(filepath.Join(outputDir, "readme (2).txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "lower", string(content))
+		content, err = os.ReadFile(filepath.Join(outputDir, "Docs", "a (2)"))
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(content))
+		assert.NoFileExists(t, filepath.Join(outputDir, "readme.txt"))
+	})
+}
+
+func TestFormatRestoreProgress(t *testing.T) {
+	testCases := []struct {
+		name              string
+		done, queued      int64
+		traversed         bool
+		bytes, totalBytes int64
+		elapsed           time.Duration
+		expected          string
+	}{
+		{
+			name: "should estimate from bytes when their total is known",
+			done: 10, queued: 40, traversed: false,
+			bytes: 1024, totalBytes: 4096, elapsed: 3 * time.Second,
+			expected: "   - Files: 10/40+, 1.00 KB of 4.00 KB written, ETA 9s",
+		},
+		{
+			name: "should estimate from files once all are queued",
+			done: 30, queued: 40, traversed: true,
+			bytes: 2048, elapsed: 6 * time.Second,
+			expected: "   - Files: 30/40, 2.00 KB written, ETA 2s",
+		},
+		{
+			name: "should not estimate from files still being queued",
+			done: 30, queued: 40, traversed: false,
+			bytes: 2048, elapsed: 6 * time.Second,
+			expected: "   - Files: 30/40+, 2.00 KB written",
+		},
+		{
+			name: "should not estimate when done",
+			done: 40, queued: 40, traversed: true,
+			bytes: 4096, totalBytes: 4096, elapsed: 6 * time.Second,
+			expected: "   - Files: 40/40, 4.00 KB of 4.00 KB written",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			line := formatRestoreProgress(tc.done, tc.queued, tc.traversed, tc.bytes, tc.totalBytes, tc.elapsed)
+			assert.Equal(t, tc.expected, line)
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// DaemonOptions holds the configuration for the daemon command.
+type DaemonOptions struct {
+	// ConfigFile is the JSON file listing the jobs to run. Empty selects
+	// lib.DefaultDaemonConfigFile.
+	ConfigFile string
+	// Stop ends the daemon when closed, as an interrupt does.
+	Stop <-chan struct{}
+	// RepositoryOptions apply to every job, except that a job's own repo
+	// replaces Repo.
+	RepositoryOptions
+}
+
+// runDaemonJob runs a job of the daemon once.
+func runDaemonJob(job lib.DaemonJob, options RepositoryOptions) error {
+	if job.Repo != "" {
+		options.Repo = job.Repo
+	}
+	switch job.Command {
+	case lib.DaemonCommandSnap:
+		return Snap(job.Directory, SnapOptions{Message: job.Message, SkipIfUnchanged: job.SkipIfUnchanged, SpecialFiles: job.SpecialFiles, Streams: job.Streams, RepositoryOptions: options})
+	case lib.DaemonCommandPrune:
+		return Prune(job.Directory, PruneOptions{KeepLast: job.KeepLast, RepositoryOptions: options})
+	}
+	return fmt.Errorf("unknown command %q", job.Command)
+}
+
+// Daemon is the main function for the 'daemon' command. It runs the jobs of
+// its config file on their schedules, one at a time, until interrupted or
+// options.Stop is closed. A job that fails is reported and runs again at its
+// next time; runs missed while another job ran are skipped.
+func Daemon(options DaemonOptions) error {
+	configFile := options.ConfigFile
+	if configFile == "" {
+		var err error
+		if configFile, err = lib.DefaultDaemonConfigFile(); err != nil {
+			return fmt.Errorf("could not find the daemon config: %w", err)
+		}
+	}
+	config, err := lib.LoadDaemonConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	// Read the password once rather than before every job, which would run
+	// a password command over and over.
+	if options.Password, err = resolvePassword(options.passwordSource()); err != nil {
+		return err
+	}
+	options.PasswordFile, options.PasswordCommand = "", ""
+
+	fmt.Printf("🕒 Running %d job(s) from \"%s\"...\n", len(config.Jobs), configFile)
+	now := time.Now()
+	next := make([]time.Time, len(config.Jobs))
+	for i, job := range config.Jobs {
+		next[i] = job.ParsedSchedule().Next(now)
+		if next[i].IsZero() {
+			return fmt.Errorf("job %s never runs: no time matches %q", job.Name, job.Schedule)
+		}
+		fmt.Printf("   - %s (%s): next at %s\n", job.Name, job.Schedule, next[i].Format(time.DateTime))
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupt)
+
+	for {
+		due := 0
+		for i := range next {
+			if next[i].Before(next[due]) {
+				due = i
+			}
+		}
+
+		timer := time.NewTimer(time.Until(next[due]))
+		select {
+		case <-timer.C:
+		case <-interrupt:
+			timer.Stop()
+			return nil
+		case <-options.Stop:
+			timer.Stop()
+			return nil
+		}
+
+		job := config.Jobs[due]
+		fmt.Printf("🕒 Running job %s...\n", job.Name)
+		if err := runDaemonJob(job, options.RepositoryOptions); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: job %s failed: %v\n", job.Name, err)
+		}
+		next[due] = job.ParsedSchedule().Next(time.Now())
+		fmt.Printf("   - Next run of %s at %s\n", job.Name, next[due].Format(time.DateTime))
+	}
+}
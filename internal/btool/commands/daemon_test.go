@@ -0,0 +1,88 @@
+package commands_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDaemonCommand(t *testing.T) {
+	// writeConfig writes a daemon config of jobs and returns its name.
+	writeConfig := func(t *testing.T, jobs ...lib.DaemonJob) string {
+		content, err := json.Marshal(lib.DaemonConfig{Jobs: jobs})
+		require.NoError(t, err)
+		fileName := filepath.Join(t.TempDir(), "daemon.json")
+		require.NoError(t, os.WriteFile(fileName, content, 0600))
+		return fileName
+	}
+
+	// runDaemon runs the daemon with configFile until the test ends.
+	runDaemon := func(t *testing.T, configFile string) {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- commands.Daemon(commands.DaemonOptions{ConfigFile: configFile, Stop: stop}) }()
+		t.Cleanup(func() {
+			close(stop)
+			assert.NoError(t, <-done)
+		})
+	}
+	// snapMessages returns the messages of the snaps of testDir, oldest first.
+	snapMessages := func(testDir string) []string {
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		if err != nil {
+			return nil
+		}
+		var messages []string
+		for _, snap := range snaps {
+			messages = append(messages, snap.Message)
+		}
+		return messages
+	}
+
+	t.Run("should run snap jobs on their schedules", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		configFile := writeConfig(t, lib.DaemonJob{Schedule: "@every 1s", Command: lib.DaemonCommandSnap, Directory: testDir, Message: "scheduled"})
+
+		// Act
+		runDaemon(t, configFile)
+
+		// Assert
+		require.Eventually(t, func() bool { return len(snapMessages(testDir)) >= 2 }, 10*time.Second, 50*time.Millisecond)
+		assert.Equal(t, "scheduled", snapMessages(testDir)[0])
+	})
+
+	t.Run("should run prune jobs keeping the latest snaps", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 3)
+		configFile := writeConfig(t, lib.DaemonJob{Schedule: "@every 1s", Command: lib.DaemonCommandPrune, Directory: testDir, KeepLast: 2})
+
+		// Act
+		runDaemon(t, configFile)
+
+		// Assert
+		require.Eventually(t, func() bool { return len(snapMessages(testDir)) == 2 }, 10*time.Second, 50*time.Millisecond)
+		assert.Equal(t, []string{"snap 2", "snap 3"}, snapMessages(testDir))
+	})
+
+	t.Run("should refuse a job that never runs", func(t *testing.T) {
+		// Arrange
+		configFile := writeConfig(t, lib.DaemonJob{Schedule: "0 0 30 2 *", Command: lib.DaemonCommandSnap, Directory: t.TempDir()})
+
+		// Act
+		err := commands.Daemon(commands.DaemonOptions{ConfigFile: configFile})
+
+		// Assert
+		assert.ErrorContains(t, err, "never runs")
+	})
+}
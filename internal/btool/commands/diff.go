@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// DiffOptions holds the configuration for the diff command.
+type DiffOptions struct {
+	// From and To identify the snaps to compare, the older first.
+	From, To string
+	RepositoryOptions
+}
+
+// The kinds of change diff reports.
+const (
+	changeAdded    = "+"
+	changeRemoved  = "-"
+	changeModified = "M"
+)
+
+// treeChange is a file or directory that differs between two snaps.
+type treeChange struct {
+	kind    string
+	path    string
+	entry   types.TreeEntry // As it is in the newer snap, or the older one if removed.
+	size    int64
+	oldSize int64 // Of a modified file, in the older snap.
+	// details says what differs about a modified file, where it is known,
+	// such as "contents, mtime".
+	details string
+}
+
+// differ compares the trees of two snaps.
+type differ struct {
+	store   *lib.ObjectStore
+	changes []treeChange
+}
+
+// entrySize returns the size of the contents of a tree entry, which only
+// files have.
+func (d *differ) entrySize(entry types.TreeEntry) (int64, error) {
+	if entry.Type != "blob" {
+		return 0, nil
+	}
+	var manifest types.FileManifest
+	if err := d.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+		return 0, fmt.Errorf("failed to read manifest %s: %w", entry.Hash, err)
+	}
+	return manifest.TotalSize, nil
+}
+
+// readEntries returns the entries of the tree with the given hash by name,
+// or none for an empty hash.
+func (d *differ) readEntries(treeHash string) (map[string]types.TreeEntry, error) {
+	entries := make(map[string]types.TreeEntry)
+	if treeHash == "" {
+		return entries, nil
+	}
+	var tree types.Tree
+	if err := d.store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	for _, entry := range tree.Entries {
+		entries[entry.Name] = entry
+	}
+	return entries, nil
+}
+
+// addWhole records an entry found at path p as added or removed, along with
+// everything below it if it is a directory.
+func (d *differ) addWhole(kind, p string, entry types.TreeEntry) error {
+	size, err := d.entrySize(entry)
+	if err != nil {
+		return err
+	}
+	d.changes = append(d.changes, treeChange{kind: kind, path: p, entry: entry, size: size})
+	if entry.Type != "tree" {
+		return nil
+	}
+	if kind == changeAdded {
+		return d.diffTrees("", entry.Hash, p)
+	}
+	return d.diffTrees(entry.Hash, "", p)
+}
+
+// diffTrees records the changes between the trees with hashes oldHash and
+// newHash, found at path dir, either of which may be empty for a tree that
+// is missing. Trees with the same hash hold the same entries, so they are
+// passed over without being read.
+func (d *differ) diffTrees(oldHash, newHash, dir string) error {
+	if oldHash == newHash {
+		return nil
+	}
+	oldEntries, err := d.readEntries(oldHash)
+	if err != nil {
+		return err
+	}
+	newEntries, err := d.readEntries(newHash)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldEntries)+len(newEntries))
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := path.Join(dir, name)
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+		switch {
+		case !inNew:
+			err = d.addWhole(changeRemoved, p, oldEntry)
+		case !inOld:
+			err = d.addWhole(changeAdded, p, newEntry)
+		case oldEntry.Type != newEntry.Type:
+			if err = d.addWhole(changeRemoved, p, oldEntry); err == nil {
+				err = d.addWhole(changeAdded, p, newEntry)
+			}
+		case newEntry.Type == "tree":
+			err = d.diffTrees(oldEntry.Hash, newEntry.Hash, p)
+		case oldEntry.Hash != newEntry.Hash || oldEntry.Mode != newEntry.Mode || oldEntry.Rdev != newEntry.Rdev:
+			err = d.addModified(p, oldEntry, newEntry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addModified records a file found at path p whose contents or mode
+// differ between the snaps.
+func (d *differ) addModified(p string, oldEntry, newEntry types.TreeEntry) error {
+	oldSize, err := d.entrySize(oldEntry)
+	if err != nil {
+		return err
+	}
+	size, err := d.entrySize(newEntry)
+	if err != nil {
+		return err
+	}
+	d.changes = append(d.changes, treeChange{kind: changeModified, path: p, entry: newEntry, size: size, oldSize: oldSize})
+	return nil
+}
+
+// Diff is the main function for the 'diff' command. It lists the files and
+// directories added, removed, and modified between two snaps.
+func Diff(sourceDir string, options DiffOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	from, err := store.FindSnap(options.From)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.From, err)
+	}
+	to, err := store.FindSnap(options.To)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.To, err)
+	}
+
+	d := &differ{store: store}
+	if err := d.diffTrees(from.RootTreeHash, to.RootTreeHash, ""); err != nil {
+		return fmt.Errorf("failed to compare snaps %d and %d: %w", from.ID, to.ID, err)
+	}
+
+	fmt.Printf("Changes from snap %d (%s) to snap %d (%s):\n", from.ID, from.Hash[:7], to.ID, to.Hash[:7])
+	if len(d.changes) == 0 {
+		fmt.Println("No changes.")
+		return nil
+	}
+	printChanges(d.changes)
+	return nil
+}
+
+// printChanges prints one line for each change, then how many files were
+// added, removed, and modified.
+func printChanges(changes []treeChange) {
+	counts := make(map[string]int)
+	for _, change := range changes {
+		name := change.path
+		if change.entry.Type == "tree" {
+			name += "/"
+		}
+		switch {
+		case change.kind == changeModified && change.details != "":
+			fmt.Printf("%s %s (%s -> %s) [%s]\n", change.kind, name, formatBytes(change.oldSize, 2), formatBytes(change.size, 2), change.details)
+		case change.kind == changeModified:
+			fmt.Printf("%s %s (%s -> %s)\n", change.kind, name, formatBytes(change.oldSize, 2), formatBytes(change.size, 2))
+		case change.entry.Type == "blob":
+			fmt.Printf("%s %s (%s)\n", change.kind, name, formatBytes(change.size, 2))
+		default:
+			fmt.Printf("%s %s\n", change.kind, name)
+		}
+		if change.entry.Type != "tree" {
+			counts[change.kind]++
+		}
+	}
+	fmt.Printf("\n%d file(s) added, %d removed, %d modified.\n", counts[changeAdded], counts[changeRemoved], counts[changeModified])
+}
@@ -0,0 +1,66 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffCommand(t *testing.T) {
+	t.Run("should report added, removed, and modified files", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileA.txt"), []byte("restore me, changed"), 0644))
+		require.NoError(t, os.RemoveAll(filepath.Join(sourceDir, "subdir")))
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "newdir"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "newdir", "fileC.txt"), []byte("new"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var diffErr error
+		output := captureStdout(t, func() {
+			diffErr = commands.Diff(sourceDir, commands.DiffOptions{From: "1", To: "2"})
+		})
+
+		// Assert
+		require.NoError(t, diffErr)
+		assert.Contains(t, output, "M fileA.txt (10.00 Bytes -> 19.00 Bytes)\n")
+		assert.Contains(t, output, "- subdir/\n")
+		assert.Contains(t, output, "- subdir/fileB.txt (6.00 Bytes)\n")
+		assert.Contains(t, output, "+ newdir/\n")
+		assert.Contains(t, output, "+ newdir/fileC.txt (3.00 Bytes)\n")
+		assert.Contains(t, output, "1 file(s) added, 1 removed, 1 modified.")
+	})
+
+	t.Run("should report nothing between snaps of the same tree", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var diffErr error
+		output := captureStdout(t, func() {
+			diffErr = commands.Diff(sourceDir, commands.DiffOptions{From: "1", To: "2"})
+		})
+
+		// Assert
+		require.NoError(t, diffErr)
+		assert.Contains(t, output, "No changes.")
+	})
+
+	t.Run("should fail for a snap that does not exist", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		err := commands.Diff(sourceDir, commands.DiffOptions{From: "1", To: "99"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "99")
+	})
+}
@@ -0,0 +1,119 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// DuOptions holds the configuration for the du command.
+type DuOptions struct {
+	SnapIdentifier string
+	// Path is the directory within the snap to sum up, with forward slashes.
+	// An empty path sums up the whole snap.
+	Path string
+	// MaxDepth is how many levels of directories below Path to print, or
+	// all of them if negative.
+	MaxDepth int
+	RepositoryOptions
+}
+
+// duLine is a directory and the total size of the files below it.
+type duLine struct {
+	path string
+	size int64
+}
+
+// sizeCounter sums up the sizes of the files below the directories of a
+// snap.
+type sizeCounter struct {
+	store    *lib.ObjectStore
+	maxDepth int
+	// sizes holds the total of each tree counted, as the same tree may turn
+	// up in several places.
+	sizes map[string]int64
+	lines []duLine
+}
+
+// treeSize returns the total size of the files below the tree with the
+// given hash, found at path p at the given depth below the path that du
+// was asked for, recording a line for it and the trees below it that are
+// not too deep, each after those below it, as du prints them.
+func (c *sizeCounter) treeSize(treeHash, p string, depth int) (int64, error) {
+	printed := c.maxDepth < 0 || depth <= c.maxDepth
+	if size, ok := c.sizes[treeHash]; ok && !printed {
+		return size, nil
+	}
+
+	var tree types.Tree
+	if err := c.store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return 0, fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	var size int64
+	for _, entry := range tree.Entries {
+		switch entry.Type {
+		case "tree":
+			entrySize, err := c.treeSize(entry.Hash, path.Join(p, entry.Name), depth+1)
+			if err != nil {
+				return 0, err
+			}
+			size += entrySize
+		case "blob":
+			var manifest types.FileManifest
+			if err := c.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+				return 0, fmt.Errorf("failed to read manifest of %s: %w", path.Join(p, entry.Name), err)
+			}
+			size += manifest.TotalSize
+		}
+	}
+	c.sizes[treeHash] = size
+	if printed {
+		c.lines = append(c.lines, duLine{path: p, size: size})
+	}
+	return size, nil
+}
+
+// Du is the main function for the 'du' command. It prints the total size of
+// the files below each directory within a snap, without restoring anything.
+func Du(sourceDir string, options DuOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	p := cleanSnapPath(options.Path)
+	entry, err := findSnapEntry(store, snap.RootTreeHash, p)
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", options.Path, snap.ID, err)
+	}
+	if entry.Type != "tree" {
+		return fmt.Errorf("%q is not a directory in snap %d; use 'btool ls' to see its size", options.Path, snap.ID)
+	}
+
+	c := &sizeCounter{store: store, maxDepth: options.MaxDepth, sizes: make(map[string]int64)}
+	if _, err := c.treeSize(entry.Hash, p, 0); err != nil {
+		return err
+	}
+	for _, line := range c.lines {
+		name := line.path
+		if name == "" {
+			name = "."
+		}
+		fmt.Printf("%-15s %s\n", formatBytes(line.size, 2), name)
+	}
+	return nil
+}
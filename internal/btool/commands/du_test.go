@@ -0,0 +1,78 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuCommand(t *testing.T) {
+	// setupDuTest creates a repository with a snap of a small tree, in which
+	// two directories have the same contents.
+	setupDuTest := func(t *testing.T) string {
+		t.Helper()
+		sourceDir := t.TempDir()
+		for _, dir := range []string{"a/x", "a/y", "b"} {
+			require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, dir), 0755))
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "top.txt"), []byte(strings.Repeat("t", 100)), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a", "x", "f"), []byte(strings.Repeat("f", 1000)), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a", "y", "f"), []byte(strings.Repeat("f", 1000)), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "b", "g"), []byte(strings.Repeat("g", 2048)), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		return sourceDir
+	}
+
+	t.Run("should print the size of every directory, deepest first", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupDuTest(t)
+
+		// Act
+		var duErr error
+		output := captureStdout(t, func() {
+			duErr = commands.Du(sourceDir, commands.DuOptions{SnapIdentifier: "1", MaxDepth: -1})
+		})
+
+		// Assert
+		require.NoError(t, duErr)
+		lines := strings.Split(strings.TrimSpace(output), "\n")
+		require.Len(t, lines, 5)
+		assert.Regexp(t, `^1000.00 Bytes +a/x$`, lines[0])
+		assert.Regexp(t, `^1000.00 Bytes +a/y$`, lines[1])
+		assert.Regexp(t, `^1.95 KB +a$`, lines[2])
+		assert.Regexp(t, `^2.00 KB +b$`, lines[3])
+		assert.Regexp(t, `^4.05 KB +\.$`, lines[4])
+	})
+
+	t.Run("should print only down to the maximum depth below a path", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupDuTest(t)
+
+		// Act
+		var duErr error
+		output := captureStdout(t, func() {
+			duErr = commands.Du(sourceDir, commands.DuOptions{SnapIdentifier: "1", Path: "a", MaxDepth: 0})
+		})
+
+		// Assert
+		require.NoError(t, duErr)
+		assert.Regexp(t, `^1.95 KB +a\n$`, output)
+	})
+
+	t.Run("should refuse a file", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupDuTest(t)
+
+		// Act
+		err := commands.Du(sourceDir, commands.DuOptions{SnapIdentifier: "1", Path: "top.txt"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is not a directory")
+	})
+}
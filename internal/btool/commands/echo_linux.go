@@ -0,0 +1,9 @@
+package commands
+
+import "golang.org/x/sys/unix"
+
+// The ioctl requests that read and change terminal settings.
+const (
+	ioctlGetTermios = unix.TCGETS
+	ioctlSetTermios = unix.TCSETS
+)
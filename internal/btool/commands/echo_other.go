@@ -0,0 +1,17 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package commands
+
+import "errors"
+
+// isTerminal always reports false, since passwords cannot be typed in on this
+// platform.
+func isTerminal(fd int) bool {
+	return false
+}
+
+// disableEcho is not supported on this platform, so passwords cannot be typed
+// in and must come from a file, a command, or the environment.
+func disableEcho(fd int) (func(), error) {
+	return nil, errors.New("not supported on this platform")
+}
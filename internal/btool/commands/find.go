@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// FindOptions holds the configuration for the find command.
+type FindOptions struct {
+	// Pattern is a glob in the syntax of path.Match. A pattern holding a
+	// slash is matched against the whole path of each entry within its snap,
+	// and any other against its name.
+	Pattern string
+	// Snaps identifies the snaps to search; all of them if empty.
+	Snaps []string
+	RepositoryOptions
+}
+
+// finder searches the trees of snaps for entries matching a pattern.
+type finder struct {
+	store   *lib.ObjectStore
+	pattern string
+	byPath  bool
+	// matches holds the paths matched below each tree searched by name,
+	// relative to it, as snaps mostly share their trees.
+	matches map[string][]string
+}
+
+// findInTree returns the paths of the entries of the tree with the given
+// hash, found at path dir, and of the trees below it, that match the
+// pattern.
+func (f *finder) findInTree(treeHash, dir string) ([]string, error) {
+	if !f.byPath {
+		if matched, ok := f.matches[treeHash]; ok {
+			return prefixPaths(dir, matched), nil
+		}
+		matched, err := f.searchTree(treeHash, "")
+		if err != nil {
+			return nil, err
+		}
+		f.matches[treeHash] = matched
+		return prefixPaths(dir, matched), nil
+	}
+	return f.searchTree(treeHash, dir)
+}
+
+// searchTree reads the tree with the given hash to find the matches of
+// findInTree.
+func (f *finder) searchTree(treeHash, dir string) ([]string, error) {
+	var tree types.Tree
+	if err := f.store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	var matched []string
+	for _, entry := range tree.Entries {
+		p := path.Join(dir, entry.Name)
+		subject := entry.Name
+		if f.byPath {
+			subject = p
+		}
+		if ok, _ := path.Match(f.pattern, subject); ok {
+			if entry.Type == "tree" {
+				matched = append(matched, p+"/")
+			} else {
+				matched = append(matched, p)
+			}
+		}
+		if entry.Type == "tree" {
+			below, err := f.findInTree(entry.Hash, p)
+			if err != nil {
+				return nil, err
+			}
+			matched = append(matched, below...)
+		}
+	}
+	return matched, nil
+}
+
+// prefixPaths returns paths with dir joined before each.
+func prefixPaths(dir string, paths []string) []string {
+	if dir == "" {
+		return paths
+	}
+	prefixed := make([]string, len(paths))
+	for i, p := range paths {
+		prefixed[i] = dir + "/" + p
+	}
+	return prefixed
+}
+
+// Find is the main function for the 'find' command. It lists the files and
+// directories whose names, or paths, match a glob in each of the snaps, so
+// that one can tell when a file appeared or disappeared.
+func Find(sourceDir string, options FindOptions) error {
+	pattern := strings.Trim(filepath.ToSlash(options.Pattern), "/")
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", options.Pattern, err)
+	}
+
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var snaps []lib.SnapDetail
+	if len(options.Snaps) == 0 {
+		if snaps, err = store.GetSortedSnaps(); err != nil {
+			return fmt.Errorf("failed to get snapshots: %w", err)
+		}
+	}
+	for _, identifier := range options.Snaps {
+		snap, err := store.FindSnap(identifier)
+		if err != nil {
+			return fmt.Errorf("failed to find snapshot %s: %w", identifier, err)
+		}
+		snaps = append(snaps, *snap)
+	}
+
+	f := &finder{store: store, pattern: pattern, byPath: strings.Contains(pattern, "/"), matches: make(map[string][]string)}
+	total, snapsMatched := 0, 0
+	for _, snap := range snaps {
+		matched, err := f.findInTree(snap.RootTreeHash, "")
+		if err != nil {
+			return fmt.Errorf("failed to search snap %d: %w", snap.ID, err)
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		if snapsMatched == 0 {
+			fmt.Printf("%-10s %-10s %-28s %s\n", "SNAPSHOT", "HASH", "TIMESTAMP", "PATH")
+		}
+		for _, p := range matched {
+			fmt.Printf("%-10s %-10s %-28s %s\n",
+				strconv.FormatInt(snap.ID, 10),
+				snap.Hash[:7],
+				snap.Timestamp.Format("2006-01-02 15:04:05 MST"),
+				p,
+			)
+		}
+		total += len(matched)
+		snapsMatched++
+	}
+
+	if total == 0 {
+		fmt.Printf("No matches for %q in %d snap(s).\n", options.Pattern, len(snaps))
+		return nil
+	}
+	fmt.Printf("\nFound %d match(es) in %d of %d snap(s).\n", total, snapsMatched, len(snaps))
+	return nil
+}
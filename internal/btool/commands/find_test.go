@@ -0,0 +1,90 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommand(t *testing.T) {
+	// setupFindTest creates a repository whose second snap adds a file and
+	// whose third removes it.
+	setupFindTest := func(t *testing.T) string {
+		t.Helper()
+		sourceDir := setupRestoreTest(t)
+		notesPath := filepath.Join(sourceDir, "subdir", "notes.txt")
+		require.NoError(t, os.WriteFile(notesPath, []byte("notes"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		require.NoError(t, os.Remove(notesPath))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		return sourceDir
+	}
+
+	t.Run("should find names across every snap", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupFindTest(t)
+
+		// Act
+		var findErr error
+		output := captureStdout(t, func() {
+			findErr = commands.Find(sourceDir, commands.FindOptions{Pattern: "*.txt"})
+		})
+
+		// Assert
+		require.NoError(t, findErr)
+		assert.Regexp(t, `(?m)^1 .* subdir/fileB\.txt$`, output)
+		assert.Regexp(t, `(?m)^2 .* subdir/notes\.txt$`, output)
+		assert.Regexp(t, `(?m)^3 .* fileA\.txt$`, output)
+		assert.Len(t, regexp.MustCompile(`(?m)notes\.txt$`).FindAllString(output, -1), 1, "Only snap 2 holds notes.txt")
+		assert.Contains(t, output, "Found 7 match(es) in 3 of 3 snap(s).")
+	})
+
+	t.Run("should match a pattern with a slash against whole paths in the selected snaps", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupFindTest(t)
+
+		// Act
+		var findErr error
+		output := captureStdout(t, func() {
+			findErr = commands.Find(sourceDir, commands.FindOptions{Pattern: "sub*/n*", Snaps: []string{"2", "3"}})
+		})
+
+		// Assert
+		require.NoError(t, findErr)
+		assert.Regexp(t, `(?m)^2 .* subdir/notes\.txt$`, output)
+		assert.NotContains(t, output, "fileB.txt")
+		assert.Contains(t, output, "Found 1 match(es) in 1 of 2 snap(s).")
+	})
+
+	t.Run("should report when nothing matches", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupFindTest(t)
+
+		// Act
+		var findErr error
+		output := captureStdout(t, func() {
+			findErr = commands.Find(sourceDir, commands.FindOptions{Pattern: "*.go"})
+		})
+
+		// Assert
+		require.NoError(t, findErr)
+		assert.Contains(t, output, `No matches for "*.go" in 3 snap(s).`)
+	})
+
+	t.Run("should reject a malformed pattern", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupFindTest(t)
+
+		// Act
+		err := commands.Find(sourceDir, commands.FindOptions{Pattern: "[a-"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid pattern")
+	})
+}
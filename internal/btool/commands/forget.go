@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// ForgetOptions holds the configuration for the forget command.
+type ForgetOptions struct {
+	// SnapIdentifiers identify the snaps to remove.
+	SnapIdentifiers []string
+	RepositoryOptions
+}
+
+// Forget is the main function for the 'forget' command. It removes the given
+// snaps, wherever they are in the timeline, and the data that no other snap
+// needs. Unlike prune, it also rewrites the packs that hold such data beside
+// data still needed, so that nothing only the forgotten snaps held, such as
+// a file that should never have been snapped, can be read back.
+func Forget(directory string, options ForgetOptions) error {
+	absSourceDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve path: %w", err)
+	}
+	if len(options.SnapIdentifiers) == 0 {
+		return fmt.Errorf("forget needs at least one snapshot to remove")
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	// 1. Find the snaps to forget, then split the timeline around them.
+	forget := make(map[string]bool)
+	for _, identifier := range options.SnapIdentifiers {
+		snap, err := store.FindSnap(identifier)
+		if err != nil {
+			return fmt.Errorf("failed to find snapshot %s: %w", identifier, err)
+		}
+		forget[snap.Hash] = true
+	}
+	allSnaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	var snapsToKeep, snapsToForget []lib.SnapDetail
+	for _, snap := range allSnaps {
+		if forget[snap.Hash] {
+			snapsToForget = append(snapsToForget, snap)
+		} else {
+			snapsToKeep = append(snapsToKeep, snap)
+		}
+	}
+
+	fmt.Printf("🧹 Forgetting %d snap(s) of \"%s\"...\n", len(snapsToForget), absSourceDir)
+	for _, snap := range snapsToForget {
+		fmt.Printf("   - Snap %d (%s), taken %s\n", snap.ID, snap.Hash[:7], snap.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	plan, err := planRemoval(store, snapsToKeep, snapsToForget, rewriteRemoved)
+	if err != nil {
+		return err
+	}
+	stats, err := plan.apply(store)
+	if err != nil {
+		return err
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Forget complete!")
+	fmt.Printf("   - Removed %d snap(s) and the data only they held.\n", stats.snaps)
+	stats.print()
+	return nil
+}
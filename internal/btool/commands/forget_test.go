@@ -0,0 +1,98 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForgetCommand(t *testing.T) {
+	// setupForgetTest takes three snaps, the second of which holds a secret
+	// beside a file that the third keeps, so that they share a pack.
+	setupForgetTest := func(t *testing.T) (string, string) {
+		t.Helper()
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		sourceDir := t.TempDir()
+		secret := "hunter2, the password of the production database"
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("first"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "b.txt"), []byte("kept from the second snap"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "secret.txt"), []byte(secret), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		require.NoError(t, os.Remove(filepath.Join(sourceDir, "secret.txt")))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		return sourceDir, secret
+	}
+
+	t.Run("should remove a snapshot from the middle and the data only it held", func(t *testing.T) {
+		// Arrange
+		sourceDir, secret := setupForgetTest(t)
+		store := lib.NewLocalObjectStore(sourceDir)
+		secretHash := store.Hasher().GetHash([]byte(secret))
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		require.Contains(t, index, secretHash)
+
+		// Act
+		var forgetErr error
+		output := captureStdout(t, func() {
+			forgetErr = commands.Forget(sourceDir, commands.ForgetOptions{SnapIdentifiers: []string{"2"}})
+		})
+
+		// Assert
+		require.NoError(t, forgetErr)
+		assert.Contains(t, output, "Rewrote pack")
+		lib.ResetObjectStoreState()
+		store = lib.NewLocalObjectStore(sourceDir)
+		snaps, err := store.GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 2)
+		assert.Equal(t, int64(1), snaps[0].ID)
+		assert.Equal(t, int64(3), snaps[1].ID)
+		index, err = store.GetIndex()
+		require.NoError(t, err)
+		assert.NotContains(t, index, secretHash)
+
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "3", OutputDir: outputDir}))
+		content, err := os.ReadFile(filepath.Join(outputDir, "b.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "kept from the second snap", string(content))
+		require.NoError(t, commands.Check(sourceDir, commands.CheckOptions{}))
+	})
+
+	t.Run("should remove several snapshots at once", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupForgetTest(t)
+
+		// Act
+		err := commands.Forget(sourceDir, commands.ForgetOptions{SnapIdentifiers: []string{"1", "latest"}})
+
+		// Assert
+		require.NoError(t, err)
+		snaps, err := lib.NewLocalObjectStore(sourceDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 1)
+		assert.Equal(t, int64(2), snaps[0].ID)
+	})
+
+	t.Run("should return an error for a non-existent snapshot identifier", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupForgetTest(t)
+
+		// Act
+		err := commands.Forget(sourceDir, commands.ForgetOptions{SnapIdentifiers: []string{"2", "99"}})
+
+		// Assert
+		require.Error(t, err)
+		snaps, err := lib.NewLocalObjectStore(sourceDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, snaps, 3, "no snapshot should be removed")
+	})
+}
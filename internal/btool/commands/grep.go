@@ -0,0 +1,150 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// GrepOptions holds the configuration for the grep command.
+type GrepOptions struct {
+	SnapIdentifier string
+	// Pattern is a regular expression in the syntax of the regexp package.
+	Pattern string
+	// Path is the file or directory within the snap to search, with forward
+	// slashes. An empty path searches the whole snap.
+	Path string
+	// IgnoreCase matches letters of either case.
+	IgnoreCase bool
+	// FilesOnly prints only the path of each file that matches, once.
+	FilesOnly bool
+	RepositoryOptions
+}
+
+// grepMaxLine is the longest line grep matches whole. Longer lines, which
+// mostly come of files that are not text, are matched on their start.
+const grepMaxLine = 1024 * 1024
+
+// errGrepDone stops the chunks of a file being read once grep needs no more.
+var errGrepDone = errors.New("grep done with file")
+
+// grepFile searches the contents of the file with the given manifest, found
+// at path p, printing its matching lines, and reports whether any matched.
+// The chunks are read as the lines are matched, so that a file is never held
+// in memory whole.
+func grepFile(store *lib.ObjectStore, manifestHash, p string, re *regexp.Regexp, filesOnly bool) (bool, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeManifest(store, manifestHash, writer))
+	}()
+	defer reader.CloseWithError(errGrepDone)
+
+	lines := bufio.NewReaderSize(reader, 64*1024)
+	var line []byte
+	binary, matched := false, false
+	for lineNumber := 1; ; lineNumber++ {
+		line = line[:0]
+		var err error
+		for {
+			var part []byte
+			var isPrefix bool
+			part, isPrefix, err = lines.ReadLine()
+			if len(line) < grepMaxLine {
+				line = append(line, part[:min(len(part), grepMaxLine-len(line))]...)
+			}
+			if !isPrefix || err != nil {
+				break
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return matched, nil
+		}
+		if err != nil {
+			return matched, fmt.Errorf("failed to read %s: %w", p, err)
+		}
+
+		// A file with NUL bytes is taken not to be text, as grep does.
+		binary = binary || bytes.IndexByte(line, 0) >= 0
+		if !re.Match(line) {
+			continue
+		}
+		matched = true
+		switch {
+		case filesOnly:
+			fmt.Println(p)
+			return true, nil
+		case binary:
+			fmt.Printf("Binary file %s matches\n", p)
+			return true, nil
+		}
+		fmt.Printf("%s:%d:%s\n", p, lineNumber, line)
+	}
+}
+
+// Grep is the main function for the 'grep' command. It prints the lines of
+// the files within a snap that match a regular expression, without
+// restoring anything.
+func Grep(sourceDir string, options GrepOptions) error {
+	pattern := options.Pattern
+	if options.IgnoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", options.Pattern, err)
+	}
+
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	p := cleanSnapPath(options.Path)
+	entry, err := findSnapEntry(store, snap.RootTreeHash, p)
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", options.Path, snap.ID, err)
+	}
+
+	matchedFiles := 0
+	search := func(p string, entry types.TreeEntry) error {
+		if entry.Type != "blob" {
+			return nil
+		}
+		matched, err := grepFile(store, entry.Hash, p, re, options.FilesOnly)
+		if matched {
+			matchedFiles++
+		}
+		return err
+	}
+	if entry.Type == "tree" {
+		err = walkSnapTree(store, entry.Hash, p, search)
+	} else {
+		err = search(p, entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	if matchedFiles == 0 {
+		fmt.Printf("No matches for %q in snap %d.\n", options.Pattern, snap.ID)
+	}
+	return nil
+}
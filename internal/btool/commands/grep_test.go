@@ -0,0 +1,92 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGrepCommand(t *testing.T) {
+	// setupGrepTest creates a repository with a snap of a few text files,
+	// one of them large enough to span many chunks, and a binary file.
+	setupGrepTest := func(t *testing.T) string {
+		t.Helper()
+		sourceDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("alpha\nbeta\nAlphabet\n"), 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(sourceDir, "logs"), 0755))
+		var large strings.Builder
+		for i := 0; i < 200000; i++ {
+			large.WriteString("nothing to see on this line\n")
+		}
+		large.WriteString("needle at the end")
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "logs", "large.log"), []byte(large.String()), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "logs", "blob.bin"), []byte("\x00\x01needle\x00"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		return sourceDir
+	}
+
+	t.Run("should print the matching lines with their numbers", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupGrepTest(t)
+
+		// Act
+		var grepErr error
+		output := captureStdout(t, func() {
+			grepErr = commands.Grep(sourceDir, commands.GrepOptions{SnapIdentifier: "1", Pattern: "^alpha|needle"})
+		})
+
+		// Assert
+		require.NoError(t, grepErr)
+		assert.Contains(t, output, "a.txt:1:alpha\n")
+		assert.NotContains(t, output, "Alphabet")
+		assert.Contains(t, output, "logs/large.log:200001:needle at the end\n")
+		assert.Contains(t, output, "Binary file logs/blob.bin matches\n")
+	})
+
+	t.Run("should ignore case and print only paths when asked", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupGrepTest(t)
+
+		// Act
+		var grepErr error
+		output := captureStdout(t, func() {
+			grepErr = commands.Grep(sourceDir, commands.GrepOptions{SnapIdentifier: "1", Pattern: "ALPHA", IgnoreCase: true, FilesOnly: true})
+		})
+
+		// Assert
+		require.NoError(t, grepErr)
+		assert.Equal(t, "a.txt\n", output)
+	})
+
+	t.Run("should search only within a path", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupGrepTest(t)
+
+		// Act
+		var grepErr error
+		output := captureStdout(t, func() {
+			grepErr = commands.Grep(sourceDir, commands.GrepOptions{SnapIdentifier: "1", Pattern: "alpha", Path: "logs"})
+		})
+
+		// Assert
+		require.NoError(t, grepErr)
+		assert.Contains(t, output, `No matches for "alpha" in snap 1.`)
+	})
+
+	t.Run("should reject a malformed pattern", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupGrepTest(t)
+
+		// Act
+		err := commands.Grep(sourceDir, commands.GrepOptions{SnapIdentifier: "1", Pattern: "("})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid pattern")
+	})
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// HistoryOptions holds the configuration for the history command.
+type HistoryOptions struct {
+	// Path is the file or directory within the snaps to follow, with forward
+	// slashes.
+	Path string
+	RepositoryOptions
+}
+
+// History is the main function for the 'history' command. It lists the
+// snaps in which a file or directory was added, changed, or removed, like
+// git log does for a file.
+func History(sourceDir string, options HistoryOptions) error {
+	p := cleanSnapPath(options.Path)
+	if p == "" {
+		return fmt.Errorf("a path within the snaps is required")
+	}
+
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots: %w", err)
+	}
+
+	fmt.Printf("History of \"%s\":\n", p)
+	var last *types.TreeEntry
+	events := 0
+	for _, snap := range snaps {
+		var current *types.TreeEntry
+		entry, err := findSnapEntry(store, snap.RootTreeHash, p)
+		switch {
+		case err == nil:
+			current = &entry
+		case !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, errNotDirectory):
+			return fmt.Errorf("failed to read snap %d: %w", snap.ID, err)
+		}
+
+		var change, size string
+		switch {
+		case current == nil && last == nil:
+			continue
+		case current == nil:
+			change = "removed"
+		case last == nil:
+			change = "added"
+		case current.Type != last.Type || current.Hash != last.Hash:
+			change = "modified"
+		default:
+			last = current
+			continue
+		}
+		last = current
+
+		if current != nil && current.Type == "blob" {
+			var manifest types.FileManifest
+			if err := store.ReadObjectAsJSON(current.Hash, &manifest); err != nil {
+				return fmt.Errorf("failed to read manifest in snap %d: %w", snap.ID, err)
+			}
+			size = formatBytes(manifest.TotalSize, 2)
+		}
+		if events == 0 {
+			fmt.Printf("%-10s %-10s %-28s %-10s %-15s %s\n", "SNAPSHOT", "HASH", "TIMESTAMP", "CHANGE", "SIZE", "MESSAGE")
+		}
+		fmt.Printf("%-10s %-10s %-28s %-10s %-15s %s\n",
+			strconv.FormatInt(snap.ID, 10),
+			snap.Hash[:7],
+			snap.Timestamp.Format("2006-01-02 15:04:05 MST"),
+			change,
+			size,
+			snap.Message,
+		)
+		events++
+	}
+
+	if events == 0 {
+		fmt.Printf("No snap holds \"%s\".\n", p)
+	}
+	return nil
+}
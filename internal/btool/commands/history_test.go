@@ -0,0 +1,62 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryCommand(t *testing.T) {
+	t.Run("should list the snaps in which a file changed", func(t *testing.T) {
+		// Arrange: snap 1 lacks the file, 2 adds it, 3 keeps it, 4 changes
+		// it, 5 removes it, and 6 adds it back.
+		sourceDir := setupRestoreTest(t)
+		filePath := filepath.Join(sourceDir, "subdir", "notes.txt")
+		steps := []func(){
+			func() { require.NoError(t, os.WriteFile(filePath, []byte("v1"), 0644)) },
+			func() {},
+			func() { require.NoError(t, os.WriteFile(filePath, []byte("v2 longer"), 0644)) },
+			func() { require.NoError(t, os.Remove(filePath)) },
+			func() { require.NoError(t, os.WriteFile(filePath, []byte("v3"), 0644)) },
+		}
+		for i, step := range steps {
+			step()
+			require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{Message: "step " + string(rune('2'+i))}))
+		}
+
+		// Act
+		var historyErr error
+		output := captureStdout(t, func() {
+			historyErr = commands.History(sourceDir, commands.HistoryOptions{Path: "./subdir/notes.txt"})
+		})
+
+		// Assert
+		require.NoError(t, historyErr)
+		assert.Regexp(t, `(?m)^2 .* added +2\.00 Bytes +step 2$`, output)
+		assert.Regexp(t, `(?m)^4 .* modified +9\.00 Bytes +step 4$`, output)
+		assert.Regexp(t, `(?m)^5 .* removed +step 5$`, output)
+		assert.Regexp(t, `(?m)^6 .* added +2\.00 Bytes +step 6$`, output)
+		assert.NotRegexp(t, `(?m)^[13] `, output)
+		assert.Len(t, strings.Split(strings.TrimSpace(output), "\n"), 6)
+	})
+
+	t.Run("should report a path that no snap holds", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var historyErr error
+		output := captureStdout(t, func() {
+			historyErr = commands.History(sourceDir, commands.HistoryOptions{Path: "fileA.txt/inner"})
+		})
+
+		// Assert
+		require.NoError(t, historyErr)
+		assert.Contains(t, output, `No snap holds "fileA.txt/inner".`)
+	})
+}
@@ -0,0 +1,49 @@
+package commands
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// KDFTuneOptions holds the configuration for the 'kdf tune' command.
+type KDFTuneOptions struct {
+	// Target is how long deriving a key from a password should take.
+	Target time.Duration
+	// Memory is the argon2id memory cost in MiB. Zero selects the default.
+	Memory uint32
+	// Parallelism is the number of argon2id lanes. Zero selects the number of
+	// CPUs, up to the default.
+	Parallelism uint8
+}
+
+// KDFTune is the main function for the 'kdf tune' command. It benchmarks
+// argon2id on this machine and prints the flags that make unlocking a
+// repository take about the target time.
+func KDFTune(options KDFTuneOptions) error {
+	if options.Target <= 0 {
+		return fmt.Errorf("the target time must be positive")
+	}
+	defaults := lib.DefaultKDF()
+	memory := defaults.Memory
+	if options.Memory != 0 {
+		memory = options.Memory << 10
+	}
+	parallelism := options.Parallelism
+	if parallelism == 0 {
+		parallelism = uint8(min(runtime.NumCPU(), int(defaults.Parallelism)))
+	}
+
+	fmt.Printf("⏱️  Benchmarking argon2id with %d MiB and %d lane(s), aiming for %s...\n", memory>>10, parallelism, options.Target)
+	params, elapsed, err := lib.TuneKDF(memory, parallelism, options.Target)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   - %s takes %s\n", params, elapsed.Round(time.Millisecond))
+	fmt.Printf("Use these flags when creating a repository or with 'key add' and 'key passwd':\n")
+	fmt.Printf("   --kdf %s --kdf-memory %d --kdf-iterations %d --kdf-parallelism %d\n",
+		params.Algorithm, params.Memory>>10, params.Iterations, params.Parallelism)
+	return nil
+}
@@ -0,0 +1,73 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKDFOptions(t *testing.T) {
+	cheap := lib.KDFParams{Algorithm: lib.KDFArgon2id, Memory: 64, Iterations: 2, Parallelism: 1}
+
+	// slotKDFs returns the KDF of every key slot of the repository in testDir.
+	slotKDFs := func(t *testing.T, testDir, password string) []string {
+		backend, err := lib.OpenBackend("", testDir)
+		require.NoError(t, err)
+		key, err := lib.UnlockRepositoryKey(backend, password)
+		require.NoError(t, err)
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		var kdfs []string
+		for _, slot := range slots {
+			kdfs = append(kdfs, slot.KDF)
+		}
+		return kdfs
+	}
+
+	t.Run("should create a repository with the chosen KDF", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("secret"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "pw", KDF: cheap}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{cheap.String()}, slotKDFs(t, testDir, "pw"))
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: commands.RepositoryOptions{Password: "pw"}}))
+	})
+
+	t.Run("should default to argon2id and change it with key passwd", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "old"}}))
+		require.Equal(t, []string{lib.DefaultKDF().String()}, slotKDFs(t, testDir, "old"))
+
+		// Act
+		err := commands.KeyPasswd(testDir, commands.KeyAddOptions{NewPassword: "new", RepositoryOptions: commands.RepositoryOptions{Password: "old", KDF: cheap}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{cheap.String()}, slotKDFs(t, testDir, "new"))
+	})
+
+	t.Run("should reject invalid parameters", func(t *testing.T) {
+		// Act
+		err := commands.Snap(t.TempDir(), commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "pw", KDF: lib.KDFParams{Algorithm: "bcrypt"}}})
+
+		// Assert
+		assert.ErrorContains(t, err, "unsupported key derivation function")
+	})
+
+	t.Run("should tune argon2id", func(t *testing.T) {
+		assert.NoError(t, commands.KDFTune(commands.KDFTuneOptions{Target: time.Millisecond, Memory: 1, Parallelism: 1}))
+		assert.Error(t, commands.KDFTune(commands.KDFTuneOptions{}))
+	})
+}
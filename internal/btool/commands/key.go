@@ -0,0 +1,304 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"filippo.io/age"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// NewPasswordEnvVar names the environment variable that can hold the new
+// password for 'key add' and 'key passwd'.
+const NewPasswordEnvVar = "BTOOL_NEW_PASSWORD"
+
+// KeyOptions holds the configuration for the 'key list' command.
+type KeyOptions struct {
+	RepositoryOptions
+}
+
+// KeyAddOptions holds the configuration for the 'key add' and 'key passwd'
+// commands. The new password is NewPassword, or else the contents of
+// NewPasswordFile, or else the output of NewPasswordCommand, or else
+// $BTOOL_NEW_PASSWORD, or else typed in twice at a prompt.
+type KeyAddOptions struct {
+	NewPassword        string
+	NewPasswordFile    string
+	NewPasswordCommand string
+	// AgeRecipient, if set, adds a slot for this age public key instead of a
+	// password.
+	AgeRecipient string
+	// KMSKey, if set, adds a slot for this cloud KMS key URI instead of a
+	// password.
+	KMSKey string
+	RepositoryOptions
+}
+
+// KeyRemoveOptions holds the configuration for the 'key remove' command.
+type KeyRemoveOptions struct {
+	KeyID string
+	RepositoryOptions
+}
+
+// KeyRotateOptions holds the configuration for the 'key rotate' command.
+type KeyRotateOptions struct {
+	// Reencrypt re-encrypts everything stored with the new data key and then
+	// retires the old ones.
+	Reencrypt bool
+	RepositoryOptions
+}
+
+// openRepositoryKey opens the repository for a key command and returns the
+// unlocked key along with the store, which the caller must close.
+func openRepositoryKey(targetDirectory string, options RepositoryOptions) (*lib.ObjectStore, *lib.RepositoryKey, error) {
+	absTargetPath, err := filepath.Abs(targetDirectory)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	}
+	store, err := openStore(options, absTargetPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := store.Key()
+	if key == nil {
+		store.Close()
+		return nil, nil, fmt.Errorf("repository %s is not encrypted", store.Backend().Location())
+	}
+	if options.KDF.Algorithm != "" {
+		if err := key.SetKDF(options.KDF); err != nil {
+			store.Close()
+			return nil, nil, err
+		}
+	}
+	return store, key, nil
+}
+
+// newPassword resolves the new password for 'key add' and 'key passwd'.
+func (options KeyAddOptions) newPassword() (string, error) {
+	password, err := resolvePassword(passwordSource{
+		password: options.NewPassword,
+		file:     options.NewPasswordFile,
+		command:  options.NewPasswordCommand,
+		envVar:   NewPasswordEnvVar,
+	})
+	if err != nil || password != "" {
+		return password, err
+	}
+	password, err = promptPassword("New password: ", true)
+	if errors.Is(err, errNoTerminal) {
+		return "", errors.New("no new password given; use --new-password-file, --new-password-command, or $" + NewPasswordEnvVar)
+	}
+	return password, err
+}
+
+// KeyList is the main function for the 'key list' command. It prints the key
+// slots of an encrypted repository, marking the one that was used to unlock it.
+func KeyList(targetDirectory string, options KeyOptions) error {
+	store, key, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	slots, err := key.ListKeySlots()
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	fmt.Printf("   %-18s %-10s %-22s %s\n", "ID", "TYPE", "CREATED", "DETAILS")
+	for _, slot := range slots {
+		marker := " "
+		if slot.Current {
+			marker = "*"
+		}
+		details := slot.Recipient + slot.KDF + slot.KMS // Only one is set.
+		fmt.Printf(" %s %-18s %-10s %-22s %s\n", marker, slot.ID, slot.Type, slot.Created, details)
+	}
+	return nil
+}
+
+// KeyAdd is the main function for the 'key add' command. It grants access to
+// a new password, age identity, or KMS key without re-encrypting any data.
+func KeyAdd(targetDirectory string, options KeyAddOptions) error {
+	var recipient *age.X25519Recipient
+	var kmsKey *lib.KMSKey
+	var err error
+	if options.AgeRecipient != "" && options.KMSKey != "" {
+		return errors.New("add either an age recipient or a KMS key, not both")
+	}
+	if options.AgeRecipient != "" {
+		if recipient, err = age.ParseX25519Recipient(options.AgeRecipient); err != nil {
+			return fmt.Errorf("invalid age recipient %q: %w", options.AgeRecipient, err)
+		}
+	}
+	if options.KMSKey != "" {
+		if kmsKey, err = lib.ParseKMSKey(options.KMSKey); err != nil {
+			return err
+		}
+	}
+
+	store, key, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var id string
+	switch {
+	case recipient != nil:
+		id, err = key.AddAgeSlot(recipient)
+	case kmsKey != nil:
+		id, err = key.AddKMSSlot(kmsKey)
+	default:
+		var password string
+		if password, err = options.newPassword(); err != nil {
+			return err
+		}
+		id, err = key.AddPasswordSlot(password)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add key: %w", err)
+	}
+	fmt.Printf("🔑 Added key %s\n", id)
+	return nil
+}
+
+// KeyRemove is the main function for the 'key remove' command. It revokes a
+// key slot; whoever held it can no longer unlock the repository.
+func KeyRemove(targetDirectory string, options KeyRemoveOptions) error {
+	store, key, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := key.RemoveKeySlot(options.KeyID); err != nil {
+		return fmt.Errorf("failed to remove key: %w", err)
+	}
+	fmt.Printf("🔑 Removed key %s\n", options.KeyID)
+	return nil
+}
+
+// KeyPasswd is the main function for the 'key passwd' command. It replaces
+// the password that unlocked the repository with a new one.
+func KeyPasswd(targetDirectory string, options KeyAddOptions) error {
+	store, key, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	password, err := options.newPassword()
+	if err != nil {
+		return err
+	}
+	id, err := key.ChangePassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to change password: %w", err)
+	}
+	fmt.Printf("🔑 Changed password; the new key is %s\n", id)
+	return nil
+}
+
+// KeyRotate is the main function for the 'key rotate' command. It switches the
+// repository to a new data key and master key, rewrapping every key slot, and
+// optionally re-encrypts the stored data with it so the old keys can be
+// retired. Re-encryption runs in the foreground rather than in the
+// background, since nothing else may write to the repository meanwhile; an
+// interrupted run resumes where it stopped.
+func KeyRotate(targetDirectory string, options KeyRotateOptions) error {
+	store, key, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	id, err := key.RotateDataKey()
+	if err != nil {
+		return fmt.Errorf("failed to rotate key: %w", err)
+	}
+	fmt.Printf("🔑 Rotated to data key %s and a new master key; every key now unlocks them, and new data is encrypted with it\n", id)
+	if !options.Reencrypt {
+		fmt.Println("   - Existing data still uses the old keys; run with --reencrypt to retire them.")
+		return nil
+	}
+
+	fmt.Println("🔁 Re-encrypting the repository...")
+	stats, err := store.Reencrypt(func(done, total int) {
+		fmt.Printf("\r   - Packs: %d/%d", done, total)
+	})
+	fmt.Println()
+	if err != nil {
+		return fmt.Errorf("failed to re-encrypt (the repository is still readable; run 'key rotate --reencrypt' again to finish): %w", err)
+	}
+	if err := key.RetireDataKeys(); err != nil {
+		return fmt.Errorf("failed to retire old keys: %w", err)
+	}
+	fmt.Printf("   - Re-encrypted %d object(s) in %d pack(s) and %d other file(s)\n", stats.Objects, stats.Packs, stats.Files)
+	fmt.Println("✅ Retired the old data keys.")
+	return nil
+}
+
+// KeyStore is the main function for the 'key store' command. It checks that
+// the repository password unlocks the repository, then stores it in the
+// system keychain, where later commands find it without being given one.
+func KeyStore(targetDirectory string, options KeyOptions) error {
+	password, err := resolvePassword(options.passwordSource())
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		password, err = promptPassword("Repository password: ", false)
+		if errors.Is(err, errNoTerminal) {
+			return fmt.Errorf("no password given; use --password-file, --password-command, or $%s", PasswordEnvVar)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	options.Password = password
+
+	keychain, err := lib.SystemKeychain()
+	if err != nil {
+		return err
+	}
+	store, _, err := openRepositoryKey(targetDirectory, options.RepositoryOptions)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	account := lib.KeychainAccount(store.Backend())
+	if err := keychain.Set(account, password); err != nil {
+		return fmt.Errorf("failed to store the password: %w", err)
+	}
+	fmt.Printf("🔑 Stored the password for %s in the system keychain\n", account)
+	return nil
+}
+
+// KeyForget is the main function for the 'key forget' command. It removes the
+// repository's password from the system keychain.
+func KeyForget(targetDirectory string, options KeyOptions) error {
+	absTargetPath, err := filepath.Abs(targetDirectory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	}
+	keychain, err := lib.SystemKeychain()
+	if err != nil {
+		return err
+	}
+	// The repository need not be unlocked to forget its password.
+	backend, err := lib.OpenBackend(options.Repo, absTargetPath)
+	if err != nil {
+		return fmt.Errorf("could not open repository: %w", err)
+	}
+	defer closeBackend(backend)
+
+	account := backend.Location()
+	if err := keychain.Delete(account); err != nil {
+		return fmt.Errorf("failed to remove the password: %w", err)
+	}
+	fmt.Printf("🔑 Removed the password for %s from the system keychain\n", account)
+	return nil
+}
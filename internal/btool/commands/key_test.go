@@ -0,0 +1,226 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCommand(t *testing.T) {
+	// newEncryptedRepo snaps a directory into a repository encrypted with password.
+	newEncryptedRepo := func(t *testing.T, password string) string {
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("secret"), 0644))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: password}}))
+		return testDir
+	}
+	withPassword := func(password string) commands.RepositoryOptions {
+		return commands.RepositoryOptions{Password: password}
+	}
+
+	t.Run("should unlock the repository with an added password", func(t *testing.T) {
+		// Arrange
+		testDir := newEncryptedRepo(t, "first")
+
+		// Act
+		err := commands.KeyAdd(testDir, commands.KeyAddOptions{NewPassword: "second", RepositoryOptions: withPassword("first")})
+
+		// Assert
+		require.NoError(t, err)
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("first")}))
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("second")}))
+		assert.NoError(t, commands.KeyList(testDir, commands.KeyOptions{RepositoryOptions: withPassword("second")}))
+	})
+
+	t.Run("should change the password", func(t *testing.T) {
+		// Arrange
+		testDir := newEncryptedRepo(t, "old")
+
+		// Act
+		err := commands.KeyPasswd(testDir, commands.KeyAddOptions{NewPassword: "new", RepositoryOptions: withPassword("old")})
+
+		// Assert
+		require.NoError(t, err)
+		assert.ErrorIs(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("old")}), lib.ErrWrongPassword)
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("new")}))
+	})
+
+	t.Run("should revoke a removed key", func(t *testing.T) {
+		// Arrange
+		testDir := newEncryptedRepo(t, "keeper")
+		require.NoError(t, commands.KeyAdd(testDir, commands.KeyAddOptions{NewPassword: "leaver", RepositoryOptions: withPassword("keeper")}))
+		backend, err := lib.OpenBackend("", testDir)
+		require.NoError(t, err)
+		key, err := lib.UnlockRepositoryKey(backend, "leaver")
+		require.NoError(t, err)
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		var leaverID string
+		for _, slot := range slots {
+			if slot.Current {
+				leaverID = slot.ID
+			}
+		}
+
+		// Act
+		err = commands.KeyRemove(testDir, commands.KeyRemoveOptions{KeyID: leaverID, RepositoryOptions: withPassword("keeper")})
+
+		// Assert
+		require.NoError(t, err)
+		assert.ErrorIs(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("leaver")}), lib.ErrWrongPassword)
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("keeper")}))
+	})
+
+	t.Run("should rotate and re-encrypt without losing data or keys", func(t *testing.T) {
+		// Arrange
+		testDir := newEncryptedRepo(t, "first")
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		identityFile := filepath.Join(t.TempDir(), "identity.txt")
+		require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+		require.NoError(t, commands.KeyAdd(testDir, commands.KeyAddOptions{AgeRecipient: identity.Recipient().String(), RepositoryOptions: withPassword("first")}))
+
+		// Act
+		err = commands.KeyRotate(testDir, commands.KeyRotateOptions{Reencrypt: true, RepositoryOptions: withPassword("first")})
+
+		// Assert
+		require.NoError(t, err)
+		for _, options := range []commands.RepositoryOptions{withPassword("first"), {AgeIdentityFile: identityFile}} {
+			restoreDir := t.TempDir()
+			require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: restoreDir, RepositoryOptions: options}))
+			content, err := os.ReadFile(filepath.Join(restoreDir, "file.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, "secret", string(content))
+		}
+	})
+
+	t.Run("should refuse to rotate while another password could not be rewrapped", func(t *testing.T) {
+		// Arrange
+		testDir := newEncryptedRepo(t, "first")
+		require.NoError(t, commands.KeyAdd(testDir, commands.KeyAddOptions{NewPassword: "second", RepositoryOptions: withPassword("first")}))
+
+		// Act
+		err := commands.KeyRotate(testDir, commands.KeyRotateOptions{RepositoryOptions: withPassword("first")})
+
+		// Assert
+		assert.ErrorContains(t, err, "without their passwords")
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{RepositoryOptions: withPassword("second")}))
+	})
+
+	t.Run("should refuse to manage keys of an unencrypted repository", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		err := commands.KeyList(testDir, commands.KeyOptions{})
+
+		// Assert
+		assert.ErrorContains(t, err, "is not encrypted")
+	})
+}
+
+// fakeSecretTool is a secret-tool stand-in that keeps one file per account,
+// named after the account's hex encoding, in $FAKE_KEYRING.
+const fakeSecretTool = `#!/bin/sh
+eval account=\${$#}
+file="$FAKE_KEYRING/$(printf '%s' "$account" | od -An -tx1 | tr -d ' \n')"
+case "$1" in
+store) cat > "$file" ;;
+lookup) [ -f "$file" ] || exit 1; cat "$file" ;;
+clear) rm -f "$file" ;;
+esac
+`
+
+func TestKeyCommand_Keychain(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake keychain stands in for secret-tool, which is only used on Linux")
+	}
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "secret-tool"), []byte(fakeSecretTool), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_KEYRING", t.TempDir())
+
+	t.Run("should unlock the repository with the stored password until it is forgotten", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("secret"), 0644))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "stored"}}))
+
+		// Act
+		err := commands.KeyStore(testDir, commands.KeyOptions{RepositoryOptions: commands.RepositoryOptions{Password: "stored"}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{}), "The keychain should supply the password")
+
+		require.NoError(t, commands.KeyForget(testDir, commands.KeyOptions{}))
+		assert.ErrorContains(t, commands.List(testDir, commands.ListOptions{}), "is encrypted")
+	})
+
+	t.Run("should not store a wrong password", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "right"}}))
+
+		// Act
+		err := commands.KeyStore(testDir, commands.KeyOptions{RepositoryOptions: commands.RepositoryOptions{Password: "wrong"}})
+
+		// Assert
+		assert.ErrorIs(t, err, lib.ErrWrongPassword)
+		assert.ErrorContains(t, commands.List(testDir, commands.ListOptions{}), "is encrypted")
+	})
+}
+
+// fakeAWS is an aws stand-in whose KMS "wraps" data by leaving it as it is,
+// printing it base64 encoded as aws kms does.
+const fakeAWS = `#!/bin/sh
+base64 | tr -d '\n'
+echo
+`
+
+func TestKeyCommand_KMS(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake aws CLI is a shell script")
+	}
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "aws"), []byte(fakeAWS), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	t.Run("should create a repository that the KMS unlocks without a password", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("secret"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{KMSKeys: []string{"awskms://alias/btool"}}})
+
+		// Assert
+		require.NoError(t, err)
+		restoreDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: restoreDir}))
+		content, err := os.ReadFile(filepath.Join(restoreDir, "file.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "secret", string(content))
+	})
+
+	t.Run("should add a KMS key to a password repository", func(t *testing.T) {
+		// Arrange
+		testDir := t.TempDir()
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "pw"}}))
+
+		// Act
+		err := commands.KeyAdd(testDir, commands.KeyAddOptions{KMSKey: "awskms://alias/btool", RepositoryOptions: commands.RepositoryOptions{Password: "pw"}})
+
+		// Assert
+		require.NoError(t, err)
+		assert.NoError(t, commands.List(testDir, commands.ListOptions{}))
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 )
@@ -30,32 +31,39 @@ func formatBytes(bytes int64, decimals int) string {
 	return fmt.Sprintf("%.*f %s", decimals, float64(bytes)/math.Pow(k, float64(i)), sizes[i])
 }
 
-// getStoredObjectsSize calculates the total size of all packfiles on disk.
-func getStoredObjectsSize(baseDir string) (int64, error) {
-	packsDir := lib.GetPacksDir(baseDir)
-	dirEntries, err := os.ReadDir(packsDir)
+// ListOptions holds the configuration for the list command.
+type ListOptions struct {
+	RepositoryOptions
+}
+
+// getStoredObjectsSize calculates the total size of all packfiles in the
+// repository, including those that prunes keep in the trash.
+func getStoredObjectsSize(store *lib.ObjectStore) (int64, error) {
+	packs, err := store.ListPacks()
 	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil // No packs exist yet.
-		}
 		return 0, err
 	}
+	trashes, err := store.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+	for _, trash := range trashes {
+		contents, err := store.TrashContents(trash)
+		if err != nil {
+			return 0, err
+		}
+		packs = append(packs, contents[lib.PacksDirName]...)
+	}
 
 	var totalSize int64
-	for _, entry := range dirEntries {
-		if !entry.IsDir() {
-			info, err := entry.Info()
-			if err != nil {
-				continue // Skip files we can't get info for.
-			}
-			totalSize += info.Size()
-		}
+	for _, pack := range packs {
+		totalSize += pack.Size
 	}
 	return totalSize, nil
 }
 
 // List is the main function for the 'list' command.
-func List(targetDirectory string) error {
+func List(targetDirectory string, options ListOptions) error {
 	absTargetPath, err := filepath.Abs(targetDirectory)
 	if err != nil {
 		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
@@ -65,38 +73,52 @@ func List(targetDirectory string) error {
 	}
 	
 
+	store, err := openStore(options.RepositoryOptions, absTargetPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	displayName := absTargetPath
+	if options.Repo != "" {
+		displayName = store.Backend().Location()
+	}
+
 	// 1. Get all sorted snapshots using our new library function.
-	snaps, err := lib.GetSortedSnaps(absTargetPath)
+	snaps, err := store.GetSortedSnaps()
 	if err != nil {
 		return fmt.Errorf("failed to get snapshots: %w", err)
 	}
 
 	if len(snaps) == 0 {
-		fmt.Printf("No snaps found for \"%s\".\n", absTargetPath)
+		fmt.Printf("No snaps found for \"%s\".\n", displayName)
 		return nil
 	}
 	
 	// 2. Calculate total stored size.
-	totalStoredSize, err := getStoredObjectsSize(absTargetPath)
+	totalStoredSize, err := getStoredObjectsSize(store)
 	if err != nil {
 		return fmt.Errorf("failed to calculate stored size: %w", err)
 	}
 
 	// 3. Print the formatted table.
-	fmt.Printf("Snaps for \"%s\":\n", absTargetPath)
+	fmt.Printf("Snaps for \"%s\":\n", displayName)
 	// Headers
 	fmt.Printf("%-10s %-10s %-28s %-15s %-15s %s\n", "SNAPSHOT", "HASH", "TIMESTAMP", "SOURCE SIZE", "SNAP SIZE", "MESSAGE")
 	// Separator
 	fmt.Printf("%-10s %-10s %-28s %-15s %-15s %s\n", "=======", "=======", "=======================", "=============", "=============", "=======")
 
 	for _, snap := range snaps {
+		message := snap.Message
+		if snap.Damaged {
+			message = strings.TrimSpace("[damaged] " + message)
+		}
 		fmt.Printf("%-10s %-10s %-28s %-15s %-15s %s\n",
 			strconv.FormatInt(snap.ID, 10),
 			snap.Hash[:7],
 			snap.Timestamp.Format("2006-01-02 15:04:05 MST"),
 			formatBytes(snap.SourceSize, 2),
 			formatBytes(snap.SnapSize, 2),
-			snap.Message,
+			message,
 		)
 	}
 	
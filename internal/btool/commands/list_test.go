@@ -51,7 +51,7 @@ func TestListCommand(t *testing.T) {
 		err := os.WriteFile(file1Path, []byte("version 1"), 0644)
 		require.NoError(t, err, "Setup failed: could not write file1")
 
-		err = commands.Snap(testDir, "first commit")
+		err = commands.Snap(testDir, commands.SnapOptions{Message: "first commit"})
 		require.NoError(t, err, "Setup failed: first snap command failed")
 
 		time.Sleep(10 * time.Millisecond)
@@ -60,13 +60,13 @@ func TestListCommand(t *testing.T) {
 		err = os.WriteFile(file1Path, []byte("version 2 is a bit longer"), 0644)
 		require.NoError(t, err, "Setup failed: could not modify file1")
 
-		err = commands.Snap(testDir, "second commit")
+		err = commands.Snap(testDir, commands.SnapOptions{Message: "second commit"})
 		require.NoError(t, err, "Setup failed: second snap command failed")
 
 		// Act: Capture the output of the List command.
 		var listErr error
 		output := captureStdout(t, func() {
-			listErr = commands.List(testDir)
+			listErr = commands.List(testDir, commands.ListOptions{})
 		})
 		require.NoError(t, listErr, "commands.List() returned an unexpected error")
 
@@ -113,7 +113,7 @@ func TestListCommand(t *testing.T) {
 		// Act
 		var listErr error
 		output := captureStdout(t, func() {
-			listErr = commands.List(testDir)
+			listErr = commands.List(testDir, commands.ListOptions{})
 		})
 
 		// Assert
@@ -126,7 +126,7 @@ func TestListCommand(t *testing.T) {
 		nonExistentDir := filepath.Join(t.TempDir(), "this_does_not_exist")
 
 		// Act
-		err := commands.List(nonExistentDir)
+		err := commands.List(nonExistentDir, commands.ListOptions{})
 
 		// Assert
 		require.Error(t, err, "Expected an error for a non-existent directory, but got nil")
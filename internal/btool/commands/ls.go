@@ -0,0 +1,227 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// LsOptions holds the configuration for the ls command.
+type LsOptions struct {
+	SnapIdentifier string
+	// Path is the file or directory within the snap to list, with forward
+	// slashes. An empty path lists the root of the snap.
+	Path string
+	// Recursive lists the contents of every directory below Path too.
+	Recursive bool
+	// JSON prints the entries as a JSON array instead of a table.
+	JSON bool
+	RepositoryOptions
+}
+
+// lsEntry is an entry of a snap as ls prints it with --json.
+type lsEntry struct {
+	Path    string     `json:"path"`
+	Type    string     `json:"type"`
+	Mode    uint32     `json:"mode"`
+	Size    int64      `json:"size"`
+	ModTime *time.Time `json:"mtime,omitempty"`
+	Hash    string     `json:"hash,omitempty"`
+}
+
+// cleanSnapPath returns a path within a snap with the redundant parts taken
+// out, relative to its root, or an empty string for the root itself.
+func cleanSnapPath(p string) string {
+	return strings.Trim(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+// errNotDirectory tells that a path within a snap goes through a file.
+var errNotDirectory = errors.New("not a directory")
+
+// findSnapEntry returns the entry at a path within the tree with the given
+// hash. An empty path gives an entry standing for the tree itself.
+func findSnapEntry(store *lib.ObjectStore, rootTreeHash, p string) (types.TreeEntry, error) {
+	entry := types.TreeEntry{Type: "tree", Hash: rootTreeHash, Mode: 0755}
+	if p == "" {
+		return entry, nil
+	}
+	names := strings.Split(p, "/")
+	for i, name := range names {
+		if entry.Type != "tree" {
+			return types.TreeEntry{}, fmt.Errorf("%s is %w", path.Join(names[:i]...), errNotDirectory)
+		}
+		var tree types.Tree
+		if err := store.ReadObjectAsJSON(entry.Hash, &tree); err != nil {
+			return types.TreeEntry{}, fmt.Errorf("failed to read tree %s: %w", entry.Hash, err)
+		}
+		found := false
+		for _, e := range tree.Entries {
+			if e.Name == name {
+				entry, found = e, true
+				break
+			}
+		}
+		if !found {
+			return types.TreeEntry{}, fmt.Errorf("%s: %w", p, fs.ErrNotExist)
+		}
+	}
+	return entry, nil
+}
+
+// walkSnapTree calls fn for each entry of the tree with the given hash,
+// found at path dir, and of the trees below it, each directory before its
+// contents.
+func walkSnapTree(store *lib.ObjectStore, treeHash, dir string, fn func(p string, entry types.TreeEntry) error) error {
+	var tree types.Tree
+	if err := store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	for _, entry := range tree.Entries {
+		p := path.Join(dir, entry.Name)
+		if err := fn(p, entry); err != nil {
+			return err
+		}
+		if entry.Type == "tree" {
+			if err := walkSnapTree(store, entry.Hash, p, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// entryFileMode returns the mode of a tree entry, with the bits telling its
+// type as os.Lstat would give them.
+func entryFileMode(entry types.TreeEntry) fs.FileMode {
+	mode := fs.FileMode(entry.Mode).Perm()
+	switch entry.Type {
+	case "tree":
+		mode |= fs.ModeDir
+	case lib.EntryTypeFIFO:
+		mode |= fs.ModeNamedPipe
+	case lib.EntryTypeSocket:
+		mode |= fs.ModeSocket
+	case lib.EntryTypeCharDevice:
+		mode |= fs.ModeDevice | fs.ModeCharDevice
+	case lib.EntryTypeBlockDevice:
+		mode |= fs.ModeDevice
+	}
+	return mode
+}
+
+// lister collects the entries of a snap for ls.
+type lister struct {
+	store     *lib.ObjectStore
+	recursive bool
+	entries   []lsEntry
+}
+
+// add records an entry found at path p, reading its manifest for its size.
+func (l *lister) add(p string, entry types.TreeEntry) error {
+	listed := lsEntry{Path: p, Type: entry.Type, Mode: uint32(entryFileMode(entry)), Hash: entry.Hash}
+	if entry.ModTime != 0 {
+		modTime := time.Unix(0, entry.ModTime).UTC()
+		listed.ModTime = &modTime
+	}
+	if entry.Type == "blob" {
+		var manifest types.FileManifest
+		if err := l.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+			return fmt.Errorf("failed to read manifest of %s: %w", p, err)
+		}
+		listed.Size = manifest.TotalSize
+	}
+	l.entries = append(l.entries, listed)
+	return nil
+}
+
+// addTree records the entries of the tree with the given hash, found at
+// path dir, and with recursive those of the trees below it.
+func (l *lister) addTree(treeHash, dir string) error {
+	var tree types.Tree
+	if err := l.store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	for _, entry := range tree.Entries {
+		p := path.Join(dir, entry.Name)
+		if err := l.add(p, entry); err != nil {
+			return err
+		}
+		if l.recursive && entry.Type == "tree" {
+			if err := l.addTree(entry.Hash, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Ls is the main function for the 'ls' command. It lists the entries of a
+// directory within a snap, or a single file, without restoring anything.
+func Ls(sourceDir string, options LsOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	p := cleanSnapPath(options.Path)
+	entry, err := findSnapEntry(store, snap.RootTreeHash, p)
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", options.Path, snap.ID, err)
+	}
+
+	l := &lister{store: store, recursive: options.Recursive}
+	if entry.Type == "tree" {
+		err = l.addTree(entry.Hash, p)
+	} else {
+		err = l.add(p, entry)
+	}
+	if err != nil {
+		return err
+	}
+
+	if options.JSON {
+		if l.entries == nil {
+			l.entries = []lsEntry{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(l.entries)
+	}
+
+	for _, e := range l.entries {
+		size := "-"
+		if e.Type == "blob" {
+			size = fmt.Sprint(e.Size)
+		}
+		modTime := "-"
+		if e.ModTime != nil {
+			modTime = e.ModTime.Local().Format("2006-01-02 15:04:05")
+		}
+		name := e.Path
+		if e.Type == "tree" {
+			name += "/"
+		}
+		fmt.Printf("%-11s %12s  %-19s  %s\n", fs.FileMode(e.Mode), size, modTime, name)
+	}
+	return nil
+}
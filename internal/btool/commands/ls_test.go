@@ -0,0 +1,88 @@
+package commands_test
+
+import (
+	"encoding/json"
+	"io/fs"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLsCommand(t *testing.T) {
+	t.Run("should list the root of a snap", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var lsErr error
+		output := captureStdout(t, func() {
+			lsErr = commands.Ls(sourceDir, commands.LsOptions{SnapIdentifier: "1"})
+		})
+
+		// Assert
+		require.NoError(t, lsErr)
+		assert.Regexp(t, `(?m)^-\S+ +10 .* fileA\.txt$`, output)
+		assert.Regexp(t, `(?m)^d\S+ +- .* subdir/$`, output)
+		assert.NotContains(t, output, "fileB.txt", "Only the root should be listed without --recursive")
+	})
+
+	t.Run("should list every entry with --recursive", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var lsErr error
+		output := captureStdout(t, func() {
+			lsErr = commands.Ls(sourceDir, commands.LsOptions{SnapIdentifier: "1", Recursive: true})
+		})
+
+		// Assert
+		require.NoError(t, lsErr)
+		assert.Regexp(t, `(?m)^-\S+ +6 .* subdir/fileB\.txt$`, output)
+	})
+
+	t.Run("should list a directory or a file within the snap as JSON", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		for _, path := range []string{"subdir", "/subdir/./fileB.txt"} {
+			// Act
+			var lsErr error
+			output := captureStdout(t, func() {
+				lsErr = commands.Ls(sourceDir, commands.LsOptions{SnapIdentifier: "1", Path: path, JSON: true})
+			})
+
+			// Assert
+			require.NoError(t, lsErr)
+			var entries []struct {
+				Path string      `json:"path"`
+				Type string      `json:"type"`
+				Mode fs.FileMode `json:"mode"`
+				Size int64       `json:"size"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(output), &entries))
+			require.Len(t, entries, 1, path)
+			assert.Equal(t, "subdir/fileB.txt", entries[0].Path)
+			assert.Equal(t, "blob", entries[0].Type)
+			assert.True(t, entries[0].Mode.IsRegular())
+			assert.Equal(t, int64(6), entries[0].Size)
+		}
+	})
+
+	t.Run("should fail for a path the snap does not hold", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		err := commands.Ls(sourceDir, commands.LsOptions{SnapIdentifier: "1", Path: "subdir/missing.txt"})
+		notDirErr := commands.Ls(sourceDir, commands.LsOptions{SnapIdentifier: "1", Path: "fileA.txt/inner"})
+
+		// Assert
+		require.Error(t, err)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		require.Error(t, notDirErr)
+		assert.Contains(t, notDirErr.Error(), "fileA.txt is not a directory")
+	})
+}
@@ -0,0 +1,130 @@
+package commands
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// PasswordEnvVar names the environment variable that can hold the password of
+// an encrypted repository.
+const PasswordEnvVar = "BTOOL_PASSWORD"
+
+// errNoTerminal is returned when a password would have to be typed in, but
+// standard input is not a terminal.
+var errNoTerminal = errors.New("standard input is not a terminal")
+
+// passwordSource lists the places a password may come from. resolvePassword
+// tries them in the order of the fields.
+type passwordSource struct {
+	password string // Given directly, by callers of the commands package.
+	file     string // A file holding the password.
+	command  string // A shell command that prints the password.
+	envVar   string // An environment variable holding the password.
+}
+
+// resolvePassword returns the first password found in source, or an empty
+// string if none was given. It is the one place commands read secrets from.
+func resolvePassword(source passwordSource) (string, error) {
+	switch {
+	case source.password != "":
+		return source.password, nil
+	case source.file != "":
+		content, err := os.ReadFile(source.file)
+		if err != nil {
+			return "", fmt.Errorf("could not read password file: %w", err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	case source.command != "":
+		return runPasswordCommand(source.command)
+	default:
+		return os.Getenv(source.envVar), nil
+	}
+}
+
+// keychainPassword returns the password stored for the repository behind
+// backend in the system keychain, or an empty string if there is none. A
+// keychain that cannot be reached is only worth a warning, since the password
+// may still come from elsewhere.
+func keychainPassword(backend lib.Backend) string {
+	keychain, err := lib.SystemKeychain()
+	if err != nil {
+		return ""
+	}
+	password, err := keychain.Get(lib.KeychainAccount(backend))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: could not read the system keychain: %v\n", err)
+	}
+	return password
+}
+
+// runPasswordCommand runs command with sh and returns the first line it
+// prints. Standard input and error are passed through, so tools such as
+// `pass` can ask for their own passphrase.
+func runPasswordCommand(command string) (string, error) {
+	cmd := exec.Command("sh", "-c", command)
+	var stdout bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("password command %q failed: %w", command, err)
+	}
+	password, _, _ := strings.Cut(stdout.String(), "\n")
+	password = strings.TrimRight(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("password command %q printed no password", command)
+	}
+	return password, nil
+}
+
+// promptPassword asks for a password on the terminal without echoing it. With
+// confirm, the password must be typed twice, as when it is first chosen.
+func promptPassword(prompt string, confirm bool) (string, error) {
+	if !isTerminal(int(os.Stdin.Fd())) {
+		return "", errNoTerminal
+	}
+	password, err := readHidden(prompt)
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("the password must not be empty")
+	}
+	if confirm {
+		again, err := readHidden("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+		if err != nil {
+			return "", err
+		}
+		if again != password {
+			return "", errors.New("the passwords do not match")
+		}
+	}
+	return password, nil
+}
+
+// readHidden prints prompt to standard error and reads a line from standard
+// input with echo turned off.
+func readHidden(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	defer fmt.Fprintln(os.Stderr)
+
+	restore, err := disableEcho(int(os.Stdin.Fd()))
+	if err != nil {
+		return "", fmt.Errorf("could not turn off terminal echo: %w", err)
+	}
+	defer restore()
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("could not read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
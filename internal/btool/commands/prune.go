@@ -2,10 +2,14 @@ package commands
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
@@ -14,11 +18,34 @@ import (
 // PruneOptions holds the configuration for the prune command.
 type PruneOptions struct {
 	SnapIdentifier string
+	// KeepLast, when SnapIdentifier is empty, keeps the KeepLast most recent
+	// snaps and removes the ones before them.
+	KeepLast int
+	// Before, when neither is set, removes the snaps taken before it. The
+	// most recent snap is kept even if it was, so that a prune run on a
+	// schedule never leaves the repository empty.
+	Before time.Time
+	// Repack also rewrites the packs that hold objects no kept snap needs
+	// beside ones they do, keeping only the latter, rather than keeping
+	// such packs whole.
+	Repack bool
+	// DryRun lists the snaps and packs that pruning would delete, and the
+	// space it would reclaim, without changing anything.
+	DryRun bool
+	// KeepBackup keeps the deleted snaps and packs in the trash for this
+	// long, so that the prune can be undone until then, rather than deleting
+	// them outright.
+	KeepBackup time.Duration
+	// Undo restores what the latest prune run with KeepBackup deleted, if it
+	// has not been purged yet, instead of pruning.
+	Undo bool
+	RepositoryOptions
 }
 
 // markReachableObjects is a recursive function to find all objects referenced by a starting hash.
-// It's designed to be run in a goroutine.
-func markReachableObjects(store *lib.ObjectStore, startHash string, liveHashes *sync.Map) error {
+// It's designed to be run in a goroutine. With damaged, objects that cannot be
+// read are taken as lost, and the rest are still marked.
+func markReachableObjects(store *lib.ObjectStore, startHash string, liveHashes *sync.Map, damaged bool) error {
 	// Check if we've already processed this hash to avoid redundant work.
 	if _, loaded := liveHashes.LoadOrStore(startHash, true); loaded {
 		return nil
@@ -28,6 +55,9 @@ func markReachableObjects(store *lib.ObjectStore, startHash string, liveHashes *
 	// A simple way is to try to unmarshal it as JSON. Chunks are raw binary and will fail.
 	buffer, err := store.ReadObjectAsBuffer(startHash)
 	if err != nil {
+		if damaged {
+			return nil
+		}
 		return fmt.Errorf("failed to read object %s for marking: %w", startHash, err)
 	}
 
@@ -35,8 +65,17 @@ func markReachableObjects(store *lib.ObjectStore, startHash string, liveHashes *
 	var tree types.Tree
 	if err := json.Unmarshal(buffer, &tree); err == nil && len(tree.Entries) > 0 {
 		for _, entry := range tree.Entries {
-			if err := markReachableObjects(store, entry.Hash, liveHashes); err != nil {
-				return err
+			if entry.Hash != "" { // A special file has no object.
+				if err := markReachableObjects(store, entry.Hash, liveHashes, damaged); err != nil {
+					return err
+				}
+			}
+			// The manifests of a file's alternate data streams live as long
+			// as the file does.
+			for _, stream := range entry.Streams {
+				if err := markReachableObjects(store, stream.Hash, liveHashes, damaged); err != nil {
+					return err
+				}
 			}
 		}
 		return nil
@@ -63,17 +102,61 @@ func Prune(directory string, options PruneOptions) error {
 		return fmt.Errorf("could not resolve path: %w", err)
 	}
 
-	fmt.Printf("🧹 Starting prune for \"%s\", removing snaps older than %s...\n", absSourceDir, options.SnapIdentifier)
-	store := lib.NewObjectStore(absSourceDir)
+	switch {
+	case options.Undo:
+		return undoPrune(absSourceDir, options.RepositoryOptions)
+	case options.SnapIdentifier == "" && options.KeepLast > 0:
+		fmt.Printf("🧹 Starting prune for \"%s\", keeping the last %d snap(s)...\n", absSourceDir, options.KeepLast)
+	case options.SnapIdentifier == "" && !options.Before.IsZero():
+		fmt.Printf("🧹 Starting prune for \"%s\", removing snaps taken before %s...\n", absSourceDir, options.Before.Format("2006-01-02 15:04:05"))
+	case options.SnapIdentifier == "":
+		return fmt.Errorf("prune needs a snapshot to keep from, a number of snapshots to keep, or a time to remove snapshots from before")
+	default:
+		fmt.Printf("🧹 Starting prune for \"%s\", removing snaps older than %s...\n", absSourceDir, options.SnapIdentifier)
+	}
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if !options.DryRun {
+		if err := purgeExpiredTrash(store); err != nil {
+			return err
+		}
+	}
 
 	// 1. Identify Snaps to Keep and Prune
-	allSnaps, err := lib.GetSortedSnaps(absSourceDir)
+	allSnaps, err := store.GetSortedSnaps()
 	if err != nil {
 		return fmt.Errorf("could not get snapshots: %w", err)
 	}
 
 	// Find the snapshot to prune from.
-	snapToKeepFrom, err := lib.FindSnap(absSourceDir, options.SnapIdentifier)
+	if options.SnapIdentifier == "" && options.KeepLast > 0 {
+		if len(allSnaps) <= options.KeepLast {
+			fmt.Println("No snapshots beyond the ones to keep to prune.")
+			return nil
+		}
+		options.SnapIdentifier = allSnaps[len(allSnaps)-options.KeepLast].Hash
+	} else if options.SnapIdentifier == "" {
+		if len(allSnaps) == 0 {
+			fmt.Println("No snapshots to prune.")
+			return nil
+		}
+		keepFrom := len(allSnaps) - 1
+		for i, s := range allSnaps {
+			if !s.Timestamp.Before(options.Before) {
+				keepFrom = i
+				break
+			}
+		}
+		if latest := allSnaps[keepFrom]; latest.Timestamp.Before(options.Before) {
+			fmt.Printf("   - Keeping snap %d, the most recent, though it was taken before then.\n", latest.ID)
+		}
+		options.SnapIdentifier = allSnaps[keepFrom].Hash
+	}
+	snapToKeepFrom, err := store.FindSnap(options.SnapIdentifier)
 	if err != nil {
 		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
 	}
@@ -98,20 +181,81 @@ func Prune(directory string, options PruneOptions) error {
 		return nil
 	}
 
+	rewrite := rewriteNone
+	if options.Repack {
+		rewrite = rewriteAll
+	}
+	plan, err := planRemoval(store, snapsToKeep, snapsToPrune, rewrite)
+	if err != nil {
+		return err
+	}
+	if options.DryRun {
+		fmt.Println("🔍 Pruning would make these changes:")
+		plan.print()
+		return nil
+	}
+	var trash *lib.Trash
+	if options.KeepBackup > 0 {
+		if trash, err = store.StartTrash(time.Now().Add(options.KeepBackup)); err != nil {
+			return err
+		}
+	}
+	stats, err := plan.apply(store)
+	if err != nil {
+		return err
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Prune complete!")
+	fmt.Printf("   - Deleted %d old snap(s).\n", stats.snaps)
+	stats.print()
+	if trash != nil {
+		fmt.Printf("   - Kept what was deleted until %s; run 'btool prune --undo' to restore it before then.\n", trash.Expires.Format("2006-01-02 15:04:05"))
+	}
 
+	return nil
+}
 
-	// 2. Mark Phase
+// Which of the packs that hold both live and dead objects a gcPlan rewrites
+// with only the live ones. The others are kept whole.
+const (
+	// rewriteNone rewrites none of them.
+	rewriteNone = iota
+	// rewriteRemoved rewrites those that hold objects only the removed
+	// snaps reached.
+	rewriteRemoved
+	// rewriteAll rewrites all of them.
+	rewriteAll
+)
+
+// gcPlan is what removing some snaps of a repository takes: the snaps
+// themselves, the packs that hold nothing the other snaps need, and the packs
+// to rewrite without the objects they do not need.
+type gcPlan struct {
+	snapsToRemove []lib.SnapDetail
+	deletePacks   []lib.BackendEntry
+	// rewritePacks holds the live objects of each pack to rewrite, which
+	// are all that its new pack keeps.
+	rewritePacks map[string]map[string]types.PackIndexEntry
+	packSizes    map[string]int64
+}
+
+// planRemoval finds what removing the snaps of snapsToRemove takes, without
+// changing anything. The objects the snaps of snapsToKeep reach are live,
+// and a pack is kept as long as it holds any of them, rewritten as rewrite
+// says if it also holds dead ones.
+func planRemoval(store *lib.ObjectStore, snapsToKeep, snapsToRemove []lib.SnapDetail, rewrite int) (*gcPlan, error) {
+	// 1. Mark Phase
 	fmt.Println("   - Marking live objects from snapshots to keep...")
 	var liveHashes sync.Map // A thread-safe map
 	var wg sync.WaitGroup
 	errs := make(chan error, len(snapsToKeep))
 
 	for _, snap := range snapsToKeep {
-	
 		wg.Add(1)
 		go func(s lib.SnapDetail) {
 			defer wg.Done()
-			if err := markReachableObjects(store, s.RootTreeHash, &liveHashes); err != nil {
+			if err := markReachableObjects(store, s.RootTreeHash, &liveHashes, s.Damaged); err != nil {
 				errs <- err
 			}
 		}(snap)
@@ -121,36 +265,31 @@ func Prune(directory string, options PruneOptions) error {
 	close(errs)
 	for err := range errs {
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-
-
-	// 3. Sweep Phase: Rebuild the index and copy necessary packfiles.
-	fmt.Println("   - Sweeping old objects and rebuilding index...")
-	btoolDir := lib.GetBtoolDir(absSourceDir)
-	tmpPacksDir := filepath.Join(btoolDir, "packs.tmp")
-	_ = os.RemoveAll(tmpPacksDir) // Clean up from previous failed runs
-	if err := os.MkdirAll(tmpPacksDir, 0755); err != nil {
-		return err
-	}
+	// 2. Sweep Phase: Find the packs that hold live objects.
+	fmt.Println("   - Sweeping packs without live objects...")
 
 	// Get the current index to find where live objects are stored.
 	currentIndex, err := store.GetIndex()
 	if err != nil {
-		return fmt.Errorf("failed to get current index for sweep: %w", err)
+		return nil, fmt.Errorf("failed to get current index for sweep: %w", err)
 	}
 
-	newIndex := make(types.PackIndex)
 	packsToKeep := make(map[string]bool)
+	// Snaps marked as damaged are known to miss objects.
+	damagedKept := false
+	for _, snap := range snapsToKeep {
+		damagedKept = damagedKept || snap.Damaged
+	}
 
 	liveHashes.Range(func(key, value interface{}) bool {
 		hash := key.(string)
 		if entry, exists := currentIndex[hash]; exists {
-			newIndex[hash] = entry
 			packsToKeep[entry.PackHash] = true
-		} else {
+		} else if !damagedKept {
 			// This case should ideally not happen in a consistent repository.
 			// It means a live hash was not found in the index.
 			fmt.Fprintf(os.Stderr, "Warning: Live object %s not found in the index during prune.\n", hash)
@@ -158,60 +297,328 @@ func Prune(directory string, options PruneOptions) error {
 		return true
 	})
 
-	// Copy the required packfiles to the temporary directory.
-	packsDir := lib.GetPacksDir(absSourceDir)
-	for packHash := range packsToKeep {
-		originalPath := filepath.Join(packsDir, packHash)
-		newPath := filepath.Join(tmpPacksDir, packHash)
-		if err := lib.CopyFile(originalPath, newPath); err != nil {
-			return fmt.Errorf("failed to copy packfile %s: %w", packHash, err)
+	plan := &gcPlan{snapsToRemove: snapsToRemove, packSizes: make(map[string]int64)}
+	switch rewrite {
+	case rewriteRemoved:
+		if plan.rewritePacks, err = findPacksToPurge(store, snapsToRemove, currentIndex, &liveHashes); err != nil {
+			return nil, err
 		}
+	case rewriteAll:
+		plan.rewritePacks = findPartlyLivePacks(currentIndex, &liveHashes)
 	}
-
-	// 4. Finalization Phase: Write the new index and atomically swap directories.
-	fmt.Println("   - Finalizing changes...")
-	tmpIndexPath := filepath.Join(btoolDir, "index.tmp.json")
-	newIndexJSON, err := json.MarshalIndent(newIndex, "", "  ")
+	packs, err := store.ListPacks()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to list packfiles: %w", err)
 	}
-	if err := os.WriteFile(tmpIndexPath, newIndexJSON, 0644); err != nil {
-		return err
+	for _, pack := range packs {
+		plan.packSizes[pack.Name] = pack.Size
+		if !packsToKeep[pack.Name] {
+			plan.deletePacks = append(plan.deletePacks, pack)
+		}
+	}
+	return plan, nil
+}
+
+// findPacksToPurge finds each pack that holds objects only the removed snaps
+// reached beside live ones, and the live objects it holds. Packs without
+// live objects are left for the sweep to delete.
+func findPacksToPurge(store *lib.ObjectStore, removed []lib.SnapDetail, index types.PackIndex, liveHashes *sync.Map) (map[string]map[string]types.PackIndexEntry, error) {
+	var removedHashes sync.Map
+	for _, snap := range removed {
+		if err := markReachableObjects(store, snap.RootTreeHash, &removedHashes, true); err != nil {
+			return nil, err
+		}
+	}
+
+	// The live objects of each pack that holds dead ones of the removed
+	// snaps.
+	keep := make(map[string]map[string]types.PackIndexEntry)
+	removedHashes.Range(func(key, value interface{}) bool {
+		hash := key.(string)
+		if _, live := liveHashes.Load(hash); live {
+			return true
+		}
+		if entry, exists := index[hash]; exists {
+			keep[entry.PackHash] = make(map[string]types.PackIndexEntry)
+		}
+		return true
+	})
+	for hash, entry := range index {
+		if objects, ok := keep[entry.PackHash]; ok {
+			if _, live := liveHashes.Load(hash); live {
+				objects[hash] = entry
+			}
+		}
+	}
+	for packHash, objects := range keep {
+		if len(objects) == 0 {
+			delete(keep, packHash)
+		}
+	}
+	return keep, nil
+}
+
+// findPartlyLivePacks finds each pack that holds both live and dead
+// objects, and the live objects it holds.
+func findPartlyLivePacks(index types.PackIndex, liveHashes *sync.Map) map[string]map[string]types.PackIndexEntry {
+	live := make(map[string]map[string]types.PackIndexEntry)
+	dead := make(map[string]bool)
+	for hash, entry := range index {
+		if _, isLive := liveHashes.Load(hash); !isLive {
+			dead[entry.PackHash] = true
+			continue
+		}
+		if live[entry.PackHash] == nil {
+			live[entry.PackHash] = make(map[string]types.PackIndexEntry)
+		}
+		live[entry.PackHash][hash] = entry
+	}
+	for packHash := range live {
+		if !dead[packHash] {
+			delete(live, packHash)
+		}
+	}
+	return live
+}
+
+// reclaimable returns how many bytes carrying out the plan would free: all
+// of the packs it deletes, and what the packs it rewrites hold beyond their
+// live objects.
+func (p *gcPlan) reclaimable() int64 {
+	var total int64
+	for _, pack := range p.deletePacks {
+		total += pack.Size
 	}
+	for packHash, objects := range p.rewritePacks {
+		kept := int64(0)
+		for _, entry := range objects {
+			kept += entry.Length
+		}
+		if size := p.packSizes[packHash]; size > kept {
+			total += size - kept
+		}
+	}
+	return total
+}
 
-	indexPath := lib.GetIndexPath(absSourceDir)
-	bakPacksDir := packsDir + ".bak"
-	bakIndexPath := indexPath + ".bak"
+// print lists what carrying out the plan would remove, for a dry run.
+func (p *gcPlan) print() {
+	for _, snap := range p.snapsToRemove {
+		fmt.Printf("   - Would delete snap %d (%s), taken %s\n", snap.ID, snap.Hash[:7], snap.Timestamp.Format("2006-01-02 15:04:05"))
+	}
+	for _, pack := range p.deletePacks {
+		fmt.Printf("   - Would drop pack %s (%s)\n", shortHash(pack.Name), formatBytes(pack.Size, 2))
+	}
+	packHashes := make([]string, 0, len(p.rewritePacks))
+	for packHash := range p.rewritePacks {
+		packHashes = append(packHashes, packHash)
+	}
+	sort.Strings(packHashes)
+	for _, packHash := range packHashes {
+		fmt.Printf("   - Would rewrite pack %s (%s), keeping %d live object(s)\n", shortHash(packHash), formatBytes(p.packSizes[packHash], 2), len(p.rewritePacks[packHash]))
+	}
+	fmt.Printf("\n%d snap(s), %d pack(s) dropped, %d rewritten; %s would be reclaimed.\n",
+		len(p.snapsToRemove), len(p.deletePacks), len(p.rewritePacks), formatBytes(p.reclaimable(), 2))
+}
 
-	_ = os.RemoveAll(bakPacksDir) // Remove old backup if it exists
-	_ = os.Remove(bakIndexPath)
+// gcStats counts what carrying out a gcPlan freed, as measured in the
+// repository before and after.
+type gcStats struct {
+	snaps, objects               int
+	packsDeleted, packsRewritten int
+	reclaimed, remaining         int64 // Bytes of packs.
+}
 
-	if err := os.Rename(packsDir, bakPacksDir); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to backup old packs directory: %w", err)
+// print prints the statistics below the summary line of a command.
+func (s gcStats) print() {
+	fmt.Printf("   - Freed %d object(s): deleted %d pack(s) and rewrote %d.\n", s.objects, s.packsDeleted, s.packsRewritten)
+	fmt.Printf("   - Reclaimed %s; the repository now stores %s.\n", formatBytes(s.reclaimed, 2), formatBytes(s.remaining, 2))
+}
+
+// apply carries out the plan. The order of its steps keeps the repository
+// consistent if it is interrupted. Snap manifests go first, so no remaining
+// snap can refer to objects of a deleted pack. A rewritten pack is written
+// with its index shard before the old one is deleted, and each pack's index
+// shard is deleted before the pack itself, so the index never refers to a
+// missing pack. An interruption at any point leaves at worst some
+// unreferenced data behind.
+func (p *gcPlan) apply(store *lib.ObjectStore) (gcStats, error) {
+	stats := gcStats{snaps: len(p.snapsToRemove)}
+	sizeBefore, err := getStoredObjectsSize(store)
+	if err != nil {
+		return stats, fmt.Errorf("failed to measure the repository: %w", err)
 	}
-	if err := os.Rename(indexPath, bakIndexPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to backup old index file: %w", err)
+	indexBefore, err := store.GetIndex()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get current index: %w", err)
 	}
 
-	if err := os.Rename(tmpPacksDir, packsDir); err != nil {
-		return fmt.Errorf("failed to activate new packs directory: %w", err)
+	fmt.Println("   - Finalizing changes...")
+	for _, snap := range p.snapsToRemove {
+		if err := store.DeleteSnap(snap.Hash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return stats, fmt.Errorf("failed to delete snap %s: %w", snap.Hash, err)
+		}
+	}
+
+	packHashes := make([]string, 0, len(p.rewritePacks))
+	for packHash := range p.rewritePacks {
+		packHashes = append(packHashes, packHash)
 	}
-	if err := os.Rename(tmpIndexPath, indexPath); err != nil {
-		return fmt.Errorf("failed to activate new index file: %w", err)
+	sort.Strings(packHashes)
+	for _, packHash := range packHashes {
+		newPack, err := store.SalvagePack(packHash, p.rewritePacks[packHash])
+		if err != nil {
+			return stats, fmt.Errorf("failed to rewrite pack %s: %w", packHash, err)
+		}
+		fmt.Printf("   - Rewrote pack %s as pack %s, keeping %d live object(s)\n", shortHash(packHash), shortHash(newPack), len(p.rewritePacks[packHash]))
+		stats.packsRewritten++
 	}
 
-	_ = os.RemoveAll(bakPacksDir)
-	_ = os.Remove(bakIndexPath)
+	for _, pack := range p.deletePacks {
+		// Note: we only warn here, as a leftover packfile wastes space but is harmless.
+		if err := store.DeletePack(pack.Name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			fmt.Fprintf(os.Stderr, "Warning: could not delete packfile %s: %v\n", pack.Name, err)
+			continue
+		}
+		stats.packsDeleted++
+	}
 
-	// 5. Cleanup old snapshot manifests
-	snapsDir := lib.GetSnapsDir(absSourceDir)
-	for _, snap := range snapsToPrune {
-		// Note: we ignore errors here, as a failure to delete a snap manifest is not critical.
-		_ = os.Remove(filepath.Join(snapsDir, snap.Hash+".json"))
+	if stats.remaining, err = getStoredObjectsSize(store); err != nil {
+		return stats, fmt.Errorf("failed to measure the repository: %w", err)
+	}
+	stats.reclaimed = sizeBefore - stats.remaining
+	indexAfter, err := store.GetIndex()
+	if err != nil {
+		return stats, fmt.Errorf("failed to get current index: %w", err)
 	}
+	stats.objects = len(indexBefore) - len(indexAfter)
+	return stats, nil
+}
 
-	fmt.Println("✅ Prune complete!")
-	fmt.Printf("   - Deleted %d old snap(s).\n", len(snapsToPrune))
+// enforceMaxSize removes the oldest snaps, and the data that only they
+// needed, until the packs of the repository take up no more than maxSize
+// bytes, always keeping the latest snap. Packs holding dead objects are
+// rewritten without them, so that as few snaps as possible go. If even the
+// latest snap alone takes up too much, it fails without removing anything.
+func enforceMaxSize(store *lib.ObjectStore, maxSize int64) error {
+	size, err := getStoredObjectsSize(store)
+	if err != nil {
+		return fmt.Errorf("failed to measure the repository: %w", err)
+	}
+	if maxSize <= 0 || size <= maxSize {
+		return nil
+	}
+	if !canReadAllSnaps(store) {
+		fmt.Printf("🧹 The repository stores %s, over its max size of %s; the next snap made with its password or age identity removes the oldest snaps to make room.\n",
+			formatBytes(size, 2), formatBytes(maxSize, 2))
+		return nil
+	}
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	if len(snaps) == 0 {
+		return fmt.Errorf("the repository stores %s, over its max size of %s, without any snaps to remove", formatBytes(size, 2), formatBytes(maxSize, 2))
+	}
+	fmt.Printf("🧹 The repository stores %s, over its max size of %s; removing the oldest snaps...\n", formatBytes(size, 2), formatBytes(maxSize, 2))
+
+	// Removing more snaps never frees less, so the fewest to remove are
+	// found by bisection, from none, which only repacks, to all but the
+	// latest.
+	plans := make(map[int]*gcPlan)
+	planFor := func(remove int) (*gcPlan, error) {
+		if plans[remove] == nil {
+			plan, err := planRemoval(store, snaps[remove:], snaps[:remove], rewriteAll)
+			if err != nil {
+				return nil, err
+			}
+			plans[remove] = plan
+		}
+		return plans[remove], nil
+	}
+	fits := func(plan *gcPlan) bool {
+		return size-plan.reclaimable() <= maxSize
+	}
 
+	hi := len(snaps) - 1
+	plan, err := planFor(hi)
+	if err != nil {
+		return err
+	}
+	if !fits(plan) {
+		return fmt.Errorf("the repository stores %s, over its max size of %s, and would still store %s with only snap %d kept; "+
+			"nothing was removed, so raise the limit with --max-size", formatBytes(size, 2), formatBytes(maxSize, 2),
+			formatBytes(size-plan.reclaimable(), 2), snaps[hi].ID)
+	}
+	lo := 0
+	for lo < hi {
+		mid := (lo + hi) / 2
+		plan, err := planFor(mid)
+		if err != nil {
+			return err
+		}
+		if fits(plan) {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+
+	stats, err := plans[hi].apply(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   - Removed the %d oldest snap(s) to stay under the max size.\n", stats.snaps)
+	stats.print()
 	return nil
 }
+
+// canReadAllSnaps reports whether the store can read every snap of its
+// repository, which keeping to a max size needs. A write-only backup reads
+// none of the others, so it would take all of their data for garbage.
+func canReadAllSnaps(store *lib.ObjectStore) bool {
+	key := store.Key()
+	return key == nil || key.CanRead()
+}
+
+// checkMaxSize makes sure, before a snap whose root tree is rootTreeHash is
+// recorded, that the repository can hold it within its max size once every
+// older snap is removed. If it cannot, the packs the snap wrote are dropped
+// again, leaving the repository as it was, and it fails.
+func checkMaxSize(store *lib.ObjectStore, rootTreeHash string) error {
+	defaults, err := store.LoadDefaults()
+	if err != nil {
+		return fmt.Errorf("failed to read the repository defaults: %w", err)
+	}
+	size, err := getStoredObjectsSize(store)
+	if err != nil {
+		return fmt.Errorf("failed to measure the repository: %w", err)
+	}
+	// A store that cannot read every snap leaves the limit to enforceMaxSize,
+	// which reports it.
+	if defaults.MaxSize <= 0 || size <= defaults.MaxSize || !canReadAllSnaps(store) {
+		return nil
+	}
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	plan, err := planRemoval(store, []lib.SnapDetail{{RootTreeHash: rootTreeHash}}, snaps, rewriteAll)
+	if err != nil {
+		return err
+	}
+	needed := size - plan.reclaimable()
+	if needed <= defaults.MaxSize {
+		return nil
+	}
+
+	// Only the packs the snap wrote are dropped, since another snap may be
+	// writing packs that it has not recorded yet.
+	for _, packHash := range store.WrittenPacks() {
+		if err := store.DeletePack(packHash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to drop the data of the snap: %w", err)
+		}
+	}
+	return fmt.Errorf("the snap would need %s, over the max size of %s, even with every older snap removed; "+
+		"no snap was taken, so raise the limit with --max-size", formatBytes(needed, 2), formatBytes(defaults.MaxSize, 2))
+}
@@ -6,9 +6,11 @@ import (
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,16 +18,8 @@ import (
 // getIndexObjectCount is a test helper to read the index and count the objects.
 func getIndexObjectCount(t *testing.T, baseDir string) int {
 	lib.ResetObjectStoreState() // Ensure we read from disk, not cache.
-	indexPath := lib.GetIndexPath(baseDir)
-	content, err := os.ReadFile(indexPath)
-	if os.IsNotExist(err) {
-		return 0
-	}
-	require.NoError(t, err, "Failed to read index file")
-
-	var index map[string]interface{}
-	err = json.Unmarshal(content, &index)
-	require.NoError(t, err, "Failed to parse index json")
+	index, err := lib.NewLocalObjectStore(baseDir).GetIndex()
+	require.NoError(t, err, "Failed to read index")
 	return len(index)
 }
 
@@ -39,14 +33,54 @@ func setupSnapshots(t *testing.T, testDir string, numSnaps int) []lib.SnapDetail
 
 		content := "version " + strconv.Itoa(i)
 		require.NoError(t, os.WriteFile(filePath, []byte(content), 0644))
-		require.NoError(t, commands.Snap(testDir, "snap "+strconv.Itoa(i)))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{Message: "snap " + strconv.Itoa(i)}))
 	}
-	snaps, err := lib.GetSortedSnaps(testDir)
+	snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
 	require.NoError(t, err)
 	require.Len(t, snaps, numSnaps, "should have created the correct number of snapshots")
 	return snaps
 }
 
+// writeTestManifest writes content to the store as a single chunk with its
+// manifest, returning the manifest's hash.
+func writeTestManifest(t *testing.T, store *lib.ObjectStore, content string) string {
+	chunkHash, err := store.WriteObject([]byte(content))
+	require.NoError(t, err)
+	manifestJSON, err := json.Marshal(types.FileManifest{Chunks: []types.ChunkRef{{Hash: chunkHash, Size: int64(len(content))}}, TotalSize: int64(len(content))})
+	require.NoError(t, err)
+	manifestHash, err := store.WriteMetadataObject(manifestJSON)
+	require.NoError(t, err)
+	return manifestHash
+}
+
+// addStreamSnap adds a snap holding a file with an alternate data stream,
+// as snaps taken with --streams on Windows do, returning the hash of the
+// stream's manifest.
+func addStreamSnap(t *testing.T, testDir, streamContent string) string {
+	lib.ResetObjectStoreState()
+	store := lib.NewLocalObjectStore(testDir)
+	streamHash := writeTestManifest(t, store, streamContent)
+	treeJSON, err := json.Marshal(types.Tree{Entries: []types.TreeEntry{{
+		Name:    "file.txt",
+		Hash:    writeTestManifest(t, store, "file with a stream"),
+		Type:    "blob",
+		Mode:    0644,
+		Streams: []types.Stream{{Name: "Zone.Identifier", Hash: streamHash}},
+	}}})
+	require.NoError(t, err)
+	rootTreeHash, err := store.WriteMetadataObject(treeJSON)
+	require.NoError(t, err)
+	_, err = store.Commit()
+	require.NoError(t, err)
+	id, err := store.GetNextSnapID()
+	require.NoError(t, err)
+	_, err = store.WriteSnap(types.Snap{ID: id, Timestamp: time.Now().UTC().Format(time.RFC3339), RootTreeHash: rootTreeHash})
+	require.NoError(t, err)
+	require.NoError(t, store.IncrementNextSnapID())
+	lib.ResetObjectStoreState()
+	return streamHash
+}
+
 func TestPruneCommand(t *testing.T) {
 	t.Run("should prune snapshots older than the one specified by ID", func(t *testing.T) {
 		// Arrange
@@ -67,7 +101,7 @@ func TestPruneCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Assert
-		remainingSnaps, err := lib.GetSortedSnaps(testDir)
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
 		require.NoError(t, err)
 		t.Logf("Snaps remaining after prune:")
 		for i, s := range remainingSnaps {
@@ -84,7 +118,7 @@ func TestPruneCommand(t *testing.T) {
 		// This is the critical test. Snap 3's objects might have been shared with pruned snaps 1 and 2,
 		// so we must ensure its content is still correct after GC.
 		restoreDir := t.TempDir()
-		err = commands.Restore(testDir, remainingSnaps[0].Hash[:12], restoreDir)
+		err = commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: remainingSnaps[0].Hash[:12], OutputDir: restoreDir})
 		require.NoError(t, err, "should be able to restore oldest remaining snap")
 
 		restoredContent, err := os.ReadFile(filepath.Join(restoreDir, "file.txt"))
@@ -106,7 +140,7 @@ func TestPruneCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Assert
-		remainingSnaps, err := lib.GetSortedSnaps(testDir)
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
 		require.NoError(t, err)
 		assert.Len(t, remainingSnaps, 2)
 		assert.Equal(t, snapToPruneFrom.Hash, remainingSnaps[0].Hash)
@@ -114,7 +148,7 @@ func TestPruneCommand(t *testing.T) {
 		// Golden Test: Restore the OLDEST remaining snapshot to verify GC didn't corrupt it.
 		restoreDir := t.TempDir()
 		// After pruning, snaps 3 and 4 are left. remainingSnaps[0] is original snap 3.
-		err = commands.Restore(testDir, remainingSnaps[0].Hash[:12], restoreDir)
+		err = commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: remainingSnaps[0].Hash[:12], OutputDir: restoreDir})
 		require.NoError(t, err)
 		restoredContent, err := os.ReadFile(filepath.Join(restoreDir, "file.txt"))
 		require.NoError(t, err)
@@ -136,12 +170,236 @@ func TestPruneCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Assert
-		remainingSnaps, err := lib.GetSortedSnaps(testDir)
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
 		require.NoError(t, err)
 		assert.Len(t, remainingSnaps, 3, "Should not prune any snapshots")
 		assert.Equal(t, initialObjectCount, getIndexObjectCount(t, testDir), "Object count should not change")
 	})
 
+	t.Run("should keep the given number of most recent snapshots", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		allSnaps := setupSnapshots(t, testDir, 4)
+
+		// Act
+		err := commands.Prune(testDir, commands.PruneOptions{KeepLast: 3})
+		require.NoError(t, err)
+
+		// Assert
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, remainingSnaps, 3)
+		assert.Equal(t, allSnaps[1].Hash, remainingSnaps[0].Hash)
+	})
+
+	t.Run("should do nothing if no more snapshots than to keep exist", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 2)
+
+		// Act
+		err := commands.Prune(testDir, commands.PruneOptions{KeepLast: 5})
+
+		// Assert
+		require.NoError(t, err)
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, remainingSnaps, 2)
+	})
+
+	t.Run("should prune snapshots taken before a time but keep the most recent", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		allSnaps := setupSnapshots(t, testDir, 3)
+
+		// Act: every snap was taken before an hour from now.
+		err := commands.Prune(testDir, commands.PruneOptions{Before: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+
+		// Assert
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, remainingSnaps, 1)
+		assert.Equal(t, allSnaps[2].Hash, remainingSnaps[0].Hash)
+	})
+
+	t.Run("should prune nothing when every snapshot was taken since the time", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 3)
+
+		// Act
+		err := commands.Prune(testDir, commands.PruneOptions{Before: time.Now().Add(-time.Hour)})
+
+		// Assert
+		require.NoError(t, err)
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, remainingSnaps, 3)
+	})
+
+	t.Run("should report the objects, packs, and space it freed", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 3)
+		initialObjectCount := getIndexObjectCount(t, testDir)
+
+		// Act
+		var pruneErr error
+		output := captureStdout(t, func() {
+			pruneErr = commands.Prune(testDir, commands.PruneOptions{KeepLast: 1})
+		})
+
+		// Assert
+		require.NoError(t, pruneErr)
+		freed := initialObjectCount - getIndexObjectCount(t, testDir)
+		require.Greater(t, freed, 0)
+		assert.Contains(t, output, "Deleted 2 old snap(s).")
+		assert.Contains(t, output, "Freed "+strconv.Itoa(freed)+" object(s): deleted 2 pack(s) and rewrote 0.")
+		assert.Contains(t, output, "Reclaimed ")
+		assert.Contains(t, output, "the repository now stores ")
+	})
+
+	t.Run("should rewrite partly live packs with repack", func(t *testing.T) {
+		// Arrange
+		sourceDir, removedHash := setupRepackTest(t)
+
+		// Act
+		var pruneErr error
+		output := captureStdout(t, func() {
+			pruneErr = commands.Prune(sourceDir, commands.PruneOptions{KeepLast: 1, Repack: true})
+		})
+
+		// Assert
+		require.NoError(t, pruneErr)
+		assert.Contains(t, output, "rewrote 1.")
+		assert.NotContains(t, indexOf(t, sourceDir), removedHash)
+	})
+
+	t.Run("should keep the alternate data streams of the files it keeps", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 2)
+		streamHash := addStreamSnap(t, testDir, "[ZoneTransfer]\nZoneId=3\n")
+
+		// Act
+		err := commands.Prune(testDir, commands.PruneOptions{KeepLast: 1, Repack: true})
+
+		// Assert
+		require.NoError(t, err)
+		lib.ResetObjectStoreState()
+		store := lib.NewLocalObjectStore(testDir)
+		var manifest types.FileManifest
+		require.NoError(t, store.ReadObjectAsJSON(streamHash, &manifest), "the stream's manifest should survive the prune")
+		var stream []byte
+		for _, chunk := range manifest.Chunks {
+			data, err := store.ReadObjectAsBuffer(chunk.Hash)
+			require.NoError(t, err)
+			stream = append(stream, data...)
+		}
+		assert.Equal(t, "[ZoneTransfer]\nZoneId=3\n", string(stream))
+		require.NoError(t, commands.Check(testDir, commands.CheckOptions{}))
+	})
+
+	t.Run("should list what it would prune without changing anything on a dry run", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 3)
+		packs, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		initialObjectCount := getIndexObjectCount(t, testDir)
+
+		// Act
+		var pruneErr error
+		output := captureStdout(t, func() {
+			pruneErr = commands.Prune(testDir, commands.PruneOptions{KeepLast: 1, DryRun: true})
+		})
+
+		// Assert
+		require.NoError(t, pruneErr)
+		assert.Contains(t, output, "Would delete snap 1")
+		assert.Contains(t, output, "Would delete snap 2")
+		assert.Contains(t, output, "Would drop pack")
+		assert.Contains(t, output, "2 snap(s), 2 pack(s) dropped, 0 rewritten;")
+		assert.Contains(t, output, "would be reclaimed")
+		lib.ResetObjectStoreState()
+		remainingSnaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, remainingSnaps, 3)
+		remainingPacks, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		assert.Len(t, remainingPacks, len(packs))
+		assert.Equal(t, initialObjectCount, getIndexObjectCount(t, testDir))
+	})
+
+	t.Run("should undo a prune run with a backup kept", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 3)
+		initialObjectCount := getIndexObjectCount(t, testDir)
+		require.NoError(t, commands.Prune(testDir, commands.PruneOptions{KeepLast: 1, KeepBackup: 24 * time.Hour}))
+		require.Less(t, getIndexObjectCount(t, testDir), initialObjectCount)
+
+		// Act
+		var undoErr error
+		output := captureStdout(t, func() {
+			undoErr = commands.Prune(testDir, commands.PruneOptions{Undo: true})
+		})
+
+		// Assert
+		require.NoError(t, undoErr)
+		assert.Contains(t, output, "Restored 2 snap(s) and 2 pack(s).")
+		lib.ResetObjectStoreState()
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, snaps, 3)
+		assert.Equal(t, initialObjectCount, getIndexObjectCount(t, testDir))
+		trashes, err := lib.NewLocalObjectStore(testDir).ListTrash()
+		require.NoError(t, err)
+		assert.Empty(t, trashes)
+	})
+
+	t.Run("should purge backups whose time is over on the next prune", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		setupSnapshots(t, testDir, 2)
+		require.NoError(t, commands.Prune(testDir, commands.PruneOptions{KeepLast: 1, KeepBackup: time.Nanosecond}))
+
+		// Act
+		var pruneErr error
+		output := captureStdout(t, func() {
+			pruneErr = commands.Prune(testDir, commands.PruneOptions{KeepLast: 1})
+		})
+
+		// Assert
+		require.NoError(t, pruneErr)
+		assert.Contains(t, output, "Purged what the prune of ")
+		trashes, err := lib.NewLocalObjectStore(testDir).ListTrash()
+		require.NoError(t, err)
+		assert.Empty(t, trashes)
+		err = commands.Prune(testDir, commands.PruneOptions{Undo: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "there is no prune to undo")
+	})
+
 	t.Run("should return an error for a non-existent snapshot identifier", func(t *testing.T) {
 		// Arrange
 		lib.ResetObjectStoreState()
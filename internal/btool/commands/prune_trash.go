@@ -0,0 +1,60 @@
+package commands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// purgeExpiredTrash deletes for good what earlier prunes kept in the trash
+// once the time they were kept for is over.
+func purgeExpiredTrash(store *lib.ObjectStore) error {
+	trashes, err := store.ListTrash()
+	if err != nil {
+		return fmt.Errorf("failed to list the trash: %w", err)
+	}
+	for _, trash := range trashes {
+		if time.Now().Before(trash.Expires) {
+			continue
+		}
+		if err := store.PurgeTrash(trash); err != nil {
+			return fmt.Errorf("failed to purge the trash of the prune of %s: %w", trash.Created.Format("2006-01-02 15:04:05"), err)
+		}
+		fmt.Printf("   - Purged what the prune of %s kept.\n", trash.Created.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// undoPrune restores the snaps and packs that the latest prune run with a
+// grace period deleted, as long as they have not been purged yet.
+func undoPrune(absSourceDir string, options RepositoryOptions) error {
+	store, err := openStore(options, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	trashes, err := store.ListTrash()
+	if err != nil {
+		return fmt.Errorf("failed to list the trash: %w", err)
+	}
+	if len(trashes) == 0 {
+		return fmt.Errorf("there is no prune to undo: only prunes run with --keep-backup can be undone, until their backup is purged")
+	}
+	trash := trashes[len(trashes)-1]
+	contents, err := store.TrashContents(trash)
+	if err != nil {
+		return fmt.Errorf("failed to list the trash: %w", err)
+	}
+
+	fmt.Printf("⏪ Undoing the prune of %s...\n", trash.Created.Format("2006-01-02 15:04:05"))
+	if err := store.RestoreTrash(trash); err != nil {
+		return fmt.Errorf("failed to undo the prune: %w", err)
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Undo complete!")
+	fmt.Printf("   - Restored %d snap(s) and %d pack(s).\n", len(contents[lib.SnapsDirName]), len(contents[lib.PacksDirName]))
+	return nil
+}
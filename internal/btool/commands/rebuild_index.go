@@ -0,0 +1,58 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// RebuildIndexOptions holds the configuration for the rebuild-index command.
+type RebuildIndexOptions struct {
+	RepositoryOptions
+}
+
+// RebuildIndex is the main function for the 'rebuild-index' command. It
+// writes the index anew from the index files that can still be read and the
+// packs themselves, so that a lost or corrupt index file no longer makes the
+// whole repository unusable.
+func RebuildIndex(directory string, options RebuildIndexOptions) error {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", directory, err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	fmt.Printf("🔧 Rebuilding the index of %s...\n", store.Backend().Location())
+	rebuild, err := store.RebuildIndex()
+	if err != nil {
+		return fmt.Errorf("failed to rebuild the index: %w", err)
+	}
+	for _, name := range rebuild.Dropped {
+		fmt.Printf("   - Dropped %s, which could not be read or only listed missing packs\n", name)
+	}
+	for _, packHash := range rebuild.Scanned {
+		fmt.Printf("   - Listed the objects of pack %s from the pack itself\n", shortHash(packHash))
+	}
+	lost := make([]string, 0, len(rebuild.Lost))
+	for packHash := range rebuild.Lost {
+		lost = append(lost, packHash)
+	}
+	sort.Strings(lost)
+	for _, packHash := range lost {
+		fmt.Printf("   ❌ Could not list the objects of pack %s: %v\n", shortHash(packHash), rebuild.Lost[packHash])
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Index rebuilt!")
+	fmt.Printf("   - %d object(s) in %d pack(s).\n", rebuild.Objects, rebuild.Packs)
+	if len(lost) > 0 {
+		// The packs are kept, as their objects may still be recovered.
+		return fmt.Errorf("the objects of %d pack(s) could not be listed; run 'btool check' to find the snaps that need them", len(lost))
+	}
+	return nil
+}
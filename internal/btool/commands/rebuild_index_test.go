@@ -0,0 +1,79 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildIndexCommand(t *testing.T) {
+	t.Run("should rewrite the index of an intact repository as it was", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupCheckTest(t)
+		before, err := lib.NewLocalObjectStore(sourceDir).GetIndex()
+		require.NoError(t, err)
+
+		// Act
+		var rebuildErr error
+		output := captureStdout(t, func() {
+			rebuildErr = commands.RebuildIndex(sourceDir, commands.RebuildIndexOptions{})
+		})
+
+		// Assert
+		require.NoError(t, rebuildErr)
+		assert.Contains(t, output, "Index rebuilt!")
+		assert.Contains(t, output, "in 2 pack(s)")
+		after, err := lib.NewLocalObjectStore(sourceDir).GetIndex()
+		require.NoError(t, err)
+		assert.Equal(t, before, after)
+	})
+
+	t.Run("should list the objects of a pack whose shard cannot be read from the pack", func(t *testing.T) {
+		// Arrange
+		sourceDir, packHash := setupCheckTest(t)
+		shardPath := filepath.Join(lib.GetIndexDir(sourceDir), packHash)
+		require.NoError(t, os.WriteFile(shardPath, []byte("garbage"), 0644))
+		_, err := lib.NewLocalObjectStore(sourceDir).GetIndex()
+		require.Error(t, err)
+
+		// Act
+		var rebuildErr error
+		output := captureStdout(t, func() {
+			rebuildErr = commands.RebuildIndex(sourceDir, commands.RebuildIndexOptions{})
+		})
+
+		// Assert
+		require.NoError(t, rebuildErr)
+		assert.Contains(t, output, "Dropped index/"+packHash)
+		assert.Contains(t, output, "from the pack itself")
+
+		restoreDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: restoreDir}))
+		content, err := os.ReadFile(filepath.Join(restoreDir, "fileC.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "only in the second snap", string(content))
+	})
+
+	t.Run("should keep a pack it cannot list and report it", func(t *testing.T) {
+		// Arrange: a pack of the old format, which only the index describes.
+		sourceDir, _ := setupCheckTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(lib.GetPacksDir(sourceDir), "0ld"), []byte("raw bytes"), 0644))
+
+		// Act
+		var rebuildErr error
+		output := captureStdout(t, func() {
+			rebuildErr = commands.RebuildIndex(sourceDir, commands.RebuildIndexOptions{})
+		})
+
+		// Assert
+		require.Error(t, rebuildErr)
+		assert.Contains(t, rebuildErr.Error(), "1 pack(s) could not be listed")
+		assert.Contains(t, output, "Could not list the objects of pack 0ld")
+		assert.FileExists(t, filepath.Join(lib.GetPacksDir(sourceDir), "0ld"))
+	})
+}
@@ -0,0 +1,61 @@
+package commands
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// RepackOptions holds the configuration for the repack command.
+type RepackOptions struct {
+	// DryRun lists the packs that repacking would drop and rewrite, and the
+	// space it would reclaim, without changing anything.
+	DryRun bool
+	RepositoryOptions
+}
+
+// Repack is the main function for the 'repack' command. It removes the
+// objects that no snap needs from the packs: a pack that holds only such
+// objects is deleted, and one that holds them beside objects a snap needs is
+// rewritten into a new pack with just the latter. Prune leaves such packs
+// whole, so their dead objects would otherwise take up space for good.
+func Repack(directory string, options RepackOptions) error {
+	absSourceDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	allSnaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+
+	fmt.Printf("📦 Repacking the repository of \"%s\"...\n", absSourceDir)
+	plan, err := planRemoval(store, allSnaps, nil, rewriteAll)
+	if err != nil {
+		return err
+	}
+	if len(plan.deletePacks) == 0 && len(plan.rewritePacks) == 0 {
+		fmt.Println("No packs hold dead objects; nothing to repack.")
+		return nil
+	}
+	if options.DryRun {
+		fmt.Println("🔍 Repacking would make these changes:")
+		plan.print()
+		return nil
+	}
+	stats, err := plan.apply(store)
+	if err != nil {
+		return err
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Repack complete!")
+	stats.print()
+	return nil
+}
@@ -0,0 +1,101 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRepackTest takes two snaps, the first holding a file that the second
+// no longer does beside one it keeps, so that the first snap's pack holds
+// both live and dead objects once the first snap is pruned. It returns the
+// hash of the removed file's contents.
+func setupRepackTest(t *testing.T) (string, string) {
+	t.Helper()
+	lib.ResetObjectStoreState()
+	lib.ResetIgnoreState()
+	sourceDir := t.TempDir()
+	removed := "only the first snap holds this"
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "kept.txt"), []byte("every snap holds this"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "removed.txt"), []byte(removed), 0644))
+	require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+	require.NoError(t, os.Remove(filepath.Join(sourceDir, "removed.txt")))
+	require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+	return sourceDir, lib.NewLocalObjectStore(sourceDir).Hasher().GetHash([]byte(removed))
+}
+
+func TestRepackCommand(t *testing.T) {
+	t.Run("should rewrite a pack that prune kept whole without its dead objects", func(t *testing.T) {
+		// Arrange
+		sourceDir, removedHash := setupRepackTest(t)
+		require.NoError(t, commands.Prune(sourceDir, commands.PruneOptions{KeepLast: 1}))
+		require.Contains(t, indexOf(t, sourceDir), removedHash, "prune should keep the partly live pack whole")
+
+		// Act
+		var repackErr error
+		output := captureStdout(t, func() {
+			repackErr = commands.Repack(sourceDir, commands.RepackOptions{})
+		})
+
+		// Assert
+		require.NoError(t, repackErr)
+		assert.Contains(t, output, "deleted 0 pack(s) and rewrote 1.")
+		assert.NotContains(t, indexOf(t, sourceDir), removedHash)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "latest", OutputDir: outputDir}))
+		content, err := os.ReadFile(filepath.Join(outputDir, "kept.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "every snap holds this", string(content))
+		require.NoError(t, commands.Check(sourceDir, commands.CheckOptions{}))
+	})
+
+	t.Run("should list what it would rewrite without changing anything on a dry run", func(t *testing.T) {
+		// Arrange
+		sourceDir, removedHash := setupRepackTest(t)
+		require.NoError(t, commands.Prune(sourceDir, commands.PruneOptions{KeepLast: 1}))
+
+		// Act
+		var repackErr error
+		output := captureStdout(t, func() {
+			repackErr = commands.Repack(sourceDir, commands.RepackOptions{DryRun: true})
+		})
+
+		// Assert
+		require.NoError(t, repackErr)
+		assert.Contains(t, output, "Would rewrite pack")
+		assert.Contains(t, indexOf(t, sourceDir), removedHash)
+	})
+
+	t.Run("should do nothing when every object is live", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupRepackTest(t)
+
+		// Act
+		var repackErr error
+		output := captureStdout(t, func() {
+			repackErr = commands.Repack(sourceDir, commands.RepackOptions{})
+		})
+
+		// Assert
+		require.NoError(t, repackErr)
+		assert.Contains(t, output, "nothing to repack")
+	})
+}
+
+// indexOf reads the index of the repository of sourceDir from disk.
+func indexOf(t *testing.T, sourceDir string) map[string]bool {
+	t.Helper()
+	lib.ResetObjectStoreState()
+	index, err := lib.NewLocalObjectStore(sourceDir).GetIndex()
+	require.NoError(t, err)
+	hashes := make(map[string]bool, len(index))
+	for hash := range index {
+		hashes[hash] = true
+	}
+	return hashes
+}
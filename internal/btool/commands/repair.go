@@ -0,0 +1,158 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// RepairOptions holds the configuration for the repair command.
+type RepairOptions struct {
+	RepositoryOptions
+}
+
+// salvagePacks copies the intact objects of every pack that the check found
+// missing, short, holding corrupt objects, or no longer hashing to its name
+// to a new pack, and deletes the old one with its index shard, so the index
+// only lists objects that can be read. It returns how many objects were kept
+// and how many dropped.
+func (c *repoChecker) salvagePacks() (int, int, error) {
+	broken := make(map[string]map[string]types.PackIndexEntry)
+	for _, lost := range []map[string]types.PackIndexEntry{c.dangling, c.corrupt} {
+		for _, entry := range lost {
+			broken[entry.PackHash] = make(map[string]types.PackIndexEntry)
+		}
+	}
+	for packHash := range c.mismatched {
+		broken[packHash] = make(map[string]types.PackIndexEntry)
+	}
+	packHashes := make([]string, 0, len(broken))
+	for packHash := range broken {
+		packHashes = append(packHashes, packHash)
+	}
+	sort.Strings(packHashes)
+
+	kept, dropped := 0, 0
+	for hash, entry := range c.index {
+		keep, isBroken := broken[entry.PackHash]
+		if !isBroken {
+			continue
+		}
+		if c.usable(hash) {
+			keep[hash] = entry
+		} else {
+			dropped++
+		}
+	}
+	for _, packHash := range packHashes {
+		keep := broken[packHash]
+		newPack, err := c.store.SalvagePack(packHash, keep)
+		if err != nil {
+			return kept, dropped, fmt.Errorf("failed to salvage pack %s: %w", packHash, err)
+		}
+		kept += len(keep)
+		if newPack == "" {
+			fmt.Printf("   - Dropped pack %s, which held nothing intact\n", shortHash(packHash))
+		} else {
+			fmt.Printf("   - Salvaged %d object(s) of pack %s into pack %s\n", len(keep), shortHash(packHash), shortHash(newPack))
+		}
+	}
+	return kept, dropped, nil
+}
+
+// markDamaged rewrites each snap the check found damaged with its Damaged
+// flag set. A snap's hash changes with its contents, so the snaps that name
+// a rewritten one as their parent are rewritten too. Each new snap is written
+// before the old one is deleted, so an interruption leaves at worst both. It
+// returns how many snaps were marked.
+func (c *repoChecker) markDamaged() (int, error) {
+	renamed := make(map[string]string)
+	marked := 0
+	for _, detail := range c.snaps {
+		newParent, parentRenamed := renamed[detail.Parent]
+		mark := c.damaged[detail.Hash] && !detail.Damaged
+		if !mark && !parentRenamed {
+			continue
+		}
+		snap, err := c.store.ReadSnap(detail.Hash)
+		if err != nil {
+			return marked, fmt.Errorf("failed to read snap %d: %w", detail.ID, err)
+		}
+		if parentRenamed {
+			snap.Parent = newParent
+		}
+		if mark {
+			snap.Damaged = true
+		}
+		newHash, err := c.store.WriteSnap(snap)
+		if err != nil {
+			return marked, fmt.Errorf("failed to write snap %d: %w", detail.ID, err)
+		}
+		if err := c.store.DeleteSnap(detail.Hash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return marked, fmt.Errorf("failed to delete snap %s: %w", detail.Hash, err)
+		}
+		renamed[detail.Hash] = newHash
+		if mark {
+			marked++
+			fmt.Printf("   - Marked snap %d as damaged\n", detail.ID)
+		}
+	}
+	return marked, nil
+}
+
+// Repair is the main function for the 'repair' command. It checks the
+// repository as check does, then drops the index entries of objects that are
+// missing or corrupt, moving the intact objects of the packs that held them
+// to new packs, and marks the snaps that needed what was lost as damaged, so
+// the rest of the repository can be used and pruned again.
+func Repair(directory string, options RepairOptions) error {
+	absDir, err := filepath.Abs(directory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", directory, err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	fmt.Printf("🔍 Checking %s...\n", store.Backend().Location())
+	c, err := newRepoChecker(store)
+	if err != nil {
+		return err
+	}
+	found, err := c.run(false, nil)
+	if err != nil {
+		return err
+	}
+	if found == 0 {
+		fmt.Println("✅ No problems found; nothing to repair.")
+		return nil
+	}
+	// A pack that cannot be read at all may only be out of reach for now,
+	// and dropping it would lose everything in it.
+	if len(c.unreadable) > 0 {
+		return fmt.Errorf("%d pack(s) could not be read; not repairing until they can", len(c.unreadable))
+	}
+
+	fmt.Println("🔧 Repairing...")
+	kept, dropped, err := c.salvagePacks()
+	if err != nil {
+		return err
+	}
+	marked, err := c.markDamaged()
+	if err != nil {
+		return err
+	}
+
+	reportDestinations(store)
+	fmt.Println("✅ Repair complete!")
+	fmt.Printf("   - Dropped %d missing or corrupt object(s), keeping %d intact one(s) from the same packs.\n", dropped, kept)
+	fmt.Printf("   - Marked %d snap(s) as damaged.\n", marked)
+	return nil
+}
@@ -0,0 +1,111 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairCommand(t *testing.T) {
+	t.Run("should do nothing to an intact repository", func(t *testing.T) {
+		// Arrange
+		sourceDir, _ := setupCheckTest(t)
+
+		// Act
+		var repairErr error
+		output := captureStdout(t, func() {
+			repairErr = commands.Repair(sourceDir, commands.RepairOptions{})
+		})
+
+		// Assert
+		require.NoError(t, repairErr)
+		assert.Contains(t, output, "nothing to repair")
+	})
+
+	t.Run("should salvage a pack with a corrupt object and mark the snaps that need it", func(t *testing.T) {
+		// Arrange
+		sourceDir, packHash := setupCheckTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileA.txt"), []byte("changed in the third snap"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		store := lib.NewLocalObjectStore(sourceDir)
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		inPack := 0
+		var damagedEntry int64 = -1
+		for _, entry := range index {
+			if entry.PackHash == packHash {
+				inPack++
+				damagedEntry = entry.Offset
+			}
+		}
+		require.Greater(t, inPack, 1)
+		packPath := filepath.Join(lib.GetPacksDir(sourceDir), packHash)
+		pack, err := os.ReadFile(packPath)
+		require.NoError(t, err)
+		pack[damagedEntry] ^= 0xff
+		require.NoError(t, os.WriteFile(packPath, pack, 0644))
+
+		// Act
+		var repairErr error
+		output := captureStdout(t, func() {
+			repairErr = commands.Repair(sourceDir, commands.RepairOptions{})
+		})
+
+		// Assert
+		require.NoError(t, repairErr)
+		assert.Contains(t, output, "Repair complete!")
+		assert.Contains(t, output, "Dropped 1 missing or corrupt object(s)")
+		assert.NoFileExists(t, packPath)
+
+		snaps, err := lib.NewLocalObjectStore(sourceDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 3)
+		assert.False(t, snaps[0].Damaged)
+		assert.Equal(t, snaps[0].Hash, snaps[1].Parent)
+		assert.Equal(t, snaps[1].Hash, snaps[2].Parent, "the snap after a marked one should name its new hash")
+
+		var checkErr error
+		checkOutput := captureStdout(t, func() {
+			checkErr = commands.Check(sourceDir, commands.CheckOptions{})
+		})
+		require.NoError(t, checkErr)
+		assert.Contains(t, checkOutput, "marked as damaged")
+
+		restoreDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: restoreDir}))
+		content, err := os.ReadFile(filepath.Join(restoreDir, "subdir", "fileB.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "me too", string(content))
+	})
+
+	t.Run("should drop the entries of a missing pack so prune works again", func(t *testing.T) {
+		// Arrange
+		sourceDir, packHash := setupCheckTest(t)
+		require.NoError(t, os.Remove(filepath.Join(lib.GetPacksDir(sourceDir), packHash)))
+
+		// Act
+		var repairErr error
+		output := captureStdout(t, func() {
+			repairErr = commands.Repair(sourceDir, commands.RepairOptions{})
+		})
+
+		// Assert
+		require.NoError(t, repairErr)
+		assert.Contains(t, output, "Marked snap 2 as damaged")
+		snap, err := lib.NewLocalObjectStore(sourceDir).FindSnap("2")
+		require.NoError(t, err)
+		assert.True(t, snap.Damaged)
+		assert.NoFileExists(t, filepath.Join(lib.GetIndexDir(sourceDir), packHash))
+
+		captureStdout(t, func() {
+			err = commands.Prune(sourceDir, commands.PruneOptions{KeepLast: 1})
+		})
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,437 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// RepositoryOptions holds the settings shared by every command that opens a
+// repository.
+type RepositoryOptions struct {
+	// Repo is the repository location: a local path or a URL such as
+	// s3://bucket/prefix. An empty value selects the default .btool directory
+	// inside the command's directory.
+	Repo string
+	// Mirrors are additional locations that every write is replicated to, for
+	// redundancy. Reads prefer Repo and fall back to the mirrors in order.
+	Mirrors []string
+	// Retries is how many times a failed storage operation is retried, with
+	// exponential backoff, before giving up.
+	Retries int
+	// LimitUpload and LimitDownload cap the transfer rate to and from the
+	// repository in KiB/s. Zero means unlimited.
+	LimitUpload   int64
+	LimitDownload int64
+	// Password unlocks an encrypted repository. Given for a repository that
+	// holds no data yet, it creates the repository encrypted. When it is empty,
+	// the password is read from PasswordFile, or else printed by
+	// PasswordCommand, or else taken from $BTOOL_PASSWORD, or else typed in
+	// at a prompt when standard input is a terminal.
+	Password        string
+	PasswordFile    string
+	PasswordCommand string
+	// Encrypt creates a new repository encrypted even though no password was
+	// given, by prompting for one.
+	Encrypt bool
+	// KeyedHashes makes a new encrypted repository derive object IDs with
+	// HMAC-SHA256 under a secret key, rather than plain SHA-256, so that the
+	// IDs cannot be used to test whether the repository holds a known file.
+	KeyedHashes bool
+	// HashAlgorithm selects the hash that derives the object IDs of a new
+	// repository: sha256, the default, or blake3, which is faster. Like
+	// KeyedHashes, it is recorded in the repository config.
+	HashAlgorithm string
+	// Chunker selects how a new repository splits files into chunks: rabin,
+	// the default, or fastcdc, which is faster. It is recorded in the
+	// repository config, since chunks only de-duplicate against chunks cut
+	// the same way.
+	Chunker string
+	// KDF selects the key derivation function, and its cost, for the password
+	// slot of a new encrypted repository and for those 'key add' and 'key
+	// passwd' create. The zero value selects lib.DefaultKDF.
+	KDF lib.KDFParams
+	// AgeRecipients are age public keys (age1...) that a new repository is
+	// encrypted to. Backups can then run without any secret, while reading the
+	// repository takes one of the identities in AgeIdentityFile.
+	AgeRecipients   []string
+	AgeIdentityFile string
+	// KMSKeys are cloud KMS key URIs, such as awskms://alias/btool, that wrap
+	// the master key of a new repository. A repository with KMS slots is then
+	// unlocked through the KMS whenever no password or identity is given.
+	KMSKeys []string
+	// Compression selects how objects are compressed: off, lz4, zstd, or
+	// gzip, at CompressionLevel, where 0 selects the algorithm's default.
+	// Either given for a repository that holds no data yet becomes its
+	// default; otherwise they apply to this command only. When both are
+	// empty, the repository's defaults apply.
+	Compression      string
+	CompressionLevel int
+	// PackSize is the size, in MiB, of the packs a snap writes as it goes,
+	// which bounds the memory it holds. Like Compression, given for a
+	// repository that holds no data yet it becomes its default. Zero selects
+	// the repository's default, or else lib.DefaultPackSize.
+	PackSize int64
+	// NoCache disables the local cache of a remote repository's index and
+	// snap manifests.
+	NoCache bool
+}
+
+// repositorySecrets holds everything a command was given to unlock or create
+// an encrypted repository.
+type repositorySecrets struct {
+	password   string
+	identities []age.Identity
+	recipients []*age.X25519Recipient
+	kmsKeys    []*lib.KMSKey
+	encrypt    bool
+	kdf        lib.KDFParams
+}
+
+// passwordSource returns where options look for the repository password.
+func (options RepositoryOptions) passwordSource() passwordSource {
+	return passwordSource{
+		password: options.Password,
+		file:     options.PasswordFile,
+		command:  options.PasswordCommand,
+		envVar:   PasswordEnvVar,
+	}
+}
+
+// secrets resolves the password, age identities, and age recipients selected
+// by options.
+func (options RepositoryOptions) secrets() (repositorySecrets, error) {
+	var secrets repositorySecrets
+	var err error
+	secrets.encrypt = options.Encrypt
+	secrets.kdf = options.KDF
+	if secrets.kdf.Algorithm == "" {
+		secrets.kdf = lib.DefaultKDF()
+	} else if err := secrets.kdf.Validate(); err != nil {
+		return secrets, err
+	}
+	if secrets.password, err = resolvePassword(options.passwordSource()); err != nil {
+		return secrets, err
+	}
+	if options.AgeIdentityFile != "" {
+		file, err := os.Open(options.AgeIdentityFile)
+		if err != nil {
+			return secrets, fmt.Errorf("could not read age identity file: %w", err)
+		}
+		defer file.Close()
+		if secrets.identities, err = age.ParseIdentities(file); err != nil {
+			return secrets, fmt.Errorf("could not parse age identity file: %w", err)
+		}
+	}
+	for _, recipient := range options.AgeRecipients {
+		parsed, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return secrets, fmt.Errorf("invalid age recipient %q: %w", recipient, err)
+		}
+		secrets.recipients = append(secrets.recipients, parsed)
+	}
+	for _, uri := range options.KMSKeys {
+		kmsKey, err := lib.ParseKMSKey(uri)
+		if err != nil {
+			return secrets, err
+		}
+		secrets.kmsKeys = append(secrets.kmsKeys, kmsKey)
+	}
+	return secrets, nil
+}
+
+// openStore resolves the repository for a command that reads it and returns
+// an ObjectStore on top of it. An empty Repo selects the default .btool
+// directory inside absDir. With mirrors, writes are replicated to every
+// destination.
+func openStore(options RepositoryOptions, absDir string) (*lib.ObjectStore, error) {
+	return openStoreMode(options, absDir, false)
+}
+
+// openWriteStore is like openStore, for commands that only add to the
+// repository. Without a password or identity, an encrypted repository is
+// opened write-only.
+func openWriteStore(options RepositoryOptions, absDir string) (*lib.ObjectStore, error) {
+	return openStoreMode(options, absDir, true)
+}
+
+// openStoreMode implements openStore and openWriteStore.
+func openStoreMode(options RepositoryOptions, absDir string, allowWriteOnly bool) (*lib.ObjectStore, error) {
+	secrets, err := options.secrets()
+	if err != nil {
+		return nil, err
+	}
+	backend, err := openBackend(options, options.Repo, absDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(options.Mirrors) > 0 {
+		backends := []lib.Backend{backend}
+		for _, mirror := range options.Mirrors {
+			mirrorBackend, err := openBackend(options, mirror, absDir)
+			if err != nil {
+				for _, opened := range backends {
+					closeBackend(opened)
+				}
+				return nil, err
+			}
+			backends = append(backends, mirrorBackend)
+		}
+		backend = lib.NewMultiBackend(backends...)
+	}
+
+	key, err := unlockRepository(backend, secrets, allowWriteOnly)
+	if err != nil {
+		closeBackend(backend)
+		return nil, err
+	}
+	store := lib.NewObjectStore(backend)
+	if key != nil {
+		store = lib.NewEncryptedObjectStore(backend, key)
+	}
+	if err := configureRepository(store, options); err != nil {
+		closeBackend(backend)
+		return nil, err
+	}
+	if err := configureDefaults(store, options); err != nil {
+		closeBackend(backend)
+		return nil, err
+	}
+	return store, nil
+}
+
+// configureDefaults sets store's compression and pack size from options over
+// the repository defaults. Options given for a repository that holds no data
+// yet are stored as its defaults.
+func configureDefaults(store *lib.ObjectStore, options RepositoryOptions) error {
+	defaults, err := store.LoadDefaults()
+	if err != nil {
+		return err
+	}
+	compression := defaults.CompressionOptions()
+	if options.Compression != "" {
+		// A level belongs to its algorithm, so a new algorithm starts from its
+		// default level.
+		compression = lib.Compression{Algorithm: options.Compression}
+	}
+	if options.CompressionLevel != 0 {
+		compression.Level = options.CompressionLevel
+	}
+	if err := store.SetCompression(compression); err != nil {
+		return err
+	}
+	packSize := defaults.PackSize
+	if options.PackSize != 0 {
+		packSize = options.PackSize * 1024 * 1024
+	}
+	if err := store.SetPackSize(packSize); err != nil {
+		return err
+	}
+
+	if options.Compression == "" && options.CompressionLevel == 0 && options.PackSize == 0 {
+		return nil
+	}
+	hasData, err := repositoryHasData(store.Backend())
+	if err != nil || hasData {
+		return err
+	}
+	if options.Compression != "" || options.CompressionLevel != 0 {
+		defaults.Compression, defaults.CompressionLevel = compression.Algorithm, compression.Level
+	}
+	defaults.PackSize = packSize
+	return store.WriteDefaults(defaults)
+}
+
+// configureRepository loads the repository config into store. For a
+// repository that holds no data yet, it first records the hash algorithm,
+// keyed hashing, and chunker that options choose.
+func configureRepository(store *lib.ObjectStore, options RepositoryOptions) error {
+	location := store.Backend().Location()
+	err := store.LoadConfig()
+	if errors.Is(err, lib.ErrWriteOnly) {
+		return fmt.Errorf("repository %s uses keyed hashes, so backing up to it needs its password or an age identity", location)
+	}
+	if err != nil {
+		return err
+	}
+
+	config := store.Config()
+	var changes []string
+	hasher := store.Hasher()
+	keyed, base := hasher.Keyed(), hasher.Base()
+	if options.KeyedHashes && !keyed {
+		if store.Key() == nil {
+			return fmt.Errorf("keyed hashes need an encrypted repository; supply a password or age recipient when creating %s", location)
+		}
+		keyed = true
+		changes = append(changes, "keyed hashes")
+	}
+	if options.HashAlgorithm != "" && options.HashAlgorithm != base {
+		base = options.HashAlgorithm
+		changes = append(changes, "the hash algorithm")
+	}
+	if keyed != hasher.Keyed() || base != hasher.Base() {
+		hashConfig, err := lib.NewHashConfig(base, keyed)
+		if err != nil {
+			return err
+		}
+		config.HashAlgorithm, config.HashKey = hashConfig.HashAlgorithm, hashConfig.HashKey
+	}
+	if options.Chunker != "" && options.Chunker != store.Chunker().Name() {
+		if _, err := lib.NewChunker(options.Chunker); err != nil {
+			return err
+		}
+		config.Chunker = options.Chunker
+		changes = append(changes, "the chunker")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	hasData, err := repositoryHasData(store.Backend())
+	if err != nil {
+		return err
+	}
+	if hasData {
+		return fmt.Errorf("repository %s already holds data; %s can only be chosen when it is created", location, strings.Join(changes, " and "))
+	}
+	return store.WriteConfig(config)
+}
+
+// repositoryHasData reports whether the repository holds an index or snaps.
+func repositoryHasData(backend lib.Backend) (bool, error) {
+	snaps, err := backend.List(lib.SnapsDirName)
+	if err != nil {
+		return false, err
+	}
+	shards, err := backend.List(lib.IndexDirName)
+	if err != nil {
+		return false, err
+	}
+	_, err = backend.Get(lib.IndexFileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+	return err == nil || len(shards) > 0 || len(snaps) > 0, nil
+}
+
+// unlockRepository returns the key of an encrypted repository, or nil for an
+// unencrypted one. A password or age recipients given for a repository that
+// holds no data yet make it an encrypted repository.
+func unlockRepository(backend lib.Backend, secrets repositorySecrets, allowWriteOnly bool) (*lib.RepositoryKey, error) {
+	encrypted, err := lib.IsEncrypted(backend)
+	if err != nil {
+		return nil, fmt.Errorf("could not read repository keys: %w", err)
+	}
+
+	if encrypted {
+		switch {
+		case len(secrets.recipients) > 0:
+			return nil, fmt.Errorf("repository %s already exists; age recipients can only be given when it is created", backend.Location())
+		case len(secrets.kmsKeys) > 0:
+			return nil, fmt.Errorf("repository %s already exists; add a KMS key with 'btool key add --kms'", backend.Location())
+		case secrets.password != "":
+			return lib.UnlockRepositoryKey(backend, secrets.password)
+		case len(secrets.identities) > 0:
+			return lib.UnlockRepositoryKeyWithIdentities(backend, secrets.identities)
+		}
+		if password := keychainPassword(backend); password != "" {
+			return lib.UnlockRepositoryKey(backend, password)
+		}
+		hasKMS, err := lib.HasKMSSlots(backend)
+		if err != nil {
+			return nil, err
+		}
+		if hasKMS {
+			return lib.UnlockRepositoryKeyWithKMS(backend)
+		}
+		if allowWriteOnly {
+			return lib.OpenWriteOnlyKey(backend)
+		}
+		password, err := promptPassword("Repository password: ", false)
+		if errors.Is(err, errNoTerminal) {
+			return nil, fmt.Errorf("repository %s is encrypted; supply its password with --password-file, --password-command, or $%s, or an age identity with --age-identity-file", backend.Location(), PasswordEnvVar)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return lib.UnlockRepositoryKey(backend, password)
+	}
+	if secrets.password == "" && len(secrets.recipients) == 0 && len(secrets.kmsKeys) == 0 && !secrets.encrypt {
+		return nil, nil
+	}
+
+	hasData, err := repositoryHasData(backend)
+	if err != nil {
+		return nil, err
+	}
+	if hasData {
+		return nil, fmt.Errorf("repository %s is not encrypted, but a password, age recipient, or KMS key was given", backend.Location())
+	}
+	if secrets.password == "" && len(secrets.recipients) == 0 && len(secrets.kmsKeys) == 0 {
+		if secrets.password, err = promptPassword("New repository password: ", true); err != nil {
+			return nil, fmt.Errorf("could not read a password for the new repository: %w", err)
+		}
+	}
+	fmt.Printf("🔑 Creating encrypted repository at %s\n", backend.Location())
+	return lib.CreateRepositoryKey(backend, lib.RepositoryKeySpec{
+		Password:   secrets.password,
+		KDF:        secrets.kdf,
+		Recipients: secrets.recipients,
+		KMSKeys:    secrets.kmsKeys,
+	})
+}
+
+// openBackend opens a single repository destination with the throttling,
+// retry, and caching layers selected by options.
+func openBackend(options RepositoryOptions, repo, absDir string) (lib.Backend, error) {
+	backend, err := lib.OpenBackend(repo, absDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open repository: %w", err)
+	}
+	if options.LimitUpload > 0 || options.LimitDownload > 0 {
+		backend = lib.NewThrottledBackend(backend, options.LimitUpload*1024, options.LimitDownload*1024)
+	}
+	if options.Retries > 0 {
+		backend = lib.NewRetryBackend(backend, options.Retries)
+	}
+	if _, isLocal := lib.UnwrapBackend(backend).(*lib.LocalBackend); !isLocal && !options.NoCache {
+		// Caching is an optimization; without a cache directory, go without.
+		if cacheDir, err := lib.DefaultCacheDir(backend.Location()); err == nil {
+			backend = lib.NewCachedBackend(backend, cacheDir)
+		}
+	}
+	return backend, nil
+}
+
+// closeBackend releases any resources held by backend.
+func closeBackend(backend lib.Backend) {
+	if closer, ok := backend.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// reportDestinations prints how the writes to each destination of a
+// replicated store went. It prints nothing for a single destination, whose
+// failures already fail the command.
+func reportDestinations(store *lib.ObjectStore) {
+	multi, ok := lib.UnwrapBackend(store.Backend()).(*lib.MultiBackend)
+	if !ok {
+		return
+	}
+	for _, status := range multi.Status() {
+		if status.Failures == 0 {
+			fmt.Printf("   - Replicated to %s\n", status.Location)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %d of %d writes to %s failed, so it may be incomplete: %v\n",
+			status.Failures, status.Writes, status.Location, status.Err)
+	}
+}
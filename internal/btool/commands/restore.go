@@ -1,118 +1,1025 @@
 package commands
 
 import (
+	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
 )
 
+// RestoreOptions holds the configuration for the restore command.
+type RestoreOptions struct {
+	SnapIdentifier string
+	// OutputDir is the directory to restore into. It is brought in line
+	// with the snap in place: files whose size and modification time match
+	// are left as they are, and only what the snap does not hold, and a snap
+	// of it would not leave out, is removed.
+	OutputDir string
+	// Path, if set, is the file or directory within the snap to restore,
+	// with forward slashes. It is restored at the same path below OutputDir,
+	// and nothing else there is touched.
+	Path string
+	// Include and Exclude, if set, restore only the files whose paths in
+	// the snap match an Include pattern and no Exclude pattern, in the
+	// syntax of .btoolignore. Nothing is removed then.
+	Include, Exclude []string
+	// NoDelete leaves what OutputDir holds beyond the snap, layering the
+	// snap over it.
+	NoDelete bool
+	// DryRun prints the files that restoring would create, overwrite, and
+	// delete, with their sizes, without writing anything.
+	DryRun bool
+	// Tar, if set, writes the snap, or the part of it at Path, as a tar
+	// archive to the file it names instead, or to stdout if it is "-". A
+	// file named .tar.gz or .tgz is compressed with gzip.
+	Tar string
+	// OnConflict decides what happens to a file of OutputDir that differs
+	// from the file the snap holds at its path: one of the Conflict
+	// policies, ConflictOverwrite if empty.
+	OnConflict string
+	// Verify reads every restored file back once the restore is done and
+	// checks each of its chunks against the hash its manifest lists, failing
+	// if any differ.
+	Verify bool
+	// VerifyOnly compares OutputDir with the snap instead, contents and
+	// metadata, and reports how they differ without changing anything,
+	// failing if they do.
+	VerifyOnly bool
+	// Stdout writes the file at Path to stdout instead, so that nothing is
+	// restored to disk.
+	Stdout bool
+	// PreserveOwner gives restored files and directories the user and group
+	// that owned them when snapped, by name where the name exists here and
+	// by ID otherwise. It usually takes root.
+	PreserveOwner bool
+	// ACLs gives restored files and directories the POSIX ACLs they had
+	// when snapped. Named users and groups keep their IDs.
+	ACLs bool
+	// RenameCollisions restores the entries of a directory whose names
+	// differ only in case under other names, where the filesystem cannot
+	// tell them apart, instead of failing.
+	RenameCollisions bool
+	RepositoryOptions
+}
+
+// The policies for a file of the output directory that differs from the
+// file the snap holds at its path.
+const (
+	// ConflictOverwrite replaces the file.
+	ConflictOverwrite = "overwrite"
+	// ConflictSkip leaves the file as it is.
+	ConflictSkip = "skip"
+	// ConflictFail fails the restore before anything is written.
+	ConflictFail = "fail"
+	// ConflictRename keeps the file under another name, such as
+	// "notes (conflict).txt", and restores the snap's file in its place.
+	ConflictRename = "rename"
+)
+
 // fileRestoreJob holds the information needed for a worker to restore one file.
 type fileRestoreJob struct {
 	ManifestHash    string
 	DestinationPath string
 	Mode            os.FileMode
+	ModTime         int64 // In nanoseconds since the epoch; 0 if unknown.
+	BirthTime       int64 // Likewise, the creation time on macOS.
+	Streams         []types.Stream
+	OnConflict      string // What to do with a differing file in the way.
+}
+
+// restoreStats counts what a restore did to the files of the output
+// directory, and how far it has got.
+type restoreStats struct {
+	written, unchanged, removed, skipped atomic.Int64
+	// queued is the number of files sent to the workers, which is final
+	// once traversed is set.
+	queued    atomic.Int64
+	traversed atomic.Bool
+	// bytes is the size of the files written and left unchanged so far.
+	bytes atomic.Int64
 }
 
 // restoreFileWorker is the logic executed by each goroutine in the pool.
 // It reads jobs from a channel, restores the file, and signals completion.
-func restoreFileWorker(wg *sync.WaitGroup, store *lib.ObjectStore, jobs <-chan fileRestoreJob, errs chan<- error) {
+func restoreFileWorker(wg *sync.WaitGroup, store *lib.ObjectStore, jobs <-chan fileRestoreJob, errs chan<- error, stats *restoreStats) {
 	defer wg.Done()
 	for job := range jobs {
-		// 1. Read the file manifest object.
-		manifestBuffer, err := store.ReadObjectAsBuffer(job.ManifestHash)
+		unchanged, size, err := fileUnchanged(store, job)
 		if err != nil {
-			errs <- fmt.Errorf("failed to read manifest %s for %s: %w", job.ManifestHash, job.DestinationPath, err)
+			errs <- err
 			continue
 		}
-		var manifest types.FileManifest
-		if err := json.Unmarshal(manifestBuffer, &manifest); err != nil {
-			errs <- fmt.Errorf("failed to parse manifest %s for %s: %w", job.ManifestHash, job.DestinationPath, err)
+		if unchanged {
+			stats.unchanged.Add(1)
+			stats.bytes.Add(size)
+			continue
+		}
+		if skip, err := resolveConflict(job.DestinationPath, job.OnConflict); err != nil {
+			errs <- err
+			continue
+		} else if skip {
+			stats.skipped.Add(1)
 			continue
 		}
+		if err := restoreFile(store, job, &stats.bytes); err != nil {
+			errs <- err
+			continue
+		}
+		stats.written.Add(1)
+		restoreTimes(job.DestinationPath, job.BirthTime, job.ModTime)
+	}
+}
 
-		// 2. Read all data chunks for the file.
-		var fileContent []byte
-		for _, chunkRef := range manifest.Chunks {
-			chunkData, err := store.ReadObjectAsBuffer(chunkRef.Hash)
-			if err != nil {
-				errs <- fmt.Errorf("failed to read chunk %s for file %s: %w", chunkRef.Hash, job.DestinationPath, err)
-				break // Stop processing this file if a chunk is missing
+// matchesSnap tells whether an existing file is taken to hold the contents
+// of a file of the given modification time and size in a snap. Snaps taken
+// before times were recorded hold no modification time to compare, so their
+// files never match.
+func matchesSnap(info os.FileInfo, modTime, size int64) bool {
+	return info.Mode().IsRegular() && modTime != 0 && info.ModTime().UnixNano() == modTime && info.Size() == size
+}
+
+// fileUnchanged tells whether the file at the destination of a job already
+// holds what the job would write, going by its size and modification time,
+// and gives it the mode of the job if so. The size is that of the file if it
+// is unchanged.
+func fileUnchanged(store *lib.ObjectStore, job fileRestoreJob) (bool, int64, error) {
+	info, err := os.Lstat(job.DestinationPath)
+	if os.IsNotExist(err) {
+		return false, 0, nil
+	} else if err != nil {
+		return false, 0, err
+	}
+	if matchesSnap(info, job.ModTime, info.Size()) {
+		var manifest types.FileManifest
+		if err := store.ReadObjectAsJSON(job.ManifestHash, &manifest); err != nil {
+			return false, 0, fmt.Errorf("failed to read manifest %s for %s: %w", job.ManifestHash, job.DestinationPath, err)
+		}
+		if matchesSnap(info, job.ModTime, manifest.TotalSize) {
+			if info.Mode().Perm() != job.Mode.Perm() {
+				if err := os.Chmod(job.DestinationPath, job.Mode); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: could not set mode on %s: %v\n", job.DestinationPath, err)
+				}
 			}
-			fileContent = append(fileContent, chunkData...)
+			return true, manifest.TotalSize, nil
+		}
+	}
+	return false, 0, nil
+}
+
+// resolveConflict clears the way for a file to be restored at path, as the
+// conflict policy says, reporting whether to skip it instead. What is in the
+// way is removed or renamed rather than truncated, leaving alone the other
+// links of a hardlinked file and whatever a symlink points to.
+func resolveConflict(path, policy string) (skip bool, err error) {
+	if _, err := os.Lstat(path); os.IsNotExist(err) {
+		return false, nil
+	}
+	switch policy {
+	case ConflictSkip:
+		return true, nil
+	case ConflictRename:
+		for n := 1; ; n++ {
+			ext := filepath.Ext(path)
+			suffix := " (conflict)"
+			if n > 1 {
+				suffix = " (conflict " + strconv.Itoa(n) + ")"
+			}
+			aside := strings.TrimSuffix(path, ext) + suffix + ext
+			if _, err := os.Lstat(aside); !os.IsNotExist(err) {
+				continue
+			}
+			if err := os.Rename(path, aside); err != nil {
+				return false, fmt.Errorf("failed to keep %s as %s: %w", path, aside, err)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: kept %s as %s, as it differs from the snap\n", path, filepath.Base(aside))
+			return false, nil
+		}
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return false, fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return false, nil
+}
+
+// restoreFile writes the file of a job, then its alternate data streams,
+// adding the bytes of the file to written as they are.
+func restoreFile(store *lib.ObjectStore, job fileRestoreJob, written *atomic.Int64) error {
+	if err := restoreManifest(store, job.ManifestHash, job.DestinationPath, job.Mode, written); err != nil {
+		return err
+	}
+	if len(job.Streams) > 0 && !lib.StreamsSupported {
+		fmt.Fprintf(os.Stderr, "Warning: skipping the %d alternate data stream(s) of %s, which only Windows keeps\n", len(job.Streams), job.DestinationPath)
+		return nil
+	}
+	for _, stream := range job.Streams {
+		if err := restoreManifest(store, stream.Hash, lib.StreamPath(job.DestinationPath, stream.Name), job.Mode, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreManifest writes the contents that a file manifest lists to
+// destinationPath, counting the bytes written in written, if not nil.
+func restoreManifest(store *lib.ObjectStore, manifestHash, destinationPath string, mode os.FileMode, written *atomic.Int64) error {
+	// 1. Read the file manifest object.
+	manifestBuffer, err := store.ReadObjectAsBuffer(manifestHash)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest %s for %s: %w", manifestHash, destinationPath, err)
+	}
+	var manifest types.FileManifest
+	if err := json.Unmarshal(manifestBuffer, &manifest); err != nil {
+		return fmt.Errorf("failed to parse manifest %s for %s: %w", manifestHash, destinationPath, err)
+	}
+
+	// 2. Read all data chunks for the file and write them to disk. They
+	// are read together, so that chunks stored next to each other take
+	// a single read.
+	hashes := make([]string, len(manifest.Chunks))
+	for i, chunkRef := range manifest.Chunks {
+		hashes[i] = chunkRef.Hash
+	}
+	return writeRestoredFile(store, destinationPath, mode, hashes, written)
+}
+
+// restoreTimes gives a restored path the creation and modification times it
+// had, skipping those that are 0, as in snaps taken before times were
+// recorded. The creation time is set first, as macOS moves it back to a
+// modification time set earlier than it.
+func restoreTimes(path string, birthTime, modTime int64) {
+	if birthTime != 0 {
+		if err := lib.SetFileBirthTime(path, birthTime); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not set creation time on %s: %v\n", path, err)
+		}
+	}
+	if modTime == 0 {
+		return
+	}
+	t := time.Unix(0, modTime)
+	if err := os.Chtimes(path, t, t); err != nil {
+		// Log a warning, as with modes, since the contents are intact.
+		fmt.Fprintf(os.Stderr, "Warning: could not set modification time on %s: %v\n", path, err)
+	}
+}
+
+// attributedPath is a restored file or directory and the Windows attributes
+// it had.
+type attributedPath struct {
+	path       string
+	attributes uint32
+}
+
+// timedPath is a restored directory and the times it had.
+type timedPath struct {
+	path               string
+	birthTime, modTime int64
+}
+
+// xattrPath is a restored file or directory and the extended attributes it
+// had.
+type xattrPath struct {
+	path   string
+	xattrs map[string][]byte
+}
+
+// restoreBufferSize is the size of the buffer between the chunks a restore
+// worker reads and the file it writes them to.
+const restoreBufferSize = 256 * 1024 // 256KB
+
+// restoreWriters holds the buffers of restore workers, reused from file to
+// file.
+var restoreWriters = sync.Pool{New: func() any {
+	return bufio.NewWriterSize(nil, restoreBufferSize)
+}}
+
+// writeRestoredFile writes the chunks with the given hashes to the file at
+// destinationPath as they are read, so that a file is never held in memory
+// whole. The file is only created once its chunks are found, and removed
+// again if reading them fails partway. The bytes written are counted in
+// written, if not nil.
+func writeRestoredFile(store *lib.ObjectStore, destinationPath string, mode os.FileMode, hashes []string, written *atomic.Int64) error {
+	var file *os.File
+	writer := restoreWriters.Get().(*bufio.Writer)
+	defer func() {
+		writer.Reset(nil)
+		restoreWriters.Put(writer)
+	}()
+	create := func() error {
+		var err error
+		if file, err = os.OpenFile(destinationPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode); err != nil {
+			return err
+		}
+		writer.Reset(file)
+		return nil
+	}
+
+	var writeErr error
+	readErr := store.ReadObjects(hashes, func(chunkData []byte) error {
+		if file == nil {
+			if writeErr = create(); writeErr != nil {
+				return writeErr
+			}
+		}
+		_, writeErr = writer.Write(chunkData)
+		if written != nil && writeErr == nil {
+			written.Add(int64(len(chunkData)))
+		}
+		return writeErr
+	})
+	if readErr == nil && file == nil {
+		writeErr = create() // An empty file.
+	}
+	if writeErr == nil && readErr == nil {
+		writeErr = writer.Flush()
+	}
+	if file != nil {
+		if err := file.Close(); writeErr == nil {
+			writeErr = err
+		}
+		if writeErr != nil || readErr != nil {
+			os.Remove(destinationPath)
+		}
+	}
+
+	switch {
+	case writeErr != nil:
+		return fmt.Errorf("failed to write file %s: %w", destinationPath, writeErr)
+	case readErr != nil:
+		return fmt.Errorf("failed to read chunks for file %s: %w", destinationPath, readErr)
+	}
+	return nil
+}
+
+// restoredFile is a file that a restore wrote or found unchanged, and the
+// manifest of what it should hold.
+type restoredFile struct {
+	path, manifestHash string
+}
+
+// verifyRestoredFile reads the file back and hashes it chunk by chunk, by the
+// sizes its manifest lists, checking each chunk against the hash listed for
+// it.
+func verifyRestoredFile(store *lib.ObjectStore, file restoredFile) error {
+	var manifest types.FileManifest
+	if err := store.ReadObjectAsJSON(file.manifestHash, &manifest); err != nil {
+		return fmt.Errorf("failed to read manifest %s for %s: %w", file.manifestHash, file.path, err)
+	}
+	f, err := os.Open(file.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReaderSize(f, restoreBufferSize)
+	var buffer []byte
+	var offset int64
+	for _, chunk := range manifest.Chunks {
+		buffer = slices.Grow(buffer[:0], int(chunk.Size))[:chunk.Size]
+		if _, err := io.ReadFull(reader, buffer); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("%s is shorter than the %d bytes it has in the snap", file.path, manifest.TotalSize)
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.path, err)
+		}
+		if store.Hasher().GetHash(buffer) != chunk.Hash {
+			return fmt.Errorf("%s differs from the snap in bytes %d to %d", file.path, offset, offset+chunk.Size)
+		}
+		offset += chunk.Size
+	}
+	if _, err := reader.ReadByte(); err != io.EOF {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.path, err)
 		}
+		return fmt.Errorf("%s is longer than the %d bytes it has in the snap", file.path, manifest.TotalSize)
+	}
+	return nil
+}
 
-		// 3. Write the reconstructed file to disk and set its permissions.
-		if err := os.WriteFile(job.DestinationPath, fileContent, job.Mode); err != nil {
-			errs <- fmt.Errorf("failed to write file %s: %w", job.DestinationPath, err)
+// verifyRestoredFiles verifies the restored files on as many goroutines as
+// there are CPUs, returning the problem found with each, or nil.
+func verifyRestoredFiles(store *lib.ObjectStore, files []restoredFile) []error {
+	problems := make([]error, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				problems[i] = verifyRestoredFile(store, files[i])
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return problems
+}
+
+// hardLinks tracks the files of a restore that were hardlinked together when
+// snapped. The first file of each group is restored from its chunks, and the
+// others are linked to it once it is written.
+type hardLinks struct {
+	first   map[string]string // The path of the first file, by link.
+	pending []hardLink
+}
+
+// hardLink is a file to be linked to the first file of its group.
+type hardLink struct {
+	target, path string
+	entry        types.TreeEntry
+}
+
+// add records the file of a tree entry, to be restored at path, reporting
+// whether it is to be linked rather than restored.
+func (l *hardLinks) add(path string, entry types.TreeEntry) bool {
+	if entry.Link == "" {
+		return false
+	}
+	target, ok := l.first[entry.Link]
+	if !ok {
+		l.first[entry.Link] = path
+		return false
+	}
+	l.pending = append(l.pending, hardLink{target: target, path: path, entry: entry})
+	return true
+}
+
+// create links the pending files to the first files of their groups, which
+// must have been written. Where the filesystem cannot link them, the files
+// are copied instead. A file already linked to the first file of its group
+// is left as it is, and anything else in the way is dealt with as the
+// conflict policy says.
+func (l *hardLinks) create(onConflict string, stats *restoreStats) error {
+	for _, link := range l.pending {
+		if info, err := os.Lstat(link.path); err == nil {
+			if target, err := os.Stat(link.target); err == nil && os.SameFile(info, target) {
+				continue
+			}
+		}
+		if skip, err := resolveConflict(link.path, onConflict); err != nil {
+			return fmt.Errorf("failed to restore hardlink %s: %w", link.path, err)
+		} else if skip {
+			stats.skipped.Add(1)
+			continue
+		}
+		if err := os.Link(link.target, link.path); err == nil {
 			continue
 		}
+		if err := copyFile(link.target, link.path, os.FileMode(link.entry.Mode)); err != nil {
+			return fmt.Errorf("failed to restore hardlink %s: %w", link.path, err)
+		}
+		restoreTimes(link.path, link.entry.BirthTime, link.entry.ModTime)
 	}
+	return nil
 }
 
-// restoreTree recursively reconstructs a directory from a tree object.
-func restoreTree(store *lib.ObjectStore, treeHash, destinationPath string, jobs chan<- fileRestoreJob) error {
-	treeBuffer, err := store.ReadObjectAsBuffer(treeHash)
+// copyFile copies the file at source to a new file at destination.
+func copyFile(source, destination string, mode os.FileMode) error {
+	in, err := os.Open(source)
 	if err != nil {
 		return err
 	}
-	var tree types.Tree
-	if err := json.Unmarshal(treeBuffer, &tree); err != nil {
+	defer in.Close()
+	out, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
 		return err
 	}
-
-	// Ensure the destination directory exists.
-	if err := os.MkdirAll(destinationPath, 0755); err != nil {
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
 		return err
 	}
+	return out.Close()
+}
+
+// ownedPath is a restored file or directory and the owner it had.
+type ownedPath struct {
+	path  string
+	owner types.Owner
+}
+
+// restoreOwners gives restored paths their owners.
+func restoreOwners(owners []ownedPath) error {
+	for _, owned := range owners {
+		uid, gid := lib.LocalOwnerIDs(owned.owner)
+		if err := os.Lchown(owned.path, uid, gid); err != nil {
+			return fmt.Errorf("failed to set the owner of %s (this usually takes root): %w", owned.path, err)
+		}
+	}
+	return nil
+}
+
+// aclPath is a restored file or directory and the ACL it had.
+type aclPath struct {
+	path string
+	acl  types.ACL
+}
+
+// restoreACLs gives restored paths their ACLs.
+func restoreACLs(acls []aclPath) error {
+	for _, a := range acls {
+		if err := lib.SetFileACL(a.path, a.acl); err != nil {
+			return fmt.Errorf("failed to set the ACL of %s: %w", a.path, err)
+		}
+	}
+	return nil
+}
+
+// caseInsensitive tells whether the filesystem of a directory takes names
+// that differ only in case for the same. Tests replace it.
+var caseInsensitive = lib.IsCaseInsensitive
+
+// foldCase returns the key under which a case-insensitive filesystem finds
+// a name.
+func foldCase(name string) string {
+	return strings.ToLower(name)
+}
 
+// findCaseCollisions returns, for each set of entries of the tree and the
+// trees below it whose names differ only in case, a line listing their
+// paths, relative to the root tree.
+func findCaseCollisions(store *lib.ObjectStore, treeHash, dir string) ([]string, error) {
+	var tree types.Tree
+	if err := store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return nil, err
+	}
+	byKey := make(map[string][]string)
+	var keys []string
+	var collisions []string
 	for _, entry := range tree.Entries {
-		fullRestorePath := filepath.Join(destinationPath, entry.Name)
+		key := foldCase(entry.Name)
+		if byKey[key] == nil {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], path.Join(dir, entry.Name))
+		if entry.Type == "tree" {
+			below, err := findCaseCollisions(store, entry.Hash, path.Join(dir, entry.Name))
+			if err != nil {
+				return nil, err
+			}
+			collisions = append(collisions, below...)
+		}
+	}
+	for _, key := range keys {
+		if len(byKey[key]) > 1 {
+			collisions = append(collisions, strings.Join(byKey[key], ", "))
+		}
+	}
+	return collisions, nil
+}
 
+// collisionName returns a name for an entry named name that differs from the
+// names of taken in more than case, such as "readme (2).txt", adding it to
+// taken.
+func collisionName(name string, taken map[string]bool) string {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 2; ; n++ {
+		candidate := base + " (" + strconv.Itoa(n) + ")" + ext
+		if !taken[foldCase(candidate)] {
+			taken[foldCase(candidate)] = true
+			return candidate
+		}
+	}
+}
+
+// restorePlan records what a dry run of a restore would do, instead of
+// doing it.
+type restorePlan struct {
+	rootDir   string
+	changes   []treeChange // With paths relative to rootDir.
+	unchanged int
+	// skipConflicts leaves files that differ as they are, counting them in
+	// skipped rather than changes.
+	skipConflicts bool
+	skipped       int
+}
+
+// file records the file of a tree entry, of the given size, restored at
+// path, as created, overwriting what is there, or left unchanged.
+func (p *restorePlan) file(path string, entry types.TreeEntry, size int64) {
+	change := treeChange{kind: changeAdded, path: p.rel(path), entry: entry, size: size}
+	if info, err := os.Lstat(path); err == nil {
+		if entry.Type == "blob" && matchesSnap(info, entry.ModTime, size) {
+			p.unchanged++
+			return
+		}
+		if p.skipConflicts {
+			p.skipped++
+			return
+		}
+		change.kind, change.oldSize = changeModified, pathSize(path)
+	}
+	p.changes = append(p.changes, change)
+}
+
+// remove records the file or directory at path as deleted.
+func (p *restorePlan) remove(path string, isDir bool) {
+	entry := types.TreeEntry{Type: "blob"}
+	if isDir {
+		entry.Type = "tree"
+	}
+	p.changes = append(p.changes, treeChange{kind: changeRemoved, path: p.rel(path), entry: entry, size: pathSize(path)})
+}
+
+// rel returns path relative to the output directory, with forward slashes.
+func (p *restorePlan) rel(path string) string {
+	return relativeSlashPath(p.rootDir, path)
+}
+
+// print prints one line for each file the restore would create, overwrite,
+// or delete, then how many of each and their sizes.
+func (p *restorePlan) print() {
+	counts := make(map[string]int)
+	sizes := make(map[string]int64)
+	for _, change := range p.changes {
+		name := change.path
+		if change.entry.Type == "tree" {
+			name += "/"
+		}
+		if change.kind == changeModified {
+			fmt.Printf("%s %s (%s -> %s)\n", change.kind, name, formatBytes(change.oldSize, 2), formatBytes(change.size, 2))
+		} else {
+			fmt.Printf("%s %s (%s)\n", change.kind, name, formatBytes(change.size, 2))
+		}
+		counts[change.kind]++
+		sizes[change.kind] += change.size
+	}
+	fmt.Printf("\nWould create %d file(s) (%s), overwrite %d (%s), and delete %d (%s); %d unchanged",
+		counts[changeAdded], formatBytes(sizes[changeAdded], 2),
+		counts[changeModified], formatBytes(sizes[changeModified], 2),
+		counts[changeRemoved], formatBytes(sizes[changeRemoved], 2),
+		p.unchanged)
+	if p.skipped > 0 {
+		fmt.Printf(", %d skipped as they differ", p.skipped)
+	}
+	fmt.Println(".")
+}
+
+// pathSize returns the size of the file at path, or of the files below the
+// directory at path, counting what cannot be read as empty.
+func pathSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// treeRestorer reconstructs the directories of a snap, sending their files
+// to the restore workers and recording what can only be applied once every
+// file is written.
+type treeRestorer struct {
+	store *lib.ObjectStore
+	jobs  chan<- fileRestoreJob
+	links *hardLinks
+	// preserveOwner and restoreACL tell whether to record owners and acls.
+	preserveOwner, restoreACL bool
+	owners                    []ownedPath
+	acls                      []aclPath
+	// dirTimes holds each directory after its own subdirectories, since
+	// writing into a directory changes its modification time.
+	dirTimes []timedPath
+	xattrs   []xattrPath
+	// attributes are set last, as a read-only file or directory could not
+	// be changed after.
+	attributes []attributedPath
+	// renameCollisions gives entries whose names differ only in case from
+	// an earlier entry's other names.
+	renameCollisions bool
+	// filter selects the entries to restore; nil restores every entry.
+	filter *lib.PathFilter
+	// deleteExtras removes what the directories restored hold beyond their
+	// entries, except what a snap of rootDir would leave out, such as the
+	// repository itself.
+	deleteExtras bool
+	rootDir      string
+	repoDirs     []string
+	// foldNames compares names as a case-insensitive filesystem does.
+	foldNames bool
+	stats     *restoreStats
+	// plan, in a dry run, records what would be written and removed, and
+	// nothing is.
+	plan *restorePlan
+	// restored lists the files restored, to be verified, when verify is set.
+	verify   bool
+	restored []restoredFile
+	// onConflict is the policy for differing files in the way.
+	onConflict string
+}
+
+// restoreSpecialFile recreates a FIFO, socket, or device node, reporting
+// whether it did. Device nodes are only recreated when running as root, and
+// a special file that cannot be created is skipped with a warning, as the
+// regular files matter more.
+func (r *treeRestorer) restoreSpecialFile(entry types.TreeEntry, path string) bool {
+	if lib.IsDeviceType(entry.Type) && os.Geteuid() != 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipping device node %s, as creating device nodes takes root\n", path)
+		return false
+	}
+	if err := os.RemoveAll(path); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not replace %s: %v\n", path, err)
+		return false
+	}
+	if err := lib.MakeSpecialFile(path, entry.Type, os.FileMode(entry.Mode), entry.Rdev); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not create %s %s: %v\n", entry.Type, path, err)
+		return false
+	}
+	if err := os.Chmod(path, os.FileMode(entry.Mode)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not set mode on %s: %v\n", path, err)
+	}
+	restoreTimes(path, entry.BirthTime, entry.ModTime)
+	return true
+}
+
+// restoreEntry reconstructs the file, directory, or special file of a tree
+// entry, found at snapPath in the snap, at fullRestorePath. included tells
+// whether an include pattern matched a directory above it. It reports
+// whether the entry was restored rather than filtered out.
+func (r *treeRestorer) restoreEntry(entry types.TreeEntry, fullRestorePath, snapPath string, included bool) (bool, error) {
+	isDir := entry.Type == "tree"
+	if r.filter.Excludes(snapPath, isDir) {
+		return false, nil
+	}
+	included = included || r.filter.Includes(snapPath, isDir)
+	if isDir {
+		// A directory is only kept when something below it is, or when it
+		// is included itself, so that filtering a snap restores no empty
+		// directories.
+		_, statErr := os.Lstat(fullRestorePath)
+		kept, err := r.restoreTree(entry.Hash, fullRestorePath, snapPath, included)
+		if err != nil {
+			return false, err
+		}
+		if !kept && !included {
+			if os.IsNotExist(statErr) && r.plan == nil {
+				return false, os.Remove(fullRestorePath)
+			}
+			return false, nil
+		}
+	} else if !included {
+		return false, nil
+	}
+
+	if r.plan != nil {
+		if isDir {
+			return true, nil
+		}
+		var size int64
 		if entry.Type == "blob" {
-			// For files, send a job to the worker pool.
-			jobs <- fileRestoreJob{
-				ManifestHash:    entry.Hash,
-				DestinationPath: fullRestorePath,
-				Mode:            os.FileMode(entry.Mode),
+			var manifest types.FileManifest
+			if err := r.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+				return false, fmt.Errorf("failed to read manifest %s for %s: %w", entry.Hash, fullRestorePath, err)
 			}
-		} else if entry.Type == "tree" {
-			// For directories, recurse synchronously.
-			if err := restoreTree(store, entry.Hash, fullRestorePath, jobs); err != nil {
-				return err
+			size = manifest.TotalSize
+		}
+		r.plan.file(fullRestorePath, entry, size)
+		return true, nil
+	}
+
+	if entry.Type != "blob" && !isDir && !r.restoreSpecialFile(entry, fullRestorePath) {
+		return false, nil
+	}
+	if r.preserveOwner && entry.Owner != nil {
+		r.owners = append(r.owners, ownedPath{path: fullRestorePath, owner: *entry.Owner})
+	}
+	if r.restoreACL && entry.ACL != nil {
+		r.acls = append(r.acls, aclPath{path: fullRestorePath, acl: *entry.ACL})
+	}
+	if entry.Xattrs != nil {
+		r.xattrs = append(r.xattrs, xattrPath{path: fullRestorePath, xattrs: entry.Xattrs})
+	}
+	if entry.Attributes != 0 {
+		r.attributes = append(r.attributes, attributedPath{path: fullRestorePath, attributes: entry.Attributes})
+	}
+
+	if entry.Type == "blob" {
+		if r.verify {
+			r.restored = append(r.restored, restoredFile{path: fullRestorePath, manifestHash: entry.Hash})
+		}
+		if r.links.add(fullRestorePath, entry) {
+			return true, nil
+		}
+		// For files, send a job to the worker pool.
+		r.stats.queued.Add(1)
+		r.jobs <- fileRestoreJob{
+			ManifestHash:    entry.Hash,
+			DestinationPath: fullRestorePath,
+			Mode:            os.FileMode(entry.Mode),
+			ModTime:         entry.ModTime,
+			BirthTime:       entry.BirthTime,
+			Streams:         entry.Streams,
+			OnConflict:      r.onConflict,
+		}
+	} else if isDir {
+		// Directories were restored above, synchronously.
+		r.dirTimes = append(r.dirTimes, timedPath{path: fullRestorePath, birthTime: entry.BirthTime, modTime: entry.ModTime})
+		// Set permissions on the directory after its contents are processed.
+		if err := os.Chmod(fullRestorePath, os.FileMode(entry.Mode)); err != nil {
+			// Log a warning, as this is often not a critical failure.
+			fmt.Fprintf(os.Stderr, "Warning: could not set mode on directory %s: %v\n", fullRestorePath, err)
+		}
+	}
+	return true, nil
+}
+
+// removeExtras removes what the directory at destinationPath holds besides
+// the given paths, leaving what a snap would leave out.
+func (r *treeRestorer) removeExtras(destinationPath string, restorePaths []string) error {
+	key := func(name string) string {
+		if r.foldNames {
+			return foldCase(name)
+		}
+		return name
+	}
+	keep := make(map[string]bool, len(restorePaths))
+	for _, p := range restorePaths {
+		keep[key(filepath.Base(p))] = true
+	}
+
+	existing, err := os.ReadDir(destinationPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", destinationPath, err)
+	}
+	for _, e := range existing {
+		extra := filepath.Join(destinationPath, e.Name())
+		if keep[key(e.Name())] || isExcluded(r.rootDir, r.repoDirs, extra) {
+			continue
+		}
+		if r.plan != nil {
+			r.plan.remove(extra, e.IsDir())
+			continue
+		}
+		if err := os.RemoveAll(extra); err != nil {
+			return fmt.Errorf("failed to remove %s, which the snap does not hold: %w", extra, err)
+		}
+		r.stats.removed.Add(1)
+	}
+	return nil
+}
+
+// parentsIncluded tells whether an include pattern matches a directory above
+// subPath, which includes it as much as one matching subPath itself.
+func parentsIncluded(filter *lib.PathFilter, subPath string) bool {
+	for dir := path.Dir(subPath); dir != "."; dir = path.Dir(dir) {
+		if filter.Includes(dir, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// restoreTree recursively reconstructs a directory, found at snapPath in the
+// snap, from a tree object. It reports whether any of its entries were
+// restored.
+func (r *treeRestorer) restoreTree(treeHash, destinationPath, snapPath string, included bool) (bool, error) {
+	treeBuffer, err := r.store.ReadObjectAsBuffer(treeHash)
+	if err != nil {
+		return false, err
+	}
+	var tree types.Tree
+	if err := json.Unmarshal(treeBuffer, &tree); err != nil {
+		return false, err
+	}
+
+	// Ensure the destination directory exists, replacing a file in its way.
+	info, err := os.Lstat(destinationPath)
+	existed := err == nil && info.IsDir()
+	if r.plan != nil {
+		if err == nil && !info.IsDir() {
+			r.plan.remove(destinationPath, false)
+		}
+	} else {
+		if err == nil && !info.IsDir() {
+			if err := os.Remove(destinationPath); err != nil {
+				return false, err
 			}
-			// Set permissions on the directory after its contents are processed.
-			if err := os.Chmod(fullRestorePath, os.FileMode(entry.Mode)); err != nil {
-				// Log a warning, as this is often not a critical failure.
-				fmt.Fprintf(os.Stderr, "Warning: could not set mode on directory %s: %v\n", fullRestorePath, err)
+		}
+		if err := os.MkdirAll(destinationPath, 0755); err != nil {
+			return false, err
+		}
+	}
+
+	// names holds every name of the directory, and seen those restored so
+	// far, folded, so that a renamed entry takes a name no other has.
+	var names, seen map[string]bool
+	if r.renameCollisions {
+		names, seen = make(map[string]bool), make(map[string]bool)
+		for _, entry := range tree.Entries {
+			names[foldCase(entry.Name)] = true
+		}
+	}
+
+	restorePaths := make([]string, len(tree.Entries))
+	for i, entry := range tree.Entries {
+		fullRestorePath := filepath.Join(destinationPath, entry.Name)
+		if r.renameCollisions {
+			if seen[foldCase(entry.Name)] {
+				fullRestorePath = filepath.Join(destinationPath, collisionName(entry.Name, names))
+				fmt.Fprintf(os.Stderr, "Warning: restoring %s as %s, as its name differs only in case from another's\n",
+					filepath.Join(destinationPath, entry.Name), filepath.Base(fullRestorePath))
 			}
+			seen[foldCase(filepath.Base(fullRestorePath))] = true
 		}
+		restorePaths[i] = fullRestorePath
 	}
-	return nil
+
+	// Extras are removed before anything is written, so that on a
+	// case-insensitive filesystem a file whose name changed case is not
+	// removed after being written under its new name.
+	if r.deleteExtras && (existed || r.plan == nil) {
+		if err := r.removeExtras(destinationPath, restorePaths); err != nil {
+			return false, err
+		}
+	}
+
+	kept := false
+	for i, entry := range tree.Entries {
+		restored, err := r.restoreEntry(entry, restorePaths[i], path.Join(snapPath, entry.Name), included)
+		if err != nil {
+			return false, err
+		}
+		kept = kept || restored
+	}
+	return kept, nil
 }
 
 // Restore is the main function for the 'restore' command.
-func Restore(sourceDir, snapIdentifier, outputDir string) error {
+func Restore(sourceDir string, options RestoreOptions) error {
 	absSourceDir, err := filepath.Abs(sourceDir)
 	if err != nil {
 		return fmt.Errorf("could not resolve source path: %w", err)
 	}
-	absOutputDir, err := filepath.Abs(outputDir)
+	absOutputDir, err := filepath.Abs(options.OutputDir)
 	if err != nil {
 		return fmt.Errorf("could not resolve output path: %w", err)
 	}
 
-	store := lib.NewObjectStore(absSourceDir)
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
 
 	// 1. Find the exact snapshot to restore.
-	snapToRestore, err := lib.FindSnap(absSourceDir, snapIdentifier)
+	snapToRestore, err := store.FindSnap(options.SnapIdentifier)
 	if err != nil {
-		return fmt.Errorf("failed to find snapshot %s to restore: %w", snapIdentifier, err)
+		return fmt.Errorf("failed to find snapshot %s to restore: %w", options.SnapIdentifier, err)
+	}
+	if options.Stdout {
+		if cleanSnapPath(options.Path) == "" {
+			return fmt.Errorf("restoring to stdout takes the path of a file in the snap")
+		}
+		return printSnapFile(store, snapToRestore, options.Path)
+	}
+	if options.VerifyOnly {
+		if options.Path != "" || len(options.Include) > 0 || len(options.Exclude) > 0 || options.Tar != "" || options.DryRun {
+			return fmt.Errorf("verifying only compares the whole directory, and takes none of --path, --include, --exclude, --tar, or --dry-run")
+		}
+		return verifyDirectory(store, snapToRestore, absOutputDir)
+	}
+
+	onConflict := options.OnConflict
+	switch onConflict {
+	case "":
+		onConflict = ConflictOverwrite
+	case ConflictOverwrite, ConflictSkip, ConflictFail, ConflictRename:
+	default:
+		return fmt.Errorf("unsupported conflict policy %q; use %s, %s, %s, or %s", onConflict, ConflictOverwrite, ConflictSkip, ConflictFail, ConflictRename)
+	}
+
+	// Resolve the part of the snap to restore, reading only the trees on
+	// the way to it.
+	subPath := cleanSnapPath(options.Path)
+	entry, err := findSnapEntry(store, snapToRestore.RootTreeHash, subPath)
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", options.Path, snapToRestore.ID, err)
+	}
+	destination := filepath.Join(absOutputDir, filepath.FromSlash(subPath))
+	if options.Tar != "" {
+		return exportTar(store, snapToRestore, entry, subPath, options.Tar, lib.NewPathFilter(options.Include, options.Exclude))
 	}
 
 	// 2. Validate and prepare the output directory.
@@ -126,15 +1033,102 @@ func Restore(sourceDir, snapIdentifier, outputDir string) error {
 		return fmt.Errorf("could not stat output directory: %w", err)
 	}
 
-	// Clean the output directory before restoring.
-	if err := os.RemoveAll(absOutputDir); err != nil {
-		return fmt.Errorf("failed to clean output directory: %w", err)
+	// A filesystem that does not tell names apart by case would write the
+	// entries whose names differ only in case over each other, so they are
+	// found before anything is removed.
+	probeDir := absOutputDir
+	for {
+		if _, err := os.Stat(probeDir); err == nil || filepath.Dir(probeDir) == probeDir {
+			break
+		}
+		probeDir = filepath.Dir(probeDir)
+	}
+	insensitive, err := caseInsensitive(probeDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not tell whether %s tells names apart by case: %v\n", probeDir, err)
+	}
+	if insensitive && !options.RenameCollisions && entry.Type == "tree" {
+		collisions, err := findCaseCollisions(store, entry.Hash, subPath)
+		if err != nil {
+			return fmt.Errorf("failed to check for names that differ only in case: %w", err)
+		}
+		if len(collisions) > 0 {
+			return fmt.Errorf("snap %d holds names that differ only in case, which the filesystem of %s cannot tell apart:\n  %s\n"+
+				"restore to a case-sensitive filesystem, or pass --rename-collisions to restore them under other names",
+				snapToRestore.ID, absOutputDir, strings.Join(collisions, "\n  "))
+		}
+	}
+
+	stats := &restoreStats{}
+	restorer := &treeRestorer{
+		store:            store,
+		links:            &hardLinks{first: make(map[string]string)},
+		preserveOwner:    options.PreserveOwner,
+		restoreACL:       options.ACLs,
+		renameCollisions: insensitive && options.RenameCollisions,
+		filter:           lib.NewPathFilter(options.Include, options.Exclude),
+		rootDir:          absOutputDir,
+		repoDirs:         localRepoDirs(store),
+		foldNames:        insensitive,
+		stats:            stats,
+		verify:           options.Verify,
+		onConflict:       onConflict,
 	}
-	if err := os.MkdirAll(absOutputDir, 0755); err != nil {
-		return fmt.Errorf("failed to recreate output directory: %w", err)
+	// A filtered restore leaves what it filters out alone, so it removes
+	// nothing.
+	restorer.deleteExtras = restorer.filter == nil && !options.NoDelete
+	restoreSnapPath := func() error {
+		if subPath == "" {
+			_, err := restorer.restoreTree(snapToRestore.RootTreeHash, absOutputDir, "", !restorer.filter.HasIncludes())
+			return err
+		}
+		_, err := restorer.restoreEntry(entry, destination, subPath, parentsIncluded(restorer.filter, subPath))
+		return err
 	}
 
-	fmt.Printf("💧 Restoring snap %d (%s) to \"%s\"...\n", snapToRestore.ID, snapToRestore.Hash[:7], absOutputDir)
+	if options.DryRun {
+		fmt.Printf("🔍 Restoring snap %d (%s) to \"%s\" would make these changes:\n", snapToRestore.ID, snapToRestore.Hash[:7], absOutputDir)
+		restorer.plan = &restorePlan{rootDir: absOutputDir, skipConflicts: onConflict == ConflictSkip}
+		if err := restoreSnapPath(); err != nil {
+			return fmt.Errorf("failed during tree traversal: %w", err)
+		}
+		restorer.plan.print()
+		return nil
+	}
+
+	// Find the files that differ first, so that nothing is written when
+	// there are any.
+	if onConflict == ConflictFail {
+		restorer.plan = &restorePlan{rootDir: absOutputDir}
+		if err := restoreSnapPath(); err != nil {
+			return fmt.Errorf("failed during tree traversal: %w", err)
+		}
+		var conflicts []string
+		for _, change := range restorer.plan.changes {
+			if change.kind == changeModified {
+				conflicts = append(conflicts, change.path)
+			}
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%d file(s) of %s differ from snap %d:\n  %s\n"+
+				"pass --on-conflict overwrite, skip, or rename to restore anyway",
+				len(conflicts), absOutputDir, snapToRestore.ID, strings.Join(conflicts, "\n  "))
+		}
+		restorer.plan = nil
+	}
+
+	// The output directory is brought in line with the snap rather than
+	// cleaned, so that files it already holds unchanged are not written
+	// again.
+	if err := os.MkdirAll(filepath.Dir(destination), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+
+	if subPath == "" {
+		fmt.Printf("💧 Restoring snap %d (%s) to \"%s\"...\n", snapToRestore.ID, snapToRestore.Hash[:7], absOutputDir)
+	} else {
+		fmt.Printf("💧 Restoring %s from snap %d (%s) to \"%s\"...\n", subPath, snapToRestore.ID, snapToRestore.Hash[:7], destination)
+	}
 
 	// 3. Set up the worker pool.
 	jobs := make(chan fileRestoreJob, 100) // Buffered channel
@@ -144,19 +1138,32 @@ func Restore(sourceDir, snapIdentifier, outputDir string) error {
 
 	for w := 0; w < numWorkers; w++ {
 		wg.Add(1)
-		go restoreFileWorker(&wg, store, jobs, errs)
+		go restoreFileWorker(&wg, store, jobs, errs, stats)
 	}
 
+	// The size of a whole snap is known up front; that of a part of it is
+	// not.
+	var totalBytes int64
+	if subPath == "" && restorer.filter == nil {
+		totalBytes = snapToRestore.SourceSize
+	}
+	progress := startRestoreProgress(stats, totalBytes)
+
 	// 4. Start the recursive tree traversal.
 	// This will populate the jobs channel.
-	err = restoreTree(store, snapToRestore.RootTreeHash, absOutputDir, jobs)
+	restorer.jobs = jobs
+	err = restoreSnapPath()
 	close(jobs) // Signal that no more jobs will be sent.
+	stats.traversed.Store(true)
 	if err != nil {
+		wg.Wait()
+		progress.finish()
 		return fmt.Errorf("failed during tree traversal: %w", err)
 	}
 
 	// 5. Wait for all workers to finish.
 	wg.Wait()
+	progress.finish()
 	close(errs) // Close the errors channel after workers are done.
 
 	// 6. Check if any worker reported an error.
@@ -167,6 +1174,58 @@ func Restore(sourceDir, snapIdentifier, outputDir string) error {
 		}
 	}
 
+	// 7. Link the files that were hardlinked together.
+	if err := restorer.links.create(restorer.onConflict, stats); err != nil {
+		return err
+	}
+
+	// 8. Give files and directories back to their owners, and their ACLs
+	// and extended attributes.
+	if err := restoreOwners(restorer.owners); err != nil {
+		return err
+	}
+	if err := restoreACLs(restorer.acls); err != nil {
+		return err
+	}
+	for _, x := range restorer.xattrs {
+		if err := lib.SetFileXattrs(x.path, x.xattrs); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not restore the Finder info or quarantine of %s: %v\n", x.path, err)
+		}
+	}
+
+	// 9. Set the times of directories, now that nothing more is written
+	// into them.
+	for _, dir := range restorer.dirTimes {
+		restoreTimes(dir.path, dir.birthTime, dir.modTime)
+	}
+
+	// 10. Set the Windows attributes, now that nothing more is changed.
+	for _, a := range restorer.attributes {
+		if err := lib.SetFileAttributes(a.path, a.attributes); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not set attributes on %s: %v\n", a.path, err)
+		}
+	}
+
+	fmt.Printf("   - Wrote %d file(s), left %d unchanged, removed %d extra path(s).\n",
+		stats.written.Load(), stats.unchanged.Load(), stats.removed.Load())
+	if skipped := stats.skipped.Load(); skipped > 0 {
+		fmt.Printf("   - Skipped %d file(s) that differ from the snap.\n", skipped)
+	}
+
+	// 11. Read the restored files back and check them against the snap.
+	if options.Verify {
+		fmt.Printf("🔍 Verifying %d restored file(s)...\n", len(restorer.restored))
+		var problems []string
+		for _, problem := range verifyRestoredFiles(store, restorer.restored) {
+			if problem != nil {
+				problems = append(problems, problem.Error())
+			}
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("%d restored file(s) do not match snap %d:\n  %s", len(problems), snapToRestore.ID, strings.Join(problems, "\n  "))
+		}
+		fmt.Println("   - Every restored file matches the snap.")
+	}
 	fmt.Println("✅ Restore complete!")
 	return nil
 }
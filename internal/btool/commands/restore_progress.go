@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// How often restore reports its progress: in place on a terminal, and as
+// lines of their own otherwise, so that logs are not flooded.
+const (
+	progressTerminalInterval = 250 * time.Millisecond
+	progressLogInterval      = 10 * time.Second
+)
+
+// restoreProgress reports how far the restore workers have got while they
+// run, from the counts in restoreStats.
+type restoreProgress struct {
+	stats *restoreStats
+	// totalBytes is the size of what is restored, or 0 if it is not known
+	// up front, as when only part of a snap is restored.
+	totalBytes int64
+	start      time.Time
+	terminal   bool
+	lastWidth  int // Of the line last printed in place.
+	stop, done chan struct{}
+}
+
+// startRestoreProgress starts reporting the progress of a restore, until
+// finish is called.
+func startRestoreProgress(stats *restoreStats, totalBytes int64) *restoreProgress {
+	p := &restoreProgress{
+		stats:      stats,
+		totalBytes: totalBytes,
+		start:      time.Now(),
+		terminal:   isTerminal(int(os.Stdout.Fd())),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	interval := progressLogInterval
+	if p.terminal {
+		interval = progressTerminalInterval
+	}
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.print()
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// print prints the current progress, over the last line on a terminal.
+func (p *restoreProgress) print() {
+	line := formatRestoreProgress(p.stats.done(), p.stats.queued.Load(), p.stats.traversed.Load(),
+		p.stats.bytes.Load(), p.totalBytes, time.Since(p.start))
+	if !p.terminal {
+		fmt.Println(line)
+		return
+	}
+	width := len(line)
+	if width < p.lastWidth {
+		line += strings.Repeat(" ", p.lastWidth-width)
+	}
+	p.lastWidth = width
+	fmt.Print("\r" + line)
+}
+
+// finish stops reporting progress, printing where it ended up on a
+// terminal.
+func (p *restoreProgress) finish() {
+	close(p.stop)
+	<-p.done
+	if p.terminal {
+		p.print()
+		fmt.Println()
+	}
+}
+
+// formatRestoreProgress describes the progress of a restore that has
+// finished done of the queued files, and of the bytes in totalBytes, in
+// elapsed. The ETA is estimated from the bytes if their total is known, and
+// from the files once every file is queued otherwise.
+func formatRestoreProgress(done, queued int64, traversed bool, bytes, totalBytes int64, elapsed time.Duration) string {
+	files := fmt.Sprintf("%d/%d", done, queued)
+	if !traversed {
+		files += "+"
+	}
+	line := fmt.Sprintf("   - Files: %s, %s", files, formatBytes(bytes, 2))
+	if totalBytes > 0 {
+		line += " of " + formatBytes(totalBytes, 2)
+	}
+	line += " written"
+
+	var fraction float64
+	switch {
+	case totalBytes > 0:
+		fraction = float64(bytes) / float64(totalBytes)
+	case traversed && queued > 0:
+		fraction = float64(done) / float64(queued)
+	}
+	if fraction > 0 && fraction < 1 {
+		remaining := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		line += ", ETA " + remaining.Round(time.Second).String()
+	}
+	return line
+}
+
+// done is the number of files the workers have finished with.
+func (s *restoreStats) done() int64 {
+	return s.written.Load() + s.unchanged.Load() + s.skipped.Load()
+}
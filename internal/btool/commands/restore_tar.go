@@ -0,0 +1,226 @@
+package commands
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// tarExporter writes the entries of a snap to a tar archive, in the PAX
+// format, so that long names and times to the nanosecond are kept.
+type tarExporter struct {
+	store  *lib.ObjectStore
+	writer *tar.Writer
+	filter *lib.PathFilter
+	// snapTime stands in for the modification times of entries of snaps
+	// taken before times were recorded.
+	snapTime time.Time
+	// links holds the archive name of the first file of each group of
+	// hardlinked files, by link.
+	links map[string]string
+	// pending holds the headers of the directories entered whose entries
+	// are yet to be written, so that a filtered export writes no empty
+	// directories.
+	pending []*tar.Header
+	files   int
+}
+
+// header returns the tar header of a tree entry archived under name,
+// without its type and size.
+func (e *tarExporter) header(entry types.TreeEntry, name string) *tar.Header {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    int64(entry.Mode),
+		ModTime: e.snapTime,
+		Format:  tar.FormatPAX,
+	}
+	if entry.ModTime != 0 {
+		header.ModTime = time.Unix(0, entry.ModTime)
+	}
+	if entry.Owner != nil {
+		header.Uid, header.Gid = int(entry.Owner.UID), int(entry.Owner.GID)
+		header.Uname, header.Gname = entry.Owner.User, entry.Owner.Group
+	}
+	for key, value := range entry.Xattrs {
+		if header.PAXRecords == nil {
+			header.PAXRecords = make(map[string]string)
+		}
+		header.PAXRecords["SCHILY.xattr."+key] = string(value)
+	}
+	return header
+}
+
+// flush writes the headers of the directories entered that have not been
+// written yet.
+func (e *tarExporter) flush() error {
+	for _, header := range e.pending {
+		if err := e.writer.WriteHeader(header); err != nil {
+			return err
+		}
+	}
+	e.pending = e.pending[:0]
+	return nil
+}
+
+// writeEntry archives a tree entry under name, the entry's path in the
+// snap, and everything below it. included tells whether an include pattern
+// matched a directory above it.
+func (e *tarExporter) writeEntry(entry types.TreeEntry, name string, included bool) error {
+	isDir := entry.Type == "tree"
+	if e.filter.Excludes(name, isDir) {
+		return nil
+	}
+	included = included || e.filter.Includes(name, isDir)
+	header := e.header(entry, name)
+
+	switch {
+	case isDir:
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		e.pending = append(e.pending, header)
+		if included {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+		var tree types.Tree
+		if err := e.store.ReadObjectAsJSON(entry.Hash, &tree); err != nil {
+			return fmt.Errorf("failed to read tree %s: %w", entry.Hash, err)
+		}
+		for _, child := range tree.Entries {
+			if err := e.writeEntry(child, path.Join(name, child.Name), included); err != nil {
+				return err
+			}
+		}
+		// Nothing below the directory was written.
+		if n := len(e.pending); n > 0 && e.pending[n-1] == header {
+			e.pending = e.pending[:n-1]
+		}
+		return nil
+	case !included:
+		return nil
+	case entry.Type == lib.EntryTypeSocket:
+		fmt.Fprintf(os.Stderr, "Warning: skipping socket %s, which tar archives cannot hold\n", name)
+		return nil
+	}
+
+	if err := e.flush(); err != nil {
+		return err
+	}
+	switch entry.Type {
+	case "blob":
+		if first, ok := e.links[entry.Link]; ok && entry.Link != "" {
+			header.Typeflag, header.Linkname = tar.TypeLink, first
+			return e.writer.WriteHeader(header)
+		}
+		if entry.Link != "" {
+			e.links[entry.Link] = name
+		}
+		var manifest types.FileManifest
+		if err := e.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+			return fmt.Errorf("failed to read manifest %s for %s: %w", entry.Hash, name, err)
+		}
+		header.Typeflag, header.Size = tar.TypeReg, manifest.TotalSize
+		if err := e.writer.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := writeManifest(e.store, entry.Hash, e.writer); err != nil {
+			return fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		e.files++
+		return nil
+	case lib.EntryTypeFIFO:
+		header.Typeflag = tar.TypeFifo
+	case lib.EntryTypeCharDevice, lib.EntryTypeBlockDevice:
+		header.Typeflag = tar.TypeChar
+		if entry.Type == lib.EntryTypeBlockDevice {
+			header.Typeflag = tar.TypeBlock
+		}
+		major, minor := lib.DeviceNumbers(entry.Rdev)
+		header.Devmajor, header.Devminor = int64(major), int64(minor)
+	default:
+		return fmt.Errorf("%s has unknown type %q", name, entry.Type)
+	}
+	return e.writer.WriteHeader(header)
+}
+
+// exportTar writes the entry of a snap found at subPath, the root tree if
+// empty, as a tar archive to the file at destination, or to stdout if it is
+// "-". A file named .tar.gz or .tgz is compressed with gzip. An archive that
+// could not be written whole is removed rather than left truncated.
+func exportTar(store *lib.ObjectStore, snap *lib.SnapDetail, entry types.TreeEntry, subPath, destination string, filter *lib.PathFilter) (err error) {
+	var out io.Writer = os.Stdout
+	var file *os.File
+	if destination != "-" {
+		if file, err = os.Create(destination); err != nil {
+			return fmt.Errorf("failed to create %s: %w", destination, err)
+		}
+		defer func() {
+			file.Close()
+			if err != nil {
+				os.Remove(destination)
+			}
+		}()
+		out = file
+		fmt.Printf("📦 Writing snap %d (%s) to \"%s\"...\n", snap.ID, snap.Hash[:7], destination)
+	}
+	buffered := bufio.NewWriterSize(out, restoreBufferSize)
+	out = buffered
+	var compressor *gzip.Writer
+	if strings.HasSuffix(destination, ".tar.gz") || strings.HasSuffix(destination, ".tgz") {
+		compressor = gzip.NewWriter(buffered)
+		out = compressor
+	}
+
+	exporter := &tarExporter{
+		store:    store,
+		writer:   tar.NewWriter(out),
+		filter:   filter,
+		snapTime: snap.Timestamp,
+		links:    make(map[string]string),
+	}
+	if subPath == "" {
+		var tree types.Tree
+		if err := store.ReadObjectAsJSON(entry.Hash, &tree); err != nil {
+			return fmt.Errorf("failed to read tree %s: %w", entry.Hash, err)
+		}
+		for _, child := range tree.Entries {
+			if err = exporter.writeEntry(child, child.Name, false); err != nil {
+				break
+			}
+		}
+	} else {
+		err = exporter.writeEntry(entry, subPath, parentsIncluded(filter, subPath))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write tar archive: %w", err)
+	}
+
+	if err := exporter.writer.Close(); err != nil {
+		return fmt.Errorf("failed to write tar archive: %w", err)
+	}
+	if compressor != nil {
+		if err := compressor.Close(); err != nil {
+			return fmt.Errorf("failed to write tar archive: %w", err)
+		}
+	}
+	if err := buffered.Flush(); err != nil {
+		return fmt.Errorf("failed to write tar archive: %w", err)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to write tar archive: %w", err)
+		}
+		fmt.Printf("✅ Wrote %d file(s) to %s.\n", exporter.files, destination)
+	}
+	return nil
+}
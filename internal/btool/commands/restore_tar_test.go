@@ -0,0 +1,125 @@
+package commands_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTar returns the headers and file contents of a tar archive, by name.
+func readTar(t *testing.T, r io.Reader) (map[string]*tar.Header, map[string]string) {
+	t.Helper()
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[header.Name] = header
+		content, err := io.ReadAll(reader)
+		require.NoError(t, err)
+		contents[header.Name] = string(content)
+	}
+	return headers, contents
+}
+
+func TestRestoreTar(t *testing.T) {
+	t.Run("should write the snap as a tar archive keeping paths and modes", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		archive := filepath.Join(t.TempDir(), "snap.tar")
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", Tar: archive})
+		require.NoError(t, err)
+
+		// Assert
+		file, err := os.Open(archive)
+		require.NoError(t, err)
+		defer file.Close()
+		headers, contents := readTar(t, file)
+		require.Contains(t, headers, "fileA.txt")
+		require.Contains(t, headers, "subdir/")
+		assert.Equal(t, byte(tar.TypeDir), headers["subdir/"].Typeflag)
+		assert.Equal(t, "restore me", contents["fileA.txt"])
+		assert.Equal(t, "me too", contents["subdir/fileB.txt"])
+		if runtime.GOOS != "windows" {
+			assert.Equal(t, int64(0744), headers["fileA.txt"].Mode)
+		}
+	})
+
+	t.Run("should compress an archive named .tar.gz and narrow it to the path", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		archive := filepath.Join(t.TempDir(), "snap.tar.gz")
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", Tar: archive, Path: "subdir"})
+		require.NoError(t, err)
+
+		// Assert
+		file, err := os.Open(archive)
+		require.NoError(t, err)
+		defer file.Close()
+		gz, err := gzip.NewReader(file)
+		require.NoError(t, err)
+		headers, contents := readTar(t, gz)
+		assert.Len(t, headers, 2)
+		assert.Equal(t, "me too", contents["subdir/fileB.txt"])
+	})
+
+	t.Run("should archive hardlinked files as links to the first", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("hardlinks are only snapped on Unix systems")
+		}
+		// Arrange
+		sourceDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), []byte("shared"), 0644))
+		require.NoError(t, os.Link(filepath.Join(sourceDir, "a.txt"), filepath.Join(sourceDir, "b.txt")))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", Tar: "-"})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		headers, contents := readTar(t, strings.NewReader(output))
+		assert.Equal(t, "shared", contents["a.txt"])
+		assert.Equal(t, byte(tar.TypeLink), headers["b.txt"].Typeflag)
+		assert.Equal(t, "a.txt", headers["b.txt"].Linkname)
+	})
+
+	t.Run("should remove the archive when it cannot be written whole", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		packs, err := os.ReadDir(lib.GetPacksDir(sourceDir))
+		require.NoError(t, err)
+		for _, pack := range packs {
+			require.NoError(t, os.Remove(filepath.Join(lib.GetPacksDir(sourceDir), pack.Name())))
+		}
+		archive := filepath.Join(t.TempDir(), "snap.tar")
+
+		// Act
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", Tar: archive})
+
+		// Assert
+		require.Error(t, err)
+		assert.NoFileExists(t, archive)
+	})
+}
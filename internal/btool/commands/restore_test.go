@@ -1,12 +1,16 @@
 package commands_test
 
 import (
+	"crypto/rand"
 	"encoding/json"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"runtime/metrics"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
@@ -39,7 +43,7 @@ func setupRestoreTest(t *testing.T) (sourceDir string) {
 	require.NoError(t, err, "Failed to write nested test file")
 
 	// Create the snapshot.
-	err = commands.Snap(sourceDir, "restore test snap")
+	err = commands.Snap(sourceDir, commands.SnapOptions{Message: "restore test snap"})
 	require.NoError(t, err, "Setup failed: snap command failed")
 
 	return sourceDir
@@ -99,7 +103,7 @@ func TestRestoreCommand(t *testing.T) {
 		snapID := 1              // The first snapshot created always has ID 1.
 
 		// Act
-		err := commands.Restore(sourceDir, strconv.Itoa(snapID), outputDir)
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: strconv.Itoa(snapID), OutputDir: outputDir})
 		require.NoError(t, err, "commands.Restore() returned an unexpected error")
 
 		// Assert: The contents of the original sourceDir and the outputDir should be identical.
@@ -114,7 +118,7 @@ func TestRestoreCommand(t *testing.T) {
 		snapID := 1
 
 		// Act
-		err := commands.Restore(sourceDir, strconv.Itoa(snapID), nonExistentOutputDir)
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: strconv.Itoa(snapID), OutputDir: nonExistentOutputDir})
 		require.NoError(t, err, "commands.Restore() returned an unexpected error")
 
 		// Assert
@@ -132,7 +136,7 @@ func TestRestoreCommand(t *testing.T) {
 		snapID := 1
 
 		// Act
-		err = commands.Restore(sourceDir, strconv.Itoa(snapID), outputFile)
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: strconv.Itoa(snapID), OutputDir: outputFile})
 
 		// Assert
 		require.Error(t, err, "Expected an error when output path is a file, but got nil")
@@ -147,7 +151,7 @@ func TestRestoreCommand(t *testing.T) {
 		nonExistentSnapID := "999"
 
 		// Act
-		err := commands.Restore(sourceDir, nonExistentSnapID, outputDir)
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: nonExistentSnapID, OutputDir: outputDir})
 
 		// Assert
 		require.Error(t, err, "Expected an error for a non-existent snapshot, but got nil")
@@ -162,7 +166,7 @@ func TestRestoreCommand(t *testing.T) {
 		err := os.WriteFile(fileToKeepPath, []byte("i should exist"), 0644)
 		require.NoError(t, err, "failed to write file to keep")
 
-		err = commands.Snap(sourceDir, "snap with one file")
+		err = commands.Snap(sourceDir, commands.SnapOptions{Message: "snap with one file"})
 		require.NoError(t, err, "snap failed")
 
 		// Prepare the restore destination with an extra file
@@ -172,7 +176,7 @@ func TestRestoreCommand(t *testing.T) {
 		require.NoError(t, err, "failed to write file to delete")
 
 		// Act
-		err = commands.Restore(sourceDir, "1", restoreDir)
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: restoreDir})
 		require.NoError(t, err, "Restore command failed")
 
 		// Assert
@@ -183,14 +187,268 @@ func TestRestoreCommand(t *testing.T) {
 		assert.NoFileExists(t, fileToDeletePath, "Extraneous file was not deleted from the restore directory")
 	})
 
+	t.Run("should write a large file as its chunks are read, without holding it in memory", func(t *testing.T) {
+		if testing.Short() {
+			t.Skip("snaps and restores a 192 MiB file")
+		}
+		// Arrange
+		lib.ResetObjectStoreState()
+		sourceDir := t.TempDir()
+		// The file is larger than the cache of recently read objects, which
+		// holds up to 64 MiB, so the cache alone cannot account for holding it.
+		const size = 192 * 1024 * 1024
+		content := make([]byte, size)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "disk.img"), content, 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		content = nil
+		lib.ResetObjectStoreState()
+		outputDir := t.TempDir()
+		// Pools keep their buffers through one collection.
+		runtime.GC()
+		runtime.GC()
+		// The live heap is measured by each garbage collection, so sampling
+		// it neither stops the world nor counts garbage not yet collected.
+		live := []metrics.Sample{{Name: "/gc/heap/live:bytes"}}
+		metrics.Read(live)
+		before := live[0].Value.Uint64()
+
+		// Act: sample the live heap while restoring, to find its peak.
+		done := make(chan struct{})
+		peak := make(chan uint64)
+		go func() {
+			var highest uint64
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				metrics.Read(live)
+				highest = max(highest, live[0].Value.Uint64())
+				select {
+				case <-done:
+					peak <- highest
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+		close(done)
+		grown := int64(<-peak) - int64(before)
+
+		// Assert
+		require.NoError(t, err)
+		info, err := os.Stat(filepath.Join(outputDir, "disk.img"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(size), info.Size())
+		// The object cache and the readahead stay well under the file's size,
+		// while a file held whole adds its size to them.
+		assert.Less(t, grown, int64(size), "the restore should not hold the file in memory")
+	})
+
+	t.Run("should restore files of many chunks and empty files", func(t *testing.T) {
+		// Arrange
+		sourceDir := t.TempDir()
+		content := make([]byte, 2*1024*1024+123)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "large.bin"), content, 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "empty.txt"), nil, 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		outputDir := t.TempDir()
+
+		// Act
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		compareDirs(t, sourceDir, outputDir)
+	})
+
+	t.Run("should link files that were hardlinked together", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("hardlinks are only detected where files have inode numbers")
+		}
+		// Arrange
+		sourceDir := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(sourceDir, "subdir"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "original.txt"), []byte("linked content"), 0644))
+		require.NoError(t, os.Link(filepath.Join(sourceDir, "original.txt"), filepath.Join(sourceDir, "subdir", "link.txt")))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "copy.txt"), []byte("linked content"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		compareDirs(t, sourceDir, outputDir)
+		original, err := os.Stat(filepath.Join(outputDir, "original.txt"))
+		require.NoError(t, err)
+		link, err := os.Stat(filepath.Join(outputDir, "subdir", "link.txt"))
+		require.NoError(t, err)
+		copied, err := os.Stat(filepath.Join(outputDir, "copy.txt"))
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(original, link), "The hardlink should be restored as one")
+		assert.False(t, os.SameFile(original, copied), "An identical file should stay a file of its own")
+	})
+
+	t.Run("should restore the modification times of files and directories", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		fileTime := time.Date(2020, 1, 2, 3, 4, 5, 123456789, time.UTC)
+		dirTime := time.Date(2021, 6, 7, 8, 9, 10, 987654321, time.UTC)
+		require.NoError(t, os.Chtimes(filepath.Join(sourceDir, "subdir", "fileB.txt"), fileTime, fileTime))
+		require.NoError(t, os.Chtimes(filepath.Join(sourceDir, "subdir"), dirTime, dirTime))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		file, err := os.Stat(filepath.Join(outputDir, "subdir", "fileB.txt"))
+		require.NoError(t, err)
+		assert.True(t, fileTime.Equal(file.ModTime()), "The file's time should be restored, got %v", file.ModTime())
+		dir, err := os.Stat(filepath.Join(outputDir, "subdir"))
+		require.NoError(t, err)
+		assert.True(t, dirTime.Equal(dir.ModTime()), "The directory's time should survive the files written into it, got %v", dir.ModTime())
+	})
+
+	t.Run("should give files back to their owners only when asked", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+			t.Skip("changing the owner of files takes root on a system with owners")
+		}
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		owned := []string{filepath.Join(sourceDir, "subdir"), filepath.Join(sourceDir, "subdir", "fileB.txt")}
+		for _, path := range owned {
+			require.NoError(t, os.Chown(path, 54321, 54322))
+		}
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		preservedDir, plainDir := t.TempDir(), t.TempDir()
+
+		// Act
+		errPreserved := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: preservedDir, PreserveOwner: true})
+		errPlain := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: plainDir})
+
+		// Assert
+		require.NoError(t, errPreserved)
+		require.NoError(t, errPlain)
+		for _, path := range owned {
+			relative, err := filepath.Rel(sourceDir, path)
+			require.NoError(t, err)
+			info, err := os.Stat(filepath.Join(preservedDir, relative))
+			require.NoError(t, err)
+			owner := lib.FileOwner(info)
+			assert.Equal(t, [2]uint32{54321, 54322}, [2]uint32{owner.UID, owner.GID}, relative)
+			info, err = os.Stat(filepath.Join(plainDir, relative))
+			require.NoError(t, err)
+			assert.Equal(t, uint32(0), lib.FileOwner(info).UID, "Without the option, the restorer should own "+relative)
+		}
+	})
+
+	t.Run("should restore ACLs only when asked", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("POSIX ACLs are only supported on Linux")
+		}
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		fileACL := types.ACL{Access: "user::rw-,user:1234:r--,group::r--,mask::r--,other::r--"}
+		dirACL := types.ACL{Default: "user::rwx,group::r-x,group:5678:r-x,mask::r-x,other::---"}
+		if err := lib.SetFileACL(filepath.Join(sourceDir, "subdir", "fileB.txt"), fileACL); err != nil {
+			t.Skipf("the filesystem does not support ACLs: %v", err)
+		}
+		require.NoError(t, lib.SetFileACL(filepath.Join(sourceDir, "subdir"), dirACL))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		withACLsDir, plainDir := t.TempDir(), t.TempDir()
+
+		// Act
+		errWithACLs := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: withACLsDir, ACLs: true})
+		errPlain := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: plainDir})
+
+		// Assert
+		require.NoError(t, errWithACLs)
+		require.NoError(t, errPlain)
+		for path, want := range map[string]types.ACL{filepath.Join("subdir", "fileB.txt"): fileACL, "subdir": dirACL} {
+			info, err := os.Stat(filepath.Join(withACLsDir, path))
+			require.NoError(t, err)
+			acl, err := lib.FileACL(filepath.Join(withACLsDir, path), info.IsDir())
+			require.NoError(t, err)
+			require.NotNil(t, acl, path)
+			assert.Equal(t, want, *acl, path)
+			acl, err = lib.FileACL(filepath.Join(plainDir, path), info.IsDir())
+			require.NoError(t, err)
+			assert.Nil(t, acl, "Without the option, %s should get no ACL", path)
+		}
+	})
+
+	t.Run("should restore Windows attributes and alternate data streams", func(t *testing.T) {
+		if runtime.GOOS != "windows" {
+			t.Skip("files only have attributes and alternate data streams on Windows")
+		}
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		fileB := filepath.Join(sourceDir, "subdir", "fileB.txt")
+		require.NoError(t, os.WriteFile(lib.StreamPath(fileB, "extra"), []byte("hidden stream"), 0644))
+		require.NoError(t, lib.SetFileAttributes(fileB, 0x2)) // Hidden.
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{Streams: true}))
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		restoredB := filepath.Join(outputDir, "subdir", "fileB.txt")
+		stream, err := os.ReadFile(lib.StreamPath(restoredB, "extra"))
+		require.NoError(t, err)
+		assert.Equal(t, "hidden stream", string(stream))
+		info, err := os.Stat(restoredB)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(0x2), lib.FileAttributes(info))
+	})
+
+	t.Run("should restore the creation time, Finder info, and quarantine on macOS", func(t *testing.T) {
+		if runtime.GOOS != "darwin" {
+			t.Skip("creation times, Finder info, and quarantine are only kept on macOS")
+		}
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		fileB := filepath.Join(sourceDir, "subdir", "fileB.txt")
+		finderInfo := make([]byte, 32)
+		finderInfo[8] = 0x40 // The hidden Finder flag.
+		xattrs := map[string][]byte{"com.apple.FinderInfo": finderInfo, "com.apple.quarantine": []byte("0081;5f000000;Safari;")}
+		require.NoError(t, lib.SetFileXattrs(fileB, xattrs))
+		birthTime := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+		require.NoError(t, lib.SetFileBirthTime(fileB, birthTime.UnixNano()))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		restoredB := filepath.Join(outputDir, "subdir", "fileB.txt")
+		restored, err := lib.FileXattrs(restoredB)
+		require.NoError(t, err)
+		assert.Equal(t, xattrs, restored)
+		info, err := os.Stat(restoredB)
+		require.NoError(t, err)
+		assert.Equal(t, birthTime.UnixNano(), lib.FileBirthTime(info))
+	})
+
 	t.Run("should fail gracefully if an object is missing from the index", func(t *testing.T) {
 		// Arrange
 		sourceDir := setupRestoreTest(t) // This creates a snap with a few objects
 		outputDir := t.TempDir()
 
 		// Find a chunk object hash to remove from the index.
-		store := lib.NewObjectStore(sourceDir)
-		snaps, err := lib.GetSortedSnaps(sourceDir)
+		store := lib.NewLocalObjectStore(sourceDir)
+		snaps, err := lib.NewLocalObjectStore(sourceDir).GetSortedSnaps()
 		require.NoError(t, err)
 		require.NotEmpty(t, snaps, "Failed to get snaps to find an object to delete")
 
@@ -219,10 +477,12 @@ func TestRestoreCommand(t *testing.T) {
 
 		objectToDelete := fileManifest.Chunks[0].Hash
 
-		// Now, corrupt the index by removing this object.
-		indexPath := lib.GetIndexPath(sourceDir)
+		// Now, corrupt the index by removing this object from its pack's shard.
+		currentIndex, err := store.GetIndex()
+		require.NoError(t, err, "Failed to get index")
+		indexPath := filepath.Join(lib.GetIndexDir(sourceDir), currentIndex[objectToDelete].PackHash)
 		indexContent, err := os.ReadFile(indexPath)
-		require.NoError(t, err, "Failed to read index file")
+		require.NoError(t, err, "Failed to read index shard")
 
 		var index types.PackIndex
 		err = json.Unmarshal(indexContent, &index)
@@ -238,10 +498,388 @@ func TestRestoreCommand(t *testing.T) {
 
 		// Act
 		// The Restore command will create its own ObjectStore, which will load the now-corrupted index.
-		err = commands.Restore(sourceDir, "1", outputDir)
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
 
 		// Assert
 		require.Error(t, err, "Expected restore to fail due to missing object, but it succeeded")
 		assert.Contains(t, err.Error(), "not found in index", "Expected error about missing object from index")
 	})
+
+	t.Run("should fail rather than restore a file whose stored bytes changed", func(t *testing.T) {
+		// Arrange: change every object in the only pack.
+		sourceDir := setupRestoreTest(t)
+		index, err := lib.NewLocalObjectStore(sourceDir).GetIndex()
+		require.NoError(t, err)
+		var packHash string
+		for _, entry := range index {
+			packHash = entry.PackHash
+		}
+		packPath := filepath.Join(lib.GetPacksDir(sourceDir), packHash)
+		pack, err := os.ReadFile(packPath)
+		require.NoError(t, err)
+		for _, entry := range index {
+			pack[entry.Offset+entry.Length-1] ^= 0xff
+		}
+		require.NoError(t, os.WriteFile(packPath, pack, 0644))
+
+		// Act
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: t.TempDir()})
+
+		// Assert
+		require.ErrorIs(t, err, lib.ErrCorrupted)
+		assert.Contains(t, err.Error(), "pack "+packHash+" corrupted at object")
+	})
+
+	t.Run("should restore only the given path, leaving the rest of the output alone", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "keep.txt"), []byte("untouched"), 0644))
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Path: "subdir/fileB.txt"})
+		require.NoError(t, err)
+
+		// Assert
+		content, err := os.ReadFile(filepath.Join(outputDir, "subdir", "fileB.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "me too", string(content))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		content, err = os.ReadFile(filepath.Join(outputDir, "keep.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "untouched", string(content))
+	})
+
+	t.Run("should restore a directory given as the path", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Path: "/subdir/"})
+		require.NoError(t, err)
+
+		// Assert
+		compareDirs(t, filepath.Join(sourceDir, "subdir"), filepath.Join(outputDir, "subdir"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"))
+	})
+
+	t.Run("should fail for a path the snap does not hold", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Path: "missing.txt"})
+
+		// Assert
+		require.ErrorIs(t, err, fs.ErrNotExist)
+	})
+
+	t.Run("should write the file at the path to stdout without touching the output", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := filepath.Join(t.TempDir(), "never_created")
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Path: "subdir/fileB.txt", Stdout: true})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		assert.Equal(t, "me too", output)
+		assert.NoDirExists(t, outputDir)
+	})
+
+	t.Run("should fail to write to stdout without a path", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: t.TempDir(), Stdout: true})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "takes the path of a file")
+	})
+
+	t.Run("should restore only the files matching the include and exclude patterns", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "db", "archive"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "db", "dump.sql"), []byte("select 1;"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "db", "archive", "old.sql"), []byte("select 0;"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		outputDir := t.TempDir()
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{
+			SnapIdentifier: "2",
+			OutputDir:      outputDir,
+			Include:        []string{"*.sql"},
+			Exclude:        []string{"archive"},
+		})
+		require.NoError(t, err)
+
+		// Assert
+		assert.FileExists(t, filepath.Join(outputDir, "db", "dump.sql"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "db", "archive", "old.sql"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "subdir"), "a directory with nothing to restore should not be created")
+	})
+
+	t.Run("should rewrite only changed files and remove only extras when restoring over a previous restore", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "subdir", "fileB.txt"), []byte("edited since"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "extra.txt"), []byte("not in the snap"), 0644))
+		require.NoError(t, os.MkdirAll(filepath.Join(outputDir, ".git"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644))
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		assert.Contains(t, output, "Wrote 1 file(s), left 1 unchanged, removed 1 extra path(s).")
+		compareDirs(t, sourceDir, outputDir)
+		assert.NoFileExists(t, filepath.Join(outputDir, "extra.txt"))
+		assert.FileExists(t, filepath.Join(outputDir, ".git", "HEAD"), "paths a snap leaves out should not be removed")
+	})
+
+	t.Run("should report what it would change without writing anything in a dry run", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		require.NoError(t, os.RemoveAll(filepath.Join(outputDir, "fileA.txt")))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "subdir", "fileB.txt"), []byte("edited since"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "extra.txt"), []byte("12345"), 0644))
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, DryRun: true})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		assert.Contains(t, output, "+ fileA.txt (10.00 Bytes)")
+		assert.Contains(t, output, "M subdir/fileB.txt (12.00 Bytes -> 6.00 Bytes)")
+		assert.Contains(t, output, "- extra.txt (5.00 Bytes)")
+		assert.Contains(t, output, "Would create 1 file(s) (10.00 Bytes), overwrite 1 (6.00 Bytes), and delete 1 (5.00 Bytes); 0 unchanged.")
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.FileExists(t, filepath.Join(outputDir, "extra.txt"))
+		content, err := os.ReadFile(filepath.Join(outputDir, "subdir", "fileB.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "edited since", string(content))
+	})
+
+	t.Run("should not create the output directory in a dry run", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := filepath.Join(t.TempDir(), "new_output")
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, DryRun: true})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		assert.Contains(t, output, "Would create 2 file(s)")
+		assert.NoDirExists(t, outputDir)
+	})
+
+	t.Run("should verify the restored files against the snap", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+
+		// Act
+		var restoreErr error
+		output := captureStdout(t, func() {
+			restoreErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Verify: true})
+		})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		assert.Contains(t, output, "Verifying 2 restored file(s)")
+		assert.Contains(t, output, "Every restored file matches the snap.")
+	})
+
+	t.Run("should fail verification for a file left unchanged whose contents differ", func(t *testing.T) {
+		// Arrange: change a restored file without changing its size or
+		// modification time, so that restoring again leaves it alone.
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		filePath := filepath.Join(outputDir, "fileA.txt")
+		info, err := os.Stat(filePath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filePath, []byte("RESTORE ME"), 0644))
+		require.NoError(t, os.Chtimes(filePath, info.ModTime(), info.ModTime()))
+
+		// Act
+		err = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, Verify: true})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "1 restored file(s) do not match snap 1")
+		assert.Contains(t, err.Error(), filePath+" differs from the snap in bytes 0 to 10")
+	})
+
+	t.Run("should report that a directory matches the snap when verifying only", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+
+		// Act
+		var verifyErr error
+		output := captureStdout(t, func() {
+			verifyErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, VerifyOnly: true})
+		})
+
+		// Assert
+		require.NoError(t, verifyErr)
+		assert.Contains(t, output, "matches snap 1")
+	})
+
+	t.Run("should list the differences without changing anything when verifying only", func(t *testing.T) {
+		// Arrange: change the contents of one file, only the modification
+		// time of another, and add a third.
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "fileA.txt"), []byte("tampered"), 0644))
+		touched := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(outputDir, "subdir", "fileB.txt"), touched, touched))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "extra.txt"), []byte("extra"), 0644))
+
+		// Act
+		var verifyErr error
+		output := captureStdout(t, func() {
+			verifyErr = commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, VerifyOnly: true})
+		})
+
+		// Assert
+		require.Error(t, verifyErr)
+		assert.Contains(t, verifyErr.Error(), "3 path(s) of")
+		assert.Contains(t, output, "M fileA.txt")
+		assert.Contains(t, output, "[contents, mtime]")
+		assert.Contains(t, output, "M subdir/fileB.txt (6.00 Bytes -> 6.00 Bytes) [mtime]")
+		assert.Contains(t, output, "+ extra.txt")
+		content, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "tampered", string(content))
+		assert.FileExists(t, filepath.Join(outputDir, "extra.txt"))
+	})
+
+	t.Run("should deal with files that differ as the conflict policy says", func(t *testing.T) {
+		testCases := []struct {
+			policy      string
+			wantErr     string
+			wantContent string
+			wantAside   bool
+		}{
+			{policy: commands.ConflictOverwrite, wantContent: "me too"},
+			{policy: commands.ConflictSkip, wantContent: "edited since"},
+			{policy: commands.ConflictFail, wantErr: "1 file(s) of", wantContent: "edited since"},
+			{policy: commands.ConflictRename, wantContent: "me too", wantAside: true},
+			{policy: "merge", wantErr: "unsupported conflict policy", wantContent: "edited since"},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.policy, func(t *testing.T) {
+				// Arrange
+				sourceDir := setupRestoreTest(t)
+				outputDir := t.TempDir()
+				require.NoError(t, commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+				filePath := filepath.Join(outputDir, "subdir", "fileB.txt")
+				require.NoError(t, os.WriteFile(filePath, []byte("edited since"), 0644))
+				require.NoError(t, os.Remove(filepath.Join(outputDir, "fileA.txt")))
+
+				// Act
+				err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, OnConflict: tc.policy})
+
+				// Assert
+				if tc.wantErr != "" {
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), tc.wantErr)
+					assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"), "nothing should be written")
+				} else {
+					require.NoError(t, err)
+					assert.FileExists(t, filepath.Join(outputDir, "fileA.txt"))
+				}
+				content, err := os.ReadFile(filePath)
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantContent, string(content))
+				aside, err := os.ReadFile(filepath.Join(outputDir, "subdir", "fileB (conflict).txt"))
+				if tc.wantAside {
+					require.NoError(t, err)
+					assert.Equal(t, "edited since", string(aside))
+				} else {
+					assert.True(t, os.IsNotExist(err))
+				}
+			})
+		}
+	})
+
+	t.Run("should keep extras with NoDelete", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(outputDir, "subdir"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "subdir", "mine.txt"), []byte("keep me"), 0644))
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, NoDelete: true})
+
+		// Assert
+		require.NoError(t, err)
+		compareDirs(t, sourceDir, outputDir)
+		assert.FileExists(t, filepath.Join(outputDir, "subdir", "mine.txt"))
+	})
+
+	t.Run("should replace a directory where the snap holds a file", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		outputDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(outputDir, "fileA.txt", "nested"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(outputDir, "subdir"), []byte("a file in the way"), 0644))
+
+		// Act
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		compareDirs(t, sourceDir, outputDir)
+	})
+}
+
+func BenchmarkRestore(b *testing.B) {
+	sourceDir := b.TempDir()
+	content := make([]byte, 1024*1024)
+	for i := 0; i < 16; i++ {
+		_, err := rand.Read(content)
+		require.NoError(b, err)
+		require.NoError(b, os.WriteFile(filepath.Join(sourceDir, "file"+strconv.Itoa(i)+".bin"), content, 0644))
+	}
+	require.NoError(b, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+	b.SetBytes(16 * int64(len(content)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := commands.Restore(sourceDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: b.TempDir()})
+		require.NoError(b, err)
+	}
 }
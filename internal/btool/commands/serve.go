@@ -0,0 +1,77 @@
+package commands
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// ServeOptions holds the configuration for the serve command.
+type ServeOptions struct {
+	// Listen is the TCP address to listen on, e.g. ":8520".
+	Listen string
+	// Token is a bearer token that gives full access to every repository.
+	Token string
+	// ClientsFile names a JSON file listing client tokens, each with a role
+	// and the repositories it may access. Without it or Token, authentication
+	// is disabled.
+	ClientsFile string
+	// TLSCertFile and TLSKeyFile enable HTTPS when both are set.
+	TLSCertFile string
+	TLSKeyFile  string
+}
+
+// Serve is the main function for the 'serve' command. It exposes every
+// repository stored under rootDir over HTTP and runs until the server fails.
+func Serve(rootDir string, options ServeOptions) error {
+	absRootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", rootDir, err)
+	}
+	if err := os.MkdirAll(absRootDir, 0755); err != nil {
+		return fmt.Errorf("could not create repository root %s: %w", absRootDir, err)
+	}
+	if (options.TLSCertFile == "") != (options.TLSKeyFile == "") {
+		return fmt.Errorf("both a TLS certificate and key are required to serve HTTPS")
+	}
+	var clients []lib.ServerClient
+	if options.ClientsFile != "" {
+		if clients, err = lib.LoadServerClients(options.ClientsFile); err != nil {
+			return err
+		}
+	}
+	if options.Token != "" {
+		clients = append(clients, lib.ServerClient{Name: "token", Token: options.Token, Role: lib.RoleAdmin})
+	}
+
+	listener, err := net.Listen("tcp", options.Listen)
+	if err != nil {
+		return fmt.Errorf("could not listen on %s: %w", options.Listen, err)
+	}
+
+	scheme := "http"
+	if options.TLSCertFile != "" {
+		scheme = "https"
+	}
+	fmt.Printf("🌐 Serving repositories under \"%s\" on %s://%s\n", absRootDir, scheme, listener.Addr())
+	fmt.Printf("   - Clients can use --repo %s://<host>:<port>/<name>\n", scheme)
+	if len(clients) == 0 {
+		fmt.Fprintln(os.Stderr, "Warning: no access token is set; anyone who can reach this server can read and modify its repositories.")
+	} else {
+		fmt.Printf("   - Accepting %d client token(s)\n", len(clients))
+	}
+
+	server := &http.Server{
+		Handler:           lib.NewRepositoryServer(absRootDir, clients...),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if options.TLSCertFile != "" {
+		return server.ServeTLS(listener, options.TLSCertFile, options.TLSKeyFile)
+	}
+	return server.Serve(listener)
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// ShowOptions holds the configuration for the show command.
+type ShowOptions struct {
+	SnapIdentifier string
+	// JSON prints the snap manifest, with its hash, as JSON.
+	JSON bool
+	RepositoryOptions
+}
+
+// shownSnap is a snap manifest as show prints it with --json.
+type shownSnap struct {
+	Hash string `json:"hash"`
+	types.Snap
+}
+
+// Show is the main function for the 'show' command. It prints everything
+// the manifest of a snap records.
+func Show(sourceDir string, options ShowOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	if options.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(shownSnap{Hash: snap.Hash, Snap: types.Snap{
+			ID:           snap.ID,
+			Timestamp:    snap.Timestamp.UTC().Format(time.RFC3339),
+			RootTreeHash: snap.RootTreeHash,
+			Message:      snap.Message,
+			SourceSize:   snap.SourceSize,
+			SnapSize:     snap.SnapSize,
+			Parent:       snap.Parent,
+			Damaged:      snap.Damaged,
+			Hostname:     snap.Hostname,
+			Username:     snap.Username,
+			Paths:        snap.Paths,
+			Version:      snap.Version,
+			Command:      snap.Command,
+		}})
+	}
+
+	// The parent is named by its ID too while it exists.
+	parent := "none"
+	if snap.Parent != "" {
+		parent = snap.Parent + " (pruned)"
+		if parentSnap, err := store.FindSnap(snap.Parent); err == nil && parentSnap.Hash == snap.Parent {
+			parent = fmt.Sprintf("%s (snap %d)", snap.Parent, parentSnap.ID)
+		}
+	}
+	message := snap.Message
+	if message == "" {
+		message = "(none)"
+	}
+
+	fmt.Printf("Snap %d\n", snap.ID)
+	fmt.Printf("   - Hash:        %s\n", snap.Hash)
+	fmt.Printf("   - Timestamp:   %s\n", snap.Timestamp.Format("2006-01-02 15:04:05 MST"))
+	fmt.Printf("   - Message:     %s\n", message)
+	fmt.Printf("   - Root tree:   %s\n", snap.RootTreeHash)
+	fmt.Printf("   - Source size: %s\n", formatBytes(snap.SourceSize, 2))
+	fmt.Printf("   - Snap size:   %s\n", formatBytes(snap.SnapSize, 2))
+	fmt.Printf("   - Parent:      %s\n", parent)
+	// Snaps taken before btool recorded where they came from have none of it.
+	if snap.Hostname != "" || snap.Username != "" {
+		fmt.Printf("   - Taken by:    %s\n", strings.Trim(snap.Username+"@"+snap.Hostname, "@"))
+	}
+	for _, p := range snap.Paths {
+		fmt.Printf("   - Path:        %s\n", p)
+	}
+	if snap.Version != "" {
+		fmt.Printf("   - Version:     btool %s\n", snap.Version)
+	}
+	if len(snap.Command) > 0 {
+		fmt.Printf("   - Command:     %s\n", strings.Join(snap.Command, " "))
+	}
+	if snap.Damaged {
+		fmt.Println("   - Damaged:     yes, objects it needs were lost and it cannot be restored in full")
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package commands_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShowCommand(t *testing.T) {
+	// setupShowTest creates a repository with two snaps, the second with a
+	// message.
+	setupShowTest := func(t *testing.T) (string, []lib.SnapDetail) {
+		t.Helper()
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileA.txt"), []byte("changed"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{Message: "second"}))
+		snaps, err := lib.NewLocalObjectStore(sourceDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 2)
+		return sourceDir, snaps
+	}
+
+	t.Run("should print the details of a snap", func(t *testing.T) {
+		// Arrange
+		sourceDir, snaps := setupShowTest(t)
+
+		// Act
+		var showErr error
+		output := captureStdout(t, func() {
+			showErr = commands.Show(sourceDir, commands.ShowOptions{SnapIdentifier: "2"})
+		})
+
+		// Assert
+		require.NoError(t, showErr)
+		assert.Contains(t, output, "Snap 2\n")
+		assert.Contains(t, output, "Hash:        "+snaps[1].Hash+"\n")
+		assert.Contains(t, output, "Message:     second\n")
+		assert.Contains(t, output, "Root tree:   "+snaps[1].RootTreeHash+"\n")
+		assert.Contains(t, output, "Parent:      "+snaps[0].Hash+" (snap 1)\n")
+		assert.Contains(t, output, "Path:        "+sourceDir+"\n")
+		assert.Contains(t, output, "Version:     btool ")
+		assert.Contains(t, output, "Command:     ")
+	})
+
+	t.Run("should print the manifest as JSON", func(t *testing.T) {
+		// Arrange
+		sourceDir, snaps := setupShowTest(t)
+
+		// Act
+		var showErr error
+		output := captureStdout(t, func() {
+			showErr = commands.Show(sourceDir, commands.ShowOptions{SnapIdentifier: snaps[0].Hash[:8], JSON: true})
+		})
+
+		// Assert
+		require.NoError(t, showErr)
+		var shown map[string]any
+		require.NoError(t, json.Unmarshal([]byte(output), &shown))
+		assert.Equal(t, snaps[0].Hash, shown["hash"])
+		assert.Equal(t, float64(1), shown["id"])
+		assert.Equal(t, snaps[0].RootTreeHash, shown["rootTreeHash"])
+		assert.Equal(t, "restore test snap", shown["message"])
+		assert.NotContains(t, shown, "parent")
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+		assert.Equal(t, hostname, shown["hostname"])
+		assert.Equal(t, []any{sourceDir}, shown["paths"])
+		assert.NotEmpty(t, shown["version"])
+		assert.NotEmpty(t, shown["command"])
+	})
+}
@@ -2,71 +2,417 @@
 package commands
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/fs"
+	"maps"
 	"os"
+	"os/user"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/lib"
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
 )
 
+// SnapOptions holds the configuration for the snap command.
+type SnapOptions struct {
+	Message string
+	// ForceRescan ignores the file cache, reading and chunking every file
+	// and building every tree again, for when file times cannot be trusted
+	// or the cache is suspect.
+	ForceRescan bool
+	// SkipIfUnchanged creates no snap when the directory holds the same as
+	// the latest snap, so that scheduled backups do not pile up identical
+	// snaps.
+	SkipIfUnchanged bool
+	// Streams records the alternate data streams of files on Windows.
+	Streams bool
+	// SpecialFiles includes FIFOs, sockets, and device nodes, which are
+	// otherwise left out, recording the device numbers of device nodes.
+	SpecialFiles bool
+	// MaxMemory bounds, in MiB, the memory held by the objects being written
+	// and those pending, making the workers wait for room. Zero means no limit.
+	MaxMemory int64
+	// Exclude leaves out the paths matching these patterns, in the syntax of
+	// .btoolignore and relative to the snapped directory, besides those that
+	// .btoolignore leaves out.
+	Exclude []string
+	// ExcludeFiles name files of such patterns, one per line.
+	ExcludeFiles []string
+	// Include, if given, holds only the paths matching these patterns, in
+	// the same syntax, everything below the directories they match, and the
+	// directories on the way to those.
+	Include []string
+	// StdinFilename names the file that SnapStdin stores what it reads as.
+	StdinFilename string
+	// DryRun walks the tree and reads the files that changed since the last
+	// snap, reporting how many files the snap would hold and about how much
+	// new data it would add, without writing anything.
+	DryRun bool
+	// FilesFrom names a file listing the paths to hold, one per line, either
+	// absolute or relative to the snapped directory. With Include, the paths
+	// either selects are held.
+	FilesFrom string
+	// PreSnap and PostSnap are shell commands run before the walk and once
+	// the snap is written, in place of the repository's hooks of the same
+	// name.
+	PreSnap  string
+	PostSnap string
+	// ExcludeLargerThan, if set, leaves out the files larger than this many
+	// bytes, reporting them once the files are processed.
+	ExcludeLargerThan int64
+	// MaxSize, such as "50G", becomes the most the repository's packs may
+	// take up before a snap removes the oldest snaps to make room, as
+	// lib.ParseSize reads it. It can be changed at any time; "0" removes the
+	// limit, and empty leaves it as it is.
+	MaxSize string
+	RepositoryOptions
+}
+
 // fileProcessResult is a struct to hold the outcome of processing a single file in a worker.
 type fileProcessResult struct {
 	FilePath     string
 	ManifestHash string
 	TotalSize    int64
+	CacheEntry   lib.FileCacheEntry
+	Reused       bool // The manifest came from the file cache.
 	Err          error
 }
 
-// findAllFiles walks the directory tree and returns a slice of all file paths
-// to be included in the snapshot, respecting the .btoolignore configuration.
-func findAllFiles(rootDir string) ([]string, error) {
-	var files []string
+// processedFiles is the outcome of processing all the files of a snap.
+type processedFiles struct {
+	ManifestHashes map[string]string // By path.
+	TotalSize      int64
+	CacheEntries   map[string]lib.FileCacheEntry // By path.
+	Reused         int                           // Files not read again.
+}
 
-	err := filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+// isExcluded reports whether path should be left out of a snapshot of rootDir,
+// either because it is ignored or because it is a repository directory itself
+// (when --repo or --mirror points somewhere inside the tree being snapped).
+func isExcluded(rootDir string, repoDirs []string, path string) bool {
+	return slices.Contains(repoDirs, path) || lib.IsPathIgnored(rootDir, path)
+}
+
+// excludePatterns returns the patterns given, followed by those read from
+// each of the named files.
+func excludePatterns(patterns, files []string) ([]string, error) {
+	all := slices.Clone(patterns)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("could not read exclude file: %w", err)
 		}
+		all = append(all, strings.Split(string(content), "\n")...)
+	}
+	return all, nil
+}
 
-		if path == rootDir {
-			return nil
+// relativeSlashPath returns path relative to rootDir, with forward slashes,
+// as patterns are matched against.
+func relativeSlashPath(rootDir, path string) string {
+	rel, err := filepath.Rel(rootDir, path)
+	if err != nil {
+		return path
+	}
+	return filepath.ToSlash(rel)
+}
+
+// hostname returns the name of this machine, or an empty string if it cannot
+// be told.
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// username returns the name of the user running btool, or an empty string if
+// it cannot be told.
+func username() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// localRepoDirs returns the directories of the destinations of a store that
+// are kept on the local filesystem.
+func localRepoDirs(store *lib.ObjectStore) []string {
+	backends := []lib.Backend{store.Backend()}
+	if multi, ok := lib.UnwrapBackend(store.Backend()).(*lib.MultiBackend); ok {
+		backends = multi.Backends()
+	}
+
+	var dirs []string
+	for _, backend := range backends {
+		if local, ok := lib.UnwrapBackend(backend).(*lib.LocalBackend); ok {
+			dirs = append(dirs, local.Location())
+		}
+	}
+	return dirs
+}
+
+// walkedDir is a directory as the walk of a snap found it.
+type walkedDir struct {
+	path    string
+	mode    uint32 // The permissions in the directory's tree entry.
+	modTime int64  // In nanoseconds since the epoch.
+	owner   *types.Owner
+	acl     *types.ACL
+	attrs   uint32 // The Windows attributes, from lib.FileAttributes.
+	macMeta
+	entries []walkedEntry
+	// selected tells whether everything below the directory is held, as
+	// when nothing restricts the snap or an include pattern matched it.
+	selected bool
+}
+
+// macMeta is the metadata of a file or directory that only macOS keeps.
+type macMeta struct {
+	birthTime int64             // In nanoseconds since the epoch.
+	xattrs    map[string][]byte // From lib.FileXattrs.
+}
+
+// walkedEntry is an entry of a walkedDir.
+type walkedEntry struct {
+	name    string
+	path    string
+	mode    uint32 // The permissions in the entry's tree entry.
+	modTime int64  // In nanoseconds since the epoch.
+	link    string // The hardlink group of a file, from lib.FileLinkID.
+	owner   *types.Owner
+	acl     *types.ACL
+	attrs   uint32 // The Windows attributes, from lib.FileAttributes.
+	macMeta
+	special string     // The tree entry type of a special file, from lib.SpecialFileType.
+	rdev    uint64     // The device number of a device node.
+	dir     *walkedDir // The subdirectory, or nil for a file.
+}
+
+// walkTree walks the directory tree once, respecting the .btoolignore
+// configuration and the selection sel, sending the path of each file to be
+// included in the snapshot to files as it goes and recording the entries of
+// each directory, from which the trees are built. Only regular files and
+// directories are included, and special files if specialFiles is set; the
+// special files left out are counted in skipped.
+func walkTree(rootDir string, repoDirs []string, sel *snapSelection, specialFiles bool, files chan<- string) (root *walkedDir, skipped int, err error) {
+	dirs := make(map[string]*walkedDir)
+
+	err = filepath.WalkDir(rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
 		}
 
-		if lib.IsPathIgnored(rootDir, path) {
-			if d.IsDir() {
+		selected := true
+		if path != rootDir {
+			rel := relativeSlashPath(rootDir, path)
+			if isExcluded(rootDir, repoDirs, path) || sel.excludes(rel, d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			selected = sel.includes(rel, d.IsDir()) || dirs[filepath.Dir(path)].selected
+			if !selected && !d.IsDir() {
+				return nil
+			}
+			if !selected && !sel.mayHoldSelected(rel) {
 				return filepath.SkipDir
 			}
+		}
+		special := lib.SpecialFileType(d.Type())
+		if !d.IsDir() && !d.Type().IsRegular() {
+			if special == "" {
+				return nil
+			}
+			if !specialFiles {
+				skipped++
+				return nil
+			}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && sel.tooLarge(path, info) {
+			return nil
+		}
+		entry, err := newWalkedEntry(path, info)
+		if err != nil {
+			return err
+		}
+		if path == rootDir {
+			root = entry.newDir(!sel.restricts())
+			dirs[path] = root
 			return nil
 		}
 
-		if d.Type().IsRegular() {
-			files = append(files, path)
+		if d.IsDir() {
+			entry.dir = entry.newDir(selected)
+			dirs[path] = entry.dir
+		} else if special != "" {
+			entry.special, entry.rdev = special, lib.FileRdev(info)
+		} else {
+			entry.link = lib.FileLinkID(info)
+			files <- path
 		}
+		parent := dirs[filepath.Dir(path)]
+		parent.entries = append(parent.entries, entry)
 		return nil
 	})
 
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return files, nil
+	if sel.restricts() {
+		pruneUnselected(root)
+	}
+	return root, skipped, nil
 }
 
-// processFilesConcurrently creates a worker pool of goroutines to process files in parallel.
-// It chunks, hashes, and writes all file data (chunks and manifests) to the object store.
-func processFilesConcurrently(store *lib.ObjectStore, files []string) (map[string]string, int64, error) {
-	numJobs := len(files)
-	jobs := make(chan string, numJobs)
-	results := make(chan fileProcessResult, numJobs)
+// newWalkedEntry returns the entry of the walk for the file or directory at
+// path, whose info is given, without its subdirectory.
+func newWalkedEntry(path string, info fs.FileInfo) (walkedEntry, error) {
+	acl, err := lib.FileACL(path, info.IsDir())
+	if err != nil {
+		return walkedEntry{}, err
+	}
+	xattrs, err := lib.FileXattrs(path)
+	if err != nil {
+		return walkedEntry{}, err
+	}
+	return walkedEntry{
+		name:    info.Name(),
+		path:    path,
+		mode:    uint32(info.Mode().Perm()),
+		modTime: info.ModTime().UnixNano(),
+		owner:   lib.FileOwner(info),
+		acl:     acl,
+		attrs:   lib.FileAttributes(info),
+		macMeta: macMeta{birthTime: lib.FileBirthTime(info), xattrs: xattrs},
+	}, nil
+}
 
-	// Use a WaitGroup to wait for all goroutines to finish.
-	var wg sync.WaitGroup
+// newDir returns the walked directory of the entry, with its metadata.
+func (e walkedEntry) newDir(selected bool) *walkedDir {
+	return &walkedDir{path: e.path, mode: e.mode, modTime: e.modTime, owner: e.owner, acl: e.acl, attrs: e.attrs, macMeta: e.macMeta, selected: selected}
+}
+
+// walkPaths walks each of paths, which are directories or regular files, as
+// walkTree does, returning a directory that holds them as its entries, named
+// after the last elements of their paths. That directory lies nowhere, so it
+// has no path, nor any metadata of its own.
+func walkPaths(paths []string, repoDirs []string, sels []*snapSelection, specialFiles bool, files chan<- string) (root *walkedDir, skipped int, err error) {
+	root = &walkedDir{selected: true}
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		switch {
+		case info.IsDir():
+			dir, dirSkipped, err := walkTree(path, repoDirs, sels[i], specialFiles, files)
+			if err != nil {
+				return nil, 0, err
+			}
+			skipped += dirSkipped
+			root.entries = append(root.entries, walkedEntry{name: filepath.Base(path), path: path, mode: dir.mode, modTime: dir.modTime,
+				owner: dir.owner, acl: dir.acl, attrs: dir.attrs, macMeta: dir.macMeta, dir: dir})
+		case info.Mode().IsRegular():
+			if sels[i].tooLarge(path, info) {
+				continue
+			}
+			entry, err := newWalkedEntry(path, info)
+			if err != nil {
+				return nil, 0, err
+			}
+			entry.link = lib.FileLinkID(info)
+			files <- path
+			root.entries = append(root.entries, entry)
+		default:
+			return nil, 0, fmt.Errorf("%s is neither a regular file nor a directory", path)
+		}
+	}
+	return root, skipped, nil
+}
+
+// writeFileObjects chunks the file at filePath, writing its chunks and its
+// manifest to the store and returning the manifest's hash. A large file is
+// chunked by several goroutines at once, so that it does not leave the other
+// CPUs idle.
+func writeFileObjects(store *lib.ObjectStore, filePath string, workers int) (manifestHash string, totalSize int64, err error) {
+	// Write each data chunk to the pending object store as it is cut, so
+	// that only the chunks in hand are held. Chunks of files that are
+	// compressed already are not compressed again.
+	writeChunk := store.WriteObject
+	if lib.HasCompressedExtension(filePath) {
+		writeChunk = store.WriteIncompressibleObject
+	}
+	chunkRefs, totalSize, err := lib.ChunkFileParallel(filePath, store.Chunker(), store.Hasher(), workers, func(chunk types.Chunk) error {
+		_, err := writeChunk(chunk.Data)
+		return err
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Create and write the file manifest object.
+	manifest := types.FileManifest{Chunks: chunkRefs, TotalSize: totalSize}
+	manifestJSON, _ := json.Marshal(manifest)
+	manifestHash, err = store.WriteMetadataObject(manifestJSON)
+	if err != nil {
+		return "", 0, err
+	}
+	return manifestHash, totalSize, nil
+}
+
+// writeStreamObjects writes the alternate data streams of the file at
+// filePath to the store as writeFileObjects does its contents.
+func writeStreamObjects(store *lib.ObjectStore, filePath string, workers int) ([]types.Stream, error) {
+	names, err := lib.FileStreams(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not list streams: %w", err)
+	}
+	var streams []types.Stream
+	for _, name := range names {
+		manifestHash, _, err := writeFileObjects(store, lib.StreamPath(filePath, name), workers)
+		if err != nil {
+			return nil, fmt.Errorf("stream %s: %w", name, err)
+		}
+		streams = append(streams, types.Stream{Name: name, Hash: manifestHash})
+	}
+	return streams, nil
+}
+
+// processFilesConcurrently creates a worker pool of goroutines to process files in parallel,
+// as they arrive on jobs until it is closed.
+// It chunks, hashes, and writes all file data (chunks and manifests) to the object store,
+// with the alternate data streams of files if streams is set.
+// Files that look as they did in previous, the file cache of the last snap,
+// keep the manifests recorded there without being read.
+func processFilesConcurrently(store *lib.ObjectStore, jobs <-chan string, previous map[string]lib.FileCacheEntry, streams bool) (processedFiles, error) {
 	numWorkers := runtime.NumCPU()
+	results := make(chan fileProcessResult, numWorkers)
+
+	// Use a WaitGroup to wait for all goroutines to finish. Once a file
+	// fails, the workers drain the remaining jobs without processing them,
+	// so that the walk feeding them can finish.
+	var wg sync.WaitGroup
+	var failed atomic.Bool
 
 	// Start worker goroutines.
 	for w := 0; w < numWorkers; w++ {
@@ -74,108 +420,282 @@ func processFilesConcurrently(store *lib.ObjectStore, files []string) (map[strin
 		go func() {
 			defer wg.Done()
 			for filePath := range jobs {
+				if failed.Load() {
+					continue
+				}
 				// --- This is the work each goroutine does ---
-				chunks, totalSize, err := lib.ChunkFile(filePath)
+				info, err := os.Stat(filePath)
 				if err != nil {
 					results <- fileProcessResult{FilePath: filePath, Err: err}
 					continue
 				}
-
-				// Write all data chunks to the pending object store.
-				for _, chunk := range chunks {
-					if _, err := store.WriteObject(chunk.Data); err != nil {
-						results <- fileProcessResult{FilePath: filePath, Err: err}
-						return // Use return to stop processing on this file
+				cacheEntry := lib.NewFileCacheEntry(info)
+				if cached, ok := previous[filePath]; ok && cached.Unchanged(cacheEntry) {
+					cacheEntry.ManifestHash = cached.ManifestHash
+					if streams {
+						cacheEntry.Streams = cached.Streams
 					}
+					results <- fileProcessResult{FilePath: filePath, ManifestHash: cached.ManifestHash, TotalSize: cached.Size, CacheEntry: cacheEntry, Reused: true}
+					continue
 				}
 
-				// Create and write the file manifest object.
-				chunkRefs := make([]types.ChunkRef, len(chunks))
-				for i, c := range chunks {
-					chunkRefs[i] = types.ChunkRef{Hash: c.Hash, Size: c.Size}
+				manifestHash, totalSize, err := writeFileObjects(store, filePath, numWorkers)
+				if err == nil && streams {
+					cacheEntry.Streams, err = writeStreamObjects(store, filePath, numWorkers)
 				}
-				manifest := types.FileManifest{Chunks: chunkRefs, TotalSize: totalSize}
-				manifestJSON, _ := json.Marshal(manifest)
-				manifestHash, err := store.WriteObject(manifestJSON)
 				if err != nil {
 					results <- fileProcessResult{FilePath: filePath, Err: err}
 					continue
 				}
 
-				results <- fileProcessResult{FilePath: filePath, ManifestHash: manifestHash, TotalSize: totalSize}
+				cacheEntry.ManifestHash = manifestHash
+				results <- fileProcessResult{FilePath: filePath, ManifestHash: manifestHash, TotalSize: totalSize, CacheEntry: cacheEntry}
 			}
 		}()
 	}
 
-	// Send all file paths to the jobs channel.
-	for _, file := range files {
-		jobs <- file
-	}
-	close(jobs) // Signal that no more jobs will be sent.
-
 	// Wait for all workers to finish, then close the results channel.
-	wg.Wait()
-	close(results)
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
 
 	// Collect results and check for errors.
-	fileHashes := make(map[string]string)
-	var totalSourceSize int64
+	processed := processedFiles{
+		ManifestHashes: make(map[string]string),
+		CacheEntries:   make(map[string]lib.FileCacheEntry),
+	}
+	var firstErr error
 	for res := range results {
 		if res.Err != nil {
-			return nil, 0, fmt.Errorf("failed to process file %s: %w", res.FilePath, res.Err)
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to process file %s: %w", res.FilePath, res.Err)
+				failed.Store(true)
+			}
+			continue
+		}
+		processed.ManifestHashes[res.FilePath] = res.ManifestHash
+		processed.TotalSize += res.TotalSize
+		processed.CacheEntries[res.FilePath] = res.CacheEntry
+		if res.Reused {
+			processed.Reused++
 		}
-		fileHashes[res.FilePath] = res.ManifestHash
-		totalSourceSize += res.TotalSize
 	}
+	if firstErr != nil {
+		return processedFiles{}, firstErr
+	}
+
+	return processed, nil
+}
 
-	return fileHashes, totalSourceSize, nil
+// fileCacheRacyWindow is how recently before a snap a file may have been
+// modified for the snap not to record it in the file cache. A file modified
+// again within its timestamp's granularity, which is 2 seconds on some
+// filesystems, would keep the same time, and a later snap would miss the
+// change.
+const fileCacheRacyWindow = 2 * time.Second
+
+// localStateDir returns the directory holding what this machine keeps about
+// the store's repository: inside the repository if it is local and
+// unencrypted, and otherwise in the user's cache directory, so that a remote
+// or encrypted repository does not give away the paths of the files snapped.
+func localStateDir(store *lib.ObjectStore) (string, error) {
+	backend := lib.UnwrapBackend(store.Backend())
+	if multi, ok := backend.(*lib.MultiBackend); ok {
+		backend = lib.UnwrapBackend(multi.Backends()[0])
+	}
+	if local, ok := backend.(*lib.LocalBackend); ok && store.Key() == nil {
+		return local.Location(), nil
+	}
+	return lib.DefaultCacheDir(store.Backend().Location())
 }
 
-// buildTree recursively traverses a directory path and constructs a Tree object,
-// saving it to the object store and returning its hash.
-func buildTree(store *lib.ObjectStore, baseDir, directoryPath string, fileHashes map[string]string) (string, error) {
-	entries := []types.TreeEntry{}
-	dirEntries, err := os.ReadDir(directoryPath)
+// fileCacheDir returns the directory holding the file caches of the store's
+// repository, within its local state directory.
+func fileCacheDir(store *lib.ObjectStore) (string, error) {
+	stateDir, err := localStateDir(store)
 	if err != nil {
 		return "", err
 	}
+	return filepath.Join(stateDir, lib.FileCacheDirName), nil
+}
+
+// loadFileCache returns the file cache that the last snap of sourceDir
+// recorded, as long as that snap still exists. A cache that cannot be read
+// only means that every file is read and every tree built again.
+func loadFileCache(store *lib.ObjectStore, sourceDir string) lib.FileCache {
+	cacheDir, err := fileCacheDir(store)
+	if err != nil {
+		return lib.FileCache{}
+	}
+	cache, err := lib.LoadFileCache(cacheDir, sourceDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring unreadable file cache: %v\n", err)
+		return lib.FileCache{}
+	}
+	if cache.SnapHash == "" {
+		return lib.FileCache{}
+	}
+	if exists, err := store.HasSnap(cache.SnapHash); err != nil || !exists {
+		return lib.FileCache{} // Its manifests and trees may have been pruned.
+	}
+	return cache
+}
 
-	for _, entry := range dirEntries {
-		fullPath := filepath.Join(directoryPath, entry.Name())
-		if lib.IsPathIgnored(baseDir, fullPath) {
-			continue
+// saveFileCache records the files and directories of the snap with the given
+// hash as the file cache of sourceDir, leaving out the files modified too
+// shortly before the snap started to be trusted. Their directories are then
+// built again too, as a file missing from the cache never matches.
+func saveFileCache(store *lib.ObjectStore, sourceDir, snapHash string, files map[string]lib.FileCacheEntry, dirs map[string]lib.DirCacheEntry,
+	streams bool, started time.Time) error {
+	cacheDir, err := fileCacheDir(store)
+	if err != nil {
+		return err
+	}
+	cutoff := started.Add(-fileCacheRacyWindow).UnixNano()
+	cache := lib.FileCache{SnapHash: snapHash, Files: make(map[string]lib.FileCacheEntry), Dirs: dirs, Streams: streams}
+	for path, entry := range files {
+		if entry.ModTime < cutoff {
+			cache.Files[path] = entry
 		}
+	}
+	return lib.SaveFileCache(cacheDir, sourceDir, cache)
+}
 
-		info, err := entry.Info()
-		if err != nil {
-			return "", err
-		}
+// treeBuilder builds the tree objects of a snap from its walk and its
+// processed files.
+type treeBuilder struct {
+	store *lib.ObjectStore
+	files processedFiles
+	// previous is the file cache of the last snap of the directory, whose
+	// trees are reused for the directories none of whose entries have
+	// changed.
+	previous lib.FileCache
+	// dirs records the tree built for each directory, for the next snap.
+	dirs   map[string]lib.DirCacheEntry
+	reused int // Trees taken from previous.
+}
+
+// newTreeBuilder returns a builder for a snap.
+func newTreeBuilder(store *lib.ObjectStore, files processedFiles, previous lib.FileCache) *treeBuilder {
+	return &treeBuilder{
+		store:    store,
+		files:    files,
+		previous: previous,
+		dirs:     make(map[string]lib.DirCacheEntry),
+	}
+}
+
+// sameOwner reports whether two owners, either of which may be missing,
+// are the same.
+func sameOwner(a, b *types.Owner) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// sameACL reports whether two ACLs, either of which may be missing, are the
+// same.
+func sameACL(a, b *types.ACL) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
 
-		if entry.IsDir() {
-			treeHash, err := buildTree(store, baseDir, fullPath, fileHashes)
+// sameXattrs reports whether two sets of extended attributes are the same.
+func sameXattrs(a, b map[string][]byte) bool {
+	return maps.EqualFunc(a, b, bytes.Equal)
+}
+
+// buildTree recursively constructs the Tree object of a walked directory,
+// bottom-up, saving it to the object store and returning its hash.
+func (b *treeBuilder) buildTree(dir *walkedDir) (string, error) {
+	entries := make([]types.TreeEntry, 0, len(dir.entries))
+
+	// The tree of the last snap holds as long as every entry has the same
+	// name, hash, mode, times, link, owner, ACL, attributes, streams, and
+	// extended attributes as then.
+	unchanged := true
+	for _, entry := range dir.entries {
+		if entry.special != "" {
+			// The file cache has no record of special files, so the
+			// directory's tree is built again.
+			unchanged = false
+			entries = append(entries, types.TreeEntry{
+				Name:       entry.name,
+				Type:       entry.special,
+				Mode:       entry.mode,
+				Rdev:       entry.rdev,
+				ModTime:    entry.modTime,
+				Owner:      entry.owner,
+				ACL:        entry.acl,
+				Attributes: entry.attrs,
+				BirthTime:  entry.birthTime,
+				Xattrs:     entry.xattrs,
+			})
+		} else if entry.dir != nil {
+			treeHash, err := b.buildTree(entry.dir)
 			if err != nil {
 				return "", err
 			}
+			cached, ok := b.previous.Dirs[entry.path]
+			unchanged = unchanged && ok && cached.TreeHash == treeHash && cached.Mode == entry.mode && cached.ModTime == entry.modTime &&
+				sameOwner(cached.Owner, entry.owner) && sameACL(cached.ACL, entry.acl) && cached.Attributes == entry.attrs &&
+				cached.BirthTime == entry.birthTime && sameXattrs(cached.Xattrs, entry.xattrs)
 			entries = append(entries, types.TreeEntry{
-				Name: entry.Name(),
-				Hash: treeHash,
-				Type: "tree",
-				Mode: uint32(info.Mode().Perm()),
+				Name:       entry.name,
+				Hash:       treeHash,
+				Type:       "tree",
+				Mode:       entry.mode,
+				ModTime:    entry.modTime,
+				Owner:      entry.owner,
+				ACL:        entry.acl,
+				Attributes: entry.attrs,
+				BirthTime:  entry.birthTime,
+				Xattrs:     entry.xattrs,
 			})
 		} else {
-			manifestHash, ok := fileHashes[fullPath]
+			manifestHash, ok := b.files.ManifestHashes[entry.path]
 			if !ok {
-				return "", fmt.Errorf("missing manifest hash for file: %s", fullPath)
+				return "", fmt.Errorf("missing manifest hash for file: %s", entry.path)
 			}
+			// The ACL and extended attributes come from the walk rather than
+			// the file's info, so the cache learns them here.
+			current := b.files.CacheEntries[entry.path]
+			current.ACL, current.Xattrs = entry.acl, entry.xattrs
+			b.files.CacheEntries[entry.path] = current
+			cached, ok := b.previous.Files[entry.path]
+			unchanged = unchanged && ok && cached.ManifestHash == manifestHash && cached.Mode == entry.mode && cached.ModTime == entry.modTime &&
+				cached.Link == entry.link && sameOwner(cached.Owner, entry.owner) && sameACL(cached.ACL, entry.acl) &&
+				cached.Attributes == entry.attrs && slices.Equal(cached.Streams, current.Streams) &&
+				cached.BirthTime == entry.birthTime && sameXattrs(cached.Xattrs, entry.xattrs)
 			entries = append(entries, types.TreeEntry{
-				Name: entry.Name(),
-				Hash: manifestHash,
-				Type: "blob",
-				Mode: uint32(info.Mode().Perm()),
+				Name:       entry.name,
+				Hash:       manifestHash,
+				Type:       "blob",
+				Mode:       entry.mode,
+				ModTime:    entry.modTime,
+				Link:       entry.link,
+				Owner:      entry.owner,
+				ACL:        entry.acl,
+				Attributes: entry.attrs,
+				Streams:    current.Streams,
+				BirthTime:  entry.birthTime,
+				Xattrs:     entry.xattrs,
 			})
 		}
 	}
 
+	// With every entry found in the last snap's directory, matching counts
+	// mean that none were removed either.
+	if cached, ok := b.previous.Dirs[dir.path]; ok && unchanged && cached.Entries == len(entries) {
+		b.reused++
+		b.dirs[dir.path] = lib.DirCacheEntry{Mode: dir.mode, ModTime: dir.modTime, Owner: dir.owner, ACL: dir.acl, Attributes: dir.attrs, BirthTime: dir.birthTime, Xattrs: dir.xattrs, Entries: len(entries), TreeHash: cached.TreeHash}
+		return cached.TreeHash, nil
+	}
+
 	// Sort entries for deterministic tree hashing.
 	sort.Slice(entries, func(i, j int) bool {
 		return entries[i].Name < entries[j].Name
@@ -183,89 +703,307 @@ func buildTree(store *lib.ObjectStore, baseDir, directoryPath string, fileHashes
 
 	tree := types.Tree{Entries: entries}
 	treeJSON, _ := json.Marshal(tree)
-	treeHash, err := store.WriteObject(treeJSON)
+	treeHash, err := b.store.WriteMetadataObject(treeJSON)
 	if err != nil {
 		return "", err
 	}
+	b.dirs[dir.path] = lib.DirCacheEntry{Mode: dir.mode, ModTime: dir.modTime, Owner: dir.owner, ACL: dir.acl, Attributes: dir.attrs, BirthTime: dir.birthTime, Xattrs: dir.xattrs, Entries: len(entries), TreeHash: treeHash}
 	return treeHash, nil
 }
 
 // Snap is the main function for the 'snap' command. It orchestrates the entire
 // snapshotting process.
-func Snap(targetDirectory string, message string) error {
+func Snap(targetDirectory string, options SnapOptions) error {
+	return SnapPaths([]string{targetDirectory}, options)
+}
+
+// SnapPaths takes a snap of several directories and files at once, whose root
+// tree holds each of them under the last element of its path. The snap is
+// stored in the repository of the current directory, unless another is
+// given. With a single directory, it is the same as Snap.
+func SnapPaths(paths []string, options SnapOptions) (err error) {
 	// 1. Initial setup and validation
-	absTargetPath, err := filepath.Abs(targetDirectory)
-	if err != nil {
-		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	if len(paths) == 0 {
+		return fmt.Errorf("no paths to snap")
 	}
-	if _, err := os.Stat(absTargetPath); os.IsNotExist(err) {
-		return fmt.Errorf("target directory does not exist: %s", absTargetPath)
+	absPaths := make([]string, len(paths))
+	names := make(map[string]string)
+	for i, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return fmt.Errorf("could not resolve absolute path for %s: %w", path, err)
+		}
+		if _, err := os.Stat(absPath); os.IsNotExist(err) {
+			if len(paths) == 1 {
+				return fmt.Errorf("target directory does not exist: %s", absPath)
+			}
+			return fmt.Errorf("path to snap does not exist: %s", absPath)
+		}
+		name := filepath.Base(absPath)
+		if other, ok := names[name]; ok && len(paths) > 1 {
+			return fmt.Errorf("%s and %s would both be named %s in the snap", other, absPath, name)
+		}
+		if name == string(filepath.Separator) && len(paths) > 1 {
+			return fmt.Errorf("%s cannot be snapped with other paths, as it has no name to hold it under", absPath)
+		}
+		absPaths[i], names[name] = absPath, absPath
 	}
 
-	fmt.Printf("📷 Starting snap for \"%s\"...\n", absTargetPath)
+	// absTargetPath is where the repository is found, unless one is given.
+	absTargetPath := absPaths[0]
+	if len(absPaths) > 1 {
+		if options.FilesFrom != "" {
+			return fmt.Errorf("--files-from cannot be used when snapping several paths")
+		}
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("could not get the current directory: %w", err)
+		}
+		absTargetPath = wd
+	}
+	sels := make([]*snapSelection, len(absPaths))
+	for i, absPath := range absPaths {
+		sel, err := newSnapSelection(absPath, options)
+		if err != nil {
+			return err
+		}
+		sels[i] = sel
+	}
 
-	if _, err := lib.EnsureBtoolDirs(absTargetPath); err != nil {
-		return fmt.Errorf("failed to ensure .btool directories: %w", err)
+	quoted := make([]string, len(absPaths))
+	for i, absPath := range absPaths {
+		quoted[i] = "\"" + absPath + "\""
 	}
+	fmt.Printf("📷 Starting snap for %s...\n", strings.Join(quoted, ", "))
 
-	store := lib.NewObjectStore(absTargetPath)
+	store, err := openWriteStore(options.RepositoryOptions, absTargetPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.SetMemoryLimit(options.MaxMemory * 1024 * 1024); err != nil {
+		return err
+	}
+	if !options.DryRun {
+		if err := storeMaxSize(store, options.MaxSize); err != nil {
+			return err
+		}
+	}
 
-	// 2. Find all files to be processed.
-	files, err := findAllFiles(absTargetPath)
+	// The latest snap so far becomes the parent of this one. Snaps that cannot
+	// be decrypted, such as by a write-only backup, are skipped, so one that
+	// reads none records no parent; failing to list them is an error.
+	snaps, err := store.GetSortedSnaps()
 	if err != nil {
-		return fmt.Errorf("error finding files: %w", err)
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	var parent string
+	var latest lib.SnapDetail
+	if len(snaps) > 0 {
+		latest = snaps[len(snaps)-1]
+		parent = latest.Hash
 	}
 
-	fmt.Printf("   - Found %d files to process...\n", len(files))
+	// The file cache of several paths is kept apart from that of any one.
+	cacheKey := absTargetPath
+	if len(absPaths) > 1 {
+		cacheKey = strings.Join(absPaths, string(os.PathListSeparator))
+	}
+	var previous lib.FileCache
+	if !options.ForceRescan {
+		previous = loadFileCache(store, cacheKey)
+	}
+	if options.Streams && !previous.Streams {
+		// The cached files may have streams that were not recorded.
+		previous.Files = nil
+	}
+
+	// The pre-snap hook runs before anything is read, and the post-snap hook
+	// once the snap is over, however it went.
+	outcome := snapOutcome{status: snapFailed}
+	if !options.DryRun {
+		hooks, hooksErr := loadSnapHooks(store, absPaths, options)
+		if hooksErr != nil {
+			return hooksErr
+		}
+		if err := hooks.runPre(); err != nil {
+			return err
+		}
+		defer func() {
+			outcome.err = err
+			err = hooks.runPost(outcome)
+		}()
+	}
+
+	// 2. Walk the directory tree once, handing each file to be processed to
+	// the workers as it is found.
+	started := time.Now()
+	files := make(chan string, runtime.NumCPU())
+	var root *walkedDir
+	var skippedSpecial int
+	var walkErr error
+	go func() {
+		defer close(files)
+		if len(absPaths) == 1 {
+			root, skippedSpecial, walkErr = walkTree(absTargetPath, localRepoDirs(store), sels[0], options.SpecialFiles, files)
+			return
+		}
+		root, skippedSpecial, walkErr = walkPaths(absPaths, localRepoDirs(store), sels, options.SpecialFiles, files)
+	}()
+
+	if options.DryRun {
+		estimate, err := estimateSnap(store, files, previous.Files)
+		if walkErr != nil {
+			return fmt.Errorf("error finding files: %w", walkErr)
+		}
+		if err != nil {
+			return err
+		}
+		estimate.print()
+		reportLargeFiles(sels, options)
+		return nil
+	}
 
-	// 3. Process files concurrently to generate chunks and manifests.
-	fileHashes, totalSourceSize, err := processFilesConcurrently(store, files)
+	// 3. Process files concurrently to generate chunks and manifests. The
+	// workers finish only once the walk has.
+	processed, err := processFilesConcurrently(store, files, previous.Files, options.Streams)
+	if walkErr != nil {
+		return fmt.Errorf("error finding files: %w", walkErr)
+	}
 	if err != nil {
 		return fmt.Errorf("error processing files: %w", err)
 	}
-	fmt.Println("   - Finished processing files.")
+	fmt.Printf("   - Finished processing %d files.\n", len(processed.ManifestHashes))
+	if processed.Reused > 0 {
+		fmt.Printf("   - Skipped %d unchanged file(s).\n", processed.Reused)
+	}
+	missing := sels[0].missing()
+	sort.Strings(missing)
+	for _, p := range missing {
+		fmt.Fprintf(os.Stderr, "Warning: %s, listed in %s, does not exist or is ignored\n", p, options.FilesFrom)
+	}
+	if skippedSpecial > 0 {
+		fmt.Printf("   - Left out %d FIFO(s), socket(s), and device node(s); pass --special-files to include them.\n", skippedSpecial)
+	}
+	reportLargeFiles(sels, options)
 
 	// 4. Build the directory tree structure.
-	rootTreeHash, err := buildTree(store, absTargetPath, absTargetPath, fileHashes)
+	trees := newTreeBuilder(store, processed, previous)
+	rootTreeHash, err := trees.buildTree(root)
 	if err != nil {
 		return fmt.Errorf("error building directory tree: %w", err)
 	}
+	if trees.reused > 0 {
+		fmt.Printf("   - Reused %d unchanged director(ies).\n", trees.reused)
+	}
 
 	// 5. Commit all pending objects to a new packfile.
 	snapSize, err := store.Commit()
 	if err != nil {
 		return fmt.Errorf("failed to commit objects: %w", err)
 	}
-
-	// 6. Create and save the final Snap object now that we have the size.
-	nextID, err := lib.GetNextSnapID(absTargetPath)
-	if err != nil {
-		return fmt.Errorf("failed to get next snapshot ID: %w", err)
+	if options.SkipIfUnchanged && parent != "" && latest.RootTreeHash == rootTreeHash {
+		// The latest snap refers to the same manifests and trees, so the file
+		// cache can refer to it instead.
+		if err := saveFileCache(store, cacheKey, parent, processed.CacheEntries, trees.dirs, options.Streams, started); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to save the file cache: %v\n", err)
+		}
+		fmt.Printf("✅ No changes since snap %d; no snap created.\n", latest.ID)
+		outcome.status, outcome.hash = snapUnchanged, latest.Hash
+		outcome.snap = types.Snap{ID: latest.ID, RootTreeHash: latest.RootTreeHash, SourceSize: latest.SourceSize, SnapSize: latest.SnapSize}
+		return nil
 	}
 
-	snap := types.Snap{
-		ID:           nextID,
-		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+	// 6. Create and save the final Snap object now that we have the size,
+	// as long as the repository has room for it.
+	if err := checkMaxSize(store, rootTreeHash); err != nil {
+		return err
+	}
+	outcome.snap = types.Snap{
 		RootTreeHash: rootTreeHash,
-		Message:      message,
-		SourceSize:   totalSourceSize,
+		Message:      options.Message,
+		SourceSize:   processed.TotalSize,
 		SnapSize:     snapSize,
+		Parent:       parent,
+		Paths:        absPaths,
 	}
-	snapJSON, _ := json.MarshalIndent(snap, "", "  ")
-	snapHash := lib.GetHash(snapJSON)
-	snapPath := filepath.Join(lib.GetSnapsDir(absTargetPath), snapHash+".json")
-	if err := os.WriteFile(snapPath, snapJSON, 0644); err != nil {
-		return fmt.Errorf("failed to write snap manifest: %w", err)
+	snapHash, err := recordSnap(store, &outcome.snap)
+	if err != nil {
+		return err
+	}
+	outcome.status, outcome.hash = snapCreated, snapHash
+
+	// The next snap reads only the files that have changed since this one.
+	if err := saveFileCache(store, cacheKey, snapHash, processed.CacheEntries, trees.dirs, options.Streams, started); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to save the file cache: %v\n", err)
+	}
+
+	// 7. Make room for the snap, if the repository has a max size.
+	return finishSnap(store, snapHash, rootTreeHash)
+}
+
+// recordSnap numbers snap with the next snap ID, stamps it with the time and
+// where it came from, and writes its manifest, returning its hash.
+func recordSnap(store *lib.ObjectStore, snap *types.Snap) (string, error) {
+	nextID, err := store.GetNextSnapID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get next snapshot ID: %w", err)
+	}
+	snap.ID = nextID
+	snap.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	snap.Hostname, snap.Username = hostname(), username()
+	snap.Version, snap.Command = lib.Version(), os.Args
+	snapHash, err := store.WriteSnap(*snap)
+	if err != nil {
+		return "", fmt.Errorf("failed to write snap manifest: %w", err)
 	}
 
 	// Increment the counter only after the snap is successfully written.
-	if err := lib.IncrementNextSnapID(absTargetPath); err != nil {
+	if err := store.IncrementNextSnapID(); err != nil {
 		// This is not a fatal error for the snap itself, but should be reported.
 		fmt.Fprintf(os.Stderr, "Warning: failed to increment snapshot counter: %v\n", err)
 	}
+	return snapHash, nil
+}
 
+// storeMaxSize stores maxSize, as lib.ParseSize reads it, as the most the
+// packs of the store's repository may take up, unless it is empty.
+func storeMaxSize(store *lib.ObjectStore, maxSize string) error {
+	if maxSize == "" {
+		return nil
+	}
+	size, err := lib.ParseSize(maxSize)
+	if err != nil {
+		return err
+	}
+	if !canReadAllSnaps(store) {
+		return fmt.Errorf("--max-size needs the repository's password or age identity, since a write-only backup cannot tell which data the other snaps need")
+	}
+	defaults, err := store.LoadDefaults()
+	if err != nil {
+		return fmt.Errorf("failed to read the repository defaults: %w", err)
+	}
+	if size == defaults.MaxSize {
+		return nil
+	}
+	defaults.MaxSize = size
+	if err := store.WriteDefaults(defaults); err != nil {
+		return fmt.Errorf("failed to store the max size: %w", err)
+	}
+	return nil
+}
+
+// finishSnap reports a snap that was written, then makes room for it if the
+// repository has a max size.
+func finishSnap(store *lib.ObjectStore, snapHash, rootTreeHash string) error {
+	reportDestinations(store)
 	fmt.Println("✅ Snap complete!")
 	fmt.Printf("   - Snap Hash: %s\n", snapHash)
 	fmt.Printf("   - Root Tree Hash: %s\n", rootTreeHash)
-	return nil
+
+	defaults, err := store.LoadDefaults()
+	if err != nil {
+		return fmt.Errorf("failed to read the repository defaults: %w", err)
+	}
+	return enforceMaxSize(store, defaults.MaxSize)
 }
@@ -0,0 +1,101 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// snapEstimate is what a dry run found that a snap would hold and add to the
+// repository.
+type snapEstimate struct {
+	files     int   // Files the snap would hold.
+	unchanged int   // Of those, the ones the file cache vouches for.
+	totalSize int64 // Bytes of all the files.
+	newSize   int64 // Bytes of the chunks that are not stored yet.
+}
+
+// estimateSnap handles the files sent to jobs as a snap would, reading and
+// chunking those that changed since the file cache was written, but writes
+// nothing. Chunks count as new unless the index, or a file read before,
+// holds them already, so the estimate is of the data before compression. A
+// store that cannot read the index counts every chunk of a changed file.
+func estimateSnap(store *lib.ObjectStore, jobs <-chan string, previous map[string]lib.FileCacheEntry) (snapEstimate, error) {
+	index, err := store.GetIndex()
+	if err != nil {
+		for range jobs {
+		}
+		return snapEstimate{}, fmt.Errorf("failed to get current index: %w", err)
+	}
+
+	var (
+		estimate snapEstimate
+		seen     = make(map[string]bool)
+		mutex    sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	numWorkers := runtime.NumCPU()
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for filePath := range jobs {
+				mutex.Lock()
+				failed := firstErr != nil
+				mutex.Unlock()
+				if failed {
+					continue // Drain the jobs, so that the walk can finish.
+				}
+
+				info, err := os.Stat(filePath)
+				var chunkRefs []types.ChunkRef
+				unchanged := false
+				if err == nil {
+					cached, ok := previous[filePath]
+					unchanged = ok && cached.Unchanged(lib.NewFileCacheEntry(info))
+					if !unchanged {
+						chunkRefs, _, err = lib.ChunkFileParallel(filePath, store.Chunker(), store.Hasher(), numWorkers, func(types.Chunk) error {
+							return nil
+						})
+					}
+				}
+
+				mutex.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to read file %s: %w", filePath, err)
+					}
+					mutex.Unlock()
+					continue
+				}
+				estimate.files++
+				estimate.totalSize += info.Size()
+				if unchanged {
+					estimate.unchanged++
+				}
+				for _, ref := range chunkRefs {
+					if _, stored := index[ref.Hash]; stored || seen[ref.Hash] {
+						continue
+					}
+					seen[ref.Hash] = true
+					estimate.newSize += ref.Size
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return estimate, firstErr
+}
+
+// print reports the estimate of a dry run of snap.
+func (e snapEstimate) print() {
+	fmt.Printf("🔍 A snap would hold %d file(s), %s in all:\n", e.files, formatBytes(e.totalSize, 2))
+	fmt.Printf("   - %d file(s) would be read and %d skipped as unchanged.\n", e.files-e.unchanged, e.unchanged)
+	fmt.Printf("   - About %s of new data would be added, before compression.\n", formatBytes(e.newSize, 2))
+}
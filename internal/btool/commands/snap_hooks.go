@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// The hooks run around a snap, by the names of their files in the hooks
+// directory.
+const (
+	preSnapHook  = "pre-snap"
+	postSnapHook = "post-snap"
+)
+
+// hooksDirName is the name of the directory of a repository's hooks, within
+// its local state directory.
+const hooksDirName = "hooks"
+
+// The statuses a post-snap hook is told of in BTOOL_SNAP_STATUS.
+const (
+	snapCreated   = "created"
+	snapUnchanged = "unchanged"
+	snapFailed    = "failed"
+)
+
+// snapHooks are the commands run before a snap walks anything and after it
+// is written, such as to quiesce a service and to send a notification.
+type snapHooks struct {
+	// pre and post are the commands, as arguments, or nil for none.
+	pre, post []string
+	// env describes the snap to both of them.
+	env []string
+}
+
+// snapOutcome is what a post-snap hook is told about the snap.
+type snapOutcome struct {
+	status string
+	snap   types.Snap
+	hash   string
+	err    error
+}
+
+// loadSnapHooks returns the hooks of a snap of paths into store: the
+// commands options give, run with sh, or else the executable files named
+// pre-snap and post-snap in the repository's hooks directory.
+func loadSnapHooks(store *lib.ObjectStore, paths []string, options SnapOptions) (*snapHooks, error) {
+	hooks := &snapHooks{env: []string{
+		"BTOOL_REPO=" + store.Backend().Location(),
+		"BTOOL_PATHS=" + strings.Join(paths, string(os.PathListSeparator)),
+	}}
+	if options.PreSnap != "" {
+		hooks.pre = []string{"sh", "-c", options.PreSnap}
+	}
+	if options.PostSnap != "" {
+		hooks.post = []string{"sh", "-c", options.PostSnap}
+	}
+	if hooks.pre != nil && hooks.post != nil {
+		return hooks, nil
+	}
+
+	dir, err := hooksDir(store)
+	if err != nil {
+		return nil, err
+	}
+	for _, hook := range []struct {
+		name    string
+		command *[]string
+	}{{preSnapHook, &hooks.pre}, {postSnapHook, &hooks.post}} {
+		if *hook.command != nil {
+			continue
+		}
+		file := filepath.Join(dir, hook.name)
+		info, err := os.Stat(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read the %s hook: %w", hook.name, err)
+		}
+		if info.Mode()&0111 == 0 {
+			fmt.Fprintf(os.Stderr, "Warning: ignoring the %s hook %s, which is not executable\n", hook.name, file)
+			continue
+		}
+		*hook.command = []string{file}
+	}
+	return hooks, nil
+}
+
+// hooksDir returns the directory of the hooks of the store's repository,
+// within its local state directory. Hooks are never read from the repository
+// itself, so that whoever can write to a shared repository cannot run
+// commands on the machines that snap into it.
+func hooksDir(store *lib.ObjectStore) (string, error) {
+	stateDir, err := localStateDir(store)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(stateDir, hooksDirName), nil
+}
+
+// runPre runs the pre-snap hook, if there is one. The snap is not taken if
+// it fails.
+func (h *snapHooks) runPre() error {
+	if h == nil || h.pre == nil {
+		return nil
+	}
+	if err := h.run(preSnapHook, h.pre, nil); err != nil {
+		return fmt.Errorf("%s hook failed, so no snap was taken: %w", preSnapHook, err)
+	}
+	return nil
+}
+
+// runPost runs the post-snap hook, if there is one, telling it how the snap
+// went, and returns the error of the snap, or else that of the hook.
+func (h *snapHooks) runPost(outcome snapOutcome) error {
+	if h == nil || h.post == nil {
+		return outcome.err
+	}
+	env := []string{"BTOOL_SNAP_STATUS=" + outcome.status}
+	if outcome.hash != "" {
+		env = append(env,
+			"BTOOL_SNAP_ID="+strconv.FormatInt(outcome.snap.ID, 10),
+			"BTOOL_SNAP_HASH="+outcome.hash,
+			"BTOOL_ROOT_TREE_HASH="+outcome.snap.RootTreeHash,
+			"BTOOL_SOURCE_SIZE="+strconv.FormatInt(outcome.snap.SourceSize, 10),
+			"BTOOL_SNAP_SIZE="+strconv.FormatInt(outcome.snap.SnapSize, 10))
+	}
+	if outcome.err != nil {
+		env = append(env, "BTOOL_SNAP_ERROR="+outcome.err.Error())
+	}
+	err := h.run(postSnapHook, h.post, env)
+	switch {
+	case outcome.err != nil:
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s hook failed: %v\n", postSnapHook, err)
+		}
+		return outcome.err
+	case err != nil:
+		return fmt.Errorf("%s hook failed: %w", postSnapHook, err)
+	}
+	return nil
+}
+
+// run runs a hook with the environment of btool, the variables describing
+// the snap, and env, passing its output through.
+func (h *snapHooks) run(name string, command, env []string) error {
+	fmt.Printf("   - Running the %s hook...\n", name)
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = append(append(append(os.Environ(), "BTOOL_HOOK="+name), h.env...), env...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,201 @@
+package commands
+
+import (
+	"cmp"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+)
+
+// snapSelection selects the paths a snap holds beyond what .btoolignore
+// leaves out. Paths matching an exclude pattern are left out. With include
+// patterns or listed paths, only the paths they match, everything below the
+// directories they match, and the directories on the way to those are held.
+// Files larger than maxFileSize, if it is set, are left out too. A nil
+// snapSelection selects every path.
+type snapSelection struct {
+	filter *lib.PathFilter
+	// maxFileSize is the size in bytes above which files are left out, or
+	// 0 for no limit, and large holds the files it left out.
+	maxFileSize int64
+	large       []largeFile
+	// listed holds the paths from --files-from, relative to the snapped
+	// directory with forward slashes, and listedDirs the directories above
+	// them.
+	listed, listedDirs map[string]bool
+	// found holds the listed paths the walk came across.
+	found map[string]bool
+}
+
+// newSnapSelection returns the selection that options make of the paths
+// below rootDir, or nil if they make none.
+func newSnapSelection(rootDir string, options SnapOptions) (*snapSelection, error) {
+	exclude, err := excludePatterns(options.Exclude, options.ExcludeFiles)
+	if err != nil {
+		return nil, err
+	}
+	sel := &snapSelection{filter: lib.NewPathFilter(options.Include, exclude), maxFileSize: options.ExcludeLargerThan}
+	if options.FilesFrom != "" {
+		if sel.listed, err = readFileList(rootDir, options.FilesFrom); err != nil {
+			return nil, err
+		}
+		sel.listedDirs = make(map[string]bool)
+		for p := range sel.listed {
+			for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+				sel.listedDirs[dir] = true
+			}
+		}
+		sel.found = make(map[string]bool)
+	}
+	if sel.filter == nil && sel.listed == nil && sel.maxFileSize == 0 {
+		return nil, nil
+	}
+	return sel, nil
+}
+
+// readFileList reads the paths listed one per line in the named file, which
+// are either absolute or relative to rootDir and must lie inside it. Blank
+// lines and lines starting with # are skipped.
+func readFileList(rootDir, name string) (map[string]bool, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file list: %w", err)
+	}
+	listed := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := filepath.FromSlash(line)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(rootDir, p)
+		}
+		rel := relativeSlashPath(rootDir, filepath.Clean(p))
+		if rel == "." {
+			return nil, fmt.Errorf("%s lists the snapped directory itself; leave out --files-from to snap all of it", name)
+		}
+		if rel == ".." || strings.HasPrefix(rel, "../") || filepath.IsAbs(rel) {
+			return nil, fmt.Errorf("%s lists %s, which is outside %s", name, line, rootDir)
+		}
+		listed[rel] = true
+	}
+	return listed, nil
+}
+
+// restricts tells whether the selection holds only what its include
+// patterns and listed paths match.
+func (s *snapSelection) restricts() bool {
+	return s != nil && (s.filter.HasIncludes() || s.listed != nil)
+}
+
+// excludes tells whether an exclude pattern matches rel.
+func (s *snapSelection) excludes(rel string, isDir bool) bool {
+	return s != nil && s.filter.Excludes(rel, isDir)
+}
+
+// includes tells whether an include pattern or a listed path matches rel,
+// noting the listed paths found.
+func (s *snapSelection) includes(rel string, isDir bool) bool {
+	if !s.restricts() {
+		return true
+	}
+	if s.listed[rel] {
+		s.found[rel] = true
+		return true
+	}
+	return s.filter.HasIncludes() && s.filter.Includes(rel, isDir)
+}
+
+// mayHoldSelected tells whether the directory rel, which is not selected
+// itself, may hold paths that are, and so must be walked.
+func (s *snapSelection) mayHoldSelected(rel string) bool {
+	return s.filter.HasIncludes() || s.listedDirs[rel]
+}
+
+// largeFile is a file that was left out of a snap for its size.
+type largeFile struct {
+	path string
+	size int64
+}
+
+// tooLarge tells whether the regular file at path, whose info is given, is
+// larger than the selection allows, noting it if so.
+func (s *snapSelection) tooLarge(path string, info fs.FileInfo) bool {
+	if s == nil || s.maxFileSize == 0 || info.Size() <= s.maxFileSize {
+		return false
+	}
+	s.large = append(s.large, largeFile{path: path, size: info.Size()})
+	return true
+}
+
+// maxListedLargeFiles is how many of the files left out for their size a
+// snap names, the largest first.
+const maxListedLargeFiles = 10
+
+// reportLargeFiles reports the files that the selections left out for their
+// size, if any.
+func reportLargeFiles(sels []*snapSelection, options SnapOptions) {
+	var large []largeFile
+	var total int64
+	for _, sel := range sels {
+		if sel == nil {
+			continue
+		}
+		for _, file := range sel.large {
+			large = append(large, file)
+			total += file.size
+		}
+	}
+	if len(large) == 0 {
+		return
+	}
+	slices.SortFunc(large, func(a, b largeFile) int {
+		return cmp.Or(cmp.Compare(b.size, a.size), strings.Compare(a.path, b.path))
+	})
+	fmt.Printf("   - Left out %d file(s) larger than %s, %s in all:\n", len(large), formatBytes(options.ExcludeLargerThan, 2), formatBytes(total, 2))
+	for i, file := range large {
+		if i == maxListedLargeFiles {
+			fmt.Printf("       ...and %d more.\n", len(large)-i)
+			break
+		}
+		fmt.Printf("       %s (%s)\n", file.path, formatBytes(file.size, 2))
+	}
+}
+
+// missing returns the listed paths that the walk did not come across, as
+// they do not exist or are left out.
+func (s *snapSelection) missing() []string {
+	if s == nil {
+		return nil
+	}
+	var missing []string
+	for p := range s.listed {
+		if !s.found[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// pruneUnselected removes the directories below dir that are neither
+// selected themselves nor hold anything that is, so that a restricted snap
+// holds no empty directories it did not select. It reports whether dir
+// still holds anything.
+func pruneUnselected(dir *walkedDir) bool {
+	entries := dir.entries[:0]
+	for _, entry := range dir.entries {
+		if entry.dir != nil && !pruneUnselected(entry.dir) && !entry.dir.selected {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	dir.entries = entries
+	return len(entries) > 0
+}
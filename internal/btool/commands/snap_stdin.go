@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// DefaultStdinFilename names the file that a snap of standard input holds
+// when no other name is given.
+const DefaultStdinFilename = "stdin"
+
+// SnapStdin takes a snap holding a single file, named options.StdinFilename,
+// with what it reads from reader, such as the output of a database dump piped
+// into btool. The stream is chunked as it is read, so it is never held in
+// full. The snap is stored in the repository of the current directory, unless
+// another is given.
+func SnapStdin(reader io.Reader, options SnapOptions) (err error) {
+	name := options.StdinFilename
+	if name == "" {
+		name = DefaultStdinFilename
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("invalid name for standard input %q: it must be a file name, without directories", name)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("could not get the current directory: %w", err)
+	}
+
+	fmt.Printf("📷 Starting snap of standard input as \"%s\"...\n", name)
+
+	store, err := openWriteStore(options.RepositoryOptions, wd)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if err := store.SetMemoryLimit(options.MaxMemory * 1024 * 1024); err != nil {
+		return err
+	}
+	if err := storeMaxSize(store, options.MaxSize); err != nil {
+		return err
+	}
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	var parent string
+	if len(snaps) > 0 {
+		parent = snaps[len(snaps)-1].Hash
+	}
+
+	hooks, err := loadSnapHooks(store, []string{name}, options)
+	if err != nil {
+		return err
+	}
+	if err := hooks.runPre(); err != nil {
+		return err
+	}
+	outcome := snapOutcome{status: snapFailed}
+	defer func() {
+		outcome.err = err
+		err = hooks.runPost(outcome)
+	}()
+
+	// Write each chunk as it is cut, as for the files of a snap.
+	writeChunk := store.WriteObject
+	if lib.HasCompressedExtension(name) {
+		writeChunk = store.WriteIncompressibleObject
+	}
+	var chunkRefs []types.ChunkRef
+	totalSize, err := lib.ChunkReader(reader, store.Chunker(), store.Hasher(), func(chunk types.Chunk) error {
+		chunkRefs = append(chunkRefs, types.ChunkRef{Hash: chunk.Hash, Size: chunk.Size})
+		_, err := writeChunk(chunk.Data)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error reading standard input: %w", err)
+	}
+	manifestJSON, _ := json.Marshal(types.FileManifest{Chunks: chunkRefs, TotalSize: totalSize})
+	manifestHash, err := store.WriteMetadataObject(manifestJSON)
+	if err != nil {
+		return fmt.Errorf("error writing the file manifest: %w", err)
+	}
+	fmt.Printf("   - Read %s from standard input.\n", formatBytes(totalSize, 2))
+
+	treeJSON, _ := json.Marshal(types.Tree{Entries: []types.TreeEntry{{
+		Name:    name,
+		Hash:    manifestHash,
+		Type:    "blob",
+		Mode:    0644,
+		ModTime: time.Now().UnixNano(),
+	}}})
+	rootTreeHash, err := store.WriteMetadataObject(treeJSON)
+	if err != nil {
+		return fmt.Errorf("error building directory tree: %w", err)
+	}
+
+	snapSize, err := store.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit objects: %w", err)
+	}
+	if err := checkMaxSize(store, rootTreeHash); err != nil {
+		return err
+	}
+	outcome.snap = types.Snap{
+		RootTreeHash: rootTreeHash,
+		Message:      options.Message,
+		SourceSize:   totalSize,
+		SnapSize:     snapSize,
+		Parent:       parent,
+	}
+	snapHash, err := recordSnap(store, &outcome.snap)
+	if err != nil {
+		return err
+	}
+	outcome.status, outcome.hash = snapCreated, snapHash
+	return finishSnap(store, snapHash, rootTreeHash)
+}
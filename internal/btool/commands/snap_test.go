@@ -3,10 +3,17 @@
 package commands_test
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"filippo.io/age"
 
 	// We must now explicitly import the packages we are testing or using.
 	"github.com/gingerrexayers/btool-go/internal/btool/commands"
@@ -49,7 +56,7 @@ func TestSnapCommand(t *testing.T) {
 	testDir := setupTestDir(t)
 
 	// 2. Act: Call the public Snap function from the 'commands' package.
-	err := commands.Snap(testDir, "My first integration test snap")
+	err := commands.Snap(testDir, commands.SnapOptions{Message: "My first integration test snap"})
 	require.NoError(t, err, "commands.Snap() failed unexpectedly")
 
 	// 3. Assert - Check the filesystem state after the command has run.
@@ -86,7 +93,7 @@ func TestSnapCommand(t *testing.T) {
 	assert.Equal(t, expectedSize, snapData.SourceSize)
 
 	// Read and verify the root tree from the object store.
-	store := lib.NewObjectStore(testDir)
+	store := lib.NewLocalObjectStore(testDir)
 	var rootTree types.Tree
 	err = store.ReadObjectAsJSON(snapData.RootTreeHash, &rootTree)
 	require.NoError(t, err, "Could not read root tree object from store")
@@ -133,17 +140,17 @@ func TestSnapCommand_EmptyDir(t *testing.T) {
 	testDir := t.TempDir()
 
 	// Act: Take a snapshot of the empty directory.
-	err := commands.Snap(testDir, "empty dir snap")
+	err := commands.Snap(testDir, commands.SnapOptions{Message: "empty dir snap"})
 	require.NoError(t, err, "Snap command failed for an empty directory")
 
 	// Assert: A snapshot was created.
-	snaps, err := lib.GetSortedSnaps(testDir)
+	snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
 	require.NoError(t, err, "Failed to get snaps after snapshotting empty dir")
 	require.Len(t, snaps, 1, "Expected 1 snapshot for empty dir")
 	assert.Equal(t, "empty dir snap", snaps[0].Message, "Incorrect snap message")
 
 	// Assert: The root tree is empty.
-	store := lib.NewObjectStore(testDir)
+	store := lib.NewLocalObjectStore(testDir)
 	var rootTree types.Tree
 	err = store.ReadObjectAsJSON(snaps[0].RootTreeHash, &rootTree)
 	require.NoError(t, err, "Failed to read root tree of empty snap")
@@ -151,7 +158,7 @@ func TestSnapCommand_EmptyDir(t *testing.T) {
 
 	// Act: Restore the snapshot to a new directory.
 	outputDir := t.TempDir()
-	err = commands.Restore(testDir, snaps[0].Hash, outputDir)
+	err = commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: snaps[0].Hash, OutputDir: outputDir})
 	require.NoError(t, err, "Failed to restore empty snapshot")
 
 	// Assert: The restored directory is empty.
@@ -159,3 +166,1428 @@ func TestSnapCommand_EmptyDir(t *testing.T) {
 	require.NoError(t, err, "Could not read restored directory")
 	assert.Empty(t, files, "Restored directory is not empty")
 }
+
+func TestSnapCommand_Walk(t *testing.T) {
+	t.Run("should snap a deep tree in one walk", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		deepDir := filepath.Join(testDir, "a", "b", "c")
+		require.NoError(t, os.MkdirAll(deepDir, 0755))
+		require.NoError(t, os.Mkdir(filepath.Join(testDir, "a", "empty"), 0755))
+		for i := 0; i < 50; i++ {
+			name := "file" + strconv.Itoa(i) + ".txt"
+			require.NoError(t, os.WriteFile(filepath.Join(deepDir, name), []byte("content "+name), 0644))
+		}
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		restored, err := os.ReadFile(filepath.Join(outputDir, "a", "b", "c", "file42.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "content file42.txt", string(restored))
+		assert.DirExists(t, filepath.Join(outputDir, "a", "empty"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "app.log"))
+	})
+
+	t.Run("should snap and restore paths longer than Windows' MAX_PATH", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		deepDir := testDir
+		for len(deepDir) < 300 {
+			deepDir = filepath.Join(deepDir, "node_modules")
+		}
+		require.NoError(t, os.MkdirAll(deepDir, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(deepDir, "index.js"), []byte("module.exports = {}"), 0644))
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		relative, err := filepath.Rel(testDir, deepDir)
+		require.NoError(t, err)
+		restored, err := os.ReadFile(filepath.Join(outputDir, relative, "index.js"))
+		require.NoError(t, err)
+		assert.Equal(t, "module.exports = {}", string(restored))
+	})
+
+	t.Run("should leave out files that are not regular files", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		if err := os.Symlink(filepath.Join(testDir, "fileA.txt"), filepath.Join(testDir, "link.txt")); err != nil {
+			t.Skipf("cannot create symlinks here: %v", err)
+		}
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		assert.FileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		_, err = os.Lstat(filepath.Join(outputDir, "link.txt"))
+		assert.True(t, os.IsNotExist(err), "The symlink should not be snapped")
+	})
+}
+
+func TestSnapCommand_Exclude(t *testing.T) {
+	t.Run("should leave out paths matching exclude patterns and files besides .btoolignore", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "dump.sql"), []byte("a database dump"), 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(testDir, "tmp"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "tmp", "scratch.txt"), []byte("scratch"), 0644))
+		excludeFile := filepath.Join(t.TempDir(), "excludes.txt")
+		require.NoError(t, os.WriteFile(excludeFile, []byte("# Scratch space\ntmp/\n"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{Exclude: []string{"*.sql", "subdir/fileC.txt"}, ExcludeFiles: []string{excludeFile}})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		assert.FileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "dump.sql"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "subdir", "fileC.txt"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "tmp"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "app.log"), ".btoolignore should still apply")
+	})
+
+	t.Run("should return an error for a missing exclude file", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{ExcludeFiles: []string{filepath.Join(testDir, "missing.txt")}})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not read exclude file")
+	})
+}
+
+func TestSnapCommand_ExcludeLargerThan(t *testing.T) {
+	t.Run("should leave out and list the files larger than the limit", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "disk.img"), make([]byte, 4096), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "subdir", "backup.iso"), make([]byte, 2048), 0644))
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = commands.Snap(testDir, commands.SnapOptions{ExcludeLargerThan: 1024})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.Contains(t, output, "Left out 2 file(s) larger than 1.00 KB, 6.00 KB in all:")
+		assert.Less(t, strings.Index(output, "disk.img"), strings.Index(output, "backup.iso"), "the largest file should be listed first")
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		assert.FileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "disk.img"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "subdir", "backup.iso"))
+	})
+
+	t.Run("should report nothing when no file is larger than the limit", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = commands.Snap(testDir, commands.SnapOptions{ExcludeLargerThan: 1024})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.NotContains(t, output, "Left out")
+		assert.Contains(t, output, "Finished processing 3 files.")
+	})
+}
+
+func TestSnapCommand_Include(t *testing.T) {
+	t.Run("should hold only the paths matching include patterns and the directories on the way", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(testDir, "db", "dumps"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "db", "dumps", "main.sql"), []byte("a database dump"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "db", "notes.txt"), []byte("notes"), 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(testDir, "docs"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "docs", "guide.md"), []byte("a guide"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{Include: []string{"*.sql", "docs/"}})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		assert.FileExists(t, filepath.Join(outputDir, "db", "dumps", "main.sql"))
+		assert.FileExists(t, filepath.Join(outputDir, "docs", "guide.md"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "db", "notes.txt"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "subdir"), "directories holding nothing selected should be left out")
+	})
+
+	t.Run("should hold only the files and directories listed in a file", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, os.MkdirAll(filepath.Join(testDir, "a", "b"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "a", "b", "deep.txt"), []byte("deep"), 0644))
+		list := filepath.Join(t.TempDir(), "list.txt")
+		content := "# Curated files\nfileA.txt\n" + filepath.Join(testDir, "a") + "\n\nmissing.txt\n"
+		require.NoError(t, os.WriteFile(list, []byte(content), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{FilesFrom: list})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		assert.FileExists(t, filepath.Join(outputDir, "fileA.txt"))
+		assert.FileExists(t, filepath.Join(outputDir, "a", "b", "deep.txt"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileB.txt"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "subdir"))
+	})
+
+	t.Run("should return an error for a listed path outside the directory", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		list := filepath.Join(t.TempDir(), "list.txt")
+		require.NoError(t, os.WriteFile(list, []byte("../elsewhere.txt\n"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{FilesFrom: list})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "which is outside")
+	})
+}
+
+func TestSnapCommand_Paths(t *testing.T) {
+	t.Run("should hold several directories and files at the top of one snap", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		base := t.TempDir()
+		etc := filepath.Join(base, "etc")
+		www := filepath.Join(base, "var", "www")
+		require.NoError(t, os.MkdirAll(etc, 0755))
+		require.NoError(t, os.MkdirAll(www, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(etc, "hosts"), []byte("127.0.0.1 localhost"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(www, "index.html"), []byte("<html></html>"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(base, "notes.txt"), []byte("notes"), 0644))
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		repo := commands.RepositoryOptions{Repo: repoDir}
+
+		// Act
+		err := commands.SnapPaths([]string{etc, www, filepath.Join(base, "notes.txt")}, commands.SnapOptions{RepositoryOptions: repo})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(base, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RepositoryOptions: repo}))
+		hosts, err := os.ReadFile(filepath.Join(outputDir, "etc", "hosts"))
+		require.NoError(t, err)
+		assert.Equal(t, "127.0.0.1 localhost", string(hosts))
+		assert.FileExists(t, filepath.Join(outputDir, "www", "index.html"))
+		assert.FileExists(t, filepath.Join(outputDir, "notes.txt"))
+		assert.NoDirExists(t, filepath.Join(outputDir, "var"))
+	})
+
+	t.Run("should return an error for paths that would have the same name", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		base := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(base, "a", "data"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(base, "b", "data"), 0755))
+
+		// Act
+		err := commands.SnapPaths([]string{filepath.Join(base, "a", "data"), filepath.Join(base, "b", "data")},
+			commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Repo: filepath.Join(base, "repo")}})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "would both be named data")
+	})
+}
+
+func TestSnapCommand_DryRun(t *testing.T) {
+	t.Run("should report what a snap would hold and add without writing anything", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		packs, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileA.txt"), []byte("changed content A"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "new.txt"), []byte("identical content"), 0644))
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = commands.Snap(testDir, commands.SnapOptions{ForceRescan: true, DryRun: true})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.Contains(t, output, "A snap would hold 4 file(s)")
+		assert.Contains(t, output, "4 file(s) would be read and 0 skipped as unchanged.")
+		assert.Contains(t, output, "About 17.00 Bytes of new data would be added", "only the changed file's contents are new")
+		lib.ResetObjectStoreState()
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, snaps, 1)
+		packsAfter, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		assert.Len(t, packsAfter, len(packs))
+	})
+}
+
+func TestSnapCommand_Stdin(t *testing.T) {
+	t.Run("should snap a stream as a single file", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		repo := commands.RepositoryOptions{Repo: repoDir}
+		dump := strings.Repeat("INSERT INTO t VALUES (1);\n", 10000)
+
+		// Act
+		err := commands.SnapStdin(strings.NewReader(dump), commands.SnapOptions{StdinFilename: "dump.sql", Message: "nightly dump", RepositoryOptions: repo})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(t.TempDir(), commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RepositoryOptions: repo}))
+		restored, err := os.ReadFile(filepath.Join(outputDir, "dump.sql"))
+		require.NoError(t, err)
+		assert.Equal(t, dump, string(restored))
+		entries, err := os.ReadDir(outputDir)
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("should return an error for a name with directories", func(t *testing.T) {
+		// Act
+		err := commands.SnapStdin(strings.NewReader("data"), commands.SnapOptions{StdinFilename: "backups/dump.sql"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "without directories")
+	})
+}
+
+func TestSnapCommand_Hooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hooks are shell scripts")
+	}
+
+	// readHookEnv reads the BTOOL_ variables a hook wrote to the named file.
+	readHookEnv := func(t *testing.T, name string) map[string]string {
+		t.Helper()
+		content, err := os.ReadFile(name)
+		require.NoError(t, err)
+		env := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			key, value, _ := strings.Cut(line, "=")
+			env[key] = value
+		}
+		return env
+	}
+
+	t.Run("should tell the post-snap hook about the snap", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		envFile := filepath.Join(t.TempDir(), "env")
+		options := commands.SnapOptions{PostSnap: "env | grep ^BTOOL_ > " + envFile, RepositoryOptions: commands.RepositoryOptions{Repo: repoDir}}
+
+		// Act
+		err := commands.Snap(testDir, options)
+
+		// Assert
+		require.NoError(t, err)
+		snaps, err := lib.NewObjectStore(lib.NewLocalBackend(repoDir)).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 1)
+		env := readHookEnv(t, envFile)
+		assert.Equal(t, "post-snap", env["BTOOL_HOOK"])
+		assert.Equal(t, "created", env["BTOOL_SNAP_STATUS"])
+		assert.Equal(t, "1", env["BTOOL_SNAP_ID"])
+		assert.Equal(t, snaps[0].Hash, env["BTOOL_SNAP_HASH"])
+		assert.Equal(t, snaps[0].RootTreeHash, env["BTOOL_ROOT_TREE_HASH"])
+		assert.Equal(t, strconv.FormatInt(snaps[0].SourceSize, 10), env["BTOOL_SOURCE_SIZE"])
+		assert.Equal(t, testDir, env["BTOOL_PATHS"])
+		assert.NotContains(t, env, "BTOOL_SNAP_ERROR")
+	})
+
+	t.Run("should run the hooks in the repository's hooks directory", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		repo := commands.RepositoryOptions{Repo: repoDir}
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: repo}))
+		envDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(repoDir, "hooks"), 0755))
+		for _, hook := range []string{"pre-snap", "post-snap"} {
+			script := "#!/bin/sh\nenv | grep ^BTOOL_ > " + filepath.Join(envDir, hook) + "\n"
+			require.NoError(t, os.WriteFile(filepath.Join(repoDir, "hooks", hook), []byte(script), 0755))
+		}
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{SkipIfUnchanged: true, RepositoryOptions: repo})
+
+		// Assert
+		require.NoError(t, err)
+		pre := readHookEnv(t, filepath.Join(envDir, "pre-snap"))
+		assert.Equal(t, "pre-snap", pre["BTOOL_HOOK"])
+		assert.NotContains(t, pre, "BTOOL_SNAP_STATUS")
+		post := readHookEnv(t, filepath.Join(envDir, "post-snap"))
+		assert.Equal(t, "unchanged", post["BTOOL_SNAP_STATUS"])
+		assert.Equal(t, "1", post["BTOOL_SNAP_ID"], "the unchanged snap is the latest one")
+	})
+
+	t.Run("should take no snap if the pre-snap hook fails", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+		envFile := filepath.Join(t.TempDir(), "env")
+		options := commands.SnapOptions{PreSnap: "exit 3", PostSnap: "touch " + envFile}
+
+		// Act
+		err := commands.Snap(testDir, options)
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "pre-snap hook failed, so no snap was taken")
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Empty(t, snaps)
+		assert.NoFileExists(t, envFile, "the post-snap hook runs only if the snap was attempted")
+	})
+
+	t.Run("should return an error if the post-snap hook fails", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		testDir := setupTestDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{PostSnap: "exit 1"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "post-snap hook failed")
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, snaps, 1, "the snap was taken all the same")
+	})
+
+	t.Run("should run the hooks around a snap of standard input", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		repoDir := filepath.Join(t.TempDir(), "repo")
+		envFile := filepath.Join(t.TempDir(), "env")
+		options := commands.SnapOptions{StdinFilename: "dump.sql", PostSnap: "env | grep ^BTOOL_ > " + envFile, RepositoryOptions: commands.RepositoryOptions{Repo: repoDir}}
+
+		// Act
+		err := commands.SnapStdin(strings.NewReader("data"), options)
+
+		// Assert
+		require.NoError(t, err)
+		env := readHookEnv(t, envFile)
+		assert.Equal(t, "created", env["BTOOL_SNAP_STATUS"])
+		assert.Equal(t, "4", env["BTOOL_SOURCE_SIZE"])
+	})
+}
+
+func TestSnapCommand_SpecialFiles(t *testing.T) {
+	// setupSpecialDir creates a test directory with a FIFO, and a device node
+	// like /dev/null when running as root, returning the path of the device
+	// node or an empty string.
+	setupSpecialDir := func(t *testing.T) (testDir, device string) {
+		testDir = setupTestDir(t)
+		if err := lib.MakeSpecialFile(filepath.Join(testDir, "pipe"), lib.EntryTypeFIFO, 0640, 0); err != nil {
+			t.Skipf("cannot create FIFOs here: %v", err)
+		}
+		null, err := os.Stat("/dev/null")
+		if os.Geteuid() != 0 || err != nil {
+			return testDir, ""
+		}
+		device = filepath.Join(testDir, "subdir", "null")
+		if err := lib.MakeSpecialFile(device, lib.EntryTypeCharDevice, 0666, lib.FileRdev(null)); err != nil {
+			return testDir, ""
+		}
+		return testDir, device
+	}
+
+	t.Run("should leave out special files unless asked", func(t *testing.T) {
+		// Arrange
+		testDir, _ := setupSpecialDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		_, err = os.Lstat(filepath.Join(outputDir, "pipe"))
+		assert.True(t, os.IsNotExist(err), "The FIFO should not be snapped")
+	})
+
+	t.Run("should recreate special files on restore", func(t *testing.T) {
+		// Arrange
+		testDir, device := setupSpecialDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{SpecialFiles: true})
+
+		// Assert
+		require.NoError(t, err)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir}))
+		info, err := os.Lstat(filepath.Join(outputDir, "pipe"))
+		require.NoError(t, err)
+		assert.Equal(t, os.ModeNamedPipe|0640, info.Mode())
+		if device == "" {
+			return
+		}
+		original, err := os.Lstat(device)
+		require.NoError(t, err)
+		restored, err := os.Lstat(filepath.Join(outputDir, "subdir", "null"))
+		require.NoError(t, err)
+		assert.Equal(t, original.Mode(), restored.Mode())
+		assert.Equal(t, lib.FileRdev(original), lib.FileRdev(restored))
+	})
+
+	t.Run("should prune snaps holding special files", func(t *testing.T) {
+		// Arrange
+		testDir, _ := setupSpecialDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{SpecialFiles: true}))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileA.txt"), []byte("changed"), 0644))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{SpecialFiles: true}))
+
+		// Act
+		err := commands.Prune(testDir, commands.PruneOptions{KeepLast: 1})
+
+		// Assert
+		require.NoError(t, err)
+	})
+}
+
+func TestSnapCommand_Repo(t *testing.T) {
+	t.Run("should store the repository outside the source tree", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		repoDir := filepath.Join(t.TempDir(), "repo")
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{Message: "external", RepositoryOptions: commands.RepositoryOptions{Repo: repoDir}})
+		require.NoError(t, err, "Snap with an external repo failed")
+
+		// Assert: nothing was written to the source tree.
+		assert.NoDirExists(t, lib.GetBtoolDir(testDir), "Source tree should not get a .btool directory")
+
+		backend, err := lib.OpenBackend(repoDir, testDir)
+		require.NoError(t, err)
+		snaps, err := lib.NewObjectStore(backend).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 1)
+		assert.Equal(t, "external", snaps[0].Message)
+	})
+
+	t.Run("should leave a repository inside the source tree out of the snapshot", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("content"), 0644))
+		repoDir := filepath.Join(testDir, "backups")
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Repo: repoDir}})
+		require.NoError(t, err)
+
+		// Assert: the root tree only contains the source file.
+		backend, err := lib.OpenBackend(repoDir, testDir)
+		require.NoError(t, err)
+		store := lib.NewObjectStore(backend)
+		snaps, err := store.GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 1)
+
+		var rootTree types.Tree
+		require.NoError(t, store.ReadObjectAsJSON(snaps[0].RootTreeHash, &rootTree))
+		require.Len(t, rootTree.Entries, 1, "The repository directory should not be snapped")
+		assert.Equal(t, "file.txt", rootTree.Entries[0].Name)
+	})
+
+	t.Run("should replicate the snapshot to every mirror", func(t *testing.T) {
+		// Arrange
+		lib.ResetObjectStoreState()
+		lib.ResetIgnoreState()
+		testDir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "file.txt"), []byte("content"), 0644))
+		mirrorDir := filepath.Join(testDir, "mirror")
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{Message: "mirrored", RepositoryOptions: commands.RepositoryOptions{Mirrors: []string{mirrorDir}}})
+		require.NoError(t, err)
+
+		// Assert: both destinations hold a complete, restorable repository.
+		for _, repo := range []string{"", mirrorDir} {
+			backend, err := lib.OpenBackend(repo, testDir)
+			require.NoError(t, err)
+			store := lib.NewObjectStore(backend)
+			snaps, err := store.GetSortedSnaps()
+			require.NoError(t, err)
+			require.Len(t, snaps, 1, "repo %q should hold the snap", repo)
+			assert.Equal(t, "mirrored", snaps[0].Message)
+
+			var rootTree types.Tree
+			require.NoError(t, store.ReadObjectAsJSON(snaps[0].RootTreeHash, &rootTree))
+			require.Len(t, rootTree.Entries, 1, "Neither repository should be snapped")
+			assert.Equal(t, "file.txt", rootTree.Entries[0].Name)
+		}
+	})
+}
+
+func TestSnapCommand_Encryption(t *testing.T) {
+	t.Run("should encrypt a new repository and restore from it with the password", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		encrypted := commands.RepositoryOptions{Password: "correct horse"}
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{Message: "encrypted", RepositoryOptions: encrypted}))
+		outputDir := t.TempDir()
+		err := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RepositoryOptions: encrypted})
+
+		// Assert
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "unique content A", string(content))
+
+		shards, err := os.ReadDir(lib.GetIndexDir(testDir))
+		require.NoError(t, err)
+		require.NotEmpty(t, shards)
+		indexContent, err := os.ReadFile(filepath.Join(lib.GetIndexDir(testDir), shards[0].Name()))
+		require.NoError(t, err)
+		assert.False(t, json.Valid(indexContent), "The index should not be stored as plain JSON")
+	})
+
+	t.Run("should read the password from a file", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		passwordFile := filepath.Join(t.TempDir(), "password")
+		require.NoError(t, os.WriteFile(passwordFile, []byte("from a file\n"), 0600))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "from a file"}}))
+
+		// Act
+		err := commands.List(testDir, commands.ListOptions{RepositoryOptions: commands.RepositoryOptions{PasswordFile: passwordFile}})
+
+		// Assert
+		assert.NoError(t, err, "The trailing newline should not be part of the password")
+	})
+
+	t.Run("should read the password from a command", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "from a command"}}))
+
+		// Act
+		err := commands.List(testDir, commands.ListOptions{RepositoryOptions: commands.RepositoryOptions{PasswordCommand: "echo 'from a command'"}})
+		failedErr := commands.List(testDir, commands.ListOptions{RepositoryOptions: commands.RepositoryOptions{PasswordCommand: "exit 3"}})
+
+		// Assert
+		assert.NoError(t, err)
+		assert.ErrorContains(t, failedErr, "password command")
+	})
+
+	t.Run("should not prompt for a new password without a terminal", func(t *testing.T) {
+		// Arrange: tests never run with a terminal on standard input.
+		testDir := setupTestDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Encrypt: true}})
+
+		// Assert
+		assert.ErrorContains(t, err, "not a terminal")
+		_, statErr := os.Stat(lib.GetIndexDir(testDir))
+		assert.True(t, os.IsNotExist(statErr), "Nothing should be written without encryption")
+	})
+
+	t.Run("should refuse to open an encrypted repository without the right password", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "correct horse"}}))
+
+		// Act
+		noPasswordErr := commands.List(testDir, commands.ListOptions{})
+		wrongPasswordErr := commands.List(testDir, commands.ListOptions{RepositoryOptions: commands.RepositoryOptions{Password: "battery staple"}})
+
+		// Assert
+		assert.ErrorContains(t, noPasswordErr, "is encrypted")
+		assert.ErrorIs(t, wrongPasswordErr, lib.ErrWrongPassword)
+	})
+
+	t.Run("should refuse a password for an existing unencrypted repository", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "too late"}})
+
+		// Assert
+		assert.ErrorContains(t, err, "is not encrypted")
+	})
+}
+
+func TestSnapCommand_KeyedHashes(t *testing.T) {
+	t.Run("should restore from a repository with keyed hashes", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		keyed := commands.RepositoryOptions{Password: "correct horse", KeyedHashes: true}
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: keyed}))
+		outputDir := t.TempDir()
+
+		// Act: the flag is only needed when the repository is created.
+		err := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RepositoryOptions: commands.RepositoryOptions{Password: "correct horse"}})
+
+		// Assert
+		require.NoError(t, err)
+		content, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "unique content A", string(content))
+	})
+
+	t.Run("should refuse keyed hashes without encryption or after the first snap", func(t *testing.T) {
+		// Arrange
+		plainDir := setupTestDir(t)
+		encryptedDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(encryptedDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "correct horse"}}))
+
+		// Act
+		plainErr := commands.Snap(plainDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{KeyedHashes: true}})
+		lateErr := commands.Snap(encryptedDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Password: "correct horse", KeyedHashes: true}})
+
+		// Assert
+		assert.ErrorContains(t, plainErr, "need an encrypted repository")
+		assert.ErrorContains(t, lateErr, "already holds data")
+	})
+}
+
+func TestSnapCommand_Compression(t *testing.T) {
+	t.Run("should keep the compression chosen at creation as the repository default", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		lz4 := commands.RepositoryOptions{Compression: lib.CompressionLZ4, CompressionLevel: 9}
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: lz4}))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileD.txt"), []byte(strings.Repeat("added later\n", 100)), 0644))
+		outputDir := t.TempDir()
+
+		// Act: later commands need no flags.
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		err := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		stored, err := os.ReadFile(filepath.Join(testDir, ".btool", "meta", "defaults"))
+		require.NoError(t, err)
+		var defaults lib.RepositoryDefaults
+		require.NoError(t, json.Unmarshal(stored, &defaults))
+		assert.Equal(t, lib.RepositoryDefaults{Compression: lib.CompressionLZ4, CompressionLevel: 9}, defaults)
+		content, err := os.ReadFile(filepath.Join(outputDir, "fileD.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("added later\n", 100), string(content))
+	})
+
+	t.Run("should apply a later choice to that snap only", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileD.txt"), []byte("added later"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Compression: lib.CompressionOff}})
+
+		// Assert
+		require.NoError(t, err)
+		_, statErr := os.Stat(filepath.Join(testDir, ".btool", "meta", "defaults"))
+		assert.True(t, os.IsNotExist(statErr), "The defaults should only be set at creation")
+	})
+
+	t.Run("should refuse an unknown algorithm or a level out of range", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+
+		// Act
+		unknownErr := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Compression: "brotli"}})
+		levelErr := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{CompressionLevel: 23}})
+
+		// Assert
+		assert.ErrorContains(t, unknownErr, "unsupported compression")
+		assert.ErrorContains(t, levelErr, "from 1 to 22")
+	})
+}
+
+func TestSnapCommand_PackSize(t *testing.T) {
+	t.Run("should write packs of the chosen size as the snap goes", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		content := make([]byte, 3*1024*1024)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "large.bin"), content, 0644))
+		outputDir := t.TempDir()
+
+		// Act
+		err = commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{PackSize: 1}})
+		require.NoError(t, err)
+		restoreErr := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		packs, err := os.ReadDir(lib.GetPacksDir(testDir))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, len(packs), 3, "3 MB of data should fill several 1 MB packs")
+		for _, pack := range packs {
+			info, err := pack.Info()
+			require.NoError(t, err)
+			assert.Less(t, info.Size(), int64(2*1024*1024), "No pack should grow far beyond the pack size")
+		}
+		restored, err := os.ReadFile(filepath.Join(outputDir, "large.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, content, restored)
+		defaults, err := lib.NewLocalObjectStore(testDir).LoadDefaults()
+		require.NoError(t, err)
+		assert.Equal(t, lib.RepositoryDefaults{PackSize: 1024 * 1024}, defaults, "The pack size should become the repository default")
+	})
+
+	t.Run("should refuse a pack size out of range", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{PackSize: 8192}})
+
+		// Assert
+		assert.ErrorContains(t, err, "pack size must be")
+	})
+}
+
+func TestSnapCommand_MaxSize(t *testing.T) {
+	// snapRandomFile replaces the contents of the file in testDir with
+	// 200 KB that do not compress, then snaps it.
+	snapRandomFile := func(t *testing.T, testDir string, options commands.SnapOptions) error {
+		t.Helper()
+		content := make([]byte, 200*1024)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "data.bin"), content, 0644))
+		return commands.Snap(testDir, options)
+	}
+
+	t.Run("should remove the oldest snaps to stay under the max size", func(t *testing.T) {
+		// Arrange: the limit is stored with the first snap.
+		testDir := setupTestDir(t)
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "500K"}))
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{}))
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = snapRandomFile(t, testDir, commands.SnapOptions{})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.Contains(t, output, "over its max size of 500.00 KB")
+		assert.Contains(t, output, "Removed the 1 oldest snap(s)")
+		lib.ResetObjectStoreState()
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 2)
+		assert.Equal(t, int64(2), snaps[0].ID)
+		defaults, err := lib.NewLocalObjectStore(testDir).LoadDefaults()
+		require.NoError(t, err)
+		assert.Equal(t, int64(500*1024), defaults.MaxSize)
+	})
+
+	t.Run("should count the packs kept in the trash toward the max size", func(t *testing.T) {
+		// Arrange: the packs of the first snap are moved to the trash.
+		testDir := setupTestDir(t)
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "500K"}))
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{}))
+		require.NoError(t, commands.Prune(testDir, commands.PruneOptions{KeepLast: 1, KeepBackup: 24 * time.Hour}))
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = snapRandomFile(t, testDir, commands.SnapOptions{})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.Contains(t, output, "over its max size of 500.00 KB", "the packs alone take up about 400 KB")
+	})
+
+	t.Run("should take no snap and remove nothing when the new snap alone is too big", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{}))
+		packs, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+
+		// Act
+		err = snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "100K"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "even with every older snap removed; no snap was taken")
+		lib.ResetObjectStoreState()
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Len(t, snaps, 1)
+		packsAfter, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, packs, packsAfter, "the packs the snap wrote should be dropped again")
+	})
+
+	t.Run("should drop only the packs the snap wrote", func(t *testing.T) {
+		// Arrange: another snap has written a pack but not recorded itself yet.
+		testDir := setupTestDir(t)
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{}))
+		pending := lib.NewLocalObjectStore(testDir)
+		_, err := pending.WriteObject([]byte("written by a snap still running"))
+		require.NoError(t, err)
+		_, err = pending.Commit()
+		require.NoError(t, err)
+		packs, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		require.Len(t, packs, 2)
+
+		// Act
+		err = snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "100K"})
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no snap was taken")
+		packsAfter, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		assert.ElementsMatch(t, packs, packsAfter)
+	})
+
+	t.Run("should leave the max size to a snap that can read the repository", func(t *testing.T) {
+		// Arrange: a repository encrypted to an identity, over its max size
+		// once a write-only backup adds a snap.
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		identityFile := filepath.Join(t.TempDir(), "identity.txt")
+		require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+		withIdentity := commands.RepositoryOptions{AgeIdentityFile: identityFile}
+		testDir := setupTestDir(t)
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{
+			AgeRecipients: []string{identity.Recipient().String()},
+		}}))
+		require.NoError(t, snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "500K", RepositoryOptions: withIdentity}))
+		packs, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+
+		// Act
+		var snapErr error
+		output := captureStdout(t, func() {
+			snapErr = snapRandomFile(t, testDir, commands.SnapOptions{})
+		})
+
+		// Assert
+		require.NoError(t, snapErr)
+		assert.Contains(t, output, "over its max size of 500.00 KB; the next snap made with its password or age identity")
+		packsAfter, err := lib.NewLocalObjectStore(testDir).ListPacks()
+		require.NoError(t, err)
+		assert.Subset(t, packsAfter, packs, "the packs of the snaps the backup cannot read should be kept")
+		err = snapRandomFile(t, testDir, commands.SnapOptions{MaxSize: "1M"})
+		assert.ErrorContains(t, err, "--max-size needs the repository's password or age identity")
+	})
+}
+
+func TestSnapCommand_MaxMemory(t *testing.T) {
+	t.Run("should snap more data than the memory limit", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		content := make([]byte, 24*1024*1024)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "large.bin"), content, 0644))
+		outputDir := t.TempDir()
+
+		// Act
+		err = commands.Snap(testDir, commands.SnapOptions{MaxMemory: 16})
+		require.NoError(t, err)
+		restoreErr := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, restoreErr)
+		packs, err := os.ReadDir(lib.GetPacksDir(testDir))
+		require.NoError(t, err)
+		assert.GreaterOrEqual(t, len(packs), 3, "Packs should be written whenever 8 MB is pending")
+		restored, err := os.ReadFile(filepath.Join(outputDir, "large.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, content, restored)
+	})
+
+	t.Run("should refuse a memory limit below the minimum", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{MaxMemory: 8})
+
+		// Assert
+		assert.ErrorContains(t, err, "memory limit must be at least 16 MiB")
+	})
+}
+
+func TestSnapCommand_Parent(t *testing.T) {
+	t.Run("should record the previous snap as the parent", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{Message: "first"}))
+
+		// Act
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileA.txt"), []byte("changed"), 0644))
+		err := commands.Snap(testDir, commands.SnapOptions{Message: "second"})
+
+		// Assert
+		require.NoError(t, err)
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		require.Len(t, snaps, 2)
+		assert.Empty(t, snaps[0].Parent, "The first snap should have no parent")
+		assert.Equal(t, snaps[0].Hash, snaps[1].Parent)
+	})
+}
+
+func TestSnapCommand_SkipIfUnchanged(t *testing.T) {
+	// countSnaps returns how many snaps the repository of testDir holds.
+	countSnaps := func(t *testing.T, testDir string) int {
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		return len(snaps)
+	}
+
+	t.Run("should create no snap when nothing changed", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{SkipIfUnchanged: true})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 1, countSnaps(t, testDir))
+	})
+
+	t.Run("should snap a changed directory", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileD.txt"), []byte("new file D"), 0644))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{SkipIfUnchanged: true})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 2, countSnaps(t, testDir))
+	})
+
+	t.Run("should snap an unchanged directory without the option", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		err := commands.Snap(testDir, commands.SnapOptions{})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 2, countSnaps(t, testDir))
+	})
+}
+
+func TestSnapCommand_FileCache(t *testing.T) {
+	// rewriteInPlace gives the file at path new contents of the same size,
+	// then turns its modification time back, so that it looks unchanged.
+	rewriteInPlace := func(t *testing.T, path, content string, modTime time.Time) {
+		file, err := os.OpenFile(path, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		_, err = file.WriteString(content)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	// snapTwice snaps dir, rewrites fileA.txt so that it looks unchanged,
+	// runs between, snaps dir again with options, and returns what the second
+	// snap holds for fileA.txt.
+	snapTwice := func(t *testing.T, options commands.SnapOptions, between func(testDir string)) string {
+		testDir := setupTestDir(t)
+		path := filepath.Join(testDir, "fileA.txt")
+		modTime := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		rewriteInPlace(t, path, "UNIQUE CONTENT A", modTime)
+		between(testDir)
+
+		require.NoError(t, commands.Snap(testDir, options))
+
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir}))
+		restored, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+		require.NoError(t, err)
+		return string(restored)
+	}
+
+	t.Run("should reuse the manifests of files that look unchanged", func(t *testing.T) {
+		// Act
+		var cacheFiles []os.DirEntry
+		restored := snapTwice(t, commands.SnapOptions{}, func(testDir string) {
+			var err error
+			cacheFiles, err = os.ReadDir(filepath.Join(lib.GetBtoolDir(testDir), lib.FileCacheDirName))
+			require.NoError(t, err)
+		})
+
+		// Assert
+		assert.Len(t, cacheFiles, 1, "The snap should record its files in the repository's cache")
+		assert.Equal(t, "unique content A", restored, "The file should not have been read again")
+	})
+
+	t.Run("should read every file again when forced", func(t *testing.T) {
+		// Act
+		restored := snapTwice(t, commands.SnapOptions{ForceRescan: true}, func(string) {})
+
+		// Assert
+		assert.Equal(t, "UNIQUE CONTENT A", restored)
+	})
+
+	t.Run("should read every file again once the cached snap is gone", func(t *testing.T) {
+		// Act
+		restored := snapTwice(t, commands.SnapOptions{}, func(testDir string) {
+			store := lib.NewLocalObjectStore(testDir)
+			snaps, err := store.GetSortedSnaps()
+			require.NoError(t, err)
+			require.NoError(t, store.DeleteSnap(snaps[0].Hash))
+		})
+
+		// Assert
+		assert.Equal(t, "UNIQUE CONTENT A", restored)
+	})
+
+	t.Run("should read files modified shortly before the snap again", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		path := filepath.Join(testDir, "fileA.txt")
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		rewriteInPlace(t, path, "UNIQUE CONTENT A", info.ModTime())
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir}))
+		restored, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "UNIQUE CONTENT A", string(restored))
+	})
+}
+
+func TestSnapCommand_TreeCache(t *testing.T) {
+	// snapAged snaps a test directory whose files were all modified an hour
+	// ago, so that the file cache records every one of them.
+	snapAged := func(t *testing.T) string {
+		testDir := setupTestDir(t)
+		modTime := time.Now().Add(-time.Hour)
+		for _, name := range []string{"fileA.txt", "fileB.txt", filepath.Join("subdir", "fileC.txt")} {
+			require.NoError(t, os.Chtimes(filepath.Join(testDir, name), modTime, modTime))
+		}
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+		return testDir
+	}
+	// lastSnap returns the latest snap of testDir.
+	lastSnap := func(t *testing.T, testDir string) lib.SnapDetail {
+		snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+		require.NoError(t, err)
+		return snaps[len(snaps)-1]
+	}
+
+	t.Run("should record the tree of each directory", func(t *testing.T) {
+		// Act
+		testDir := snapAged(t)
+
+		// Assert
+		cache, err := lib.LoadFileCache(filepath.Join(lib.GetBtoolDir(testDir), lib.FileCacheDirName), testDir)
+		require.NoError(t, err)
+		assert.Equal(t, lastSnap(t, testDir).RootTreeHash, cache.Dirs[testDir].TreeHash)
+		assert.Equal(t, 1, cache.Dirs[filepath.Join(testDir, "subdir")].Entries)
+		assert.NotContains(t, cache.Dirs, filepath.Join(testDir, "ignored_dir"))
+	})
+
+	t.Run("should reuse the trees of unchanged directories", func(t *testing.T) {
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		assert.Equal(t, first.RootTreeHash, lastSnap(t, testDir).RootTreeHash)
+	})
+
+	t.Run("should build the tree of a directory again when a file gains a hardlink", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("hardlinks are only detected where files have inode numbers")
+		}
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+		require.NoError(t, os.Link(filepath.Join(testDir, "fileA.txt"), filepath.Join(testDir, "subdir", "linkA.txt")))
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		second := lastSnap(t, testDir)
+		assert.NotEqual(t, first.RootTreeHash, second.RootTreeHash)
+		var rootTree types.Tree
+		require.NoError(t, lib.NewLocalObjectStore(testDir).ReadObjectAsJSON(second.RootTreeHash, &rootTree))
+		for _, entry := range rootTree.Entries {
+			if entry.Name == "fileA.txt" {
+				assert.NotEmpty(t, entry.Link, "The unchanged file should now record its link")
+			}
+		}
+	})
+
+	t.Run("should build the tree of a directory again when a file is touched", func(t *testing.T) {
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+		modTime := time.Now().Add(-30 * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(testDir, "subdir", "fileC.txt"), modTime, modTime))
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		assert.NotEqual(t, first.RootTreeHash, lastSnap(t, testDir).RootTreeHash)
+	})
+
+	t.Run("should build the tree of a directory again when a file gains an ACL", func(t *testing.T) {
+		if runtime.GOOS != "linux" {
+			t.Skip("POSIX ACLs are only supported on Linux")
+		}
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+		acl := types.ACL{Access: "user::rw-,user:1234:r--,group::r--,mask::r--,other::r--"}
+		if err := lib.SetFileACL(filepath.Join(testDir, "subdir", "fileC.txt"), acl); err != nil {
+			t.Skipf("the filesystem does not support ACLs: %v", err)
+		}
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		assert.NotEqual(t, first.RootTreeHash, lastSnap(t, testDir).RootTreeHash)
+		cache, err := lib.LoadFileCache(filepath.Join(lib.GetBtoolDir(testDir), lib.FileCacheDirName), testDir)
+		require.NoError(t, err)
+		assert.Equal(t, &acl, cache.Files[filepath.Join(testDir, "subdir", "fileC.txt")].ACL)
+	})
+
+	t.Run("should build the tree of a directory again when a file changes owner", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+			t.Skip("changing the owner of files takes root on a system with owners")
+		}
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+		require.NoError(t, os.Chown(filepath.Join(testDir, "subdir", "fileC.txt"), 54321, 54321))
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		assert.NotEqual(t, first.RootTreeHash, lastSnap(t, testDir).RootTreeHash)
+	})
+
+	t.Run("should build the trees of changed directories again", func(t *testing.T) {
+		// Arrange
+		testDir := snapAged(t)
+		first := lastSnap(t, testDir)
+		require.NoError(t, os.Chmod(filepath.Join(testDir, "subdir", "fileC.txt"), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "subdir", "fileD.txt"), []byte("new file D"), 0644))
+		require.NoError(t, os.Remove(filepath.Join(testDir, "fileB.txt")))
+
+		// Act
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Assert
+		assert.NotEqual(t, first.RootTreeHash, lastSnap(t, testDir).RootTreeHash)
+		outputDir := t.TempDir()
+		require.NoError(t, commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir}))
+		info, err := os.Stat(filepath.Join(outputDir, "subdir", "fileC.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "The new mode should be restored")
+		assert.FileExists(t, filepath.Join(outputDir, "subdir", "fileD.txt"))
+		assert.NoFileExists(t, filepath.Join(outputDir, "fileB.txt"))
+	})
+}
+
+func TestSnapCommand_HashAlgorithm(t *testing.T) {
+	for _, keyed := range []bool{false, true} {
+		want := lib.Blake3HashAlgorithm
+		options := commands.RepositoryOptions{HashAlgorithm: lib.Blake3HashAlgorithm}
+		if keyed {
+			want = lib.KeyedBlake3HashAlgorithm
+			options = commands.RepositoryOptions{HashAlgorithm: lib.Blake3HashAlgorithm, KeyedHashes: true, Password: "correct horse"}
+		}
+
+		t.Run("should restore from a repository with "+want+" IDs", func(t *testing.T) {
+			// Arrange
+			testDir := setupTestDir(t)
+			require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: options}))
+			outputDir := t.TempDir()
+
+			// Act: the flags are only needed when the repository is created.
+			err := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir, RepositoryOptions: commands.RepositoryOptions{Password: options.Password}})
+
+			// Assert
+			require.NoError(t, err)
+			content, err := os.ReadFile(filepath.Join(outputDir, "fileA.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, "unique content A", string(content))
+			store := lib.NewLocalObjectStore(testDir)
+			if keyed {
+				key, err := lib.UnlockRepositoryKey(store.Backend(), "correct horse")
+				require.NoError(t, err)
+				store = lib.NewEncryptedObjectStore(store.Backend(), key)
+			}
+			require.NoError(t, store.LoadConfig())
+			assert.Equal(t, want, store.Hasher().Algorithm())
+		})
+	}
+
+	t.Run("should refuse to change the hash algorithm after the first snap", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		lateErr := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{HashAlgorithm: lib.Blake3HashAlgorithm}})
+		unknownErr := commands.Snap(setupTestDir(t), commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{HashAlgorithm: "md5"}})
+
+		// Assert
+		assert.ErrorContains(t, lateErr, "the hash algorithm can only be chosen when it is created")
+		assert.ErrorContains(t, unknownErr, "unsupported hash algorithm")
+	})
+}
+
+func TestSnapCommand_Chunker(t *testing.T) {
+	t.Run("should restore from a repository chunked with FastCDC", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		content := make([]byte, 100*1024)
+		_, err := rand.Read(content)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "large.bin"), content, 0644))
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Chunker: lib.ChunkerFastCDC}}))
+		outputDir := t.TempDir()
+
+		// Act: the flag is only needed when the repository is created.
+		err = commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "1", OutputDir: outputDir})
+
+		// Assert
+		require.NoError(t, err)
+		restored, err := os.ReadFile(filepath.Join(outputDir, "large.bin"))
+		require.NoError(t, err)
+		assert.Equal(t, content, restored)
+		config, err := os.ReadFile(filepath.Join(testDir, ".btool", "meta", "config"))
+		require.NoError(t, err)
+		assert.Contains(t, string(config), `"chunker": "fastcdc"`)
+	})
+
+	t.Run("should refuse to change the chunker after the first snap", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		require.NoError(t, commands.Snap(testDir, commands.SnapOptions{}))
+
+		// Act
+		lateErr := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Chunker: lib.ChunkerFastCDC}})
+		sameErr := commands.Snap(testDir, commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Chunker: lib.ChunkerRabin}})
+
+		// Assert
+		assert.ErrorContains(t, lateErr, "already holds data; the chunker can only be chosen when it is created")
+		assert.NoError(t, sameErr, "Naming the chunker in use changes nothing")
+	})
+
+	t.Run("should refuse an unknown chunker", func(t *testing.T) {
+		err := commands.Snap(setupTestDir(t), commands.SnapOptions{RepositoryOptions: commands.RepositoryOptions{Chunker: "fixed"}})
+		assert.ErrorContains(t, err, "unsupported chunker")
+	})
+}
+
+func TestSnapCommand_AgeRecipients(t *testing.T) {
+	// Arrange: an identity whose public key the repository is encrypted to.
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	identityFile := filepath.Join(t.TempDir(), "identity.txt")
+	require.NoError(t, os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600))
+	testDir := setupTestDir(t)
+	require.NoError(t, commands.Snap(testDir, commands.SnapOptions{Message: "first", RepositoryOptions: commands.RepositoryOptions{
+		AgeRecipients: []string{identity.Recipient().String()},
+	}}))
+
+	// Act: a later backup runs without any secret.
+	require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileD.txt"), []byte("added later"), 0644))
+	require.NoError(t, commands.Snap(testDir, commands.SnapOptions{Message: "second"}))
+
+	t.Run("should refuse to read without the identity", func(t *testing.T) {
+		err := commands.List(testDir, commands.ListOptions{})
+		assert.ErrorContains(t, err, "is encrypted")
+	})
+
+	t.Run("should restore every snap with the identity", func(t *testing.T) {
+		// Act
+		withIdentity := commands.RepositoryOptions{AgeIdentityFile: identityFile}
+		outputDir := t.TempDir()
+		err := commands.Restore(testDir, commands.RestoreOptions{SnapIdentifier: "2", OutputDir: outputDir, RepositoryOptions: withIdentity})
+
+		// Assert
+		require.NoError(t, err)
+		for name, expected := range map[string]string{"fileA.txt": "unique content A", "fileD.txt": "added later"} {
+			content, err := os.ReadFile(filepath.Join(outputDir, name))
+			require.NoError(t, err)
+			assert.Equal(t, expected, string(content))
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// StatsOptions holds the configuration for the stats command.
+type StatsOptions struct {
+	RepositoryOptions
+}
+
+// dataStats sums up the files of one snap, or of all of them.
+type dataStats struct {
+	files      int
+	sourceSize int64 // The sizes of the files, counting each time a chunk is used.
+	chunks     map[string]int64
+	storedSize int64 // Of the distinct chunks, as they lie in the packs.
+}
+
+// addChunk counts a chunk of a file, and its stored size the first time the
+// stats see it.
+func (d *dataStats) addChunk(chunk types.ChunkRef, index types.PackIndex) {
+	d.sourceSize += chunk.Size
+	if _, seen := d.chunks[chunk.Hash]; seen {
+		return
+	}
+	d.chunks[chunk.Hash] = chunk.Size
+	d.storedSize += index[chunk.Hash].Length
+}
+
+// uniqueSize returns the sum of the sizes of the distinct chunks.
+func (d *dataStats) uniqueSize() int64 {
+	var size int64
+	for _, chunkSize := range d.chunks {
+		size += chunkSize
+	}
+	return size
+}
+
+// formatRatio returns a ratio such as "2.50x", or "-" if there is nothing
+// to divide by.
+func formatRatio(numerator, denominator int64) string {
+	if denominator == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", float64(numerator)/float64(denominator))
+}
+
+// statsCollector reads the files of snaps for stats, reading each manifest
+// once however many snaps refer to it.
+type statsCollector struct {
+	store     *lib.ObjectStore
+	index     types.PackIndex
+	manifests map[string][]types.ChunkRef
+}
+
+// addSnap adds the files of the tree with the given hash to each of stats.
+func (c *statsCollector) addSnap(rootTreeHash string, stats ...*dataStats) error {
+	return walkSnapTree(c.store, rootTreeHash, "", func(p string, entry types.TreeEntry) error {
+		if entry.Type != "blob" {
+			return nil
+		}
+		chunks, ok := c.manifests[entry.Hash]
+		if !ok {
+			var manifest types.FileManifest
+			if err := c.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+				return fmt.Errorf("failed to read manifest of %s: %w", p, err)
+			}
+			chunks = manifest.Chunks
+			c.manifests[entry.Hash] = chunks
+		}
+		for _, s := range stats {
+			s.files++
+			for _, chunk := range chunks {
+				s.addChunk(chunk, c.index)
+			}
+		}
+		return nil
+	})
+}
+
+// Stats is the main function for the 'stats' command. It reports how much
+// each snap and the repository as a whole hold, and how much deduplication
+// and compression save on storing it.
+func Stats(targetDirectory string, options StatsOptions) error {
+	absTargetPath, err := filepath.Abs(targetDirectory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	}
+	if _, err := os.Stat(absTargetPath); os.IsNotExist(err) {
+		return fmt.Errorf("target directory does not exist: %s", absTargetPath)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absTargetPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	displayName := absTargetPath
+	if options.Repo != "" {
+		displayName = store.Backend().Location()
+	}
+
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots: %w", err)
+	}
+	index, err := store.GetIndex()
+	if err != nil {
+		return fmt.Errorf("failed to read the index: %w", err)
+	}
+	packs, err := store.ListPacks()
+	if err != nil {
+		return fmt.Errorf("failed to list packs: %w", err)
+	}
+	var packSize int64
+	for _, pack := range packs {
+		packSize += pack.Size
+	}
+
+	fmt.Printf("Stats for \"%s\":\n", displayName)
+	c := &statsCollector{store: store, index: index, manifests: make(map[string][]types.ChunkRef)}
+	total := &dataStats{chunks: make(map[string]int64)}
+	if len(snaps) > 0 {
+		fmt.Printf("%-10s %-10s %-10s %-15s %-15s %-15s %-8s %s\n", "SNAPSHOT", "FILES", "CHUNKS", "SOURCE SIZE", "UNIQUE SIZE", "STORED SIZE", "DEDUP", "COMPRESSION")
+		fmt.Printf("%-10s %-10s %-10s %-15s %-15s %-15s %-8s %s\n", "=======", "=====", "======", "=============", "=============", "=============", "=====", "===========")
+	}
+	for _, snap := range snaps {
+		stats := &dataStats{chunks: make(map[string]int64)}
+		if err := c.addSnap(snap.RootTreeHash, stats, total); err != nil {
+			return fmt.Errorf("failed to read snap %d: %w", snap.ID, err)
+		}
+		unique := stats.uniqueSize()
+		fmt.Printf("%-10s %-10d %-10d %-15s %-15s %-15s %-8s %s\n",
+			strconv.FormatInt(snap.ID, 10),
+			stats.files,
+			len(stats.chunks),
+			formatBytes(stats.sourceSize, 2),
+			formatBytes(unique, 2),
+			formatBytes(stats.storedSize, 2),
+			formatRatio(stats.sourceSize, unique),
+			formatRatio(unique, stats.storedSize),
+		)
+	}
+
+	unique := total.uniqueSize()
+	if len(snaps) > 0 {
+		fmt.Println()
+	}
+	fmt.Println("Repository:")
+	fmt.Printf("   - Snaps: %d\n", len(snaps))
+	fmt.Printf("   - Objects: %d, of which %d data chunks, in %d pack(s)\n", len(index), len(total.chunks), len(packs))
+	fmt.Printf("   - Source size of all snaps: %s\n", formatBytes(total.sourceSize, 2))
+	fmt.Printf("   - Unique data: %s (deduplication %s)\n", formatBytes(unique, 2), formatRatio(total.sourceSize, unique))
+	fmt.Printf("   - Stored data: %s (compression %s)\n", formatBytes(total.storedSize, 2), formatRatio(unique, total.storedSize))
+	fmt.Printf("   - Total size of packs: %s (overall %s)\n", formatBytes(packSize, 2), formatRatio(total.sourceSize, packSize))
+	return nil
+}
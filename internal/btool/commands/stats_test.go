@@ -0,0 +1,54 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCommand(t *testing.T) {
+	t.Run("should report deduplication within and across snaps", func(t *testing.T) {
+		// Arrange: two copies of the same text, snapped twice.
+		sourceDir := t.TempDir()
+		content := []byte(strings.Repeat("the same line again\n", 1000))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "a.txt"), content, 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "b.txt"), content, 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var statsErr error
+		output := captureStdout(t, func() {
+			statsErr = commands.Stats(sourceDir, commands.StatsOptions{})
+		})
+
+		// Assert
+		require.NoError(t, statsErr)
+		assert.Regexp(t, `(?m)^1 +2 +\d+ +39\.06 KB +19\.53 KB +\S+ \S+ +2\.00x +\d+\.\d\dx$`, output)
+		assert.Contains(t, output, "   - Snaps: 2\n")
+		assert.Contains(t, output, "   - Source size of all snaps: 78.12 KB\n")
+		assert.Contains(t, output, "   - Unique data: 19.53 KB (deduplication 4.00x)\n")
+		assert.Contains(t, output, "in 1 pack(s)")
+	})
+
+	t.Run("should report an empty repository", func(t *testing.T) {
+		// Arrange
+		sourceDir := t.TempDir()
+
+		// Act
+		var statsErr error
+		output := captureStdout(t, func() {
+			statsErr = commands.Stats(sourceDir, commands.StatsOptions{})
+		})
+
+		// Assert
+		require.NoError(t, statsErr)
+		assert.Contains(t, output, "   - Snaps: 0\n")
+		assert.Contains(t, output, "(deduplication -)")
+	})
+}
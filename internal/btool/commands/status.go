@@ -0,0 +1,230 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// StatusOptions holds the configuration for the status command.
+type StatusOptions struct {
+	RepositoryOptions
+}
+
+// statusChecker compares a directory as it is now with a snap of it.
+type statusChecker struct {
+	*differ
+	// cached holds the files of the file cache, whose manifests are taken
+	// as they are for files that look the same.
+	cached  map[string]lib.FileCacheEntry
+	workers int
+	// compareTimes also takes files whose modification times differ from
+	// those in the snap as modified, noting what differs.
+	compareTimes bool
+}
+
+// manifestHash returns the hash that the manifest of the file at filePath
+// would have in the store, reusing the one in the file cache if the file
+// looks as it did then. Nothing is written to the store.
+func (c *statusChecker) manifestHash(filePath string) (string, int64, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	if cached, ok := c.cached[filePath]; ok && cached.Unchanged(lib.NewFileCacheEntry(info)) {
+		return cached.ManifestHash, cached.Size, nil
+	}
+	chunkRefs, totalSize, err := lib.ChunkFileParallel(filePath, c.store.Chunker(), c.store.Hasher(), c.workers, func(types.Chunk) error {
+		return nil
+	})
+	if err != nil {
+		return "", 0, err
+	}
+	manifestJSON, _ := json.Marshal(types.FileManifest{Chunks: chunkRefs, TotalSize: totalSize})
+	return c.store.Hasher().GetHash(manifestJSON), totalSize, nil
+}
+
+// walkedTreeEntry returns a tree entry for a walked file or directory, with
+// the name, type, and mode that status reports.
+func walkedTreeEntry(entry walkedEntry) types.TreeEntry {
+	if entry.dir != nil {
+		return types.TreeEntry{Name: entry.name, Type: "tree", Mode: entry.mode}
+	}
+	return types.TreeEntry{Name: entry.name, Type: "blob", Mode: entry.mode}
+}
+
+// addNew records a walked file or directory found at path p as added, along
+// with everything below it if it is a directory.
+func (c *statusChecker) addNew(p string, entry walkedEntry) error {
+	change := treeChange{kind: changeAdded, path: p, entry: walkedTreeEntry(entry)}
+	if entry.dir == nil {
+		info, err := os.Stat(entry.path)
+		if err != nil {
+			return err
+		}
+		change.size = info.Size()
+	}
+	c.changes = append(c.changes, change)
+	if entry.dir != nil {
+		for _, child := range entry.dir.entries {
+			if err := c.addNew(path.Join(p, child.name), child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compareDir records the changes between the tree with the given hash in
+// the snap and the walked directory dir, found at path rel.
+func (c *statusChecker) compareDir(treeHash string, dir *walkedDir, rel string) error {
+	snapEntries, err := c.readEntries(treeHash)
+	if err != nil {
+		return err
+	}
+	// Special files are only compared by snap with --special-files.
+	for name, entry := range snapEntries {
+		if entry.Type != "blob" && entry.Type != "tree" {
+			delete(snapEntries, name)
+		}
+	}
+	walked := make(map[string]walkedEntry)
+	for _, entry := range dir.entries {
+		walked[entry.name] = entry
+	}
+
+	names := make([]string, 0, len(snapEntries)+len(walked))
+	for name := range snapEntries {
+		names = append(names, name)
+	}
+	for name := range walked {
+		if _, ok := snapEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := path.Join(rel, name)
+		snapEntry, inSnap := snapEntries[name]
+		entry, onDisk := walked[name]
+		switch {
+		case !onDisk:
+			err = c.addWhole(changeRemoved, p, snapEntry)
+		case !inSnap:
+			err = c.addNew(p, entry)
+		case snapEntry.Type != walkedTreeEntry(entry).Type:
+			if err = c.addWhole(changeRemoved, p, snapEntry); err == nil {
+				err = c.addNew(p, entry)
+			}
+		case entry.dir != nil:
+			err = c.compareDir(snapEntry.Hash, entry.dir, p)
+		default:
+			err = c.compareFile(p, snapEntry, entry)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compareFile records a walked file found at path p as modified if its
+// contents or mode differ from those of its entry in the snap.
+func (c *statusChecker) compareFile(p string, snapEntry types.TreeEntry, entry walkedEntry) error {
+	manifestHash, size, err := c.manifestHash(entry.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", entry.path, err)
+	}
+	var differs []string
+	if manifestHash != snapEntry.Hash {
+		differs = append(differs, "contents")
+	}
+	if entry.mode != snapEntry.Mode {
+		differs = append(differs, "mode")
+	}
+	if c.compareTimes && snapEntry.ModTime != 0 && entry.modTime != snapEntry.ModTime {
+		differs = append(differs, "mtime")
+	}
+	if len(differs) == 0 {
+		return nil
+	}
+	oldSize, err := c.entrySize(snapEntry)
+	if err != nil {
+		return err
+	}
+	change := treeChange{kind: changeModified, path: p, entry: walkedTreeEntry(entry), size: size, oldSize: oldSize}
+	if c.compareTimes {
+		change.details = strings.Join(differs, ", ")
+	}
+	c.changes = append(c.changes, change)
+	return nil
+}
+
+// Status is the main function for the 'status' command. It lists the files
+// and directories added, removed, and modified in a directory since its
+// latest snap, like git status. Files that look as they did at the last snap
+// of the directory are not read again.
+func Status(targetDirectory string, options StatusOptions) error {
+	absTargetPath, err := filepath.Abs(targetDirectory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	}
+	if _, err := os.Stat(absTargetPath); os.IsNotExist(err) {
+		return fmt.Errorf("target directory does not exist: %s", absTargetPath)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absTargetPath)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("could not get snapshots: %w", err)
+	}
+	if len(snaps) == 0 {
+		fmt.Printf("No snaps found for \"%s\"; every file is new.\n", absTargetPath)
+		return nil
+	}
+	latest := snaps[len(snaps)-1]
+
+	// The walk hands each file to a channel for snap; status reads the
+	// files from the walked directories instead.
+	files := make(chan string)
+	go func() {
+		for range files {
+		}
+	}()
+	root, _, err := walkTree(absTargetPath, localRepoDirs(store), nil, false, files)
+	close(files)
+	if err != nil {
+		return fmt.Errorf("error finding files: %w", err)
+	}
+
+	c := &statusChecker{
+		differ:  &differ{store: store},
+		cached:  loadFileCache(store, absTargetPath).Files,
+		workers: runtime.NumCPU(),
+	}
+	if err := c.compareDir(latest.RootTreeHash, root, ""); err != nil {
+		return fmt.Errorf("failed to compare with snap %d: %w", latest.ID, err)
+	}
+
+	if len(c.changes) == 0 {
+		fmt.Printf("No changes since snap %d (%s).\n", latest.ID, latest.Hash[:7])
+		return nil
+	}
+	fmt.Printf("Changes in \"%s\" since snap %d (%s):\n", absTargetPath, latest.ID, latest.Hash[:7])
+	printChanges(c.changes)
+	return nil
+}
@@ -0,0 +1,75 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusCommand(t *testing.T) {
+	t.Run("should report nothing right after a snap", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var statusErr error
+		output := captureStdout(t, func() {
+			statusErr = commands.Status(sourceDir, commands.StatusOptions{})
+		})
+
+		// Assert
+		require.NoError(t, statusErr)
+		assert.Contains(t, output, "No changes since snap 1")
+	})
+
+	t.Run("should report new, modified, and deleted files", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileA.txt"), []byte("restore me!"), 0744))
+		require.NoError(t, os.Remove(filepath.Join(sourceDir, "subdir", "fileB.txt")))
+		require.NoError(t, os.MkdirAll(filepath.Join(sourceDir, "newdir"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "newdir", "fileC.txt"), []byte("new"), 0644))
+
+		// Act
+		var statusErr error
+		output := captureStdout(t, func() {
+			statusErr = commands.Status(sourceDir, commands.StatusOptions{})
+		})
+
+		// Assert
+		require.NoError(t, statusErr)
+		assert.Contains(t, output, "M fileA.txt (10.00 Bytes -> 11.00 Bytes)\n")
+		assert.Contains(t, output, "- subdir/fileB.txt (6.00 Bytes)\n")
+		assert.Contains(t, output, "+ newdir/\n")
+		assert.Contains(t, output, "+ newdir/fileC.txt (3.00 Bytes)\n")
+		assert.NotContains(t, output, ".btool")
+		assert.Contains(t, output, "1 file(s) added, 1 removed, 1 modified.")
+	})
+
+	t.Run("should read a file whose contents changed without its size or time", func(t *testing.T) {
+		// Arrange
+		sourceDir := t.TempDir()
+		filePath := filepath.Join(sourceDir, "same.txt")
+		require.NoError(t, os.WriteFile(filePath, []byte("aaaa"), 0644))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+		info, err := os.Stat(filePath)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filePath, []byte("bbbb"), 0644))
+		require.NoError(t, os.Chtimes(filePath, info.ModTime(), info.ModTime()))
+
+		// Act
+		var statusErr error
+		output := captureStdout(t, func() {
+			statusErr = commands.Status(sourceDir, commands.StatusOptions{})
+		})
+
+		// Assert: the file was modified within the racy window of the snap,
+		// so the file cache does not vouch for it.
+		require.NoError(t, statusErr)
+		assert.Contains(t, output, "M same.txt")
+	})
+}
@@ -0,0 +1,159 @@
+package commands
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// TreeOptions holds the configuration for the tree command.
+type TreeOptions struct {
+	SnapIdentifier string
+	// Path is the directory within the snap to draw, with forward slashes.
+	// An empty path draws the whole snap.
+	Path string
+	// MaxDepth is how many levels of directories below Path to draw, or
+	// all of them if not positive.
+	MaxDepth int
+	RepositoryOptions
+}
+
+// treeDrawer draws the directories of a snap as the tree utility does.
+type treeDrawer struct {
+	store    *lib.ObjectStore
+	maxDepth int
+	// changed holds the kind of change of each path since the previous
+	// snap, and changeBelow the directories holding changes.
+	changed     map[string]string
+	changeBelow map[string]bool
+	dirs, files int
+}
+
+// marker returns the note that highlights an entry found at path p that
+// differs from the previous snap, if it does.
+func (d *treeDrawer) marker(p string) string {
+	switch {
+	case d.changed[p] == changeAdded:
+		return " [new]"
+	case d.changed[p] == changeModified, d.changeBelow[p]:
+		return " [changed]"
+	}
+	return ""
+}
+
+// drawTree prints the entries of the tree with the given hash, found at
+// path dir at the given depth, each line starting with prefix.
+func (d *treeDrawer) drawTree(treeHash, dir, prefix string, depth int) error {
+	var tree types.Tree
+	if err := d.store.ReadObjectAsJSON(treeHash, &tree); err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", treeHash, err)
+	}
+	for i, entry := range tree.Entries {
+		p := path.Join(dir, entry.Name)
+		branch, indent := "├── ", "│   "
+		if i == len(tree.Entries)-1 {
+			branch, indent = "└── ", "    "
+		}
+
+		switch entry.Type {
+		case "tree":
+			d.dirs++
+			fmt.Printf("%s%s%s/%s\n", prefix, branch, entry.Name, d.marker(p))
+			if d.maxDepth <= 0 || depth < d.maxDepth {
+				if err := d.drawTree(entry.Hash, p, prefix+indent, depth+1); err != nil {
+					return err
+				}
+			}
+		case "blob":
+			d.files++
+			var manifest types.FileManifest
+			if err := d.store.ReadObjectAsJSON(entry.Hash, &manifest); err != nil {
+				return fmt.Errorf("failed to read manifest of %s: %w", p, err)
+			}
+			fmt.Printf("%s%s%s (%s)%s\n", prefix, branch, entry.Name, formatBytes(manifest.TotalSize, 2), d.marker(p))
+		default:
+			d.files++
+			fmt.Printf("%s%s%s (%s)%s\n", prefix, branch, entry.Name, entry.Type, d.marker(p))
+		}
+	}
+	return nil
+}
+
+// Tree is the main function for the 'tree' command. It draws the
+// directories and files of a snap, or within one of its directories, as the
+// tree utility does, highlighting those that differ from the snap before.
+func Tree(sourceDir string, options TreeOptions) error {
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return fmt.Errorf("could not resolve source path: %w", err)
+	}
+
+	store, err := openStore(options.RepositoryOptions, absSourceDir)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	snap, err := store.FindSnap(options.SnapIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to find snapshot %s: %w", options.SnapIdentifier, err)
+	}
+
+	p := cleanSnapPath(options.Path)
+	entry, err := findSnapEntry(store, snap.RootTreeHash, p)
+	if err != nil {
+		return fmt.Errorf("failed to find %q in snap %d: %w", options.Path, snap.ID, err)
+	}
+	if entry.Type != "tree" {
+		return fmt.Errorf("%q is not a directory in snap %d; use 'btool ls' to list it", options.Path, snap.ID)
+	}
+
+	// The changes are those since the snap before this one, if any.
+	snaps, err := store.GetSortedSnaps()
+	if err != nil {
+		return fmt.Errorf("failed to get snapshots: %w", err)
+	}
+	var previous *lib.SnapDetail
+	for i := range snaps {
+		if snaps[i].ID < snap.ID {
+			previous = &snaps[i]
+		}
+	}
+	d := &treeDrawer{store: store, maxDepth: options.MaxDepth, changed: make(map[string]string), changeBelow: make(map[string]bool)}
+	removed := 0
+	if previous != nil {
+		changes := &differ{store: store}
+		if err := changes.diffTrees(previous.RootTreeHash, snap.RootTreeHash, ""); err != nil {
+			return fmt.Errorf("failed to compare with snap %d: %w", previous.ID, err)
+		}
+		for _, change := range changes.changes {
+			if change.kind == changeRemoved {
+				if change.entry.Type != "tree" {
+					removed++
+				}
+			} else {
+				d.changed[change.path] = change.kind
+			}
+			for dir := path.Dir(change.path); dir != "."; dir = path.Dir(dir) {
+				d.changeBelow[dir] = true
+			}
+		}
+	}
+
+	name := p
+	if name == "" {
+		name = "."
+	}
+	fmt.Printf("%s%s\n", name, d.marker(p))
+	if err := d.drawTree(entry.Hash, p, "", 1); err != nil {
+		return err
+	}
+	fmt.Printf("\n%d directories, %d files\n", d.dirs, d.files)
+	if previous != nil {
+		fmt.Printf("Marked entries differ from snap %d; %d file(s) removed since then.\n", previous.ID, removed)
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTreeCommand(t *testing.T) {
+	t.Run("should draw the hierarchy with sizes", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var treeErr error
+		output := captureStdout(t, func() {
+			treeErr = commands.Tree(sourceDir, commands.TreeOptions{SnapIdentifier: "1"})
+		})
+
+		// Assert
+		require.NoError(t, treeErr)
+		assert.Equal(t, ".\n"+
+			"├── fileA.txt (10.00 Bytes)\n"+
+			"└── subdir/\n"+
+			"    └── fileB.txt (6.00 Bytes)\n"+
+			"\n1 directories, 2 files\n", output)
+	})
+
+	t.Run("should mark what changed since the snap before", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "subdir", "fileB.txt"), []byte("changed"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(sourceDir, "fileC.txt"), []byte("new"), 0644))
+		require.NoError(t, os.Remove(filepath.Join(sourceDir, "fileA.txt")))
+		require.NoError(t, commands.Snap(sourceDir, commands.SnapOptions{}))
+
+		// Act
+		var treeErr error
+		output := captureStdout(t, func() {
+			treeErr = commands.Tree(sourceDir, commands.TreeOptions{SnapIdentifier: "2"})
+		})
+
+		// Assert
+		require.NoError(t, treeErr)
+		assert.Contains(t, output, "├── fileC.txt (3.00 Bytes) [new]\n")
+		assert.Contains(t, output, "└── subdir/ [changed]\n")
+		assert.Contains(t, output, "    └── fileB.txt (7.00 Bytes) [changed]\n")
+		assert.Contains(t, output, "Marked entries differ from snap 1; 1 file(s) removed since then.")
+	})
+
+	t.Run("should stop at the given depth", func(t *testing.T) {
+		// Arrange
+		sourceDir := setupRestoreTest(t)
+
+		// Act
+		var treeErr error
+		output := captureStdout(t, func() {
+			treeErr = commands.Tree(sourceDir, commands.TreeOptions{SnapIdentifier: "1", MaxDepth: 1})
+		})
+
+		// Assert
+		require.NoError(t, treeErr)
+		assert.Contains(t, output, "└── subdir/\n")
+		assert.NotContains(t, output, "fileB.txt")
+	})
+}
@@ -0,0 +1,179 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Defaults for the watch command.
+const (
+	DefaultQuietPeriod = 10 * time.Second
+	DefaultMinInterval = time.Minute
+)
+
+// WatchOptions holds the configuration for the watch command.
+type WatchOptions struct {
+	// QuietPeriod is how long the directory must go without a change before
+	// a snap is taken, so that a burst of changes makes a single snap.
+	QuietPeriod time.Duration
+	// MinInterval is the least time between the starts of two snaps, so that
+	// a directory that keeps changing, such as a build directory, does not
+	// make one snap after another.
+	MinInterval time.Duration
+	// Stop ends the watch when closed, as an interrupt does.
+	Stop <-chan struct{}
+	SnapOptions
+}
+
+// directoryWatcher reports the changes in a directory tree, leaving out the
+// paths a snap of it leaves out. fsnotify watches single directories, so
+// each directory of the tree is watched, including those created later.
+type directoryWatcher struct {
+	watcher  *fsnotify.Watcher
+	rootDir  string
+	repoDirs []string
+}
+
+// addTree watches dir and the directories below it.
+func (w *directoryWatcher) addTree(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path != dir && os.IsNotExist(err) {
+				return nil // Removed while being walked.
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if path != w.rootDir && isExcluded(w.rootDir, w.repoDirs, path) {
+			return filepath.SkipDir
+		}
+		return w.watcher.Add(path)
+	})
+}
+
+// changed reports whether event changes what a snap would hold, watching
+// the directories it creates.
+func (w *directoryWatcher) changed(event fsnotify.Event) bool {
+	if event.Name == w.rootDir || isExcluded(w.rootDir, w.repoDirs, event.Name) {
+		return false
+	}
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := w.addTree(event.Name); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: could not watch %s: %v\n", event.Name, err)
+			}
+		}
+	}
+	return true
+}
+
+// Watch is the main function for the 'watch' command. It takes a snap of
+// targetDirectory, then another whenever it changes, once it has been quiet
+// for options.QuietPeriod and options.MinInterval has passed since the last
+// snap started. It runs until interrupted or options.Stop is closed.
+func Watch(targetDirectory string, options WatchOptions) error {
+	if options.QuietPeriod <= 0 {
+		return fmt.Errorf("quiet period must be positive")
+	}
+	if options.MinInterval < 0 {
+		return fmt.Errorf("minimum interval must not be negative")
+	}
+	absTargetPath, err := filepath.Abs(targetDirectory)
+	if err != nil {
+		return fmt.Errorf("could not resolve absolute path for %s: %w", targetDirectory, err)
+	}
+	if _, err := os.Stat(absTargetPath); os.IsNotExist(err) {
+		return fmt.Errorf("target directory does not exist: %s", absTargetPath)
+	}
+
+	// Read the password once rather than before every snap, which would run
+	// a password command over and over.
+	if options.Password, err = resolvePassword(options.passwordSource()); err != nil {
+		return err
+	}
+	options.PasswordFile, options.PasswordCommand = "", ""
+
+	// Opening the repository up front reports a wrong password or an
+	// unreachable repository right away, and tells which directories are the
+	// repository's own, whose changes are the snaps themselves.
+	store, err := openWriteStore(options.RepositoryOptions, absTargetPath)
+	if err != nil {
+		return err
+	}
+	repoDirs := localRepoDirs(store)
+	store.Close()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("could not watch %s: %w", absTargetPath, err)
+	}
+	defer watcher.Close()
+	dirs := &directoryWatcher{watcher: watcher, rootDir: absTargetPath, repoDirs: repoDirs}
+	if err := dirs.addTree(absTargetPath); err != nil {
+		return fmt.Errorf("could not watch %s: %w", absTargetPath, err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	// Snap what is there now, since it may have changed since the last snap.
+	lastSnap := time.Now()
+	if err := Snap(absTargetPath, options.SnapOptions); err != nil {
+		return err
+	}
+	fmt.Printf("👀 Watching \"%s\" for changes...\n", absTargetPath)
+
+	// timer fires when a snap is due; it only runs while there are changes
+	// not yet snapped. Each change pushes it back by the quiet period.
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	schedule := func() {
+		due := time.Now().Add(options.QuietPeriod)
+		if earliest := lastSnap.Add(options.MinInterval); due.Before(earliest) {
+			due = earliest
+		}
+		timer.Reset(time.Until(due))
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if dirs.changed(event) {
+				schedule()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Events may have been dropped, so a snap is the safe bet.
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			schedule()
+
+		case <-timer.C:
+			lastSnap = time.Now()
+			// A failed snap, say of a file removed while it was read, is
+			// taken again on the next change.
+			if err := Snap(absTargetPath, options.SnapOptions); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: snap failed: %v\n", err)
+			}
+
+		case <-interrupt:
+			return nil
+		case <-options.Stop:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package commands_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/commands"
+	"github.com/gingerrexayers/btool-go/internal/btool/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchCommand(t *testing.T) {
+	// startWatch watches testDir with options until the test ends, returning
+	// a function that counts its snaps.
+	startWatch := func(t *testing.T, testDir string, options commands.WatchOptions) func() int {
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		options.Stop = stop
+		go func() { done <- commands.Watch(testDir, options) }()
+		t.Cleanup(func() {
+			close(stop)
+			assert.NoError(t, <-done)
+		})
+		return func() int {
+			snaps, err := lib.NewLocalObjectStore(testDir).GetSortedSnaps()
+			if err != nil {
+				return -1
+			}
+			return len(snaps)
+		}
+	}
+
+	t.Run("should snap on start and once after each burst of changes", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		countSnaps := startWatch(t, testDir, commands.WatchOptions{QuietPeriod: 200 * time.Millisecond})
+		require.Eventually(t, func() bool { return countSnaps() == 1 }, 5*time.Second, 20*time.Millisecond)
+
+		// Act
+		for i := 0; i < 5; i++ {
+			require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileA.txt"), []byte("burst of changes"), 0644))
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		// Assert
+		require.Eventually(t, func() bool { return countSnaps() == 2 }, 5*time.Second, 20*time.Millisecond)
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, 2, countSnaps(), "Neither the burst nor the snap's own writes should make more snaps")
+	})
+
+	t.Run("should watch directories created after it started", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		countSnaps := startWatch(t, testDir, commands.WatchOptions{QuietPeriod: 100 * time.Millisecond})
+		require.Eventually(t, func() bool { return countSnaps() == 1 }, 5*time.Second, 20*time.Millisecond)
+		newDir := filepath.Join(testDir, "newdir")
+		require.NoError(t, os.Mkdir(newDir, 0755))
+		require.Eventually(t, func() bool { return countSnaps() == 2 }, 5*time.Second, 20*time.Millisecond)
+
+		// Act
+		require.NoError(t, os.WriteFile(filepath.Join(newDir, "fileD.txt"), []byte("new file D"), 0644))
+
+		// Assert
+		require.Eventually(t, func() bool { return countSnaps() == 3 }, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("should wait the minimum interval between snaps", func(t *testing.T) {
+		// Arrange
+		testDir := setupTestDir(t)
+		countSnaps := startWatch(t, testDir, commands.WatchOptions{QuietPeriod: 50 * time.Millisecond, MinInterval: 1500 * time.Millisecond})
+		require.Eventually(t, func() bool { return countSnaps() == 1 }, 5*time.Second, 20*time.Millisecond)
+
+		// Act
+		require.NoError(t, os.WriteFile(filepath.Join(testDir, "fileA.txt"), []byte("changed"), 0644))
+
+		// Assert
+		time.Sleep(500 * time.Millisecond)
+		assert.Equal(t, 1, countSnaps(), "The change should wait for the minimum interval")
+		require.Eventually(t, func() bool { return countSnaps() == 2 }, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("should refuse a quiet period that is not positive", func(t *testing.T) {
+		// Act
+		err := commands.Watch(t.TempDir(), commands.WatchOptions{})
+
+		// Assert
+		assert.ErrorContains(t, err, "quiet period")
+	})
+}
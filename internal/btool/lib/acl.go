@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The layout of a POSIX ACL as Linux keeps it in the system.posix_acl_access
+// and system.posix_acl_default extended attributes: a version, then an
+// entry of a tag, permissions, and ID for each line of the ACL.
+const (
+	aclXattrVersion   = 2
+	aclXattrEntrySize = 8
+	aclUndefinedID    = 0xFFFFFFFF
+)
+
+// The tags of the entries of a POSIX ACL, and their names in its text form.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+var aclTagNames = map[uint16]string{
+	aclUserObj:  "user",
+	aclUser:     "user",
+	aclGroupObj: "group",
+	aclGroup:    "group",
+	aclMask:     "mask",
+	aclOther:    "other",
+}
+
+// aclMinimalSize is the size of an ACL extended attribute with only the
+// three entries that repeat the mode bits.
+const aclMinimalSize = 4 + 3*aclXattrEntrySize
+
+// formatACL turns an ACL extended attribute into the short text form of
+// getfacl -n, such as "user::rw-,user:1000:r--,group::r--,mask::r--,other::---".
+func formatACL(xattr []byte) (string, error) {
+	if len(xattr) < 4 || (len(xattr)-4)%aclXattrEntrySize != 0 {
+		return "", fmt.Errorf("invalid ACL of %d bytes", len(xattr))
+	}
+	if version := binary.LittleEndian.Uint32(xattr); version != aclXattrVersion {
+		return "", fmt.Errorf("unsupported ACL version %d", version)
+	}
+
+	var lines []string
+	for offset := 4; offset < len(xattr); offset += aclXattrEntrySize {
+		tag := binary.LittleEndian.Uint16(xattr[offset:])
+		perm := binary.LittleEndian.Uint16(xattr[offset+2:])
+		id := binary.LittleEndian.Uint32(xattr[offset+4:])
+		name, ok := aclTagNames[tag]
+		if !ok {
+			return "", fmt.Errorf("unknown ACL entry tag %#x", tag)
+		}
+		qualifier := ""
+		if tag == aclUser || tag == aclGroup {
+			qualifier = strconv.FormatUint(uint64(id), 10)
+		}
+		perms := []byte("---")
+		for i, bit := range []uint16{4, 2, 1} {
+			if perm&bit != 0 {
+				perms[i] = "rwx"[i]
+			}
+		}
+		lines = append(lines, name+":"+qualifier+":"+string(perms))
+	}
+	return strings.Join(lines, ","), nil
+}
+
+// parseACL turns the text form of formatACL back into an ACL extended
+// attribute.
+func parseACL(text string) ([]byte, error) {
+	lines := strings.Split(text, ",")
+	xattr := binary.LittleEndian.AppendUint32(make([]byte, 0, 4+len(lines)*aclXattrEntrySize), aclXattrVersion)
+	for _, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 || len(fields[2]) != 3 {
+			return nil, fmt.Errorf("invalid ACL entry %q", line)
+		}
+		name, qualifier, perms := fields[0], fields[1], fields[2]
+
+		var tag uint16
+		id := uint64(aclUndefinedID)
+		switch {
+		case name == "user" && qualifier == "":
+			tag = aclUserObj
+		case name == "group" && qualifier == "":
+			tag = aclGroupObj
+		case name == "mask" && qualifier == "":
+			tag = aclMask
+		case name == "other" && qualifier == "":
+			tag = aclOther
+		case name == "user" || name == "group":
+			tag = aclUser
+			if name == "group" {
+				tag = aclGroup
+			}
+			var err error
+			if id, err = strconv.ParseUint(qualifier, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid ID in ACL entry %q", line)
+			}
+		default:
+			return nil, fmt.Errorf("invalid ACL entry %q", line)
+		}
+
+		var perm uint16
+		for i, bit := range []uint16{4, 2, 1} {
+			switch perms[i] {
+			case "rwx"[i]:
+				perm |= bit
+			case '-':
+			default:
+				return nil, fmt.Errorf("invalid permissions in ACL entry %q", line)
+			}
+		}
+		xattr = binary.LittleEndian.AppendUint16(xattr, tag)
+		xattr = binary.LittleEndian.AppendUint16(xattr, perm)
+		xattr = binary.LittleEndian.AppendUint32(xattr, uint32(id))
+	}
+	return xattr, nil
+}
@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"golang.org/x/sys/unix"
+)
+
+// The extended attributes holding the ACLs of a file or directory.
+const (
+	aclAccessXattr  = "system.posix_acl_access"
+	aclDefaultXattr = "system.posix_acl_default"
+)
+
+// readACLXattr returns the ACL extended attribute attr of a path, or nil if
+// it has none or the filesystem does not support ACLs.
+func readACLXattr(path, attr string) ([]byte, error) {
+	buf := make([]byte, 4+16*aclXattrEntrySize)
+	for {
+		n, err := unix.Lgetxattr(path, attr, buf)
+		switch {
+		case errors.Is(err, unix.ERANGE):
+			size, err := unix.Lgetxattr(path, attr, nil)
+			if err != nil {
+				return nil, err
+			}
+			buf = make([]byte, size)
+			continue
+		case errors.Is(err, unix.ENODATA), errors.Is(err, unix.ENOTSUP):
+			return nil, nil
+		case err != nil:
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
+
+// FileACL returns the POSIX ACLs of a file or directory, or nil if it has
+// none beyond its mode bits or the filesystem does not support them.
+func FileACL(path string, isDir bool) (*types.ACL, error) {
+	var acl types.ACL
+	access, err := readACLXattr(path, aclAccessXattr)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the ACL of %s: %w", path, err)
+	}
+	// An access ACL of three entries only repeats the mode bits.
+	if len(access) > aclMinimalSize {
+		if acl.Access, err = formatACL(access); err != nil {
+			return nil, fmt.Errorf("could not read the ACL of %s: %w", path, err)
+		}
+	}
+	if isDir {
+		defaults, err := readACLXattr(path, aclDefaultXattr)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the default ACL of %s: %w", path, err)
+		}
+		if defaults != nil {
+			if acl.Default, err = formatACL(defaults); err != nil {
+				return nil, fmt.Errorf("could not read the default ACL of %s: %w", path, err)
+			}
+		}
+	}
+	if acl == (types.ACL{}) {
+		return nil, nil
+	}
+	return &acl, nil
+}
+
+// SetFileACL gives a file or directory the POSIX ACLs of acl.
+func SetFileACL(path string, acl types.ACL) error {
+	for _, a := range []struct{ attr, text string }{{aclAccessXattr, acl.Access}, {aclDefaultXattr, acl.Default}} {
+		if a.text == "" {
+			continue
+		}
+		xattr, err := parseACL(a.text)
+		if err != nil {
+			return err
+		}
+		if err := unix.Lsetxattr(path, a.attr, xattr, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
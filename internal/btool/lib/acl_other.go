@@ -0,0 +1,19 @@
+//go:build !linux
+
+package lib
+
+import (
+	"errors"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// FileACL returns nil, as POSIX ACLs are only read on Linux.
+func FileACL(string, bool) (*types.ACL, error) {
+	return nil, nil
+}
+
+// SetFileACL fails, as POSIX ACLs are only restored on Linux.
+func SetFileACL(string, types.ACL) error {
+	return errors.New("POSIX ACLs are only supported on Linux")
+}
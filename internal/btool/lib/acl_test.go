@@ -0,0 +1,50 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLText(t *testing.T) {
+	t.Run("should turn the text form into an extended attribute and back", func(t *testing.T) {
+		// Arrange
+		text := "user::rw-,user:1000:r--,group::r-x,group:50:rwx,mask::rwx,other::---"
+
+		// Act
+		xattr, err := parseACL(text)
+		require.NoError(t, err)
+		formatted, err := formatACL(xattr)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Len(t, xattr, 4+6*aclXattrEntrySize)
+		assert.Equal(t, text, formatted)
+	})
+
+	t.Run("should refuse malformed text", func(t *testing.T) {
+		for _, text := range []string{"", "user::rw", "user:bob:rw-", "owner::rw-", "other::rwz", "mask:1:r--"} {
+			// Act
+			_, err := parseACL(text)
+
+			// Assert
+			assert.Error(t, err, text)
+		}
+	})
+
+	t.Run("should refuse malformed extended attributes", func(t *testing.T) {
+		// Arrange
+		valid, err := parseACL("user::rw-,group::r--,other::r--")
+		require.NoError(t, err)
+		badVersion := append([]byte{1}, valid[1:]...)
+
+		// Act
+		_, errShort := formatACL(valid[:len(valid)-1])
+		_, errVersion := formatACL(badVersion)
+
+		// Assert
+		assert.Error(t, errShort)
+		assert.ErrorContains(t, errVersion, "version")
+	})
+}
@@ -0,0 +1,229 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the files and directories stored inside a repository. These are
+// backend-relative names and always use forward slashes, regardless of the OS.
+const (
+	// IndexFileName is the name of the single index file of repositories
+	// from before the index was split into shards in IndexDirName.
+	IndexFileName = "index.json"
+	// CounterFileName is the name of the persistent snapshot ID counter.
+	CounterFileName = "meta/counter"
+	// RecipientFileName is the name of the file holding the age recipient that
+	// backups without the master key encrypt their session keys to.
+	RecipientFileName = "meta/recipient"
+	// ConfigFileName is the name of the repository config, which holds settings
+	// fixed when the repository was created.
+	ConfigFileName = "meta/config"
+	// DefaultsFileName is the name of the file holding the repository's
+	// default settings for commands, such as compression. It is never
+	// encrypted, so that backups without the master key can read it.
+	DefaultsFileName = "meta/defaults"
+	// KeyringFileName is the name of the file holding the data keys of an
+	// encrypted repository whose key has been rotated.
+	KeyringFileName = "meta/keyring"
+)
+
+// BackendEntry describes a single file returned by Backend.List.
+type BackendEntry struct {
+	Name string `json:"name"` // The base name of the file within the listed directory.
+	Size int64  `json:"size"`
+}
+
+// Backend is the storage layer underneath a repository. All reads and writes
+// of packs, the index, snap manifests, and metadata go through a Backend, so
+// the same repository logic works against local disk and remote storage.
+//
+// Names are slash-separated paths relative to the repository root, such as
+// "index/<hash>" or "packs/<hash>". Implementations must be safe for concurrent
+// use, and Get, GetRange, and Delete must return an error satisfying
+// errors.Is(err, fs.ErrNotExist) when the named file does not exist.
+type Backend interface {
+	// Put stores data under name, replacing any existing file.
+	Put(name string, data []byte) error
+	// Get returns the full contents of the named file.
+	Get(name string) ([]byte, error)
+	// GetRange returns length bytes of the named file starting at offset.
+	GetRange(name string, offset, length int64) ([]byte, error)
+	// List returns the files directly inside dir. A missing dir is not an error.
+	List(dir string) ([]BackendEntry, error)
+	// Delete removes the named file.
+	Delete(name string) error
+	// Location returns a human-readable description of where the repository lives.
+	Location() string
+}
+
+// UnwrapBackend returns the innermost Backend beneath any wrappers, such as
+// RetryBackend, that expose the backend they wrap through an Unwrap method.
+func UnwrapBackend(backend Backend) Backend {
+	for {
+		wrapper, ok := backend.(interface{ Unwrap() Backend })
+		if !ok {
+			return backend
+		}
+		backend = wrapper.Unwrap()
+	}
+}
+
+// VersionedBackend is implemented by backends that can cheaply report the
+// version of a file, such as an ETag or its modification time and size,
+// without downloading it. The version changes whenever the file does.
+type VersionedBackend interface {
+	Version(name string) (string, error)
+}
+
+// backendVersion returns the version of the named file, or an error wrapping
+// errors.ErrUnsupported if the backend cannot report versions.
+func backendVersion(backend Backend, name string) (string, error) {
+	versioned, ok := backend.(VersionedBackend)
+	if !ok {
+		return "", fmt.Errorf("%s does not report file versions: %w", backend.Location(), errors.ErrUnsupported)
+	}
+	return versioned.Version(name)
+}
+
+// RenamingBackend is implemented by backends that can move a file to another
+// name without its contents passing through the client, such as a rename on
+// disk or a server-side move.
+type RenamingBackend interface {
+	// Rename moves the file oldName to newName, replacing any file there. It
+	// returns an error satisfying errors.Is(err, fs.ErrNotExist) if oldName
+	// does not exist.
+	Rename(oldName, newName string) error
+}
+
+// renameFile moves oldName to newName, with Rename if the backend is a
+// RenamingBackend, and otherwise by copying it.
+func renameFile(backend Backend, oldName, newName string) error {
+	if renaming, ok := backend.(RenamingBackend); ok {
+		return renaming.Rename(oldName, newName)
+	}
+	return copyAndDelete(backend, oldName, newName)
+}
+
+// copyAndDelete moves oldName to newName on a backend that cannot rename, by
+// reading it, storing it under newName, and deleting it.
+func copyAndDelete(backend Backend, oldName, newName string) error {
+	data, err := backend.Get(oldName)
+	if err != nil {
+		return err
+	}
+	if err := backend.Put(newName, data); err != nil {
+		return err
+	}
+	return backend.Delete(oldName)
+}
+
+// StreamingBackend is implemented by backends that can store a file while it
+// is being written, rather than from a buffer holding all of it. Packs are
+// written this way, since their name is only known once all of their
+// contents are.
+type StreamingBackend interface {
+	// Create starts a new, unnamed file in dir.
+	Create(dir string) (BackendWriter, error)
+}
+
+// BackendWriter is a file being written to a backend. Nothing is visible
+// under any name until Commit.
+type BackendWriter interface {
+	io.Writer
+	// Commit stores the file under name, which must be inside the dir it was
+	// created in, replacing any existing file.
+	Commit(name string) error
+	// Abort discards the file. It does nothing after Commit.
+	Abort() error
+}
+
+// createFile starts a new file in dir, streamed to the backend if it is a
+// StreamingBackend, and otherwise buffered in memory and stored with Put.
+func createFile(backend Backend, dir string) (BackendWriter, error) {
+	if streaming, ok := backend.(StreamingBackend); ok {
+		return streaming.Create(dir)
+	}
+	return &bufferedWriter{backend: backend}, nil
+}
+
+// bufferedWriter is the BackendWriter of backends that can only Put.
+type bufferedWriter struct {
+	backend Backend
+	data    []byte
+}
+
+func (w *bufferedWriter) Write(p []byte) (int, error) {
+	w.data = append(w.data, p...)
+	return len(p), nil
+}
+
+func (w *bufferedWriter) Commit(name string) error {
+	if err := w.backend.Put(name, w.data); err != nil {
+		return err
+	}
+	w.data = nil
+	return nil
+}
+
+func (w *bufferedWriter) Abort() error {
+	w.data = nil
+	return nil
+}
+
+// statVersion builds a version string from a file's modification time and size.
+func statVersion(info fs.FileInfo) string {
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+}
+
+// packName returns the backend name of the packfile with the given hash.
+func packName(packHash string) string {
+	return PacksDirName + "/" + packHash
+}
+
+// snapName returns the backend name of the snap manifest with the given hash.
+func snapName(snapHash string) string {
+	return SnapsDirName + "/" + snapHash + ".json"
+}
+
+// OpenBackend resolves a repository location into a Backend. An empty repo
+// selects the default local repository inside baseDir (baseDir/.btool), a plain
+// path selects a local repository at that path, and URL-style locations such as
+// s3://bucket/prefix, sftp://user@host/path, davs://host/path (WebDAV), or
+// https://host/repo (served by `btool serve`) select a remote backend. Any
+// rclone remote can be used as rclone:remote:path. Backends that hold
+// connections implement io.Closer.
+func OpenBackend(repo, baseDir string) (Backend, error) {
+	if repo == "" {
+		return NewLocalBackend(GetBtoolDir(baseDir)), nil
+	}
+	if strings.HasPrefix(repo, "rclone:") {
+		return NewRcloneBackend(repo)
+	}
+
+	scheme, _, found := strings.Cut(repo, "://")
+	if !found {
+		absRepo, err := filepath.Abs(repo)
+		if err != nil {
+			return nil, fmt.Errorf("could not resolve repository path %s: %w", repo, err)
+		}
+		return NewLocalBackend(absRepo), nil
+	}
+
+	switch scheme {
+	case "s3":
+		return NewS3Backend(repo)
+	case "sftp":
+		return NewSFTPBackend(repo)
+	case "http", "https":
+		return NewHTTPBackend(repo)
+	case "dav", "davs":
+		return NewWebDAVBackend(repo)
+	default:
+		return nil, fmt.Errorf("unsupported repository scheme %q in %q", scheme, repo)
+	}
+}
@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexVersionFileName records the version of the cached index, so the cache
+// can tell whether the repository's index has changed since it was stored.
+const indexVersionFileName = IndexFileName + ".version"
+
+// CachedBackend keeps local copies of a remote repository's metadata, so
+// commands like list and restore do not download it again on every run.
+//
+// Snap manifests and index shards are named by the hash of their content or
+// pack and never change, so they are cached as soon as they are read or
+// written. The single index file of older repositories changes on every snap;
+// it is only cached when the wrapped backend can report file versions, and a
+// cached copy is used only while its version still matches. Packs are never
+// cached.
+type CachedBackend struct {
+	backend Backend
+	cache   *LocalBackend
+}
+
+// NewCachedBackend wraps backend with a metadata cache stored in cacheDir.
+func NewCachedBackend(backend Backend, cacheDir string) *CachedBackend {
+	return &CachedBackend{backend: backend, cache: NewLocalBackend(cacheDir)}
+}
+
+// DefaultCacheDir returns the cache directory for the repository at location,
+// inside the user's cache directory (e.g. ~/.cache/btool on Linux).
+func DefaultCacheDir(location string) (string, error) {
+	userCacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userCacheDir, "btool", GetHash([]byte(location))[:16]), nil
+}
+
+// isImmutableName reports whether name is a snap manifest or an index shard,
+// which never change once written.
+func isImmutableName(name string) bool {
+	return strings.HasPrefix(name, SnapsDirName+"/") || strings.HasPrefix(name, IndexDirName+"/")
+}
+
+// Put stores data in the wrapped backend and keeps the cache in step.
+func (b *CachedBackend) Put(name string, data []byte) error {
+	if err := b.backend.Put(name, data); err != nil {
+		return err
+	}
+	switch {
+	case isImmutableName(name):
+		_ = b.cache.Put(name, data)
+	case name == IndexFileName:
+		// The new version is only known to the backend; drop the stale copy.
+		_ = b.cache.Delete(indexVersionFileName)
+	}
+	return nil
+}
+
+// Get returns the named file, from the cache when a valid copy exists.
+// Cache failures are never fatal; the file is fetched from the backend instead.
+func (b *CachedBackend) Get(name string) ([]byte, error) {
+	switch {
+	case isImmutableName(name):
+		if data, err := b.cache.Get(name); err == nil {
+			return data, nil
+		}
+		data, err := b.backend.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		_ = b.cache.Put(name, data)
+		return data, nil
+
+	case name == IndexFileName:
+		version, err := backendVersion(b.backend, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		if err != nil {
+			return b.backend.Get(name)
+		}
+		if cachedVersion, err := b.cache.Get(indexVersionFileName); err == nil && string(cachedVersion) == version {
+			if data, err := b.cache.Get(name); err == nil {
+				return data, nil
+			}
+		}
+
+		data, err := b.backend.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		// Store the data before its version, so a crash in between can only
+		// leave a copy that fails validation.
+		if b.cache.Put(name, data) == nil {
+			_ = b.cache.Put(indexVersionFileName, []byte(version))
+		}
+		return data, nil
+	}
+	return b.backend.Get(name)
+}
+
+// GetRange reads part of a file from the wrapped backend.
+func (b *CachedBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	return b.backend.GetRange(name, offset, length)
+}
+
+// List lists dir in the wrapped backend.
+func (b *CachedBackend) List(dir string) ([]BackendEntry, error) {
+	return b.backend.List(dir)
+}
+
+// Delete removes the named file from the wrapped backend and the cache.
+func (b *CachedBackend) Delete(name string) error {
+	if err := b.backend.Delete(name); err != nil {
+		return err
+	}
+	switch {
+	case isImmutableName(name):
+		_ = b.cache.Delete(name)
+	case name == IndexFileName:
+		_ = b.cache.Delete(indexVersionFileName)
+	}
+	return nil
+}
+
+// Rename moves the named file in the wrapped backend and drops it from the
+// cache. A file the wrapped backend cannot rename is copied through the cache.
+func (b *CachedBackend) Rename(oldName, newName string) error {
+	renaming, ok := b.backend.(RenamingBackend)
+	if !ok {
+		return copyAndDelete(b, oldName, newName)
+	}
+	if err := renaming.Rename(oldName, newName); err != nil {
+		return err
+	}
+	for _, name := range []string{oldName, newName} {
+		switch {
+		case isImmutableName(name):
+			_ = b.cache.Delete(name)
+		case name == IndexFileName:
+			_ = b.cache.Delete(indexVersionFileName)
+		}
+	}
+	return nil
+}
+
+// Version returns the version of the named file from the wrapped backend.
+func (b *CachedBackend) Version(name string) (string, error) {
+	return backendVersion(b.backend, name)
+}
+
+// Unwrap returns the wrapped backend.
+func (b *CachedBackend) Unwrap() Backend {
+	return b.backend
+}
+
+// Location returns the location of the wrapped backend.
+func (b *CachedBackend) Location() string {
+	return b.backend.Location()
+}
+
+// Close closes the wrapped backend if it holds any resources.
+func (b *CachedBackend) Close() error {
+	if closer, ok := b.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
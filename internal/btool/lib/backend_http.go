@@ -0,0 +1,164 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ServerTokenEnvVar names the environment variable holding the bearer token
+// that HTTPBackend presents to a `btool serve` repository server.
+const ServerTokenEnvVar = "BTOOL_SERVER_TOKEN"
+
+// HTTPBackend talks to a repository exposed by `btool serve`. The protocol is
+// deliberately small: GET (optionally with a Range header), PUT, and DELETE on
+// file URLs, and GET on a directory URL ending in "/" for a JSON listing.
+type HTTPBackend struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewHTTPBackend creates a Backend for an http:// or https:// repository URL.
+func NewHTTPBackend(location string) (*HTTPBackend, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return nil, fmt.Errorf("invalid HTTP repository location %q", location)
+	}
+	return &HTTPBackend{
+		baseURL: strings.TrimSuffix(location, "/"),
+		token:   os.Getenv(ServerTokenEnvVar),
+		client:  &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// do sends a request for the given backend name and returns the response if
+// its status is one of the expected codes.
+func (b *HTTPBackend) do(method, name string, body []byte, header http.Header, expected ...int) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, b.baseURL+"/"+name, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s %s: server returned %s: %s", method, name, resp.Status, strings.TrimSpace(string(message)))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		// Retrying cannot help until the token or its role changes.
+		return nil, fmt.Errorf("%w (%w)", err, fs.ErrPermission)
+	}
+	return nil, err
+}
+
+// Put uploads data to the server.
+func (b *HTTPBackend) Put(name string, data []byte) error {
+	resp, err := b.do(http.MethodPut, name, data, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get downloads the full contents of a file.
+func (b *HTTPBackend) Get(name string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, name, nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// GetRange downloads part of a file using an HTTP Range request.
+func (b *HTTPBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		return []byte{}, nil
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.do(http.MethodGet, name, nil, header, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("GET %s: short read at offset %d: %w", name, offset, io.ErrUnexpectedEOF)
+	}
+	return data, nil
+}
+
+// List fetches the JSON listing of a directory.
+func (b *HTTPBackend) List(dir string) ([]BackendEntry, error) {
+	resp, err := b.do(http.MethodGet, dir+"/", nil, nil, http.StatusOK)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []BackendEntry{}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	entries := []BackendEntry{}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, fmt.Errorf("could not parse listing of %s: %w", dir, err)
+	}
+	return entries, nil
+}
+
+// Delete removes a file on the server.
+func (b *HTTPBackend) Delete(name string) error {
+	resp, err := b.do(http.MethodDelete, name, nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Version returns the ETag the server reports for a file.
+func (b *HTTPBackend) Version(name string) (string, error) {
+	resp, err := b.do(http.MethodHead, name, nil, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Location returns the repository URL.
+func (b *HTTPBackend) Location() string {
+	return b.baseURL
+}
@@ -0,0 +1,332 @@
+package lib
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxOpenHandles is the number of files a LocalBackend keeps open for
+// GetRange. A restore reads many small objects from a few packs at a time,
+// and opening the pack for each of them would cost more than the read.
+const maxOpenHandles = 64
+
+// LocalBackend stores a repository as plain files under a root directory on
+// the local filesystem. This is the layout used by the default .btool directory.
+type LocalBackend struct {
+	root    string
+	handles handlePool
+}
+
+// NewLocalBackend creates a Backend rooted at the given directory. The
+// directory does not need to exist yet; it is created on the first write.
+func NewLocalBackend(root string) *LocalBackend {
+	return &LocalBackend{root: root}
+}
+
+// path converts a slash-separated backend name into an OS path under the root.
+func (b *LocalBackend) path(name string) string {
+	return filepath.Join(b.root, filepath.FromSlash(name))
+}
+
+// Put writes data to a temporary file and renames it into place, so readers
+// never observe a partially written file.
+func (b *LocalBackend) Put(name string, data []byte) error {
+	finalPath := b.path(name)
+	// A handle kept open for the old file would go on reading it.
+	b.handles.evict(finalPath)
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0755); err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(finalPath), ".tmp-"+filepath.Base(finalPath)+"-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Create starts a new file in dir as a temporary file, which Commit renames
+// into place like Put does.
+func (b *LocalBackend) Create(dir string) (BackendWriter, error) {
+	dirPath := b.path(dir)
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, err
+	}
+	tmpFile, err := os.CreateTemp(dirPath, ".tmp-new-*")
+	if err != nil {
+		return nil, err
+	}
+	return &localWriter{backend: b, file: tmpFile, buffer: bufio.NewWriterSize(tmpFile, localWriteBufferSize)}, nil
+}
+
+// Get reads the full contents of the named file.
+func (b *LocalBackend) Get(name string) ([]byte, error) {
+	return os.ReadFile(b.path(name))
+}
+
+// GetRange reads length bytes of the named file starting at offset. The file
+// is kept open for later reads until it falls out of the handle pool, the
+// file is replaced or deleted, or the backend is closed.
+func (b *LocalBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	handle, err := b.handles.acquire(b.path(name))
+	if err != nil {
+		return nil, err
+	}
+	defer b.handles.release(handle)
+
+	buffer := make([]byte, length)
+	if _, err := handle.file.ReadAt(buffer, offset); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// List returns the regular files directly inside dir, skipping any temporary
+// files left behind by an interrupted Put.
+func (b *LocalBackend) List(dir string) ([]BackendEntry, error) {
+	dirEntries, err := os.ReadDir(b.path(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []BackendEntry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := []BackendEntry{}
+	for _, entry := range dirEntries {
+		if entry.IsDir() || isLocalTempFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // The file may have been removed concurrently.
+		}
+		entries = append(entries, BackendEntry{Name: entry.Name(), Size: info.Size()})
+	}
+	return entries, nil
+}
+
+// Delete removes the named file.
+func (b *LocalBackend) Delete(name string) error {
+	// Windows cannot remove a file that is open.
+	b.handles.evict(b.path(name))
+	return os.Remove(b.path(name))
+}
+
+// Rename moves the named file with a rename on disk.
+func (b *LocalBackend) Rename(oldName, newName string) error {
+	newPath := b.path(newName)
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return err
+	}
+	b.handles.evict(b.path(oldName))
+	b.handles.evict(newPath)
+	return os.Rename(b.path(oldName), newPath)
+}
+
+// Version returns the modification time and size of the named file.
+func (b *LocalBackend) Version(name string) (string, error) {
+	info, err := os.Stat(b.path(name))
+	if err != nil {
+		return "", err
+	}
+	return statVersion(info), nil
+}
+
+// Location returns the root directory of the repository.
+func (b *LocalBackend) Location() string {
+	return b.root
+}
+
+// Close closes the files kept open for GetRange.
+func (b *LocalBackend) Close() error {
+	return b.handles.closeAll()
+}
+
+// localWriteBufferSize is the size of the buffer in front of a file being
+// written by Create, so that small objects do not each cost a system call.
+const localWriteBufferSize = 1024 * 1024 // 1MB
+
+// localWriter is a file being written to a LocalBackend.
+type localWriter struct {
+	backend *LocalBackend
+	file    *os.File
+	buffer  *bufio.Writer
+	done    bool
+}
+
+func (w *localWriter) Write(p []byte) (int, error) {
+	return w.buffer.Write(p)
+}
+
+// Commit flushes the file and renames it to name.
+func (w *localWriter) Commit(name string) error {
+	if w.done {
+		return errors.New("file already committed or aborted")
+	}
+	tmpPath := w.file.Name()
+	if err := w.buffer.Flush(); err != nil {
+		w.Abort()
+		return err
+	}
+	w.done = true
+	if err := w.file.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	finalPath := w.backend.path(name)
+	w.backend.handles.evict(finalPath)
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Abort closes and removes the temporary file.
+func (w *localWriter) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	w.file.Close()
+	return os.Remove(w.file.Name())
+}
+
+// isLocalTempFile reports whether name is a temporary file created by Put or
+// Create.
+func isLocalTempFile(name string) bool {
+	return strings.HasPrefix(name, ".tmp-")
+}
+
+// handlePool keeps up to maxOpenHandles files open for reading, closing the
+// least recently used one to make room. Handles are shared by concurrent
+// readers and reference counted, so a handle that is evicted while in use is
+// closed by its last reader.
+type handlePool struct {
+	mutex   sync.Mutex
+	handles map[string]*list.Element // Of *poolHandle, by path.
+	lru     list.List                // Most recently used first.
+}
+
+// poolHandle is an open file in a handlePool.
+type poolHandle struct {
+	path    string
+	file    *os.File
+	readers int
+	evicted bool // Closed by the last reader instead of the pool.
+}
+
+// acquire returns an open handle for path, which must be released.
+func (p *handlePool) acquire(path string) (*poolHandle, error) {
+	p.mutex.Lock()
+	if element, ok := p.handles[path]; ok {
+		handle := element.Value.(*poolHandle)
+		handle.readers++
+		p.lru.MoveToFront(element)
+		p.mutex.Unlock()
+		return handle, nil
+	}
+	p.mutex.Unlock()
+
+	// Open outside the lock, so that slow opens do not hold up reads of
+	// other files. Two readers may race to open the same file; the loser
+	// closes its copy.
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if element, ok := p.handles[path]; ok {
+		file.Close()
+		handle := element.Value.(*poolHandle)
+		handle.readers++
+		p.lru.MoveToFront(element)
+		return handle, nil
+	}
+	if p.handles == nil {
+		p.handles = make(map[string]*list.Element)
+	}
+	handle := &poolHandle{path: path, file: file, readers: 1}
+	p.handles[path] = p.lru.PushFront(handle)
+	for p.lru.Len() > maxOpenHandles {
+		p.remove(p.lru.Back())
+	}
+	return handle, nil
+}
+
+// release ends a read through handle.
+func (p *handlePool) release(handle *poolHandle) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	handle.readers--
+	if handle.evicted && handle.readers == 0 {
+		handle.file.Close()
+	}
+}
+
+// evict closes the handle for path, if one is open.
+func (p *handlePool) evict(path string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if element, ok := p.handles[path]; ok {
+		p.remove(element)
+	}
+}
+
+// remove takes a handle out of the pool and closes it, or leaves that to its
+// last reader. It must be called with the mutex held.
+func (p *handlePool) remove(element *list.Element) error {
+	handle := p.lru.Remove(element).(*poolHandle)
+	delete(p.handles, handle.path)
+	if handle.readers > 0 {
+		handle.evicted = true
+		return nil
+	}
+	return handle.file.Close()
+}
+
+// closeAll closes every handle in the pool.
+func (p *handlePool) closeAll() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	var errs []error
+	for p.lru.Len() > 0 {
+		errs = append(errs, p.remove(p.lru.Back()))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+)
+
+// MemoryBackend keeps a repository entirely in memory. It is primarily a test
+// double for code that works against a Backend, but it is also handy for
+// throwaway repositories.
+type MemoryBackend struct {
+	mutex sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryBackend creates an empty in-memory Backend.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{files: make(map[string][]byte)}
+}
+
+// Put stores a copy of data under name.
+func (b *MemoryBackend) Put(name string, data []byte) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get returns a copy of the named file.
+func (b *MemoryBackend) Get(name string) ([]byte, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	data, exists := b.files[name]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// GetRange returns a copy of part of the named file.
+func (b *MemoryBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	data, exists := b.files[name]
+	if !exists {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	if offset < 0 || length < 0 || offset+length > int64(len(data)) {
+		return nil, fmt.Errorf("%s: range %d+%d out of bounds: %w", name, offset, length, io.ErrUnexpectedEOF)
+	}
+	return append([]byte(nil), data[offset:offset+length]...), nil
+}
+
+// List returns the files directly inside dir.
+func (b *MemoryBackend) List(dir string) ([]BackendEntry, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	entries := []BackendEntry{}
+	for name, data := range b.files {
+		if path.Dir(name) == path.Clean(dir) {
+			entries = append(entries, BackendEntry{Name: path.Base(name), Size: int64(len(data))})
+		}
+	}
+	return entries, nil
+}
+
+// Delete removes the named file.
+func (b *MemoryBackend) Delete(name string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if _, exists := b.files[name]; !exists {
+		return fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	delete(b.files, name)
+	return nil
+}
+
+// Rename moves the named file.
+func (b *MemoryBackend) Rename(oldName, newName string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	data, exists := b.files[oldName]
+	if !exists {
+		return fmt.Errorf("%s: %w", oldName, fs.ErrNotExist)
+	}
+	delete(b.files, oldName)
+	b.files[newName] = data
+	return nil
+}
+
+// Version returns the hash of the named file's contents.
+func (b *MemoryBackend) Version(name string) (string, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	data, exists := b.files[name]
+	if !exists {
+		return "", fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return GetHash(data), nil
+}
+
+// Location describes the backend. In-memory repositories have no address.
+func (b *MemoryBackend) Location() string {
+	return "memory"
+}
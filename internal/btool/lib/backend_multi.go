@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DestinationStatus summarizes the writes made to one destination of a
+// MultiBackend.
+type DestinationStatus struct {
+	Location string
+	Writes   int   // The number of writes attempted.
+	Failures int   // The number of those writes that failed.
+	Err      error // The first failure, if any.
+}
+
+// MultiBackend replicates a repository to several destinations. Writes fan out
+// to every destination in parallel and succeed as long as at least one of them
+// does, so an unreachable mirror does not stop a backup; failures are recorded
+// per destination and reported through Status. Reads are served by the first
+// destination, in order, that can answer them.
+type MultiBackend struct {
+	backends []Backend
+	mutex    sync.Mutex
+	status   []DestinationStatus
+}
+
+// NewMultiBackend replicates writes to all backends. The first backend is the
+// primary and is preferred for reads.
+func NewMultiBackend(backends ...Backend) *MultiBackend {
+	status := make([]DestinationStatus, len(backends))
+	for i, backend := range backends {
+		status[i].Location = backend.Location()
+	}
+	return &MultiBackend{backends: backends, status: status}
+}
+
+// Backends returns the destinations, primary first.
+func (b *MultiBackend) Backends() []Backend {
+	return b.backends
+}
+
+// Status returns a snapshot of the writes made to each destination so far.
+func (b *MultiBackend) Status() []DestinationStatus {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]DestinationStatus(nil), b.status...)
+}
+
+// writeAll runs op against every destination in parallel and records the
+// outcomes. It fails only if every destination failed. Missing files are not
+// counted as failures, so deleting a file that one mirror never received works.
+func (b *MultiBackend) writeAll(op func(Backend) error) error {
+	errs := make([]error, len(b.backends))
+	var wg sync.WaitGroup
+	for i, backend := range b.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = op(backend)
+		}()
+	}
+	wg.Wait()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	succeeded := false
+	for i, err := range errs {
+		b.status[i].Writes++
+		switch {
+		case err == nil:
+			succeeded = true
+		case errors.Is(err, fs.ErrNotExist):
+		default:
+			b.status[i].Failures++
+			if b.status[i].Err == nil {
+				b.status[i].Err = err
+			}
+		}
+	}
+	if succeeded {
+		return nil
+	}
+	return b.combineErrors(errs)
+}
+
+// combineErrors merges the errors from every destination into one. If every
+// destination reported the file missing, the result is fs.ErrNotExist.
+func (b *MultiBackend) combineErrors(errs []error) error {
+	var messages []string
+	allMissing := true
+	for i, err := range errs {
+		messages = append(messages, fmt.Sprintf("%s: %v", b.backends[i].Location(), err))
+		allMissing = allMissing && errors.Is(err, fs.ErrNotExist)
+	}
+	message := "all destinations failed: " + strings.Join(messages, "; ")
+	if allMissing {
+		return fmt.Errorf("%s: %w", message, fs.ErrNotExist)
+	}
+	return errors.New(message)
+}
+
+// readFirst runs op against each destination in order until one succeeds.
+func (b *MultiBackend) readFirst(op func(Backend) error) error {
+	errs := make([]error, len(b.backends))
+	for i, backend := range b.backends {
+		if errs[i] = op(backend); errs[i] == nil {
+			return nil
+		}
+	}
+	return b.combineErrors(errs)
+}
+
+// Put stores data in every destination.
+func (b *MultiBackend) Put(name string, data []byte) error {
+	return b.writeAll(func(backend Backend) error {
+		return backend.Put(name, data)
+	})
+}
+
+// Get reads the named file from the first destination that has it.
+func (b *MultiBackend) Get(name string) ([]byte, error) {
+	var data []byte
+	err := b.readFirst(func(backend Backend) (err error) {
+		data, err = backend.Get(name)
+		return err
+	})
+	return data, err
+}
+
+// GetRange reads part of the named file from the first destination that has it.
+func (b *MultiBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	var data []byte
+	err := b.readFirst(func(backend Backend) (err error) {
+		data, err = backend.GetRange(name, offset, length)
+		return err
+	})
+	return data, err
+}
+
+// List returns the union of the files inside dir across all destinations that
+// could be listed, so that files missing from the primary are still found.
+func (b *MultiBackend) List(dir string) ([]BackendEntry, error) {
+	seen := make(map[string]BackendEntry)
+	errs := make([]error, len(b.backends))
+	listed := false
+	for i, backend := range b.backends {
+		entries, err := backend.List(dir)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		listed = true
+		for _, entry := range entries {
+			if _, exists := seen[entry.Name]; !exists {
+				seen[entry.Name] = entry
+			}
+		}
+	}
+	if !listed {
+		return nil, b.combineErrors(errs)
+	}
+
+	entries := make([]BackendEntry, 0, len(seen))
+	for _, entry := range seen {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Delete removes the named file from every destination.
+func (b *MultiBackend) Delete(name string) error {
+	return b.writeAll(func(backend Backend) error {
+		return backend.Delete(name)
+	})
+}
+
+// Rename moves the named file in every destination, with a rename where the
+// destination has one.
+func (b *MultiBackend) Rename(oldName, newName string) error {
+	return b.writeAll(func(backend Backend) error {
+		return renameFile(backend, oldName, newName)
+	})
+}
+
+// Version returns the version of the named file in the primary destination.
+func (b *MultiBackend) Version(name string) (string, error) {
+	return backendVersion(b.backends[0], name)
+}
+
+// Location lists the locations of all destinations.
+func (b *MultiBackend) Location() string {
+	locations := make([]string, len(b.backends))
+	for i, backend := range b.backends {
+		locations[i] = backend.Location()
+	}
+	return strings.Join(locations, ", ")
+}
+
+// Close closes every destination that holds resources.
+func (b *MultiBackend) Close() error {
+	var errs []error
+	for _, backend := range b.backends {
+		if closer, ok := backend.(io.Closer); ok {
+			errs = append(errs, closer.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// rclone exit codes that indicate a missing directory or file.
+// See https://rclone.org/docs/#exit-code.
+const (
+	rcloneExitDirNotFound  = 3
+	rcloneExitFileNotFound = 4
+)
+
+// RcloneBackend stores a repository on any remote configured in rclone, such
+// as Dropbox, OneDrive, or Google Drive. Every operation runs the rclone
+// binary, so remotes, credentials, and flags come from the user's rclone config.
+type RcloneBackend struct {
+	remote  string   // The rclone path, e.g. "dropbox:backups/laptop".
+	command []string // The rclone executable and any leading arguments.
+}
+
+// NewRcloneBackend creates a Backend for an rclone:remote:path location.
+func NewRcloneBackend(location string) (*RcloneBackend, error) {
+	remote := strings.TrimPrefix(location, "rclone:")
+	if remote == "" || !strings.Contains(remote, ":") {
+		return nil, fmt.Errorf("invalid rclone location %q: expected rclone:remote:path", location)
+	}
+	if _, err := exec.LookPath("rclone"); err != nil {
+		return nil, fmt.Errorf("rclone location %q requires the rclone binary: %w", location, err)
+	}
+	return &RcloneBackend{remote: strings.TrimSuffix(remote, "/"), command: []string{"rclone"}}, nil
+}
+
+// remotePath converts a backend name into an rclone path.
+func (b *RcloneBackend) remotePath(name string) string {
+	remoteName, remoteDir, _ := strings.Cut(b.remote, ":")
+	return remoteName + ":" + path.Join(remoteDir, name)
+}
+
+// run executes rclone with the given arguments, feeding stdin if provided,
+// and returns its standard output.
+func (b *RcloneBackend) run(name string, stdin []byte, args ...string) ([]byte, error) {
+	cmdArgs := append(append([]string{}, b.command[1:]...), args...)
+	cmd := exec.Command(b.command[0], cmdArgs...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			switch exitErr.ExitCode() {
+			case rcloneExitDirNotFound, rcloneExitFileNotFound:
+				return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+			}
+		}
+		return nil, fmt.Errorf("rclone %s %s: %w: %s", args[0], name, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Put streams data into the remote file with `rclone rcat`. rclone uploads to a
+// temporary name and renames on backends that support it.
+func (b *RcloneBackend) Put(name string, data []byte) error {
+	_, err := b.run(name, data, "rcat", b.remotePath(name))
+	return err
+}
+
+// Get reads the remote file with `rclone cat`.
+func (b *RcloneBackend) Get(name string) ([]byte, error) {
+	return b.run(name, nil, "cat", b.remotePath(name))
+}
+
+// GetRange reads part of the remote file with `rclone cat --offset --count`.
+func (b *RcloneBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	data, err := b.run(name, nil, "cat",
+		"--offset", strconv.FormatInt(offset, 10),
+		"--count", strconv.FormatInt(length, 10),
+		b.remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("rclone %s: short read at offset %d", name, offset)
+	}
+	return data, nil
+}
+
+// rcloneListEntry is the subset of `rclone lsjson` output that we use.
+type rcloneListEntry struct {
+	Name  string `json:"Name"`
+	Size  int64  `json:"Size"`
+	IsDir bool   `json:"IsDir"`
+}
+
+// List returns the files directly inside dir using `rclone lsjson`.
+func (b *RcloneBackend) List(dir string) ([]BackendEntry, error) {
+	output, err := b.run(dir, nil, "lsjson", "--files-only", b.remotePath(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []BackendEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []rcloneListEntry
+	if err := json.Unmarshal(output, &listed); err != nil {
+		return nil, fmt.Errorf("could not parse rclone listing of %s: %w", dir, err)
+	}
+
+	entries := []BackendEntry{}
+	for _, entry := range listed {
+		if !entry.IsDir {
+			entries = append(entries, BackendEntry{Name: entry.Name, Size: entry.Size})
+		}
+	}
+	return entries, nil
+}
+
+// Delete removes the remote file with `rclone deletefile`.
+func (b *RcloneBackend) Delete(name string) error {
+	_, err := b.run(name, nil, "deletefile", b.remotePath(name))
+	return err
+}
+
+// Rename moves the remote file with `rclone moveto`, which uses a server-side
+// move or copy where the remote supports one.
+func (b *RcloneBackend) Rename(oldName, newName string) error {
+	_, err := b.run(oldName, nil, "moveto", b.remotePath(oldName), b.remotePath(newName))
+	return err
+}
+
+// Location returns the rclone: location of the repository.
+func (b *RcloneBackend) Location() string {
+	return "rclone:" + b.remote
+}
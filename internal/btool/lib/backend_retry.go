@@ -0,0 +1,158 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"time"
+)
+
+// Bounds for the exponential backoff between retries of a failed operation.
+const (
+	retryBaseDelay = 250 * time.Millisecond
+	retryMaxDelay  = 15 * time.Second
+)
+
+// RetryBackend wraps another Backend and retries failed operations with
+// exponential backoff and jitter, so a transient network error or a briefly
+// locked file does not abort a whole snap or restore.
+type RetryBackend struct {
+	backend Backend
+	retries int
+	sleep   func(time.Duration) // Replaced in tests to avoid real delays.
+}
+
+// NewRetryBackend wraps backend so that each operation is attempted up to
+// retries additional times after its first failure.
+func NewRetryBackend(backend Backend, retries int) *RetryBackend {
+	return &RetryBackend{backend: backend, retries: retries, sleep: time.Sleep}
+}
+
+// isPermanentError reports whether retrying an operation cannot help.
+func isPermanentError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) ||
+		errors.Is(err, fs.ErrPermission) ||
+		errors.Is(err, errors.ErrUnsupported) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryDelay returns the backoff before retry number attempt (starting at 0):
+// the base delay doubled per attempt and capped, with the upper half randomized
+// so that concurrent workers do not retry in lockstep.
+func retryDelay(attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		delay = min(retryBaseDelay<<attempt, retryMaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// do runs op until it succeeds, fails permanently, or runs out of retries.
+func (b *RetryBackend) do(op func() error) error {
+	err := op()
+	for attempt := 0; attempt < b.retries && err != nil && !isPermanentError(err); attempt++ {
+		b.sleep(retryDelay(attempt))
+		err = op()
+	}
+	if err != nil && b.retries > 0 && !isPermanentError(err) {
+		return fmt.Errorf("%w (gave up after %d attempts)", err, b.retries+1)
+	}
+	return err
+}
+
+// Put stores data, retrying on failure.
+func (b *RetryBackend) Put(name string, data []byte) error {
+	return b.do(func() error {
+		return b.backend.Put(name, data)
+	})
+}
+
+// Create starts a new file in dir. A streamed file cannot be written again,
+// so it is not retried; a file the wrapped backend cannot stream is buffered
+// and stored with Put, which is.
+func (b *RetryBackend) Create(dir string) (BackendWriter, error) {
+	streaming, ok := b.backend.(StreamingBackend)
+	if !ok {
+		return &bufferedWriter{backend: b}, nil
+	}
+	return streaming.Create(dir)
+}
+
+// Get reads the named file, retrying on failure.
+func (b *RetryBackend) Get(name string) ([]byte, error) {
+	var data []byte
+	err := b.do(func() (err error) {
+		data, err = b.backend.Get(name)
+		return err
+	})
+	return data, err
+}
+
+// GetRange reads part of the named file, retrying on failure.
+func (b *RetryBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	var data []byte
+	err := b.do(func() (err error) {
+		data, err = b.backend.GetRange(name, offset, length)
+		return err
+	})
+	return data, err
+}
+
+// List lists dir, retrying on failure.
+func (b *RetryBackend) List(dir string) ([]BackendEntry, error) {
+	var entries []BackendEntry
+	err := b.do(func() (err error) {
+		entries, err = b.backend.List(dir)
+		return err
+	})
+	return entries, err
+}
+
+// Delete removes the named file, retrying on failure.
+func (b *RetryBackend) Delete(name string) error {
+	return b.do(func() error {
+		return b.backend.Delete(name)
+	})
+}
+
+// Rename moves the named file, retrying on failure. A file the wrapped
+// backend cannot rename is copied with retried reads and writes.
+func (b *RetryBackend) Rename(oldName, newName string) error {
+	renaming, ok := b.backend.(RenamingBackend)
+	if !ok {
+		return copyAndDelete(b, oldName, newName)
+	}
+	return b.do(func() error {
+		return renaming.Rename(oldName, newName)
+	})
+}
+
+// Version returns the version of the named file, retrying on failure.
+func (b *RetryBackend) Version(name string) (string, error) {
+	var version string
+	err := b.do(func() (err error) {
+		version, err = backendVersion(b.backend, name)
+		return err
+	})
+	return version, err
+}
+
+// Unwrap returns the wrapped backend.
+func (b *RetryBackend) Unwrap() Backend {
+	return b.backend
+}
+
+// Location returns the location of the wrapped backend.
+func (b *RetryBackend) Location() string {
+	return b.backend.Location()
+}
+
+// Close closes the wrapped backend if it holds any resources.
+func (b *RetryBackend) Close() error {
+	if closer, ok := b.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,435 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultS3Region is used when neither AWS_REGION nor AWS_DEFAULT_REGION is set.
+const defaultS3Region = "us-east-1"
+
+// s3Credentials holds the static credentials used to sign S3 requests.
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3Backend stores a repository in an S3-compatible bucket under a key prefix.
+// It speaks the S3 REST API directly and signs requests with AWS Signature
+// Version 4. Credentials come from the standard AWS environment variables or
+// the shared credentials file, and AWS_ENDPOINT_URL points the backend at a
+// non-AWS service such as MinIO.
+type S3Backend struct {
+	bucket   string
+	prefix   string
+	region   string
+	endpoint *url.URL
+	// pathStyle selects https://host/bucket/key addressing instead of
+	// https://bucket.host/key. S3-compatible services usually require it.
+	pathStyle bool
+	creds     s3Credentials
+	client    *http.Client
+	now       func() time.Time
+}
+
+// parseS3Location splits an s3://bucket/prefix URL into its bucket and prefix.
+func parseS3Location(location string) (bucket, prefix string, err error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid S3 location %q: %w", location, err)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("invalid S3 location %q: expected s3://bucket/prefix", location)
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// loadS3Credentials reads credentials from the environment, falling back to
+// the shared credentials file (~/.aws/credentials) for the selected profile.
+func loadS3Credentials() (s3Credentials, error) {
+	creds := s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID != "" && creds.SecretAccessKey != "" {
+		return creds, nil
+	}
+
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return s3Credentials{}, fmt.Errorf("no AWS credentials found in the environment: %w", err)
+		}
+		credentialsFile = filepath.Join(home, ".aws", "credentials")
+	}
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(credentialsFile)
+	if err != nil {
+		return s3Credentials{}, fmt.Errorf("no AWS credentials found in the environment or %s: %w", credentialsFile, err)
+	}
+	defer file.Close()
+
+	// The credentials file is a simple INI file; we only need three keys.
+	creds = s3Credentials{}
+	inProfile := false
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inProfile = strings.TrimSpace(line[1:len(line)-1]) == profile
+			continue
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !inProfile || !found {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = strings.TrimSpace(value)
+		case "aws_session_token":
+			creds.SessionToken = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return s3Credentials{}, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return s3Credentials{}, fmt.Errorf("no credentials for profile %q in %s", profile, credentialsFile)
+	}
+	return creds, nil
+}
+
+// NewS3Backend creates a Backend for an s3://bucket/prefix location.
+func NewS3Backend(location string) (*S3Backend, error) {
+	bucket, prefix, err := parseS3Location(location)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := loadS3Credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	backend := &S3Backend{
+		bucket: bucket,
+		prefix: prefix,
+		region: region,
+		creds:  creds,
+		client: &http.Client{Timeout: 5 * time.Minute},
+		now:    time.Now,
+	}
+
+	if rawEndpoint := os.Getenv("AWS_ENDPOINT_URL"); rawEndpoint != "" {
+		endpoint, err := url.Parse(rawEndpoint)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL %q", rawEndpoint)
+		}
+		backend.endpoint = endpoint
+		backend.pathStyle = true
+	} else {
+		backend.endpoint = &url.URL{Scheme: "https", Host: "s3." + region + ".amazonaws.com"}
+	}
+
+	return backend, nil
+}
+
+// key converts a backend name into an object key under the configured prefix.
+func (b *S3Backend) key(name string) string {
+	if b.prefix == "" {
+		return name
+	}
+	return path.Join(b.prefix, name)
+}
+
+// objectURL builds the request URL for an object key (or the bucket itself
+// when key is empty) with the given query parameters.
+func (b *S3Backend) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	if b.pathStyle {
+		u.Path = "/" + b.bucket + "/" + key
+	} else {
+		u.Host = b.bucket + "." + u.Host
+		u.Path = "/" + key
+	}
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do signs and sends a request, returning the response if its status is one
+// of the expected codes. A 404 is translated into fs.ErrNotExist.
+func (b *S3Backend) do(method, name string, u *url.URL, body []byte, header http.Header, expected ...int) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	b.sign(req, body)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 %s %s: %w", method, name, err)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	var s3Err struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if xml.Unmarshal(responseBody, &s3Err) == nil && s3Err.Code != "" {
+		return nil, fmt.Errorf("s3 %s %s: %s: %s", method, name, s3Err.Code, s3Err.Message)
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s", method, name, resp.Status)
+}
+
+// sign adds AWS Signature Version 4 headers to the request.
+func (b *S3Backend) sign(req *http.Request, body []byte) {
+	now := b.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	shortDate := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("Host", req.URL.Host)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.creds.SessionToken)
+	}
+
+	// 1. Build the canonical request from the signed headers.
+	var headerNames []string
+	for name := range req.Header {
+		headerNames = append(headerNames, strings.ToLower(name))
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQueryString(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	// 2. Build the string to sign and derive the signing key.
+	scope := shortDate + "/" + b.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSHA256([]byte("AWS4"+b.creds.SecretAccessKey), shortDate)
+	signingKey = hmacSHA256(signingKey, b.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		b.creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalQueryString encodes query parameters as required by SigV4: sorted
+// by key and escaped with %20 for spaces rather than "+".
+func canonicalQueryString(query url.Values) string {
+	var keys []string
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape percent-encodes a query component the way SigV4 expects.
+func s3Escape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Put uploads data as a single object.
+func (b *S3Backend) Put(name string, data []byte) error {
+	resp, err := b.do(http.MethodPut, name, b.objectURL(b.key(name), nil), data, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get downloads the full contents of an object.
+func (b *S3Backend) Get(name string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, name, b.objectURL(b.key(name), nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// GetRange downloads length bytes of an object using an HTTP range request.
+func (b *S3Backend) GetRange(name string, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		return []byte{}, nil
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.do(http.MethodGet, name, b.objectURL(b.key(name), nil), nil, header, http.StatusPartialContent)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != length {
+		return nil, fmt.Errorf("s3 %s: short read at offset %d: %w", name, offset, io.ErrUnexpectedEOF)
+	}
+	return data, nil
+}
+
+// s3ListResult is the subset of a ListObjectsV2 response that we use.
+type s3ListResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the objects directly under dir, following pagination.
+func (b *S3Backend) List(dir string) ([]BackendEntry, error) {
+	listPrefix := b.key(dir) + "/"
+	entries := []BackendEntry{}
+	continuationToken := ""
+
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", listPrefix)
+		query.Set("delimiter", "/")
+		if continuationToken != "" {
+			query.Set("continuation-token", continuationToken)
+		}
+
+		resp, err := b.do(http.MethodGet, dir, b.objectURL("", query), nil, nil, http.StatusOK)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not parse S3 listing of %s: %w", dir, err)
+		}
+
+		for _, object := range result.Contents {
+			name := strings.TrimPrefix(object.Key, listPrefix)
+			if name != "" {
+				entries = append(entries, BackendEntry{Name: name, Size: object.Size})
+			}
+		}
+		if !result.IsTruncated {
+			return entries, nil
+		}
+		continuationToken = result.NextContinuationToken
+	}
+}
+
+// Delete removes an object. S3 does not report missing keys on delete, so we
+// check for existence first to honor the Backend contract.
+func (b *S3Backend) Delete(name string) error {
+	objectURL := b.objectURL(b.key(name), nil)
+	resp, err := b.do(http.MethodHead, name, objectURL, nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	resp, err = b.do(http.MethodDelete, name, objectURL, nil, nil, http.StatusNoContent, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Version returns the ETag of an object.
+func (b *S3Backend) Version(name string) (string, error) {
+	resp, err := b.do(http.MethodHead, name, b.objectURL(b.key(name), nil), nil, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Location returns the s3:// URL of the repository.
+func (b *S3Backend) Location() string {
+	return "s3://" + path.Join(b.bucket, b.prefix)
+}
@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTPBackend stores a repository on a remote host over SFTP. It runs the
+// system ssh client as a subprocess, so host keys, agents, jump hosts, and
+// other settings from ~/.ssh/config work exactly as they do for ssh itself.
+// A single SFTP session is opened per backend and shared by all workers.
+type SFTPBackend struct {
+	client *sftp.Client
+	cmd    *exec.Cmd
+	host   string
+	root   string
+}
+
+// parseSFTPLocation splits an sftp://[user@]host[:port]/path URL into the ssh
+// destination, port, and remote directory.
+func parseSFTPLocation(location string) (destination, port, root string, err error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid SFTP location %q: %w", location, err)
+	}
+	if u.Scheme != "sftp" || u.Hostname() == "" {
+		return "", "", "", fmt.Errorf("invalid SFTP location %q: expected sftp://user@host/path", location)
+	}
+
+	destination = u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		destination = u.User.Username() + "@" + destination
+	}
+
+	// A leading "/~/" selects a path relative to the remote home directory.
+	root = u.Path
+	if strings.HasPrefix(root, "/~/") {
+		root = root[len("/~/"):]
+	}
+	root = strings.TrimSuffix(root, "/")
+	if root == "" {
+		root = "."
+	}
+	return destination, u.Port(), root, nil
+}
+
+// NewSFTPBackend connects to an sftp://[user@]host[:port]/path location.
+func NewSFTPBackend(location string) (*SFTPBackend, error) {
+	destination, port, root, err := parseSFTPLocation(location)
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	if port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, destination, "-s", "sftp")
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start ssh: %w", err)
+	}
+
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, fmt.Errorf("failed to start SFTP session with %s: %w", destination, err)
+	}
+
+	return &SFTPBackend{client: client, cmd: cmd, host: destination, root: root}, nil
+}
+
+// remotePath converts a backend name into a path on the remote host.
+func (b *SFTPBackend) remotePath(name string) string {
+	return path.Join(b.root, name)
+}
+
+// Put uploads data to a temporary file and renames it into place.
+func (b *SFTPBackend) Put(name string, data []byte) error {
+	finalPath := b.remotePath(name)
+	if err := b.client.MkdirAll(path.Dir(finalPath)); err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	tmpPath := path.Join(path.Dir(finalPath), ".tmp-"+path.Base(finalPath)+"-"+hex.EncodeToString(suffix))
+	file, err := b.client.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		_ = b.client.Remove(tmpPath)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		_ = b.client.Remove(tmpPath)
+		return err
+	}
+	// PosixRename overwrites an existing target atomically where the server
+	// supports it; plain SFTP rename refuses to replace existing files.
+	if err := b.client.PosixRename(tmpPath, finalPath); err != nil {
+		_ = b.client.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// Get downloads the full contents of the named file.
+func (b *SFTPBackend) Get(name string) ([]byte, error) {
+	file, err := b.client.Open(b.remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// GetRange downloads length bytes of the named file starting at offset.
+func (b *SFTPBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	file, err := b.client.Open(b.remotePath(name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, length)
+	if _, err := file.ReadAt(buffer, offset); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// List returns the regular files directly inside dir.
+func (b *SFTPBackend) List(dir string) ([]BackendEntry, error) {
+	infos, err := b.client.ReadDir(b.remotePath(dir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []BackendEntry{}, nil
+		}
+		return nil, err
+	}
+
+	entries := []BackendEntry{}
+	for _, info := range infos {
+		if info.IsDir() || isLocalTempFile(info.Name()) {
+			continue
+		}
+		entries = append(entries, BackendEntry{Name: info.Name(), Size: info.Size()})
+	}
+	return entries, nil
+}
+
+// Delete removes the named file.
+func (b *SFTPBackend) Delete(name string) error {
+	return b.client.Remove(b.remotePath(name))
+}
+
+// Rename moves the named file with a rename on the remote host.
+func (b *SFTPBackend) Rename(oldName, newName string) error {
+	newPath := b.remotePath(newName)
+	if err := b.client.MkdirAll(path.Dir(newPath)); err != nil {
+		return err
+	}
+	return b.client.PosixRename(b.remotePath(oldName), newPath)
+}
+
+// Version returns the modification time and size of the named file.
+func (b *SFTPBackend) Version(name string) (string, error) {
+	info, err := b.client.Stat(b.remotePath(name))
+	if err != nil {
+		return "", err
+	}
+	return statVersion(info), nil
+}
+
+// Location returns the sftp:// URL of the repository.
+func (b *SFTPBackend) Location() string {
+	if path.IsAbs(b.root) {
+		return "sftp://" + b.host + b.root
+	}
+	if b.root == "." {
+		return "sftp://" + b.host + "/~/"
+	}
+	return "sftp://" + b.host + "/~/" + b.root
+}
+
+// Close ends the SFTP session and waits for the ssh process to exit.
+func (b *SFTPBackend) Close() error {
+	err := b.client.Close()
+	if waitErr := b.cmd.Wait(); err == nil && waitErr != nil {
+		// ssh exits non-zero when its session is torn down; that is expected.
+		if _, ok := waitErr.(*exec.ExitError); !ok {
+			err = waitErr
+		}
+	}
+	return err
+}
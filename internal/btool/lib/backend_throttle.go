@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter paces transfers to an average number of bytes per second. It
+// tracks the time at which the bandwidth already handed out is used up, and
+// makes each caller wait until its own share of that time has passed. It is
+// safe for concurrent use, so all workers share a single limit.
+type rateLimiter struct {
+	bytesPerSecond int64
+	mutex          sync.Mutex
+	next           time.Time
+	now            func() time.Time    // Replaced in tests.
+	sleep          func(time.Duration) // Replaced in tests.
+}
+
+// newRateLimiter returns a limiter for the given rate, or nil if the rate is
+// not positive, meaning unlimited.
+func newRateLimiter(bytesPerSecond int64) *rateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSecond: bytesPerSecond, now: time.Now, sleep: time.Sleep}
+}
+
+// wait blocks until transferring n bytes fits within the rate. A nil limiter
+// never blocks.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+
+	l.mutex.Lock()
+	now := l.now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(float64(n) / float64(l.bytesPerSecond) * float64(time.Second)))
+	delay := l.next.Sub(now)
+	l.mutex.Unlock()
+
+	l.sleep(delay)
+}
+
+// ThrottledBackend wraps another Backend and limits the bandwidth used by
+// uploads (Put and Create) and downloads (Get and GetRange).
+type ThrottledBackend struct {
+	backend  Backend
+	upload   *rateLimiter
+	download *rateLimiter
+}
+
+// NewThrottledBackend wraps backend with upload and download limits in bytes
+// per second. A limit of zero leaves that direction unlimited.
+func NewThrottledBackend(backend Backend, uploadBytesPerSecond, downloadBytesPerSecond int64) *ThrottledBackend {
+	return &ThrottledBackend{
+		backend:  backend,
+		upload:   newRateLimiter(uploadBytesPerSecond),
+		download: newRateLimiter(downloadBytesPerSecond),
+	}
+}
+
+// Put waits for upload bandwidth, then stores data.
+func (b *ThrottledBackend) Put(name string, data []byte) error {
+	b.upload.wait(len(data))
+	return b.backend.Put(name, data)
+}
+
+// Create starts a new file in dir whose writes wait for upload bandwidth.
+func (b *ThrottledBackend) Create(dir string) (BackendWriter, error) {
+	writer, err := createFile(b.backend, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &throttledWriter{BackendWriter: writer, upload: b.upload}, nil
+}
+
+// throttledWriter is a file being written to a ThrottledBackend.
+type throttledWriter struct {
+	BackendWriter
+	upload *rateLimiter
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	w.upload.wait(len(p))
+	return w.BackendWriter.Write(p)
+}
+
+// Get reads the named file, then waits until its size fits the download limit.
+func (b *ThrottledBackend) Get(name string) ([]byte, error) {
+	data, err := b.backend.Get(name)
+	b.download.wait(len(data))
+	return data, err
+}
+
+// GetRange reads part of the named file, then waits until its size fits the
+// download limit.
+func (b *ThrottledBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	data, err := b.backend.GetRange(name, offset, length)
+	b.download.wait(len(data))
+	return data, err
+}
+
+// List lists dir without throttling; listings are small.
+func (b *ThrottledBackend) List(dir string) ([]BackendEntry, error) {
+	return b.backend.List(dir)
+}
+
+// Delete removes the named file without throttling.
+func (b *ThrottledBackend) Delete(name string) error {
+	return b.backend.Delete(name)
+}
+
+// Rename moves the named file without throttling if the wrapped backend can
+// rename it, and otherwise copies it within the limits.
+func (b *ThrottledBackend) Rename(oldName, newName string) error {
+	renaming, ok := b.backend.(RenamingBackend)
+	if !ok {
+		return copyAndDelete(b, oldName, newName)
+	}
+	return renaming.Rename(oldName, newName)
+}
+
+// Version returns the version of the named file from the wrapped backend.
+func (b *ThrottledBackend) Version(name string) (string, error) {
+	return backendVersion(b.backend, name)
+}
+
+// Unwrap returns the wrapped backend.
+func (b *ThrottledBackend) Unwrap() Backend {
+	return b.backend
+}
+
+// Location returns the location of the wrapped backend.
+func (b *ThrottledBackend) Location() string {
+	return b.backend.Location()
+}
+
+// Close closes the wrapped backend if it holds any resources.
+func (b *ThrottledBackend) Close() error {
+	if closer, ok := b.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
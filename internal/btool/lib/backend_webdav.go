@@ -0,0 +1,313 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Environment variables holding the WebDAV credentials, used when the
+// location does not include them.
+const (
+	WebDAVUserEnvVar     = "BTOOL_WEBDAV_USER"
+	WebDAVPasswordEnvVar = "BTOOL_WEBDAV_PASSWORD"
+)
+
+// WebDAVBackend stores a repository on a WebDAV share such as Nextcloud or
+// ownCloud. Locations use the dav:// (plain HTTP) or davs:// (HTTPS) schemes.
+// Credentials come from the URL's user info or from the environment.
+type WebDAVBackend struct {
+	location string // The dav:// or davs:// location, without credentials.
+	baseURL  *url.URL
+	username string
+	password string
+	client   *http.Client
+}
+
+// NewWebDAVBackend creates a Backend for a dav:// or davs:// location.
+func NewWebDAVBackend(location string) (*WebDAVBackend, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebDAV location %q: %w", location, err)
+	}
+	switch u.Scheme {
+	case "dav":
+		u.Scheme = "http"
+	case "davs":
+		u.Scheme = "https"
+	default:
+		return nil, fmt.Errorf("invalid WebDAV location %q: expected dav:// or davs://", location)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid WebDAV location %q: missing host", location)
+	}
+
+	backend := &WebDAVBackend{
+		username: os.Getenv(WebDAVUserEnvVar),
+		password: os.Getenv(WebDAVPasswordEnvVar),
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}
+	if u.User != nil {
+		backend.username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			backend.password = password
+		}
+		u.User = nil
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	backend.baseURL = u
+
+	davURL := *u
+	davURL.Scheme = strings.Replace(u.Scheme, "http", "dav", 1)
+	backend.location = davURL.String()
+	return backend, nil
+}
+
+// fileURL returns the absolute URL of a backend name.
+func (b *WebDAVBackend) fileURL(name string) string {
+	u := *b.baseURL
+	u.Path = path.Join(u.Path, name)
+	return u.String()
+}
+
+// do sends a WebDAV request to target and returns the response if its status
+// is one of the expected codes. A 404 is translated into fs.ErrNotExist.
+func (b *WebDAVBackend) do(method, target string, body []byte, header http.Header, expected ...int) (*http.Response, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, target, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if b.username != "" {
+		req.SetBasicAuth(b.username, b.password)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return resp, nil
+		}
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%s: %w", target, fs.ErrNotExist)
+	}
+	return nil, fmt.Errorf("WebDAV %s %s: %s", method, target, resp.Status)
+}
+
+// mkcolAll creates the collection at the server path dirPath along with any
+// missing parents. WebDAV has no recursive MKCOL: creating a collection whose
+// parent is missing fails with 409 Conflict, so parents are created on demand.
+// A 405 means the collection already exists.
+func (b *WebDAVBackend) mkcolAll(dirPath string) error {
+	u := *b.baseURL
+	u.Path = strings.TrimSuffix(dirPath, "/") + "/"
+	resp, err := b.do("MKCOL", u.String(), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		return nil
+	}
+
+	parent := path.Dir(strings.TrimSuffix(dirPath, "/"))
+	if parent == dirPath || parent == "/" || parent == "." {
+		return fmt.Errorf("WebDAV MKCOL %s: %s", u.String(), resp.Status)
+	}
+	if err := b.mkcolAll(parent); err != nil {
+		return err
+	}
+	resp, err = b.do("MKCOL", u.String(), nil, nil, http.StatusCreated, http.StatusMethodNotAllowed)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Put uploads data, creating parent collections as needed.
+func (b *WebDAVBackend) Put(name string, data []byte) error {
+	resp, err := b.do(http.MethodPut, b.fileURL(name), data, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent, http.StatusConflict, http.StatusNotFound)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict && resp.StatusCode != http.StatusNotFound {
+		return nil
+	}
+
+	// The parent collection does not exist yet. Servers report this as 409
+	// Conflict (as RFC 4918 specifies) or 404 Not Found.
+	if err := b.mkcolAll(path.Dir(path.Join(b.baseURL.Path, name))); err != nil {
+		return err
+	}
+	resp, err = b.do(http.MethodPut, b.fileURL(name), data, nil, http.StatusOK, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Get downloads the full contents of a file.
+func (b *WebDAVBackend) Get(name string) ([]byte, error) {
+	resp, err := b.do(http.MethodGet, b.fileURL(name), nil, nil, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// GetRange downloads part of a file with a ranged GET. Servers that ignore
+// the Range header and return the whole file are handled by slicing locally.
+func (b *WebDAVBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	if length == 0 {
+		return []byte{}, nil
+	}
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := b.do(http.MethodGet, b.fileURL(name), nil, header, http.StatusPartialContent, http.StatusOK)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusOK {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return nil, fmt.Errorf("WebDAV GET %s: short read at offset %d: %w", name, offset, io.ErrUnexpectedEOF)
+		}
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(resp.Body, data); err != nil {
+		return nil, fmt.Errorf("WebDAV GET %s: short read at offset %d: %w", name, offset, io.ErrUnexpectedEOF)
+	}
+	return data, nil
+}
+
+// propfindRequest asks only for the properties needed to list a directory.
+const propfindRequest = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/></d:prop></d:propfind>`
+
+// davMultistatus is the subset of a PROPFIND response that we parse.
+type davMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"href"`
+		Propstat []struct {
+			Prop struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"collection"`
+				} `xml:"resourcetype"`
+				ContentLength int64 `xml:"getcontentlength"`
+			} `xml:"prop"`
+			Status string `xml:"status"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// List returns the files directly inside dir using a depth-1 PROPFIND.
+func (b *WebDAVBackend) List(dir string) ([]BackendEntry, error) {
+	header := http.Header{}
+	header.Set("Depth", "1")
+	header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := b.do("PROPFIND", b.fileURL(dir)+"/", []byte(propfindRequest), header, http.StatusMultiStatus)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []BackendEntry{}, nil
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var multistatus davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&multistatus); err != nil {
+		return nil, fmt.Errorf("could not parse WebDAV listing of %s: %w", dir, err)
+	}
+
+	entries := []BackendEntry{}
+	for _, response := range multistatus.Responses {
+		href, err := url.PathUnescape(response.Href)
+		if err != nil {
+			continue
+		}
+		name := path.Base(strings.TrimSuffix(href, "/"))
+		for _, propstat := range response.Propstat {
+			if !strings.Contains(propstat.Status, " 200 ") || propstat.Prop.ResourceType.Collection != nil {
+				continue
+			}
+			entries = append(entries, BackendEntry{Name: name, Size: propstat.Prop.ContentLength})
+		}
+	}
+	return entries, nil
+}
+
+// Delete removes a file from the share.
+func (b *WebDAVBackend) Delete(name string) error {
+	resp, err := b.do(http.MethodDelete, b.fileURL(name), nil, nil, http.StatusOK, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Rename moves a file on the server with MOVE, creating the parent
+// collections of newName as needed.
+func (b *WebDAVBackend) Rename(oldName, newName string) error {
+	header := http.Header{"Destination": {b.fileURL(newName)}, "Overwrite": {"T"}}
+	resp, err := b.do("MOVE", b.fileURL(oldName), nil, header, http.StatusCreated, http.StatusNoContent, http.StatusForbidden, http.StatusConflict)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusConflict {
+		return nil
+	}
+
+	// The parent collection of newName may not exist yet. Servers report
+	// this as 409 Conflict (as RFC 4918 specifies) or 403 Forbidden, which
+	// some also answer when oldName is missing.
+	if _, err := b.Version(oldName); err != nil {
+		return err
+	}
+	if err := b.mkcolAll(path.Dir(path.Join(b.baseURL.Path, newName))); err != nil {
+		return err
+	}
+	resp, err = b.do("MOVE", b.fileURL(oldName), nil, header, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Version returns the ETag the server reports for a file.
+func (b *WebDAVBackend) Version(name string) (string, error) {
+	resp, err := b.do(http.MethodHead, b.fileURL(name), nil, nil, http.StatusOK)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// Location returns the dav:// or davs:// location without credentials.
+func (b *WebDAVBackend) Location() string {
+	return b.location
+}
@@ -0,0 +1,71 @@
+package lib
+
+import "hash/maphash"
+
+// A bloom filter with bloomBitsPerHash bits and bloomProbes probes per hash
+// reports about one in a hundred hashes it was never given as possibly
+// present, and never misses one it was.
+const (
+	bloomBitsPerHash = 10
+	bloomProbes      = 7
+	minBloomCapacity = 1024
+)
+
+// bloomFilter is a compact set of object hashes that answers "definitely
+// not present" or "possibly present". It lets writeObject skip looking up
+// new objects in the full index, which for large repositories is by far the
+// common case. Hashes cannot be removed, so one whose pack is deleted stays
+// possibly present, which only costs an index lookup.
+type bloomFilter struct {
+	bits     []uint64
+	count    int // Hashes added.
+	capacity int // Hashes the filter is sized for.
+	seeds    [2]maphash.Seed
+}
+
+// newBloomFilter returns an empty filter sized for capacity hashes.
+func newBloomFilter(capacity int) *bloomFilter {
+	capacity = max(capacity, minBloomCapacity)
+	words := (capacity*bloomBitsPerHash + 63) / 64
+	return &bloomFilter{
+		bits:     make([]uint64, words),
+		capacity: capacity,
+		seeds:    [2]maphash.Seed{maphash.MakeSeed(), maphash.MakeSeed()},
+	}
+}
+
+// full reports whether the filter holds as many hashes as it is sized for,
+// beyond which it gives more and more false positives.
+func (f *bloomFilter) full() bool {
+	return f.count >= f.capacity
+}
+
+// probes calls probe with each bit position of hash, derived from two base
+// hashes by double hashing.
+func (f *bloomFilter) probes(hash string, probe func(bit uint64) bool) bool {
+	h1 := maphash.String(f.seeds[0], hash)
+	h2 := maphash.String(f.seeds[1], hash) | 1
+	size := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomProbes; i++ {
+		if !probe((h1 + i*h2) % size) {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds hash to the filter.
+func (f *bloomFilter) add(hash string) {
+	f.probes(hash, func(bit uint64) bool {
+		f.bits[bit/64] |= 1 << (bit % 64)
+		return true
+	})
+	f.count++
+}
+
+// mayContain reports false if hash was certainly never added.
+func (f *bloomFilter) mayContain(hash string) bool {
+	return f.probes(hash, func(bit uint64) bool {
+		return f.bits[bit/64]&(1<<(bit%64)) != 0
+	})
+}
@@ -0,0 +1,45 @@
+package lib
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloomFilter(t *testing.T) {
+	t.Run("should report every hash it was given", func(t *testing.T) {
+		// Arrange
+		filter := newBloomFilter(5000)
+
+		// Act
+		for i := 0; i < 5000; i++ {
+			filter.add(GetHash([]byte(strconv.Itoa(i))))
+		}
+
+		// Assert
+		for i := 0; i < 5000; i++ {
+			assert.True(t, filter.mayContain(GetHash([]byte(strconv.Itoa(i)))), "Missed hash %d", i)
+		}
+		assert.True(t, filter.full())
+	})
+
+	t.Run("should rule out most hashes it was not given", func(t *testing.T) {
+		// Arrange
+		filter := newBloomFilter(5000)
+		for i := 0; i < 5000; i++ {
+			filter.add(GetHash([]byte(strconv.Itoa(i))))
+		}
+
+		// Act
+		falsePositives := 0
+		for i := 5000; i < 15000; i++ {
+			if filter.mayContain(GetHash([]byte(strconv.Itoa(i)))) {
+				falsePositives++
+			}
+		}
+
+		// Assert: about 1% is expected at capacity.
+		assert.Less(t, falsePositives, 300)
+	})
+}
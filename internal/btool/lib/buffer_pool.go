@@ -0,0 +1,46 @@
+package lib
+
+import "sync"
+
+// bufferPool recycles byte slices of at least one capacity, so that the hot
+// paths of snap and restore, which go through a buffer per chunk or per
+// file, do not leave the garbage collector to clean up after each of them.
+// Slices are handed around as pointers, which the pool holds without
+// allocating. It is safe for concurrent use.
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// newBufferPool returns a pool of slices of size bytes.
+func newBufferPool(size int) *bufferPool {
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	return p
+}
+
+// get returns a slice of the pool's size, whose contents are undefined.
+func (p *bufferPool) get() *[]byte {
+	buffer := p.pool.Get().(*[]byte)
+	*buffer = (*buffer)[:p.size]
+	return buffer
+}
+
+// put returns a slice from get to the pool. The caller must not use it
+// afterwards.
+func (p *bufferPool) put(buffer *[]byte) {
+	if cap(*buffer) < p.size {
+		return
+	}
+	p.pool.Put(buffer)
+}
+
+var (
+	// splitBuffers hold the data a chunker has read but not yet cut.
+	splitBuffers = newBufferPool(2 * maxChunkSize)
+	// objectBuffers hold pending objects up to a chunk in size.
+	objectBuffers = newBufferPool(maxChunkSize)
+)
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// CheckPack reads the pack with the given hash whole and checks that each
+// of the objects the index places in it lies within it, decodes, and hashes
+// to its ID, calling problem for each that does not. A pack that describes
+// its own objects must also hash to its name as a whole, or problem is
+// called with an empty hash. It fails only if the pack cannot be read at all.
+func (s *ObjectStore) CheckPack(packHash string, objects map[string]types.PackIndexEntry, problem func(hash string, err error)) error {
+	pack, err := s.backend.Get(packName(packHash))
+	if err != nil {
+		return err
+	}
+	// Packs written before they described themselves were not always named
+	// by the hash of their bytes.
+	if bytes.HasPrefix(pack, []byte(packMagic)) {
+		packHasher := s.hasher.newHash()
+		packHasher.Write(pack)
+		if actual := hex.EncodeToString(packHasher.Sum(nil)); actual != packHash {
+			problem("", fmt.Errorf("its bytes hash to %s, not to its name", actual))
+		}
+	}
+	for hash, entry := range objects {
+		if entry.Offset < 0 || entry.Length < 0 || entry.Offset+entry.Length > int64(len(pack)) {
+			problem(hash, fmt.Errorf("lies at %d-%d, past the end of the pack at %d", entry.Offset, entry.Offset+entry.Length, len(pack)))
+			continue
+		}
+		data, err := s.decodeObject(hash, entry, pack[entry.Offset:entry.Offset+entry.Length:entry.Offset+entry.Length])
+		if err != nil {
+			problem(hash, err)
+			continue
+		}
+		if actual := s.hasher.GetHash(data); actual != hash {
+			problem(hash, fmt.Errorf("content hashes to %s", actual))
+		}
+	}
+	return nil
+}
+
+// SalvagePack copies the objects of keep, which the index places in the pack
+// with the given hash, to a new pack as they are stored, then deletes the old
+// pack and its index shard, so that the index no longer lists the objects of
+// the old pack that were lost. The objects are neither decoded nor checked,
+// so keep must only hold those CheckPack found intact. It returns the hash of
+// the new pack, or an empty string if there was nothing to keep.
+func (s *ObjectStore) SalvagePack(packHash string, keep map[string]types.PackIndexEntry) (string, error) {
+	newPack := ""
+	if len(keep) > 0 {
+		pack, err := s.backend.Get(packName(packHash))
+		if err != nil {
+			return "", err
+		}
+		s.mutex.Lock()
+		newPack, err = s.copyObjects(pack, keep)
+		s.mutex.Unlock()
+		if err != nil {
+			return "", err
+		}
+		if newPack == packHash {
+			// Every byte of the pack was kept, so only its index was wrong,
+			// and the shard written for the copy replaced it.
+			return newPack, nil
+		}
+	}
+	if err := s.DeletePack(packHash); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return newPack, err
+	}
+	return newPack, nil
+}
+
+// copyObjects writes the objects of keep, which lie in pack, to a new pack
+// in the order they lay there, and adds them to the index. It returns the
+// hash of the new pack.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) copyObjects(pack []byte, keep map[string]types.PackIndexEntry) (string, error) {
+	hashes := make([]string, 0, len(keep))
+	for hash, entry := range keep {
+		if entry.Offset < 0 || entry.Length < 0 || entry.Offset+entry.Length > int64(len(pack)) {
+			return "", fmt.Errorf("object %s lies past the end of the pack", hash)
+		}
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return keep[hashes[i]].Offset < keep[hashes[j]].Offset
+	})
+
+	// The records of a pack that has them give the types of its objects.
+	records, _, _ := s.readRecords(pack)
+	builder, err := s.newPackBuilder()
+	if err != nil {
+		return "", err
+	}
+	defer builder.abort() // Does nothing once finished.
+	for _, hash := range hashes {
+		entry := keep[hash]
+		if err := builder.add(hash, records[hash].Type, pack[entry.Offset:entry.Offset+entry.Length], entry); err != nil {
+			return "", err
+		}
+	}
+	packHash, newEntries, err := builder.finish()
+	if err != nil {
+		return "", err
+	}
+	if err := s.writeShard(packHash, newEntries); err != nil {
+		return "", err
+	}
+
+	if err := s.loadIndex(); err != nil {
+		return "", err
+	}
+	for hash, entry := range s.packIndex {
+		if _, kept := newEntries[hash]; !kept && entry.PackHash == packHash {
+			delete(s.packIndex, hash)
+		}
+	}
+	for hash, entry := range newEntries {
+		s.addIndexEntry(hash, entry)
+	}
+	// An index of the old layout would go on listing the lost objects.
+	if err := s.migrateIndex(); err != nil {
+		return "", err
+	}
+	return packHash, nil
+}
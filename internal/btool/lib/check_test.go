@@ -0,0 +1,178 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCheckPackTest commits two objects, stored as they are, to a single
+// pack and returns its hash and index entries.
+func setupCheckPackTest(t *testing.T) (*ObjectStore, string, string, map[string]types.PackIndexEntry) {
+	t.Helper()
+	store, testDir := setupObjectStoreTest(t)
+	require.NoError(t, store.SetCompression(Compression{Algorithm: CompressionOff}))
+	_, err := store.WriteObject([]byte("first object"))
+	require.NoError(t, err)
+	_, err = store.WriteObject([]byte("second object"))
+	require.NoError(t, err)
+	_, err = store.Commit()
+	require.NoError(t, err)
+
+	index, err := store.GetIndex()
+	require.NoError(t, err)
+	objects := make(map[string]types.PackIndexEntry)
+	var packHash string
+	for hash, entry := range index {
+		objects[hash] = entry
+		packHash = entry.PackHash
+	}
+	require.Len(t, objects, 2)
+	return store, testDir, packHash, objects
+}
+
+func TestCheckPack(t *testing.T) {
+	t.Run("should find nothing wrong with an intact pack", func(t *testing.T) {
+		// Arrange
+		store, _, packHash, objects := setupCheckPackTest(t)
+
+		// Act
+		var problems []string
+		err := store.CheckPack(packHash, objects, func(hash string, err error) {
+			problems = append(problems, hash)
+		})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Empty(t, problems)
+	})
+
+	t.Run("should report an object whose bytes changed", func(t *testing.T) {
+		// Arrange
+		store, testDir, packHash, objects := setupCheckPackTest(t)
+		packPath := filepath.Join(GetPacksDir(testDir), packHash)
+		pack, err := os.ReadFile(packPath)
+		require.NoError(t, err)
+		var damaged string
+		for hash, entry := range objects {
+			damaged = hash
+			pack[entry.Offset] ^= 0xff
+			break
+		}
+		require.NoError(t, os.WriteFile(packPath, pack, 0644))
+
+		// Act
+		problems := make(map[string]error)
+		err = store.CheckPack(packHash, objects, func(hash string, err error) {
+			problems[hash] = err
+		})
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, problems, 2)
+		assert.ErrorContains(t, problems[damaged], "content hashes to")
+		assert.ErrorContains(t, problems[""], "not to its name")
+	})
+
+	t.Run("should report an object past the end of the pack", func(t *testing.T) {
+		// Arrange
+		store, _, packHash, objects := setupCheckPackTest(t)
+		for hash, entry := range objects {
+			entry.Offset += 1000
+			objects[hash] = entry
+			break
+		}
+
+		// Act
+		var problems []error
+		err := store.CheckPack(packHash, objects, func(hash string, err error) {
+			problems = append(problems, err)
+		})
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, problems, 1)
+		assert.ErrorContains(t, problems[0], "past the end of the pack")
+	})
+}
+
+func TestSalvagePack(t *testing.T) {
+	t.Run("should move the objects kept to a new pack and drop the rest", func(t *testing.T) {
+		// Arrange
+		store, testDir, packHash, objects := setupCheckPackTest(t)
+		keep := make(map[string]types.PackIndexEntry)
+		var lost string
+		for hash, entry := range objects {
+			if lost == "" {
+				lost = hash
+				continue
+			}
+			keep[hash] = entry
+		}
+
+		// Act
+		newPack, err := store.SalvagePack(packHash, keep)
+
+		// Assert
+		require.NoError(t, err)
+		assert.NotEqual(t, packHash, newPack)
+		assert.NoFileExists(t, filepath.Join(GetPacksDir(testDir), packHash))
+		assert.NoFileExists(t, filepath.Join(GetIndexDir(testDir), packHash))
+
+		reopened := NewLocalObjectStore(testDir)
+		index, err := reopened.GetIndex()
+		require.NoError(t, err)
+		require.Len(t, index, 1)
+		for hash := range keep {
+			assert.Equal(t, newPack, index[hash].PackHash)
+			data, err := reopened.ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, hash, reopened.Hasher().GetHash(data))
+		}
+	})
+
+	t.Run("should delete a pack with nothing to keep", func(t *testing.T) {
+		// Arrange
+		store, testDir, packHash, _ := setupCheckPackTest(t)
+
+		// Act
+		newPack, err := store.SalvagePack(packHash, nil)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Empty(t, newPack)
+		assert.NoFileExists(t, filepath.Join(GetPacksDir(testDir), packHash))
+		index, err := NewLocalObjectStore(testDir).GetIndex()
+		require.NoError(t, err)
+		assert.Empty(t, index)
+	})
+
+	t.Run("should only rewrite the shard when every byte of the pack is kept", func(t *testing.T) {
+		// Arrange
+		store, testDir, packHash, objects := setupCheckPackTest(t)
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		entries := make(types.PackIndex)
+		for hash, entry := range index {
+			entries[hash] = entry
+		}
+		entries["0000"] = types.PackIndexEntry{PackHash: packHash, Offset: 1000, Length: 10}
+		require.NoError(t, store.writeShard(packHash, entries))
+
+		// Act
+		newPack, err := store.SalvagePack(packHash, objects)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, packHash, newPack)
+		assert.FileExists(t, filepath.Join(GetPacksDir(testDir), packHash))
+		reopened, err := NewLocalObjectStore(testDir).GetIndex()
+		require.NoError(t, err)
+		assert.Len(t, reopened, 2)
+		assert.NotContains(t, reopened, "0000")
+	})
+}
@@ -2,7 +2,7 @@
 package lib
 
 import (
-	"bytes"
+	"fmt"
 	"io"
 	"os"
 
@@ -10,7 +10,7 @@ import (
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
 )
 
-// Constants for the Rabin chunker configuration.
+// Constants for the chunker configuration, shared by all chunkers.
 const (
 	// These values determine the target chunk sizes.
 	minChunkSize = 4 * 1024  // 4KB
@@ -23,73 +23,123 @@ const (
 	defaultWindowSize = 64
 )
 
+// Names of the chunkers, as the repository config records them.
+const (
+	ChunkerRabin   = "rabin"
+	ChunkerFastCDC = "fastcdc"
+)
+
 // rabinTable is a pre-computed table for the Rabin chunker.
 // Initializing this is computationally expensive, so we do it once and reuse it.
 var rabinTable = rabin.NewTable(defaultPoly, defaultWindowSize)
 
-// ChunkFile reads a file from disk, splits it into variable-sized chunks using
-// Rabin fingerprinting, and returns a slice of Chunk objects containing the
-// data and hash of each chunk, along with the total file size.
-func ChunkFile(filePath string) ([]types.Chunk, int64, error) {
-	// 1. Read the entire file into memory. For very large files, a streaming
-	// implementation would be more memory-efficient.
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, 0, err
+// Chunker splits a stream into content-defined chunks, whose boundaries
+// depend on the data around them rather than on their offset, so that an
+// edit only changes the chunks it touches. Two chunkers cut the same data
+// differently, so a repository must stick to one for its chunks to
+// de-duplicate.
+type Chunker interface {
+	// Name returns the name the repository config records for the chunker.
+	Name() string
+	// Split reads reader to its end and calls emit with the data of each
+	// chunk, in order, stopping at the first error. The data is only valid
+	// until emit returns, since its buffer is reused; emit must copy what it
+	// keeps.
+	Split(reader io.Reader, emit func(data []byte) error) error
+}
+
+// NewChunker returns the chunker with the given name. An empty name selects
+// Rabin fingerprinting, which repositories used before the choice existed.
+func NewChunker(name string) (Chunker, error) {
+	switch name {
+	case "", ChunkerRabin:
+		return rabinChunker{}, nil
+	case ChunkerFastCDC:
+		return fastCDCChunker{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported chunker %q; use %s or %s", name, ChunkerRabin, ChunkerFastCDC)
 	}
+}
 
-	// If the file is empty, there's nothing to chunk.
-	if len(content) == 0 {
-		return []types.Chunk{}, 0, nil
+// ChunkFile splits the file at filePath into chunks as ChunkReader does,
+// reading it as a stream so that files of any size can be chunked.
+func ChunkFile(filePath string, chunker Chunker, hasher *Hasher, emit func(types.Chunk) error) (int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, err
 	}
+	defer file.Close()
+	return ChunkReader(file, chunker, hasher, emit)
+}
 
-	// 2. Create a reader from the in-memory content.
-	reader := bytes.NewReader(content)
+// ChunkReader splits everything read from reader into variable-sized chunks
+// with chunker and calls emit with each chunk, in order, along with its data
+// and hash. Chunks are hashed with hasher, so that their hashes match the
+// repository's object IDs. It returns the total number of bytes read, and
+// stops at the first error from reader or emit.
+//
+// Only about one chunk is held in memory at a time, whatever the size of the
+// input. As with Chunker.Split, each chunk's data is only valid until emit
+// returns.
+func ChunkReader(reader io.Reader, chunker Chunker, hasher *Hasher, emit func(types.Chunk) error) (int64, error) {
+	var totalSize int64
+	err := chunker.Split(reader, func(data []byte) error {
+		totalSize += int64(len(data))
+		return emit(types.Chunk{Hash: hasher.GetHash(data), Size: int64(len(data)), Data: data})
+	})
+	return totalSize, err
+}
 
-	// 3. Create a new Rabin chunker using our pre-computed table and chunk size settings.
-	chunker := rabin.NewChunker(rabinTable, reader, minChunkSize, avgChunkSize, maxChunkSize)
+// rabinChunker cuts chunks with Rabin fingerprinting.
+type rabinChunker struct{}
 
-	var chunks []types.Chunk
-	var totalSize int64
-	var offset int64
+func (rabinChunker) Name() string {
+	return ChunkerRabin
+}
 
-	// 4. Loop, calling Next() to get the length of each chunk.
+func (rabinChunker) Split(reader io.Reader, emit func(data []byte) error) error {
+	buffer := splitBuffers.get()
+	recorder := &recordingReader{reader: reader, recorded: (*buffer)[:0]}
+	defer func() {
+		*buffer = recorder.recorded // It may have grown.
+		splitBuffers.put(buffer)
+	}()
+	chunker := rabin.NewChunker(rabinTable, recorder, minChunkSize, avgChunkSize, maxChunkSize)
 	for {
+		// Next only reports where the chunk ends, while the data it read
+		// waits in the recorder.
 		length, err := chunker.Next()
 		if err == io.EOF {
-			// We've reached the end of the file.
-			break
+			return nil
 		}
 		if err != nil {
-			return nil, 0, err
+			return err
 		}
-
-		// 5. Use the length to slice the original content buffer. This is efficient
-		// as it avoids copying the data for each chunk.
-		chunkData := content[offset : offset+int64(length)]
-		offset += int64(length)
-
-		// 6. Create the Chunk object with its data and hash.
-		hash := GetHash(chunkData) // Assumes GetHash() from hasher.go
-		size := int64(len(chunkData))
-		totalSize += size
-
-		chunks = append(chunks, types.Chunk{
-			Hash: hash,
-			Size: size,
-			Data: chunkData,
-		})
+		if length == 0 {
+			continue // Empty input ends in an empty chunk, which is dropped.
+		}
+		if err := emit(recorder.recorded[:length]); err != nil {
+			return err
+		}
+		recorder.drop(length)
 	}
+}
 
-	// 7. Handle the edge case where a file is smaller than the minimum chunk size.
-	// In this case, the chunker may not produce any chunks, so we treat the
-	// entire file as a single chunk.
-	if len(chunks) == 0 && len(content) > 0 {
-		hash := GetHash(content)
-		size := int64(len(content))
-		chunks = append(chunks, types.Chunk{Hash: hash, Size: size, Data: content})
-		totalSize = size
-	}
+// recordingReader keeps the data read through it until it is dropped. The
+// chunker reads at most a small buffer beyond the end of the current chunk,
+// so what is kept stays below a chunk and that buffer.
+type recordingReader struct {
+	reader   io.Reader
+	recorded []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.recorded = append(r.recorded, p[:n]...)
+	return n, err
+}
 
-	return chunks, totalSize, nil
+// drop removes the first n recorded bytes.
+func (r *recordingReader) drop(n int) {
+	r.recorded = r.recorded[:copy(r.recorded, r.recorded[n:])]
 }
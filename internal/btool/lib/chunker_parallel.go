@@ -0,0 +1,325 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// Large files are chunked in regions of parallelRegionSize bytes, one worker
+// each. Where a region begins, the chunks of the worker before it are taken
+// until one of its cuts falls on a cut of the region's own worker within
+// parallelSyncWindow bytes of the start. From there on the two cut alike,
+// since every chunker starts afresh after each cut, so the result is exactly
+// what chunking the file in one go gives and de-duplication is unaffected.
+var (
+	parallelRegionSize int64 = 64 * 1024 * 1024 // 64MB
+	parallelSyncWindow int64 = 1024 * 1024      // 1MB
+)
+
+// errStopChunking stops a worker's Split once its work is done.
+var errStopChunking = errors.New("stop chunking")
+
+// skippedOffset marks a region whose worker's chunks are not used at all,
+// because the worker before it did not meet its cuts within the window.
+const skippedOffset = math.MaxInt64
+
+// ChunkFileParallel splits the file at filePath into exactly the chunks that
+// ChunkFile would, but has up to workers goroutines chunk separate regions of
+// a large file, so that a single file keeps every CPU busy. It calls write
+// with each chunk, concurrently and in no particular order, and returns the
+// chunks without their data, in order, along with the file's size. As with
+// ChunkReader, a chunk's data is only valid until write returns.
+//
+// Chunks that turn out not to belong to the file are dropped unwritten,
+// except in data so uniform, such as long runs of zeros, that workers find no
+// common cut; write may then see a few chunks that are not returned.
+func ChunkFileParallel(filePath string, chunker Chunker, hasher *Hasher, workers int, write func(types.Chunk) error) ([]types.ChunkRef, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if workers < 2 || info.Size() < 2*parallelRegionSize {
+		chunks := []types.ChunkRef{}
+		totalSize, err := ChunkReader(file, chunker, hasher, func(chunk types.Chunk) error {
+			if err := write(chunk); err != nil {
+				return err
+			}
+			chunks = append(chunks, types.ChunkRef{Hash: chunk.Hash, Size: chunk.Size})
+			return nil
+		})
+		return chunks, totalSize, err
+	}
+
+	p := &parallelChunking{file: file, size: info.Size(), chunker: chunker, hasher: hasher, write: write}
+	for start := int64(0); start < p.size; start += parallelRegionSize {
+		p.regions = append(p.regions, &chunkRegion{start: start})
+	}
+	if err := p.run(workers); err != nil {
+		return nil, 0, err
+	}
+	return p.chunks(), p.size, nil
+}
+
+// parallelChunking is the state shared by the workers of ChunkFileParallel.
+type parallelChunking struct {
+	file    io.ReaderAt
+	size    int64
+	chunker Chunker
+	hasher  *Hasher
+	write   func(types.Chunk) error
+	regions []*chunkRegion
+
+	errMutex sync.Mutex
+	err      error // The first error of any worker.
+}
+
+// chunkRegion is a region of the file and what its worker found.
+type chunkRegion struct {
+	start int64
+
+	// early holds the starts of the chunks the region's worker cuts within
+	// the sync window, computed by whichever worker needs them first.
+	earlyOnce sync.Once
+	early     map[int64]bool
+	earlyErr  error
+
+	// Until the worker before it meets its cuts, the region's chunks within
+	// the sync window are held back, since only those from the meeting
+	// point, syncOffset, on are the file's.
+	mutex      sync.Mutex
+	resolved   bool
+	syncOffset int64
+	held       []chunkRecord
+
+	// Written only by the region's worker.
+	records []chunkRecord
+	next    int   // The region the worker met, or len(regions) at the end.
+	stop    int64 // Where the worker met region next.
+}
+
+// chunkRecord is a chunk at an offset of the file.
+type chunkRecord struct {
+	offset int64
+	chunk  types.Chunk
+}
+
+// fail records err as the first error, which stops every worker.
+func (p *parallelChunking) fail(err error) {
+	p.errMutex.Lock()
+	defer p.errMutex.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// failed reports whether any worker has failed.
+func (p *parallelChunking) failed() bool {
+	p.errMutex.Lock()
+	defer p.errMutex.Unlock()
+	return p.err != nil
+}
+
+// run chunks every region with workers goroutines, taking regions in order.
+func (p *parallelChunking) run(workers int) error {
+	jobs := make(chan int, len(p.regions))
+	for k := range p.regions {
+		jobs <- k
+	}
+	close(jobs)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(p.regions); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for k := range jobs {
+				if err := p.chunkRegion(k); err != nil {
+					p.fail(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return p.err
+}
+
+// chunkRegion chunks the file from the start of region k until its cuts meet
+// those of a later region's worker, or to the end of the file.
+func (p *parallelChunking) chunkRegion(k int) error {
+	r := p.regions[k]
+	offset := r.start
+	next := k + 1
+	r.next = len(p.regions)
+	err := p.chunker.Split(io.NewSectionReader(p.file, r.start, p.size-r.start), func(data []byte) error {
+		if p.failed() || r.skipped() {
+			return errStopChunking
+		}
+		chunkStart := offset
+		offset += int64(len(data))
+
+		for next < len(p.regions) && chunkStart >= p.regions[next].start {
+			meeting, err := p.meet(k, next, chunkStart)
+			if err != nil {
+				return err
+			}
+			if meeting == ownSkipped {
+				return errStopChunking
+			}
+			if meeting == metRegion {
+				r.next, r.stop = next, chunkStart
+				return errStopChunking
+			}
+			if meeting == notYetMet {
+				break
+			}
+			next++
+		}
+
+		chunk := types.Chunk{Hash: p.hasher.GetHash(data), Size: int64(len(data)), Data: data}
+		r.records = append(r.records, chunkRecord{offset: chunkStart, chunk: types.Chunk{Hash: chunk.Hash, Size: chunk.Size}})
+		return p.writeChunk(k, chunkRecord{offset: chunkStart, chunk: chunk})
+	})
+	if errors.Is(err, errStopChunking) {
+		err = nil
+	}
+	return err
+}
+
+// Outcomes of meet.
+const (
+	notYetMet    = iota // The cuts may still meet.
+	metRegion           // The cuts meet here.
+	passedRegion        // The cuts will not meet, so the region is skipped.
+	ownSkipped          // The worker's own region was skipped meanwhile.
+)
+
+// meet tells region k's worker, at a cut at chunkStart within region j,
+// whether it meets the cuts of region j's worker there. The first worker to
+// meet a region fixes where the region's chunks start, so any worker arriving
+// later must meet it at exactly that point or skip the region.
+func (p *parallelChunking) meet(k, j int, chunkStart int64) (int, error) {
+	early, err := p.earlyStarts(j)
+	if err != nil {
+		return notYetMet, err
+	}
+	// Regions are locked in order, so workers cannot deadlock.
+	own, other := p.regions[k], p.regions[j]
+	own.mutex.Lock()
+	defer own.mutex.Unlock()
+	if own.resolved && own.syncOffset == skippedOffset {
+		return ownSkipped, nil
+	}
+
+	other.mutex.Lock()
+	meeting := notYetMet
+	var held []chunkRecord
+	switch {
+	case other.resolved && other.syncOffset == skippedOffset:
+		meeting = passedRegion
+	case other.resolved && chunkStart == other.syncOffset:
+		meeting = metRegion
+	case other.resolved && chunkStart > other.syncOffset:
+		meeting = passedRegion
+	case !other.resolved && early[chunkStart]:
+		meeting = metRegion
+		other.resolved, other.syncOffset = true, chunkStart
+		held, other.held = other.held, nil
+	case !other.resolved && chunkStart >= other.start+parallelSyncWindow:
+		meeting = passedRegion
+	}
+	if meeting == passedRegion {
+		other.resolved, other.syncOffset, other.held = true, skippedOffset, nil
+	}
+	other.mutex.Unlock()
+
+	for _, record := range held {
+		if record.offset >= chunkStart {
+			if err := p.write(record.chunk); err != nil {
+				return notYetMet, err
+			}
+		}
+	}
+	return meeting, nil
+}
+
+// earlyStarts returns the starts of the chunks that region k's worker cuts
+// within the sync window, chunking just enough of the file to find them.
+func (p *parallelChunking) earlyStarts(k int) (map[int64]bool, error) {
+	r := p.regions[k]
+	r.earlyOnce.Do(func() {
+		// A chunk starting within the window ends at most a chunk beyond it.
+		limit := min(parallelSyncWindow+maxChunkSize, p.size-r.start)
+		r.early = make(map[int64]bool)
+		offset := r.start
+		r.earlyErr = p.chunker.Split(io.NewSectionReader(p.file, r.start, limit), func(data []byte) error {
+			if offset >= r.start+parallelSyncWindow {
+				return errStopChunking
+			}
+			r.early[offset] = true
+			offset += int64(len(data))
+			return nil
+		})
+		if errors.Is(r.earlyErr, errStopChunking) {
+			r.earlyErr = nil
+		}
+	})
+	return r.early, r.earlyErr
+}
+
+// writeChunk writes a chunk that region k's worker cut, or holds it back if
+// it is within the sync window and the region's meeting point is unknown.
+func (p *parallelChunking) writeChunk(k int, record chunkRecord) error {
+	r := p.regions[k]
+	if k > 0 {
+		r.mutex.Lock()
+		if !r.resolved && record.offset < r.start+parallelSyncWindow {
+			// The chunker reuses the data's buffer once this returns.
+			record.chunk.Data = bytes.Clone(record.chunk.Data)
+			r.held = append(r.held, record)
+			r.mutex.Unlock()
+			return nil
+		}
+		drop := r.resolved && record.offset < r.syncOffset
+		r.mutex.Unlock()
+		if drop {
+			return nil
+		}
+	}
+	return p.write(record.chunk)
+}
+
+// skipped reports whether the region's chunks are not used at all.
+func (r *chunkRegion) skipped() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.resolved && r.syncOffset == skippedOffset
+}
+
+// chunks follows the workers from the start of the file, each up to where it
+// met the next, and returns the chunks they cut along the way.
+func (p *parallelChunking) chunks() []types.ChunkRef {
+	chunks := []types.ChunkRef{}
+	k, from := 0, int64(0)
+	for k < len(p.regions) {
+		r := p.regions[k]
+		for _, record := range r.records {
+			if record.offset >= from {
+				chunks = append(chunks, types.ChunkRef{Hash: record.chunk.Hash, Size: record.chunk.Size})
+			}
+		}
+		k, from = r.next, r.stop
+	}
+	return chunks
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkSequentially returns the chunks that ChunkReader cuts from content.
+func chunkSequentially(t *testing.T, chunker Chunker, content []byte) []types.ChunkRef {
+	chunks := []types.ChunkRef{}
+	_, err := ChunkReader(bytes.NewReader(content), chunker, NewHasher(nil), func(chunk types.Chunk) error {
+		chunks = append(chunks, types.ChunkRef{Hash: chunk.Hash, Size: chunk.Size})
+		return nil
+	})
+	require.NoError(t, err)
+	return chunks
+}
+
+// chunkInParallel writes content to a file, chunks it with
+// ChunkFileParallel, and returns the chunks along with the hashes written.
+func chunkInParallel(t *testing.T, chunker Chunker, content []byte, workers int) ([]types.ChunkRef, map[string]bool) {
+	filePath := filepath.Join(t.TempDir(), "large.bin")
+	require.NoError(t, os.WriteFile(filePath, content, 0644))
+
+	var mutex sync.Mutex
+	written := make(map[string]bool)
+	chunks, totalSize, err := ChunkFileParallel(filePath, chunker, NewHasher(nil), workers, func(chunk types.Chunk) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		assert.Equal(t, chunk.Hash, NewHasher(nil).GetHash(chunk.Data))
+		written[chunk.Hash] = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), totalSize)
+	return chunks, written
+}
+
+func TestChunkFileParallel(t *testing.T) {
+	// Shrink the regions so that a few megabytes are split among several
+	// workers.
+	regionSize, syncWindow := parallelRegionSize, parallelSyncWindow
+	t.Cleanup(func() { parallelRegionSize, parallelSyncWindow = regionSize, syncWindow })
+	parallelRegionSize, parallelSyncWindow = 256*1024, 64*1024
+
+	random := make([]byte, 3*1024*1024+12345)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	for _, name := range []string{ChunkerRabin, ChunkerFastCDC} {
+		chunker, err := NewChunker(name)
+		require.NoError(t, err)
+
+		t.Run("should cut the same chunks as a single pass with "+name, func(t *testing.T) {
+			// Act
+			chunks, written := chunkInParallel(t, chunker, random, 4)
+
+			// Assert
+			assert.Equal(t, chunkSequentially(t, chunker, random), chunks)
+			assert.Len(t, written, len(chunks), "Only the file's chunks should be written")
+			for _, chunk := range chunks {
+				assert.True(t, written[chunk.Hash], "Every chunk should be written")
+			}
+		})
+
+		t.Run("should cut the same chunks when workers find no common cut with "+name, func(t *testing.T) {
+			// Arrange: zeros are cut at fixed lengths, which an odd region
+			// size keeps from lining up, with random data on either side.
+			parallelRegionSize = 256*1024 + 1000
+			defer func() { parallelRegionSize = 256 * 1024 }()
+			content := append(append(append([]byte{}, random[:300*1024]...), make([]byte, 2*1024*1024)...), random[:500*1024]...)
+
+			// Act
+			chunks, written := chunkInParallel(t, chunker, content, 4)
+
+			// Assert
+			assert.Equal(t, chunkSequentially(t, chunker, content), chunks)
+			for _, chunk := range chunks {
+				assert.True(t, written[chunk.Hash], "Every chunk should be written")
+			}
+		})
+	}
+
+	t.Run("should chunk small files in a single pass", func(t *testing.T) {
+		// Arrange
+		chunker, err := NewChunker(ChunkerFastCDC)
+		require.NoError(t, err)
+		small := random[:parallelRegionSize]
+
+		// Act
+		chunks, written := chunkInParallel(t, chunker, small, 4)
+
+		// Assert
+		assert.Equal(t, chunkSequentially(t, chunker, small), chunks)
+		assert.Len(t, written, len(chunks))
+	})
+
+	t.Run("should return no chunks for an empty file", func(t *testing.T) {
+		// Arrange
+		chunker, err := NewChunker(ChunkerRabin)
+		require.NoError(t, err)
+
+		// Act
+		chunks, _ := chunkInParallel(t, chunker, nil, 4)
+
+		// Assert
+		assert.Equal(t, []types.ChunkRef{}, chunks)
+	})
+}
@@ -1,11 +1,16 @@
 package lib
 
 import (
+	"bytes"
 	"crypto/rand"
+	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
+	"testing/iotest"
 
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -27,6 +32,18 @@ func setupTestFile(t *testing.T, content []byte) (string, func()) {
 	return filePath, cleanup
 }
 
+// collectChunks chunks the file at filePath and returns all of its chunks,
+// with copies of their data.
+func collectChunks(filePath string) ([]types.Chunk, int64, error) {
+	var chunks []types.Chunk
+	totalSize, err := ChunkFile(filePath, rabinChunker{}, NewHasher(nil), func(chunk types.Chunk) error {
+		chunk.Data = bytes.Clone(chunk.Data)
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	return chunks, totalSize, err
+}
+
 func TestChunkFile(t *testing.T) {
 	t.Run("Chunk a normal-sized file", func(t *testing.T) {
 		// Create a file large enough to be chunked into multiple pieces.
@@ -38,7 +55,7 @@ func TestChunkFile(t *testing.T) {
 		filePath, cleanup := setupTestFile(t, content)
 		defer cleanup()
 
-		chunks, totalSize, err := ChunkFile(filePath)
+		chunks, totalSize, err := collectChunks(filePath)
 
 		require.NoError(t, err, "ChunkFile failed with an unexpected error")
 		assert.Greater(t, len(chunks), 1, "Expected file to be split into multiple chunks")
@@ -58,7 +75,7 @@ func TestChunkFile(t *testing.T) {
 		filePath, cleanup := setupTestFile(t, content)
 		defer cleanup()
 
-		chunks, totalSize, err := ChunkFile(filePath)
+		chunks, totalSize, err := collectChunks(filePath)
 
 		require.NoError(t, err, "ChunkFile failed with an unexpected error")
 		// It should be treated as a single chunk.
@@ -72,7 +89,7 @@ func TestChunkFile(t *testing.T) {
 		filePath, cleanup := setupTestFile(t, content)
 		defer cleanup()
 
-		chunks, totalSize, err := ChunkFile(filePath)
+		chunks, totalSize, err := collectChunks(filePath)
 
 		require.NoError(t, err, "ChunkFile failed with an unexpected error")
 		assert.Empty(t, chunks, "Expected 0 chunks for an empty file")
@@ -82,7 +99,7 @@ func TestChunkFile(t *testing.T) {
 	t.Run("Attempt to chunk a non-existent file", func(t *testing.T) {
 		nonExistentPath := filepath.Join(t.TempDir(), "this_file_does_not_exist.txt")
 
-		_, _, err := ChunkFile(nonExistentPath)
+		_, _, err := collectChunks(nonExistentPath)
 
 		require.Error(t, err, "Expected an error when chunking a non-existent file")
 		// Check that the error is a file system "not exist" error.
@@ -97,7 +114,7 @@ func TestChunkFile(t *testing.T) {
 		filePath, cleanup := setupTestFile(t, content)
 		defer cleanup()
 
-		chunks, _, err := ChunkFile(filePath)
+		chunks, _, err := collectChunks(filePath)
 		require.NoError(t, err, "ChunkFile failed")
 
 		for _, chunk := range chunks {
@@ -109,3 +126,78 @@ func TestChunkFile(t *testing.T) {
 		}
 	})
 }
+
+func TestChunkReader(t *testing.T) {
+	content := make([]byte, 1024*1024)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+
+	t.Run("should cut the same chunks however the data is read", func(t *testing.T) {
+		// Arrange
+		var whole, byteByByte []types.Chunk
+		collect := func(chunks *[]types.Chunk) func(types.Chunk) error {
+			return func(chunk types.Chunk) error {
+				chunk.Data = bytes.Clone(chunk.Data)
+				*chunks = append(*chunks, chunk)
+				return nil
+			}
+		}
+
+		// Act
+		wholeSize, err := ChunkReader(bytes.NewReader(content), rabinChunker{}, NewHasher(nil), collect(&whole))
+		require.NoError(t, err)
+		oneByteSize, err := ChunkReader(iotest.OneByteReader(bytes.NewReader(content)), rabinChunker{}, NewHasher(nil), collect(&byteByByte))
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(len(content)), wholeSize)
+		assert.Equal(t, wholeSize, oneByteSize)
+		require.Equal(t, len(whole), len(byteByByte))
+		var reconstructed []byte
+		for i, chunk := range whole {
+			assert.Equal(t, chunk.Hash, byteByByte[i].Hash)
+			assert.LessOrEqual(t, chunk.Size, int64(maxChunkSize))
+			reconstructed = append(reconstructed, chunk.Data...)
+		}
+		assert.Equal(t, content, reconstructed)
+	})
+
+	t.Run("should stop at the first error from emit", func(t *testing.T) {
+		// Arrange
+		stop := errors.New("stop")
+		calls := 0
+
+		// Act
+		_, err := ChunkReader(bytes.NewReader(content), rabinChunker{}, NewHasher(nil), func(types.Chunk) error {
+			calls++
+			return stop
+		})
+
+		// Assert
+		assert.ErrorIs(t, err, stop)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("should report read errors", func(t *testing.T) {
+		_, err := ChunkReader(iotest.ErrReader(io.ErrUnexpectedEOF), rabinChunker{}, NewHasher(nil), func(types.Chunk) error { return nil })
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+func BenchmarkChunkReader(b *testing.B) {
+	content := make([]byte, 16*1024*1024)
+	_, err := rand.Read(content)
+	require.NoError(b, err)
+
+	for _, chunker := range []Chunker{rabinChunker{}, fastCDCChunker{}} {
+		b.Run(chunker.Name(), func(b *testing.B) {
+			hasher := NewHasher(nil)
+			b.SetBytes(int64(len(content)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, err := ChunkReader(bytes.NewReader(content), chunker, hasher, func(types.Chunk) error { return nil })
+				require.NoError(b, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package lib
+
+import (
+	"bytes"
+	"compress/gzip"
+	_ "embed"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Compression algorithms for stored objects. Index entries of objects stored
+// as they are name no algorithm.
+const (
+	CompressionOff  = "off"
+	CompressionLZ4  = "lz4"
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+// maxObjectSize bounds the size an object may claim to decompress to, which
+// protects readers from corrupt or malicious index entries.
+const maxObjectSize = 1 << 30 // 1GB
+
+// Compression selects how objects are compressed before they are stored.
+// Level 0 selects the algorithm's default level.
+type Compression struct {
+	Algorithm string
+	Level     int
+}
+
+// DefaultCompression returns the compression used unless another is chosen:
+// zstd at its default level.
+func DefaultCompression() Compression {
+	return Compression{Algorithm: CompressionZstd}
+}
+
+// Validate checks that the algorithm is known and the level is in its range.
+func (c Compression) Validate() error {
+	maxLevel := 0
+	switch c.Algorithm {
+	case CompressionOff:
+	case CompressionLZ4, CompressionGzip:
+		maxLevel = 9
+	case CompressionZstd:
+		maxLevel = 22
+	default:
+		return fmt.Errorf("unsupported compression %q; use %s, %s, %s, or %s", c.Algorithm, CompressionOff, CompressionLZ4, CompressionZstd, CompressionGzip)
+	}
+	if c.Level < 0 || c.Level > maxLevel {
+		if maxLevel == 0 {
+			return fmt.Errorf("compression %s takes no level", c.Algorithm)
+		}
+		return fmt.Errorf("%s compression levels range from 1 to %d", c.Algorithm, maxLevel)
+	}
+	return nil
+}
+
+//go:generate go run gendict.go
+
+// metadataDictionary is the zstd dictionary that file manifests and trees are
+// compressed with. Those objects are small and alike, so on their own they
+// barely compress; the dictionary supplies what they have in common. Every
+// zstd frame names the dictionary it needs by ID, so the index need not.
+//
+//go:embed metadata.zdict
+var metadataDictionary []byte
+
+// metadataDictionaryID is the ID of metadataDictionary, which gendict.go
+// trains. Data compressed with it can only be read with it, so it must never
+// change; a new dictionary needs a new ID, and the old one kept for reading.
+const metadataDictionaryID = 0x62740001
+
+// zstdEncoderKey selects one of the shared zstd encoders.
+type zstdEncoderKey struct {
+	level    int
+	metadata bool // Whether the encoder uses metadataDictionary.
+}
+
+var (
+	zstdMutex    sync.Mutex
+	zstdEncoders = make(map[zstdEncoderKey]*zstd.Encoder)
+	zstdDecoder  *zstd.Decoder
+)
+
+// zstdEncoder returns the shared zstd encoder for level, using
+// metadataDictionary if metadata is set, which is safe for concurrent use
+// through EncodeAll.
+func zstdEncoder(level int, metadata bool) (*zstd.Encoder, error) {
+	zstdMutex.Lock()
+	defer zstdMutex.Unlock()
+	key := zstdEncoderKey{level: level, metadata: metadata}
+	if encoder, ok := zstdEncoders[key]; ok {
+		return encoder, nil
+	}
+	encoderLevel := zstd.SpeedDefault
+	if level != 0 {
+		encoderLevel = zstd.EncoderLevelFromZstd(level)
+	}
+	options := []zstd.EOption{zstd.WithEncoderLevel(encoderLevel)}
+	if metadata {
+		options = append(options, zstd.WithEncoderDict(metadataDictionary))
+	}
+	encoder, err := zstd.NewWriter(nil, options...)
+	if err != nil {
+		return nil, err
+	}
+	zstdEncoders[key] = encoder
+	return encoder, nil
+}
+
+// sharedZstdDecoder returns the shared zstd decoder, which is safe for
+// concurrent use through DecodeAll.
+func sharedZstdDecoder() (*zstd.Decoder, error) {
+	zstdMutex.Lock()
+	defer zstdMutex.Unlock()
+	if zstdDecoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxObjectSize), zstd.WithDecoderDicts(metadataDictionary))
+		if err != nil {
+			return nil, err
+		}
+		zstdDecoder = decoder
+	}
+	return zstdDecoder, nil
+}
+
+// lz4Levels maps levels 1 to 9 onto lz4's high-compression depths.
+var lz4Levels = [...]lz4.CompressionLevel{lz4.Level1, lz4.Level2, lz4.Level3, lz4.Level4, lz4.Level5, lz4.Level6, lz4.Level7, lz4.Level8, lz4.Level9}
+
+// compressedExtensions are the extensions of file formats that are already
+// compressed, so their chunks are stored without trying to compress them.
+var compressedExtensions = map[string]bool{
+	// Images.
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".heif": true, ".avif": true, ".jxl": true,
+	// Audio and video.
+	".mp3": true, ".aac": true, ".m4a": true, ".ogg": true, ".opus": true, ".flac": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true, ".wmv": true,
+	// Archives and compressed files.
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".txz": true, ".zst": true, ".lz4": true, ".br": true, ".7z": true, ".rar": true,
+	// Formats that are zip archives underneath.
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".odt": true, ".ods": true, ".odp": true, ".epub": true,
+}
+
+// HasCompressedExtension reports whether path names a file in a format that
+// is already compressed, judging by its extension alone.
+func HasCompressedExtension(path string) bool {
+	return compressedExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// Objects are sampled for their entropy in entropySamples slices of
+// entropySampleSize bytes spread evenly across them. Smaller objects are too
+// short to judge and are always tried.
+const (
+	entropySamples     = 16
+	entropySampleSize  = 4 * 1024
+	minEntropyDataSize = 4 * 1024
+	// maxCompressibleEntropy is the entropy, in bits per byte, above which
+	// data is taken to be compressed or encrypted already. Text has around 5
+	// and machine code around 6, while compressed data comes close to 8.
+	maxCompressibleEntropy = 7.5
+)
+
+// looksIncompressible reports whether a sample of data has the byte entropy of
+// data that is already compressed, which is far cheaper to find out than by
+// compressing it.
+func looksIncompressible(data []byte) bool {
+	if len(data) < minEntropyDataSize {
+		return false
+	}
+	var counts [256]int
+	sampled := 0
+	if len(data) <= entropySamples*entropySampleSize {
+		for _, b := range data {
+			counts[b]++
+		}
+		sampled = len(data)
+	} else {
+		stride := (len(data) - entropySampleSize) / (entropySamples - 1)
+		for i := 0; i < entropySamples; i++ {
+			for _, b := range data[i*stride : i*stride+entropySampleSize] {
+				counts[b]++
+			}
+		}
+		sampled = entropySamples * entropySampleSize
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			p := float64(count) / float64(sampled)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy > maxCompressibleEntropy
+}
+
+// compressObject compresses data as c selects and returns the result along
+// with the algorithm's name. Data that looks incompressible or does not
+// shrink, and any data when compression is off, is returned as it is, with no
+// algorithm. Metadata objects are compressed with zstd and
+// metadataDictionary whatever the algorithm, since they are small and cheap
+// to compress either way.
+func compressObject(data []byte, c Compression, metadata bool) ([]byte, string, error) {
+	if c.Algorithm == CompressionOff || looksIncompressible(data) {
+		return data, "", nil
+	}
+	if metadata && c.Algorithm != CompressionZstd {
+		c = Compression{Algorithm: CompressionZstd}
+	}
+	var compressed []byte
+	switch c.Algorithm {
+	case CompressionZstd:
+		encoder, err := zstdEncoder(c.Level, metadata)
+		if err != nil {
+			return nil, "", err
+		}
+		compressed = encoder.EncodeAll(data, make([]byte, 0, len(data)))
+	case CompressionLZ4:
+		// lz4 blocks do not record their size; the index does.
+		compressed = make([]byte, lz4.CompressBlockBound(len(data)))
+		var n int
+		var err error
+		if c.Level == 0 {
+			n, err = lz4.CompressBlock(data, compressed, nil)
+		} else {
+			n, err = lz4.CompressBlockHC(data, compressed, lz4Levels[c.Level-1], nil, nil)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if n == 0 {
+			return data, "", nil // Incompressible.
+		}
+		compressed = compressed[:n]
+	case CompressionGzip:
+		level := gzip.DefaultCompression
+		if c.Level != 0 {
+			level = c.Level
+		}
+		var buffer bytes.Buffer
+		writer, err := gzip.NewWriterLevel(&buffer, level)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, "", err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", err
+		}
+		compressed = buffer.Bytes()
+	default:
+		return nil, "", fmt.Errorf("unsupported compression %q", c.Algorithm)
+	}
+	if len(compressed) >= len(data) {
+		return data, "", nil
+	}
+	return compressed, c.Algorithm, nil
+}
+
+// decompressObject reverses compressObject, checking that the result has the
+// size recorded in the index.
+func decompressObject(data []byte, algorithm string, size int64) ([]byte, error) {
+	if algorithm == "" {
+		return data, nil
+	}
+	if size < 0 || size > maxObjectSize {
+		return nil, fmt.Errorf("invalid uncompressed size %d", size)
+	}
+
+	var decompressed []byte
+	switch algorithm {
+	case CompressionZstd:
+		decoder, err := sharedZstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		if decompressed, err = decoder.DecodeAll(data, make([]byte, 0, size)); err != nil {
+			return nil, fmt.Errorf("could not decompress: %w", err)
+		}
+	case CompressionLZ4:
+		decompressed = make([]byte, size)
+		n, err := lz4.UncompressBlock(data, decompressed)
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress: %w", err)
+		}
+		decompressed = decompressed[:n]
+	case CompressionGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress: %w", err)
+		}
+		// One byte more than expected is enough to notice a wrong size.
+		if decompressed, err = io.ReadAll(io.LimitReader(reader, size+1)); err != nil {
+			return nil, fmt.Errorf("could not decompress: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", algorithm)
+	}
+	if int64(len(decompressed)) != size {
+		return nil, fmt.Errorf("decompressed to %d bytes, expected %d", len(decompressed), size)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,292 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompressObject(t *testing.T) {
+	for _, compression := range []Compression{
+		{Algorithm: CompressionZstd},
+		{Algorithm: CompressionZstd, Level: 1},
+		{Algorithm: CompressionZstd, Level: 19},
+		{Algorithm: CompressionLZ4},
+		{Algorithm: CompressionLZ4, Level: 9},
+		{Algorithm: CompressionGzip},
+		{Algorithm: CompressionGzip, Level: 1},
+	} {
+		t.Run(fmt.Sprintf("should compress repetitive data with %s level %d and restore it", compression.Algorithm, compression.Level), func(t *testing.T) {
+			// Arrange
+			data := bytes.Repeat([]byte("the same line of text\n"), 1000)
+
+			// Act
+			compressed, algorithm, err := compressObject(data, compression, false)
+			require.NoError(t, err)
+			decompressed, err := decompressObject(compressed, algorithm, int64(len(data)))
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, compression.Algorithm, algorithm)
+			assert.Less(t, len(compressed), len(data)/10)
+			assert.Equal(t, data, decompressed)
+		})
+	}
+
+	t.Run("should store data as it is when compression is off", func(t *testing.T) {
+		data := bytes.Repeat([]byte("a"), 1000)
+		compressed, algorithm, err := compressObject(data, Compression{Algorithm: CompressionOff}, false)
+		require.NoError(t, err)
+		assert.Empty(t, algorithm)
+		assert.Equal(t, data, compressed)
+	})
+
+	t.Run("should store incompressible data as it is", func(t *testing.T) {
+		// Arrange
+		data := make([]byte, 4096)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+
+		for _, algorithm := range []string{CompressionZstd, CompressionLZ4, CompressionGzip} {
+			// Act
+			compressed, used, err := compressObject(data, Compression{Algorithm: algorithm}, false)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Empty(t, used, algorithm)
+			assert.Equal(t, data, compressed, algorithm)
+		}
+	})
+
+	t.Run("should reject a size that does not match", func(t *testing.T) {
+		compressed, algorithm, err := compressObject(bytes.Repeat([]byte("a"), 1000), DefaultCompression(), false)
+		require.NoError(t, err)
+		_, err = decompressObject(compressed, algorithm, 999)
+		assert.ErrorContains(t, err, "expected 999")
+		_, err = decompressObject(compressed, "lzma", 1000)
+		assert.ErrorContains(t, err, "unsupported compression")
+	})
+}
+
+func TestCompressionValidate(t *testing.T) {
+	assert.NoError(t, DefaultCompression().Validate())
+	assert.NoError(t, Compression{Algorithm: CompressionOff}.Validate())
+	assert.NoError(t, Compression{Algorithm: CompressionZstd, Level: 22}.Validate())
+	assert.ErrorContains(t, Compression{Algorithm: CompressionGzip, Level: 10}.Validate(), "from 1 to 9")
+	assert.ErrorContains(t, Compression{Algorithm: CompressionOff, Level: 1}.Validate(), "takes no level")
+	assert.ErrorContains(t, Compression{Algorithm: "brotli"}.Validate(), "unsupported compression")
+}
+
+func TestObjectStoreCompression(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		name := "should compress objects before storing them"
+		if encrypted {
+			name += " encrypted"
+		}
+		t.Run(name, func(t *testing.T) {
+			// Arrange
+			backend := NewMemoryBackend()
+			newStore := func() *ObjectStore { return NewObjectStore(backend) }
+			if encrypted {
+				key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+				require.NoError(t, err)
+				newStore = func() *ObjectStore { return NewEncryptedObjectStore(backend, key) }
+			}
+			store := newStore()
+			text := bytes.Repeat([]byte("compressible text "), 500)
+
+			// Act
+			hash, err := store.WriteObject(text)
+			require.NoError(t, err)
+			packSize, err := store.Commit()
+			require.NoError(t, err)
+
+			// Assert
+			assert.Less(t, packSize, int64(len(text)/10))
+			index, err := store.GetIndex()
+			require.NoError(t, err)
+			assert.Equal(t, CompressionZstd, index[hash].Compression)
+			assert.Equal(t, int64(len(text)), index[hash].UncompressedLength)
+			data, err := newStore().ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, text, data)
+		})
+	}
+}
+
+func TestIncompressibleDetection(t *testing.T) {
+	random := make([]byte, 256*1024)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	t.Run("should detect random data by its entropy", func(t *testing.T) {
+		assert.True(t, looksIncompressible(random))
+		assert.True(t, looksIncompressible(random[:8*1024]))
+	})
+
+	t.Run("should try to compress text, mixed data, and short objects", func(t *testing.T) {
+		text := bytes.Repeat([]byte("the same line of text\n"), 10000)
+		mixed := append(bytes.Repeat([]byte("header "), 20000), random[:64*1024]...)
+		assert.False(t, looksIncompressible(text))
+		assert.False(t, looksIncompressible(mixed))
+		assert.False(t, looksIncompressible(random[:1024]))
+	})
+
+	t.Run("should recognize compressed file formats by extension", func(t *testing.T) {
+		assert.True(t, HasCompressedExtension("photos/IMG_0001.JPG"))
+		assert.True(t, HasCompressedExtension("backup.tar.gz"))
+		assert.True(t, HasCompressedExtension("report.docx"))
+		assert.False(t, HasCompressedExtension("notes.txt"))
+		assert.False(t, HasCompressedExtension("Makefile"))
+	})
+
+	t.Run("should store incompressible objects without compression", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+		text := bytes.Repeat([]byte("compressible text "), 500)
+
+		// Act
+		randomHash, err := store.WriteObject(random)
+		require.NoError(t, err)
+		textHash, err := store.WriteIncompressibleObject(text)
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+
+		// Assert: the index records that both were stored as they are.
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		assert.Empty(t, index[randomHash].Compression)
+		assert.Equal(t, int64(len(random)), index[randomHash].Length)
+		assert.Empty(t, index[textHash].Compression)
+		assert.Equal(t, int64(len(text)), index[textHash].Length)
+		data, err := NewObjectStore(store.Backend()).ReadObjectAsBuffer(textHash)
+		require.NoError(t, err)
+		assert.Equal(t, text, data)
+	})
+}
+
+func TestMetadataDictionary(t *testing.T) {
+	// Arrange: a manifest and a tree like those snap writes.
+	manifest, err := json.Marshal(types.FileManifest{
+		Chunks:    []types.ChunkRef{{Hash: NewHasher(nil).GetHash([]byte("chunk")), Size: 4096}},
+		TotalSize: 4096,
+	})
+	require.NoError(t, err)
+	tree, err := json.Marshal(types.Tree{Entries: []types.TreeEntry{
+		{Name: "README.md", Hash: NewHasher(nil).GetHash([]byte("readme")), Type: "blob", Mode: 0644},
+		{Name: "src", Hash: NewHasher(nil).GetHash([]byte("src")), Type: "tree", Mode: 0755},
+	}})
+	require.NoError(t, err)
+
+	for _, object := range [][]byte{manifest, tree} {
+		t.Run(fmt.Sprintf("should compress %d bytes of metadata better with the dictionary", len(object)), func(t *testing.T) {
+			// Act
+			plain, _, err := compressObject(object, DefaultCompression(), false)
+			require.NoError(t, err)
+			compressed, algorithm, err := compressObject(object, Compression{Algorithm: CompressionLZ4}, true)
+			require.NoError(t, err)
+			decompressed, err := decompressObject(compressed, algorithm, int64(len(object)))
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, CompressionZstd, algorithm, "Metadata is always compressed with zstd")
+			assert.Less(t, len(compressed), len(plain))
+			assert.Equal(t, object, decompressed)
+			var header zstd.Header
+			require.NoError(t, header.Decode(compressed))
+			assert.Equal(t, uint32(metadataDictionaryID), header.DictionaryID)
+		})
+	}
+
+	t.Run("should store metadata objects with the dictionary", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+
+		// Act
+		hash, err := store.WriteMetadataObject(tree)
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+
+		// Assert
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		assert.Equal(t, CompressionZstd, index[hash].Compression)
+		data, err := NewObjectStore(store.Backend()).ReadObjectAsBuffer(hash)
+		require.NoError(t, err)
+		assert.Equal(t, tree, data)
+	})
+}
+
+func TestRepositoryDefaults(t *testing.T) {
+	t.Run("should default to zstd without a defaults file", func(t *testing.T) {
+		defaults, err := NewObjectStore(NewMemoryBackend()).LoadDefaults()
+		require.NoError(t, err)
+		assert.Equal(t, DefaultCompression(), defaults.CompressionOptions())
+	})
+
+	t.Run("should store defaults unencrypted for write-only backups", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		want := RepositoryDefaults{Compression: CompressionGzip, CompressionLevel: 9}
+
+		// Act
+		require.NoError(t, NewEncryptedObjectStore(backend, key).WriteDefaults(want))
+		writeOnly, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+		got, err := NewEncryptedObjectStore(backend, writeOnly).LoadDefaults()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("should refuse invalid defaults", func(t *testing.T) {
+		err := NewObjectStore(NewMemoryBackend()).WriteDefaults(RepositoryDefaults{Compression: "brotli"})
+		assert.ErrorContains(t, err, "unsupported compression")
+	})
+}
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "1024", expected: 1024},
+		{input: "500K", expected: 500 * 1024},
+		{input: "500MB", expected: 500 * 1024 * 1024},
+		{input: "10GiB", expected: 10 * 1024 * 1024 * 1024},
+		{input: "1.5g", expected: 3 * 512 * 1024 * 1024},
+		{input: "2T", expected: 2 * 1024 * 1024 * 1024 * 1024},
+		{input: "0", expected: 0},
+		{input: "-1G", wantErr: true},
+		{input: "lots", wantErr: true},
+		{input: "NaN", wantErr: true},
+		{input: "Inf", wantErr: true},
+		{input: "+InfG", wantErr: true},
+		{input: "1e30T", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			size, err := ParseSize(tc.input)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, size)
+		})
+	}
+}
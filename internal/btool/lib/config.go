@@ -24,6 +24,18 @@ const SnapsDirName = "snaps"
 // PacksDirName is the name of the subdirectory for packed object files.
 const PacksDirName = "packs"
 
+// KeysDirName is the name of the subdirectory holding the key slots of an
+// encrypted repository.
+const KeysDirName = "keys"
+
+// DataKeysDirName is the name of the subdirectory holding the session keys
+// written by backups that ran without the repository's master key.
+const DataKeysDirName = "datakeys"
+
+// IndexDirName is the name of the subdirectory holding the index, as one
+// shard per pack named by the pack's hash.
+const IndexDirName = "index"
+
 // BtoolIgnoreFilename is the name of the file containing user-defined ignore patterns.
 const BtoolIgnoreFilename = ".btoolignore"
 
@@ -31,6 +43,18 @@ const BtoolIgnoreFilename = ".btoolignore"
 // for easy swapping/testing and ensures consistency across the app.
 const HashAlgorithm = "sha256"
 
+// KeyedHashAlgorithm is the algorithm of repositories that derive object IDs
+// with a secret key, so that IDs cannot be used to fingerprint content.
+const KeyedHashAlgorithm = "hmac-sha256"
+
+// Blake3HashAlgorithm is the algorithm of repositories that derive object IDs
+// with BLAKE3, which is several times faster than SHA-256.
+const Blake3HashAlgorithm = "blake3"
+
+// KeyedBlake3HashAlgorithm is BLAKE3 in its keyed mode, the BLAKE3
+// counterpart of KeyedHashAlgorithm.
+const KeyedBlake3HashAlgorithm = "blake3-keyed"
+
 // --- Package-level Variables ---
 
 // defaultIgnorePatterns contains the essential directories that should always be ignored.
@@ -74,9 +98,9 @@ func GetPacksDir(baseDir string) string {
 	return filepath.Join(GetBtoolDir(baseDir), PacksDirName)
 }
 
-// GetIndexPath returns the absolute path to the index.json file.
-func GetIndexPath(baseDir string) string {
-	return filepath.Join(GetBtoolDir(baseDir), "index.json")
+// GetIndexDir returns the absolute path to the index subdirectory.
+func GetIndexDir(baseDir string) string {
+	return filepath.Join(GetBtoolDir(baseDir), IndexDirName)
 }
 
 // BtoolPaths holds the structured paths for the btool directory.
@@ -182,14 +206,20 @@ func loadIgnoreMatcher(baseDir string) gitignore.GitIgnore {
 		}
 	}
 
-	// 3. Clean up the patterns: remove comments and trim whitespace.
+	return compilePatterns(rawPatterns, baseDir)
+}
+
+// compilePatterns compiles lines in the syntax of .btoolignore into a
+// matcher, skipping blank lines and comments.
+func compilePatterns(rawPatterns []string, baseDir string) gitignore.GitIgnore {
+	// Clean up the patterns: remove comments and trim whitespace.
 	var finalPatterns []string
 	for _, p := range rawPatterns {
 		trimmed := strings.TrimSpace(p)
 		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
 			// Normalize Windows-style backslashes to forward slashes for cross-platform compatibility
 			trimmed = strings.ReplaceAll(trimmed, "\\", "/")
-			
+
 			// Convert directory patterns (ending with /) to glob patterns for better gitignore compatibility
 			if strings.HasSuffix(trimmed, "/") && !strings.HasSuffix(trimmed, "**/") {
 				trimmed = trimmed + "**"
@@ -198,7 +228,7 @@ func loadIgnoreMatcher(baseDir string) gitignore.GitIgnore {
 		}
 	}
 
-	// 4. Compile the patterns into a matcher.
+	// Compile the patterns into a matcher.
 	combinedPatterns := strings.Join(finalPatterns, "\n")
 	reader := strings.NewReader(combinedPatterns)
 	matcher := gitignore.New(
@@ -216,6 +246,62 @@ func loadIgnoreMatcher(baseDir string) gitignore.GitIgnore {
 	return matcher
 }
 
+// PathFilter selects the paths of a snap by include and exclude patterns in
+// the syntax of .btoolignore, matched against paths relative to the root of
+// the snap. A nil PathFilter selects every path.
+type PathFilter struct {
+	include, exclude gitignore.GitIgnore
+}
+
+// NewPathFilter returns a filter for the given include and exclude patterns,
+// or nil if there are none.
+func NewPathFilter(include, exclude []string) *PathFilter {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil
+	}
+	f := &PathFilter{}
+	if len(include) > 0 {
+		f.include = compilePatterns(include, "")
+	}
+	if len(exclude) > 0 {
+		f.exclude = compilePatterns(exclude, "")
+	}
+	return f
+}
+
+// HasIncludes tells whether the filter selects only the paths that match its
+// include patterns.
+func (f *PathFilter) HasIncludes() bool {
+	return f != nil && f.include != nil
+}
+
+// Includes tells whether an include pattern matches p, which is true of
+// every path when there are none.
+func (f *PathFilter) Includes(p string, isDir bool) bool {
+	if !f.HasIncludes() {
+		return true
+	}
+	return patternsMatch(f.include, p, isDir)
+}
+
+// Excludes tells whether an exclude pattern matches p.
+func (f *PathFilter) Excludes(p string, isDir bool) bool {
+	if f == nil || f.exclude == nil {
+		return false
+	}
+	return patternsMatch(f.exclude, p, isDir)
+}
+
+// patternsMatch tells whether the last of the patterns that matches p, with
+// forward slashes, selects it rather than negating an earlier one. Access is
+// serialized, as with IsPathIgnored.
+func patternsMatch(matcher gitignore.GitIgnore, p string, isDir bool) bool {
+	cacheMutex.Lock()
+	defer cacheMutex.Unlock()
+	match := matcher.Relative(p, isDir)
+	return match != nil && match.Ignore()
+}
+
 // ResetIgnoreState clears the ignore cache. This is used for testing.
 func ResetIgnoreState() {
 	cacheMutex.Lock()
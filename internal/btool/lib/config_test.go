@@ -210,3 +210,29 @@ func TestIgnoreConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestPathFilter(t *testing.T) {
+	testCases := []struct {
+		name             string
+		include, exclude []string
+		path             string
+		isDir            bool
+		wantIncluded     bool
+		wantExcluded     bool
+	}{
+		{name: "No patterns include everything", path: "a/b.txt", wantIncluded: true},
+		{name: "Extension glob at any depth", include: []string{"*.sql"}, path: "db/dump.sql", wantIncluded: true},
+		{name: "Extension glob does not match other files", include: []string{"*.sql"}, path: "db/notes.txt"},
+		{name: "Directory pattern matches files below", exclude: []string{"logs/"}, path: "logs/app.log", wantIncluded: true, wantExcluded: true},
+		{name: "Negation overrides an earlier pattern", include: []string{"*.sql", "!old.sql"}, path: "old.sql"},
+		{name: "Directory name matches the directory", exclude: []string{"node_modules"}, path: "web/node_modules", isDir: true, wantIncluded: true, wantExcluded: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter := NewPathFilter(tc.include, tc.exclude)
+			assert.Equal(t, tc.wantIncluded, filter.Includes(tc.path, tc.isDir))
+			assert.Equal(t, tc.wantExcluded, filter.Excludes(tc.path, tc.isDir))
+		})
+	}
+}
@@ -0,0 +1,724 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// Parameters of the at-rest encryption. Every object is sealed with
+// AES-256-GCM, using a fresh random nonce, under either the repository's
+// master key or, for backups that run without it, a per-session key.
+const (
+	masterKeySize = 32 // AES-256.
+	// envelopeVersion marks data sealed with the master key.
+	envelopeVersion = 1
+	// sessionEnvelopeVersion marks data sealed with a session key, whose ID
+	// follows the version byte.
+	sessionEnvelopeVersion = 2
+	sessionIDSize          = 8
+	// dataKeyEnvelopeVersion marks data sealed with a data key from the
+	// keyring, whose ID follows the version byte.
+	dataKeyEnvelopeVersion = 3
+	dataKeyIDSize          = 8
+)
+
+// Cost parameters of password slots that use scrypt, which new slots only do
+// when asked to. N=2^15 takes roughly 100ms on current hardware.
+const (
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+	kdfSaltLen = 32
+)
+
+// Types of key slot.
+const (
+	passwordSlot = "password"
+	ageSlot      = "age"
+	kmsSlot      = "kms"
+)
+
+// ErrWrongPassword is returned when a password does not open any key slot of
+// an encrypted repository.
+var ErrWrongPassword = errors.New("wrong password: it does not unlock any key of the repository")
+
+// ErrNoMatchingIdentity is returned when none of the given age identities
+// opens a key slot of an encrypted repository.
+var ErrNoMatchingIdentity = errors.New("none of the age identities unlocks a key of the repository")
+
+// ErrNoKMSKey is returned when no KMS key slot of an encrypted repository
+// could be unwrapped.
+var ErrNoKMSKey = errors.New("no KMS key of the repository could be unwrapped")
+
+// ErrWriteOnly is returned when reading data that a write-only key cannot
+// decrypt.
+var ErrWriteOnly = errors.New("this repository can only be written without a password or age identity")
+
+// RepositoryKey encrypts and decrypts the data stored in an encrypted
+// repository. It is safe for concurrent use.
+//
+// A key unlocked with a password or age identity holds the master key and can
+// read everything. A write-only key, opened with nothing but the repository's
+// public age recipient, encrypts under a fresh session key that only master
+// key holders can recover, and can read back nothing but its own writes.
+type RepositoryKey struct {
+	masterKey []byte              // Nil for write-only keys.
+	master    cipher.AEAD         // Nil for write-only keys.
+	slotID    string              // The key slot that was unlocked, if any.
+	password  string              // The password that unlocked it, if any, to rewrap its slot on rotation.
+	kdf       KDFParams           // For new password slots; the zero value means DefaultKDF.
+	identity  *age.X25519Identity // Recovers session keys; nil if the repository has none.
+	backend   Backend
+
+	sessionID []byte      // Set for write-only keys.
+	session   cipher.AEAD // Set for write-only keys.
+
+	// dataKeyID and dataKey seal new data once the key has been rotated;
+	// until then the master key does. dataKeys holds every data key in the
+	// keyring, by hex ID.
+	dataKeyID []byte
+	dataKey   cipher.AEAD
+	dataKeys  map[string]cipher.AEAD
+	// oldMasters and oldIdentities are the master keys and repository
+	// identities the repository had before rotating to the current ones,
+	// which still open what they sealed until it is re-encrypted.
+	oldMasters    []cipher.AEAD
+	oldIdentities []age.Identity
+
+	mutex       sync.Mutex
+	sessionKeys map[string]cipher.AEAD // Session keys already recovered, by hex ID.
+}
+
+// newAEAD returns AES-256-GCM under key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts plaintext into an envelope: the header (a version byte and
+// any key ID), a random nonce, and the ciphertext with its authentication tag.
+func seal(aead cipher.AEAD, header, plaintext []byte) ([]byte, error) {
+	envelope := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(envelope, header)
+	nonce := envelope[len(header):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(envelope, nonce, plaintext, nil), nil
+}
+
+// open decrypts and authenticates an envelope produced by seal.
+func open(aead cipher.AEAD, headerSize int, envelope []byte) ([]byte, error) {
+	if len(envelope) < headerSize+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	nonce := envelope[headerSize : headerSize+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, envelope[headerSize+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("encrypted data failed authentication: it is corrupt or was tampered with")
+	}
+	return plaintext, nil
+}
+
+// CanRead reports whether the key can decrypt everything in the repository,
+// rather than only its own writes.
+func (k *RepositoryKey) CanRead() bool {
+	return k.master != nil
+}
+
+// Seal encrypts plaintext for storage in the repository.
+func (k *RepositoryKey) Seal(plaintext []byte) ([]byte, error) {
+	if k.dataKey != nil {
+		return seal(k.dataKey, append([]byte{dataKeyEnvelopeVersion}, k.dataKeyID...), plaintext)
+	}
+	if k.master != nil {
+		return seal(k.master, []byte{envelopeVersion}, plaintext)
+	}
+	return seal(k.session, append([]byte{sessionEnvelopeVersion}, k.sessionID...), plaintext)
+}
+
+// Open decrypts data produced by Seal, failing if it was modified.
+func (k *RepositoryKey) Open(envelope []byte) ([]byte, error) {
+	if len(envelope) == 0 {
+		return nil, errors.New("encrypted data is truncated")
+	}
+	switch envelope[0] {
+	case envelopeVersion:
+		if k.master == nil {
+			return nil, ErrWriteOnly
+		}
+		return k.openWithMasterKeys(envelope)
+	case sessionEnvelopeVersion:
+		if len(envelope) < 1+sessionIDSize {
+			return nil, errors.New("encrypted data is truncated")
+		}
+		aead, err := k.sessionKey(envelope[1 : 1+sessionIDSize])
+		if err != nil {
+			return nil, err
+		}
+		return open(aead, 1+sessionIDSize, envelope)
+	case dataKeyEnvelopeVersion:
+		if len(envelope) < 1+dataKeyIDSize {
+			return nil, errors.New("encrypted data is truncated")
+		}
+		if k.master == nil {
+			return nil, ErrWriteOnly
+		}
+		id := hex.EncodeToString(envelope[1 : 1+dataKeyIDSize])
+		aead, ok := k.dataKeys[id]
+		if !ok {
+			return nil, fmt.Errorf("encrypted data uses data key %s, which is not in the keyring; it may have been retired", id)
+		}
+		return open(aead, 1+dataKeyIDSize, envelope)
+	default:
+		return nil, fmt.Errorf("unsupported encryption envelope version %d", envelope[0])
+	}
+}
+
+// openWithMasterKeys opens an envelope sealed with the master key, or with
+// one the repository had before rotating, which does not record which.
+func (k *RepositoryKey) openWithMasterKeys(envelope []byte) ([]byte, error) {
+	plaintext, err := open(k.master, 1, envelope)
+	for _, master := range k.oldMasters {
+		if err == nil {
+			break
+		}
+		plaintext, err = open(master, 1, envelope)
+	}
+	return plaintext, err
+}
+
+// sessionKey returns the session key with the given ID, recovering it from
+// the repository with the repository identity the first time it is needed.
+func (k *RepositoryKey) sessionKey(id []byte) (cipher.AEAD, error) {
+	if k.session != nil && bytes.Equal(id, k.sessionID) {
+		return k.session, nil
+	}
+	if k.identity == nil {
+		return nil, ErrWriteOnly
+	}
+
+	hexID := hex.EncodeToString(id)
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if aead, ok := k.sessionKeys[hexID]; ok {
+		return aead, nil
+	}
+
+	wrapped, err := k.backend.Get(DataKeysDirName + "/" + hexID)
+	if err != nil {
+		return nil, fmt.Errorf("could not read session key %s: %w", hexID, err)
+	}
+	reader, err := age.Decrypt(bytes.NewReader(wrapped), append([]age.Identity{k.identity}, k.oldIdentities...)...)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt session key %s: %w", hexID, err)
+	}
+	sessionKey, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt session key %s: %w", hexID, err)
+	}
+	aead, err := newAEAD(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if k.sessionKeys == nil {
+		k.sessionKeys = make(map[string]cipher.AEAD)
+	}
+	k.sessionKeys[hexID] = aead
+	return aead, nil
+}
+
+// keySlot is the stored form of one copy of the master key. A password slot
+// wraps it under a key derived from the password; an age slot encrypts it to
+// an age recipient; a KMS slot holds it as wrapped by a cloud KMS key. A
+// repository has one slot per password, recipient, or KMS key.
+type keySlot struct {
+	Type string `json:"type,omitempty"` // passwordSlot (the default), ageSlot, or kmsSlot.
+	KDF  string `json:"kdf,omitempty"`
+	N    int    `json:"n,omitempty"`
+	R    int    `json:"r,omitempty"`
+	P    int    `json:"p,omitempty"`
+	// Memory (in KiB), Iterations, and Parallelism are the argon2id costs.
+	Memory      uint32 `json:"memory,omitempty"`
+	Iterations  uint32 `json:"iterations,omitempty"`
+	Parallelism uint8  `json:"parallelism,omitempty"`
+	Salt        []byte `json:"salt,omitempty"`
+	Recipient   string `json:"recipient,omitempty"`
+	KMS         string `json:"kms,omitempty"`     // The KMS key URI, for KMS slots.
+	Created     string `json:"created,omitempty"` // RFC 3339.
+	// Key is the wrapped master key.
+	Key []byte `json:"key"`
+}
+
+// kdfParams returns the key derivation parameters recorded in a password slot.
+func (slot *keySlot) kdfParams() KDFParams {
+	return KDFParams{Algorithm: slot.KDF, Memory: slot.Memory, Iterations: slot.Iterations, Parallelism: slot.Parallelism}
+}
+
+// wrappingKey derives the AEAD that seals the master key in a password slot.
+// The slot's costs are checked first, since anyone who can write to the
+// repository can change them.
+func (slot *keySlot) wrappingKey(password string) (cipher.AEAD, error) {
+	if slot.KDF == KDFScrypt && (slot.N != scryptN || slot.R != scryptR || slot.P != scryptP) {
+		return nil, fmt.Errorf("unsupported scrypt parameters N=%d r=%d p=%d", slot.N, slot.R, slot.P)
+	}
+	params := slot.kdfParams()
+	if err := params.Validate(); err != nil {
+		return nil, fmt.Errorf("key slot has unusable key derivation parameters: %w", err)
+	}
+	derived, err := params.deriveKey(password, slot.Salt)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(derived)
+}
+
+// newPasswordSlot wraps masterKey under password, with a key derived as kdf
+// specifies.
+func newPasswordSlot(masterKey []byte, password string, kdf KDFParams) (*keySlot, error) {
+	slot := &keySlot{Type: passwordSlot, KDF: kdf.Algorithm, Salt: make([]byte, kdfSaltLen)}
+	if kdf.Algorithm == KDFScrypt {
+		slot.N, slot.R, slot.P = scryptN, scryptR, scryptP
+	} else {
+		slot.Memory, slot.Iterations, slot.Parallelism = kdf.Memory, kdf.Iterations, kdf.Parallelism
+	}
+	if _, err := rand.Read(slot.Salt); err != nil {
+		return nil, err
+	}
+	aead, err := slot.wrappingKey(password)
+	if err != nil {
+		return nil, err
+	}
+	if slot.Key, err = seal(aead, []byte{envelopeVersion}, masterKey); err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// ageEncrypt encrypts data to an age recipient.
+func ageEncrypt(recipient age.Recipient, data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer, err := age.Encrypt(&buffer, recipient)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// newAgeSlot encrypts masterKey to recipient.
+func newAgeSlot(masterKey []byte, recipient *age.X25519Recipient) (*keySlot, error) {
+	wrapped, err := ageEncrypt(recipient, masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keySlot{Type: ageSlot, Recipient: recipient.String(), Key: wrapped}, nil
+}
+
+// newKMSSlot wraps masterKey with a KMS key.
+func newKMSSlot(masterKey []byte, kmsKey *KMSKey) (*keySlot, error) {
+	wrapped, err := kmsKey.Wrap(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	return &keySlot{Type: kmsSlot, KMS: kmsKey.URI(), Key: wrapped}, nil
+}
+
+// keySlotName returns the backend name of the key slot with the given ID.
+func keySlotName(id string) string {
+	return KeysDirName + "/" + id
+}
+
+// randomHex returns n random bytes, hex encoded.
+func randomHex(n int) (string, error) {
+	id := make([]byte, n)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// putKeySlot stores slot under a new random ID and returns the ID.
+func putKeySlot(backend Backend, slot *keySlot) (string, error) {
+	slot.Created = time.Now().UTC().Format(time.RFC3339)
+	slotJSON, err := json.MarshalIndent(slot, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return "", err
+	}
+	if err := backend.Put(keySlotName(id), slotJSON); err != nil {
+		return "", fmt.Errorf("could not store repository key: %w", err)
+	}
+	return id, nil
+}
+
+// repositoryRecipient is the stored form of the repository's own age key
+// pair. Backups without the master key encrypt their session keys to the
+// public recipient; the matching identity is sealed under the master key.
+type repositoryRecipient struct {
+	Recipient string `json:"recipient"`
+	Identity  []byte `json:"identity"`
+}
+
+// IsEncrypted reports whether the repository in backend is encrypted, which
+// is the case once it has any key slots.
+func IsEncrypted(backend Backend) (bool, error) {
+	entries, err := backend.List(KeysDirName)
+	if err != nil {
+		return false, err
+	}
+	return len(entries) > 0, nil
+}
+
+// InitRepositoryKey turns an empty repository into an encrypted one: it
+// generates a random master key and stores it in a key slot protected by
+// password, if given, and in one slot for each age recipient.
+func InitRepositoryKey(backend Backend, password string, recipients ...*age.X25519Recipient) (*RepositoryKey, error) {
+	return InitRepositoryKeyWithKDF(backend, password, DefaultKDF(), recipients...)
+}
+
+// InitRepositoryKeyWithKDF is InitRepositoryKey with the key derivation
+// parameters of the password slot, which the returned key also uses for any
+// password slots it adds.
+func InitRepositoryKeyWithKDF(backend Backend, password string, kdf KDFParams, recipients ...*age.X25519Recipient) (*RepositoryKey, error) {
+	return CreateRepositoryKey(backend, RepositoryKeySpec{Password: password, KDF: kdf, Recipients: recipients})
+}
+
+// RepositoryKeySpec lists the key slots that a new encrypted repository
+// starts with. At least one is required.
+type RepositoryKeySpec struct {
+	Password string
+	// KDF derives the password slot's key, and those of password slots the
+	// returned key adds. The zero value selects DefaultKDF.
+	KDF        KDFParams
+	Recipients []*age.X25519Recipient
+	KMSKeys    []*KMSKey
+}
+
+// CreateRepositoryKey turns an empty repository into an encrypted one: it
+// generates a random master key and stores it in one key slot for each
+// password, age recipient, and KMS key of spec.
+func CreateRepositoryKey(backend Backend, spec RepositoryKeySpec) (*RepositoryKey, error) {
+	if spec.Password == "" && len(spec.Recipients) == 0 && len(spec.KMSKeys) == 0 {
+		return nil, errors.New("an encrypted repository needs a non-empty password, an age recipient, or a KMS key")
+	}
+	kdf := spec.KDF
+	if kdf.Algorithm == "" {
+		kdf = DefaultKDF()
+	}
+	if err := kdf.Validate(); err != nil {
+		return nil, err
+	}
+	masterKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return nil, err
+	}
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wrapping can fail, with a KMS, so it happens before anything is stored.
+	var slots []*keySlot
+	if spec.Password != "" {
+		slot, err := newPasswordSlot(masterKey, spec.Password, kdf)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	for _, recipient := range spec.Recipients {
+		slot, err := newAgeSlot(masterKey, recipient)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+	for _, kmsKey := range spec.KMSKeys {
+		slot, err := newKMSSlot(masterKey, kmsKey)
+		if err != nil {
+			return nil, err
+		}
+		slots = append(slots, slot)
+	}
+
+	// The repository key pair comes first: the repository only counts as
+	// encrypted once a slot exists.
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, err
+	}
+	sealedIdentity, err := seal(master, []byte{envelopeVersion}, []byte(identity.String()))
+	if err != nil {
+		return nil, err
+	}
+	recipientJSON, err := json.MarshalIndent(repositoryRecipient{Recipient: identity.Recipient().String(), Identity: sealedIdentity}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Put(RecipientFileName, recipientJSON); err != nil {
+		return nil, fmt.Errorf("could not store repository recipient: %w", err)
+	}
+
+	key := &RepositoryKey{masterKey: masterKey, master: master, password: spec.Password, identity: identity, backend: backend, kdf: kdf}
+	for _, slot := range slots {
+		id, err := putKeySlot(backend, slot)
+		if err != nil {
+			return nil, err
+		}
+		if key.slotID == "" {
+			key.slotID = id
+		}
+	}
+	return key, nil
+}
+
+// unlockRepositoryKey recovers the master key by offering each key slot to
+// unwrap, which returns nil for slots it cannot open.
+func unlockRepositoryKey(backend Backend, unwrap func(slot *keySlot) ([]byte, error), errNoMatch error) (*RepositoryKey, error) {
+	entries, err := backend.List(KeysDirName)
+	if err != nil {
+		return nil, err
+	}
+	var openErr error
+	for _, entry := range entries {
+		content, err := backend.Get(keySlotName(entry.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not read key %s: %w", entry.Name, err)
+		}
+		var slot keySlot
+		if err := json.Unmarshal(content, &slot); err != nil {
+			return nil, fmt.Errorf("could not parse key %s: %w", entry.Name, err)
+		}
+		masterKey, err := unwrap(&slot)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", entry.Name, err)
+		}
+		if masterKey != nil {
+			// A rotation that was interrupted can leave slots whose master
+			// key opens nothing yet, next to the ones that still work.
+			key, err := openRepositoryKey(backend, masterKey)
+			if err != nil {
+				openErr = err
+				continue
+			}
+			key.slotID = entry.Name
+			return key, nil
+		}
+	}
+	if openErr != nil {
+		return nil, openErr
+	}
+	return nil, errNoMatch
+}
+
+// openRepositoryKey builds the key for a recovered master key, along with the
+// repository identity that recovers the session keys of write-only backups.
+func openRepositoryKey(backend Backend, masterKey []byte) (*RepositoryKey, error) {
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	key := &RepositoryKey{masterKey: masterKey, master: master, backend: backend}
+	if err := key.loadKeyring(); err != nil {
+		return nil, err
+	}
+	if key.identity != nil {
+		return key, nil // A rotated keyring holds the identities.
+	}
+
+	content, err := backend.Get(RecipientFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return key, nil // Created before write-only backups existed.
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read repository recipient: %w", err)
+	}
+	var recipient repositoryRecipient
+	if err := json.Unmarshal(content, &recipient); err != nil {
+		return nil, fmt.Errorf("could not parse repository recipient: %w", err)
+	}
+	identity, err := open(master, 1, recipient.Identity)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt repository identity: %w", err)
+	}
+	if key.identity, err = age.ParseX25519Identity(string(identity)); err != nil {
+		return nil, fmt.Errorf("could not parse repository identity: %w", err)
+	}
+	return key, nil
+}
+
+// UnlockRepositoryKey recovers the master key of an encrypted repository by
+// trying password against each password slot. It returns ErrWrongPassword if
+// no slot opens.
+func UnlockRepositoryKey(backend Backend, password string) (*RepositoryKey, error) {
+	key, err := unlockRepositoryKey(backend, func(slot *keySlot) ([]byte, error) {
+		if slot.Type != "" && slot.Type != passwordSlot {
+			return nil, nil
+		}
+		aead, err := slot.wrappingKey(password)
+		if err != nil {
+			return nil, err
+		}
+		masterKey, err := open(aead, 1, slot.Key)
+		if err != nil {
+			return nil, nil
+		}
+		return masterKey, nil
+	}, ErrWrongPassword)
+	if err != nil {
+		return nil, err
+	}
+	key.password = password
+	return key, nil
+}
+
+// UnlockRepositoryKeyWithIdentities recovers the master key of an encrypted
+// repository with age identities, trying them against each age slot. It
+// returns ErrNoMatchingIdentity if no slot opens.
+func UnlockRepositoryKeyWithIdentities(backend Backend, identities []age.Identity) (*RepositoryKey, error) {
+	return unlockRepositoryKey(backend, func(slot *keySlot) ([]byte, error) {
+		if slot.Type != ageSlot {
+			return nil, nil
+		}
+		reader, err := age.Decrypt(bytes.NewReader(slot.Key), identities...)
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(reader)
+	}, ErrNoMatchingIdentity)
+}
+
+// UnlockRepositoryKeyWithKMS recovers the master key of an encrypted
+// repository by asking the KMS of each KMS slot to unwrap it. It returns
+// ErrNoKMSKey, along with the last failure, if none does.
+func UnlockRepositoryKeyWithKMS(backend Backend) (*RepositoryKey, error) {
+	return unlockRepositoryKeyWithKMS(backend, ParseKMSKey)
+}
+
+// unlockRepositoryKeyWithKMS is UnlockRepositoryKeyWithKMS with the KMS keys
+// of the slots resolved by parse.
+func unlockRepositoryKeyWithKMS(backend Backend, parse func(uri string) (*KMSKey, error)) (*RepositoryKey, error) {
+	var lastErr error
+	key, err := unlockRepositoryKey(backend, func(slot *keySlot) ([]byte, error) {
+		if slot.Type != kmsSlot {
+			return nil, nil
+		}
+		kmsKey, err := parse(slot.KMS)
+		if err != nil {
+			return nil, err
+		}
+		// Another slot may use a KMS these credentials can reach.
+		masterKey, err := kmsKey.Unwrap(slot.Key)
+		if err != nil {
+			lastErr = err
+			return nil, nil
+		}
+		if len(masterKey) != masterKeySize {
+			return nil, fmt.Errorf("%s unwrapped a %d-byte key", slot.KMS, len(masterKey))
+		}
+		return masterKey, nil
+	}, ErrNoKMSKey)
+	if errors.Is(err, ErrNoKMSKey) && lastErr != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNoKMSKey, lastErr)
+	}
+	return key, err
+}
+
+// HasKMSSlots reports whether the repository in backend has any KMS key slots.
+func HasKMSSlots(backend Backend) (bool, error) {
+	entries, err := backend.List(KeysDirName)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		content, err := backend.Get(keySlotName(entry.Name))
+		if err != nil {
+			return false, fmt.Errorf("could not read key %s: %w", entry.Name, err)
+		}
+		var slot keySlot
+		if err := json.Unmarshal(content, &slot); err != nil {
+			return false, fmt.Errorf("could not parse key %s: %w", entry.Name, err)
+		}
+		if slot.Type == kmsSlot {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// OpenWriteOnlyKey returns a key for backing up to an encrypted repository
+// without any of its secrets. It creates a random session key, stores it
+// encrypted to the repository's public recipient, and encrypts everything it
+// writes with it. Only holders of the master key can read that data back.
+func OpenWriteOnlyKey(backend Backend) (*RepositoryKey, error) {
+	content, err := backend.Get(RecipientFileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read repository recipient: %w", err)
+	}
+	var stored repositoryRecipient
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return nil, fmt.Errorf("could not parse repository recipient: %w", err)
+	}
+	recipient, err := age.ParseX25519Recipient(stored.Recipient)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse repository recipient: %w", err)
+	}
+
+	sessionKey := make([]byte, masterKeySize)
+	sessionID := make([]byte, sessionIDSize)
+	if _, err := rand.Read(sessionKey); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(sessionID); err != nil {
+		return nil, err
+	}
+	session, err := newAEAD(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := ageEncrypt(recipient, sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := backend.Put(DataKeysDirName+"/"+hex.EncodeToString(sessionID), wrapped); err != nil {
+		return nil, fmt.Errorf("could not store session key: %w", err)
+	}
+	return &RepositoryKey{backend: backend, sessionID: sessionID, session: session}, nil
+}
+
+// SessionID returns the hex ID of a write-only key's session, or an empty
+// string for keys that hold the master key.
+func (k *RepositoryKey) SessionID() string {
+	if k.session == nil {
+		return ""
+	}
+	return hex.EncodeToString(k.sessionID)
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryKey(t *testing.T) {
+	master, err := newAEAD(make([]byte, masterKeySize))
+	require.NoError(t, err)
+	key := &RepositoryKey{master: master}
+
+	t.Run("should round-trip data", func(t *testing.T) {
+		// Act
+		sealed, err := key.Seal([]byte("secret data"))
+		require.NoError(t, err)
+		opened, err := key.Open(sealed)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []byte("secret data"), opened)
+		assert.NotContains(t, string(sealed), "secret data")
+	})
+
+	t.Run("should use a fresh nonce for every seal", func(t *testing.T) {
+		first, err := key.Seal([]byte("same"))
+		require.NoError(t, err)
+		second, err := key.Seal([]byte("same"))
+		require.NoError(t, err)
+		assert.NotEqual(t, first, second)
+	})
+
+	t.Run("should reject tampered or truncated data", func(t *testing.T) {
+		// Arrange
+		sealed, err := key.Seal([]byte("secret data"))
+		require.NoError(t, err)
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(tampered)-1] ^= 1
+
+		// Act & Assert
+		_, err = key.Open(tampered)
+		assert.ErrorContains(t, err, "failed authentication")
+		_, err = key.Open(sealed[:10])
+		assert.ErrorContains(t, err, "truncated")
+	})
+}
+
+func TestInitAndUnlockRepositoryKey(t *testing.T) {
+	// Arrange
+	backend := NewMemoryBackend()
+	encrypted, err := IsEncrypted(backend)
+	require.NoError(t, err)
+	require.False(t, encrypted)
+
+	key, err := InitRepositoryKey(backend, "correct horse")
+	require.NoError(t, err)
+	sealed, err := key.Seal([]byte("data"))
+	require.NoError(t, err)
+
+	t.Run("should mark the repository as encrypted", func(t *testing.T) {
+		encrypted, err := IsEncrypted(backend)
+		require.NoError(t, err)
+		assert.True(t, encrypted)
+	})
+
+	t.Run("should unlock the same master key with the password", func(t *testing.T) {
+		// Act
+		unlocked, err := UnlockRepositoryKey(backend, "correct horse")
+		require.NoError(t, err)
+		opened, err := unlocked.Open(sealed)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), opened)
+	})
+
+	t.Run("should reject a wrong password", func(t *testing.T) {
+		_, err := UnlockRepositoryKey(backend, "battery staple")
+		assert.True(t, errors.Is(err, ErrWrongPassword), "Expected ErrWrongPassword, got %v", err)
+	})
+
+	t.Run("should refuse an empty password", func(t *testing.T) {
+		_, err := InitRepositoryKey(NewMemoryBackend(), "")
+		assert.Error(t, err)
+	})
+}
+
+func TestAgeRepositoryKey(t *testing.T) {
+	// Arrange: a repository encrypted to one age recipient only.
+	backend := NewMemoryBackend()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	key, err := InitRepositoryKey(backend, "", identity.Recipient())
+	require.NoError(t, err)
+	masterSealed, err := key.Seal([]byte("written with the master key"))
+	require.NoError(t, err)
+
+	t.Run("should unlock with the matching identity", func(t *testing.T) {
+		unlocked, err := UnlockRepositoryKeyWithIdentities(backend, []age.Identity{identity})
+		require.NoError(t, err)
+		opened, err := unlocked.Open(masterSealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("written with the master key"), opened)
+	})
+
+	t.Run("should reject other identities and passwords", func(t *testing.T) {
+		other, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		_, err = UnlockRepositoryKeyWithIdentities(backend, []age.Identity{other})
+		assert.ErrorIs(t, err, ErrNoMatchingIdentity)
+		_, err = UnlockRepositoryKey(backend, "")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	})
+
+	t.Run("should write without secrets and read back only with the identity", func(t *testing.T) {
+		// Arrange
+		writeOnly, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+		require.False(t, writeOnly.CanRead())
+
+		// Act
+		sealed, err := writeOnly.Seal([]byte("written without secrets"))
+		require.NoError(t, err)
+
+		// Assert: the writer reads its own data, but nothing else.
+		opened, err := writeOnly.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("written without secrets"), opened)
+		_, err = writeOnly.Open(masterSealed)
+		assert.ErrorIs(t, err, ErrWriteOnly)
+
+		// Assert: the identity holder reads the session's data.
+		unlocked, err := UnlockRepositoryKeyWithIdentities(backend, []age.Identity{identity})
+		require.NoError(t, err)
+		opened, err = unlocked.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("written without secrets"), opened)
+	})
+}
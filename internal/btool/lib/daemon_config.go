@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The commands a daemon job can run.
+const (
+	DaemonCommandSnap  = "snap"
+	DaemonCommandPrune = "prune"
+)
+
+// DaemonJob is a command that the daemon runs on a schedule.
+type DaemonJob struct {
+	// Name identifies the job in messages; it defaults to the command and
+	// directory.
+	Name string `json:"name,omitempty"`
+	// Schedule is when the job runs, in the syntax of ParseSchedule.
+	Schedule string `json:"schedule"`
+	// Command is the command to run: snap or prune.
+	Command string `json:"command"`
+	// Directory is the directory to snap, or whose repository to prune. A
+	// leading ~ stands for the home directory.
+	Directory string `json:"directory"`
+	// Repo is the repository location, as with --repo. It defaults to the
+	// daemon's own --repo, then .btool in Directory.
+	Repo string `json:"repo,omitempty"`
+	// Message is the message of the snaps of a snap job.
+	Message string `json:"message,omitempty"`
+	// SkipIfUnchanged makes a snap job create no snap when nothing changed
+	// since the latest one.
+	SkipIfUnchanged bool `json:"skipIfUnchanged,omitempty"`
+	// SpecialFiles makes a snap job include FIFOs, sockets, and device nodes.
+	SpecialFiles bool `json:"specialFiles,omitempty"`
+	// Streams makes a snap job include the alternate data streams of files.
+	Streams bool `json:"streams,omitempty"`
+	// KeepLast is how many of the most recent snaps a prune job keeps.
+	KeepLast int `json:"keepLast,omitempty"`
+
+	parsed Schedule
+}
+
+// ParsedSchedule returns the job's parsed schedule.
+func (j DaemonJob) ParsedSchedule() Schedule {
+	return j.parsed
+}
+
+// DaemonConfig lists the jobs of the daemon.
+type DaemonConfig struct {
+	Jobs []DaemonJob `json:"jobs"`
+}
+
+// DefaultDaemonConfigFile returns where the daemon reads its config by
+// default: btool/daemon.json in the user's config directory.
+func DefaultDaemonConfigFile() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "btool", "daemon.json"), nil
+}
+
+// LoadDaemonConfig reads the daemon config from a JSON file, checking its
+// jobs and expanding ~ in their directories.
+func LoadDaemonConfig(fileName string) (DaemonConfig, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return DaemonConfig{}, fmt.Errorf("could not read daemon config: %w", err)
+	}
+	var config DaemonConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return DaemonConfig{}, fmt.Errorf("could not parse daemon config %s: %w", fileName, err)
+	}
+	if len(config.Jobs) == 0 {
+		return DaemonConfig{}, fmt.Errorf("daemon config %s has no jobs", fileName)
+	}
+	for i := range config.Jobs {
+		job := &config.Jobs[i]
+		if job.Directory == "" {
+			return DaemonConfig{}, fmt.Errorf("job %d in %s has no directory", i+1, fileName)
+		}
+		if rest, ok := strings.CutPrefix(job.Directory, "~"); ok && (rest == "" || os.IsPathSeparator(rest[0])) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return DaemonConfig{}, err
+			}
+			job.Directory = home + rest
+		}
+		if job.Name == "" {
+			job.Name = job.Command + " " + job.Directory
+		}
+		switch {
+		case job.Command != DaemonCommandSnap && job.Command != DaemonCommandPrune:
+			return DaemonConfig{}, fmt.Errorf("job %d (%s) in %s has unknown command %q; use %s or %s",
+				i+1, job.Name, fileName, job.Command, DaemonCommandSnap, DaemonCommandPrune)
+		case job.Command == DaemonCommandPrune && job.KeepLast < 1:
+			return DaemonConfig{}, fmt.Errorf("job %d (%s) in %s prunes without keepLast; it must keep at least one snap", i+1, job.Name, fileName)
+		}
+		if job.parsed, err = ParseSchedule(job.Schedule); err != nil {
+			return DaemonConfig{}, fmt.Errorf("job %d (%s) in %s: %w", i+1, job.Name, fileName, err)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadDaemonConfig(t *testing.T) {
+	// writeConfig writes content to a config file and returns its name.
+	writeConfig := func(t *testing.T, content string) string {
+		fileName := filepath.Join(t.TempDir(), "daemon.json")
+		require.NoError(t, os.WriteFile(fileName, []byte(content), 0600))
+		return fileName
+	}
+
+	t.Run("should load jobs, expanding ~ and naming them", func(t *testing.T) {
+		// Arrange
+		home, err := os.UserHomeDir()
+		require.NoError(t, err)
+		fileName := writeConfig(t, `{"jobs": [
+			{"schedule": "@hourly", "command": "snap", "directory": "~/docs"},
+			{"name": "trim", "schedule": "0 3 * * 0", "command": "prune", "directory": "/srv/data", "keepLast": 24}
+		]}`)
+
+		// Act
+		config, err := LoadDaemonConfig(fileName)
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, config.Jobs, 2)
+		assert.Equal(t, filepath.Join(home, "docs"), filepath.Clean(config.Jobs[0].Directory))
+		assert.Equal(t, "snap "+config.Jobs[0].Directory, config.Jobs[0].Name)
+		assert.Equal(t, "trim", config.Jobs[1].Name)
+		assert.Equal(t, 24, config.Jobs[1].KeepLast)
+		assert.False(t, config.Jobs[1].ParsedSchedule().Next(time.Now()).IsZero(), "The schedule should be parsed")
+	})
+
+	t.Run("should refuse invalid jobs", func(t *testing.T) {
+		cases := map[string]string{
+			"no jobs":            `{"jobs": []}`,
+			"no directory":       `{"jobs": [{"schedule": "@daily", "command": "snap"}]}`,
+			"unknown command":    `{"jobs": [{"schedule": "@daily", "command": "restore", "directory": "/x"}]}`,
+			"prune keeping none": `{"jobs": [{"schedule": "@daily", "command": "prune", "directory": "/x"}]}`,
+			"bad schedule":       `{"jobs": [{"schedule": "daily", "command": "snap", "directory": "/x"}]}`,
+			"not JSON":           `jobs:`,
+		}
+		for name, content := range cases {
+			_, err := LoadDaemonConfig(writeConfig(t, content))
+			assert.Error(t, err, name)
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package lib
+
+import (
+	"errors"
+	"io"
+)
+
+// FastCDC (Xia et al., "FastCDC: a Fast and Efficient Content-Defined
+// Chunking Approach for Data Deduplication", USENIX ATC 2016) finds chunk
+// boundaries with a gear hash, which takes a shift and an add per byte where
+// Rabin fingerprinting takes a table lookup for the byte leaving its window
+// as well. It skips the first minChunkSize bytes of each chunk, and
+// normalizes chunk sizes towards avgChunkSize by using a stricter mask before
+// that size and a looser one after it.
+const (
+	// fastCDCMaskSmall has two bits more than avgChunkSize needs, which makes
+	// cuts before avgChunkSize unlikely, and fastCDCMaskLarge two bits fewer,
+	// which makes them likely after it. The gear hash shifts the oldest bytes
+	// up, so the top bits depend on the most data.
+	fastCDCMaskSmall = uint64(1<<15-1) << (64 - 15)
+	fastCDCMaskLarge = uint64(1<<11-1) << (64 - 11)
+)
+
+// gearTable maps each byte to a random value for the gear hash. It is
+// generated from a fixed seed and must never change, since that would move
+// every chunk boundary in repositories chunked with FastCDC.
+var gearTable = func() (table [256]uint64) {
+	// splitmix64, which turns a counter into well-mixed values.
+	state := uint64(0x62746f6f6c)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// fastCDCChunker cuts chunks with FastCDC.
+type fastCDCChunker struct{}
+
+func (fastCDCChunker) Name() string {
+	return ChunkerFastCDC
+}
+
+func (fastCDCChunker) Split(reader io.Reader, emit func(data []byte) error) error {
+	// The buffer holds the data not yet cut, and is refilled to hold a full
+	// chunk before each cut unless the input ends first.
+	pooled := splitBuffers.get()
+	defer splitBuffers.put(pooled)
+	buffer := (*pooled)[:0]
+	eof := false
+	for {
+		for !eof && len(buffer) < maxChunkSize {
+			n, err := reader.Read(buffer[len(buffer):cap(buffer)])
+			buffer = buffer[:len(buffer)+n]
+			if errors.Is(err, io.EOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(buffer) == 0 {
+			return nil
+		}
+
+		length := fastCDCCut(buffer)
+		if err := emit(buffer[:length]); err != nil {
+			return err
+		}
+		buffer = buffer[:copy(buffer, buffer[length:])]
+	}
+}
+
+// fastCDCCut returns the length of the chunk at the start of data.
+func fastCDCCut(data []byte) int {
+	n := len(data)
+	if n <= minChunkSize {
+		return n
+	}
+	if n > maxChunkSize {
+		n = maxChunkSize
+	}
+	normal := avgChunkSize
+	if n < normal {
+		normal = n
+	}
+
+	var hash uint64
+	i := minChunkSize
+	for ; i < normal; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&fastCDCMaskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&fastCDCMaskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
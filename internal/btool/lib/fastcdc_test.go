@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// splitAll splits data with chunker and returns copies of the chunks.
+func splitAll(t *testing.T, chunker Chunker, reader *bytes.Reader) [][]byte {
+	var chunks [][]byte
+	require.NoError(t, chunker.Split(iotest.HalfReader(reader), func(data []byte) error {
+		chunks = append(chunks, bytes.Clone(data))
+		return nil
+	}))
+	return chunks
+}
+
+func TestFastCDCChunker(t *testing.T) {
+	content := make([]byte, 4*1024*1024)
+	_, err := rand.Read(content)
+	require.NoError(t, err)
+	chunker, err := NewChunker(ChunkerFastCDC)
+	require.NoError(t, err)
+
+	t.Run("should cut chunks within the size limits that re-form the input", func(t *testing.T) {
+		// Act
+		chunks := splitAll(t, chunker, bytes.NewReader(content))
+
+		// Assert
+		var reconstructed []byte
+		for i, chunk := range chunks {
+			if i < len(chunks)-1 {
+				assert.GreaterOrEqual(t, len(chunk), minChunkSize)
+			}
+			assert.LessOrEqual(t, len(chunk), maxChunkSize)
+			reconstructed = append(reconstructed, chunk...)
+		}
+		assert.Equal(t, content, reconstructed)
+		average := len(content) / len(chunks)
+		assert.InDelta(t, avgChunkSize, average, avgChunkSize/4, "Chunk sizes should be normalized towards the average")
+	})
+
+	t.Run("should cut the same chunks however the data is read", func(t *testing.T) {
+		var byteByByte [][]byte
+		require.NoError(t, chunker.Split(iotest.OneByteReader(bytes.NewReader(content[:256*1024])), func(data []byte) error {
+			byteByByte = append(byteByByte, bytes.Clone(data))
+			return nil
+		}))
+		assert.Equal(t, splitAll(t, chunker, bytes.NewReader(content[:256*1024])), byteByByte)
+	})
+
+	t.Run("should keep most chunks when data is inserted at the start", func(t *testing.T) {
+		// Arrange
+		shifted := append([]byte("a few inserted bytes"), content...)
+		original := make(map[string]bool)
+		for _, chunk := range splitAll(t, chunker, bytes.NewReader(content)) {
+			original[string(chunk)] = true
+		}
+
+		// Act
+		chunks := splitAll(t, chunker, bytes.NewReader(shifted))
+
+		// Assert
+		shared := 0
+		for _, chunk := range chunks {
+			if original[string(chunk)] {
+				shared++
+			}
+		}
+		assert.Greater(t, shared, len(chunks)*95/100)
+	})
+
+	t.Run("should cut nothing from empty input", func(t *testing.T) {
+		assert.Empty(t, splitAll(t, chunker, bytes.NewReader(nil)))
+	})
+}
+
+func TestNewChunker(t *testing.T) {
+	for name, want := range map[string]string{"": ChunkerRabin, ChunkerRabin: ChunkerRabin, ChunkerFastCDC: ChunkerFastCDC} {
+		chunker, err := NewChunker(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, chunker.Name())
+	}
+	_, err := NewChunker("fixed")
+	assert.ErrorContains(t, err, "unsupported chunker")
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// FileCacheDirName is the name of the directory holding the file caches of
+// snap. It lies inside a local repository, or else in the user's cache
+// directory.
+const FileCacheDirName = "cache"
+
+// FileCacheEntry records what a file looked like when a snap read it, and
+// the manifest of the contents it read.
+type FileCacheEntry struct {
+	Size         int64             `json:"size"`
+	ModTime      int64             `json:"mtime"` // In nanoseconds since the epoch.
+	Inode        uint64            `json:"inode,omitempty"`
+	Mode         uint32            `json:"mode,omitempty"`  // The permissions in the file's tree entry.
+	Link         string            `json:"link,omitempty"`  // The link in the file's tree entry.
+	Owner        *types.Owner      `json:"owner,omitempty"` // The owner in the file's tree entry.
+	ACL          *types.ACL        `json:"acl,omitempty"`   // The ACL in the file's tree entry.
+	Attributes   uint32            `json:"attributes,omitempty"`
+	Streams      []types.Stream    `json:"streams,omitempty"`
+	BirthTime    int64             `json:"btime,omitempty"`
+	Xattrs       map[string][]byte `json:"xattrs,omitempty"` // The extended attributes in the file's tree entry.
+	ManifestHash string            `json:"manifest"`
+}
+
+// NewFileCacheEntry returns an entry, without a manifest, for a file with
+// the given info.
+func NewFileCacheEntry(info fs.FileInfo) FileCacheEntry {
+	return FileCacheEntry{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: fileInode(info), Mode: uint32(info.Mode().Perm()), Link: FileLinkID(info), Owner: FileOwner(info),
+		Attributes: FileAttributes(info), BirthTime: FileBirthTime(info)}
+}
+
+// Unchanged reports whether a file now described by current looks the same
+// as when the entry was recorded, so that its contents can be taken to be
+// the same too. A file replaced by another of the same size and time still
+// differs in its inode, where the system has them. The mode, links, owner,
+// ACL, attributes, and extended attributes have no bearing on the contents. Writing to a stream
+// changes the time of its file.
+func (e FileCacheEntry) Unchanged(current FileCacheEntry) bool {
+	return e.Size == current.Size && e.ModTime == current.ModTime && e.Inode == current.Inode
+}
+
+// DirCacheEntry records the tree a snap built for a directory.
+type DirCacheEntry struct {
+	Mode       uint32            `json:"mode,omitempty"` // The permissions in the directory's tree entry.
+	ModTime    int64             `json:"mtime,omitempty"`
+	Owner      *types.Owner      `json:"owner,omitempty"`
+	ACL        *types.ACL        `json:"acl,omitempty"`
+	Attributes uint32            `json:"attributes,omitempty"`
+	BirthTime  int64             `json:"btime,omitempty"`
+	Xattrs     map[string][]byte `json:"xattrs,omitempty"`
+	Entries    int               `json:"entries"`
+	TreeHash   string            `json:"tree"`
+}
+
+// FileCache lets a snap reuse the manifests of files that have not changed
+// since the previous snap of the same directory, instead of reading and
+// chunking them again, and the trees of directories none of whose entries
+// have changed, instead of marshaling and hashing them again. The manifests
+// and trees are only reused while the snap that refers to them, and so keeps
+// their objects from being pruned, exists.
+type FileCache struct {
+	SnapHash string                    `json:"snap"`
+	Files    map[string]FileCacheEntry `json:"files"`          // By absolute path.
+	Dirs     map[string]DirCacheEntry  `json:"dirs,omitempty"` // By absolute path.
+	// Streams tells whether the snap recorded the alternate data streams of
+	// the files, so that their entries hold all of them.
+	Streams bool `json:"streams,omitempty"`
+}
+
+// fileCacheName returns the name of the file cache of the directory at
+// sourceDir within its cache directory.
+func fileCacheName(sourceDir string) string {
+	return GetHash([]byte(sourceDir))[:16] + ".json"
+}
+
+// LoadFileCache reads the file cache of the directory at sourceDir from
+// cacheDir. A missing cache is empty.
+func LoadFileCache(cacheDir, sourceDir string) (FileCache, error) {
+	content, err := NewLocalBackend(cacheDir).Get(fileCacheName(sourceDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return FileCache{}, nil
+	}
+	if err != nil {
+		return FileCache{}, err
+	}
+	var cache FileCache
+	if err := json.Unmarshal(content, &cache); err != nil {
+		return FileCache{}, err
+	}
+	return cache, nil
+}
+
+// SaveFileCache writes the file cache of the directory at sourceDir to
+// cacheDir, replacing the previous one.
+func SaveFileCache(cacheDir, sourceDir string, cache FileCache) error {
+	content, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	return NewLocalBackend(cacheDir).Put(fileCacheName(sourceDir), content)
+}
@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCache(t *testing.T) {
+	t.Run("should round-trip the cache of a directory", func(t *testing.T) {
+		// Arrange
+		cacheDir := t.TempDir()
+		cache := FileCache{SnapHash: "abc", Files: map[string]FileCacheEntry{
+			"/src/a.txt": {Size: 3, ModTime: 42, Inode: 7, ManifestHash: "def"},
+		}}
+
+		// Act
+		require.NoError(t, SaveFileCache(cacheDir, "/src", cache))
+		loaded, err := LoadFileCache(cacheDir, "/src")
+		other, otherErr := LoadFileCache(cacheDir, "/elsewhere")
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, cache, loaded)
+		require.NoError(t, otherErr)
+		assert.Empty(t, other.Files, "Each directory should have a cache of its own")
+	})
+
+	t.Run("should tell a changed file from an unchanged one", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "file.txt")
+		require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		recorded := NewFileCacheEntry(info)
+
+		// Act
+		unchanged, err := os.Stat(path)
+		require.NoError(t, err)
+		later := info.ModTime().Add(time.Second)
+		require.NoError(t, os.Chtimes(path, later, later))
+		touched, err := os.Stat(path)
+		require.NoError(t, err)
+
+		// Assert
+		assert.True(t, recorded.Unchanged(NewFileCacheEntry(unchanged)))
+		assert.False(t, recorded.Unchanged(NewFileCacheEntry(touched)))
+		grown := recorded
+		grown.Size++
+		assert.False(t, recorded.Unchanged(grown))
+	})
+}
@@ -3,6 +3,8 @@ package lib
 import (
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // CopyFile copies a file from src to dst. If dst does not exist, it is created.
@@ -28,3 +30,27 @@ func CopyFile(src, dst string) error {
 	// Ensure the data is written to stable storage.
 	return destFile.Sync()
 }
+
+// StreamPath returns the path that opens the named alternate data stream of
+// the file at path, on Windows.
+func StreamPath(path, stream string) string {
+	return path + ":" + stream
+}
+
+// IsCaseInsensitive reports whether the filesystem holding the directory
+// dir takes names that differ only in case for the same, as on Windows and
+// macOS by default. It finds out by creating a file there.
+func IsCaseInsensitive(dir string) (bool, error) {
+	probe, err := os.CreateTemp(dir, ".btool-case-probe-*")
+	if err != nil {
+		return false, err
+	}
+	probe.Close()
+	defer os.Remove(probe.Name())
+
+	_, err = os.Stat(filepath.Join(dir, strings.ToUpper(filepath.Base(probe.Name()))))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
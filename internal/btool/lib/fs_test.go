@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsCaseInsensitive(t *testing.T) {
+	t.Run("should agree with how the filesystem looks up names", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "readme"), nil, 0644))
+		_, err := os.Stat(filepath.Join(dir, "README"))
+		want := err == nil
+
+		// Act
+		insensitive, err := IsCaseInsensitive(dir)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, want, insensitive)
+	})
+
+	t.Run("should leave nothing behind", func(t *testing.T) {
+		// Arrange
+		dir := t.TempDir()
+
+		// Act
+		_, err := IsCaseInsensitive(dir)
+
+		// Assert
+		require.NoError(t, err)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
@@ -0,0 +1,108 @@
+//go:build ignore
+
+// gendict trains the zstd dictionary that metadata objects are compressed
+// with, on file manifests and trees like those snap writes, and stores it in
+// metadata.zdict. Run it with go generate.
+//
+// Objects compressed with a dictionary can only be read with the very same
+// dictionary, so a dictionary must never change once released. A better one
+// has to be added under a new ID, with the old ones kept for reading.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// dictionaryID must match metadataDictionaryID in compression.go.
+const dictionaryID = 0x62740001
+
+var (
+	names = []string{
+		"README.md", "LICENSE", "Makefile", "Dockerfile", "package.json", "package-lock.json", "go.mod", "go.sum",
+		"index.js", "index.html", "main.go", "main.py", "__init__.py", "setup.py", "requirements.txt", "Cargo.toml",
+		"style.css", "config.yaml", "config.json", ".gitignore", ".DS_Store", "Thumbs.db", "desktop.ini",
+		"src", "lib", "bin", "test", "tests", "docs", "build", "dist", "node_modules", "vendor", "assets", "images",
+		"Documents", "Pictures", "Music", "Videos", "Downloads", "Desktop",
+	}
+	extensions = []string{
+		".txt", ".md", ".go", ".js", ".ts", ".py", ".c", ".h", ".java", ".json", ".yaml", ".html", ".css",
+		".jpg", ".png", ".pdf", ".docx", ".xlsx", ".mp3", ".mp4", ".zip", ".log", ".csv", ".xml",
+	}
+	fileModes = []uint32{0644, 0644, 0644, 0664, 0600, 0755}
+	dirModes  = []uint32{0755, 0755, 0775, 0700}
+)
+
+func randomHash(r *rand.Rand) string {
+	var seed [8]byte
+	r.Read(seed[:])
+	sum := sha256.Sum256(seed[:])
+	return hex.EncodeToString(sum[:])
+}
+
+func randomName(r *rand.Rand) string {
+	if r.Intn(3) == 0 {
+		return names[r.Intn(len(names))]
+	}
+	return fmt.Sprintf("file%d%s", r.Intn(1000), extensions[r.Intn(len(extensions))])
+}
+
+func manifest(r *rand.Rand) []byte {
+	var m types.FileManifest
+	for i := r.Intn(4) + 1; i > 0; i-- {
+		size := r.Int63n(64 * 1024)
+		m.Chunks = append(m.Chunks, types.ChunkRef{Hash: randomHash(r), Size: size})
+		m.TotalSize += size
+	}
+	content, _ := json.Marshal(m)
+	return content
+}
+
+func tree(r *rand.Rand) []byte {
+	var t types.Tree
+	for i := r.Intn(12) + 1; i > 0; i-- {
+		entry := types.TreeEntry{Name: randomName(r), Hash: randomHash(r), Type: "blob", Mode: fileModes[r.Intn(len(fileModes))]}
+		if r.Intn(5) == 0 {
+			entry.Type, entry.Mode = "tree", dirModes[r.Intn(len(dirModes))]
+		}
+		t.Entries = append(t.Entries, entry)
+	}
+	content, _ := json.Marshal(t)
+	return content
+}
+
+func main() {
+	// A fixed seed keeps the output reproducible.
+	r := rand.New(rand.NewSource(1))
+	var contents [][]byte
+	var history []byte
+	for i := 0; i < 2000; i++ {
+		m, t := manifest(r), tree(r)
+		contents = append(contents, m, t)
+		if len(history) < 16*1024 {
+			history = append(history, m...)
+			history = append(history, t...)
+		}
+	}
+
+	dictionary, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       dictionaryID,
+		Contents: contents,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("metadata.zdict", dictionary, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -2,27 +2,127 @@
 package lib
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"hash"
 	"io"
 	"os"
+	"sync"
+
+	"github.com/zeebo/blake3"
 )
 
-// GetHash calculates the SHA-256 hash of an in-memory byte slice and returns
-// it as a lowercase hex-encoded string.
+// Hasher derives the IDs of the objects, packs, and snaps in a repository
+// with SHA-256 or BLAKE3. Without a key it uses the plain hash. With one it
+// uses HMAC-SHA256, or BLAKE3's keyed mode, under that key, so that whoever
+// lacks the key cannot tell from an ID whether the repository holds a file
+// they know.
+type Hasher struct {
+	base   string // HashAlgorithm or Blake3HashAlgorithm.
+	key    []byte
+	hashes sync.Pool // Of hash.Hash, reused by GetHash.
+}
+
+// NewHasher returns a SHA-256 Hasher keyed with key, or a plain SHA-256
+// Hasher if key is empty.
+func NewHasher(key []byte) *Hasher {
+	return &Hasher{base: HashAlgorithm, key: key}
+}
+
+// NewHasherFor returns a Hasher for base, HashAlgorithm or
+// Blake3HashAlgorithm, keyed with key unless it is empty.
+func NewHasherFor(base string, key []byte) (*Hasher, error) {
+	switch base {
+	case HashAlgorithm:
+	case Blake3HashAlgorithm:
+		if len(key) > 0 && len(key) != hashKeySize {
+			return nil, fmt.Errorf("%s needs a %d-byte key", KeyedBlake3HashAlgorithm, hashKeySize)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q; use %s or %s", base, HashAlgorithm, Blake3HashAlgorithm)
+	}
+	return &Hasher{base: base, key: key}, nil
+}
+
+// Keyed reports whether the Hasher uses a key.
+func (h *Hasher) Keyed() bool {
+	return len(h.key) > 0
+}
+
+// Base returns the hash function the Hasher uses, HashAlgorithm or
+// Blake3HashAlgorithm, whether keyed or not.
+func (h *Hasher) Base() string {
+	return h.base
+}
+
+// Algorithm returns the name of the algorithm the Hasher uses, as the
+// repository config records it.
+func (h *Hasher) Algorithm() string {
+	switch {
+	case h.base == Blake3HashAlgorithm && h.Keyed():
+		return KeyedBlake3HashAlgorithm
+	case h.base == Blake3HashAlgorithm:
+		return Blake3HashAlgorithm
+	case h.Keyed():
+		return KeyedHashAlgorithm
+	default:
+		return HashAlgorithm
+	}
+}
+
+// newHash returns a fresh hash.Hash for this Hasher.
+func (h *Hasher) newHash() hash.Hash {
+	if h.base == Blake3HashAlgorithm {
+		if h.Keyed() {
+			// NewHasherFor checked the key's size, the only possible error.
+			keyed, _ := blake3.NewKeyed(h.key)
+			return keyed
+		}
+		return blake3.New()
+	}
+	if h.Keyed() {
+		return hmac.New(sha256.New, h.key)
+	}
+	return sha256.New()
+}
+
+// GetHash calculates the ID of an in-memory byte slice and returns it as a
+// lowercase hex-encoded string.
 // This is used for hashing content that is already in memory, such as a
 // Tree or FileManifest object after it has been serialized to JSON.
+//
+// It is called for every chunk, so the hash states are reused rather than
+// allocated each time.
+func (h *Hasher) GetHash(content []byte) string {
+	hasher, ok := h.hashes.Get().(hash.Hash)
+	if ok {
+		hasher.Reset()
+	} else {
+		hasher = h.newHash()
+	}
+	hasher.Write(content)
+	var sum [sha256.Size]byte
+	id := hex.EncodeToString(hasher.Sum(sum[:0]))
+	h.hashes.Put(hasher)
+	return id
+}
+
+// GetHash calculates the plain SHA-256 hash of an in-memory byte slice and
+// returns it as a lowercase hex-encoded string. It is used for names that are
+// not repository IDs, such as cache directories.
 func GetHash(content []byte) string {
 	hashBytes := sha256.Sum256(content)
 	return hex.EncodeToString(hashBytes[:])
 }
 
-// GetFileHash calculates the SHA-256 hash of a file's contents by streaming
-// it from disk. This is highly memory-efficient as it avoids loading the entire
-// file into memory.
+// GetFileHash calculates the ID of a file's contents by streaming it from
+// disk. This is highly memory-efficient as it avoids loading the entire file
+// into memory.
 // It returns the lowercase hex-encoded hash string and an error if any file
 // operation fails.
-func GetFileHash(filePath string) (string, error) {
+func (h *Hasher) GetFileHash(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", err
@@ -30,7 +130,7 @@ func GetFileHash(filePath string) (string, error) {
 
 	defer file.Close()
 
-	hasher := sha256.New()
+	hasher := h.newHash()
 
 	if _, err := io.Copy(hasher, file); err != nil {
 		return "", err
@@ -39,3 +139,9 @@ func GetFileHash(filePath string) (string, error) {
 	hashBytes := hasher.Sum(nil)
 	return hex.EncodeToString(hashBytes), nil
 }
+
+// GetFileHash calculates the plain SHA-256 hash of a file's contents, as
+// NewHasher(nil).GetFileHash does.
+func GetFileHash(filePath string) (string, error) {
+	return NewHasher(nil).GetFileHash(filePath)
+}
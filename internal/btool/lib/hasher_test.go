@@ -74,4 +74,49 @@ func TestHashing(t *testing.T) {
 		require.Error(t, err, "Expected an error when hashing a non-existent file, but got nil")
 		assert.True(t, os.IsNotExist(err), "Expected a 'file not exist' error, but got: %v", err)
 	})
+
+	t.Run("Keyed Hasher derives HMAC-SHA256 IDs", func(t *testing.T) {
+		// Arrange: RFC 4231 test case 2.
+		hasher := NewHasher([]byte("Jefe"))
+
+		// Act
+		hash := hasher.GetHash([]byte("what do ya want for nothing?"))
+
+		// Assert
+		assert.True(t, hasher.Keyed())
+		assert.Equal(t, "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843", hash)
+		assert.False(t, NewHasher(nil).Keyed())
+		assert.Equal(t, helloWorldHash, NewHasher(nil).GetHash([]byte("hello world")))
+	})
+
+	t.Run("BLAKE3 Hasher derives BLAKE3 IDs", func(t *testing.T) {
+		// Arrange: the first cases of the BLAKE3 test vectors.
+		plain, err := NewHasherFor(Blake3HashAlgorithm, nil)
+		require.NoError(t, err)
+		keyed, err := NewHasherFor(Blake3HashAlgorithm, []byte("whats the Elvish word for friend"))
+		require.NoError(t, err)
+		filePath := filepath.Join(t.TempDir(), "empty.txt")
+		require.NoError(t, os.WriteFile(filePath, nil, 0644))
+
+		// Act
+		plainHash := plain.GetHash(nil)
+		keyedHash := keyed.GetHash(nil)
+		fileHash, err := plain.GetFileHash(filePath)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262", plainHash)
+		assert.Equal(t, "92b2b75604ed3c761f9d6f62392c8a9227ad0ea3f09573e783f1498a4ed60d26", keyedHash)
+		assert.Equal(t, plainHash, fileHash)
+		assert.Equal(t, Blake3HashAlgorithm, plain.Algorithm())
+		assert.Equal(t, KeyedBlake3HashAlgorithm, keyed.Algorithm())
+		assert.Equal(t, KeyedHashAlgorithm, NewHasher([]byte("Jefe")).Algorithm())
+	})
+
+	t.Run("NewHasherFor refuses unknown algorithms and short BLAKE3 keys", func(t *testing.T) {
+		_, err := NewHasherFor("md5", nil)
+		assert.ErrorContains(t, err, "unsupported hash algorithm")
+		_, err = NewHasherFor(Blake3HashAlgorithm, []byte("short"))
+		assert.ErrorContains(t, err, "32-byte key")
+	})
 }
@@ -0,0 +1,353 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// The index records where every object is stored. It is kept as one shard per
+// pack, IndexDirName/<pack hash>, which lists the objects in that pack and is
+// written along with it and never changed afterwards. Committing only adds a
+// shard, and prune only deletes the shards of the packs it deletes, so neither
+// rewrites the index as a whole, however large the repository.
+//
+// Repositories from before shards kept the whole index in IndexFileName, with
+// fragments in IndexDirName written by write-only backups. Those are still
+// read, and replaced by shards as soon as a store that can read them writes a
+// pack or deletes one.
+
+// maxShardReads bounds how many shards are read at once while loading the
+// index, which matters for remote backends with a round trip per file.
+const maxShardReads = 16
+
+// shardName returns the backend name of the index shard of a pack.
+func shardName(packHash string) string {
+	return IndexDirName + "/" + packHash
+}
+
+// loadIndex reads every index shard, and any index of the old layout, into the
+// in-memory index. It does so on first use, so commands that never look
+// objects up never read the index.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) loadIndex() error {
+	if s.indexLoaded {
+		return nil
+	}
+	if s.writeOnly() {
+		// The index cannot be read, so objects are only de-duplicated within
+		// this session.
+		s.rebuildIndexFilter()
+		s.indexLoaded = true
+		return nil
+	}
+
+	legacy, err := s.readIndexFile(IndexFileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		for hash, entry := range legacy {
+			s.packIndex[hash] = entry
+		}
+		s.legacyIndex = append(s.legacyIndex, IndexFileName)
+	}
+
+	entries, err := s.backend.List(IndexDirName)
+	if err != nil {
+		return err
+	}
+	shards := make([]types.PackIndex, len(entries))
+	errs := make([]error, len(entries))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, maxShardReads)
+	for i, entry := range entries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			shards[i], errs[i] = s.readIndexFile(IndexDirName + "/" + entry.Name)
+			if errors.Is(errs[i], fs.ErrNotExist) {
+				errs[i] = nil // Deleted by a prune since it was listed.
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	for i, entry := range entries {
+		shard := true
+		for hash, indexEntry := range shards[i] {
+			s.packIndex[hash] = indexEntry
+			shard = shard && indexEntry.PackHash == entry.Name
+		}
+		if shard {
+			s.shardPacks[entry.Name] = true
+		} else {
+			// A fragment of the old layout, which spans several packs.
+			s.legacyIndex = append(s.legacyIndex, IndexDirName+"/"+entry.Name)
+		}
+	}
+
+	s.rebuildIndexFilter()
+	s.indexLoaded = true
+	return nil
+}
+
+// rebuildIndexFilter builds the filter over the hashes in the index, with
+// room for as many again.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) rebuildIndexFilter() {
+	s.indexFilter = newBloomFilter(2 * len(s.packIndex))
+	for hash := range s.packIndex {
+		s.indexFilter.add(hash)
+	}
+}
+
+// addIndexEntry records where the object with the given hash is stored.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) addIndexEntry(hash string, entry types.PackIndexEntry) {
+	s.packIndex[hash] = entry
+	if s.indexFilter.full() {
+		s.rebuildIndexFilter() // Adds hash along with the rest.
+		return
+	}
+	s.indexFilter.add(hash)
+}
+
+// readIndexFile reads and decrypts the named index file.
+func (s *ObjectStore) readIndexFile(name string) (types.PackIndex, error) {
+	content, err := s.backend.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return s.decodeIndexFile(name, content)
+}
+
+// decodeIndexFile decrypts and parses the contents of the named index file.
+func (s *ObjectStore) decodeIndexFile(name string, content []byte) (types.PackIndex, error) {
+	content, err := s.decrypt(content)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: %w", name, err)
+	}
+	var entries types.PackIndex
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", name, err)
+	}
+	return entries, nil
+}
+
+// writeShard stores the index shard of a pack, which lists the objects in it.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) writeShard(packHash string, entries types.PackIndex) error {
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if content, err = s.encrypt(content); err != nil {
+		return err
+	}
+	if err := s.backend.Put(shardName(packHash), content); err != nil {
+		return err
+	}
+	s.shardPacks[packHash] = true
+	return nil
+}
+
+// migrateIndex replaces any index files of the old layout with shards for the
+// packs they cover. The shards are written before the old files are deleted,
+// so an interruption leaves at worst both behind.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) migrateIndex() error {
+	if len(s.legacyIndex) == 0 || s.writeOnly() {
+		return nil
+	}
+
+	byPack := make(map[string]types.PackIndex)
+	for hash, entry := range s.packIndex {
+		if s.shardPacks[entry.PackHash] || s.deletedPacks[entry.PackHash] {
+			continue
+		}
+		if byPack[entry.PackHash] == nil {
+			byPack[entry.PackHash] = make(types.PackIndex)
+		}
+		byPack[entry.PackHash][hash] = entry
+	}
+	for packHash, entries := range byPack {
+		if err := s.writeShard(packHash, entries); err != nil {
+			return err
+		}
+	}
+
+	// A file that cannot be deleted is merged again next time, harmlessly.
+	for _, name := range s.legacyIndex {
+		_ = s.backend.Delete(name)
+	}
+	s.legacyIndex = nil
+	return nil
+}
+
+// indexEntry returns where the object with the given hash is stored, unless
+// it is not in the index or its pack has been deleted.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) indexEntry(hash string) (types.PackIndexEntry, bool) {
+	entry, exists := s.packIndex[hash]
+	if !exists || s.deletedPacks[entry.PackHash] {
+		return types.PackIndexEntry{}, false
+	}
+	return entry, true
+}
+
+// ErrPackNotSelfDescribing is returned by ScanPack for packs written before
+// packs described their own objects, which hold nothing but the stored bytes
+// of their objects, so that only the index can tell them apart.
+var ErrPackNotSelfDescribing = errors.New("the pack does not describe its own objects")
+
+// IndexRebuild is the outcome of RebuildIndex.
+type IndexRebuild struct {
+	Packs   int // Packs whose objects the index now lists.
+	Objects int
+	// Scanned holds the packs whose shards were written from the packs
+	// themselves, and Lost those whose objects could not be listed at all,
+	// with the reason.
+	Scanned []string
+	Lost    map[string]error
+	// Dropped holds the index files removed because they could not be read
+	// or only listed packs that no longer exist.
+	Dropped []string
+}
+
+// RebuildIndex writes the index anew as one shard per pack. It reads each
+// index file on its own, so one that cannot be read does not stop the rest,
+// takes the objects of each existing pack from its shard or from an index of
+// the old layout, and scans the packs that neither lists. Index files that
+// cannot be read or only list missing packs are removed, as are those of the
+// old layout once their packs have shards.
+func (s *ObjectStore) RebuildIndex() (IndexRebuild, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rebuild := IndexRebuild{Lost: make(map[string]error)}
+	if s.writeOnly() {
+		return rebuild, fmt.Errorf("the index cannot be rebuilt without a key that can read the repository")
+	}
+	packList, err := s.backend.List(PacksDirName)
+	if err != nil {
+		return rebuild, err
+	}
+	packs := make(map[string]types.PackIndex)
+	packEntries := make(map[string]BackendEntry)
+	for _, pack := range packList {
+		packs[pack.Name] = nil
+		packEntries[pack.Name] = pack
+	}
+	shards := make(map[string]bool) // Packs whose shard exists and is complete.
+	var legacy []string
+
+	// merge adds the entries of an index file for the packs that exist, and
+	// reports whether it listed any.
+	merge := func(entries types.PackIndex) bool {
+		listed := false
+		for hash, entry := range entries {
+			objects, exists := packs[entry.PackHash]
+			if !exists {
+				continue
+			}
+			if objects == nil {
+				objects = make(types.PackIndex)
+				packs[entry.PackHash] = objects
+			}
+			objects[hash] = entry
+			listed = true
+		}
+		return listed
+	}
+
+	names := []string{IndexFileName}
+	entries, err := s.backend.List(IndexDirName)
+	if err != nil {
+		return rebuild, err
+	}
+	for _, entry := range entries {
+		names = append(names, shardName(entry.Name))
+	}
+	for _, name := range names {
+		content, err := s.backend.Get(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return rebuild, err // Only files that were read and found broken are dropped.
+		}
+		index, err := s.decodeIndexFile(name, content)
+		if err != nil || !merge(index) {
+			rebuild.Dropped = append(rebuild.Dropped, name)
+			continue
+		}
+		packHash := strings.TrimPrefix(name, IndexDirName+"/")
+		shard := name != IndexFileName
+		for _, entry := range index {
+			shard = shard && entry.PackHash == packHash
+		}
+		if shard {
+			shards[packHash] = true
+		} else {
+			legacy = append(legacy, name)
+		}
+	}
+
+	// Broken files go first, as a pack's new shard may take the place of one.
+	for _, name := range rebuild.Dropped {
+		if err := s.backend.Delete(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return rebuild, err
+		}
+	}
+
+	for packHash, objects := range packs {
+		if objects == nil {
+			scanned, err := s.ScanPack(packEntries[packHash])
+			if err != nil {
+				rebuild.Lost[packHash] = err
+				continue
+			}
+			objects = scanned
+			packs[packHash] = objects
+			rebuild.Scanned = append(rebuild.Scanned, packHash)
+		}
+		if !shards[packHash] {
+			if err := s.writeShard(packHash, objects); err != nil {
+				return rebuild, err
+			}
+			shards[packHash] = true
+		}
+		rebuild.Packs++
+		rebuild.Objects += len(objects)
+	}
+	sort.Strings(rebuild.Scanned)
+
+	// Every pack that is listed at all now has a shard of its own.
+	for _, name := range legacy {
+		if shards[strings.TrimPrefix(name, IndexDirName+"/")] {
+			continue // Since replaced by the shard of the pack of that name.
+		}
+		if err := s.backend.Delete(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return rebuild, err
+		}
+	}
+
+	s.packIndex = make(types.PackIndex)
+	s.shardPacks = make(map[string]bool)
+	s.deletedPacks = make(map[string]bool)
+	s.legacyIndex = nil
+	s.indexLoaded = false
+	return rebuild, nil
+}
@@ -0,0 +1,30 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lib
+
+import (
+	"io/fs"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// fileInode returns zero, as inode numbers are not available here.
+func fileInode(fs.FileInfo) uint64 {
+	return 0
+}
+
+// FileLinkID returns an empty string, as hardlinks cannot be told apart
+// from other files here.
+func FileLinkID(fs.FileInfo) string {
+	return ""
+}
+
+// FileOwner returns nil, as files have no user and group IDs here.
+func FileOwner(fs.FileInfo) *types.Owner {
+	return nil
+}
+
+// FileRdev returns zero, as device nodes are not found here.
+func FileRdev(fs.FileInfo) uint64 {
+	return 0
+}
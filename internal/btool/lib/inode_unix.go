@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// fileInode returns the inode number of a file.
+func fileInode(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
+
+// FileLinkID returns an identifier that the files hardlinked together share,
+// made of their device and inode numbers, or an empty string for a file
+// with a single link.
+func FileLinkID(info fs.FileInfo) string {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || info.IsDir() || stat.Nlink < 2 {
+		return ""
+	}
+	return fmt.Sprintf("%x:%x", uint64(stat.Dev), uint64(stat.Ino))
+}
+
+// FileOwner returns the user and group owning a file.
+func FileOwner(info fs.FileInfo) *types.Owner {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return newOwner(stat.Uid, stat.Gid)
+	}
+	return nil
+}
+
+// FileRdev returns the device number of a device node.
+func FileRdev(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Rdev)
+	}
+	return 0
+}
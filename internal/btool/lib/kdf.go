@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Names of the key derivation functions that protect password slots.
+const (
+	KDFArgon2id = "argon2id"
+	KDFScrypt   = "scrypt"
+)
+
+// Bounds on argon2id costs, which keep a tampered key slot from making
+// unlocking exhaust the host's memory.
+const (
+	maxKDFMemory       = 4 << 20 // KiB, i.e. 4 GiB.
+	maxKDFIterations   = 1000
+	minKDFMemoryPerCPU = 8 // KiB, the argon2 minimum.
+)
+
+// KDFParams selects the key derivation function that turns a password into
+// the key sealing a password slot, along with its cost. Higher costs make
+// guessing passwords slower, and unlocking the repository too.
+type KDFParams struct {
+	Algorithm string // KDFArgon2id or KDFScrypt.
+	// Memory (in KiB), Iterations, and Parallelism are the argon2id costs.
+	// scrypt always uses fixed costs.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+// DefaultKDF returns the parameters used for new password slots unless
+// others are chosen: argon2id with 64 MiB of memory, 3 iterations, and 4
+// lanes, the second recommendation of RFC 9106.
+func DefaultKDF() KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Memory: 64 << 10, Iterations: 3, Parallelism: 4}
+}
+
+// Validate checks that the parameters are usable.
+func (p KDFParams) Validate() error {
+	switch p.Algorithm {
+	case KDFScrypt:
+		return nil
+	case KDFArgon2id:
+		switch {
+		case p.Iterations < 1 || p.Iterations > maxKDFIterations:
+			return fmt.Errorf("argon2id iterations must be between 1 and %d", maxKDFIterations)
+		case p.Parallelism < 1:
+			return fmt.Errorf("argon2id parallelism must be at least 1")
+		case p.Memory < minKDFMemoryPerCPU*uint32(p.Parallelism) || p.Memory > maxKDFMemory:
+			return fmt.Errorf("argon2id memory must be between %d KiB and %d MiB", minKDFMemoryPerCPU*uint32(p.Parallelism), maxKDFMemory>>10)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported key derivation function %q", p.Algorithm)
+	}
+}
+
+// String describes the parameters, e.g. "argon2id m=65536 t=3 p=4".
+func (p KDFParams) String() string {
+	if p.Algorithm == KDFArgon2id {
+		return fmt.Sprintf("%s m=%d t=%d p=%d", p.Algorithm, p.Memory, p.Iterations, p.Parallelism)
+	}
+	return p.Algorithm
+}
+
+// deriveKey derives a key of masterKeySize bytes from password and salt.
+func (p KDFParams) deriveKey(password string, salt []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if p.Algorithm == KDFScrypt {
+		return scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, masterKeySize)
+	}
+	return argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, masterKeySize), nil
+}
+
+// TuneKDF benchmarks argon2id on this host with the given memory (in KiB) and
+// parallelism, and returns the parameters with the fewest iterations that
+// take at least target to derive a key, along with how long they took.
+func TuneKDF(memory uint32, parallelism uint8, target time.Duration) (KDFParams, time.Duration, error) {
+	params := KDFParams{Algorithm: KDFArgon2id, Memory: memory, Iterations: 1, Parallelism: parallelism}
+	salt := make([]byte, kdfSaltLen)
+	for {
+		if err := params.Validate(); err != nil {
+			return params, 0, err
+		}
+		start := time.Now()
+		if _, err := params.deriveKey("benchmark", salt); err != nil {
+			return params, 0, err
+		}
+		elapsed := time.Since(start)
+		if elapsed >= target || params.Iterations == maxKDFIterations {
+			return params, elapsed, nil
+		}
+		// Derivation time grows linearly with iterations, so jump close to
+		// the target and then creep up on it.
+		next := uint64(params.Iterations) * uint64(target) / uint64(max(elapsed, time.Microsecond))
+		params.Iterations = uint32(min(max(next, uint64(params.Iterations)+1), maxKDFIterations))
+	}
+}
@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cheapKDF keeps tests fast.
+var cheapKDF = KDFParams{Algorithm: KDFArgon2id, Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestKDFParams(t *testing.T) {
+	t.Run("should accept the defaults and scrypt", func(t *testing.T) {
+		assert.NoError(t, DefaultKDF().Validate())
+		assert.NoError(t, KDFParams{Algorithm: KDFScrypt}.Validate())
+	})
+
+	t.Run("should reject unusable parameters", func(t *testing.T) {
+		assert.ErrorContains(t, KDFParams{Algorithm: "bcrypt"}.Validate(), "unsupported")
+		assert.ErrorContains(t, KDFParams{Algorithm: KDFArgon2id, Memory: 64, Parallelism: 1}.Validate(), "iterations")
+		assert.ErrorContains(t, KDFParams{Algorithm: KDFArgon2id, Memory: 64, Iterations: 1}.Validate(), "parallelism")
+		assert.ErrorContains(t, KDFParams{Algorithm: KDFArgon2id, Memory: 8 << 20, Iterations: 1, Parallelism: 1}.Validate(), "memory")
+	})
+
+	t.Run("should describe argon2id costs", func(t *testing.T) {
+		assert.Equal(t, "argon2id m=65536 t=3 p=4", DefaultKDF().String())
+		assert.Equal(t, "scrypt", KDFParams{Algorithm: KDFScrypt}.String())
+	})
+}
+
+func TestPasswordSlotKDF(t *testing.T) {
+	t.Run("should record the parameters in the slot and unlock with them", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		_, err := InitRepositoryKeyWithKDF(backend, "correct horse", cheapKDF)
+		require.NoError(t, err)
+
+		// Act
+		entries, err := backend.List(KeysDirName)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		content, err := backend.Get(keySlotName(entries[0].Name))
+		require.NoError(t, err)
+		var slot keySlot
+		require.NoError(t, json.Unmarshal(content, &slot))
+		key, err := UnlockRepositoryKey(backend, "correct horse")
+
+		// Assert
+		assert.Equal(t, cheapKDF, slot.kdfParams())
+		require.NoError(t, err)
+		assert.True(t, key.CanRead())
+		_, err = UnlockRepositoryKey(backend, "wrong")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+	})
+
+	t.Run("should still unlock scrypt slots", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		_, err := InitRepositoryKeyWithKDF(backend, "correct horse", KDFParams{Algorithm: KDFScrypt})
+		require.NoError(t, err)
+
+		// Act
+		key, err := UnlockRepositoryKey(backend, "correct horse")
+
+		// Assert
+		require.NoError(t, err)
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		assert.Equal(t, "scrypt", slots[0].KDF)
+	})
+
+	t.Run("should add password slots with the parameters set on the key", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "correct horse", KDFParams{Algorithm: KDFScrypt})
+		require.NoError(t, err)
+		require.NoError(t, key.SetKDF(cheapKDF))
+
+		// Act
+		_, err = key.ChangePassword("battery staple")
+		require.NoError(t, err)
+
+		// Assert
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		require.Len(t, slots, 1)
+		assert.Equal(t, cheapKDF.String(), slots[0].KDF)
+		_, err = UnlockRepositoryKey(backend, "battery staple")
+		assert.NoError(t, err)
+		assert.Error(t, key.SetKDF(KDFParams{Algorithm: "bcrypt"}))
+	})
+
+	t.Run("should refuse a slot whose stored costs were tampered with", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		_, err := InitRepositoryKeyWithKDF(backend, "correct horse", cheapKDF)
+		require.NoError(t, err)
+		entries, err := backend.List(KeysDirName)
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		content, err := backend.Get(keySlotName(entries[0].Name))
+		require.NoError(t, err)
+		var slot keySlot
+		require.NoError(t, json.Unmarshal(content, &slot))
+		slot.Memory = 1 << 31
+		tampered, err := json.Marshal(slot)
+		require.NoError(t, err)
+		require.NoError(t, backend.Put(keySlotName(entries[0].Name), tampered))
+
+		// Act
+		_, err = UnlockRepositoryKey(backend, "correct horse")
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "argon2id memory must be between")
+	})
+}
+
+func TestTuneKDF(t *testing.T) {
+	t.Run("should raise iterations until derivation takes the target time", func(t *testing.T) {
+		// Act
+		params, elapsed, err := TuneKDF(64, 1, 5*time.Millisecond)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, KDFArgon2id, params.Algorithm)
+		assert.Equal(t, uint32(64), params.Memory)
+		assert.GreaterOrEqual(t, params.Iterations, uint32(1))
+		assert.True(t, elapsed >= 5*time.Millisecond || params.Iterations == maxKDFIterations)
+	})
+
+	t.Run("should reject invalid costs", func(t *testing.T) {
+		_, _, err := TuneKDF(1, 1, time.Millisecond)
+		assert.ErrorContains(t, err, "memory")
+	})
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// KeychainService is the service name that repository passwords are stored
+// under in the system keychain.
+const KeychainService = "btool"
+
+// Keychain stores repository passwords in the operating system's credential
+// store, so that scheduled backups need no password file. Each password is
+// stored under an account naming the repository.
+type Keychain interface {
+	// Get returns the password stored for account. A missing password is an
+	// error matching fs.ErrNotExist.
+	Get(account string) (string, error)
+	// Set stores password for account, replacing any stored before.
+	Set(account, password string) error
+	// Delete removes the password stored for account.
+	Delete(account string) error
+}
+
+// errNoKeychainEntry is returned by keychains when no password is stored.
+var errNoKeychainEntry = fmt.Errorf("no password in the system keychain: %w", fs.ErrNotExist)
+
+// errKeychainUnsupported reports a platform, or an installation, without a
+// keychain that btool can use.
+func errKeychainUnsupported(reason string) error {
+	return fmt.Errorf("the system keychain is not available: %s: %w", reason, errors.ErrUnsupported)
+}
+
+// KeychainAccount returns the account that the password of the repository
+// behind backend is stored under: the location of its primary destination.
+func KeychainAccount(backend Backend) string {
+	if multi, ok := UnwrapBackend(backend).(*MultiBackend); ok {
+		return multi.Backends()[0].Location()
+	}
+	return backend.Location()
+}
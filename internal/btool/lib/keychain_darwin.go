@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// securityExitNotFound is the exit code of the security tool when no item
+// matches.
+const securityExitNotFound = 44
+
+// macKeychain stores passwords in the login keychain with the security tool.
+type macKeychain struct{}
+
+// SystemKeychain returns the login keychain of the current user.
+func SystemKeychain() (Keychain, error) {
+	if _, err := exec.LookPath("security"); err != nil {
+		return nil, errKeychainUnsupported("the security tool is missing")
+	}
+	return macKeychain{}, nil
+}
+
+// security runs the security tool, feeding it stdin if given, and returns its
+// standard output.
+func (macKeychain) security(stdin string, args ...string) ([]byte, error) {
+	cmd := exec.Command("security", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == securityExitNotFound {
+			return nil, errNoKeychainEntry
+		}
+		return nil, fmt.Errorf("security %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Get implements Keychain.
+func (k macKeychain) Get(account string) (string, error) {
+	output, err := k.security("", "find-generic-password", "-s", KeychainService, "-a", account, "-w")
+	if err != nil {
+		return "", err
+	}
+	password, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(output)))
+	if err != nil {
+		return "", fmt.Errorf("the keychain item for %s was not stored by btool: %w", account, err)
+	}
+	return string(password), nil
+}
+
+// Set implements Keychain. The command is fed to an interactive session on
+// standard input, so the password never appears in the process list, and the
+// password is base64-encoded, so it needs no quoting.
+func (k macKeychain) Set(account, password string) error {
+	if strings.ContainsAny(account, "'\n") {
+		return fmt.Errorf("cannot store a password for %q in the keychain", account)
+	}
+	command := fmt.Sprintf("add-generic-password -U -s '%s' -a '%s' -w '%s'\n",
+		KeychainService, account, base64.StdEncoding.EncodeToString([]byte(password)))
+	_, err := k.security(command, "-i")
+	return err
+}
+
+// Delete implements Keychain.
+func (k macKeychain) Delete(account string) error {
+	_, err := k.security("", "delete-generic-password", "-s", KeychainService, "-a", account)
+	return err
+}
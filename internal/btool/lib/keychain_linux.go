@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// secretServiceKeychain stores passwords with the freedesktop.org Secret
+// Service (GNOME Keyring, KWallet) through the secret-tool command.
+type secretServiceKeychain struct{}
+
+// SystemKeychain returns the Secret Service of the current session.
+func SystemKeychain() (Keychain, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return nil, errKeychainUnsupported("secret-tool (from libsecret) is not installed")
+	}
+	return secretServiceKeychain{}, nil
+}
+
+// secretTool runs secret-tool, feeding it stdin, and returns its standard
+// output. secret-tool exits with 1 and prints nothing when no item matches.
+func (secretServiceKeychain) secretTool(stdin string, args ...string) ([]byte, error) {
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && message == "" {
+			return nil, errNoKeychainEntry
+		}
+		return nil, fmt.Errorf("secret-tool %s: %w: %s", args[0], err, message)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Get implements Keychain.
+func (k secretServiceKeychain) Get(account string) (string, error) {
+	output, err := k.secretTool("", "lookup", "service", KeychainService, "account", account)
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// Set implements Keychain. The password is passed on standard input, so it
+// never appears in the process list.
+func (k secretServiceKeychain) Set(account, password string) error {
+	_, err := k.secretTool(password, "store", "--label", "btool repository "+account,
+		"service", KeychainService, "account", account)
+	return err
+}
+
+// Delete implements Keychain.
+func (k secretServiceKeychain) Delete(account string) error {
+	_, err := k.secretTool("", "clear", "service", KeychainService, "account", account)
+	return err
+}
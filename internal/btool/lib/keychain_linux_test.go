@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSecretTool is a secret-tool stand-in that keeps one file per account,
+// named after the account's hex encoding, in $FAKE_KEYRING.
+const fakeSecretTool = `#!/bin/sh
+eval account=\${$#}
+file="$FAKE_KEYRING/$(printf '%s' "$account" | od -An -tx1 | tr -d ' \n')"
+case "$1" in
+store) cat > "$file" ;;
+lookup) [ -f "$file" ] || exit 1; cat "$file" ;;
+clear) rm -f "$file" ;;
+esac
+`
+
+// installFakeSecretTool puts fakeSecretTool first on PATH for the test.
+func installFakeSecretTool(t *testing.T) {
+	t.Helper()
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "secret-tool"), []byte(fakeSecretTool), 0755))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_KEYRING", t.TempDir())
+}
+
+func TestSecretServiceKeychain(t *testing.T) {
+	t.Run("should store, read, and delete passwords per account", func(t *testing.T) {
+		// Arrange
+		installFakeSecretTool(t)
+		keychain, err := SystemKeychain()
+		require.NoError(t, err)
+
+		// Act & Assert
+		_, err = keychain.Get("/backups/laptop")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+
+		require.NoError(t, keychain.Set("/backups/laptop", "correct horse"))
+		require.NoError(t, keychain.Set("s3://bucket/desktop", "battery staple"))
+		password, err := keychain.Get("/backups/laptop")
+		require.NoError(t, err)
+		assert.Equal(t, "correct horse", password)
+
+		require.NoError(t, keychain.Delete("/backups/laptop"))
+		_, err = keychain.Get("/backups/laptop")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		password, err = keychain.Get("s3://bucket/desktop")
+		require.NoError(t, err)
+		assert.Equal(t, "battery staple", password)
+	})
+
+	t.Run("should report a missing secret-tool as unsupported", func(t *testing.T) {
+		// Arrange
+		t.Setenv("PATH", t.TempDir())
+
+		// Act
+		_, err := SystemKeychain()
+
+		// Assert
+		assert.ErrorIs(t, err, errors.ErrUnsupported)
+		assert.ErrorContains(t, err, "secret-tool")
+	})
+}
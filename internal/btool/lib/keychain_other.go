@@ -0,0 +1,8 @@
+//go:build !darwin && !linux && !windows
+
+package lib
+
+// SystemKeychain reports that this platform has no supported keychain.
+func SystemKeychain() (Keychain, error) {
+	return nil, errKeychainUnsupported("not supported on this platform")
+}
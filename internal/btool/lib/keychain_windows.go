@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+)
+
+// Constants from wincred.h.
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredDel   = advapi32.NewProc("CredDeleteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+// credential mirrors the CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credentialManager stores passwords as generic credentials in the Windows
+// Credential Manager.
+type credentialManager struct{}
+
+// SystemKeychain returns the Credential Manager of the current user.
+func SystemKeychain() (Keychain, error) {
+	if err := advapi32.Load(); err != nil {
+		return nil, errKeychainUnsupported(err.Error())
+	}
+	return credentialManager{}, nil
+}
+
+// target returns the credential name for account.
+func (credentialManager) target(account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(KeychainService + ":" + account)
+}
+
+// credentialError converts the error of a failed call.
+func credentialError(err error) error {
+	if errors.Is(err, errorNotFound) {
+		return errNoKeychainEntry
+	}
+	return err
+}
+
+// Get implements Keychain.
+func (k credentialManager) Get(account string) (string, error) {
+	target, err := k.target(account)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	ok, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		return "", credentialError(err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// Set implements Keychain.
+func (k credentialManager) Set(account, password string) error {
+	target, err := k.target(account)
+	if err != nil {
+		return err
+	}
+	userName, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+		CredentialBlobSize: uint32(len(password)),
+	}
+	if len(password) > 0 {
+		blob := []byte(password)
+		cred.CredentialBlob = &blob[0]
+	}
+	ok, _, err := procCredWrite.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if ok == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
+
+// Delete implements Keychain.
+func (k credentialManager) Delete(account string) error {
+	target, err := k.target(account)
+	if err != nil {
+		return err
+	}
+	ok, _, err := procCredDel.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if ok == 0 {
+		return credentialError(err)
+	}
+	return nil
+}
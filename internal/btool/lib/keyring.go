@@ -0,0 +1,346 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// keyring is the stored form of the data keys of a repository whose key has
+// been rotated. It is sealed under the master key that the key slots unlock.
+type keyring struct {
+	// Current is the hex ID of the data key that seals new data.
+	Current string `json:"current"`
+	// Keys are all data keys that may still seal stored data, by hex ID.
+	Keys map[string][]byte `json:"keys"`
+	// MasterKeys are the master keys the repository had before the current
+	// one, which may still seal stored data.
+	MasterKeys [][]byte `json:"masterKeys,omitempty"`
+	// Identities are the repository's age identities, the current one first
+	// and then those that may still wrap the session keys of stored data.
+	// Keyrings written before identities were rotated have none, and the
+	// identity is read from the recipient file.
+	Identities []string `json:"identities,omitempty"`
+}
+
+// readKeyring reads and decrypts the keyring. A repository that was never
+// rotated has an empty one.
+func (k *RepositoryKey) readKeyring() (*keyring, error) {
+	ring := &keyring{Keys: make(map[string][]byte)}
+	content, err := k.backend.Get(KeyringFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ring, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read keyring: %w", err)
+	}
+	plaintext, err := open(k.master, 1, content)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt keyring: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, ring); err != nil {
+		return nil, fmt.Errorf("could not parse keyring: %w", err)
+	}
+	if _, ok := ring.Keys[ring.Current]; !ok {
+		return nil, fmt.Errorf("corrupt keyring: current data key %s is missing", ring.Current)
+	}
+	return ring, nil
+}
+
+// useKeyring makes the key seal with the keyring's current data key and open
+// data sealed with any of its keys.
+func (k *RepositoryKey) useKeyring(ring *keyring) error {
+	dataKeys := make(map[string]cipher.AEAD, len(ring.Keys))
+	for id, key := range ring.Keys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return fmt.Errorf("corrupt keyring: data key %s: %w", id, err)
+		}
+		dataKeys[id] = aead
+	}
+	oldMasters := make([]cipher.AEAD, 0, len(ring.MasterKeys))
+	for _, key := range ring.MasterKeys {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return fmt.Errorf("corrupt keyring: old master key: %w", err)
+		}
+		oldMasters = append(oldMasters, aead)
+	}
+	var identity *age.X25519Identity
+	var oldIdentities []age.Identity
+	for i, encoded := range ring.Identities {
+		parsed, err := age.ParseX25519Identity(encoded)
+		if err != nil {
+			return fmt.Errorf("corrupt keyring: repository identity: %w", err)
+		}
+		if i == 0 {
+			identity = parsed
+		} else {
+			oldIdentities = append(oldIdentities, parsed)
+		}
+	}
+	k.dataKeys = dataKeys
+	k.dataKey = dataKeys[ring.Current]
+	k.dataKeyID, _ = hex.DecodeString(ring.Current)
+	k.oldMasters = oldMasters
+	if identity != nil {
+		k.identity, k.oldIdentities = identity, oldIdentities
+	}
+	return nil
+}
+
+// loadKeyring loads the keyring, if the repository has one.
+func (k *RepositoryKey) loadKeyring() error {
+	ring, err := k.readKeyring()
+	if err != nil || ring.Current == "" {
+		return err
+	}
+	return k.useKeyring(ring)
+}
+
+// writeKeyring seals ring under the master key, stores it, and starts using
+// it.
+func (k *RepositoryKey) writeKeyring(ring *keyring) error {
+	content, err := json.MarshalIndent(ring, "", "  ")
+	if err != nil {
+		return err
+	}
+	sealed, err := seal(k.master, []byte{envelopeVersion}, content)
+	if err != nil {
+		return err
+	}
+	if err := k.backend.Put(KeyringFileName, sealed); err != nil {
+		return fmt.Errorf("could not store keyring: %w", err)
+	}
+	return k.useKeyring(ring)
+}
+
+// DataKeyID returns the hex ID of the data key that seals new data, or an
+// empty string if the master key does because the key was never rotated.
+func (k *RepositoryKey) DataKeyID() string {
+	return hex.EncodeToString(k.dataKeyID)
+}
+
+// RotateDataKey generates a new data key and seals everything written from
+// now on with it, returning its ID. It also replaces the master key, which
+// sealed the data of a repository that was never rotated, and the repository
+// identity, which the master key unseals, so that whoever leaked them cannot
+// unseal the new keyring. Every key slot is rewrapped under the new master
+// key: age and KMS slots by their public keys, and password slots only with
+// the password this key was unlocked with. A password slot it does not open
+// makes rotation fail before anything is changed, since it would otherwise
+// be locked out.
+//
+// Data already stored stays sealed with the previous keys, which remain in
+// the keyring until RetireDataKeys, once the data has been re-encrypted.
+func (k *RepositoryKey) RotateDataKey() (string, error) {
+	return k.rotate(ParseKMSKey)
+}
+
+// rotate is RotateDataKey with the KMS keys of the slots resolved by
+// parseKMS.
+func (k *RepositoryKey) rotate(parseKMS func(uri string) (*KMSKey, error)) (string, error) {
+	if err := k.requireMaster(); err != nil {
+		return "", err
+	}
+	ring, err := k.readKeyring()
+	if err != nil {
+		return "", err
+	}
+	masterKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(masterKey); err != nil {
+		return "", err
+	}
+	master, err := newAEAD(masterKey)
+	if err != nil {
+		return "", err
+	}
+
+	// Wrapping can fail, with a KMS or a foreign password, so every slot is
+	// rewrapped before anything is stored.
+	entries, err := k.backend.List(KeysDirName)
+	if err != nil {
+		return "", err
+	}
+	var oldIDs, locked []string
+	var slots []*keySlot
+	currentSlot := -1
+	for _, entry := range entries {
+		content, err := k.backend.Get(keySlotName(entry.Name))
+		if err != nil {
+			return "", fmt.Errorf("could not read key %s: %w", entry.Name, err)
+		}
+		var slot keySlot
+		if err := json.Unmarshal(content, &slot); err != nil {
+			return "", fmt.Errorf("could not parse key %s: %w", entry.Name, err)
+		}
+		oldIDs = append(oldIDs, entry.Name)
+		rewrapped, ok, err := k.rewrapSlot(&slot, masterKey, parseKMS)
+		if err != nil {
+			return "", fmt.Errorf("could not rewrap key %s: %w", entry.Name, err)
+		}
+		if !ok {
+			locked = append(locked, entry.Name)
+		}
+		if rewrapped == nil {
+			continue
+		}
+		if entry.Name == k.slotID {
+			currentSlot = len(slots)
+		}
+		slots = append(slots, rewrapped)
+	}
+	if len(locked) > 0 {
+		return "", fmt.Errorf("password key(s) %s cannot be moved to the new master key without their passwords; remove them with 'key remove' and add them again after rotating", strings.Join(locked, ", "))
+	}
+
+	dataKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	id, err := randomHex(dataKeyIDSize)
+	if err != nil {
+		return "", err
+	}
+	ring.Keys[id] = dataKey
+	ring.Current = id
+	ring.MasterKeys = append(ring.MasterKeys, k.masterKey)
+	var identity *age.X25519Identity
+	if k.identity != nil {
+		if identity, err = age.GenerateX25519Identity(); err != nil {
+			return "", err
+		}
+		if len(ring.Identities) == 0 {
+			ring.Identities = []string{k.identity.String()}
+		}
+		ring.Identities = append([]string{identity.String()}, ring.Identities...)
+	}
+
+	// The new slots come first and the old ones go last, so an interrupted
+	// rotation leaves slots that open the repository, under the old master
+	// key until the keyring is stored and under the new one after.
+	var newIDs []string
+	for _, slot := range slots {
+		newID, err := putKeySlot(k.backend, slot)
+		if err != nil {
+			return "", err
+		}
+		newIDs = append(newIDs, newID)
+	}
+	k.masterKey, k.master = masterKey, master
+	if err := k.writeKeyring(ring); err != nil {
+		return "", err
+	}
+	if identity != nil {
+		if err := k.writeRecipient(identity); err != nil {
+			return "", err
+		}
+	}
+	for _, oldID := range oldIDs {
+		if err := k.backend.Delete(keySlotName(oldID)); err != nil {
+			return "", fmt.Errorf("rotated the key, but could not remove old key %s: %w", oldID, err)
+		}
+	}
+	k.slotID = ""
+	if currentSlot >= 0 {
+		k.slotID = newIDs[currentSlot]
+	}
+	return id, nil
+}
+
+// rewrapSlot returns a copy of slot that wraps masterKey instead. It reports
+// false for a password slot that the key's password does not open, and
+// returns nil for one left over from an interrupted rotation, which opens to
+// another master key and so unlocks nothing.
+func (k *RepositoryKey) rewrapSlot(slot *keySlot, masterKey []byte, parseKMS func(uri string) (*KMSKey, error)) (*keySlot, bool, error) {
+	switch slot.Type {
+	case ageSlot:
+		recipient, err := age.ParseX25519Recipient(slot.Recipient)
+		if err != nil {
+			return nil, false, err
+		}
+		rewrapped, err := newAgeSlot(masterKey, recipient)
+		return rewrapped, true, err
+	case kmsSlot:
+		kmsKey, err := parseKMS(slot.KMS)
+		if err != nil {
+			return nil, false, err
+		}
+		rewrapped, err := newKMSSlot(masterKey, kmsKey)
+		return rewrapped, true, err
+	}
+	if k.password == "" {
+		return nil, false, nil
+	}
+	aead, err := slot.wrappingKey(k.password)
+	if err != nil {
+		return nil, false, err
+	}
+	wrapped, err := open(aead, 1, slot.Key)
+	if err != nil {
+		return nil, false, nil
+	}
+	if !bytes.Equal(wrapped, k.masterKey) {
+		return nil, true, nil
+	}
+	rewrapped, err := newPasswordSlot(masterKey, k.password, slot.kdfParams())
+	return rewrapped, true, err
+}
+
+// writeRecipient makes identity the repository's own key pair, which
+// write-only backups encrypt their session keys to from now on.
+func (k *RepositoryKey) writeRecipient(identity *age.X25519Identity) error {
+	sealedIdentity, err := seal(k.master, []byte{envelopeVersion}, []byte(identity.String()))
+	if err != nil {
+		return err
+	}
+	recipientJSON, err := json.MarshalIndent(repositoryRecipient{Recipient: identity.Recipient().String(), Identity: sealedIdentity}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := k.backend.Put(RecipientFileName, recipientJSON); err != nil {
+		return fmt.Errorf("could not store repository recipient: %w", err)
+	}
+	return nil
+}
+
+// RetireDataKeys removes every data key but the current one from the
+// keyring, along with the old master keys and repository identities, so data
+// sealed with them can no longer be read. Call it only once
+// all stored data has been re-encrypted with the current key.
+func (k *RepositoryKey) RetireDataKeys() error {
+	if err := k.requireMaster(); err != nil {
+		return err
+	}
+	if k.dataKey == nil {
+		return errors.New("the repository key has not been rotated")
+	}
+	ring, err := k.readKeyring()
+	if err != nil {
+		return err
+	}
+	current := hex.EncodeToString(k.dataKeyID)
+	if ring.Current != current {
+		return fmt.Errorf("the keyring was rotated to data key %s by another command", ring.Current)
+	}
+	retired := &keyring{Current: current, Keys: map[string][]byte{current: ring.Keys[current]}}
+	if len(ring.Identities) > 0 {
+		retired.Identities = ring.Identities[:1]
+	}
+	return k.writeKeyring(retired)
+}
+
+// sealedWithCurrentKey reports whether envelope was sealed with the current
+// data key, so re-encrypting it would change nothing.
+func (k *RepositoryKey) sealedWithCurrentKey(envelope []byte) bool {
+	return k.dataKey != nil && len(envelope) > dataKeyIDSize &&
+		envelope[0] == dataKeyEnvelopeVersion && bytes.Equal(envelope[1:1+dataKeyIDSize], k.dataKeyID)
+}
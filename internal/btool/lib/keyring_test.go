@@ -0,0 +1,258 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotateDataKey(t *testing.T) {
+	// newRepository returns an encrypted repository with a password slot and
+	// an age slot for the returned identity.
+	newRepository := func(t *testing.T) (Backend, *RepositoryKey, *age.X25519Identity) {
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "first", cheapKDF, identity.Recipient())
+		require.NoError(t, err)
+		return backend, key, identity
+	}
+
+	t.Run("should seal new data with the new key and still open old data", func(t *testing.T) {
+		// Arrange
+		_, key, _ := newRepository(t)
+		before, err := key.Seal([]byte("before"))
+		require.NoError(t, err)
+
+		// Act
+		id, err := key.RotateDataKey()
+		require.NoError(t, err)
+		after, err := key.Seal([]byte("after"))
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, id, key.DataKeyID())
+		assert.Equal(t, byte(dataKeyEnvelopeVersion), after[0])
+		assert.True(t, key.sealedWithCurrentKey(after))
+		assert.False(t, key.sealedWithCurrentKey(before))
+		opened, err := key.Open(before)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("before"), opened)
+	})
+
+	t.Run("should rewrap every slot under the new master key", func(t *testing.T) {
+		// Arrange
+		backend, key, identity := newRepository(t)
+		_, err := key.AddKMSSlot(newFakeKMSKey(t, "awskms://alias/btool"))
+		require.NoError(t, err)
+		before, err := key.Seal([]byte("before"))
+		require.NoError(t, err)
+
+		// Act
+		id, err := key.rotate(func(uri string) (*KMSKey, error) {
+			return newFakeKMSKey(t, uri), nil
+		})
+		require.NoError(t, err)
+		after, err := key.Seal([]byte("after"))
+		require.NoError(t, err)
+
+		// Assert
+		byPassword, err := UnlockRepositoryKey(backend, "first")
+		require.NoError(t, err)
+		byIdentity, err := UnlockRepositoryKeyWithIdentities(backend, []age.Identity{identity})
+		require.NoError(t, err)
+		byKMS, err := unlockWithFakeKMS(t, backend)
+		require.NoError(t, err)
+		for _, unlocked := range []*RepositoryKey{byPassword, byIdentity, byKMS} {
+			assert.Equal(t, id, unlocked.DataKeyID())
+			for want, sealed := range map[string][]byte{"before": before, "after": after} {
+				opened, err := unlocked.Open(sealed)
+				require.NoError(t, err)
+				assert.Equal(t, want, string(opened))
+			}
+		}
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		assert.Len(t, slots, 3)
+	})
+
+	t.Run("should lock out the leaked master key and repository identity", func(t *testing.T) {
+		// Arrange
+		backend, key, _ := newRepository(t)
+		leakedKey, leakedIdentity := key.masterKey, key.identity
+
+		// Act
+		_, err := key.RotateDataKey()
+		require.NoError(t, err)
+
+		// Assert
+		_, err = openRepositoryKey(backend, leakedKey)
+		assert.ErrorContains(t, err, "could not decrypt keyring")
+		writeOnly, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+		wrapped, err := backend.Get(DataKeysDirName + "/" + writeOnly.SessionID())
+		require.NoError(t, err)
+		_, err = age.Decrypt(bytes.NewReader(wrapped), leakedIdentity)
+		assert.Error(t, err, "new session keys must not be encrypted to the leaked identity")
+	})
+
+	t.Run("should still read write-only backups from before the rotation", func(t *testing.T) {
+		// Arrange
+		backend, key, _ := newRepository(t)
+		writeOnly, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+		sealed, err := writeOnly.Seal([]byte("unattended"))
+		require.NoError(t, err)
+
+		// Act
+		_, err = key.RotateDataKey()
+		require.NoError(t, err)
+
+		// Assert
+		unlocked, err := UnlockRepositoryKey(backend, "first")
+		require.NoError(t, err)
+		opened, err := unlocked.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("unattended"), opened)
+	})
+
+	t.Run("should refuse to rotate past a password slot it cannot rewrap", func(t *testing.T) {
+		// Arrange
+		backend, key, _ := newRepository(t)
+		_, err := key.AddPasswordSlot("second")
+		require.NoError(t, err)
+
+		// Act
+		_, err = key.RotateDataKey()
+
+		// Assert
+		assert.ErrorContains(t, err, "without their passwords")
+		unlocked, err := UnlockRepositoryKey(backend, "second")
+		require.NoError(t, err)
+		assert.Empty(t, unlocked.DataKeyID())
+	})
+
+	t.Run("should no longer open data sealed with a retired key", func(t *testing.T) {
+		// Arrange
+		backend, key, _ := newRepository(t)
+		_, err := key.RotateDataKey()
+		require.NoError(t, err)
+		old, err := key.Seal([]byte("old"))
+		require.NoError(t, err)
+		_, err = key.RotateDataKey()
+		require.NoError(t, err)
+
+		// Act
+		err = key.RetireDataKeys()
+
+		// Assert
+		require.NoError(t, err)
+		unlocked, err := UnlockRepositoryKey(backend, "first")
+		require.NoError(t, err)
+		_, err = unlocked.Open(old)
+		assert.ErrorContains(t, err, "not in the keyring")
+	})
+
+	t.Run("should refuse to retire keys before rotating", func(t *testing.T) {
+		_, key, _ := newRepository(t)
+		assert.ErrorContains(t, key.RetireDataKeys(), "has not been rotated")
+	})
+}
+
+func TestReencrypt(t *testing.T) {
+	t.Run("should reseal every pack, snap, and the index with the new key", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		first, err := store.WriteObject([]byte("first object"))
+		require.NoError(t, err)
+		second, err := store.WriteObject([]byte("second object"))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+		_, err = key.RotateDataKey()
+		require.NoError(t, err)
+		var calls int
+
+		// Act
+		stats, err := store.Reencrypt(func(done, total int) { calls++ })
+		require.NoError(t, err)
+		require.NoError(t, key.RetireDataKeys())
+
+		// Assert
+		assert.Equal(t, ReencryptStats{Packs: 1, Objects: 2}, stats)
+		assert.Equal(t, 1, calls)
+		unlocked, err := UnlockRepositoryKey(backend, "pw")
+		require.NoError(t, err)
+		reopened := NewEncryptedObjectStore(backend, unlocked)
+		for hash, want := range map[string]string{first: "first object", second: "second object"} {
+			data, err := reopened.ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, want, string(data))
+		}
+		packs, err := reopened.ListPacks()
+		require.NoError(t, err)
+		assert.Len(t, packs, 1)
+	})
+
+	t.Run("should skip packs that already use the new key", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		_, err = key.RotateDataKey()
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		_, err = store.WriteObject([]byte("object"))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+
+		// Act
+		stats, err := store.Reencrypt(nil)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, ReencryptStats{}, stats)
+	})
+
+	t.Run("should refuse while the trash holds files sealed with the old keys", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		_, err = store.WriteObject([]byte("object"))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+		packs, err := store.ListPacks()
+		require.NoError(t, err)
+		require.Len(t, packs, 1)
+		_, err = store.StartTrash(time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.NoError(t, store.DeletePack(packs[0].Name))
+		_, err = key.RotateDataKey()
+		require.NoError(t, err)
+
+		// Act
+		_, err = store.Reencrypt(nil)
+
+		// Assert
+		assert.ErrorContains(t, err, "the trash kept by 1 earlier prune(s) is sealed with the old keys")
+	})
+
+	t.Run("should refuse a key that was never rotated", func(t *testing.T) {
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		_, err = NewEncryptedObjectStore(backend, key).Reencrypt(nil)
+		assert.ErrorContains(t, err, "has not been rotated")
+	})
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"filippo.io/age"
+)
+
+// KeySlotInfo describes one key slot of an encrypted repository, without any
+// secret material.
+type KeySlotInfo struct {
+	ID        string
+	Type      string // "password", "age", or "kms".
+	Recipient string // The age public key, for age slots.
+	KDF       string // The key derivation function and its costs, for password slots.
+	KMS       string // The KMS key URI, for KMS slots.
+	Created   string // RFC 3339, empty for slots created before it was recorded.
+	Current   bool   // Whether this slot unlocked the key used to list it.
+}
+
+// ListKeySlots describes every key slot of the repository, oldest first.
+func (k *RepositoryKey) ListKeySlots() ([]KeySlotInfo, error) {
+	entries, err := k.backend.List(KeysDirName)
+	if err != nil {
+		return nil, err
+	}
+
+	var slots []KeySlotInfo
+	for _, entry := range entries {
+		content, err := k.backend.Get(keySlotName(entry.Name))
+		if err != nil {
+			return nil, fmt.Errorf("could not read key %s: %w", entry.Name, err)
+		}
+		var slot keySlot
+		if err := json.Unmarshal(content, &slot); err != nil {
+			return nil, fmt.Errorf("could not parse key %s: %w", entry.Name, err)
+		}
+		info := KeySlotInfo{
+			ID:        entry.Name,
+			Type:      slot.Type,
+			Recipient: slot.Recipient,
+			KMS:       slot.KMS,
+			Created:   slot.Created,
+			Current:   entry.Name == k.slotID,
+		}
+		if info.Type == "" || info.Type == passwordSlot {
+			info.Type = passwordSlot
+			info.KDF = slot.kdfParams().String()
+		}
+		slots = append(slots, info)
+	}
+
+	sort.Slice(slots, func(i, j int) bool {
+		if slots[i].Created != slots[j].Created {
+			return slots[i].Created < slots[j].Created
+		}
+		return slots[i].ID < slots[j].ID
+	})
+	return slots, nil
+}
+
+// requireMaster fails for write-only keys, which cannot grant access.
+func (k *RepositoryKey) requireMaster() error {
+	if k.masterKey == nil {
+		return ErrWriteOnly
+	}
+	return nil
+}
+
+// SetKDF sets the key derivation parameters of the password slots the key
+// adds from now on, including by ChangePassword. Existing slots keep theirs.
+func (k *RepositoryKey) SetKDF(kdf KDFParams) error {
+	if err := kdf.Validate(); err != nil {
+		return err
+	}
+	k.kdf = kdf
+	return nil
+}
+
+// AddPasswordSlot grants access to password holders by storing the master key
+// in a new slot protected by password. It returns the new slot's ID.
+func (k *RepositoryKey) AddPasswordSlot(password string) (string, error) {
+	if err := k.requireMaster(); err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", errors.New("the new password must not be empty")
+	}
+	kdf := k.kdf
+	if kdf.Algorithm == "" {
+		kdf = DefaultKDF()
+	}
+	slot, err := newPasswordSlot(k.masterKey, password, kdf)
+	if err != nil {
+		return "", err
+	}
+	return putKeySlot(k.backend, slot)
+}
+
+// AddAgeSlot grants access to the holder of the identity for recipient by
+// storing the master key in a new slot encrypted to it. It returns the new
+// slot's ID.
+func (k *RepositoryKey) AddAgeSlot(recipient *age.X25519Recipient) (string, error) {
+	if err := k.requireMaster(); err != nil {
+		return "", err
+	}
+	slot, err := newAgeSlot(k.masterKey, recipient)
+	if err != nil {
+		return "", err
+	}
+	return putKeySlot(k.backend, slot)
+}
+
+// AddKMSSlot grants access to whoever may use kmsKey by storing the master
+// key in a new slot wrapped with it. It returns the new slot's ID.
+func (k *RepositoryKey) AddKMSSlot(kmsKey *KMSKey) (string, error) {
+	if err := k.requireMaster(); err != nil {
+		return "", err
+	}
+	slot, err := newKMSSlot(k.masterKey, kmsKey)
+	if err != nil {
+		return "", err
+	}
+	return putKeySlot(k.backend, slot)
+}
+
+// RemoveKeySlot revokes the key slot with the given ID. The last slot cannot
+// be removed, since that would make the repository unreadable.
+//
+// Removing a slot revokes access for anyone who has not already copied the
+// master key; it does not re-encrypt existing data.
+func (k *RepositoryKey) RemoveKeySlot(id string) error {
+	if err := k.requireMaster(); err != nil {
+		return err
+	}
+	entries, err := k.backend.List(KeysDirName)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, entry := range entries {
+		found = found || entry.Name == id
+	}
+	if !found {
+		return fmt.Errorf("no key with ID %q", id)
+	}
+	if len(entries) == 1 {
+		return errors.New("cannot remove the only key of the repository")
+	}
+	if err := k.backend.Delete(keySlotName(id)); err != nil {
+		return err
+	}
+	if id == k.slotID {
+		k.slotID = ""
+	}
+	return nil
+}
+
+// ChangePassword replaces the password slot this key was unlocked with by a
+// new slot protected by newPassword, and returns the new slot's ID.
+func (k *RepositoryKey) ChangePassword(newPassword string) (string, error) {
+	if err := k.requireMaster(); err != nil {
+		return "", err
+	}
+	slots, err := k.ListKeySlots()
+	if err != nil {
+		return "", err
+	}
+	oldSlotID := ""
+	for _, slot := range slots {
+		if slot.Current && slot.Type == passwordSlot {
+			oldSlotID = slot.ID
+		}
+	}
+	if oldSlotID == "" {
+		return "", errors.New("the repository was not unlocked with a password")
+	}
+	newSlotID, err := k.AddPasswordSlot(newPassword)
+	if err != nil {
+		return "", err
+	}
+	// The new slot is in place before the old one goes, so a failure here
+	// leaves both passwords working rather than neither.
+	if err := k.backend.Delete(keySlotName(oldSlotID)); err != nil {
+		return "", fmt.Errorf("added the new password, but could not remove the old one: %w", err)
+	}
+	k.slotID = newSlotID
+	k.password = newPassword
+	return newSlotID, nil
+}
@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"testing"
+
+	"filippo.io/age"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeySlots(t *testing.T) {
+	// newRepository returns an encrypted repository with a single password slot.
+	newRepository := func(t *testing.T) (Backend, *RepositoryKey) {
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKey(backend, "first")
+		require.NoError(t, err)
+		return backend, key
+	}
+
+	t.Run("should let every added password unlock the same data", func(t *testing.T) {
+		// Arrange
+		backend, key := newRepository(t)
+		sealed, err := key.Seal([]byte("data"))
+		require.NoError(t, err)
+
+		// Act
+		_, err = key.AddPasswordSlot("second")
+		require.NoError(t, err)
+
+		// Assert
+		for _, password := range []string{"first", "second"} {
+			unlocked, err := UnlockRepositoryKey(backend, password)
+			require.NoError(t, err, "password %q", password)
+			opened, err := unlocked.Open(sealed)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("data"), opened)
+		}
+	})
+
+	t.Run("should list slots and mark the one in use", func(t *testing.T) {
+		// Arrange
+		_, key := newRepository(t)
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		ageID, err := key.AddAgeSlot(identity.Recipient())
+		require.NoError(t, err)
+
+		// Act
+		slots, err := key.ListKeySlots()
+
+		// Assert
+		require.NoError(t, err)
+		require.Len(t, slots, 2)
+		for _, slot := range slots {
+			if slot.ID == ageID {
+				assert.Equal(t, "age", slot.Type)
+				assert.Equal(t, identity.Recipient().String(), slot.Recipient)
+				assert.False(t, slot.Current)
+			} else {
+				assert.Equal(t, "password", slot.Type)
+				assert.True(t, slot.Current)
+			}
+			assert.NotEmpty(t, slot.Created)
+		}
+	})
+
+	t.Run("should revoke a removed slot but keep the last one", func(t *testing.T) {
+		// Arrange
+		backend, key := newRepository(t)
+		secondID, err := key.AddPasswordSlot("second")
+		require.NoError(t, err)
+
+		// Act
+		require.NoError(t, key.RemoveKeySlot(secondID))
+
+		// Assert
+		_, err = UnlockRepositoryKey(backend, "second")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+		slots, err := key.ListKeySlots()
+		require.NoError(t, err)
+		require.Len(t, slots, 1)
+		assert.ErrorContains(t, key.RemoveKeySlot(slots[0].ID), "only key")
+		assert.ErrorContains(t, key.RemoveKeySlot("missing"), "no key")
+	})
+
+	t.Run("should change the password in use", func(t *testing.T) {
+		// Arrange
+		backend, key := newRepository(t)
+
+		// Act
+		_, err := key.ChangePassword("changed")
+
+		// Assert
+		require.NoError(t, err)
+		_, err = UnlockRepositoryKey(backend, "first")
+		assert.ErrorIs(t, err, ErrWrongPassword)
+		_, err = UnlockRepositoryKey(backend, "changed")
+		assert.NoError(t, err)
+	})
+
+	t.Run("should not manage keys without the master key", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		identity, err := age.GenerateX25519Identity()
+		require.NoError(t, err)
+		_, err = InitRepositoryKey(backend, "", identity.Recipient())
+		require.NoError(t, err)
+		writeOnly, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+
+		// Act
+		_, err = writeOnly.AddPasswordSlot("sneaky")
+
+		// Assert
+		assert.ErrorIs(t, err, ErrWriteOnly)
+	})
+}
@@ -0,0 +1,119 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// KMSKey is a key held by a cloud key management service, which wraps and
+// unwraps a repository's master key so that btool never stores it in the
+// clear and access follows the service's policy and audit log. Every
+// operation runs the provider's command-line tool, so credentials, regions,
+// and profiles come from the user's configuration of that tool.
+//
+// Keys are named by URI:
+//
+//	awskms://<key ID, ARN, or alias>                                  (aws)
+//	gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>   (gcloud)
+//	azurekeyvault://<vault>.vault.azure.net/keys/<name>[/<version>]   (az)
+type KMSKey struct {
+	uri        string
+	command    []string // The executable and any leading arguments.
+	wrapArgs   []string
+	unwrapArgs []string
+	// inputBase64 and outputBase64 are set for tools that take or print the
+	// data base64 encoded rather than raw.
+	inputBase64  bool
+	outputBase64 bool
+}
+
+// ParseKMSKey returns the KMSKey named by uri.
+func ParseKMSKey(uri string) (*KMSKey, error) {
+	scheme, name, found := strings.Cut(uri, "://")
+	if !found || name == "" {
+		return nil, fmt.Errorf("invalid KMS key %q: expected awskms://, gcpkms://, or azurekeyvault:// followed by the key", uri)
+	}
+	key := &KMSKey{uri: uri}
+	switch scheme {
+	case "awskms":
+		// The data goes through /dev/stdin so it never appears in the
+		// process list.
+		key.command = []string{"aws", "kms"}
+		key.wrapArgs = []string{"encrypt", "--key-id", name, "--plaintext", "fileb:///dev/stdin", "--output", "text", "--query", "CiphertextBlob"}
+		key.unwrapArgs = []string{"decrypt", "--key-id", name, "--ciphertext-blob", "fileb:///dev/stdin", "--output", "text", "--query", "Plaintext"}
+		key.outputBase64 = true
+	case "gcpkms":
+		if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/cryptoKeys/") {
+			return nil, fmt.Errorf("invalid KMS key %q: expected gcpkms://projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>", uri)
+		}
+		key.command = []string{"gcloud", "kms"}
+		key.wrapArgs = []string{"encrypt", "--key", name, "--plaintext-file", "-", "--ciphertext-file", "-"}
+		key.unwrapArgs = []string{"decrypt", "--key", name, "--ciphertext-file", "-", "--plaintext-file", "-"}
+	case "azurekeyvault":
+		vault, keyPath, _ := strings.Cut(name, "/")
+		if !strings.HasPrefix(keyPath, "keys/") {
+			return nil, fmt.Errorf("invalid KMS key %q: expected azurekeyvault://<vault>.vault.azure.net/keys/<name>", uri)
+		}
+		id := "https://" + vault + "/" + keyPath
+		// "@file" makes az read the value from a file, here standard input.
+		key.command = []string{"az", "keyvault", "key"}
+		key.wrapArgs = []string{"encrypt", "--id", id, "--algorithm", "RSA-OAEP-256", "--data-type", "base64", "--value", "@/dev/stdin", "--query", "result", "--output", "tsv"}
+		key.unwrapArgs = []string{"decrypt", "--id", id, "--algorithm", "RSA-OAEP-256", "--data-type", "base64", "--value", "@/dev/stdin", "--query", "result", "--output", "tsv"}
+		key.inputBase64, key.outputBase64 = true, true
+	default:
+		return nil, fmt.Errorf("unsupported KMS %q in %q: use awskms, gcpkms, or azurekeyvault", scheme, uri)
+	}
+	return key, nil
+}
+
+// URI returns the URI that names the key.
+func (k *KMSKey) URI() string {
+	return k.uri
+}
+
+// Wrap encrypts data with the KMS key.
+func (k *KMSKey) Wrap(data []byte) ([]byte, error) {
+	return k.run(k.wrapArgs, data)
+}
+
+// Unwrap decrypts data that Wrap encrypted.
+func (k *KMSKey) Unwrap(data []byte) ([]byte, error) {
+	return k.run(k.unwrapArgs, data)
+}
+
+// run executes the provider's tool with args, feeding it data on standard
+// input, and returns the data it prints.
+func (k *KMSKey) run(args []string, data []byte) ([]byte, error) {
+	input := data
+	if k.inputBase64 {
+		input = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	cmd := exec.Command(k.command[0], append(slices.Clone(k.command[1:]), args...)...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("KMS key %s requires the %s command: %w", k.uri, k.command[0], err)
+		}
+		return nil, fmt.Errorf("%s %s for %s: %w: %s", strings.Join(k.command, " "), args[0], k.uri, err, strings.TrimSpace(stderr.String()))
+	}
+	if !k.outputBase64 {
+		return stdout.Bytes(), nil
+	}
+	encoded := strings.TrimSpace(stdout.String())
+	output, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		// Azure Key Vault may answer in the URL-safe alphabet.
+		if output, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "=")); err != nil {
+			return nil, fmt.Errorf("%s %s for %s printed invalid base64: %w", k.command[0], args[0], k.uri, err)
+		}
+	}
+	return output, nil
+}
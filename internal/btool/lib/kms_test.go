@@ -0,0 +1,214 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMSPrefix is what the fake KMS prepends to the data it wraps.
+const fakeKMSPrefix = "wrapped:"
+
+// TestKMSHelperProcess is not a real test. It stands in for the aws, gcloud,
+// and az tools when re-executed by newFakeKMSKey, wrapping data by prefixing
+// it with fakeKMSPrefix. It fails if BTOOL_FAKE_KMS_FAIL is set.
+func TestKMSHelperProcess(t *testing.T) {
+	if os.Getenv("BTOOL_FAKE_KMS") == "" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+	if os.Getenv("BTOOL_FAKE_KMS_FAIL") != "" {
+		fmt.Fprintln(os.Stderr, "AccessDeniedException")
+		os.Exit(254)
+	}
+
+	input, _ := io.ReadAll(os.Stdin)
+	if args[0] == "az" {
+		input, _ = base64.StdEncoding.DecodeString(string(input))
+	}
+	var output []byte
+	switch {
+	case slices.Contains(args, "encrypt"):
+		output = append([]byte(fakeKMSPrefix), input...)
+	case slices.Contains(args, "decrypt") && bytes.HasPrefix(input, []byte(fakeKMSPrefix)):
+		output = input[len(fakeKMSPrefix):]
+	default:
+		fmt.Fprintln(os.Stderr, "InvalidCiphertextException")
+		os.Exit(1)
+	}
+	if args[0] == "gcloud" {
+		os.Stdout.Write(output)
+	} else {
+		fmt.Println(base64.StdEncoding.EncodeToString(output))
+	}
+	os.Exit(0)
+}
+
+// newFakeKMSKey returns the KMSKey for uri, with its tool replaced by this
+// test executable running TestKMSHelperProcess.
+func newFakeKMSKey(t *testing.T, uri string) *KMSKey {
+	t.Helper()
+	t.Setenv("BTOOL_FAKE_KMS", "1")
+	key, err := ParseKMSKey(uri)
+	require.NoError(t, err)
+	key.command = append([]string{os.Args[0], "-test.run=^TestKMSHelperProcess$", "--"}, key.command...)
+	return key
+}
+
+// unlockWithFakeKMS unlocks the repository in backend with its KMS slots,
+// using the fake KMS.
+func unlockWithFakeKMS(t *testing.T, backend Backend) (*RepositoryKey, error) {
+	return unlockRepositoryKeyWithKMS(backend, func(uri string) (*KMSKey, error) {
+		return newFakeKMSKey(t, uri), nil
+	})
+}
+
+func TestParseKMSKey(t *testing.T) {
+	testCases := []struct {
+		uri     string
+		command string
+		wantErr string
+	}{
+		{uri: "awskms://alias/btool", command: "aws"},
+		{uri: "gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k", command: "gcloud"},
+		{uri: "azurekeyvault://vault.vault.azure.net/keys/btool", command: "az"},
+		{uri: "gcpkms://my-key", wantErr: "expected gcpkms://projects/"},
+		{uri: "azurekeyvault://vault.vault.azure.net/secrets/btool", wantErr: "expected azurekeyvault://"},
+		{uri: "vault://transit/btool", wantErr: "unsupported KMS"},
+		{uri: "alias/btool", wantErr: "invalid KMS key"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.uri, func(t *testing.T) {
+			key, err := ParseKMSKey(tc.uri)
+			if tc.wantErr != "" {
+				assert.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.command, key.command[0])
+			assert.Equal(t, tc.uri, key.URI())
+		})
+	}
+
+	t.Run("should address the Azure key by its vault URL", func(t *testing.T) {
+		key, err := ParseKMSKey("azurekeyvault://vault.vault.azure.net/keys/btool/v1")
+		require.NoError(t, err)
+		assert.Contains(t, key.wrapArgs, "https://vault.vault.azure.net/keys/btool/v1")
+	})
+}
+
+func TestKMSKey(t *testing.T) {
+	for _, uri := range []string{
+		"awskms://alias/btool",
+		"gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k",
+		"azurekeyvault://vault.vault.azure.net/keys/btool",
+	} {
+		t.Run("should wrap and unwrap through "+uri, func(t *testing.T) {
+			// Arrange
+			key := newFakeKMSKey(t, uri)
+
+			// Act
+			wrapped, err := key.Wrap([]byte("master key"))
+			require.NoError(t, err)
+			unwrapped, err := key.Unwrap(wrapped)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, []byte(fakeKMSPrefix+"master key"), wrapped)
+			assert.Equal(t, []byte("master key"), unwrapped)
+		})
+	}
+
+	t.Run("should report the tool's error", func(t *testing.T) {
+		key := newFakeKMSKey(t, "awskms://alias/btool")
+		t.Setenv("BTOOL_FAKE_KMS_FAIL", "1")
+		_, err := key.Wrap([]byte("master key"))
+		assert.ErrorContains(t, err, "AccessDeniedException")
+	})
+}
+
+func TestKMSKeySlots(t *testing.T) {
+	t.Run("should create a repository that only the KMS unlocks", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := CreateRepositoryKey(backend, RepositoryKeySpec{KMSKeys: []*KMSKey{newFakeKMSKey(t, "awskms://alias/btool")}})
+		require.NoError(t, err)
+		sealed, err := key.Seal([]byte("data"))
+		require.NoError(t, err)
+
+		// Act
+		unlocked, err := unlockWithFakeKMS(t, backend)
+
+		// Assert
+		require.NoError(t, err)
+		opened, err := unlocked.Open(sealed)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), opened)
+		slots, err := unlocked.ListKeySlots()
+		require.NoError(t, err)
+		require.Len(t, slots, 1)
+		assert.Equal(t, KeySlotInfo{ID: slots[0].ID, Type: "kms", KMS: "awskms://alias/btool", Created: slots[0].Created, Current: true}, slots[0])
+		hasKMS, err := HasKMSSlots(backend)
+		require.NoError(t, err)
+		assert.True(t, hasKMS)
+	})
+
+	t.Run("should add a KMS slot to a password repository", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKeyWithKDF(backend, "pw", cheapKDF)
+		require.NoError(t, err)
+		hasKMS, err := HasKMSSlots(backend)
+		require.NoError(t, err)
+		require.False(t, hasKMS)
+
+		// Act
+		_, err = key.AddKMSSlot(newFakeKMSKey(t, "gcpkms://projects/p/locations/global/keyRings/r/cryptoKeys/k"))
+
+		// Assert
+		require.NoError(t, err)
+		_, err = unlockWithFakeKMS(t, backend)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should report why no KMS slot unwrapped", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		_, err := CreateRepositoryKey(backend, RepositoryKeySpec{KMSKeys: []*KMSKey{newFakeKMSKey(t, "awskms://alias/btool")}})
+		require.NoError(t, err)
+		t.Setenv("BTOOL_FAKE_KMS_FAIL", "1")
+
+		// Act
+		_, err = unlockWithFakeKMS(t, backend)
+
+		// Assert
+		assert.ErrorIs(t, err, ErrNoKMSKey)
+		assert.ErrorContains(t, err, "AccessDeniedException")
+	})
+
+	t.Run("should store nothing when wrapping fails", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		kmsKey := newFakeKMSKey(t, "awskms://alias/btool")
+		t.Setenv("BTOOL_FAKE_KMS_FAIL", "1")
+
+		// Act
+		_, err := CreateRepositoryKey(backend, RepositoryKeySpec{KMSKeys: []*KMSKey{kmsKey}})
+
+		// Assert
+		assert.Error(t, err)
+		_, err = backend.Get(RecipientFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,27 @@
+package lib
+
+import "strings"
+
+// maxShortPathLength is the length from which Windows refuses a path
+// without the \\?\ prefix: MAX_PATH, less room for an 8.3 file name.
+const maxShortPathLength = 248
+
+// extendedLengthPath returns a clean, absolute Windows path with the \\?\
+// prefix that lifts the MAX_PATH limit, if it is long enough to need it.
+// The os package adds the prefix itself, but calls made straight to the
+// Windows API need it too.
+func extendedLengthPath(path string) string {
+	if len(path) < maxShortPathLength || strings.HasPrefix(path, `\\?\`) || strings.HasPrefix(path, `\??\`) {
+		return path
+	}
+	path = strings.ReplaceAll(path, "/", `\`)
+	switch {
+	case strings.HasPrefix(path, `\\.\`):
+		return path // A device path, which is not limited.
+	case strings.HasPrefix(path, `\\`):
+		return `\\?\UNC\` + path[2:]
+	case len(path) >= 3 && path[1] == ':' && path[2] == '\\':
+		return `\\?\` + path
+	}
+	return path // Relative paths cannot take the prefix.
+}
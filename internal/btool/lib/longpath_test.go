@@ -0,0 +1,31 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtendedLengthPath(t *testing.T) {
+	long := strings.Repeat(`node_modules\`, 20) + "index.js"
+
+	t.Run("should leave short paths alone", func(t *testing.T) {
+		assert.Equal(t, `C:\Users\me\file.txt`, extendedLengthPath(`C:\Users\me\file.txt`))
+	})
+
+	t.Run("should prefix long drive paths", func(t *testing.T) {
+		assert.Equal(t, `\\?\C:\`+long, extendedLengthPath(`C:\`+long))
+		assert.Equal(t, `\\?\C:\`+long, extendedLengthPath(`C:/`+strings.ReplaceAll(long, `\`, "/")))
+	})
+
+	t.Run("should prefix long UNC paths", func(t *testing.T) {
+		assert.Equal(t, `\\?\UNC\server\share\`+long, extendedLengthPath(`\\server\share\`+long))
+	})
+
+	t.Run("should leave prefixed, device, and relative paths alone", func(t *testing.T) {
+		for _, path := range []string{`\\?\C:\` + long, `\\.\pipe\` + long, long} {
+			assert.Equal(t, path, extendedLengthPath(path))
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// keptXattrs are the extended attributes that snaps record: the Finder info,
+// which holds the Finder flags, such as whether a file is hidden or has a
+// custom icon, and the quarantine that Gatekeeper checks.
+var keptXattrs = []string{"com.apple.FinderInfo", "com.apple.quarantine"}
+
+// FileBirthTime returns the creation time of a file, in nanoseconds since
+// the epoch.
+func FileBirthTime(info fs.FileInfo) int64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Birthtimespec.Nano()
+	}
+	return 0
+}
+
+// SetFileBirthTime sets the creation time of a file, in nanoseconds since the
+// epoch.
+func SetFileBirthTime(path string, birthTime int64) error {
+	attrs := unix.Attrlist{Bitmapcount: unix.ATTR_BIT_MAP_COUNT, Commonattr: unix.ATTR_CMN_CRTIME}
+	ts := unix.NsecToTimespec(birthTime)
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&ts)), unsafe.Sizeof(ts))
+	return unix.Setattrlist(path, &attrs, buf, unix.FSOPT_NOFOLLOW)
+}
+
+// FileXattrs returns the Finder info and quarantine of a file or directory,
+// or nil if it has neither.
+func FileXattrs(path string) (map[string][]byte, error) {
+	var xattrs map[string][]byte
+	for _, name := range keptXattrs {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if errors.Is(err, unix.ENOATTR) || errors.Is(err, unix.ENOTSUP) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s of %s: %w", name, path, err)
+		}
+		value := make([]byte, size)
+		if size, err = unix.Lgetxattr(path, name, value); err != nil {
+			return nil, fmt.Errorf("could not read %s of %s: %w", name, path, err)
+		}
+		value = value[:size]
+		if isZero(value) {
+			continue // Finder info with no flags set.
+		}
+		if xattrs == nil {
+			xattrs = make(map[string][]byte)
+		}
+		xattrs[name] = value
+	}
+	return xattrs, nil
+}
+
+// SetFileXattrs gives a file or directory the extended attributes of
+// xattrs.
+func SetFileXattrs(path string, xattrs map[string][]byte) error {
+	for name, value := range xattrs {
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			return fmt.Errorf("could not set %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// isZero reports whether every byte of b is zero.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
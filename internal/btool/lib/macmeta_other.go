@@ -0,0 +1,27 @@
+//go:build !darwin
+
+package lib
+
+import "io/fs"
+
+// FileBirthTime returns zero, as creation times are only kept on macOS.
+func FileBirthTime(fs.FileInfo) int64 {
+	return 0
+}
+
+// SetFileBirthTime does nothing, as creation times are only set on macOS.
+func SetFileBirthTime(string, int64) error {
+	return nil
+}
+
+// FileXattrs returns nil, as the Finder info and quarantine are only kept
+// on macOS.
+func FileXattrs(string) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// SetFileXattrs does nothing, as the Finder info and quarantine only mean
+// something on macOS.
+func SetFileXattrs(string, map[string][]byte) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+)
+
+// MinMemoryLimit is the smallest memory limit a store accepts. Half of the
+// limit is left for the objects being written while the other half fills
+// up with pending objects, and each half must hold several chunks.
+const MinMemoryLimit = 16 * 1024 * 1024 // 16MB
+
+// ValidateMemoryLimit checks that limit, in bytes, is a memory limit the store
+// accepts. Zero stands for no limit.
+func ValidateMemoryLimit(limit int64) error {
+	if limit != 0 && limit < MinMemoryLimit {
+		return fmt.Errorf("memory limit must be at least %d MiB", MinMemoryLimit>>20)
+	}
+	return nil
+}
+
+// memoryBudget bounds the bytes of the objects a store holds: those being
+// written and those pending. Writers wait for room before they hand an
+// object over, which holds them back from reading more of their files while
+// the store catches up. It is safe for concurrent use.
+type memoryBudget struct {
+	mutex sync.Mutex
+	room  sync.Cond
+	limit int64
+	used  int64
+}
+
+// newMemoryBudget returns a budget of limit bytes, or nil if the limit is
+// zero, meaning unlimited.
+func newMemoryBudget(limit int64) *memoryBudget {
+	if limit == 0 {
+		return nil
+	}
+	budget := &memoryBudget{limit: limit}
+	budget.room.L = &budget.mutex
+	return budget
+}
+
+// acquire waits until n bytes fit within the budget and takes them, returning
+// the amount taken for release. An object larger than half the budget, such
+// as the manifest of a huge file, is charged as half of it, so that it can
+// always be taken once the pending objects are written. A nil budget never
+// blocks.
+func (b *memoryBudget) acquire(n int64) int64 {
+	if b == nil {
+		return 0
+	}
+	charge := min(n, b.limit/2)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for b.used+charge > b.limit {
+		b.room.Wait()
+	}
+	b.used += charge
+	return charge
+}
+
+// release returns bytes taken by acquire to the budget.
+func (b *memoryBudget) release(charge int64) {
+	if b == nil || charge == 0 {
+		return
+	}
+	b.mutex.Lock()
+	b.used -= charge
+	b.mutex.Unlock()
+	b.room.Broadcast()
+}
+
+// pendingLimit returns the bytes of pending objects beyond which a store
+// writes a pack, so that writers waiting for room are not kept waiting for
+// good. Without a budget only the pack size applies.
+func (b *memoryBudget) pendingLimit(packSize int64) int64 {
+	if b == nil {
+		return packSize
+	}
+	return min(packSize, b.limit/2)
+}
@@ -0,0 +1,61 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	t.Run("should make acquirers wait until there is room", func(t *testing.T) {
+		// Arrange
+		budget := newMemoryBudget(100)
+		first := budget.acquire(40)
+		second := budget.acquire(40)
+		acquired := make(chan int64)
+
+		// Act
+		go func() { acquired <- budget.acquire(30) }()
+		var early bool
+		select {
+		case <-acquired:
+			early = true
+		case <-time.After(50 * time.Millisecond):
+		}
+		budget.release(first)
+		third := <-acquired
+
+		// Assert
+		assert.False(t, early, "An acquirer beyond the limit should wait")
+		assert.Equal(t, int64(30), third)
+		budget.release(second)
+		budget.release(third)
+		assert.Zero(t, budget.used)
+	})
+
+	t.Run("should charge an object larger than half the budget as half", func(t *testing.T) {
+		// Arrange
+		budget := newMemoryBudget(100)
+
+		// Act
+		charge := budget.acquire(1000)
+
+		// Assert
+		assert.Equal(t, int64(50), charge)
+		assert.Equal(t, int64(50), budget.pendingLimit(DefaultPackSize))
+	})
+
+	t.Run("should never block without a limit", func(t *testing.T) {
+		// Arrange
+		budget := newMemoryBudget(0)
+
+		// Act
+		charge := budget.acquire(1 << 40)
+
+		// Assert
+		assert.Nil(t, budget)
+		assert.Zero(t, charge)
+		assert.Equal(t, int64(DefaultPackSize), budget.pendingLimit(DefaultPackSize))
+	})
+}
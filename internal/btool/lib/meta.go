@@ -1,9 +1,12 @@
 package lib
 
 import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,21 +14,12 @@ import (
 
 var metaMutex = &sync.Mutex{}
 
-func getMetaDir(baseDir string) string {
-	return filepath.Join(GetBtoolDir(baseDir), "meta")
-}
-
-func getCounterPath(baseDir string) string {
-	return filepath.Join(getMetaDir(baseDir), "counter")
-}
-
 // getNextSnapID is the internal, non-locking implementation of GetNextSnapID.
 // It should only be called by functions that already hold the metaMutex.
-func getNextSnapID(baseDir string) (int64, error) {
-	counterPath := getCounterPath(baseDir)
-	content, err := os.ReadFile(counterPath)
+func (s *ObjectStore) getNextSnapID() (int64, error) {
+	content, err := s.backend.Get(CounterFileName)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, fs.ErrNotExist) {
 			// If the counter doesn't exist, the first ID is 1.
 			return 1, nil
 		}
@@ -47,31 +41,265 @@ func getNextSnapID(baseDir string) (int64, error) {
 }
 
 // GetNextSnapID is the public, thread-safe function to read the next snapshot ID.
-func GetNextSnapID(baseDir string) (int64, error) {
+func (s *ObjectStore) GetNextSnapID() (int64, error) {
 	metaMutex.Lock()
 	defer metaMutex.Unlock()
-	return getNextSnapID(baseDir)
+	return s.getNextSnapID()
 }
 
 // IncrementNextSnapID increments the persistent counter for the next snapshot ID.
 // This function is thread-safe.
-func IncrementNextSnapID(baseDir string) error {
+func (s *ObjectStore) IncrementNextSnapID() error {
 	metaMutex.Lock()
 	defer metaMutex.Unlock()
 
-	// Ensure the directory exists before we try to write to it.
-	metaDir := getMetaDir(baseDir)
-	if err := os.MkdirAll(metaDir, 0755); err != nil {
+	// Call the internal, non-locking function since we already have the lock.
+	currentID, err := s.getNextSnapID()
+	if err != nil {
 		return err
 	}
 
-	// Call the internal, non-locking function since we already have the lock.
-	currentID, err := getNextSnapID(baseDir)
+	nextID := currentID + 1
+	return s.backend.Put(CounterFileName, []byte(strconv.FormatInt(nextID, 10)))
+}
+
+// hashKeySize is the size of the HMAC key of a keyed repository.
+const hashKeySize = 32
+
+// RepositoryConfig holds the settings that are fixed when a repository is
+// created. A repository without a config file uses the zero value's defaults.
+type RepositoryConfig struct {
+	// HashAlgorithm is HashAlgorithm, KeyedHashAlgorithm,
+	// Blake3HashAlgorithm, or KeyedBlake3HashAlgorithm. Empty means
+	// HashAlgorithm.
+	HashAlgorithm string `json:"hashAlgorithm,omitempty"`
+	// HashKey is the key of KeyedHashAlgorithm and KeyedBlake3HashAlgorithm.
+	HashKey []byte `json:"hashKey,omitempty"`
+	// Chunker names the chunker that splits files, ChunkerRabin or
+	// ChunkerFastCDC. Empty means ChunkerRabin.
+	Chunker string `json:"chunker,omitempty"`
+}
+
+// NewKeyedConfig returns a config that derives object IDs with HMAC-SHA256
+// under a new random key.
+func NewKeyedConfig() (RepositoryConfig, error) {
+	return NewHashConfig(HashAlgorithm, true)
+}
+
+// NewHashConfig returns a config that derives object IDs with base,
+// HashAlgorithm or Blake3HashAlgorithm, under a new random key if keyed is
+// set.
+func NewHashConfig(base string, keyed bool) (RepositoryConfig, error) {
+	if _, err := NewHasherFor(base, nil); err != nil {
+		return RepositoryConfig{}, err
+	}
+	if !keyed {
+		return RepositoryConfig{HashAlgorithm: base}, nil
+	}
+	key := make([]byte, hashKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return RepositoryConfig{}, err
+	}
+	config := RepositoryConfig{HashAlgorithm: KeyedHashAlgorithm, HashKey: key}
+	if base == Blake3HashAlgorithm {
+		config.HashAlgorithm = KeyedBlake3HashAlgorithm
+	}
+	return config, nil
+}
+
+// Hasher returns the Hasher that derives IDs as the config specifies.
+func (c RepositoryConfig) Hasher() (*Hasher, error) {
+	switch c.HashAlgorithm {
+	case "", HashAlgorithm:
+		return NewHasher(nil), nil
+	case Blake3HashAlgorithm:
+		return NewHasherFor(Blake3HashAlgorithm, nil)
+	case KeyedHashAlgorithm, KeyedBlake3HashAlgorithm:
+		if len(c.HashKey) != hashKeySize {
+			return nil, fmt.Errorf("corrupt repository config: %s needs a %d-byte key", c.HashAlgorithm, hashKeySize)
+		}
+		if c.HashAlgorithm == KeyedBlake3HashAlgorithm {
+			return NewHasherFor(Blake3HashAlgorithm, c.HashKey)
+		}
+		return NewHasher(c.HashKey), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q", c.HashAlgorithm)
+	}
+}
+
+// secret reports whether the config holds a secret, and so must be stored
+// encrypted. Other configs are stored in plain, so that backups without the
+// master key can read them.
+func (c RepositoryConfig) secret() bool {
+	return len(c.HashKey) > 0
+}
+
+// isPlainConfig reports whether the stored config content is plain JSON
+// rather than an encrypted envelope, which never starts with a brace.
+func isPlainConfig(content []byte) bool {
+	return len(content) > 0 && content[0] == '{'
+}
+
+// apply makes the store derive IDs and cut chunks as config specifies.
+func (s *ObjectStore) apply(config RepositoryConfig) error {
+	hasher, err := config.Hasher()
+	if err != nil {
+		return err
+	}
+	chunker, err := NewChunker(config.Chunker)
+	if err != nil {
+		return err
+	}
+	s.config, s.hasher, s.chunker = config, hasher, chunker
+	return nil
+}
+
+// LoadConfig reads the repository config and makes the store derive IDs and
+// cut chunks as it specifies. A missing config selects plain SHA-256 and
+// Rabin fingerprinting.
+func (s *ObjectStore) LoadConfig() error {
+	content, err := s.backend.Get(ConfigFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	if !isPlainConfig(content) {
+		if content, err = s.decrypt(content); err != nil {
+			return fmt.Errorf("could not decrypt repository config: %w", err)
+		}
+	}
+	var config RepositoryConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return fmt.Errorf("corrupt repository config: %w", err)
+	}
+	return s.apply(config)
+}
 
-	nextID := currentID + 1
-	counterPath := getCounterPath(baseDir)
-	return os.WriteFile(counterPath, []byte(strconv.FormatInt(nextID, 10)), 0644)
+// WriteConfig stores config, encrypted if the repository is and the config
+// holds a secret, and makes the store derive IDs and cut chunks as it
+// specifies. It must only be called before any objects are written, since it
+// changes their IDs and chunks.
+func (s *ObjectStore) WriteConfig(config RepositoryConfig) error {
+	if _, err := config.Hasher(); err != nil {
+		return err
+	}
+	if _, err := NewChunker(config.Chunker); err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	if config.secret() {
+		if content, err = s.encrypt(content); err != nil {
+			return err
+		}
+	}
+	if err := s.backend.Put(ConfigFileName, content); err != nil {
+		return err
+	}
+	return s.apply(config)
+}
+
+// RepositoryDefaults holds the settings that commands use for a repository
+// unless told otherwise. Unlike RepositoryConfig, they can change at any time
+// and are stored unencrypted.
+type RepositoryDefaults struct {
+	Compression      string `json:"compression,omitempty"`
+	CompressionLevel int    `json:"compressionLevel,omitempty"`
+	// PackSize is the size of the packs a snap writes, in bytes, where zero
+	// selects DefaultPackSize.
+	PackSize int64 `json:"packSize,omitempty"`
+	// MaxSize is the most the packs of the repository may take up, in
+	// bytes, before a snap removes the oldest snaps to make room. Zero means
+	// no limit.
+	MaxSize int64 `json:"maxSize,omitempty"`
+}
+
+// ParseSize parses a size in bytes, such as "500M", "10GiB", or "1T", with
+// binary units of K, M, G, and T, each optionally followed by "B" or "iB". A
+// bare number is a number of bytes.
+func ParseSize(s string) (int64, error) {
+	number := strings.TrimSpace(s)
+	unit := int64(1)
+	upper := strings.ToUpper(number)
+	for _, suffix := range []string{"IB", "B"} {
+		if trimmed, ok := strings.CutSuffix(upper, suffix); ok {
+			upper = trimmed
+			break
+		}
+	}
+	for i, prefix := range []string{"K", "M", "G", "T"} {
+		if trimmed, ok := strings.CutSuffix(upper, prefix); ok {
+			upper = trimmed
+			unit = int64(1) << (10 * (i + 1))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) || n*float64(unit) >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: expected a size such as 500M or 10G", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// CompressionOptions returns the default compression, which is
+// DefaultCompression unless the repository chose another.
+func (d RepositoryDefaults) CompressionOptions() Compression {
+	if d.Compression == "" {
+		return DefaultCompression()
+	}
+	return Compression{Algorithm: d.Compression, Level: d.CompressionLevel}
+}
+
+// LoadDefaults reads the repository defaults. A missing file selects the
+// zero value.
+func (s *ObjectStore) LoadDefaults() (RepositoryDefaults, error) {
+	var defaults RepositoryDefaults
+	content, err := s.backend.Get(DefaultsFileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaults, nil
+	}
+	if err != nil {
+		return defaults, err
+	}
+	if err := json.Unmarshal(content, &defaults); err != nil {
+		return defaults, fmt.Errorf("corrupt repository defaults: %w", err)
+	}
+	return defaults, nil
+}
+
+// WriteDefaults stores the repository defaults.
+func (s *ObjectStore) WriteDefaults(defaults RepositoryDefaults) error {
+	if err := defaults.CompressionOptions().Validate(); err != nil {
+		return err
+	}
+	if err := ValidatePackSize(defaults.PackSize); err != nil {
+		return err
+	}
+	if defaults.MaxSize < 0 {
+		return fmt.Errorf("max size must not be negative")
+	}
+	content, err := json.MarshalIndent(defaults, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.backend.Put(DefaultsFileName, content)
+}
+
+// Hasher returns the Hasher that derives the store's object IDs.
+func (s *ObjectStore) Hasher() *Hasher {
+	return s.hasher
+}
+
+// Config returns the repository config that the store follows.
+func (s *ObjectStore) Config() RepositoryConfig {
+	return s.config
+}
+
+// Chunker returns the Chunker that splits files for the repository.
+func (s *ObjectStore) Chunker() Chunker {
+	return s.chunker
 }
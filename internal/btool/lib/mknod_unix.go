@@ -0,0 +1,11 @@
+//go:build linux || darwin || netbsd || openbsd || dragonfly
+
+package lib
+
+import "golang.org/x/sys/unix"
+
+// mknod creates a special file, taking the device number as this system's
+// mknod does.
+func mknod(path string, mode uint32, rdev uint64) error {
+	return unix.Mknod(path, mode, int(rdev))
+}
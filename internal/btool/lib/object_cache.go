@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"container/list"
+	"sync"
+)
+
+// defaultObjectCacheSize is the number of bytes of decoded objects an
+// ObjectStore keeps in memory. A restore reads a chunk shared by many files,
+// such as a common header or a run of zeros, once per file; with the cache
+// it is read and decoded from its pack only once.
+const defaultObjectCacheSize = 64 * 1024 * 1024 // 64MB
+
+// objectCache keeps the most recently read objects, by hash, up to a total
+// size, dropping the least recently used ones to make room. Objects are
+// content-addressed, so a cached object never goes stale. It is safe for
+// concurrent use.
+type objectCache struct {
+	mutex   sync.Mutex
+	maxSize int64
+	size    int64
+	objects map[string]*list.Element // Of *cachedObject, by hash.
+	lru     list.List                // Most recently used first.
+}
+
+// cachedObject is an object held by an objectCache.
+type cachedObject struct {
+	hash string
+	data []byte
+}
+
+// newObjectCache returns a cache of up to maxSize bytes. A cache of zero
+// bytes holds nothing.
+func newObjectCache(maxSize int64) *objectCache {
+	return &objectCache{maxSize: maxSize, objects: make(map[string]*list.Element)}
+}
+
+// get returns the cached data of the object with the given hash.
+func (c *objectCache) get(hash string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, ok := c.objects[hash]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return element.Value.(*cachedObject).data, true
+}
+
+// add caches the data of the object with the given hash. Objects larger than
+// a quarter of the cache are not cached, so that one cannot flush the rest.
+func (c *objectCache) add(hash string, data []byte) {
+	if int64(len(data)) > c.maxSize/4 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, ok := c.objects[hash]; ok {
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.objects[hash] = c.lru.PushFront(&cachedObject{hash: hash, data: data})
+	c.size += int64(len(data))
+	for c.size > c.maxSize {
+		oldest := c.lru.Remove(c.lru.Back()).(*cachedObject)
+		delete(c.objects, oldest.hash)
+		c.size -= int64(len(oldest.data))
+	}
+}
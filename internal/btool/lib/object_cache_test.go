@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObjectCache(t *testing.T) {
+	t.Run("should drop the least recently used objects to stay within its size", func(t *testing.T) {
+		// Arrange
+		cache := newObjectCache(40)
+		cache.add("a", make([]byte, 10))
+		cache.add("b", make([]byte, 10))
+		cache.add("c", make([]byte, 10))
+		cache.get("a")
+
+		// Act
+		cache.add("d", make([]byte, 10))
+		cache.add("e", make([]byte, 10))
+
+		// Assert
+		for hash, cached := range map[string]bool{"a": true, "b": false, "c": true, "d": true, "e": true} {
+			_, ok := cache.get(hash)
+			assert.Equal(t, cached, ok, "Object %s", hash)
+		}
+		assert.Equal(t, int64(40), cache.size)
+	})
+
+	t.Run("should not cache objects larger than a quarter of its size", func(t *testing.T) {
+		// Arrange
+		cache := newObjectCache(40)
+
+		// Act
+		cache.add("large", make([]byte, 11))
+
+		// Assert
+		_, ok := cache.get("large")
+		assert.False(t, ok)
+		assert.Zero(t, cache.size)
+	})
+}
@@ -2,11 +2,12 @@
 package lib
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"os"
-	"path/filepath"
-	"sort"
+	"fmt"
+	"io"
+	"io/fs"
 	"sync"
 
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
@@ -16,165 +17,419 @@ import (
 // including packfiles and the central index. It is designed to be instantiated
 // once per command execution to ensure state isolation.
 type ObjectStore struct {
-	baseDir        string
+	backend        Backend
+	key            *RepositoryKey // Nil for unencrypted repositories.
+	config         RepositoryConfig
+	hasher         *Hasher
+	chunker        Chunker
+	compression    Compression
 	mutex          sync.Mutex
 	packIndex      types.PackIndex
+	indexFilter    *bloomFilter // Hashes in packIndex, once it is loaded.
+	objectCache    *objectCache // Recently read objects.
 	pendingObjects map[string][]byte
+	pendingKinds   map[string]objectKind // Pending objects that are not plain data.
+	pendingOrder   []string              // Hashes of pendingObjects, in the order written.
+	pendingSize    int64                 // Bytes held in pendingObjects.
+	pendingBuffers []*[]byte             // Pooled buffers holding pendingObjects.
+	pendingCharge  int64                 // Bytes of memory taken for pendingObjects.
+	memory         *memoryBudget         // Nil unless memory use is limited.
+	packSize       int64                 // Pending bytes that make writeObject write a pack.
+	flushedSize    int64                 // Bytes of packs written since the last Commit.
+	writtenPacks   []string              // Hashes of the packs this store wrote, oldest first.
 	indexLoaded    bool
+	shardPacks     map[string]bool // Packs whose index shard exists.
+	legacyIndex    []string        // Files of the old index layout merged into packIndex.
+	deletedPacks   map[string]bool // Packs deleted since the index was loaded.
+	trash          *Trash          // Where deleted files are kept, if anywhere.
 }
 
-// NewObjectStore creates and initializes a new ObjectStore for a given repository.
-func NewObjectStore(baseDir string) *ObjectStore {
+// NewObjectStore creates and initializes a new ObjectStore on top of the
+// given storage backend.
+func NewObjectStore(backend Backend) *ObjectStore {
 	return &ObjectStore{
-		baseDir:        baseDir,
+		backend:        backend,
+		hasher:         NewHasher(nil),
+		chunker:        rabinChunker{},
+		compression:    DefaultCompression(),
 		pendingObjects: make(map[string][]byte),
+		pendingKinds:   make(map[string]objectKind),
+		packSize:       DefaultPackSize,
 		packIndex:      make(types.PackIndex),
+		shardPacks:     make(map[string]bool),
+		deletedPacks:   make(map[string]bool),
+		objectCache:    newObjectCache(defaultObjectCacheSize),
 	}
 }
 
-// loadIndex reads the index.json file into the in-memory cache.
-// It is NOT thread-safe by itself and should be called from within a locked section.
-func (s *ObjectStore) loadIndex() error {
-	if s.indexLoaded {
-		return nil
-	}
+// NewEncryptedObjectStore creates an ObjectStore that encrypts everything it
+// writes to backend with key, and decrypts everything it reads.
+func NewEncryptedObjectStore(backend Backend, key *RepositoryKey) *ObjectStore {
+	store := NewObjectStore(backend)
+	store.key = key
+	return store
+}
 
-	indexPath := GetIndexPath(s.baseDir)
-	if _, err := os.Stat(indexPath); os.IsNotExist(err) {
-		// Index doesn't exist yet, which is fine. The in-memory index is already empty.
-		s.indexLoaded = true
-		return nil
-	}
+// NewLocalObjectStore creates an ObjectStore for the default repository
+// location, the .btool directory inside baseDir.
+func NewLocalObjectStore(baseDir string) *ObjectStore {
+	return NewObjectStore(NewLocalBackend(GetBtoolDir(baseDir)))
+}
 
-	content, err := os.ReadFile(indexPath)
-	if err != nil {
-		return err
+// encrypt seals data for storage if the repository is encrypted, and returns
+// it unchanged otherwise.
+func (s *ObjectStore) encrypt(data []byte) ([]byte, error) {
+	if s.key == nil {
+		return data, nil
 	}
+	return s.key.Seal(data)
+}
 
-	if err := json.Unmarshal(content, &s.packIndex); err != nil {
-		return err
+// decrypt reverses encrypt.
+func (s *ObjectStore) decrypt(data []byte) ([]byte, error) {
+	if s.key == nil {
+		return data, nil
 	}
+	return s.key.Open(data)
+}
+
+// writeOnly reports whether the store can write to an encrypted repository
+// but not read what others wrote, including the index.
+func (s *ObjectStore) writeOnly() bool {
+	return s.key != nil && !s.key.CanRead()
+}
+
+// Pending objects are written to a pack of their own, before Commit, once they
+// reach the store's pack size, which thereby bounds both the memory they hold
+// and the size of packs. Larger packs mean fewer files, which some remote
+// storage charges for; smaller ones mean less memory during a snap.
+const (
+	DefaultPackSize = 64 * 1024 * 1024       // 64MB
+	MinPackSize     = 1024 * 1024            // 1MB
+	MaxPackSize     = 4 * 1024 * 1024 * 1024 // 4GB
+)
 
-	s.indexLoaded = true
+// ValidatePackSize checks that size, in bytes, is a pack size the store
+// accepts. Zero stands for DefaultPackSize.
+func ValidatePackSize(size int64) error {
+	if size != 0 && (size < MinPackSize || size > MaxPackSize) {
+		return fmt.Errorf("pack size must be from %d MiB to %d MiB", MinPackSize>>20, MaxPackSize>>20)
+	}
 	return nil
 }
 
+// objectKind tells Commit how to compress an object.
+type objectKind int
+
+const (
+	dataObject           objectKind = iota
+	incompressibleObject            // Stored without compression.
+	metadataObject                  // Compressed with metadataDictionary.
+)
+
 // WriteObject adds an object to the in-memory pending buffer.
-// The object is not persisted to disk until Commit() is called.
+// The object is not persisted to disk until Commit() is called. The store
+// keeps a copy of data, so the caller may reuse it.
 func (s *ObjectStore) WriteObject(data []byte) (string, error) {
-	hash := GetHash(data)
+	return s.writeObject(data, dataObject)
+}
+
+// WriteIncompressibleObject is like WriteObject for an object known to be
+// compressed already, such as a chunk of a JPEG file, which Commit then stores
+// without trying to compress it.
+func (s *ObjectStore) WriteIncompressibleObject(data []byte) (string, error) {
+	return s.writeObject(data, incompressibleObject)
+}
+
+// WriteMetadataObject is like WriteObject for a file manifest or tree, which
+// Commit compresses with a dictionary trained on such objects.
+func (s *ObjectStore) WriteMetadataObject(data []byte) (string, error) {
+	return s.writeObject(data, metadataObject)
+}
+
+// writeObject adds an object of the given kind to the pending buffer.
+func (s *ObjectStore) writeObject(data []byte, kind objectKind) (string, error) {
+	hash := s.hasher.GetHash(data)
+
+	// Waiting for memory outside the lock lets the pending objects be
+	// written meanwhile.
+	charge := s.memory.acquire(int64(len(data)))
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	if err := s.loadIndex(); err != nil {
+		s.memory.release(charge)
 		return "", err
 	}
 
-	// De-duplication check:
-	if _, exists := s.packIndex[hash]; exists {
-		return hash, nil
+	// De-duplication check. Most new objects are ruled out by the filter
+	// without looking them up in the index.
+	if s.indexFilter.mayContain(hash) {
+		if _, exists := s.indexEntry(hash); exists {
+			s.memory.release(charge)
+			return hash, nil
+		}
 	}
 	if _, exists := s.pendingObjects[hash]; exists {
+		s.memory.release(charge)
 		return hash, nil
 	}
 
+	// The caller may reuse data, so the store keeps a copy, in a pooled
+	// buffer if it fits one.
+	if len(data) <= objectBuffers.size {
+		buffer := objectBuffers.get()
+		data = (*buffer)[:copy(*buffer, data)]
+		s.pendingBuffers = append(s.pendingBuffers, buffer)
+	} else {
+		data = bytes.Clone(data)
+	}
 	s.pendingObjects[hash] = data
+	s.pendingOrder = append(s.pendingOrder, hash)
+	if kind != dataObject {
+		s.pendingKinds[hash] = kind
+	}
+	s.pendingSize += int64(len(data))
+	s.pendingCharge += charge
+	if s.pendingSize >= s.memory.pendingLimit(s.packSize) {
+		size, err := s.writePack()
+		if err != nil {
+			return "", err
+		}
+		s.flushedSize += size
+	}
 	return hash, nil
 }
 
-// Commit writes all pending objects to a new single packfile on disk
-// and updates the index.json file to make them persistent.
+// Commit writes all pending objects to a new single packfile and adds its
+// index shard to make them persistent. It returns the
+// size of the packs written since the last Commit, including any that pending
+// objects filled up along the way.
 func (s *ObjectStore) Commit() (int64, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
+	size, err := s.writePack()
+	if err != nil {
+		return 0, err
+	}
+	size += s.flushedSize
+	s.flushedSize = 0
+	return size, nil
+}
+
+// WrittenPacks returns the hashes of the packs this store has written, oldest
+// first, so that they can be dropped again without touching the packs that
+// others wrote meanwhile.
+func (s *ObjectStore) WrittenPacks() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.writtenPacks...)
+}
+
+// writePack writes all pending objects to a new packfile and adds them to the
+// index, returning the size of the pack.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) writePack() (int64, error) {
 	if len(s.pendingObjects) == 0 {
 		return 0, nil // Nothing to commit.
 	}
 
-	var hashes []string
-	for hash := range s.pendingObjects {
-		hashes = append(hashes, hash)
+	builder, err := s.newPackBuilder()
+	if err != nil {
+		return 0, err
 	}
-	sort.Strings(hashes)
-
-	var packBuffer []byte
-	var currentOffset int64 = 0
-	newEntries := make(map[string]types.PackIndexEntry)
-
-	for _, hash := range hashes {
-		data := s.pendingObjects[hash]
-		packBuffer = append(packBuffer, data...)
-		newEntries[hash] = types.PackIndexEntry{
-			Offset: currentOffset,
-			Length: int64(len(data)),
+	defer builder.abort() // Does nothing once finished.
+
+	// Objects are packed in the order they were written, so that the chunks
+	// of a file lie next to each other and are restored with few reads.
+	for _, hash := range s.pendingOrder {
+		// Each object is compressed and sealed separately, so it can still be
+		// read on its own. Compression must come first, since encrypted data
+		// does not compress.
+		// The index records the outcome: entries without a compression
+		// algorithm were stored as they are.
+		object := s.pendingObjects[hash]
+		compression := s.compression
+		kind := s.pendingKinds[hash]
+		if kind == incompressibleObject {
+			compression = Compression{Algorithm: CompressionOff}
+		}
+		data, algorithm, err := compressObject(object, compression, kind == metadataObject)
+		if err != nil {
+			return 0, err
+		}
+		if data, err = s.encrypt(data); err != nil {
+			return 0, err
+		}
+		entry := types.PackIndexEntry{Compression: algorithm}
+		if algorithm != "" {
+			entry.UncompressedLength = int64(len(object))
+		}
+		if err := builder.add(hash, recordTypes[kind], data, entry); err != nil {
+			return 0, err
 		}
-		currentOffset += int64(len(data))
 	}
 
-	packHash := GetHash(packBuffer)
-	packsDir := GetPacksDir(s.baseDir)
-	packPath := filepath.Join(packsDir, packHash)
-	if err := os.WriteFile(packPath, packBuffer, 0644); err != nil {
+	packHash, newEntries, err := builder.finish()
+	if err != nil {
+		return 0, err
+	}
+	// The pack's shard is all the index needs to know about it.
+	if err := s.writeShard(packHash, newEntries); err != nil {
 		return 0, err
 	}
+	s.writtenPacks = append(s.writtenPacks, packHash)
 
 	if err := s.loadIndex(); err != nil {
 		return 0, err
 	}
-
 	for hash, entry := range newEntries {
-		entry.PackHash = packHash
-		s.packIndex[hash] = entry
+		s.addIndexEntry(hash, entry)
 	}
-
-	indexPath := GetIndexPath(s.baseDir)
-	indexJSON, err := json.MarshalIndent(s.packIndex, "", "  ")
-	if err != nil {
-		return 0, err
-	}
-	if err := os.WriteFile(indexPath, indexJSON, 0644); err != nil {
+	if err := s.migrateIndex(); err != nil {
 		return 0, err
 	}
 
 	s.pendingObjects = make(map[string][]byte)
+	s.pendingKinds = make(map[string]objectKind)
+	s.pendingOrder = nil
+	s.pendingSize = 0
+	for _, buffer := range s.pendingBuffers {
+		objectBuffers.put(buffer)
+	}
+	s.pendingBuffers = nil
+	s.memory.release(s.pendingCharge)
+	s.pendingCharge = 0
+
+	return builder.offset, nil
+}
 
-	return int64(len(packBuffer)), nil
+// Reads of objects that lie close together in the same pack are merged into
+// a single range read, which costs far less than a read per object, above
+// all on remote backends. A merged read spans at most maxReadaheadSize bytes
+// and skips gaps of at most maxReadaheadGap bytes between objects.
+const (
+	maxReadaheadSize = 4 * 1024 * 1024 // 4MB
+	maxReadaheadGap  = 64 * 1024       // 64KB
+)
+
+// ErrCorrupted tells that an object read from a pack could not be decoded or
+// did not hash to its ID.
+var ErrCorrupted = errors.New("corrupted")
+
+// objectRead is an object to be read by ReadObjects.
+type objectRead struct {
+	hash  string
+	ready bool // Pending or cached, so data holds the object.
+	data  []byte
+	entry types.PackIndexEntry
 }
 
 // ReadObjectAsBuffer retrieves an object from the store by its hash.
 func (s *ObjectStore) ReadObjectAsBuffer(hash string) ([]byte, error) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	var data []byte
+	err := s.ReadObjects([]string{hash}, func(object []byte) error {
+		data = object
+		return nil
+	})
+	return data, err
+}
 
-	if data, exists := s.pendingObjects[hash]; exists {
-		return data, nil
+// ReadObjects retrieves the objects with the given hashes and calls emit with
+// each one's data, in order, stopping at the first error. Objects stored next
+// to each other in a pack, such as the chunks of a file written by one snap,
+// are read together, so reading all the chunks of a file at once is much
+// faster than reading them one at a time. Recently read objects are served
+// from memory. The data passed to emit is shared and must not be modified.
+func (s *ObjectStore) ReadObjects(hashes []string, emit func(data []byte) error) error {
+	// Look every object up first, so that the lock is not held while reading.
+	reads := make([]objectRead, len(hashes))
+	s.mutex.Lock()
+	for i, hash := range hashes {
+		if data, exists := s.pendingObjects[hash]; exists {
+			// Its buffer is reused once the object is packed.
+			reads[i] = objectRead{hash: hash, ready: true, data: bytes.Clone(data)}
+			continue
+		}
+		if data, cached := s.objectCache.get(hash); cached {
+			reads[i] = objectRead{hash: hash, ready: true, data: data}
+			continue
+		}
+		if err := s.loadIndex(); err != nil {
+			s.mutex.Unlock()
+			return err
+		}
+		entry, exists := s.indexEntry(hash)
+		if !exists {
+			s.mutex.Unlock()
+			return errors.New("object with hash " + hash + " not found in index")
+		}
+		reads[i] = objectRead{hash: hash, entry: entry}
 	}
+	s.mutex.Unlock()
+
+	for i := 0; i < len(reads); {
+		if reads[i].ready {
+			if err := emit(reads[i].data); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
 
-	if err := s.loadIndex(); err != nil {
-		return nil, err
-	}
+		// Extend the read over the objects that follow closely in the pack.
+		first := reads[i].entry
+		end := first.Offset + first.Length
+		j := i + 1
+		for ; j < len(reads); j++ {
+			next := reads[j].entry
+			if reads[j].ready || next.PackHash != first.PackHash ||
+				next.Offset < end || next.Offset-end > maxReadaheadGap ||
+				next.Offset+next.Length-first.Offset > maxReadaheadSize {
+				break
+			}
+			end = next.Offset + next.Length
+		}
 
-	entry, exists := s.packIndex[hash]
-	if !exists {
-		return nil, errors.New("object with hash " + hash + " not found in index")
+		span, err := s.backend.GetRange(packName(first.PackHash), first.Offset, end-first.Offset)
+		if err != nil {
+			return err
+		}
+		for _, read := range reads[i:j] {
+			start := read.entry.Offset - first.Offset
+			data, err := s.decodeObject(read.hash, read.entry, span[start:start+read.entry.Length:start+read.entry.Length])
+			if err == nil {
+				// Whatever changed the stored bytes, the data must still
+				// hash to its ID, or a restore would write garbage.
+				if actual := s.hasher.GetHash(data); actual != read.hash {
+					err = fmt.Errorf("content hashes to %s", actual)
+				}
+			}
+			if err != nil {
+				return fmt.Errorf("pack %s %w at object %s: %w", read.entry.PackHash, ErrCorrupted, read.hash, err)
+			}
+			s.objectCache.add(read.hash, data)
+			if err := emit(data); err != nil {
+				return err
+			}
+		}
+		i = j
 	}
+	return nil
+}
 
-	packPath := filepath.Join(GetPacksDir(s.baseDir), entry.PackHash)
-	file, err := os.Open(packPath)
+// decodeObject decrypts and decompresses an object as read from its pack.
+func (s *ObjectStore) decodeObject(hash string, entry types.PackIndexEntry, data []byte) ([]byte, error) {
+	data, err := s.decrypt(data)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not decrypt object %s: %w", hash, err)
 	}
-	defer file.Close()
-
-	buffer := make([]byte, entry.Length)
-	_, err = file.ReadAt(buffer, entry.Offset)
-	if err != nil {
-		return nil, err
+	if data, err = decompressObject(data, entry.Compression, entry.UncompressedLength); err != nil {
+		return nil, fmt.Errorf("object %s: %w", hash, err)
 	}
-
-	return buffer, nil
+	return data, nil
 }
 
 // ReadObjectAsJSON retrieves an object and unmarshals it into a given struct.
@@ -186,6 +441,95 @@ func (s *ObjectStore) ReadObjectAsJSON(hash string, target interface{}) error {
 	return json.Unmarshal(buffer, target)
 }
 
+// ListPacks returns the packfiles currently present in the backend.
+func (s *ObjectStore) ListPacks() ([]BackendEntry, error) {
+	return s.backend.List(PacksDirName)
+}
+
+// DeletePack removes a packfile and its index shard from the backend, so that
+// the objects stored in it are no longer in the index. The shard goes first,
+// so an interrupted call leaves at worst a pack that no shard refers to, which
+// the next prune removes. Callers are responsible for ensuring no live object
+// is stored only in it. Once StartTrash is called, both are kept in the trash.
+func (s *ObjectStore) DeletePack(packHash string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.loadIndex(); err != nil {
+		return err
+	}
+	// An index of the old layout would go on listing the pack's objects.
+	if err := s.migrateIndex(); err != nil {
+		return err
+	}
+	if err := s.deleteFile(shardName(packHash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	delete(s.shardPacks, packHash)
+	s.deletedPacks[packHash] = true
+	return s.deleteFile(packName(packHash))
+}
+
+// Close releases any resources held by the storage backend, such as network
+// connections. It is a no-op for backends that hold none.
+func (s *ObjectStore) Close() error {
+	if closer, ok := s.backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetCompression selects how objects committed from now on are compressed.
+func (s *ObjectStore) SetCompression(compression Compression) error {
+	if err := compression.Validate(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.compression = compression
+	return nil
+}
+
+// SetPackSize selects the size, in bytes, of the packs written from now on,
+// counted before compression. Zero selects DefaultPackSize.
+func (s *ObjectStore) SetPackSize(size int64) error {
+	if err := ValidatePackSize(size); err != nil {
+		return err
+	}
+	if size == 0 {
+		size = DefaultPackSize
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.packSize = size
+	return nil
+}
+
+// SetMemoryLimit bounds the memory, in bytes, held by the objects being
+// written and the pending ones. Writers then wait for pending objects to be
+// written to a pack before handing over more, and packs are written early
+// when half the limit is pending. Zero removes the limit. It must be called
+// before any object is written.
+func (s *ObjectStore) SetMemoryLimit(limit int64) error {
+	if err := ValidateMemoryLimit(limit); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.memory = newMemoryBudget(limit)
+	return nil
+}
+
+// Backend returns the storage backend underneath this store.
+func (s *ObjectStore) Backend() Backend {
+	return s.backend
+}
+
+// Key returns the key of an encrypted repository, or nil if it is not encrypted.
+func (s *ObjectStore) Key() *RepositoryKey {
+	return s.key
+}
+
 // GetIndex returns a copy of the current pack index.
 func (s *ObjectStore) GetIndex() (types.PackIndex, error) {
 	s.mutex.Lock()
@@ -197,7 +541,9 @@ func (s *ObjectStore) GetIndex() (types.PackIndex, error) {
 
 	indexCopy := make(types.PackIndex)
 	for hash, entry := range s.packIndex {
-		indexCopy[hash] = entry
+		if !s.deletedPacks[entry.PackHash] {
+			indexCopy[hash] = entry
+		}
 	}
 
 	return indexCopy, nil
@@ -3,10 +3,15 @@ package lib
 import (
 	"crypto/rand"
 	"encoding/json"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"testing"
 
+	"filippo.io/age"
 	"github.com/gingerrexayers/btool-go/internal/btool/types"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -21,7 +26,7 @@ func setupObjectStoreTest(t *testing.T) (*ObjectStore, string) {
 	_, err := EnsureBtoolDirs(testDir)
 	require.NoError(t, err, "Failed to create .btool directories")
 
-	store := NewObjectStore(testDir)
+	store := NewLocalObjectStore(testDir)
 	return store, testDir
 }
 
@@ -47,15 +52,40 @@ func TestObjectStore(t *testing.T) {
 		// Assert
 		assert.Equal(t, content, readContent, "Read content does not match original content")
 
-		// Assert that the index file was created and is valid
-		indexPath := GetIndexPath(testDir)
-		indexContent, err := os.ReadFile(indexPath)
-		require.NoError(t, err, "Could not read index file")
+		// Assert that the pack's index shard was created and is valid
+		shards, err := os.ReadDir(GetIndexDir(testDir))
+		require.NoError(t, err, "Could not list index shards")
+		require.Len(t, shards, 1, "Expected one index shard for the pack")
+		indexContent, err := os.ReadFile(filepath.Join(GetIndexDir(testDir), shards[0].Name()))
+		require.NoError(t, err, "Could not read index shard")
 
 		var index types.PackIndex
 		err = json.Unmarshal(indexContent, &index)
 		require.NoError(t, err, "Could not parse index JSON")
 		assert.Contains(t, index, hash, "Expected hash to be in the index")
+		assert.Equal(t, shards[0].Name(), index[hash].PackHash, "The shard should be named after its pack")
+	})
+
+	t.Run("should name a streamed pack after the hash of its contents", func(t *testing.T) {
+		// Arrange
+		store, testDir := setupObjectStoreTest(t)
+		for i := 0; i < 10; i++ {
+			_, err := store.WriteObject([]byte("streamed object " + strconv.Itoa(i)))
+			require.NoError(t, err)
+		}
+
+		// Act
+		size, err := store.Commit()
+
+		// Assert
+		require.NoError(t, err)
+		packs, err := os.ReadDir(filepath.Join(GetBtoolDir(testDir), PacksDirName))
+		require.NoError(t, err)
+		require.Len(t, packs, 1, "Expected only the pack, with no temporary file left behind")
+		packContent, err := os.ReadFile(filepath.Join(GetBtoolDir(testDir), PacksDirName, packs[0].Name()))
+		require.NoError(t, err)
+		assert.Equal(t, GetHash(packContent), packs[0].Name())
+		assert.Equal(t, int64(len(packContent)), size)
 	})
 
 	t.Run("Read an object from the pending buffer before commit", func(t *testing.T) {
@@ -147,12 +177,7 @@ func TestObjectStore(t *testing.T) {
 		require.NoError(t, err, "Commit after concurrent writes failed")
 
 		// Check the index size after commit.
-		indexPath := GetIndexPath(testDir)
-		indexContent, err := os.ReadFile(indexPath)
-		require.NoError(t, err)
-
-		var index types.PackIndex
-		err = json.Unmarshal(indexContent, &index)
+		index, err := NewLocalObjectStore(testDir).GetIndex()
 		require.NoError(t, err)
 		assert.Equal(t, numGoroutines, len(index), "Expected index to have %d objects after commit", numGoroutines)
 	})
@@ -181,3 +206,815 @@ func TestObjectStore(t *testing.T) {
 		assert.Equal(t, manifest.Chunks, readManifest.Chunks, "Read JSON object has incorrect chunk data")
 	})
 }
+
+func TestObjectStoreWithMemoryBackend(t *testing.T) {
+	t.Run("should persist packs and the index through the backend", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		content := []byte("stored in memory")
+
+		// Act
+		hash, err := store.WriteObject(content)
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+
+		// Assert: a fresh store over the same backend can read the object.
+		reopened := NewObjectStore(backend)
+		readContent, err := reopened.ReadObjectAsBuffer(hash)
+		require.NoError(t, err)
+		assert.Equal(t, content, readContent)
+
+		packs, err := reopened.ListPacks()
+		require.NoError(t, err)
+		assert.Len(t, packs, 1, "Expected one packfile after a single commit")
+
+		shards, err := backend.List(IndexDirName)
+		require.NoError(t, err)
+		assert.Equal(t, packs[0].Name, shards[0].Name, "The pack's index shard should be written to the backend")
+	})
+
+	t.Run("should manage snaps and the snap counter through the backend", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+
+		// Act
+		firstID, err := store.GetNextSnapID()
+		require.NoError(t, err)
+		snapHash, err := store.WriteSnap(types.Snap{ID: firstID, Timestamp: "2024-01-01T00:00:00Z", Message: "in memory"})
+		require.NoError(t, err)
+		require.NoError(t, store.IncrementNextSnapID())
+		secondID, err := store.GetNextSnapID()
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, int64(1), firstID)
+		assert.Equal(t, int64(2), secondID)
+
+		found, err := store.FindSnap(snapHash[:8])
+		require.NoError(t, err)
+		assert.Equal(t, "in memory", found.Message)
+
+		require.NoError(t, store.DeleteSnap(snapHash))
+		snaps, err := store.GetSortedSnaps()
+		require.NoError(t, err)
+		assert.Empty(t, snaps)
+	})
+}
+
+func TestEncryptedObjectStore(t *testing.T) {
+	t.Run("should store nothing in plaintext and read everything back", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKey(backend, "password")
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		first, second := []byte("first secret object"), []byte("second secret object")
+
+		// Act
+		firstHash, err := store.WriteObject(first)
+		require.NoError(t, err)
+		secondHash, err := store.WriteObject(second)
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+		snapHash, err := store.WriteSnap(types.Snap{ID: 1, Timestamp: "2024-01-01T00:00:00Z", Message: "secret message"})
+		require.NoError(t, err)
+
+		// Assert: no stored file reveals the plaintext.
+		for _, dir := range []string{PacksDirName, SnapsDirName, "."} {
+			entries, err := backend.List(dir)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				name := path.Join(dir, entry.Name)
+				data, err := backend.Get(name)
+				require.NoError(t, err)
+				for _, secret := range []string{"secret", firstHash} {
+					assert.NotContains(t, string(data), secret, "%s leaks plaintext", name)
+				}
+			}
+		}
+
+		// Assert: a fresh store with the unlocked key reads every object.
+		unlocked, err := UnlockRepositoryKey(backend, "password")
+		require.NoError(t, err)
+		reopened := NewEncryptedObjectStore(backend, unlocked)
+		for hash, content := range map[string][]byte{firstHash: first, secondHash: second} {
+			data, err := reopened.ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, content, data)
+		}
+		found, err := reopened.FindSnap(snapHash[:8])
+		require.NoError(t, err)
+		assert.Equal(t, "secret message", found.Message)
+	})
+
+	t.Run("should not read an encrypted repository without the key", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKey(backend, "password")
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		_, err = store.WriteObject([]byte("data"))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+
+		// Act
+		_, err = NewObjectStore(backend).GetIndex()
+
+		// Assert
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyedObjectStore(t *testing.T) {
+	// Arrange: an encrypted repository with keyed hashing.
+	backend := NewMemoryBackend()
+	key, err := InitRepositoryKey(backend, "password")
+	require.NoError(t, err)
+	store := NewEncryptedObjectStore(backend, key)
+	config, err := NewKeyedConfig()
+	require.NoError(t, err)
+	require.NoError(t, store.WriteConfig(config))
+	content := []byte("a file everybody has")
+
+	t.Run("should not use the plain SHA-256 as the ID", func(t *testing.T) {
+		// Act
+		hash, err := store.WriteObject(content)
+
+		// Assert
+		require.NoError(t, err)
+		assert.NotEqual(t, GetHash(content), hash)
+		assert.Equal(t, NewHasher(config.HashKey).GetHash(content), hash)
+		_, err = store.Commit()
+		require.NoError(t, err)
+	})
+
+	t.Run("should derive the same IDs after loading the config", func(t *testing.T) {
+		// Arrange
+		reopened := NewEncryptedObjectStore(backend, key)
+
+		// Act
+		require.NoError(t, reopened.LoadConfig())
+		hash := reopened.Hasher().GetHash(content)
+
+		// Assert
+		data, err := reopened.ReadObjectAsBuffer(hash)
+		require.NoError(t, err)
+		assert.Equal(t, content, data)
+	})
+
+	t.Run("should keep the hash key out of reach without the master key", func(t *testing.T) {
+		// Arrange
+		writeOnlyKey, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+
+		// Act
+		err = NewEncryptedObjectStore(backend, writeOnlyKey).LoadConfig()
+
+		// Assert
+		assert.ErrorIs(t, err, ErrWriteOnly)
+	})
+}
+
+func TestChunkerConfig(t *testing.T) {
+	// Arrange: a repository encrypted to an age recipient, set to FastCDC.
+	backend := NewMemoryBackend()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	key, err := InitRepositoryKey(backend, "", identity.Recipient())
+	require.NoError(t, err)
+	require.NoError(t, NewEncryptedObjectStore(backend, key).WriteConfig(RepositoryConfig{Chunker: ChunkerFastCDC}))
+
+	t.Run("should use the configured chunker after loading the config", func(t *testing.T) {
+		store := NewEncryptedObjectStore(backend, key)
+		assert.Equal(t, ChunkerRabin, store.Chunker().Name(), "Stores start with Rabin fingerprinting")
+		require.NoError(t, store.LoadConfig())
+		assert.Equal(t, ChunkerFastCDC, store.Chunker().Name())
+	})
+
+	t.Run("should store a config without secrets in plain for write-only backups", func(t *testing.T) {
+		// Arrange
+		writeOnlyKey, err := OpenWriteOnlyKey(backend)
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, writeOnlyKey)
+
+		// Act
+		err = store.LoadConfig()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, ChunkerFastCDC, store.Chunker().Name())
+	})
+
+	t.Run("should refuse an unknown chunker", func(t *testing.T) {
+		err := NewObjectStore(NewMemoryBackend()).WriteConfig(RepositoryConfig{Chunker: "fixed"})
+		assert.ErrorContains(t, err, "unsupported chunker")
+	})
+}
+
+func TestWriteOnlyObjectStore(t *testing.T) {
+	// Arrange: a repository encrypted to an age recipient, with one object
+	// written by a store holding the master key.
+	backend := NewMemoryBackend()
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	key, err := InitRepositoryKey(backend, "", identity.Recipient())
+	require.NoError(t, err)
+	store := NewEncryptedObjectStore(backend, key)
+	firstHash, err := store.WriteObject([]byte("first"))
+	require.NoError(t, err)
+	_, err = store.Commit()
+	require.NoError(t, err)
+
+	// Act: a backup without secrets adds a second object.
+	writeOnlyKey, err := OpenWriteOnlyKey(backend)
+	require.NoError(t, err)
+	writer := NewEncryptedObjectStore(backend, writeOnlyKey)
+	secondHash, err := writer.WriteObject([]byte("second"))
+	require.NoError(t, err)
+	_, err = writer.Commit()
+	require.NoError(t, err)
+
+	t.Run("should write an index shard for its pack", func(t *testing.T) {
+		shards, err := backend.List(IndexDirName)
+		require.NoError(t, err)
+		assert.Len(t, shards, 2)
+	})
+
+	t.Run("should let readers merge the shards of every writer", func(t *testing.T) {
+		// Arrange
+		unlocked, err := UnlockRepositoryKeyWithIdentities(backend, []age.Identity{identity})
+		require.NoError(t, err)
+		reader := NewEncryptedObjectStore(backend, unlocked)
+
+		// Act & Assert: both objects are readable.
+		for hash, content := range map[string]string{firstHash: "first", secondHash: "second"} {
+			data, err := reader.ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		}
+
+		// Act: a key holder commits a third object.
+		_, err = reader.WriteObject([]byte("third"))
+		require.NoError(t, err)
+		_, err = reader.Commit()
+		require.NoError(t, err)
+
+		// Assert
+		shards, err := backend.List(IndexDirName)
+		require.NoError(t, err)
+		assert.Len(t, shards, 3)
+		index, err := NewEncryptedObjectStore(backend, unlocked).GetIndex()
+		require.NoError(t, err)
+		assert.Len(t, index, 3)
+	})
+}
+
+func TestIndexFilter(t *testing.T) {
+	t.Run("should de-duplicate committed objects after the filter grows", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		objects := 3 * minBloomCapacity
+		for i := 0; i < objects; i++ {
+			_, err := store.WriteObject([]byte("object " + strconv.Itoa(i)))
+			require.NoError(t, err)
+		}
+		_, err := store.Commit()
+		require.NoError(t, err)
+		reopened := NewObjectStore(backend)
+
+		// Act
+		for i := 0; i < objects; i++ {
+			for _, s := range []*ObjectStore{store, reopened} {
+				_, err := s.WriteObject([]byte("object " + strconv.Itoa(i)))
+				require.NoError(t, err)
+			}
+		}
+
+		// Assert
+		assert.Zero(t, store.PendingObjectCount())
+		assert.Zero(t, reopened.PendingObjectCount())
+		assert.GreaterOrEqual(t, store.indexFilter.capacity, objects, "The filter should grow with the index")
+	})
+}
+
+func TestObjectStorePendingLimit(t *testing.T) {
+	t.Run("should write a pack once pending objects reach the limit", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+		store.packSize = 10
+		store.compression = Compression{Algorithm: CompressionOff}
+
+		// Act
+		first, err := store.WriteObject([]byte("first"))
+		require.NoError(t, err)
+		second, err := store.WriteObject([]byte("second"))
+		require.NoError(t, err)
+		pendingAfterLimit := store.PendingObjectCount()
+		third, err := store.WriteObject([]byte("third"))
+		require.NoError(t, err)
+		size, err := store.Commit()
+		require.NoError(t, err)
+
+		// Assert
+		assert.Equal(t, 0, pendingAfterLimit, "Reaching the limit should write the pending objects")
+		packs, err := store.ListPacks()
+		require.NoError(t, err)
+		assert.Len(t, packs, 2)
+		assert.Equal(t, packs[0].Size+packs[1].Size, size, "Commit should count every pack")
+		reader := NewObjectStore(store.Backend())
+		for hash, content := range map[string]string{first: "first", second: "second", third: "third"} {
+			data, err := reader.ReadObjectAsBuffer(hash)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(data))
+		}
+	})
+
+	t.Run("should keep concurrent writers within the memory limit", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+		require.NoError(t, store.SetMemoryLimit(MinMemoryLimit))
+		var wg sync.WaitGroup
+		errs := make(chan error, 8)
+		var peak int64
+		var peakMutex sync.Mutex
+
+		// Act
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					data := make([]byte, maxChunkSize)
+					_, _ = rand.Read(data)
+					if _, err := store.WriteObject(data); err != nil {
+						errs <- err
+						return
+					}
+					store.memory.mutex.Lock()
+					used := store.memory.used
+					store.memory.mutex.Unlock()
+					peakMutex.Lock()
+					peak = max(peak, used)
+					peakMutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		_, err := store.Commit()
+
+		// Assert
+		for err := range errs {
+			require.NoError(t, err)
+		}
+		require.NoError(t, err)
+		assert.LessOrEqual(t, peak, int64(MinMemoryLimit))
+		assert.Zero(t, store.memory.used, "Commit should return all memory")
+		packs, err := store.ListPacks()
+		require.NoError(t, err)
+		assert.Greater(t, len(packs), 2, "Packs should be written whenever half the limit is pending")
+	})
+
+	t.Run("should refuse a memory limit below the minimum", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+
+		// Act
+		err := store.SetMemoryLimit(MinMemoryLimit - 1)
+
+		// Assert
+		assert.ErrorContains(t, err, "memory limit must be at least")
+		assert.NoError(t, store.SetMemoryLimit(0))
+	})
+
+	t.Run("should accept only pack sizes within range", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+
+		// Act & Assert
+		assert.Error(t, store.SetPackSize(MinPackSize-1))
+		assert.Error(t, store.SetPackSize(MaxPackSize+1))
+		require.NoError(t, store.SetPackSize(MinPackSize))
+		assert.Equal(t, int64(MinPackSize), store.packSize)
+		require.NoError(t, store.SetPackSize(0))
+		assert.Equal(t, int64(DefaultPackSize), store.packSize, "Zero should select the default")
+	})
+}
+
+// rangeCountingBackend counts the GetRange calls that reach a MemoryBackend.
+type rangeCountingBackend struct {
+	*MemoryBackend
+	ranges int
+}
+
+func (b *rangeCountingBackend) GetRange(name string, offset, length int64) ([]byte, error) {
+	b.ranges++
+	return b.MemoryBackend.GetRange(name, offset, length)
+}
+
+func TestReadObjects(t *testing.T) {
+	// writeObjects commits the given objects and returns their hashes.
+	writeObjects := func(t *testing.T, store *ObjectStore, contents ...string) []string {
+		var hashes []string
+		for _, content := range contents {
+			hash, err := store.WriteObject([]byte(content))
+			require.NoError(t, err)
+			hashes = append(hashes, hash)
+		}
+		_, err := store.Commit()
+		require.NoError(t, err)
+		return hashes
+	}
+	// readObjects reads the objects with the given hashes through a fresh store.
+	readObjects := func(t *testing.T, backend Backend, hashes []string) []string {
+		var contents []string
+		require.NoError(t, NewObjectStore(backend).ReadObjects(hashes, func(data []byte) error {
+			contents = append(contents, string(data))
+			return nil
+		}))
+		return contents
+	}
+
+	t.Run("should read objects written together in a single read", func(t *testing.T) {
+		// Arrange
+		backend := &rangeCountingBackend{MemoryBackend: NewMemoryBackend()}
+		hashes := writeObjects(t, NewObjectStore(backend), "one", "two", "three", "four")
+
+		// Act
+		contents := readObjects(t, backend, hashes)
+
+		// Assert
+		assert.Equal(t, []string{"one", "two", "three", "four"}, contents)
+		assert.Equal(t, 1, backend.ranges)
+	})
+
+	t.Run("should return objects in the order asked for", func(t *testing.T) {
+		// Arrange
+		backend := &rangeCountingBackend{MemoryBackend: NewMemoryBackend()}
+		hashes := writeObjects(t, NewObjectStore(backend), "one", "two", "three")
+
+		// Act
+		contents := readObjects(t, backend, []string{hashes[2], hashes[0], hashes[0], hashes[1]})
+
+		// Assert
+		assert.Equal(t, []string{"three", "one", "one", "two"}, contents)
+		assert.Equal(t, 3, backend.ranges, "Only objects that follow each other should share a read")
+	})
+
+	t.Run("should read objects from several packs and the pending buffer", func(t *testing.T) {
+		// Arrange
+		backend := &rangeCountingBackend{MemoryBackend: NewMemoryBackend()}
+		store := NewObjectStore(backend)
+		first := writeObjects(t, store, "first pack")
+		second := writeObjects(t, store, "second pack")
+		pending, err := store.WriteObject([]byte("pending"))
+		require.NoError(t, err)
+
+		// Act
+		var contents []string
+		err = store.ReadObjects([]string{first[0], pending, second[0]}, func(data []byte) error {
+			contents = append(contents, string(data))
+			return nil
+		})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{"first pack", "pending", "second pack"}, contents)
+		assert.Equal(t, 2, backend.ranges)
+	})
+
+	t.Run("should serve objects read before from memory", func(t *testing.T) {
+		// Arrange
+		backend := &rangeCountingBackend{MemoryBackend: NewMemoryBackend()}
+		hashes := writeObjects(t, NewObjectStore(backend), "shared", "other")
+		store := NewObjectStore(backend)
+		_, err := store.ReadObjectAsBuffer(hashes[0])
+		require.NoError(t, err)
+
+		// Act
+		var contents []string
+		err = store.ReadObjects([]string{hashes[0], hashes[1], hashes[0]}, func(data []byte) error {
+			contents = append(contents, string(data))
+			return nil
+		})
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []string{"shared", "other", "shared"}, contents)
+		assert.Equal(t, 2, backend.ranges, "Only the object not read before should need a read")
+	})
+
+	t.Run("should not merge reads beyond the readahead limit", func(t *testing.T) {
+		// Arrange
+		backend := &rangeCountingBackend{MemoryBackend: NewMemoryBackend()}
+		store := NewObjectStore(backend)
+		store.compression = Compression{Algorithm: CompressionOff}
+		large := make([]byte, maxReadaheadSize/2+1)
+		_, err := rand.Read(large)
+		require.NoError(t, err)
+		hashes := writeObjects(t, store, string(large), "small", string(large[1:]))
+
+		// Act
+		contents := readObjects(t, backend, hashes)
+
+		// Assert
+		assert.Equal(t, []string{string(large), "small", string(large[1:])}, contents)
+		assert.Equal(t, 2, backend.ranges)
+	})
+
+	t.Run("should fail for a missing object", func(t *testing.T) {
+		// Arrange
+		store := NewObjectStore(NewMemoryBackend())
+
+		// Act
+		err := store.ReadObjects([]string{GetHash([]byte("missing"))}, func([]byte) error { return nil })
+
+		// Assert
+		assert.ErrorContains(t, err, "not found in index")
+	})
+
+	t.Run("should fail naming the pack and object whose bytes changed", func(t *testing.T) {
+		// Arrange: flip a byte of an object stored as it is, so that it still
+		// decodes but no longer hashes to its ID.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		require.NoError(t, store.SetCompression(Compression{Algorithm: CompressionOff}))
+		hashes := writeObjects(t, store, "intact", "damaged")
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		entry := index[hashes[1]]
+		pack, err := backend.Get(packName(entry.PackHash))
+		require.NoError(t, err)
+		pack[entry.Offset] ^= 0xff
+		require.NoError(t, backend.Put(packName(entry.PackHash), pack))
+		reader := NewObjectStore(backend)
+
+		// Act
+		_, err = reader.ReadObjectAsBuffer(hashes[1])
+
+		// Assert
+		require.ErrorIs(t, err, ErrCorrupted)
+		assert.Contains(t, err.Error(), "pack "+entry.PackHash+" corrupted at object "+hashes[1])
+		data, err := reader.ReadObjectAsBuffer(hashes[0])
+		require.NoError(t, err)
+		assert.Equal(t, "intact", string(data))
+	})
+}
+
+func TestIndexShards(t *testing.T) {
+	// commitObject commits a single object and returns its hash and pack.
+	commitObject := func(t *testing.T, store *ObjectStore, content string) (string, string) {
+		hash, err := store.WriteObject([]byte(content))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		return hash, index[hash].PackHash
+	}
+
+	t.Run("should add a shard for each pack without rewriting the others", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		_, firstPack := commitObject(t, store, "first")
+		firstShard, err := backend.Get(shardName(firstPack))
+		require.NoError(t, err)
+
+		// Act
+		_, secondPack := commitObject(t, store, "second")
+
+		// Assert
+		shards, err := backend.List(IndexDirName)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{firstPack, secondPack}, []string{shards[0].Name, shards[1].Name})
+		unchanged, err := backend.Get(shardName(firstPack))
+		require.NoError(t, err)
+		assert.Equal(t, firstShard, unchanged)
+		index, err := NewObjectStore(backend).GetIndex()
+		require.NoError(t, err)
+		assert.Len(t, index, 2)
+	})
+
+	t.Run("should delete a pack's shard along with the pack", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		deleted, deletedPack := commitObject(t, store, "deleted")
+		kept, _ := commitObject(t, store, "kept")
+
+		// Act
+		err := store.DeletePack(deletedPack)
+
+		// Assert
+		require.NoError(t, err)
+		_, err = backend.Get(shardName(deletedPack))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+		for _, reader := range []*ObjectStore{store, NewObjectStore(backend)} {
+			index, err := reader.GetIndex()
+			require.NoError(t, err)
+			assert.NotContains(t, index, deleted)
+			assert.Contains(t, index, kept)
+		}
+		rewritten, err := store.WriteObject([]byte("deleted"))
+		require.NoError(t, err)
+		assert.Equal(t, 1, store.PendingObjectCount(), "An object of a deleted pack should be written again")
+		assert.Equal(t, deleted, rewritten)
+	})
+
+	t.Run("should replace an index of the old layout with shards", func(t *testing.T) {
+		// Arrange: move the shards of two packs into a single index file and
+		// a fragment, as repositories kept them before shards.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		first, firstPack := commitObject(t, store, "first")
+		second, secondPack := commitObject(t, store, "second")
+		shard, err := backend.Get(shardName(firstPack))
+		require.NoError(t, err)
+		require.NoError(t, backend.Put(IndexFileName, shard))
+		secondEntry := mustReadIndexFile(t, backend, shardName(secondPack))[second]
+		require.NoError(t, backend.Put(IndexDirName+"/session", mustMarshal(t, types.PackIndex{
+			second:  secondEntry,
+			"other": {PackHash: firstPack, Length: 1},
+		})))
+		require.NoError(t, backend.Delete(shardName(firstPack)))
+		require.NoError(t, backend.Delete(shardName(secondPack)))
+
+		// Act: the old layout is read, then replaced by the next commit.
+		reader := NewObjectStore(backend)
+		data, err := reader.ReadObjectAsBuffer(first)
+		require.NoError(t, err)
+		assert.Equal(t, "first", string(data))
+		third, thirdPack := commitObject(t, reader, "third")
+
+		// Assert
+		_, err = backend.Get(IndexFileName)
+		assert.ErrorIs(t, err, fs.ErrNotExist, "The old index should be deleted")
+		shards, err := backend.List(IndexDirName)
+		require.NoError(t, err)
+		var names []string
+		for _, shard := range shards {
+			names = append(names, shard.Name)
+		}
+		assert.ElementsMatch(t, []string{firstPack, secondPack, thirdPack}, names)
+		index, err := NewObjectStore(backend).GetIndex()
+		require.NoError(t, err)
+		for _, hash := range []string{first, second, third, "other"} {
+			assert.Contains(t, index, hash)
+		}
+	})
+}
+
+func TestRebuildIndex(t *testing.T) {
+	// commitObject commits a single object and returns its hash and pack.
+	commitObject := func(t *testing.T, store *ObjectStore, content string) (string, string) {
+		hash, err := store.WriteObject([]byte(content))
+		require.NoError(t, err)
+		_, err = store.Commit()
+		require.NoError(t, err)
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		return hash, index[hash].PackHash
+	}
+
+	t.Run("should drop broken index files and keep what the rest list", func(t *testing.T) {
+		// Arrange: an index of the old layout that cannot be parsed, a shard
+		// of a pack that no longer exists, and one pack only listed in a
+		// fragment of the old layout.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		kept, _ := commitObject(t, store, "kept")
+		moved, movedPack := commitObject(t, store, "moved")
+		_, missingPack := commitObject(t, store, "missing")
+		require.NoError(t, backend.Delete(packName(missingPack)))
+		require.NoError(t, backend.Put(IndexFileName, []byte("{not json")))
+		movedShard, err := backend.Get(shardName(movedPack))
+		require.NoError(t, err)
+		require.NoError(t, backend.Put(IndexDirName+"/session", movedShard))
+		require.NoError(t, backend.Delete(shardName(movedPack)))
+		_, err = NewObjectStore(backend).GetIndex()
+		require.Error(t, err, "The broken index should make the repository unusable")
+
+		// Act
+		rebuild, err := NewObjectStore(backend).RebuildIndex()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 2, rebuild.Packs)
+		assert.Equal(t, 2, rebuild.Objects)
+		assert.Empty(t, rebuild.Lost)
+		assert.ElementsMatch(t, []string{IndexFileName, shardName(missingPack)}, rebuild.Dropped)
+		_, err = backend.Get(IndexDirName + "/session")
+		assert.ErrorIs(t, err, fs.ErrNotExist, "The fragment should be replaced by a shard")
+		index, err := NewObjectStore(backend).GetIndex()
+		require.NoError(t, err)
+		assert.Len(t, index, 2)
+		assert.Contains(t, index, kept)
+		assert.Equal(t, movedPack, index[moved].PackHash)
+	})
+
+	t.Run("should list the objects of a pack whose shard is lost from the pack", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		commitObject(t, store, "kept")
+		lost, lostPack := commitObject(t, store, "lost")
+		require.NoError(t, backend.Put(shardName(lostPack), []byte("garbage")))
+
+		// Act
+		rebuild, err := store.RebuildIndex()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 2, rebuild.Packs)
+		assert.Empty(t, rebuild.Lost)
+		assert.Equal(t, []string{lostPack}, rebuild.Scanned)
+		data, err := NewObjectStore(backend).ReadObjectAsBuffer(lost)
+		require.NoError(t, err)
+		assert.Equal(t, "lost", string(data))
+	})
+
+	t.Run("should report a pack of the old format whose shard is lost and leave it in place", func(t *testing.T) {
+		// Arrange: a pack holding nothing but the stored bytes of an object.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		commitObject(t, store, "kept")
+		require.NoError(t, backend.Put(packName("0ld"), []byte("lost")))
+
+		// Act
+		rebuild, err := store.RebuildIndex()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, 1, rebuild.Packs)
+		require.Contains(t, rebuild.Lost, "0ld")
+		assert.ErrorIs(t, rebuild.Lost["0ld"], ErrPackNotSelfDescribing)
+		_, err = backend.Get(packName("0ld"))
+		assert.NoError(t, err, "The pack should be kept for a later recovery")
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		assert.Len(t, index, 1)
+	})
+}
+
+// mustReadIndexFile returns the entries of an unencrypted index file.
+func mustReadIndexFile(t *testing.T, backend Backend, name string) types.PackIndex {
+	content, err := backend.Get(name)
+	require.NoError(t, err)
+	var entries types.PackIndex
+	require.NoError(t, json.Unmarshal(content, &entries))
+	return entries
+}
+
+// mustMarshal returns the JSON encoding of value.
+func mustMarshal(t *testing.T, value any) []byte {
+	data, err := json.Marshal(value)
+	require.NoError(t, err)
+	return data
+}
+
+func BenchmarkWriteObject(b *testing.B) {
+	chunks := make([][]byte, 1024)
+	for i := range chunks {
+		chunks[i] = make([]byte, avgChunkSize)
+		_, err := rand.Read(chunks[i])
+		require.NoError(b, err)
+	}
+
+	b.Run("new", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			store := NewObjectStore(NewMemoryBackend())
+			store.compression = Compression{Algorithm: CompressionOff}
+			for _, chunk := range chunks {
+				_, err := store.WriteObject(chunk)
+				require.NoError(b, err)
+			}
+			_, err := store.Commit()
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("duplicate", func(b *testing.B) {
+		store := NewObjectStore(NewMemoryBackend())
+		for _, chunk := range chunks {
+			_, err := store.WriteObject(chunk)
+			require.NoError(b, err)
+		}
+		_, err := store.Commit()
+		require.NoError(b, err)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for _, chunk := range chunks {
+				_, err := store.WriteObject(chunk)
+				require.NoError(b, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package lib
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// ownerNames caches the names of user and group IDs, and the IDs of user
+// and group names, since looking them up can mean reading /etc/passwd or
+// asking a directory service, and a tree has few distinct owners. Failed
+// lookups are cached too, as empty names and missing IDs.
+var ownerNames = struct {
+	sync.Mutex
+	users, groups     map[uint32]string
+	userIDs, groupIDs map[string]int
+}{
+	users:    make(map[uint32]string),
+	groups:   make(map[uint32]string),
+	userIDs:  make(map[string]int),
+	groupIDs: make(map[string]int),
+}
+
+// newOwner returns the owner with the given IDs, with the names they have on
+// this system.
+func newOwner(uid, gid uint32) *types.Owner {
+	ownerNames.Lock()
+	defer ownerNames.Unlock()
+	name, ok := ownerNames.users[uid]
+	if !ok {
+		if u, err := user.LookupId(strconv.FormatUint(uint64(uid), 10)); err == nil {
+			name = u.Username
+		}
+		ownerNames.users[uid] = name
+	}
+	group, ok := ownerNames.groups[gid]
+	if !ok {
+		if g, err := user.LookupGroupId(strconv.FormatUint(uint64(gid), 10)); err == nil {
+			group = g.Name
+		}
+		ownerNames.groups[gid] = group
+	}
+	return &types.Owner{UID: uid, GID: gid, User: name, Group: group}
+}
+
+// LocalOwnerIDs returns the user and group IDs that owner stands for on this
+// system: those of its names where they exist here, and otherwise its IDs.
+func LocalOwnerIDs(owner types.Owner) (uid, gid int) {
+	ownerNames.Lock()
+	defer ownerNames.Unlock()
+	uid, gid = int(owner.UID), int(owner.GID)
+	if owner.User != "" {
+		id, ok := ownerNames.userIDs[owner.User]
+		if !ok {
+			id = -1
+			if u, err := user.Lookup(owner.User); err == nil {
+				if parsed, err := strconv.Atoi(u.Uid); err == nil {
+					id = parsed
+				}
+			}
+			ownerNames.userIDs[owner.User] = id
+		}
+		if id >= 0 {
+			uid = id
+		}
+	}
+	if owner.Group != "" {
+		id, ok := ownerNames.groupIDs[owner.Group]
+		if !ok {
+			id = -1
+			if g, err := user.LookupGroup(owner.Group); err == nil {
+				if parsed, err := strconv.Atoi(g.Gid); err == nil {
+					id = parsed
+				}
+			}
+			ownerNames.groupIDs[owner.Group] = id
+		}
+		if id >= 0 {
+			gid = id
+		}
+	}
+	return uid, gid
+}
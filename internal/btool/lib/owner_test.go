@@ -0,0 +1,56 @@
+package lib
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("files have no user and group IDs on Windows")
+	}
+
+	t.Run("should record the owner of a file with its names", func(t *testing.T) {
+		// Arrange
+		path := filepath.Join(t.TempDir(), "file.txt")
+		require.NoError(t, os.WriteFile(path, []byte("content"), 0644))
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+
+		// Act
+		owner := FileOwner(info)
+
+		// Assert
+		require.NotNil(t, owner)
+		assert.Equal(t, uint32(os.Getuid()), owner.UID)
+		if current, err := user.Current(); err == nil {
+			assert.Equal(t, current.Username, owner.User)
+		}
+	})
+
+	t.Run("should prefer the local IDs of names to the recorded IDs", func(t *testing.T) {
+		// Arrange
+		current, err := user.Current()
+		if err != nil {
+			t.Skipf("cannot look up the current user: %v", err)
+		}
+		uid, err := strconv.Atoi(current.Uid)
+		require.NoError(t, err)
+
+		// Act
+		byName, _ := LocalOwnerIDs(types.Owner{UID: 4242424, User: current.Username})
+		unknown, _ := LocalOwnerIDs(types.Owner{UID: 4242424, User: "no-such-btool-user"})
+
+		// Assert
+		assert.Equal(t, uid, byName)
+		assert.Equal(t, 4242424, unknown, "An unknown name should fall back to the recorded ID")
+	})
+}
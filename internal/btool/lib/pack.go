@@ -0,0 +1,284 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// A pack describes its own objects, so that its index shard can be written
+// again from the pack alone. It starts with packMagic, followed by a record
+// for each object and then the object's stored bytes:
+//
+//	recordMagic | length of the record (uint32) | record | object
+//
+// The record is a packRecord in JSON, sealed like the objects in an
+// encrypted repository, so that it gives nothing away that the index does
+// not. Its CRC of the stored bytes lets a scan of a damaged pack skip the
+// objects that changed, and the pack's name, the hash of all its bytes, is
+// its checksum as a whole. After the last object come the pack's table of contents, the entries
+// of its index shard sealed the same way, and a footer holding the length of
+// the table of contents (uint64) and tocMagic. Index entries point at the
+// stored bytes of objects, so reads never look at the records, and packs
+// written before records existed, which hold nothing but the stored bytes,
+// are still read through the index.
+const (
+	packMagic   = "BTOOLPK\x01"
+	recordMagic = "BTOB"
+	tocMagic    = "BTOOLTOC"
+	footerSize  = 8 + len(tocMagic)
+)
+
+// recordCRCTable is the CRC-32C table of the checksums in pack records.
+var recordCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// maxRecordSize bounds the length a record may claim, which protects scans
+// from corrupt packs.
+const maxRecordSize = 64 * 1024
+
+// Object types recorded in packs. Objects copied from packs written before
+// records existed have no type.
+const (
+	recordTypeData           = "data"
+	recordTypeIncompressible = "incompressible"
+	recordTypeMetadata       = "metadata"
+)
+
+// recordTypes names the object kinds in pack records.
+var recordTypes = map[objectKind]string{
+	dataObject:           recordTypeData,
+	incompressibleObject: recordTypeIncompressible,
+	metadataObject:       recordTypeMetadata,
+}
+
+// packRecord describes the object that follows it in a pack.
+type packRecord struct {
+	Hash               string `json:"hash"`
+	Type               string `json:"type,omitempty"`
+	Length             int64  `json:"length"` // Bytes stored in the pack.
+	Compression        string `json:"compression,omitempty"`
+	UncompressedLength int64  `json:"uncompressedLength,omitempty"`
+	// CRC is the CRC-32C of the stored bytes. Records written before it
+	// existed have none.
+	CRC *uint32 `json:"crc,omitempty"`
+}
+
+// packBuilder streams a pack to the backend object by object, hashing it
+// along the way, so that no copy of it is built in memory.
+type packBuilder struct {
+	store   *ObjectStore
+	writer  BackendWriter
+	hasher  hash.Hash
+	out     io.Writer
+	offset  int64
+	entries types.PackIndex
+}
+
+// newPackBuilder starts a new pack.
+func (s *ObjectStore) newPackBuilder() (*packBuilder, error) {
+	writer, err := createFile(s.backend, PacksDirName)
+	if err != nil {
+		return nil, err
+	}
+	b := &packBuilder{store: s, writer: writer, hasher: s.hasher.newHash(), entries: make(types.PackIndex)}
+	b.out = io.MultiWriter(writer, b.hasher)
+	if err := b.write([]byte(packMagic)); err != nil {
+		writer.Abort()
+		return nil, err
+	}
+	return b, nil
+}
+
+// write appends data to the pack.
+func (b *packBuilder) write(data []byte) error {
+	if _, err := b.out.Write(data); err != nil {
+		return err
+	}
+	b.offset += int64(len(data))
+	return nil
+}
+
+// add appends an object, already compressed and sealed as it is to be
+// stored, with its record. The index entry gives how it was compressed.
+func (b *packBuilder) add(hash, recordType string, data []byte, entry types.PackIndexEntry) error {
+	crc := crc32.Checksum(data, recordCRCTable)
+	record, err := json.Marshal(packRecord{
+		Hash:               hash,
+		Type:               recordType,
+		Length:             int64(len(data)),
+		Compression:        entry.Compression,
+		UncompressedLength: entry.UncompressedLength,
+		CRC:                &crc,
+	})
+	if err != nil {
+		return err
+	}
+	if record, err = b.store.encrypt(record); err != nil {
+		return err
+	}
+	header := make([]byte, len(recordMagic)+4, len(recordMagic)+4+len(record))
+	copy(header, recordMagic)
+	binary.BigEndian.PutUint32(header[len(recordMagic):], uint32(len(record)))
+	if err := b.write(append(header, record...)); err != nil {
+		return err
+	}
+	entry.PackHash = ""
+	entry.Offset = b.offset
+	entry.Length = int64(len(data))
+	b.entries[hash] = entry
+	return b.write(data)
+}
+
+// finish writes the table of contents and names the pack by its hash. It
+// returns the hash and the index entries of the objects in it.
+func (b *packBuilder) finish() (string, types.PackIndex, error) {
+	toc, err := json.Marshal(b.entries)
+	if err != nil {
+		return "", nil, err
+	}
+	if toc, err = b.store.encrypt(toc); err != nil {
+		return "", nil, err
+	}
+	footer := make([]byte, 8, footerSize)
+	binary.BigEndian.PutUint64(footer, uint64(len(toc)))
+	if err := b.write(append(toc, append(footer, tocMagic...)...)); err != nil {
+		return "", nil, err
+	}
+
+	packHash := hex.EncodeToString(b.hasher.Sum(nil))
+	if err := b.writer.Commit(packName(packHash)); err != nil {
+		return "", nil, err
+	}
+	for hash, entry := range b.entries {
+		entry.PackHash = packHash
+		b.entries[hash] = entry
+	}
+	return packHash, b.entries, nil
+}
+
+// abort discards the pack unless it was finished.
+func (b *packBuilder) abort() {
+	b.writer.Abort()
+}
+
+// readRecords returns the records of the objects in pack by hash, with the
+// offset of each object's stored bytes, reading until the first record that
+// cannot be read. Objects whose stored bytes do not match the CRC of their
+// record are left out. It fails if pack does not start with packMagic.
+func (s *ObjectStore) readRecords(pack []byte) (map[string]packRecord, map[string]int64, error) {
+	if !bytes.HasPrefix(pack, []byte(packMagic)) {
+		return nil, nil, ErrPackNotSelfDescribing
+	}
+	records := make(map[string]packRecord)
+	offsets := make(map[string]int64)
+	offset := int64(len(packMagic))
+	headerSize := int64(len(recordMagic) + 4)
+	for offset+headerSize <= int64(len(pack)) && bytes.Equal(pack[offset:offset+int64(len(recordMagic))], []byte(recordMagic)) {
+		length := int64(binary.BigEndian.Uint32(pack[offset+int64(len(recordMagic)):]))
+		start := offset + headerSize
+		if length > maxRecordSize || start+length > int64(len(pack)) {
+			break
+		}
+		sealed := pack[start : start+length]
+		plaintext, err := s.decrypt(sealed)
+		if err != nil {
+			break
+		}
+		var record packRecord
+		if err := json.Unmarshal(plaintext, &record); err != nil || record.Length < 0 {
+			break
+		}
+		dataStart := start + length
+		if dataStart+record.Length > int64(len(pack)) {
+			break // The pack was cut short within the object.
+		}
+		offset = dataStart + record.Length
+		if record.CRC != nil && crc32.Checksum(pack[dataStart:offset], recordCRCTable) != *record.CRC {
+			continue
+		}
+		records[record.Hash] = record
+		offsets[record.Hash] = dataStart
+	}
+	return records, offsets, nil
+}
+
+// readTableOfContents reads the index entries that a pack lists at its end.
+func (s *ObjectStore) readTableOfContents(pack BackendEntry) (types.PackIndex, error) {
+	name := packName(pack.Name)
+	if pack.Size < int64(len(packMagic)+footerSize) {
+		return nil, ErrPackNotSelfDescribing
+	}
+	head, err := s.backend.GetRange(name, 0, int64(len(packMagic)))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(head, []byte(packMagic)) {
+		return nil, ErrPackNotSelfDescribing
+	}
+	footer, err := s.backend.GetRange(name, pack.Size-int64(footerSize), int64(footerSize))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(footer[8:], []byte(tocMagic)) {
+		return nil, errors.New("the pack has no table of contents at its end")
+	}
+	tocLength := int64(binary.BigEndian.Uint64(footer))
+	if tocLength <= 0 || tocLength > pack.Size-int64(len(packMagic)+footerSize) {
+		return nil, errors.New("the table of contents of the pack has an impossible length")
+	}
+	toc, err := s.backend.GetRange(name, pack.Size-int64(footerSize)-tocLength, tocLength)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.decodeIndexFile("the table of contents of pack "+pack.Name, toc)
+	if err != nil {
+		return nil, err
+	}
+	for hash, entry := range entries {
+		entry.PackHash = pack.Name
+		entries[hash] = entry
+	}
+	return entries, nil
+}
+
+// ScanPack lists the objects of a pack from the pack itself, for when its
+// index shard is lost. It reads the table of contents at the end of the
+// pack, or if that is damaged, the records of the objects one after the
+// other, which recovers the objects before any damage other than to their
+// own bytes.
+func (s *ObjectStore) ScanPack(pack BackendEntry) (types.PackIndex, error) {
+	entries, tocErr := s.readTableOfContents(pack)
+	if tocErr == nil || errors.Is(tocErr, ErrPackNotSelfDescribing) {
+		return entries, tocErr
+	}
+	content, err := s.backend.Get(packName(pack.Name))
+	if err != nil {
+		return nil, err
+	}
+	records, offsets, err := s.readRecords(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no object records could be read, and %w", tocErr)
+	}
+	entries = make(types.PackIndex, len(records))
+	for hash, record := range records {
+		entries[hash] = types.PackIndexEntry{
+			PackHash:           pack.Name,
+			Offset:             offsets[hash],
+			Length:             record.Length,
+			Compression:        record.Compression,
+			UncompressedLength: record.UncompressedLength,
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackFormat(t *testing.T) {
+	// setupPackTest commits three objects to a single pack and returns the
+	// pack and the index entries of its objects.
+	setupPackTest := func(t *testing.T, store *ObjectStore) (BackendEntry, types.PackIndex) {
+		t.Helper()
+		for _, content := range []string{"first object", "second object", "third object"} {
+			_, err := store.WriteObject([]byte(content))
+			require.NoError(t, err)
+		}
+		_, err := store.Commit()
+		require.NoError(t, err)
+		packs, err := store.ListPacks()
+		require.NoError(t, err)
+		require.Len(t, packs, 1)
+		index, err := store.GetIndex()
+		require.NoError(t, err)
+		require.Len(t, index, 3)
+		return packs[0], index
+	}
+
+	t.Run("should list the same objects as the index from the pack", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		pack, index := setupPackTest(t, store)
+
+		// Act
+		scanned, err := store.ScanPack(pack)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, index, scanned)
+		content, err := backend.Get(packName(pack.Name))
+		require.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(content, []byte(packMagic)))
+		records, _, err := store.readRecords(content)
+		require.NoError(t, err)
+		for hash := range index {
+			assert.Equal(t, recordTypeData, records[hash].Type)
+		}
+	})
+
+	t.Run("should list the objects of an encrypted pack without revealing their hashes", func(t *testing.T) {
+		// Arrange
+		backend := NewMemoryBackend()
+		key, err := InitRepositoryKey(backend, "password")
+		require.NoError(t, err)
+		store := NewEncryptedObjectStore(backend, key)
+		pack, index := setupPackTest(t, store)
+
+		// Act
+		scanned, err := store.ScanPack(pack)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, index, scanned)
+		content, err := backend.Get(packName(pack.Name))
+		require.NoError(t, err)
+		for hash := range index {
+			assert.NotContains(t, string(content), hash)
+		}
+	})
+
+	t.Run("should recover the objects before the damage from a pack cut short", func(t *testing.T) {
+		// Arrange: cut the pack within its last object.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		require.NoError(t, store.SetCompression(Compression{Algorithm: CompressionOff}))
+		pack, index := setupPackTest(t, store)
+		content, err := backend.Get(packName(pack.Name))
+		require.NoError(t, err)
+		var last string
+		for hash, entry := range index {
+			if last == "" || entry.Offset > index[last].Offset {
+				last = hash
+			}
+		}
+		cut := content[:index[last].Offset+2]
+		require.NoError(t, backend.Put(packName(pack.Name), cut))
+		pack.Size = int64(len(cut))
+
+		// Act
+		scanned, err := store.ScanPack(pack)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Len(t, scanned, 2)
+		assert.NotContains(t, scanned, last)
+		for hash, entry := range scanned {
+			assert.Equal(t, index[hash], entry)
+		}
+	})
+
+	t.Run("should skip an object whose bytes changed when scanning the records", func(t *testing.T) {
+		// Arrange: change the first object and cut off the table of contents.
+		backend := NewMemoryBackend()
+		store := NewObjectStore(backend)
+		require.NoError(t, store.SetCompression(Compression{Algorithm: CompressionOff}))
+		pack, index := setupPackTest(t, store)
+		content, err := backend.Get(packName(pack.Name))
+		require.NoError(t, err)
+		var first string
+		end := int64(0)
+		for hash, entry := range index {
+			if first == "" || entry.Offset < index[first].Offset {
+				first = hash
+			}
+			end = max(end, entry.Offset+entry.Length)
+		}
+		content[index[first].Offset] ^= 0xff
+		cut := content[:end]
+		require.NoError(t, backend.Put(packName(pack.Name), cut))
+		pack.Size = int64(len(cut))
+
+		// Act
+		scanned, err := store.ScanPack(pack)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Len(t, scanned, 2)
+		assert.NotContains(t, scanned, first)
+	})
+}
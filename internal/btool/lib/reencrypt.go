@@ -0,0 +1,219 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// ReencryptStats counts what Reencrypt rewrote.
+type ReencryptStats struct {
+	Packs   int // Packs rewritten, each replaced by a new one.
+	Objects int // Objects in those packs.
+	Files   int // Snap manifests and metadata files rewritten in place.
+}
+
+// Reencrypt rewrites everything stored in the repository that is not yet
+// sealed with the key's current data key, so that RetireDataKeys can then
+// drop the old keys. progress, if not nil, is called after each pack.
+//
+// Every pack is written under its new name and the index updated before the
+// old pack is deleted, so an interrupted run leaves a readable repository and
+// running it again resumes where it stopped. Other commands must not write to
+// the repository meanwhile, since they would still seal with the old key.
+func (s *ObjectStore) Reencrypt(progress func(done, total int)) (ReencryptStats, error) {
+	var stats ReencryptStats
+	if s.key == nil || !s.key.CanRead() {
+		return stats, errors.New("re-encrypting needs the repository's password or age identity")
+	}
+	if s.key.dataKey == nil {
+		return stats, errors.New("the repository key has not been rotated")
+	}
+	// The trash is not re-encrypted, so retiring the old keys would leave
+	// what 'prune --undo' restores unreadable.
+	trashes, err := s.ListTrash()
+	if err != nil {
+		return stats, fmt.Errorf("failed to list the trash: %w", err)
+	}
+	if len(trashes) > 0 {
+		var expires time.Time
+		for _, trash := range trashes {
+			if trash.Expires.After(expires) {
+				expires = trash.Expires
+			}
+		}
+		return stats, fmt.Errorf("the trash kept by %d earlier prune(s) is sealed with the old keys; re-encrypt once 'prune --undo' has restored it or a prune has purged it after %s",
+			len(trashes), expires.Format(time.RFC3339))
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if err := s.loadIndex(); err != nil {
+		return stats, err
+	}
+
+	// Small files are resealed in place.
+	names := []string{ConfigFileName}
+	snaps, err := s.backend.List(SnapsDirName)
+	if err != nil {
+		return stats, err
+	}
+	for _, entry := range snaps {
+		if path.Ext(entry.Name) == ".json" {
+			names = append(names, SnapsDirName+"/"+entry.Name)
+		}
+	}
+	for _, name := range names {
+		rewritten, err := s.reencryptFile(name)
+		if err != nil {
+			return stats, err
+		}
+		if rewritten {
+			stats.Files++
+		}
+	}
+
+	// Objects are resealed pack by pack. Their envelopes change length, so
+	// each pack is rebuilt and its index entries moved to the new pack.
+	entriesByPack := make(map[string][]string)
+	for hash, entry := range s.packIndex {
+		if s.deletedPacks[entry.PackHash] {
+			continue
+		}
+		entriesByPack[entry.PackHash] = append(entriesByPack[entry.PackHash], hash)
+	}
+	packHashes := make([]string, 0, len(entriesByPack))
+	for packHash := range entriesByPack {
+		packHashes = append(packHashes, packHash)
+	}
+	sort.Strings(packHashes)
+
+	for i, packHash := range packHashes {
+		objects, err := s.reencryptPack(packHash, entriesByPack[packHash])
+		if err != nil {
+			return stats, err
+		}
+		if objects > 0 {
+			stats.Packs++
+			stats.Objects += objects
+		}
+		if progress != nil {
+			progress(i+1, len(packHashes))
+		}
+	}
+
+	// Rewritten packs got new shards. An index of the old layout is replaced
+	// by shards, and the shards of the packs that needed no change resealed.
+	if err := s.migrateIndex(); err != nil {
+		return stats, err
+	}
+	shards, err := s.backend.List(IndexDirName)
+	if err != nil {
+		return stats, err
+	}
+	for _, shard := range shards {
+		rewritten, err := s.reencryptFile(IndexDirName + "/" + shard.Name)
+		if err != nil {
+			return stats, err
+		}
+		if rewritten {
+			stats.Files++
+		}
+	}
+	return stats, nil
+}
+
+// reencryptFile reseals a single file with the current data key. A missing
+// file, or one already sealed with it, is left alone.
+func (s *ObjectStore) reencryptFile(name string) (bool, error) {
+	content, err := s.backend.Get(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if s.key.sealedWithCurrentKey(content) || (name == ConfigFileName && isPlainConfig(content)) {
+		return false, nil
+	}
+	plaintext, err := s.key.Open(content)
+	if err != nil {
+		return false, fmt.Errorf("could not decrypt %s: %w", name, err)
+	}
+	if content, err = s.key.Seal(plaintext); err != nil {
+		return false, err
+	}
+	return true, s.backend.Put(name, content)
+}
+
+// reencryptPack rebuilds a pack with its objects resealed with the current
+// data key, writes the new pack's index shard, and deletes the old pack and
+// its shard. It returns how many objects it resealed, which is zero if the
+// pack needed no change.
+// It is NOT thread-safe by itself and should be called from within a locked section.
+func (s *ObjectStore) reencryptPack(packHash string, hashes []string) (int, error) {
+	pack, err := s.backend.Get(packName(packHash))
+	if err != nil {
+		return 0, fmt.Errorf("could not read pack %s: %w", packHash, err)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return s.packIndex[hashes[i]].Offset < s.packIndex[hashes[j]].Offset
+	})
+
+	current := true
+	for _, hash := range hashes {
+		entry := s.packIndex[hash]
+		if entry.Offset < 0 || entry.Offset+entry.Length > int64(len(pack)) {
+			return 0, fmt.Errorf("object %s lies outside pack %s", hash, packHash)
+		}
+		current = current && s.key.sealedWithCurrentKey(pack[entry.Offset:entry.Offset+entry.Length])
+	}
+	if current {
+		return 0, nil
+	}
+
+	// The records of a pack that has them give the types of its objects.
+	records, _, _ := s.readRecords(pack)
+	builder, err := s.newPackBuilder()
+	if err != nil {
+		return 0, err
+	}
+	defer builder.abort() // Does nothing once finished.
+	for _, hash := range hashes {
+		entry := s.packIndex[hash]
+		plaintext, err := s.key.Open(pack[entry.Offset : entry.Offset+entry.Length])
+		if err != nil {
+			return 0, fmt.Errorf("could not decrypt object %s: %w", hash, err)
+		}
+		data, err := s.key.Seal(plaintext)
+		if err != nil {
+			return 0, err
+		}
+		// Compression is untouched, since only the encryption changes.
+		if err := builder.add(hash, records[hash].Type, data, entry); err != nil {
+			return 0, err
+		}
+	}
+
+	newPackHash, newEntries, err := builder.finish()
+	if err != nil {
+		return 0, err
+	}
+	if err := s.writeShard(newPackHash, newEntries); err != nil {
+		return 0, err
+	}
+	for hash, entry := range newEntries {
+		s.packIndex[hash] = entry
+	}
+	if err := s.backend.Delete(shardName(packHash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	delete(s.shardPacks, packHash)
+	if err := s.backend.Delete(packName(packHash)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return 0, err
+	}
+	return len(hashes), nil
+}
@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleAliases are the named schedules a Schedule accepts besides cron
+// expressions and @every.
+var scheduleAliases = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// scheduleField is the range of a field of a cron expression.
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+var scheduleFields = [5]scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7}, // Both 0 and 7 are Sunday.
+}
+
+// Schedule tells when a recurring job runs: either at a fixed interval or
+// at the times matching a cron expression, in local time.
+type Schedule struct {
+	every time.Duration
+	// The values each field of a cron expression matches, as bit sets.
+	minutes, hours, days, months, weekdays uint64
+	// Whether the day of month or the day of week field is "*". A day
+	// matches when both fields do, or, when neither is "*", when either does.
+	anyDay, anyWeekday bool
+}
+
+// ParseSchedule parses a schedule: a cron expression of five fields (minute,
+// hour, day of month, month, day of week), each "*", a value, a range, or a
+// list of them, optionally with a "/step"; a name such as @hourly, @daily,
+// or @weekly; or "@every <duration>", such as "@every 30m".
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if rest, ok := strings.CutPrefix(spec, "@every "); ok {
+		every, err := time.ParseDuration(strings.TrimSpace(rest))
+		if err != nil || every < time.Second {
+			return Schedule{}, fmt.Errorf("invalid schedule %q: @every takes a duration of at least 1s", spec)
+		}
+		return Schedule{every: every}, nil
+	}
+	if expression, ok := scheduleAliases[spec]; ok {
+		spec = expression
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(scheduleFields) {
+		return Schedule{}, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day month weekday) or a name such as @daily", spec)
+	}
+	var sets [5]uint64
+	for i, field := range fields {
+		set, err := parseScheduleField(field, scheduleFields[i])
+		if err != nil {
+			return Schedule{}, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		sets[i] = set
+	}
+	weekdays := sets[4]
+	if weekdays&(1<<7) != 0 {
+		weekdays = weekdays&^(1<<7) | 1
+	}
+	return Schedule{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   weekdays,
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseScheduleField returns the values a field of a cron expression
+// matches, as a bit set.
+func parseScheduleField(field string, limits scheduleField) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in the %s field", stepText, limits.name)
+			}
+		}
+
+		low, high := limits.min, limits.max
+		if valueRange != "*" {
+			lowText, highText, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return 0, fmt.Errorf("invalid value %q in the %s field", part, limits.name)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highText); err != nil {
+					return 0, fmt.Errorf("invalid value %q in the %s field", part, limits.name)
+				}
+			} else if hasStep {
+				high = limits.max // "5/10" runs from 5 on.
+			}
+		}
+		if low < limits.min || high > limits.max || low > high {
+			return 0, fmt.Errorf("%q is out of range for the %s field (%d-%d)", part, limits.name, limits.min, limits.max)
+		}
+		for value := low; value <= high; value += step {
+			set |= 1 << value
+		}
+	}
+	return set, nil
+}
+
+// matchesDay reports whether the day of t matches the schedule.
+func (s Schedule) matchesDay(t time.Time) bool {
+	day := s.days&(1<<t.Day()) != 0
+	weekday := s.weekdays&(1<<int(t.Weekday())) != 0
+	if s.anyDay || s.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Next returns the first time after after that the schedule runs. A cron
+// expression that never matches, such as "0 0 30 2 *", never runs, which
+// Next reports as the zero time.
+func (s Schedule) Next(after time.Time) time.Time {
+	if s.every > 0 {
+		return after.Add(s.every)
+	}
+
+	// Step forward from the next whole minute, skipping a month, day, or
+	// hour at a time while it does not match.
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.months&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hours&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minutes&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
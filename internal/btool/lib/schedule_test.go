@@ -0,0 +1,75 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedule(t *testing.T) {
+	// at returns the given local time on 2024-03-15, a Friday, or on a later
+	// day of March.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.March, day, hour, minute, 0, 0, time.Local)
+	}
+
+	t.Run("should find the next time of cron expressions and names", func(t *testing.T) {
+		from := at(15, 10, 30)
+		cases := map[string]time.Time{
+			"@hourly":        at(15, 11, 0),
+			"@daily":         at(16, 0, 0),
+			"@weekly":        at(17, 0, 0), // Sunday.
+			"*/15 * * * *":   at(15, 10, 45),
+			"0 9-17 * * *":   at(15, 11, 0),
+			"30 2 * * 1-5":   at(18, 2, 30), // Monday.
+			"0 0 * * 7":      at(17, 0, 0),
+			"0 12 20,25 * *": at(20, 12, 0),
+			"0 0 1 * *":      time.Date(2024, time.April, 1, 0, 0, 0, 0, time.Local),
+		}
+		for spec, want := range cases {
+			schedule, err := ParseSchedule(spec)
+			require.NoError(t, err, spec)
+			assert.Equal(t, want, schedule.Next(from), spec)
+		}
+	})
+
+	t.Run("should match either day field when both are restricted", func(t *testing.T) {
+		// Arrange
+		schedule, err := ParseSchedule("0 0 20 * 0")
+
+		// Act
+		next := schedule.Next(at(15, 10, 30))
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, at(17, 0, 0), next, "Sunday the 17th comes before the 20th")
+	})
+
+	t.Run("should run at a fixed interval with @every", func(t *testing.T) {
+		// Arrange
+		schedule, err := ParseSchedule("@every 90s")
+		from := at(15, 10, 30).Add(7 * time.Second)
+
+		// Act
+		next := schedule.Next(from)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, from.Add(90*time.Second), next)
+	})
+
+	t.Run("should never run on a date that does not exist", func(t *testing.T) {
+		schedule, err := ParseSchedule("0 0 30 2 *")
+		require.NoError(t, err)
+		assert.True(t, schedule.Next(at(15, 10, 30)).IsZero())
+	})
+
+	t.Run("should refuse invalid schedules", func(t *testing.T) {
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *", "@every 1ms", "@often"} {
+			_, err := ParseSchedule(spec)
+			assert.Error(t, err, spec)
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxUploadSize bounds the size of a single PUT request to the repository
+// server, which protects the server from clients streaming unbounded bodies.
+const maxUploadSize = 4 << 30 // 4GB
+
+// ServerRole is what a client of a RepositoryServer may do.
+type ServerRole string
+
+const (
+	// RoleReadOnly clients may list and read files, for list and restore.
+	RoleReadOnly ServerRole = "read-only"
+	// RoleAppendOnly clients may also add files, for snap, but may not
+	// delete or replace them, so they cannot prune or destroy backups.
+	RoleAppendOnly ServerRole = "append-only"
+	// RoleAdmin clients may do anything, including prune.
+	RoleAdmin ServerRole = "admin"
+)
+
+// appendOnlyReplaceable are the files an append-only client may replace,
+// because every snap rewrites them. The index is not among them: a snap only
+// adds a shard for each new pack.
+var appendOnlyReplaceable = []string{CounterFileName}
+
+// ServerClient is a client of a RepositoryServer, identified by its token.
+type ServerClient struct {
+	Name  string     `json:"name"`
+	Token string     `json:"token"`
+	Role  ServerRole `json:"role"`
+	// Repositories are the repository names the client may access. Empty
+	// means every repository.
+	Repositories []string `json:"repositories,omitempty"`
+}
+
+// allows reports whether the client may access the file name, which starts
+// with the repository name.
+func (c *ServerClient) allows(name string) bool {
+	if len(c.Repositories) == 0 {
+		return true
+	}
+	for _, repository := range c.Repositories {
+		if name == repository || strings.HasPrefix(name, repository+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// LoadServerClients reads the clients of a RepositoryServer from a JSON file
+// holding an array of ServerClient.
+func LoadServerClients(fileName string) ([]ServerClient, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("could not read clients file: %w", err)
+	}
+	var clients []ServerClient
+	if err := json.Unmarshal(content, &clients); err != nil {
+		return nil, fmt.Errorf("could not parse clients file %s: %w", fileName, err)
+	}
+	tokens := make(map[string]bool)
+	for i, client := range clients {
+		switch {
+		case client.Token == "":
+			return nil, fmt.Errorf("client %d (%s) in %s has no token", i+1, client.Name, fileName)
+		case tokens[client.Token]:
+			return nil, fmt.Errorf("client %d (%s) in %s reuses another client's token", i+1, client.Name, fileName)
+		case client.Role != RoleReadOnly && client.Role != RoleAppendOnly && client.Role != RoleAdmin:
+			return nil, fmt.Errorf("client %d (%s) in %s has unknown role %q; use %s, %s, or %s",
+				i+1, client.Name, fileName, client.Role, RoleReadOnly, RoleAppendOnly, RoleAdmin)
+		}
+		tokens[client.Token] = true
+		for j, repository := range client.Repositories {
+			client.Repositories[j] = strings.Trim(path.Clean("/"+repository), "/")
+		}
+	}
+	return clients, nil
+}
+
+// RepositoryServer serves repositories stored under a local root directory
+// over the HTTP protocol understood by HTTPBackend. Every repository is a
+// subdirectory of the root, so one server can host backups for many clients.
+type RepositoryServer struct {
+	backend Backend
+	clients []ServerClient
+}
+
+// NewRepositoryServer creates an http.Handler serving the repositories under
+// root. Every request must present the token of one of clients as a bearer
+// token, and is limited to what that client may do. Without clients, the
+// server is open to anyone.
+func NewRepositoryServer(root string, clients ...ServerClient) *RepositoryServer {
+	return &RepositoryServer{backend: NewLocalBackend(root), clients: clients}
+}
+
+// ServeHTTP implements http.Handler.
+func (s *RepositoryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	client, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="btool"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Clean the path so clients cannot escape the root with "..".
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	isDir := strings.HasSuffix(r.URL.Path, "/")
+	if name == "" || name == "." {
+		http.Error(w, "a repository path is required", http.StatusBadRequest)
+		return
+	}
+	if err := s.authorize(client, r.Method, name); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && isDir:
+		s.handleList(w, name)
+	case r.Method == http.MethodGet:
+		s.handleGet(w, r, name)
+	case r.Method == http.MethodHead && !isDir:
+		s.handleHead(w, name)
+	case r.Method == http.MethodPut && !isDir:
+		s.handlePut(w, r, name)
+	case r.Method == http.MethodDelete && !isDir:
+		s.handleDelete(w, name)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authenticate finds the client whose token the request presents, comparing
+// tokens in constant time. Without configured clients, every request is
+// allowed and the returned client is nil.
+func (s *RepositoryServer) authenticate(r *http.Request) (*ServerClient, bool) {
+	if len(s.clients) == 0 {
+		return nil, true
+	}
+	presented := []byte(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	var found *ServerClient
+	for i := range s.clients {
+		if subtle.ConstantTimeCompare(presented, []byte(s.clients[i].Token)) == 1 && found == nil {
+			found = &s.clients[i]
+		}
+	}
+	return found, found != nil
+}
+
+// authorize checks that client may make a request with method for the file
+// or directory name.
+func (s *RepositoryServer) authorize(client *ServerClient, method, name string) error {
+	if client == nil {
+		return nil
+	}
+	if !client.allows(name) {
+		return fmt.Errorf("client %s may not access %s", client.Name, name)
+	}
+	if method == http.MethodGet || method == http.MethodHead || client.Role == RoleAdmin {
+		return nil
+	}
+	if client.Role != RoleAppendOnly || method != http.MethodPut {
+		return fmt.Errorf("client %s is %s and may not %s %s", client.Name, client.Role, method, name)
+	}
+
+	// An append-only client may add files, but only replace those every snap
+	// rewrites.
+	_, err := backendVersion(s.backend, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(appendOnlyReplaceable, func(file string) bool {
+		return strings.HasSuffix(name, "/"+file)
+	}) {
+		return nil
+	}
+	return fmt.Errorf("client %s is %s and may not replace %s", client.Name, client.Role, name)
+}
+
+// handleList writes the JSON listing of a directory.
+func (s *RepositoryServer) handleList(w http.ResponseWriter, dir string) {
+	entries, err := s.backend.List(dir)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+// handleGet serves a whole file, or a single byte range of it.
+func (s *RepositoryServer) handleGet(w http.ResponseWriter, r *http.Request, name string) {
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		data, err := s.backend.Get(name)
+		if err != nil {
+			writeServerError(w, err)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		_, _ = w.Write(data)
+		return
+	}
+
+	offset, length, err := parseByteRange(rangeHeader)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	data, err := s.backend.GetRange(name, offset, length)
+	if err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			http.Error(w, "range exceeds file size", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, offset+length-1))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(data)
+}
+
+// handleHead reports a file's version as its ETag, without sending the file.
+func (s *RepositoryServer) handleHead(w http.ResponseWriter, name string) {
+	version, err := backendVersion(s.backend, name)
+	if err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.Header().Set("ETag", strconv.Quote(version))
+	w.WriteHeader(http.StatusOK)
+}
+
+// handlePut stores the request body as a file.
+func (s *RepositoryServer) handlePut(w http.ResponseWriter, r *http.Request, name string) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxUploadSize))
+	if err != nil {
+		http.Error(w, "could not read request body", http.StatusBadRequest)
+		return
+	}
+	if err := s.backend.Put(name, data); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// handleDelete removes a file.
+func (s *RepositoryServer) handleDelete(w http.ResponseWriter, name string) {
+	if err := s.backend.Delete(name); err != nil {
+		writeServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeServerError maps backend errors onto HTTP status codes.
+func writeServerError(w http.ResponseWriter, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, "internal server error", http.StatusInternalServerError)
+}
+
+// parseByteRange parses a single "bytes=start-end" range header.
+func parseByteRange(header string) (offset, length int64, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	startText, endText, hasDash := strings.Cut(spec, "-")
+	if !found || !hasDash || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", header)
+	}
+	start, err := strconv.ParseInt(startText, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid range start in %q", header)
+	}
+	end, err := strconv.ParseInt(endText, 10, 64)
+	if err != nil || end < start {
+		return 0, 0, fmt.Errorf("invalid range end in %q", header)
+	}
+	return start, end - start + 1, nil
+}
@@ -0,0 +1,253 @@
+package lib
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRepositoryServer starts a RepositoryServer over a temporary root and
+// returns the root directory along with the server URL. A non-empty token is
+// given full access.
+func newTestRepositoryServer(t *testing.T, token string) (string, string) {
+	t.Helper()
+	var clients []ServerClient
+	if token != "" {
+		clients = append(clients, ServerClient{Name: "admin", Token: token, Role: RoleAdmin})
+	}
+	return newTestRepositoryServerWithClients(t, clients...)
+}
+
+// newTestRepositoryServerWithClients is like newTestRepositoryServer, for a
+// server that accepts clients.
+func newTestRepositoryServerWithClients(t *testing.T, clients ...ServerClient) (string, string) {
+	t.Helper()
+	root := t.TempDir()
+	server := httptest.NewServer(NewRepositoryServer(root, clients...))
+	t.Cleanup(server.Close)
+	return root, server.URL
+}
+
+func TestRepositoryServer(t *testing.T) {
+	t.Run("should round-trip files through HTTPBackend", func(t *testing.T) {
+		// Arrange
+		root, serverURL := newTestRepositoryServer(t, "secret")
+		t.Setenv(ServerTokenEnvVar, "secret")
+		backend, err := NewHTTPBackend(serverURL + "/laptop")
+		require.NoError(t, err)
+
+		// Act & Assert
+		require.NoError(t, backend.Put("packs/abc", []byte("0123456789")))
+		assert.FileExists(t, filepath.Join(root, "laptop", "packs", "abc"), "Files should be stored under the repository name")
+
+		data, err := backend.Get("packs/abc")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("0123456789"), data)
+
+		part, err := backend.GetRange("packs/abc", 5, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("56789"), part)
+
+		_, err = backend.GetRange("packs/abc", 8, 5)
+		assert.Error(t, err, "Reading past the end of a file should fail")
+
+		entries, err := backend.List("packs")
+		require.NoError(t, err)
+		assert.Equal(t, []BackendEntry{{Name: "abc", Size: 10}}, entries)
+
+		version, err := backend.Version("packs/abc")
+		require.NoError(t, err)
+		require.NoError(t, backend.Put("packs/abc", []byte("changed")))
+		changed, err := backend.Version("packs/abc")
+		require.NoError(t, err)
+		assert.NotEqual(t, version, changed, "The version should change with the file")
+		require.NoError(t, backend.Put("packs/abc", []byte("0123456789")))
+
+		entries, err = backend.List("snaps")
+		require.NoError(t, err, "Expected no error for a missing directory")
+		assert.Empty(t, entries)
+
+		require.NoError(t, backend.Delete("packs/abc"))
+		_, err = backend.Get("packs/abc")
+		assert.True(t, errors.Is(err, fs.ErrNotExist), "Get: expected fs.ErrNotExist, got %v", err)
+		err = backend.Delete("packs/abc")
+		assert.True(t, errors.Is(err, fs.ErrNotExist), "Delete: expected fs.ErrNotExist, got %v", err)
+	})
+
+	t.Run("should reject requests without the token", func(t *testing.T) {
+		// Arrange
+		_, serverURL := newTestRepositoryServer(t, "secret")
+		t.Setenv(ServerTokenEnvVar, "wrong")
+		backend, err := NewHTTPBackend(serverURL + "/laptop")
+		require.NoError(t, err)
+
+		// Act
+		err = backend.Put("index.json", []byte("{}"))
+
+		// Assert
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "401")
+	})
+
+	t.Run("should not serve files outside the root", func(t *testing.T) {
+		// Arrange
+		root, serverURL := newTestRepositoryServer(t, "")
+		secretPath := filepath.Join(filepath.Dir(root), "secret.txt")
+		require.NoError(t, os.WriteFile(secretPath, []byte("secret"), 0644))
+		t.Cleanup(func() { os.Remove(secretPath) })
+
+		// Act
+		resp, err := http.Get(serverURL + "/../secret.txt")
+
+		// Assert
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+}
+
+func TestRepositoryServerRoles(t *testing.T) {
+	clients := []ServerClient{
+		{Name: "admin", Token: "admin-token", Role: RoleAdmin},
+		{Name: "laptop", Token: "laptop-token", Role: RoleAppendOnly, Repositories: []string{"laptop"}},
+		{Name: "auditor", Token: "auditor-token", Role: RoleReadOnly, Repositories: []string{"laptop"}},
+	}
+	_, serverURL := newTestRepositoryServerWithClients(t, clients...)
+
+	// backendFor opens the repository name as the client with token.
+	backendFor := func(t *testing.T, token, name string) *HTTPBackend {
+		t.Setenv(ServerTokenEnvVar, token)
+		backend, err := NewHTTPBackend(serverURL + "/" + name)
+		require.NoError(t, err)
+		return backend
+	}
+
+	t.Run("should let an append-only client add files but not delete or replace them", func(t *testing.T) {
+		// Arrange
+		laptop := backendFor(t, "laptop-token", "laptop")
+
+		// Act & Assert
+		require.NoError(t, laptop.Put("packs/first", []byte("data")))
+		require.NoError(t, laptop.Put(CounterFileName, []byte("1")))
+		require.NoError(t, laptop.Put(CounterFileName, []byte("2")), "The counter is rewritten by every snap")
+		require.NoError(t, laptop.Put(shardName("first"), []byte("{}")))
+		assert.ErrorIs(t, laptop.Put(shardName("first"), []byte("{}")), fs.ErrPermission)
+		require.NoError(t, laptop.Put(IndexFileName, []byte("{}")))
+		assert.ErrorIs(t, laptop.Put(IndexFileName, []byte("{}")), fs.ErrPermission, "Snaps no longer rewrite the old index")
+		data, err := laptop.Get("packs/first")
+		require.NoError(t, err)
+		assert.Equal(t, []byte("data"), data)
+
+		err = laptop.Put("packs/first", []byte("evil"))
+		assert.ErrorIs(t, err, fs.ErrPermission)
+		err = laptop.Delete("packs/first")
+		assert.ErrorIs(t, err, fs.ErrPermission)
+		assert.Contains(t, err.Error(), "403")
+	})
+
+	t.Run("should keep clients out of other repositories", func(t *testing.T) {
+		// Arrange
+		require.NoError(t, backendFor(t, "admin-token", "desktop").Put("packs/secret", []byte("secret")))
+		laptop := backendFor(t, "laptop-token", "desktop")
+
+		// Act
+		_, readErr := laptop.Get("packs/secret")
+		writeErr := laptop.Put("packs/other", []byte("data"))
+		_, listErr := backendFor(t, "laptop-token", "laptop-other").List(SnapsDirName)
+
+		// Assert
+		assert.ErrorIs(t, readErr, fs.ErrPermission)
+		assert.ErrorIs(t, writeErr, fs.ErrPermission)
+		assert.ErrorIs(t, listErr, fs.ErrPermission, "A name sharing a prefix is a different repository")
+	})
+
+	t.Run("should let a read-only client only read", func(t *testing.T) {
+		// Arrange
+		auditor := backendFor(t, "auditor-token", "laptop")
+
+		// Act
+		_, readErr := auditor.Get("packs/first")
+		writeErr := auditor.Put("packs/second", []byte("data"))
+
+		// Assert
+		assert.NoError(t, readErr)
+		assert.ErrorIs(t, writeErr, fs.ErrPermission)
+	})
+
+	t.Run("should let an admin delete", func(t *testing.T) {
+		// Act
+		err := backendFor(t, "admin-token", "laptop").Delete("packs/first")
+
+		// Assert
+		assert.NoError(t, err)
+	})
+}
+
+func TestLoadServerClients(t *testing.T) {
+	// writeClients writes content to a clients file and loads it.
+	writeClients := func(t *testing.T, content string) ([]ServerClient, error) {
+		fileName := filepath.Join(t.TempDir(), "clients.json")
+		require.NoError(t, os.WriteFile(fileName, []byte(content), 0600))
+		return LoadServerClients(fileName)
+	}
+
+	t.Run("should load clients and clean their repository names", func(t *testing.T) {
+		// Act
+		clients, err := writeClients(t, `[{"name": "laptop", "token": "t1", "role": "append-only", "repositories": ["/laptop/"]}]`)
+
+		// Assert
+		require.NoError(t, err)
+		assert.Equal(t, []ServerClient{{Name: "laptop", Token: "t1", Role: RoleAppendOnly, Repositories: []string{"laptop"}}}, clients)
+	})
+
+	t.Run("should reject invalid clients", func(t *testing.T) {
+		for content, message := range map[string]string{
+			`[{"name": "a", "role": "admin"}]`: "no token",
+			`[{"name": "a", "token": "t", "role": "admin"}, {"name": "b", "token": "t", "role": "admin"}]`: "reuses",
+			`[{"name": "a", "token": "t", "role": "owner"}]`:                                               "unknown role",
+		} {
+			// Act
+			_, err := writeClients(t, content)
+
+			// Assert
+			assert.ErrorContains(t, err, message)
+		}
+	})
+}
+
+func TestParseByteRange(t *testing.T) {
+	testCases := []struct {
+		name           string
+		header         string
+		expectedOffset int64
+		expectedLength int64
+		expectError    bool
+	}{
+		{name: "single range", header: "bytes=10-19", expectedOffset: 10, expectedLength: 10},
+		{name: "single byte", header: "bytes=0-0", expectedOffset: 0, expectedLength: 1},
+		{name: "open-ended range", header: "bytes=10-", expectError: true},
+		{name: "multiple ranges", header: "bytes=0-1,4-5", expectError: true},
+		{name: "reversed range", header: "bytes=9-3", expectError: true},
+		{name: "wrong unit", header: "items=0-1", expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			offset, length, err := parseByteRange(tc.header)
+			if tc.expectError {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedOffset, offset)
+			assert.Equal(t, tc.expectedLength, length)
+		})
+	}
+}
@@ -3,8 +3,7 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
-	"os"
-	"path/filepath"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,36 +21,32 @@ type SnapDetail struct {
 	RootTreeHash string
 	SourceSize   int64
 	SnapSize     int64
+	Parent       string // The hash of the previous snap, if any.
+	Damaged      bool   // Marked by repair as missing objects it needs.
+	Hostname     string
+	Username     string
+	Paths        []string // The absolute paths the snap was taken of.
+	Version      string   // Of btool, when it took the snap.
+	Command      []string // The command line btool was run with.
 }
 
-// GetSortedSnaps reads all snaps for a given repository, sorts them by date
-// (oldest first), and returns them with a sequential ID.
-func GetSortedSnaps(baseDir string) ([]SnapDetail, error) {
-	snapsDir := GetSnapsDir(baseDir)
-
-	dirEntries, err := os.ReadDir(snapsDir)
+// GetSortedSnaps reads all snaps in the repository, sorts them by ID
+// (oldest first), and returns them.
+func (s *ObjectStore) GetSortedSnaps() ([]SnapDetail, error) {
+	// A missing snaps directory simply lists as empty, so that is not an error.
+	backendEntries, err := s.backend.List(SnapsDirName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return []SnapDetail{}, nil // No snaps dir exists, so no snaps. Not an error.
-		}
 		return nil, err // A different error occurred (e.g., permissions).
 	}
 
-	var snapDetails []SnapDetail
-	for _, entry := range dirEntries {
-		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
-			snapHash := entry.Name()[:len(entry.Name())-len(".json")]
+	snapDetails := []SnapDetail{}
+	for _, entry := range backendEntries {
+		if path.Ext(entry.Name) == ".json" {
+			snapHash := entry.Name[:len(entry.Name)-len(".json")]
 
-			content, err := os.ReadFile(filepath.Join(snapsDir, entry.Name()))
+			snapData, err := s.ReadSnap(snapHash)
 			if err != nil {
-				// Log a warning but continue, in case one snap file is corrupted.
-				// fmt.Fprintf(os.Stderr, "Warning: could not read snap file %s: %v\n", entry.Name(), err)
-				continue
-			}
-
-			var snapData types.Snap
-			if err := json.Unmarshal(content, &snapData); err != nil {
-				// fmt.Fprintf(os.Stderr, "Warning: could not parse snap file %s: %v\n", entry.Name(), err)
+				// Skip it but continue, in case one snap file is corrupted.
 				continue
 			}
 
@@ -69,6 +64,13 @@ func GetSortedSnaps(baseDir string) ([]SnapDetail, error) {
 				RootTreeHash: snapData.RootTreeHash,
 				SourceSize:   snapData.SourceSize,
 				SnapSize:     snapData.SnapSize,
+				Parent:       snapData.Parent,
+				Damaged:      snapData.Damaged,
+				Hostname:     snapData.Hostname,
+				Username:     snapData.Username,
+				Paths:        snapData.Paths,
+				Version:      snapData.Version,
+				Command:      snapData.Command,
 			})
 		}
 	}
@@ -82,42 +84,151 @@ func GetSortedSnaps(baseDir string) ([]SnapDetail, error) {
 	return snapDetails, nil
 }
 
-// FindSnap searches for a snapshot by a given identifier, which can be a numeric ID or a hash prefix.
-func FindSnap(baseDir, snapIdentifier string) (*SnapDetail, error) {
-	snaps, err := GetSortedSnaps(baseDir)
+// ReadSnap reads the snap manifest with the given hash.
+func (s *ObjectStore) ReadSnap(snapHash string) (types.Snap, error) {
+	var snap types.Snap
+	content, err := s.backend.Get(snapName(snapHash))
+	if err != nil {
+		return snap, err
+	}
+	if content, err = s.decrypt(content); err != nil {
+		return snap, err
+	}
+	if err := json.Unmarshal(content, &snap); err != nil {
+		return snap, fmt.Errorf("could not parse snap %s: %w", snapHash, err)
+	}
+	return snap, nil
+}
+
+// WriteSnap persists a snap manifest and returns its hash, which also serves
+// as the snap's file name in the repository.
+func (s *ObjectStore) WriteSnap(snap types.Snap) (string, error) {
+	snapJSON, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	snapHash := s.hasher.GetHash(snapJSON)
+	if snapJSON, err = s.encrypt(snapJSON); err != nil {
+		return "", err
+	}
+	if err := s.backend.Put(snapName(snapHash), snapJSON); err != nil {
+		return "", err
+	}
+	return snapHash, nil
+}
+
+// DeleteSnap removes the snap manifest with the given hash, keeping it in the
+// trash once StartTrash is called.
+func (s *ObjectStore) DeleteSnap(snapHash string) error {
+	return s.deleteFile(snapName(snapHash))
+}
+
+// HasSnap reports whether the snap manifest with the given hash exists. It
+// only lists the snaps, so it works on stores that cannot read them.
+func (s *ObjectStore) HasSnap(snapHash string) (bool, error) {
+	entries, err := s.backend.List(SnapsDirName)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if entry.Name == snapHash+".json" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// LatestSnapIdentifier identifies the most recent snap. "latest~N" identifies
+// the Nth snap before it, and "latest-<age>", such as "latest-2h", the most
+// recent snap taken at least that long ago.
+const LatestSnapIdentifier = "latest"
+
+// ParseAge parses a duration as time.ParseDuration does, also taking a
+// number of days or weeks, such as "30d" or "2w".
+func ParseAge(s string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if number, ok := strings.CutSuffix(s, suffix); ok {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n * float64(unit)), nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// findRelativeSnap returns the snap that an identifier starting with
+// LatestSnapIdentifier names among snaps, sorted oldest first.
+func findRelativeSnap(snaps []SnapDetail, snapIdentifier string) (*SnapDetail, error) {
+	rest := strings.TrimPrefix(snapIdentifier, LatestSnapIdentifier)
+	switch {
+	case rest == "":
+		return &snaps[len(snaps)-1], nil
+	case strings.HasPrefix(rest, "~"):
+		back, err := strconv.Atoi(rest[1:])
+		if err != nil || back < 0 {
+			return nil, fmt.Errorf("invalid snap identifier '%s': expected latest~N with N a number of snaps", snapIdentifier)
+		}
+		if back >= len(snaps) {
+			return nil, fmt.Errorf("no snap found %d before the latest, as there are only %d", back, len(snaps))
+		}
+		return &snaps[len(snaps)-1-back], nil
+	case strings.HasPrefix(rest, "-"):
+		age, err := ParseAge(rest[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid snap identifier '%s': expected latest-<age>, such as latest-2h or latest-7d", snapIdentifier)
+		}
+		cutoff := time.Now().Add(-age)
+		for i := len(snaps) - 1; i >= 0; i-- {
+			if !snaps[i].Timestamp.After(cutoff) {
+				return &snaps[i], nil
+			}
+		}
+		return nil, fmt.Errorf("no snap found taken %s or more ago", rest[1:])
+	}
+	return nil, fmt.Errorf("invalid snap identifier '%s': expected latest, latest~N, or latest-<age>", snapIdentifier)
+}
+
+// FindSnap searches for a snapshot by a given identifier, which can be a
+// numeric ID, a hash prefix, or one relative to the latest snap, as
+// LatestSnapIdentifier describes.
+func (s *ObjectStore) FindSnap(snapIdentifier string) (*SnapDetail, error) {
+	snaps, err := s.GetSortedSnaps()
 	if err != nil {
 		return nil, fmt.Errorf("failed to read snapshots: %w", err)
 	}
 	if len(snaps) == 0 {
 		return nil, fmt.Errorf("no snaps found to search from")
 	}
+	if strings.HasPrefix(snapIdentifier, LatestSnapIdentifier) {
+		return findRelativeSnap(snaps, snapIdentifier)
+	}
 
-	var snapToReturn *SnapDetail
-	snapID, err := strconv.ParseInt(snapIdentifier, 10, 64)
-	if err == nil { // Identifier is a numeric ID.
+	if snapID, err := strconv.ParseInt(snapIdentifier, 10, 64); err == nil { // Identifier is a numeric ID.
 		for i := range snaps {
 			if snaps[i].ID == snapID {
-				snapToReturn = &snaps[i]
-				break
+				return &snaps[i], nil
 			}
 		}
-	} else { // Identifier is a hash prefix.
-		var matches []*SnapDetail
-		for i := range snaps {
-			if strings.HasPrefix(snaps[i].Hash, snapIdentifier) {
-				matches = append(matches, &snaps[i])
-			}
-		}
-		if len(matches) == 1 {
-			snapToReturn = matches[0]
-		} else if len(matches) > 1 {
-			return nil, fmt.Errorf("ambiguous snap identifier '%s' matches multiple snapshots", snapIdentifier)
+	}
+	// Identifier is a hash prefix, which may also be all digits.
+	var matches []*SnapDetail
+	for i := range snaps {
+		if strings.HasPrefix(snaps[i].Hash, snapIdentifier) {
+			matches = append(matches, &snaps[i])
 		}
 	}
-
-	if snapToReturn == nil {
+	switch len(matches) {
+	case 0:
 		return nil, fmt.Errorf("no snap found with ID or hash prefix '%s'", snapIdentifier)
+	case 1:
+		return matches[0], nil
+	default:
+		return nil, fmt.Errorf("ambiguous snap identifier '%s' matches multiple snapshots", snapIdentifier)
 	}
-
-	return snapToReturn, nil
 }
@@ -51,7 +51,7 @@ func TestGetSortedSnaps(t *testing.T) {
 		createSnapFile(1, "hash_1", "2023-01-01T12:00:00Z", "first snap")
 
 		// Act
-		snaps, err := GetSortedSnaps(testDir)
+		snaps, err := NewLocalObjectStore(testDir).GetSortedSnaps()
 		require.NoError(t, err, "GetSortedSnaps failed unexpectedly")
 
 		// Assert
@@ -70,7 +70,7 @@ func TestGetSortedSnaps(t *testing.T) {
 		testDir := t.TempDir() // A clean directory with no .btool/snaps subdir
 
 		// Act
-		snaps, err := GetSortedSnaps(testDir)
+		snaps, err := NewLocalObjectStore(testDir).GetSortedSnaps()
 
 		// Assert
 		require.NoError(t, err, "Expected no error for missing snaps dir")
@@ -97,7 +97,7 @@ func TestGetSortedSnaps(t *testing.T) {
 		require.NoError(t, err, "Failed to write text file")
 
 		// Act
-		snaps, err := GetSortedSnaps(testDir)
+		snaps, err := NewLocalObjectStore(testDir).GetSortedSnaps()
 
 		// Assert
 		require.NoError(t, err, "GetSortedSnaps failed unexpectedly")
@@ -117,7 +117,7 @@ func TestGetSortedSnaps(t *testing.T) {
 		require.NoError(t, err)
 
 		// Act
-		snaps, err := GetSortedSnaps(testDir)
+		snaps, err := NewLocalObjectStore(testDir).GetSortedSnaps()
 		require.NoError(t, err, "GetSortedSnaps failed")
 		require.Len(t, snaps, 1, "Expected 1 snap")
 
@@ -131,3 +131,105 @@ func TestGetSortedSnaps(t *testing.T) {
 		assert.Equal(t, int64(1024), result.SourceSize, "SourceSize mismatch")
 	})
 }
+
+func TestFindSnapRelativeToLatest(t *testing.T) {
+	testDir, createSnapFile := setupSnapsTest(t)
+	now := time.Now().UTC()
+	createSnapFile(1, "hash_1", now.Add(-72*time.Hour).Format(time.RFC3339), "three days ago")
+	createSnapFile(2, "hash_2", now.Add(-3*time.Hour).Format(time.RFC3339), "three hours ago")
+	createSnapFile(3, "hash_3", now.Add(-time.Minute).Format(time.RFC3339), "a minute ago")
+	store := NewLocalObjectStore(testDir)
+
+	testCases := []struct {
+		name       string
+		identifier string
+		wantID     int64
+		wantErr    string
+	}{
+		{name: "Latest", identifier: "latest", wantID: 3},
+		{name: "Counting back", identifier: "latest~2", wantID: 1},
+		{name: "Counting back zero", identifier: "latest~0", wantID: 3},
+		{name: "Counting back too far", identifier: "latest~3", wantErr: "only 3"},
+		{name: "By age in hours", identifier: "latest-2h", wantID: 2},
+		{name: "By age in days", identifier: "latest-1d", wantID: 1},
+		{name: "Older than every snap", identifier: "latest-1w", wantErr: "no snap found taken 1w or more ago"},
+		{name: "Invalid age", identifier: "latest-soon", wantErr: "invalid snap identifier"},
+		{name: "Invalid suffix", identifier: "latest+1", wantErr: "invalid snap identifier"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snap, err := store.FindSnap(tc.identifier)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantID, snap.ID)
+		})
+	}
+}
+
+func TestFindSnapByIDOrHashPrefix(t *testing.T) {
+	testDir, createSnapFile := setupSnapsTest(t)
+	timestamp := time.Now().UTC().Format(time.RFC3339)
+	createSnapFile(1, "12345abc", timestamp, "digits first")
+	createSnapFile(2, "abc12345", timestamp, "letters first")
+	createSnapFile(3, "abd00000", timestamp, "shares a prefix")
+	store := NewLocalObjectStore(testDir)
+
+	testCases := []struct {
+		name       string
+		identifier string
+		wantID     int64
+		wantErr    string
+	}{
+		{name: "Numeric ID", identifier: "2", wantID: 2},
+		{name: "All-digit hash prefix", identifier: "12345", wantID: 1},
+		{name: "Hash prefix", identifier: "abc", wantID: 2},
+		{name: "Ambiguous hash prefix", identifier: "ab", wantErr: "ambiguous"},
+		{name: "No match", identifier: "99", wantErr: "no snap found with ID or hash prefix '99'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			snap, err := store.FindSnap(tc.identifier)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantID, snap.ID)
+		})
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	testCases := []struct {
+		input   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{input: "90m", want: 90 * time.Minute},
+		{input: "30d", want: 30 * 24 * time.Hour},
+		{input: "2w", want: 14 * 24 * time.Hour},
+		{input: "1.5d", want: 36 * time.Hour},
+		{input: "-1d", wantErr: true},
+		{input: "d", wantErr: true},
+		{input: "soon", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			got, err := ParseAge(tc.input)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package lib
+
+import "io/fs"
+
+// The tree entry types of special files, which hold no contents, only the
+// device number of a device node.
+const (
+	EntryTypeFIFO        = "fifo"
+	EntryTypeSocket      = "socket"
+	EntryTypeCharDevice  = "chardev"
+	EntryTypeBlockDevice = "blockdev"
+)
+
+// SpecialFileType returns the tree entry type of a FIFO, socket, or device
+// node with the given mode, or an empty string for any other file.
+func SpecialFileType(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeNamedPipe != 0:
+		return EntryTypeFIFO
+	case mode&fs.ModeSocket != 0:
+		return EntryTypeSocket
+	case mode&fs.ModeCharDevice != 0:
+		return EntryTypeCharDevice
+	case mode&fs.ModeDevice != 0:
+		return EntryTypeBlockDevice
+	}
+	return ""
+}
+
+// IsDeviceType reports whether a tree entry type is that of a device node,
+// which only root can create.
+func IsDeviceType(entryType string) bool {
+	return entryType == EntryTypeCharDevice || entryType == EntryTypeBlockDevice
+}
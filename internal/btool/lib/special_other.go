@@ -0,0 +1,18 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package lib
+
+import (
+	"errors"
+	"os"
+)
+
+// MakeSpecialFile fails, as special files cannot be created here.
+func MakeSpecialFile(string, string, os.FileMode, uint64) error {
+	return errors.New("special files are not supported on this system")
+}
+
+// DeviceNumbers returns zeros, as snaps taken here hold no device nodes.
+func DeviceNumbers(uint64) (major, minor uint32) {
+	return 0, 0
+}
@@ -0,0 +1,34 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package lib
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// MakeSpecialFile creates a FIFO, socket, or device node of the given tree
+// entry type at path. The permissions are left to the caller, as the umask
+// applies to them here.
+func MakeSpecialFile(path, entryType string, mode os.FileMode, rdev uint64) error {
+	perm := uint32(mode.Perm())
+	switch entryType {
+	case EntryTypeFIFO:
+		return unix.Mkfifo(path, perm)
+	case EntryTypeSocket:
+		return mknod(path, perm|unix.S_IFSOCK, 0)
+	case EntryTypeCharDevice:
+		return mknod(path, perm|unix.S_IFCHR, rdev)
+	case EntryTypeBlockDevice:
+		return mknod(path, perm|unix.S_IFBLK, rdev)
+	}
+	return fmt.Errorf("unknown special file type %q", entryType)
+}
+
+// DeviceNumbers splits the device number of a device node into its major
+// and minor numbers.
+func DeviceNumbers(rdev uint64) (major, minor uint32) {
+	return unix.Major(rdev), unix.Minor(rdev)
+}
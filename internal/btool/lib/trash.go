@@ -0,0 +1,165 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gingerrexayers/btool-go/internal/btool/types"
+)
+
+// TrashDirName is the name of the directory holding the files deleted by
+// prunes run with a grace period, so that such a prune can be undone until
+// the period is over. Each prune's files are kept in a directory of their
+// own, beside a file describing them.
+const TrashDirName = "trash"
+
+// trashTimeLayout names the trash of a prune after when it was run, so that
+// the names sort in that order.
+const trashTimeLayout = "20060102T150405.000000000Z"
+
+// Trash describes the files deleted by one prune and kept for a while.
+type Trash struct {
+	// ID is the name of the directory in TrashDirName holding the files.
+	ID      string    `json:"-"`
+	Created time.Time `json:"created"`
+	// Expires is when the files may be purged.
+	Expires time.Time `json:"expires"`
+}
+
+// trashDirs are the directories whose deleted files are moved to the trash,
+// in the order they are restored: the packs before the index shards that
+// list their objects, and both before the snaps that need them.
+var trashDirs = []string{PacksDirName, IndexDirName, SnapsDirName}
+
+// infoName returns the backend name of the file describing the trash.
+func (t Trash) infoName() string {
+	return TrashDirName + "/" + t.ID + ".json"
+}
+
+// name returns the backend name under which the trash keeps the file name.
+func (t Trash) name(name string) string {
+	return TrashDirName + "/" + t.ID + "/" + name
+}
+
+// StartTrash makes DeletePack and DeleteSnap move the files they delete to a
+// new trash, kept until expires, rather than deleting them outright.
+func (s *ObjectStore) StartTrash(expires time.Time) (*Trash, error) {
+	now := time.Now()
+	trash := &Trash{ID: now.UTC().Format(trashTimeLayout), Created: now, Expires: expires}
+	data, err := json.MarshalIndent(trash, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// The description goes first, so that the trash of an interrupted prune
+	// can still be found.
+	if err := s.backend.Put(trash.infoName(), data); err != nil {
+		return nil, fmt.Errorf("failed to create trash: %w", err)
+	}
+	s.trash = trash
+	return trash, nil
+}
+
+// deleteFile deletes the named file, or moves it to the trash if one was
+// started, with a rename on backends that can rename files.
+func (s *ObjectStore) deleteFile(name string) error {
+	if s.trash == nil {
+		return s.backend.Delete(name)
+	}
+	return renameFile(s.backend, name, s.trash.name(name))
+}
+
+// ListTrash returns the trash kept by earlier prunes, oldest first.
+func (s *ObjectStore) ListTrash() ([]Trash, error) {
+	entries, err := s.backend.List(TrashDirName)
+	if err != nil {
+		return nil, err
+	}
+	var trashes []Trash
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name, ".json")
+		if !ok {
+			continue
+		}
+		trash := Trash{ID: id}
+		data, err := s.backend.Get(trash.infoName())
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &trash); err != nil {
+			return nil, fmt.Errorf("corrupt trash %s: %w", id, err)
+		}
+		trashes = append(trashes, trash)
+	}
+	sort.Slice(trashes, func(i, j int) bool { return trashes[i].ID < trashes[j].ID })
+	return trashes, nil
+}
+
+// TrashContents lists the files kept in the trash, by the directory they
+// were deleted from.
+func (s *ObjectStore) TrashContents(trash Trash) (map[string][]BackendEntry, error) {
+	contents := make(map[string][]BackendEntry)
+	for _, dir := range trashDirs {
+		entries, err := s.backend.List(trash.name(dir))
+		if err != nil {
+			return nil, err
+		}
+		contents[dir] = entries
+	}
+	return contents, nil
+}
+
+// RestoreTrash puts the files kept in the trash back where they were deleted
+// from, then removes the trash. Objects that a restored pack holds beside a
+// pack written since are listed by both, which does no harm, and the next
+// prune drops the copy that the index does not use.
+func (s *ObjectStore) RestoreTrash(trash Trash) error {
+	contents, err := s.TrashContents(trash)
+	if err != nil {
+		return err
+	}
+	for _, dir := range trashDirs {
+		for _, entry := range contents[dir] {
+			name := dir + "/" + entry.Name
+			if err := renameFile(s.backend, trash.name(name), name); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", name, err)
+			}
+		}
+	}
+
+	// The index is read again, with the restored shards.
+	s.mutex.Lock()
+	s.packIndex = make(types.PackIndex)
+	s.shardPacks = make(map[string]bool)
+	s.deletedPacks = make(map[string]bool)
+	s.legacyIndex = nil
+	s.indexLoaded = false
+	s.mutex.Unlock()
+
+	return s.PurgeTrash(trash)
+}
+
+// PurgeTrash deletes the files kept in the trash for good. The description
+// of the trash goes last, so that an interrupted purge is finished by the
+// next one.
+func (s *ObjectStore) PurgeTrash(trash Trash) error {
+	contents, err := s.TrashContents(trash)
+	if err != nil {
+		return err
+	}
+	for _, dir := range trashDirs {
+		for _, entry := range contents[dir] {
+			if err := s.backend.Delete(trash.name(dir + "/" + entry.Name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	if err := s.backend.Delete(trash.infoName()); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
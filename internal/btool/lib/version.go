@@ -0,0 +1,20 @@
+package lib
+
+import "runtime/debug"
+
+// version is the version of btool, which releases set when building with
+// -ldflags "-X github.com/gingerrexayers/btool-go/internal/btool/lib.version=v1.2.3".
+var version string
+
+// Version returns the version of btool: the one set when it was built, or
+// else the version of the module it was installed from, such as by go
+// install, or "(devel)" for a build from a checkout.
+func Version() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
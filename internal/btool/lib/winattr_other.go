@@ -0,0 +1,25 @@
+//go:build !windows
+
+package lib
+
+import "io/fs"
+
+// StreamsSupported tells whether files here can have alternate data streams.
+const StreamsSupported = false
+
+// FileAttributes returns zero, as files have no Windows attributes here.
+func FileAttributes(fs.FileInfo) uint32 {
+	return 0
+}
+
+// SetFileAttributes does nothing, as Windows attributes have no equivalent
+// here beyond the mode bits, which are restored anyway.
+func SetFileAttributes(string, uint32) error {
+	return nil
+}
+
+// FileStreams returns no streams, as files only have alternate data streams
+// on Windows.
+func FileStreams(string) ([]string, error) {
+	return nil, nil
+}
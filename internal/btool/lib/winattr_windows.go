@@ -0,0 +1,97 @@
+package lib
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// StreamsSupported tells whether files here can have alternate data streams.
+const StreamsSupported = true
+
+// keptAttributes are the Windows file attributes that snaps record.
+const keptAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN | windows.FILE_ATTRIBUTE_SYSTEM
+
+// FileAttributes returns the read-only, hidden, and system attributes of a
+// file or directory.
+func FileAttributes(info fs.FileInfo) uint32 {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return data.FileAttributes & keptAttributes
+	}
+	return 0
+}
+
+// SetFileAttributes gives a file or directory the read-only, hidden, and
+// system attributes of attributes, keeping its others.
+func SetFileAttributes(path string, attributes uint32) error {
+	name, err := windows.UTF16PtrFromString(extendedLengthPath(filepath.Clean(path)))
+	if err != nil {
+		return err
+	}
+	current, err := windows.GetFileAttributes(name)
+	if err != nil {
+		return err
+	}
+	return windows.SetFileAttributes(name, current&^keptAttributes|attributes&keptAttributes)
+}
+
+// fileStreamInfo is the start of a FILE_STREAM_INFO structure, which the
+// name of the stream follows.
+type fileStreamInfo struct {
+	NextEntryOffset      uint32
+	StreamNameLength     uint32
+	StreamSize           int64
+	StreamAllocationSize int64
+}
+
+// FileStreams returns the names of the alternate data streams of a file,
+// leaving out its main stream.
+func FileStreams(path string) ([]string, error) {
+	name, err := windows.UTF16PtrFromString(extendedLengthPath(filepath.Clean(path)))
+	if err != nil {
+		return nil, err
+	}
+	handle, err := windows.CreateFile(name, windows.GENERIC_READ,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE, nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(handle)
+
+	buf := make([]byte, 4096)
+	for {
+		err = windows.GetFileInformationByHandleEx(handle, windows.FileStreamInfo, &buf[0], uint32(len(buf)))
+		if errors.Is(err, windows.ERROR_MORE_DATA) {
+			buf = make([]byte, 2*len(buf))
+			continue
+		}
+		if errors.Is(err, windows.ERROR_HANDLE_EOF) {
+			return nil, nil // No streams at all.
+		}
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	// Each entry is named ":name:$DATA", and the main stream "::$DATA".
+	var streams []string
+	headerSize := int(unsafe.Sizeof(fileStreamInfo{}))
+	for offset := 0; ; {
+		info := (*fileStreamInfo)(unsafe.Pointer(&buf[offset]))
+		nameChars := unsafe.Slice((*uint16)(unsafe.Pointer(&buf[offset+headerSize])), info.StreamNameLength/2)
+		streamName := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(nameChars), ":"), ":$DATA")
+		if streamName != "" {
+			streams = append(streams, streamName)
+		}
+		if info.NextEntryOffset == 0 {
+			return streams, nil
+		}
+		offset += int(info.NextEntryOffset)
+	}
+}
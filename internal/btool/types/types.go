@@ -22,8 +22,58 @@ type FileManifest struct {
 type TreeEntry struct {
 	Name string `json:"name"`
 	Hash string `json:"hash"`
-	Type string `json:"type"` // "blob" or "tree"
+	Type string `json:"type"` // "blob", "tree", or a special file: "fifo", "socket", "chardev", or "blockdev"
 	Mode uint32 `json:"mode"`
+	// Rdev is the device number of a device node.
+	Rdev uint64 `json:"rdev,omitempty"`
+	// ModTime is the modification time, in nanoseconds since the epoch.
+	ModTime int64 `json:"mtime,omitempty"`
+	// BirthTime is the creation time on macOS, in nanoseconds since the
+	// epoch.
+	BirthTime int64 `json:"btime,omitempty"`
+	// Link is shared by the blobs of files hardlinked together, which
+	// restore links together again.
+	Link string `json:"link,omitempty"`
+	// Owner is who owned the file or directory, where the system has owners.
+	Owner *Owner `json:"owner,omitempty"`
+	// ACL holds the POSIX ACLs of the file or directory, if it had any
+	// beyond its mode bits.
+	ACL *ACL `json:"acl,omitempty"`
+	// Attributes holds the read-only, hidden, and system attributes of the
+	// file or directory, as Windows numbers them.
+	Attributes uint32 `json:"attributes,omitempty"`
+	// Streams are the alternate data streams of a file on Windows, if the
+	// snap recorded them.
+	Streams []Stream `json:"streams,omitempty"`
+	// Xattrs holds the extended attributes that macOS keeps the Finder flags
+	// and the quarantine of downloads in, by name.
+	Xattrs map[string][]byte `json:"xattrs,omitempty"`
+}
+
+// Stream is an alternate data stream of a file, with the manifest of its
+// contents.
+type Stream struct {
+	Name string `json:"name"`
+	Hash string `json:"hash"`
+}
+
+// Owner is the user and group owning a file. The names let a restore on
+// another machine, where the same users may have other IDs, find them.
+type Owner struct {
+	UID   uint32 `json:"uid"`
+	GID   uint32 `json:"gid"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+}
+
+// ACL is the POSIX ACLs of a file or directory, each in the short text
+// form of getfacl -n, such as "user::rw-,user:1000:r--,group::r--,mask::r--,other::---".
+// Named users and groups are kept by ID.
+type ACL struct {
+	// Access decides who may access the file or directory.
+	Access string `json:"access,omitempty"`
+	// Default is the ACL that entries created in a directory inherit.
+	Default string `json:"default,omitempty"`
 }
 
 type Tree struct {
@@ -37,12 +87,32 @@ type Snap struct {
 	Message      string `json:"message,omitempty"`
 	SourceSize   int64  `json:"sourceSize"`
 	SnapSize     int64  `json:"snapSize,omitempty"`
+	// Parent is the hash of the latest snap in the repository when this one
+	// was made, if any. It may since have been pruned.
+	Parent string `json:"parent,omitempty"`
+	// Damaged is set by repair when objects the snap needs were lost, so it
+	// can no longer be restored in full.
+	Damaged bool `json:"damaged,omitempty"`
+	// Hostname and Username tell which machine and user took the snap, and
+	// Paths the absolute paths it was taken of, so that the snaps of a
+	// repository shared between machines can be told apart.
+	Hostname string   `json:"hostname,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Paths    []string `json:"paths,omitempty"`
+	// Version is the version of btool that took the snap, and Command the
+	// command line it was run with.
+	Version string   `json:"version,omitempty"`
+	Command []string `json:"command,omitempty"`
 }
 
 type PackIndexEntry struct {
 	PackHash string `json:"packHash"`
 	Offset   int64  `json:"offset"`
-	Length   int64  `json:"length"`
+	Length   int64  `json:"length"` // Bytes stored in the pack.
+	// Compression names the algorithm the object was compressed with before
+	// any encryption, or is empty if it is stored as it is.
+	Compression        string `json:"compression,omitempty"`
+	UncompressedLength int64  `json:"uncompressedLength,omitempty"`
 }
 
 type PackIndex map[string]PackIndexEntry